@@ -0,0 +1,292 @@
+package btc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/guggero/chantools/dataformat"
+)
+
+const (
+	// AuditRecoverableNow is the category of outputs that can be swept
+	// right away.
+	AuditRecoverableNow = "recoverable_now"
+
+	// AuditRecoverableLater is the category of outputs that can be swept
+	// once they matured.
+	AuditRecoverableLater = "recoverable_later"
+
+	// AuditNeedsCounterparty is the category of funds that can only be
+	// recovered with the help of the remote node.
+	AuditNeedsCounterparty = "needs_counterparty"
+
+	// AuditLost is the category of funds that can't be recovered.
+	AuditLost = "lost"
+
+	// AuditKeyOK means the keys needed to sweep our outputs of a channel
+	// were derived from the seed successfully.
+	AuditKeyOK = "ok"
+
+	// AuditKeyFailed means the keys needed to sweep our outputs of a
+	// channel could not be derived from the seed.
+	AuditKeyFailed = "failed"
+
+	// AuditKeyNotChecked means there was nothing to check or the keys of
+	// the channel can't be checked without the channel DB.
+	AuditKeyNotChecked = "not_checked"
+
+	// averageBlockInterval is the expected time between two blocks, used
+	// to estimate the date at which an output matures.
+	averageBlockInterval = 10 * time.Minute
+)
+
+// AuditKeyChecker checks that the keys needed to sweep our outputs of the
+// given closed channel can be derived from the seed. It returns false if there
+// is nothing that can be checked.
+type AuditKeyChecker func(entry *dataformat.SummaryEntry) (bool, error)
+
+// AuditOutput is an amount of a channel in one of the audit categories.
+type AuditOutput struct {
+	Outpoint string `json:"outpoint"`
+	Value    uint64 `json:"value"`
+	Category string `json:"category"`
+
+	// MatureHeight and MatureDate are the block height and the estimated
+	// date at which an output that is recoverable later can be swept.
+	MatureHeight uint32 `json:"mature_height,omitempty"`
+	MatureDate   string `json:"mature_date,omitempty"`
+
+	Note string `json:"note,omitempty"`
+}
+
+// AuditTotals are the amounts in satoshis of each audit category.
+type AuditTotals struct {
+	RecoverableNow    uint64 `json:"recoverable_now"`
+	RecoverableLater  uint64 `json:"recoverable_later"`
+	NeedsCounterparty uint64 `json:"needs_counterparty"`
+	Lost              uint64 `json:"lost"`
+}
+
+// add adds the value of the output to the total of its category.
+func (t *AuditTotals) add(output *AuditOutput) {
+	switch output.Category {
+	case AuditRecoverableNow:
+		t.RecoverableNow += output.Value
+
+	case AuditRecoverableLater:
+		t.RecoverableLater += output.Value
+
+	case AuditNeedsCounterparty:
+		t.NeedsCounterparty += output.Value
+
+	case AuditLost:
+		t.Lost += output.Value
+	}
+}
+
+// ChannelAudit is the audit result of a single channel.
+type ChannelAudit struct {
+	ChannelPoint string `json:"channel_point"`
+	RemotePubkey string `json:"remote_pubkey"`
+	State        string `json:"state"`
+	LocalBalance uint64 `json:"local_balance"`
+	KeyCheck     string `json:"key_check"`
+
+	AuditTotals
+
+	Outputs []*AuditOutput `json:"outputs,omitempty"`
+}
+
+// AuditReport is the audit result of all channels.
+type AuditReport struct {
+	NodePubkey string `json:"node_pubkey"`
+	Network    string `json:"network"`
+	Height     uint32 `json:"block_height"`
+	Timestamp  string `json:"timestamp"`
+
+	AuditTotals
+
+	Channels []*ChannelAudit `json:"channels"`
+}
+
+// AuditChannels finds out how much of the local balance of each of the given
+// summary entries can be recovered now, can be recovered later, needs the
+// remote node to be recovered or is lost. The maturity dates of the outputs
+// are estimated from the given time.
+func AuditChannels(api ChainAPI, entries []*dataformat.SummaryEntry,
+	checkKeys AuditKeyChecker, now time.Time) (*AuditReport, error) {
+
+	height, err := api.BlockHeight()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching block height: %w", err)
+	}
+
+	report := &AuditReport{
+		Height:    height,
+		Timestamp: now.UTC().Format(time.RFC3339),
+		Channels:  make([]*ChannelAudit, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		audit := &ChannelAudit{
+			ChannelPoint: entry.ChannelPoint,
+			RemotePubkey: entry.RemotePubkey,
+			State:        entry.State(),
+			LocalBalance: entry.LocalBalance,
+			KeyCheck:     AuditKeyNotChecked,
+		}
+
+		switch {
+		// If the funding transaction doesn't exist, the funds never
+		// left the wallet.
+		case !entry.ChanExists:
+
+		case entry.ClosingTX == nil:
+			if entry.LocalBalance == 0 {
+				break
+			}
+			audit.Outputs = append(audit.Outputs, &AuditOutput{
+				Outpoint: entry.ChannelPoint,
+				Value:    entry.LocalBalance,
+				Category: AuditNeedsCounterparty,
+				Note: "channel is still open, needs a " +
+					"cooperative close or a force close " +
+					"by the remote node",
+			})
+
+		default:
+			err := auditClosedChannel(
+				api, entry, audit, checkKeys, height, now,
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, output := range audit.Outputs {
+			audit.add(output)
+			report.add(output)
+		}
+		report.Channels = append(report.Channels, audit)
+	}
+
+	return report, nil
+}
+
+// auditClosedChannel adds the outputs of the closing transaction of the given
+// channel that are ours to the channel audit.
+func auditClosedChannel(api ChainAPI, entry *dataformat.SummaryEntry,
+	audit *ChannelAudit, checkKeys AuditKeyChecker, height uint32,
+	now time.Time) error {
+
+	closingTXID := entry.ClosingTX.TXID
+	tx, err := api.Transaction(closingTXID)
+	if err != nil {
+		return fmt.Errorf("error fetching closing transaction %s: %w",
+			closingTXID, err)
+	}
+
+	var keyErr error
+	if checkKeys != nil {
+		var checked bool
+		checked, keyErr = checkKeys(entry)
+		switch {
+		case keyErr != nil:
+			audit.KeyCheck = AuditKeyFailed
+
+		case checked:
+			audit.KeyCheck = AuditKeyOK
+		}
+	}
+
+	// Our to_local output is locked for the CSV delay. If it was spent
+	// before, the remote node used the revocation key because we
+	// published an old state.
+	ownForceClose := entry.ForceClose != nil &&
+		entry.ForceClose.TXID == closingTXID
+	if ownForceClose && entry.ClosingTX.ConfHeight > 0 {
+		matureHeight := entry.ClosingTX.ConfHeight +
+			uint32(entry.ForceClose.CSVDelay)
+		for idx, vout := range tx.Vout {
+			outspend := vout.Outspend
+			if vout.Value != entry.LocalBalance ||
+				outspend == nil || !outspend.Spent ||
+				outspend.Status == nil ||
+				!outspend.Status.Confirmed ||
+				uint32(outspend.Status.BlockHeight) >=
+					matureHeight {
+
+				continue
+			}
+
+			audit.Outputs = append(audit.Outputs, &AuditOutput{
+				Outpoint: fmt.Sprintf("%s:%d", closingTXID,
+					idx),
+				Value:    vout.Value,
+				Category: AuditLost,
+				Note: fmt.Sprintf("spent by %s before it "+
+					"matured, an old state was published "+
+					"and the remote node took the funds",
+					outspend.Txid),
+			})
+		}
+	}
+
+	htlcOutputs := make(map[int]*dataformat.HTLCOutput)
+	for _, htlc := range entry.ClosingTX.HTLCOutputs {
+		htlcOutputs[int(htlc.Index)] = htlc
+	}
+
+	for _, output := range closingOutputs(entry, tx) {
+		auditOutput := &AuditOutput{
+			Outpoint: fmt.Sprintf("%s:%d", closingTXID,
+				output.index),
+			Value: output.value,
+		}
+
+		htlc, isHTLC := htlcOutputs[output.index]
+		switch {
+		case isHTLC && htlc.Direction ==
+			dataformat.HTLCDirectionOutgoing:
+
+			auditOutput.Category = AuditRecoverableLater
+			auditOutput.Note = "outgoing HTLC, can be timed out " +
+				"after its CLTV expiry"
+			if htlc.ExpiryHeight > height {
+				auditOutput.MatureHeight = htlc.ExpiryHeight
+				auditOutput.MatureDate = estimateBlockDate(
+					now, height, htlc.ExpiryHeight,
+				).Format(time.RFC3339)
+			}
+
+		case isHTLC:
+			auditOutput.Category = AuditLost
+			auditOutput.Note = "incoming or unknown HTLC, can " +
+				"only be claimed with the payment preimage"
+
+		// Outputs without an unlock height are not ours.
+		case output.unlockHeight == 0:
+			continue
+
+		case keyErr != nil:
+			auditOutput.Category = AuditLost
+			auditOutput.Note = fmt.Sprintf("key can't be derived "+
+				"from the seed: %v", keyErr)
+
+		case height >= output.unlockHeight:
+			auditOutput.Category = AuditRecoverableNow
+
+		default:
+			auditOutput.Category = AuditRecoverableLater
+			auditOutput.MatureHeight = output.unlockHeight
+			auditOutput.MatureDate = estimateBlockDate(
+				now, height, output.unlockHeight,
+			).Format(time.RFC3339)
+			auditOutput.Note = fmt.Sprintf("matures in %d blocks",
+				output.unlockHeight-height)
+		}
+
+		audit.Outputs = append(audit.Outputs, auditOutput)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package btc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/guggero/chantools/dataformat"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditChannels(t *testing.T) {
+	unspent := func(scriptType string, value uint64) *Vout {
+		return &Vout{
+			ScriptPubkeyType: scriptType,
+			Value:            value,
+			Outspend:         &Outspend{},
+		}
+	}
+	api := &mockChainAPI{height: 150, txs: map[string]*TX{
+		"aa": {TXID: "aa", Vout: []*Vout{
+			unspent("v0_p2wsh", 50_000),
+			unspent("v0_p2wpkh", 80_000),
+			unspent("v0_p2wsh", 20_000),
+			unspent("v0_p2wsh", 5_000),
+		}},
+		"bb": {TXID: "bb", Vout: []*Vout{
+			unspent("v0_p2wpkh", 40_000),
+		}},
+		"cc": {TXID: "cc", Vout: []*Vout{{
+			ScriptPubkeyType: "v0_p2wsh",
+			Value:            60_000,
+			Outspend: &Outspend{
+				Spent: true,
+				Txid:  "ee",
+				Status: &Status{
+					Confirmed:   true,
+					BlockHeight: 110,
+				},
+			},
+		}}},
+		"dd": {TXID: "dd", Vout: []*Vout{
+			unspent("v0_p2wpkh", 10_000),
+		}},
+	}}
+	forceClose := func(txid string) *dataformat.ForceClose {
+		return &dataformat.ForceClose{TXID: txid, CSVDelay: 144}
+	}
+	entries := []*dataformat.SummaryEntry{{
+		ChannelPoint: "f0:0",
+		LocalBalance: 10_000,
+	}, {
+		ChannelPoint: "f1:0",
+		ChanExists:   true,
+		LocalBalance: 30_000,
+	}, {
+		ChannelPoint: "f2:0",
+		ChanExists:   true,
+		LocalBalance: 50_000,
+		ClosingTX: &dataformat.ClosingTX{
+			TXID:       "aa",
+			ForceClose: true,
+			ConfHeight: 100,
+			HTLCOutputs: []*dataformat.HTLCOutput{{
+				Index:        2,
+				Value:        20_000,
+				Direction:    dataformat.HTLCDirectionOutgoing,
+				ExpiryHeight: 160,
+			}, {
+				Index:     3,
+				Value:     5_000,
+				Direction: dataformat.HTLCDirectionIncoming,
+			}},
+		},
+		ForceClose: forceClose("aa"),
+	}, {
+		ChannelPoint: "f3:0",
+		ChanExists:   true,
+		LocalBalance: 40_000,
+		ClosingTX: &dataformat.ClosingTX{
+			TXID:       "bb",
+			ConfHeight: 120,
+		},
+	}, {
+		ChannelPoint: "f4:0",
+		ChanExists:   true,
+		LocalBalance: 60_000,
+		ClosingTX: &dataformat.ClosingTX{
+			TXID:       "cc",
+			ForceClose: true,
+			ConfHeight: 100,
+		},
+		ForceClose: forceClose("cc"),
+	}, {
+		ChannelPoint: "f5:0",
+		ChanExists:   true,
+		LocalBalance: 10_000,
+		ClosingTX: &dataformat.ClosingTX{
+			TXID:       "dd",
+			ConfHeight: 120,
+		},
+	}}
+
+	checkKeys := func(entry *dataformat.SummaryEntry) (bool, error) {
+		if entry.ChannelPoint == "f5:0" {
+			return false, errors.New("wrong seed")
+		}
+
+		return entry.ForceClose != nil, nil
+	}
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	report, err := AuditChannels(api, entries, checkKeys, now)
+	require.NoError(t, err)
+	require.Equal(t, uint32(150), report.Height)
+	require.Equal(t, "2023-01-01T00:00:00Z", report.Timestamp)
+	require.Len(t, report.Channels, 6)
+
+	// The funding transaction of the first channel was never published.
+	require.Empty(t, report.Channels[0].Outputs)
+
+	// The local balance of open channels needs the remote node.
+	require.Equal(t, uint64(30_000), report.Channels[1].NeedsCounterparty)
+
+	// Our to_local output matures after the CSV delay, the outgoing HTLC
+	// can be timed out and the incoming HTLC is lost.
+	forceClosed := report.Channels[2]
+	require.Equal(t, AuditKeyOK, forceClosed.KeyCheck)
+	require.Len(t, forceClosed.Outputs, 3)
+	require.Equal(t, &AuditOutput{
+		Outpoint:     "aa:0",
+		Value:        50_000,
+		Category:     AuditRecoverableLater,
+		MatureHeight: 244,
+		MatureDate:   "2023-01-01T15:40:00Z",
+		Note:         "matures in 94 blocks",
+	}, forceClosed.Outputs[0])
+	require.Equal(t, uint32(160), forceClosed.Outputs[1].MatureHeight)
+	require.Equal(t, uint64(70_000), forceClosed.RecoverableLater)
+	require.Equal(t, uint64(5_000), forceClosed.Lost)
+
+	// Our output of a cooperative close can be swept right away.
+	require.Equal(t, AuditKeyNotChecked, report.Channels[3].KeyCheck)
+	require.Equal(t, uint64(40_000), report.Channels[3].RecoverableNow)
+
+	// Our to_local output was spent before it matured, so we published an
+	// old state.
+	revoked := report.Channels[4]
+	require.Len(t, revoked.Outputs, 1)
+	require.Equal(t, AuditLost, revoked.Outputs[0].Category)
+	require.Contains(t, revoked.Outputs[0].Note, "spent by ee")
+
+	// Outputs with keys that can't be derived are lost.
+	require.Equal(t, AuditKeyFailed, report.Channels[5].KeyCheck)
+	require.Equal(t, uint64(10_000), report.Channels[5].Lost)
+
+	require.Equal(t, AuditTotals{
+		RecoverableNow:    40_000,
+		RecoverableLater:  70_000,
+		NeedsCounterparty: 30_000,
+		Lost:              75_000,
+	}, report.AuditTotals)
+}
@@ -25,23 +25,37 @@ const (
 func ReadMnemonicFromTerminal(params *chaincfg.Params) (*hdkeychain.ExtendedKey,
 	error) {
 
+	return ReadMnemonic("", "", params)
+}
+
+// ReadMnemonic derives the root key from the given BIP39 mnemonic and
+// passphrase. An empty mnemonic or passphrase is read from the environment or
+// the terminal instead, a passphrase of "-" means the seed doesn't have a
+// passphrase.
+func ReadMnemonic(mnemonicStr, passphrase string,
+	params *chaincfg.Params) (*hdkeychain.ExtendedKey, error) {
+
 	var err error
 	reader := bufio.NewReader(os.Stdin)
 
 	// To automate things with chantools, we also offer reading the seed
 	// from environment variables.
-	mnemonicStr := strings.TrimSpace(os.Getenv(BIP39MnemonicEnvName))
+	if mnemonicStr == "" {
+		mnemonicStr = strings.TrimSpace(
+			os.Getenv(BIP39MnemonicEnvName),
+		)
+	}
 
 	if mnemonicStr == "" {
 		// If there's no value in the environment, we'll now prompt the
 		// user to enter in their 12 to 24 word mnemonic.
-		fmt.Printf("Input your 12 to 24 word mnemonic separated by " +
-			"spaces: ")
+		fmt.Fprint(os.Stderr, "Input your 12 to 24 word mnemonic "+
+			"separated by spaces: ")
 		mnemonicStr, err = reader.ReadString('\n')
 		if err != nil {
 			return nil, err
 		}
-		fmt.Println()
+		fmt.Fprintln(os.Stderr)
 	}
 
 	// We'll trim off extra spaces, and ensure the mnemonic is all
@@ -58,7 +72,11 @@ func ReadMnemonicFromTerminal(params *chaincfg.Params) (*hdkeychain.ExtendedKey,
 	// Additionally, the user may have a passphrase, that will also need to
 	// be provided so the daemon can properly decipher the cipher seed.
 	// Try the environment variable first.
-	passphrase := strings.TrimSpace(os.Getenv(BIP39PassphraseEnvName))
+	if passphrase == "" {
+		passphrase = strings.TrimSpace(
+			os.Getenv(BIP39PassphraseEnvName),
+		)
+	}
 
 	// Because we cannot differentiate between an empty and a non-existent
 	// environment variable, we need a special character that indicates that
@@ -80,15 +98,16 @@ func ReadMnemonicFromTerminal(params *chaincfg.Params) (*hdkeychain.ExtendedKey,
 		// Additionally, the user may have a passphrase, that will also
 		// need to be provided so the daemon can properly decipher the
 		// cipher seed.
-		fmt.Printf("Input your cipher seed passphrase (press enter " +
-			"if your seed doesn't have a passphrase): ")
+		fmt.Fprint(os.Stderr, "Input your cipher seed passphrase "+
+			"(press enter if your seed doesn't have a "+
+			"passphrase): ")
 		passphraseBytes, err = terminal.ReadPassword(
 			int(syscall.Stdin), //nolint
 		)
 		if err != nil {
 			return nil, err
 		}
-		fmt.Println()
+		fmt.Fprintln(os.Stderr)
 
 		// Check that the mnemonic is valid.
 		_, err = bip39.EntropyFromMnemonic(mnemonicStr)
@@ -96,17 +115,17 @@ func ReadMnemonicFromTerminal(params *chaincfg.Params) (*hdkeychain.ExtendedKey,
 			return nil, err
 		}
 
-		fmt.Printf("Please choose passphrase mode:\n" +
-			"  0 - Default BIP39\n" +
-			"  1 - Passphrase to hex\n" +
-			"  2 - Digital Bitbox (extra round of PBKDF2)\n" +
-			"\n" +
+		fmt.Fprint(os.Stderr, "Please choose passphrase mode:\n"+
+			"  0 - Default BIP39\n"+
+			"  1 - Passphrase to hex\n"+
+			"  2 - Digital Bitbox (extra round of PBKDF2)\n"+
+			"\n"+
 			"Choice [default 0]: ")
 		choice, err = reader.ReadString('\n')
 		if err != nil {
 			return nil, err
 		}
-		fmt.Println()
+		fmt.Fprintln(os.Stderr)
 
 	// There was a password in the environment, just convert it to bytes.
 	default:
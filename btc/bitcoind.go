@@ -19,6 +19,28 @@ const (
 	FormatImportwallet = "bitcoin-importwallet"
 	FormatDescriptors  = "bitcoin-descriptors"
 	FormatElectrum     = "electrum"
+
+	// FormatElectrumMasterKey and FormatImportDescriptors are not
+	// KeyExporter formats as they export the extended keys of the wallet
+	// accounts instead of individual keys.
+	FormatElectrumMasterKey = "electrum-masterkey"
+	FormatImportDescriptors = "descriptors"
+)
+
+var (
+	// slip132Versions are the SLIP-0132 extended private and public key
+	// versions Electrum uses to detect the script type of a master key,
+	// indexed by the BIP43 purpose and then by whether it's a mainnet key.
+	slip132Versions = map[uint32]map[bool][2][]byte{
+		49: {
+			true:  {{0x04, 0x9d, 0x78, 0x78}, {0x04, 0x9d, 0x7c, 0xb2}},
+			false: {{0x04, 0x4a, 0x4e, 0x28}, {0x04, 0x4a, 0x52, 0x62}},
+		},
+		84: {
+			true:  {{0x04, 0xb2, 0x43, 0x0c}, {0x04, 0xb2, 0x47, 0x46}},
+			false: {{0x04, 0x5f, 0x18, 0xbc}, {0x04, 0x5f, 0x1c, 0xf6}},
+		},
+	}
 )
 
 type KeyExporter interface {
@@ -67,7 +89,7 @@ func ExportKeys(extendedKey *hdkeychain.ExtendedKey, strPaths []string,
 		// External branch first (<DerivationPath>/0/i).
 		for i := uint32(0); i < recoveryWindow; i++ {
 			path := append(path, 0, i)
-			derivedKey, err := lnd.DeriveChildren(extendedKey, path)
+			derivedKey, err := lnd.DeriveFromRoot(extendedKey, path)
 			if err != nil {
 				return err
 			}
@@ -83,7 +105,7 @@ func ExportKeys(extendedKey *hdkeychain.ExtendedKey, strPaths []string,
 		// Now the internal branch (<DerivationPath>/1/i).
 		for i := uint32(0); i < recoveryWindow; i++ {
 			path := append(path, 1, i)
-			derivedKey, err := lnd.DeriveChildren(extendedKey, path)
+			derivedKey, err := lnd.DeriveFromRoot(extendedKey, path)
 			if err != nil {
 				return err
 			}
@@ -264,8 +286,16 @@ func (p *Electrum) Format(hdKey *hdkeychain.ExtendedKey,
 		return "", fmt.Errorf("could not encode WIF: %w", err)
 	}
 
+	// lnd's BIP49 account uses native SegWit addresses for change. The
+	// legacy BIP44 account was only used by very old lnd versions.
 	prefix := "p2wpkh"
-	if strings.HasPrefix(path, lnd.WalletBIP49DerivationPath) {
+	switch {
+	case strings.HasPrefix(path, lnd.WalletBIP44DerivationPath):
+		prefix = "p2pkh"
+
+	case strings.HasPrefix(path, lnd.WalletBIP49DerivationPath) &&
+		branch == 0:
+
 		prefix = "p2wpkh-p2sh"
 	}
 
@@ -276,6 +306,75 @@ func (p *Electrum) Trailer(_ uint32) string {
 	return ""
 }
 
+// ExportElectrumMasterKeys writes the extended private and public keys of
+// lnd's BIP49 and BIP84 wallet accounts in the SLIP-0132 format to the writer.
+// Electrum can restore a wallet from such a master key ("Use a master key") and
+// derives the external and internal addresses itself. The public keys can be
+// used to create a watch-only wallet.
+func ExportElectrumMasterKeys(extendedKey *hdkeychain.ExtendedKey,
+	params *chaincfg.Params, writer io.Writer) error {
+
+	_, _ = fmt.Fprintf(
+		writer, "# Wallet dump created by chantools on %s\n",
+		time.Now().UTC(),
+	)
+	_, _ = fmt.Fprintf(writer, "# Restore a new Electrum standard wallet "+
+		"from one of the following master keys.\n")
+
+	isMainnet := params.Net == chaincfg.MainNetParams.Net
+	for _, purpose := range []uint32{49, 84} {
+		path := []uint32{
+			lnd.HardenedKey(purpose),
+			lnd.HardenedKey(params.HDCoinType),
+			lnd.HardenedKey(0),
+		}
+		accountKey, err := lnd.DeriveFromRoot(extendedKey, path)
+		if err != nil {
+			return fmt.Errorf("could not derive account key: %w",
+				err)
+		}
+		accountPubKey, err := accountKey.Neuter()
+		if err != nil {
+			return fmt.Errorf("could not neuter account key: %w",
+				err)
+		}
+
+		versions := slip132Versions[purpose][isMainnet]
+		xPriv, err := accountKey.CloneWithVersion(versions[0])
+		if err != nil {
+			return fmt.Errorf("could not encode private key: %w",
+				err)
+		}
+		xPub, err := accountPubKey.CloneWithVersion(versions[1])
+		if err != nil {
+			return fmt.Errorf("could not encode public key: %w",
+				err)
+		}
+
+		scriptType := "p2wpkh"
+		if purpose == 49 {
+			scriptType = "p2wpkh-p2sh"
+		}
+		_, _ = fmt.Fprintf(writer, "\n# Account m/%d'/%d'/0' (%s)\n",
+			purpose, params.HDCoinType, scriptType)
+		if purpose == 49 {
+			_, _ = fmt.Fprintf(writer, "# NOTE: lnd uses p2wpkh "+
+				"addresses for the change of this account, "+
+				"use the %s format to import those keys.\n",
+				FormatElectrum)
+		}
+		_, _ = fmt.Fprintf(writer, "Master private key: %s\n", xPriv)
+		_, _ = fmt.Fprintf(writer, "Master public key (watch-only): "+
+			"%s\n", xPub)
+	}
+
+	_, _ = fmt.Fprintf(writer, "\n# NOTE: Electrum doesn't support p2tr "+
+		"addresses, use the %s format and Bitcoin Core for the "+
+		"m/86' account.\n", FormatDescriptors)
+
+	return nil
+}
+
 type Descriptors struct{}
 
 func (d *Descriptors) Header() string {
@@ -302,17 +401,22 @@ func (d *Descriptors) Format(hdKey *hdkeychain.ExtendedKey,
 	if err != nil {
 		return "", fmt.Errorf("could not create address: %w", err)
 	}
+	addrP2PKH, err := lnd.P2PKHAddr(privKey.PubKey(), params)
+	if err != nil {
+		return "", fmt.Errorf("could not create address: %w", err)
+	}
 	addrP2TR, err := lnd.P2TRAddr(privKey.PubKey(), params)
 	if err != nil {
 		return "", fmt.Errorf("could not create address: %w", err)
 	}
 
+	p2pkh := makeDescriptor("pkh(%s)", wif.String(), addrP2PKH)
 	np2wkh := makeDescriptor("sh(wpkh(%s))", wif.String(), addrNP2WKH)
 	p2wkh := makeDescriptor("wpkh(%s)", wif.String(), addrP2WKH)
 	p2tr := makeDescriptor("tr(%s)", wif.String(), addrP2TR)
 
-	return fmt.Sprintf("bitcoin-cli importdescriptors '[%s,%s,%s]'",
-		np2wkh, p2wkh, p2tr), nil
+	return fmt.Sprintf("bitcoin-cli importdescriptors '[%s,%s,%s,%s]'",
+		p2pkh, np2wkh, p2wkh, p2tr), nil
 }
 
 func (d *Descriptors) Trailer(birthdayBlock uint32) string {
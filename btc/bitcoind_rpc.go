@@ -0,0 +1,730 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+const (
+	// bitcoindErrNoTx is the RPC error code bitcoind returns if a
+	// transaction cannot be found.
+	bitcoindErrNoTx btcjson.RPCErrorCode = -5
+
+	// bitcoindErrMisc is the RPC error code bitcoind returns for generic
+	// errors, for example if the block filter index is not enabled.
+	bitcoindErrMisc btcjson.RPCErrorCode = -1
+
+	// bitcoindErrMethodNotFound is the RPC error code bitcoind returns if
+	// an RPC method does not exist.
+	bitcoindErrMethodNotFound btcjson.RPCErrorCode = -32601
+)
+
+var (
+	// scriptTypes maps the script type names bitcoind uses to the ones
+	// esplora uses.
+	scriptTypes = map[string]string{
+		"pubkey":                "p2pk",
+		"pubkeyhash":            "p2pkh",
+		"scripthash":            "p2sh",
+		"witness_v0_keyhash":    "v0_p2wpkh",
+		"witness_v0_scripthash": "v0_p2wsh",
+		"witness_v1_taproot":    "v1_p2tr",
+		"nulldata":              "op_return",
+		"multisig":              "multisig",
+	}
+)
+
+// BitcoindAPI is a ChainAPI that uses the JSON-RPC interface of a bitcoind
+// full node. The node must have the transaction index enabled (txindex=1).
+// If the block filter index is enabled as well (blockfilterindex=1), spending
+// transactions are found much faster.
+type BitcoindAPI struct {
+	// Host is the host:port of the bitcoind RPC interface.
+	Host string
+
+	// User and Password are used for authentication. If CookieFile is
+	// set, the credentials are read from that file instead.
+	User       string
+	Password   string
+	CookieFile string
+}
+
+// Enforce BitcoindAPI implements the SweepAPI and BatchChainAPI interfaces.
+var _ SweepAPI = (*BitcoindAPI)(nil)
+var _ BatchChainAPI = (*BitcoindAPI)(nil)
+
+// rpcResult is the result of a single request of a batch.
+type rpcResult struct {
+	result json.RawMessage
+	err    error
+}
+
+type bitcoindScriptPubKey struct {
+	Asm     string `json:"asm"`
+	Hex     string `json:"hex"`
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+type bitcoindVout struct {
+	Value        float64              `json:"value"`
+	N            uint32               `json:"n"`
+	ScriptPubKey bitcoindScriptPubKey `json:"scriptPubKey"`
+}
+
+type bitcoindVin struct {
+	Coinbase string `json:"coinbase"`
+	TXID     string `json:"txid"`
+	Vout     uint32 `json:"vout"`
+	Sequence uint32 `json:"sequence"`
+}
+
+type bitcoindTx struct {
+	TXID      string          `json:"txid"`
+	Version   int32           `json:"version"`
+	Locktime  uint32          `json:"locktime"`
+	Vin       []*bitcoindVin  `json:"vin"`
+	Vout      []*bitcoindVout `json:"vout"`
+	BlockHash string          `json:"blockhash"`
+}
+
+type bitcoindBlock struct {
+	Hash   string        `json:"hash"`
+	Height int           `json:"height"`
+	Tx     []*bitcoindTx `json:"tx"`
+}
+
+type bitcoindBlockHeader struct {
+	Hash   string `json:"hash"`
+	Height int    `json:"height"`
+}
+
+type bitcoindScanBlocksResult struct {
+	RelevantBlocks []string `json:"relevant_blocks"`
+}
+
+type bitcoindUnspent struct {
+	TXID string `json:"txid"`
+	Vout int    `json:"vout"`
+}
+
+type bitcoindSubmitPackageResult struct {
+	PackageMsg string                              `json:"package_msg"`
+	TxResults  map[string]*bitcoindPackageTxResult `json:"tx-results"`
+}
+
+type bitcoindPackageTxResult struct {
+	TXID  string `json:"txid"`
+	Error string `json:"error"`
+}
+
+type bitcoindScanTxOutSetResult struct {
+	Success  bool               `json:"success"`
+	Unspents []*bitcoindUnspent `json:"unspents"`
+}
+
+// newClient creates a new RPC client for bitcoind. If batch is true, the
+// requests are queued until Send is called on the client. The client must be
+// shut down after use.
+func (b *BitcoindAPI) newClient(batch bool) (*rpcclient.Client, error) {
+	host, disableTLS := b.Host, true
+	switch {
+	case strings.HasPrefix(host, "http://"):
+		host = strings.TrimPrefix(host, "http://")
+
+	case strings.HasPrefix(host, "https://"):
+		host, disableTLS = strings.TrimPrefix(host, "https://"), false
+	}
+
+	cfg := &rpcclient.ConnConfig{
+		Host:         host,
+		DisableTLS:   disableTLS,
+		HTTPPostMode: true,
+	}
+
+	// The cookie file takes precedence over the user and password.
+	if b.CookieFile != "" {
+		cfg.CookiePath = b.CookieFile
+	} else {
+		cfg.User, cfg.Pass = b.User, b.Password
+	}
+	if batch {
+		return rpcclient.NewBatch(cfg)
+	}
+
+	return rpcclient.New(cfg, nil)
+}
+
+// call sends a JSON-RPC request to bitcoind and decodes the result into the
+// given target.
+func (b *BitcoindAPI) call(method string, target interface{},
+	params ...interface{}) error {
+
+	rawParams, err := marshalParams(params)
+	if err != nil {
+		return err
+	}
+
+	client, err := b.newClient(false)
+	if err != nil {
+		return err
+	}
+	defer client.Shutdown()
+
+	result, err := client.RawRequest(method, rawParams)
+	if err != nil {
+		return err
+	}
+
+	if target == nil {
+		return nil
+	}
+	return json.Unmarshal(result, target)
+}
+
+// batchCall sends one JSON-RPC request of the given method per parameter list
+// to bitcoind, all in a single HTTP request. The results are returned in the
+// order of the parameter lists, errors of the individual requests are not
+// checked.
+func (b *BitcoindAPI) batchCall(method string,
+	paramsList [][]interface{}) ([]*rpcResult, error) {
+
+	if len(paramsList) == 0 {
+		return nil, nil
+	}
+
+	client, err := b.newClient(true)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Shutdown()
+
+	futures := make([]rpcclient.FutureRawResult, len(paramsList))
+	for idx, params := range paramsList {
+		rawParams, err := marshalParams(params)
+		if err != nil {
+			return nil, err
+		}
+		futures[idx] = client.RawRequestAsync(method, rawParams)
+	}
+
+	if err := client.Send(); err != nil {
+		return nil, err
+	}
+
+	results := make([]*rpcResult, len(futures))
+	for idx, future := range futures {
+		result, err := future.Receive()
+		results[idx] = &rpcResult{result: result, err: err}
+	}
+
+	return results, nil
+}
+
+// marshalParams encodes the given parameters for a raw RPC request.
+func marshalParams(params []interface{}) ([]json.RawMessage, error) {
+	rawParams := make([]json.RawMessage, len(params))
+	for idx, param := range params {
+		rawParam, err := json.Marshal(param)
+		if err != nil {
+			return nil, err
+		}
+		rawParams[idx] = rawParam
+	}
+
+	return rawParams, nil
+}
+
+// Transaction returns the transaction with the given ID, including the spend
+// information of each of its outputs.
+func (b *BitcoindAPI) Transaction(txid string) (*TX, error) {
+	txs, err := b.Transactions([]string{txid})
+	if err != nil {
+		return nil, err
+	}
+	if txs[0] == nil {
+		return nil, ErrTxNotFound
+	}
+
+	return txs[0], nil
+}
+
+// Transactions returns the transactions with the given IDs, including the spend
+// information of each of their outputs. The transactions and the state of their
+// outputs are looked up with batched requests. Transactions that don't exist
+// are returned as nil.
+func (b *BitcoindAPI) Transactions(txids []string) ([]*TX, error) {
+	txParams := make([][]interface{}, len(txids))
+	for idx, txid := range txids {
+		txParams[idx] = []interface{}{txid, true}
+	}
+	txResponses, err := b.batchCall("getrawtransaction", txParams)
+	if err != nil {
+		return nil, err
+	}
+
+	// unspentCheck is a confirmed output we need to check the state of.
+	type unspentCheck struct {
+		vout      *Vout
+		outpoint  string
+		blockHash string
+	}
+	var (
+		txs       = make([]*TX, len(txids))
+		outParams [][]interface{}
+		checks    []*unspentCheck
+	)
+	for idx, resp := range txResponses {
+		switch {
+		case isRPCError(resp.err, bitcoindErrNoTx):
+			continue
+
+		case resp.err != nil:
+			return nil, resp.err
+		}
+
+		var rawTx bitcoindTx
+		if err := json.Unmarshal(resp.result, &rawTx); err != nil {
+			return nil, err
+		}
+		txs[idx], err = convertBitcoindTx(&rawTx)
+		if err != nil {
+			return nil, err
+		}
+
+		for voutIdx, vout := range txs[idx].Vout {
+			vout.Outspend = &Outspend{}
+
+			// Only confirmed spends count, just like with esplora.
+			if rawTx.BlockHash == "" {
+				continue
+			}
+
+			// OP_RETURN outputs never make it into the UTXO set, so
+			// gettxout would report them as spent.
+			pkScript, err := hex.DecodeString(vout.ScriptPubkey)
+			if err != nil {
+				return nil, fmt.Errorf("invalid script: %w", err)
+			}
+			class := txscript.GetScriptClass(pkScript)
+			if class == txscript.NullDataTy {
+				continue
+			}
+
+			outParams = append(outParams, []interface{}{
+				rawTx.TXID, voutIdx, false,
+			})
+			checks = append(checks, &unspentCheck{
+				vout: vout,
+				outpoint: fmt.Sprintf(
+					"%s:%d", rawTx.TXID, voutIdx,
+				),
+				blockHash: rawTx.BlockHash,
+			})
+		}
+	}
+
+	// Find out which outputs are still unspent.
+	outResponses, err := b.batchCall("gettxout", outParams)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]*Vout)
+	spentBlocks := make(map[string]struct{})
+	for idx, resp := range outResponses {
+		if resp.err != nil {
+			return nil, resp.err
+		}
+		if isJSONNull(resp.result) {
+			check := checks[idx]
+			wanted[check.outpoint] = check.vout
+			spentBlocks[check.blockHash] = struct{}{}
+		}
+	}
+
+	if len(wanted) == 0 {
+		return txs, nil
+	}
+
+	// The spends can only be in blocks after the earliest block any of
+	// the spent outputs was confirmed in.
+	headerParams := make([][]interface{}, 0, len(spentBlocks))
+	for hash := range spentBlocks {
+		headerParams = append(headerParams, []interface{}{hash, true})
+	}
+	headerResponses, err := b.batchCall("getblockheader", headerParams)
+	if err != nil {
+		return nil, err
+	}
+	startHeight := -1
+	for _, resp := range headerResponses {
+		if resp.err != nil {
+			return nil, resp.err
+		}
+
+		var header bitcoindBlockHeader
+		if err := json.Unmarshal(resp.result, &header); err != nil {
+			return nil, err
+		}
+		if startHeight < 0 || header.Height < startHeight {
+			startHeight = header.Height
+		}
+	}
+
+	err = b.findSpends(wanted, startHeight)
+	if err != nil {
+		return nil, fmt.Errorf("error finding spending transactions: "+
+			"%w", err)
+	}
+
+	return txs, nil
+}
+
+// BlockHeight returns the height of the current best block.
+func (b *BitcoindAPI) BlockHeight() (uint32, error) {
+	var height uint32
+	if err := b.call("getblockcount", &height); err != nil {
+		return 0, err
+	}
+
+	return height, nil
+}
+
+// Outpoint returns a transaction that pays to the given address and the index
+// of the output that does so. Because bitcoind doesn't have an address index,
+// the UTXO set is scanned, which can take a few minutes. Only unspent outputs
+// can be found that way.
+func (b *BitcoindAPI) Outpoint(addr string) (*TX, int, error) {
+	var result bitcoindScanTxOutSetResult
+	err := b.call(
+		"scantxoutset", &result, "start",
+		[]string{fmt.Sprintf("addr(%s)", addr)},
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error scanning UTXO set: %w", err)
+	}
+	if !result.Success || len(result.Unspents) == 0 {
+		return nil, 0, fmt.Errorf("no tx found")
+	}
+
+	unspent := result.Unspents[0]
+	tx, err := b.rawTransaction(unspent.TXID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return tx, unspent.Vout, nil
+}
+
+// Address returns the address of the given outpoint in the format
+// <txid>:<index>.
+func (b *BitcoindAPI) Address(outpoint string) (string, error) {
+	parts := strings.Split(outpoint, ":")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid outpoint: %v", outpoint)
+	}
+
+	vout, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := b.rawTransaction(parts[0])
+	if err != nil {
+		return "", err
+	}
+	if vout < 0 || len(tx.Vout) <= vout {
+		return "", fmt.Errorf("invalid output index: %d", vout)
+	}
+
+	return tx.Vout[vout].ScriptPubkeyAddr, nil
+}
+
+// PublishTx publishes the given hex encoded raw transaction and returns its
+// transaction ID.
+func (b *BitcoindAPI) PublishTx(rawTxHex string) (string, error) {
+	var txid string
+	if err := b.call("sendrawtransaction", &txid, rawTxHex); err != nil {
+		return "", err
+	}
+
+	return txid, nil
+}
+
+// SubmitPackage publishes the given hex encoded raw transactions as a single
+// package with bitcoind's submitpackage call, which requires bitcoind 28 or
+// later for packages that contain transactions below the minimum relay fee.
+func (b *BitcoindAPI) SubmitPackage(rawTxHexes []string) (string, error) {
+	var result bitcoindSubmitPackageResult
+	err := b.call("submitpackage", &result, rawTxHexes)
+	if err != nil {
+		return "", err
+	}
+
+	if result.PackageMsg != "success" {
+		var txErrors []string
+		for _, txResult := range result.TxResults {
+			if txResult.Error != "" {
+				txErrors = append(txErrors, fmt.Sprintf(
+					"%s: %s", txResult.TXID,
+					txResult.Error,
+				))
+			}
+		}
+		sort.Strings(txErrors)
+
+		return "", fmt.Errorf("package was not accepted: %s (%s)",
+			result.PackageMsg, strings.Join(txErrors, ", "))
+	}
+
+	return result.PackageMsg, nil
+}
+
+// Chain returns the name of the chain bitcoind runs on, for example main,
+// test or regtest.
+func (b *BitcoindAPI) Chain() (string, error) {
+	var info struct {
+		Chain string `json:"chain"`
+	}
+	if err := b.call("getblockchaininfo", &info); err != nil {
+		return "", err
+	}
+
+	return info.Chain, nil
+}
+
+// NewAddress returns a new bech32 address of the wallet that is loaded in
+// bitcoind.
+func (b *BitcoindAPI) NewAddress() (string, error) {
+	var addr string
+	if err := b.call("getnewaddress", &addr, "", "bech32"); err != nil {
+		return "", err
+	}
+
+	return addr, nil
+}
+
+// Balance returns the confirmed balance of the wallet that is loaded in
+// bitcoind.
+func (b *BitcoindAPI) Balance() (btcutil.Amount, error) {
+	var balance float64
+	if err := b.call("getbalance", &balance); err != nil {
+		return 0, err
+	}
+
+	return btcutil.NewAmount(balance)
+}
+
+// SendToAddress sends the given amount from the wallet that is loaded in
+// bitcoind to the given address and returns the transaction ID.
+func (b *BitcoindAPI) SendToAddress(addr string,
+	amount btcutil.Amount) (string, error) {
+
+	var txid string
+	err := b.call("sendtoaddress", &txid, addr, amount.ToBTC())
+	if err != nil {
+		return "", err
+	}
+
+	return txid, nil
+}
+
+// GenerateToAddress mines the given number of blocks with the coinbase paying
+// to the given address and returns the block hashes. This only works on
+// regtest.
+func (b *BitcoindAPI) GenerateToAddress(numBlocks uint32,
+	addr string) ([]string, error) {
+
+	var hashes []string
+	err := b.call("generatetoaddress", &hashes, numBlocks, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// rawTransaction returns the transaction with the given ID without looking up
+// the spend information of its outputs.
+func (b *BitcoindAPI) rawTransaction(txid string) (*TX, error) {
+	var rawTx bitcoindTx
+	err := b.call("getrawtransaction", &rawTx, txid, true)
+	if isRPCError(err, bitcoindErrNoTx) {
+		return nil, ErrTxNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return convertBitcoindTx(&rawTx)
+}
+
+// findSpends looks for the transactions that spend the given outputs, keyed
+// by their outpoint, in all blocks starting at the given height and records
+// them in the outputs' spend information.
+func (b *BitcoindAPI) findSpends(wanted map[string]*Vout,
+	startHeight int) error {
+
+	descriptors := make([]interface{}, 0, len(wanted))
+	for _, vout := range wanted {
+		descriptors = append(
+			descriptors, fmt.Sprintf("raw(%s)", vout.ScriptPubkey),
+		)
+	}
+
+	// With the block filter index we can find the relevant blocks
+	// directly.
+	var scanResult bitcoindScanBlocksResult
+	err := b.call(
+		"scanblocks", &scanResult, "start", descriptors, startHeight,
+	)
+	switch {
+	case err == nil:
+		for _, blockHash := range scanResult.RelevantBlocks {
+			var block bitcoindBlock
+			err := b.call("getblock", &block, blockHash, 2)
+			if err != nil {
+				return err
+			}
+
+			markSpends(&block, wanted)
+			if len(wanted) == 0 {
+				return nil
+			}
+		}
+
+	// Without the block filter index (or an old version of bitcoind) we
+	// need to scan all blocks one by one.
+	case isRPCError(err, bitcoindErrMethodNotFound) ||
+		isRPCError(err, bitcoindErrMisc):
+
+		var blockCount int
+		if err := b.call("getblockcount", &blockCount); err != nil {
+			return err
+		}
+		for height := startHeight; height <= blockCount; height++ {
+			var blockHash string
+			err := b.call("getblockhash", &blockHash, height)
+			if err != nil {
+				return err
+			}
+
+			var block bitcoindBlock
+			err = b.call("getblock", &block, blockHash, 2)
+			if err != nil {
+				return err
+			}
+
+			markSpends(&block, wanted)
+			if len(wanted) == 0 {
+				return nil
+			}
+		}
+
+	default:
+		return err
+	}
+
+	if len(wanted) > 0 {
+		return fmt.Errorf("could not find spending transaction for "+
+			"%d outputs", len(wanted))
+	}
+
+	return nil
+}
+
+// markSpends looks for inputs in the block that spend any of the wanted
+// outputs, records the spend and removes the output from the wanted map.
+func markSpends(block *bitcoindBlock, wanted map[string]*Vout) {
+	for _, blockTx := range block.Tx {
+		for vinIdx, vin := range blockTx.Vin {
+			if vin.Coinbase != "" {
+				continue
+			}
+
+			outpoint := fmt.Sprintf("%s:%d", vin.TXID, vin.Vout)
+			vout, ok := wanted[outpoint]
+			if !ok {
+				continue
+			}
+
+			vout.Outspend = &Outspend{
+				Spent: true,
+				Txid:  blockTx.TXID,
+				Vin:   vinIdx,
+				Status: &Status{
+					Confirmed:   true,
+					BlockHeight: block.Height,
+					BlockHash:   block.Hash,
+				},
+			}
+			delete(wanted, outpoint)
+		}
+	}
+}
+
+// convertBitcoindTx converts a verbose bitcoind transaction into the esplora
+// format.
+func convertBitcoindTx(rawTx *bitcoindTx) (*TX, error) {
+	tx := &TX{
+		TXID:     rawTx.TXID,
+		Version:  rawTx.Version,
+		Locktime: rawTx.Locktime,
+		Vin:      make([]*Vin, len(rawTx.Vin)),
+		Vout:     make([]*Vout, len(rawTx.Vout)),
+	}
+	for idx, vin := range rawTx.Vin {
+		tx.Vin[idx] = &Vin{
+			Tixid:    vin.TXID,
+			Vout:     int(vin.Vout),
+			Sequence: vin.Sequence,
+		}
+	}
+	for idx, vout := range rawTx.Vout {
+		value, err := btcutil.NewAmount(vout.Value)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := hex.DecodeString(vout.ScriptPubKey.Hex); err != nil {
+			return nil, fmt.Errorf("invalid script: %w", err)
+		}
+
+		scriptType, ok := scriptTypes[vout.ScriptPubKey.Type]
+		if !ok {
+			scriptType = "unknown"
+		}
+		tx.Vout[idx] = &Vout{
+			ScriptPubkey:     vout.ScriptPubKey.Hex,
+			ScriptPubkeyAsm:  vout.ScriptPubKey.Asm,
+			ScriptPubkeyType: scriptType,
+			ScriptPubkeyAddr: vout.ScriptPubKey.Address,
+			Value:            uint64(value),
+		}
+	}
+
+	return tx, nil
+}
+
+// isRPCError returns true if the error is a bitcoind RPC error with the given
+// code.
+func isRPCError(err error, code btcjson.RPCErrorCode) bool {
+	var rpcErr *btcjson.RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == code
+}
+
+// isJSONNull returns true if the raw JSON message is empty or null.
+func isJSONNull(msg json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(msg)
+	return len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null"))
+}
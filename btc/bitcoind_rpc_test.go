@@ -0,0 +1,526 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBitcoind is a minimal bitcoind JSON-RPC server without the block filter
+// index.
+type fakeBitcoind struct {
+	txs    map[string]*bitcoindTx
+	blocks []*bitcoindBlock
+	spent  map[string]bool
+
+	numRequests int32
+}
+
+type fakeRPCRequest struct {
+	ID     uint64            `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+func (f *fakeBitcoind) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&f.numRequests, 1)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Batched requests are sent as a JSON array.
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		var reqs []*fakeRPCRequest
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		responses := make([]interface{}, len(reqs))
+		for idx, req := range reqs {
+			responses[idx] = f.handle(req)
+		}
+		_ = json.NewEncoder(w).Encode(responses)
+
+		return
+	}
+
+	req := &fakeRPCRequest{}
+	if err := json.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(f.handle(req))
+}
+
+func (f *fakeBitcoind) handle(req *fakeRPCRequest) interface{} {
+	var (
+		result interface{}
+		rpcErr *btcjson.RPCError
+		str    string
+		num    int
+	)
+	switch req.Method {
+	case "getrawtransaction":
+		_ = json.Unmarshal(req.Params[0], &str)
+		tx, ok := f.txs[str]
+		if !ok {
+			rpcErr = &btcjson.RPCError{Code: bitcoindErrNoTx}
+			break
+		}
+		result = tx
+
+	case "gettxout":
+		_ = json.Unmarshal(req.Params[0], &str)
+		_ = json.Unmarshal(req.Params[1], &num)
+
+		// Just like bitcoind, we never report OP_RETURN outputs as
+		// unspent.
+		tx, ok := f.txs[str]
+		if ok && tx.Vout[num].ScriptPubKey.Type == "nulldata" {
+			break
+		}
+		if !f.spent[fmt.Sprintf("%s:%d", str, num)] {
+			result = map[string]interface{}{"value": 1}
+		}
+
+	case "getblockheader":
+		_ = json.Unmarshal(req.Params[0], &str)
+		for _, block := range f.blocks {
+			if block.Hash == str {
+				result = &bitcoindBlockHeader{
+					Hash:   block.Hash,
+					Height: block.Height,
+				}
+			}
+		}
+
+	case "scantxoutset":
+		var descriptors []string
+		_ = json.Unmarshal(req.Params[1], &descriptors)
+		var unspents []*bitcoindUnspent
+		for _, tx := range f.txs {
+			for idx, vout := range tx.Vout {
+				addr := fmt.Sprintf(
+					"addr(%s)", vout.ScriptPubKey.Address,
+				)
+				if addr == descriptors[0] {
+					unspents = append(
+						unspents, &bitcoindUnspent{
+							TXID: tx.TXID,
+							Vout: idx,
+						},
+					)
+				}
+			}
+		}
+		result = &bitcoindScanTxOutSetResult{
+			Success:  true,
+			Unspents: unspents,
+		}
+
+	case "getblockchaininfo":
+		result = map[string]string{"chain": "regtest"}
+
+	case "getnewaddress":
+		result = "bcrt1qnew"
+
+	case "getbalance":
+		result = 1.5
+
+	case "sendtoaddress":
+		var amount float64
+		_ = json.Unmarshal(req.Params[0], &str)
+		_ = json.Unmarshal(req.Params[1], &amount)
+		result = fmt.Sprintf("%s-%.8f", str, amount)
+
+	case "generatetoaddress":
+		_ = json.Unmarshal(req.Params[0], &num)
+		hashes := make([]string, num)
+		for idx := range hashes {
+			hashes[idx] = fmt.Sprintf("block%d", idx)
+		}
+		result = hashes
+
+	case "sendrawtransaction":
+		_ = json.Unmarshal(req.Params[0], &str)
+		result = "txid-of-" + str
+
+	case "submitpackage":
+		var rawTxs []string
+		_ = json.Unmarshal(req.Params[0], &rawTxs)
+		txResults := make(map[string]*bitcoindPackageTxResult)
+		for _, rawTx := range rawTxs {
+			txResults["w"+rawTx] = &bitcoindPackageTxResult{
+				TXID: "txid-of-" + rawTx,
+			}
+		}
+		msg := "success"
+		if len(rawTxs) < 2 {
+			msg = "transaction failed"
+			txResults["w"+rawTxs[0]].Error = "min relay fee not met"
+		}
+		result = &bitcoindSubmitPackageResult{
+			PackageMsg: msg,
+			TxResults:  txResults,
+		}
+
+	case "scanblocks":
+		rpcErr = &btcjson.RPCError{Code: bitcoindErrMisc}
+
+	case "getblockcount":
+		result = f.blocks[len(f.blocks)-1].Height
+
+	case "getblockhash":
+		_ = json.Unmarshal(req.Params[0], &num)
+		result = f.blocks[num].Hash
+
+	case "getblock":
+		_ = json.Unmarshal(req.Params[0], &str)
+		for _, block := range f.blocks {
+			if block.Hash == str {
+				result = block
+			}
+		}
+
+	default:
+		rpcErr = &btcjson.RPCError{Code: bitcoindErrMethodNotFound}
+	}
+
+	return map[string]interface{}{
+		"id":     req.ID,
+		"result": result,
+		"error":  rpcErr,
+	}
+}
+
+func TestBitcoindAPITransaction(t *testing.T) {
+	fundingTx := &bitcoindTx{
+		TXID:      "aa",
+		BlockHash: "block1",
+		Vout: []*bitcoindVout{{
+			Value: 0.01,
+			ScriptPubKey: bitcoindScriptPubKey{
+				Hex:     "0020abcd",
+				Type:    "witness_v0_scripthash",
+				Address: "bc1qfunding",
+			},
+		}, {
+			Value: 0.00012345,
+			ScriptPubKey: bitcoindScriptPubKey{
+				Hex:  "0014abcd",
+				Type: "witness_v0_keyhash",
+			},
+		}},
+	}
+	spendTx := &bitcoindTx{
+		TXID: "bb",
+		Vin: []*bitcoindVin{
+			{TXID: "cc", Vout: 1},
+			{TXID: "aa", Vout: 0},
+		},
+	}
+	server := httptest.NewServer(&fakeBitcoind{
+		txs: map[string]*bitcoindTx{"aa": fundingTx},
+		blocks: []*bitcoindBlock{
+			{Hash: "block0", Height: 0},
+			{Hash: "block1", Height: 1, Tx: []*bitcoindTx{
+				fundingTx,
+			}},
+			{Hash: "block2", Height: 2},
+			{Hash: "block3", Height: 3, Tx: []*bitcoindTx{
+				spendTx,
+			}},
+		},
+		spent: map[string]bool{"aa:0": true},
+	})
+	defer server.Close()
+
+	api := &BitcoindAPI{
+		Host:     server.URL,
+		User:     "user",
+		Password: "pass",
+	}
+
+	tx, err := api.Transaction("aa")
+	require.NoError(t, err)
+	require.Len(t, tx.Vout, 2)
+
+	require.Equal(t, uint64(1_000_000), tx.Vout[0].Value)
+	require.Equal(t, "v0_p2wsh", tx.Vout[0].ScriptPubkeyType)
+	require.Equal(t, "bc1qfunding", tx.Vout[0].ScriptPubkeyAddr)
+	require.Equal(t, &Outspend{
+		Spent: true,
+		Txid:  "bb",
+		Vin:   1,
+		Status: &Status{
+			Confirmed:   true,
+			BlockHeight: 3,
+			BlockHash:   "block3",
+		},
+	}, tx.Vout[0].Outspend)
+
+	require.Equal(t, uint64(12_345), tx.Vout[1].Value)
+	require.Equal(t, "v0_p2wpkh", tx.Vout[1].ScriptPubkeyType)
+	require.False(t, tx.Vout[1].Outspend.Spent)
+
+	_, err = api.Transaction("dd")
+	require.ErrorIs(t, err, ErrTxNotFound)
+}
+
+func TestBitcoindAPITransactionOpReturn(t *testing.T) {
+	tx := &bitcoindTx{
+		TXID:      "aa",
+		BlockHash: "block1",
+		Vout: []*bitcoindVout{{
+			ScriptPubKey: bitcoindScriptPubKey{
+				Hex:  "6a0461626364",
+				Type: "nulldata",
+			},
+		}, {
+			Value: 0.01,
+			ScriptPubKey: bitcoindScriptPubKey{
+				Hex:  "0014abcd",
+				Type: "witness_v0_keyhash",
+			},
+		}},
+	}
+	fake := &fakeBitcoind{
+		txs: map[string]*bitcoindTx{"aa": tx},
+		blocks: []*bitcoindBlock{
+			{Hash: "block0", Height: 0},
+			{Hash: "block1", Height: 1, Tx: []*bitcoindTx{tx}},
+			{Hash: "block2", Height: 2},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	api := &BitcoindAPI{
+		Host:     server.URL,
+		User:     "user",
+		Password: "pass",
+	}
+
+	result, err := api.Transaction("aa")
+	require.NoError(t, err)
+	require.Len(t, result.Vout, 2)
+	require.Equal(t, "op_return", result.Vout[0].ScriptPubkeyType)
+	require.Equal(t, &Outspend{}, result.Vout[0].Outspend)
+	require.False(t, result.Vout[1].Outspend.Spent)
+
+	// The OP_RETURN output isn't mistaken for a spent output, so no
+	// blocks need to be scanned for its spend.
+	require.EqualValues(t, 2, atomic.LoadInt32(&fake.numRequests))
+}
+
+func TestBitcoindAPITransactions(t *testing.T) {
+	var (
+		txs    = make(map[string]*bitcoindTx)
+		spent  = make(map[string]bool)
+		block1 = &bitcoindBlock{Hash: "block1", Height: 1}
+		block2 = &bitcoindBlock{Hash: "block2", Height: 2}
+		txids  []string
+	)
+	for i := 0; i < 50; i++ {
+		txid := fmt.Sprintf("%02x", i)
+		tx := &bitcoindTx{
+			TXID:      txid,
+			BlockHash: "block1",
+			Vout: []*bitcoindVout{{
+				Value: 0.01,
+				ScriptPubKey: bitcoindScriptPubKey{
+					Hex:  fmt.Sprintf("0020%02x", i),
+					Type: "witness_v0_scripthash",
+				},
+			}},
+		}
+		txs[txid] = tx
+		txids = append(txids, txid)
+		block1.Tx = append(block1.Tx, tx)
+
+		// Every second output is spent in the next block.
+		if i%2 == 0 {
+			spent[txid+":0"] = true
+			block2.Tx = append(block2.Tx, &bitcoindTx{
+				TXID: "spend" + txid,
+				Vin:  []*bitcoindVin{{TXID: txid}},
+			})
+		}
+	}
+	fake := &fakeBitcoind{
+		txs: txs,
+		blocks: []*bitcoindBlock{
+			{Hash: "block0", Height: 0}, block1, block2,
+		},
+		spent: spent,
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	api := &BitcoindAPI{
+		Host:     server.URL,
+		User:     "user",
+		Password: "pass",
+	}
+
+	result, err := api.Transactions(append(txids, "unknown"))
+	require.NoError(t, err)
+	require.Len(t, result, 51)
+	require.Nil(t, result[50])
+	for i, tx := range result[:50] {
+		require.Equal(t, txids[i], tx.TXID)
+
+		outspend := tx.Vout[0].Outspend
+		require.Equal(t, i%2 == 0, outspend.Spent)
+		if outspend.Spent {
+			require.Equal(t, "spend"+txids[i], outspend.Txid)
+			require.Equal(t, 2, outspend.Status.BlockHeight)
+		}
+	}
+
+	// The transactions, the state of their outputs and the block header
+	// are each fetched with a single request. Without the block filter
+	// index, the blocks are then scanned one by one.
+	require.LessOrEqual(t, atomic.LoadInt32(&fake.numRequests), int32(9))
+}
+
+func TestBitcoindAPISweep(t *testing.T) {
+	tx := &bitcoindTx{
+		TXID: "aa",
+		Vout: []*bitcoindVout{{
+			Value: 0.01,
+			ScriptPubKey: bitcoindScriptPubKey{
+				Hex:     "0014abcd",
+				Type:    "witness_v0_keyhash",
+				Address: "bc1qother",
+			},
+		}, {
+			Value: 0.02,
+			ScriptPubKey: bitcoindScriptPubKey{
+				Hex:     "0020abcd",
+				Type:    "witness_v0_scripthash",
+				Address: "bc1qtimelock",
+			},
+		}},
+	}
+	server := httptest.NewServer(&fakeBitcoind{
+		txs: map[string]*bitcoindTx{"aa": tx},
+	})
+	defer server.Close()
+
+	api := &BitcoindAPI{
+		Host:     server.URL,
+		User:     "user",
+		Password: "pass",
+	}
+
+	foundTx, idx, err := api.Outpoint("bc1qtimelock")
+	require.NoError(t, err)
+	require.Equal(t, "aa", foundTx.TXID)
+	require.Equal(t, 1, idx)
+	require.Equal(t, uint64(2_000_000), foundTx.Vout[idx].Value)
+
+	_, _, err = api.Outpoint("bc1qunknown")
+	require.ErrorContains(t, err, "no tx found")
+
+	addr, err := api.Address("aa:0")
+	require.NoError(t, err)
+	require.Equal(t, "bc1qother", addr)
+
+	_, err = api.Address("aa:2")
+	require.ErrorContains(t, err, "invalid output index")
+
+	_, err = api.Address("dd:0")
+	require.ErrorIs(t, err, ErrTxNotFound)
+
+	txid, err := api.PublishTx("0200")
+	require.NoError(t, err)
+	require.Equal(t, "txid-of-0200", txid)
+
+	msg, err := api.SubmitPackage([]string{"0200", "0201"})
+	require.NoError(t, err)
+	require.Equal(t, "success", msg)
+
+	_, err = api.SubmitPackage([]string{"0200"})
+	require.ErrorContains(t, err, "txid-of-0200: min relay fee not met")
+}
+
+func TestBitcoindAPIWallet(t *testing.T) {
+	server := httptest.NewServer(&fakeBitcoind{})
+	defer server.Close()
+
+	api := &BitcoindAPI{
+		Host:     server.URL,
+		User:     "user",
+		Password: "pass",
+	}
+
+	chain, err := api.Chain()
+	require.NoError(t, err)
+	require.Equal(t, "regtest", chain)
+
+	addr, err := api.NewAddress()
+	require.NoError(t, err)
+	require.Equal(t, "bcrt1qnew", addr)
+
+	balance, err := api.Balance()
+	require.NoError(t, err)
+	require.Equal(t, btcutil.Amount(150_000_000), balance)
+
+	txid, err := api.SendToAddress("bcrt1qto", 12_345)
+	require.NoError(t, err)
+	require.Equal(t, "bcrt1qto-0.00012345", txid)
+
+	hashes, err := api.GenerateToAddress(3, addr)
+	require.NoError(t, err)
+	require.Equal(t, []string{"block0", "block1", "block2"}, hashes)
+}
+
+func TestBitcoindAPICookie(t *testing.T) {
+	fake := &fakeBitcoind{}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			if !ok || user != "__cookie__" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fake.ServeHTTP(w, r)
+		},
+	))
+	defer server.Close()
+
+	cookieFile := filepath.Join(t.TempDir(), ".cookie")
+	err := os.WriteFile(cookieFile, []byte("__cookie__:secret\n"), 0600)
+	require.NoError(t, err)
+
+	// The cookie file takes precedence over the user and password.
+	api := &BitcoindAPI{
+		Host:       server.URL,
+		User:       "user",
+		Password:   "wrong",
+		CookieFile: cookieFile,
+	}
+	chain, err := api.Chain()
+	require.NoError(t, err)
+	require.Equal(t, "regtest", chain)
+
+	api.CookieFile = ""
+	_, err = api.Chain()
+	require.ErrorContains(t, err, "status code: 401")
+}
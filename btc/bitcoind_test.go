@@ -0,0 +1,75 @@
+package btc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/guggero/chantools/lnd"
+	"github.com/stretchr/testify/require"
+)
+
+const testRootKey = "tprv8ZgxMBicQKsPejNXQLJKe3dBBs9Zrt53EZrsBzVLQ8rZji3hV" +
+	"b3wcoRvgrjvTmjPG2ixoGUUkCyC6yBEy9T5gbLdvD2a5VmJbcFd5Q9pkAs"
+
+func TestExportElectrumMasterKeys(t *testing.T) {
+	rootKey, err := hdkeychain.NewKeyFromString(testRootKey)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		params   *chaincfg.Params
+		prefixes []string
+	}{{
+		params:   &chaincfg.MainNetParams,
+		prefixes: []string{"yprv", "ypub", "zprv", "zpub", "m/84'/0'"},
+	}, {
+		params:   &chaincfg.RegressionNetParams,
+		prefixes: []string{"uprv", "upub", "vprv", "vpub", "m/84'/1'"},
+	}}
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		err := ExportElectrumMasterKeys(rootKey, tc.params, &buf)
+		require.NoError(t, err)
+
+		for _, prefix := range tc.prefixes {
+			require.Contains(t, buf.String(), prefix)
+		}
+		require.NotContains(t, buf.String(), "tprv")
+	}
+}
+
+func TestElectrumFormatBIP49Change(t *testing.T) {
+	rootKey, err := hdkeychain.NewKeyFromString(testRootKey)
+	require.NoError(t, err)
+
+	// lnd uses native SegWit addresses for the change of the BIP49
+	// account, so only the external branch is nested SegWit.
+	electrum := &Electrum{}
+	path := lnd.WalletBIP49DerivationPath
+	external, err := electrum.Format(
+		rootKey, &chaincfg.RegressionNetParams, path, 0, 0,
+	)
+	require.NoError(t, err)
+	require.Contains(t, external, "p2wpkh-p2sh:")
+
+	internal, err := electrum.Format(
+		rootKey, &chaincfg.RegressionNetParams, path, 1, 0,
+	)
+	require.NoError(t, err)
+	require.Contains(t, internal, "p2wpkh:")
+}
+
+func TestElectrumFormatBIP44(t *testing.T) {
+	rootKey, err := hdkeychain.NewKeyFromString(testRootKey)
+	require.NoError(t, err)
+
+	// Very old lnd versions used legacy addresses in the BIP44 account.
+	electrum := &Electrum{}
+	key, err := electrum.Format(
+		rootKey, &chaincfg.RegressionNetParams,
+		lnd.WalletBIP44DerivationPath, 1, 0,
+	)
+	require.NoError(t, err)
+	require.Contains(t, key, "p2pkh:")
+}
@@ -0,0 +1,129 @@
+package btc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/guggero/chantools/dataformat"
+)
+
+const (
+	// MaturityLockCSV is the lock type of outputs that are locked for a
+	// number of blocks after the closing transaction confirmed.
+	MaturityLockCSV = "csv"
+
+	// MaturityLockCLTV is the lock type of outgoing HTLC outputs that can
+	// only be timed out after an absolute block height.
+	MaturityLockCLTV = "cltv"
+)
+
+// MaturityEntry is a time locked output of a closed channel.
+type MaturityEntry struct {
+	ChannelPoint string `json:"channel_point"`
+	Outpoint     string `json:"outpoint"`
+	Value        uint64 `json:"value"`
+	Lock         string `json:"lock"`
+
+	// Height is the block height at which the output can be swept and
+	// Date the estimated date of that block. BlocksLeft is zero if the
+	// output already matured.
+	Height     uint32 `json:"height"`
+	Date       string `json:"date"`
+	BlocksLeft uint32 `json:"blocks_left"`
+}
+
+// MaturityCalendar lists the unspent time locked outputs of the closed
+// channels of the given summary entries, sorted by the height at which they
+// can be swept. The dates are estimated from the given time and the current
+// block height, which is returned as well.
+func MaturityCalendar(api ChainAPI, entries []*dataformat.SummaryEntry,
+	now time.Time) (uint32, []*MaturityEntry, error) {
+
+	height, err := api.BlockHeight()
+	if err != nil {
+		return 0, nil, fmt.Errorf("error fetching block height: %w",
+			err)
+	}
+
+	var calendar []*MaturityEntry
+	addEntry := func(entry *dataformat.SummaryEntry, index int,
+		value uint64, lock string, matureHeight uint32) {
+
+		maturity := &MaturityEntry{
+			ChannelPoint: entry.ChannelPoint,
+			Outpoint: fmt.Sprintf("%s:%d", entry.ClosingTX.TXID,
+				index),
+			Value:  value,
+			Lock:   lock,
+			Height: matureHeight,
+			Date: estimateBlockDate(
+				now, height, matureHeight,
+			).Format(time.RFC3339),
+		}
+		if matureHeight > height {
+			maturity.BlocksLeft = matureHeight - height
+		}
+		calendar = append(calendar, maturity)
+	}
+
+	for _, entry := range entries {
+		if entry.ClosingTX == nil || entry.ClosingTX.AllOutsSpent ||
+			entry.ClosingTX.ConfHeight == 0 {
+
+			continue
+		}
+
+		tx, err := api.Transaction(entry.ClosingTX.TXID)
+		if err != nil {
+			return 0, nil, fmt.Errorf("error fetching closing "+
+				"transaction %s: %w", entry.ClosingTX.TXID, err)
+		}
+
+		// Outputs that unlock after the closing transaction confirmed
+		// are CSV locked.
+		for _, output := range closingOutputs(entry, tx) {
+			if output.unlockHeight <= entry.ClosingTX.ConfHeight {
+				continue
+			}
+
+			addEntry(
+				entry, output.index, output.value,
+				MaturityLockCSV, output.unlockHeight,
+			)
+		}
+
+		// Our outgoing HTLCs can be timed out once they expired.
+		for _, htlc := range entry.ClosingTX.HTLCOutputs {
+			if htlc.Spent || htlc.ExpiryHeight == 0 ||
+				htlc.Direction !=
+					dataformat.HTLCDirectionOutgoing {
+
+				continue
+			}
+
+			addEntry(
+				entry, int(htlc.Index), htlc.Value,
+				MaturityLockCLTV, htlc.ExpiryHeight,
+			)
+		}
+	}
+
+	sort.SliceStable(calendar, func(i, j int) bool {
+		return calendar[i].Height < calendar[j].Height
+	})
+
+	return height, calendar, nil
+}
+
+// estimateBlockDate estimates the date of the block with the target height
+// from the current time and height. The current time is returned for blocks
+// that were already mined.
+func estimateBlockDate(now time.Time, height, target uint32) time.Time {
+	if target <= height {
+		return now.UTC()
+	}
+
+	blocks := time.Duration(target - height)
+	return now.Add(blocks * averageBlockInterval).UTC()
+}
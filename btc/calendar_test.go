@@ -0,0 +1,100 @@
+package btc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/guggero/chantools/dataformat"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaturityCalendar(t *testing.T) {
+	unspent := func(scriptType string, value uint64) *Vout {
+		return &Vout{
+			ScriptPubkeyType: scriptType,
+			Value:            value,
+			Outspend:         &Outspend{},
+		}
+	}
+	api := &mockChainAPI{height: 200, txs: map[string]*TX{
+		"aa": {TXID: "aa", Vout: []*Vout{
+			unspent("v0_p2wsh", 50_000),
+			unspent("v0_p2wpkh", 80_000),
+			unspent("v0_p2wsh", 20_000),
+			unspent("v0_p2wsh", 5_000),
+		}},
+		"bb": {TXID: "bb", Vout: []*Vout{
+			unspent("v0_p2wsh", 40_000),
+			unspent("v0_p2wsh", 70_000),
+		}},
+	}}
+	entries := []*dataformat.SummaryEntry{{
+		ChannelPoint: "f0:0",
+		ChanExists:   true,
+		LocalBalance: 30_000,
+	}, {
+		ChannelPoint: "f1:0",
+		ChanExists:   true,
+		LocalBalance: 50_000,
+		ClosingTX: &dataformat.ClosingTX{
+			TXID:       "aa",
+			ForceClose: true,
+			ConfHeight: 100,
+			HTLCOutputs: []*dataformat.HTLCOutput{{
+				Index:        2,
+				Value:        20_000,
+				Direction:    dataformat.HTLCDirectionOutgoing,
+				ExpiryHeight: 190,
+			}, {
+				Index:        3,
+				Value:        5_000,
+				Direction:    dataformat.HTLCDirectionIncoming,
+				ExpiryHeight: 180,
+			}},
+		},
+		ForceClose: &dataformat.ForceClose{TXID: "aa", CSVDelay: 144},
+	}, {
+		// The to_remote output of an anchor channel is locked for one
+		// block.
+		ChannelPoint: "f2:0",
+		ChanExists:   true,
+		LocalBalance: 40_000,
+		ClosingTX: &dataformat.ClosingTX{
+			TXID:       "bb",
+			ForceClose: true,
+			ConfHeight: 210,
+		},
+	}}
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	height, calendar, err := MaturityCalendar(api, entries, now)
+	require.NoError(t, err)
+	require.Equal(t, uint32(200), height)
+
+	// The expired outgoing HTLC comes first, the incoming HTLC and the
+	// output of the remote node are not time locked for us.
+	require.Equal(t, []*MaturityEntry{{
+		ChannelPoint: "f1:0",
+		Outpoint:     "aa:2",
+		Value:        20_000,
+		Lock:         MaturityLockCLTV,
+		Height:       190,
+		Date:         "2023-01-01T00:00:00Z",
+	}, {
+		ChannelPoint: "f2:0",
+		Outpoint:     "bb:0",
+		Value:        40_000,
+		Lock:         MaturityLockCSV,
+		Height:       211,
+		Date:         "2023-01-01T01:50:00Z",
+		BlocksLeft:   11,
+	}, {
+		ChannelPoint: "f1:0",
+		Outpoint:     "aa:0",
+		Value:        50_000,
+		Lock:         MaturityLockCSV,
+		Height:       244,
+		Date:         "2023-01-01T07:20:00Z",
+		BlocksLeft:   44,
+	}}, calendar)
+}
@@ -1,7 +1,14 @@
 package btc
 
 import (
+	"fmt"
 	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/guggero/chantools/lnd"
 )
 
 var (
@@ -81,3 +88,154 @@ func DescriptorSumCheck(s string, require bool) bool {
 	)
 	return descriptorSumPolymod(symbols) == 1
 }
+
+// ImportDescriptor is a single entry of the request of bitcoind's
+// importdescriptors RPC.
+type ImportDescriptor struct {
+	Desc      string    `json:"desc"`
+	Active    bool      `json:"active,omitempty"`
+	Range     [2]uint32 `json:"range"`
+	Timestamp int64     `json:"timestamp"`
+	Internal  bool      `json:"internal"`
+}
+
+// walletAccount is one of the on-chain accounts of lnd's internal wallet with
+// the descriptor script templates of its external and internal (change)
+// branch. Old lnd versions used a different script for the change of some
+// accounts, that is added as an additional internal descriptor.
+type walletAccount struct {
+	purpose     uint32
+	external    string
+	internal    string
+	oldInternal string
+}
+
+// descriptorBranch is the descriptor script template of the external or
+// internal branch of a wallet account.
+type descriptorBranch struct {
+	script   string
+	internal bool
+}
+
+// lndWalletAccounts are the accounts of lnd's internal wallet. The BIP49
+// account uses nested SegWit addresses for receiving but native SegWit
+// addresses for change, old lnd versions also used nested SegWit addresses for
+// change. The BIP44 account with legacy addresses was only used by old lnd
+// versions.
+var lndWalletAccounts = []walletAccount{{
+	purpose:  44,
+	external: "pkh(%s)",
+	internal: "pkh(%s)",
+}, {
+	purpose:     49,
+	external:    "sh(wpkh(%s))",
+	internal:    "wpkh(%s)",
+	oldInternal: "sh(wpkh(%s))",
+}, {
+	purpose:  84,
+	external: "wpkh(%s)",
+	internal: "wpkh(%s)",
+}, {
+	purpose:  86,
+	external: "tr(%s)",
+	internal: "tr(%s)",
+}}
+
+// WatchOnlyDescriptors returns the ranged output descriptors (including their
+// checksum) for the external and internal branch of all default accounts of
+// lnd's internal wallet. Only the extended public keys of the accounts are
+// used, so the descriptors can be imported into a watch-only wallet.
+func WatchOnlyDescriptors(extendedKey *hdkeychain.ExtendedKey,
+	params *chaincfg.Params, recoveryWindow uint32,
+	birthday time.Time) ([]*ImportDescriptor, error) {
+
+	return accountDescriptors(
+		extendedKey, params, recoveryWindow, birthday, false,
+	)
+}
+
+// PrivateDescriptors returns the same ranged output descriptors as
+// WatchOnlyDescriptors but with the extended private keys of the accounts, so
+// the funds can be spent from a Bitcoin Core descriptor wallet. The descriptors
+// are marked as active, except for the internal branches of the BIP49 account
+// which would replace the active change descriptors of the BIP84 account and
+// the legacy BIP44 account that lnd doesn't use anymore.
+func PrivateDescriptors(extendedKey *hdkeychain.ExtendedKey,
+	params *chaincfg.Params, recoveryWindow uint32,
+	birthday time.Time) ([]*ImportDescriptor, error) {
+
+	return accountDescriptors(
+		extendedKey, params, recoveryWindow, birthday, true,
+	)
+}
+
+func accountDescriptors(extendedKey *hdkeychain.ExtendedKey,
+	params *chaincfg.Params, recoveryWindow uint32, birthday time.Time,
+	withPrivateKeys bool) ([]*ImportDescriptor, error) {
+
+	if recoveryWindow == 0 {
+		return nil, fmt.Errorf("recovery window must be greater than 0")
+	}
+
+	masterPubKey, err := extendedKey.ECPubKey()
+	if err != nil {
+		return nil, fmt.Errorf("could not derive master public key: %w",
+			err)
+	}
+	fingerprint := btcutil.Hash160(masterPubKey.SerializeCompressed())[:4]
+
+	descriptors := make([]*ImportDescriptor, 0, len(lndWalletAccounts)*3)
+	for _, account := range lndWalletAccounts {
+		path := []uint32{
+			lnd.HardenedKey(account.purpose),
+			lnd.HardenedKey(params.HDCoinType),
+			lnd.HardenedKey(0),
+		}
+		accountKey, err := lnd.DeriveFromRoot(extendedKey, path)
+		if err != nil {
+			return nil, fmt.Errorf("could not derive account key: %w",
+				err)
+		}
+		if !withPrivateKeys {
+			accountKey, err = accountKey.Neuter()
+			if err != nil {
+				return nil, fmt.Errorf("could not neuter "+
+					"account key: %w", err)
+			}
+		}
+
+		// We use the "h" notation for hardened levels so the
+		// descriptors can be put into a single quoted shell argument.
+		origin := fmt.Sprintf("[%x/%dh/%dh/0h]%s", fingerprint,
+			account.purpose, params.HDCoinType, accountKey)
+		branches := []descriptorBranch{
+			{script: account.external, internal: false},
+			{script: account.internal, internal: true},
+		}
+		if account.oldInternal != "" {
+			branches = append(branches, descriptorBranch{
+				script: account.oldInternal, internal: true,
+			})
+		}
+		for _, branch := range branches {
+			branchIndex := 0
+			if branch.internal {
+				branchIndex = 1
+			}
+			key := fmt.Sprintf("%s/%d/*", origin, branchIndex)
+			active := withPrivateKeys && account.purpose != 44 &&
+				!(account.purpose == 49 && branch.internal)
+			descriptors = append(descriptors, &ImportDescriptor{
+				Desc: DescriptorSumCreate(
+					fmt.Sprintf(branch.script, key),
+				),
+				Active:    active,
+				Range:     [2]uint32{0, recoveryWindow - 1},
+				Timestamp: birthday.Unix(),
+				Internal:  branch.internal,
+			})
+		}
+	}
+
+	return descriptors, nil
+}
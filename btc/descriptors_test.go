@@ -1,8 +1,12 @@
 package btc
 
 import (
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/stretchr/testify/require"
 )
 
@@ -25,3 +29,71 @@ func TestDescriptorSum(t *testing.T) {
 		DescriptorSumCheck(sum, true)
 	}
 }
+
+func TestWatchOnlyDescriptors(t *testing.T) {
+	rootKey, err := hdkeychain.NewKeyFromString(testRootKey)
+	require.NoError(t, err)
+
+	birthday := time.Unix(1600000000, 0)
+	descriptors, err := WatchOnlyDescriptors(
+		rootKey, &chaincfg.RegressionNetParams, 100, birthday,
+	)
+	require.NoError(t, err)
+	require.Len(t, descriptors, 9)
+
+	prefixes := []string{
+		"pkh([", "pkh([", "sh(wpkh([", "wpkh([", "sh(wpkh([", "wpkh([",
+		"wpkh([", "tr([", "tr([",
+	}
+	internal := []bool{
+		false, true, false, true, true, false, true, false, true,
+	}
+	for idx, desc := range descriptors {
+		require.True(t, strings.HasPrefix(desc.Desc, prefixes[idx]))
+		require.Contains(t, desc.Desc, "tpub")
+		require.NotContains(t, desc.Desc, "tprv")
+		require.Equal(t, [2]uint32{0, 99}, desc.Range)
+		require.Equal(t, birthday.Unix(), desc.Timestamp)
+		require.Equal(t, internal[idx], desc.Internal)
+
+		// The checksum must be valid for the descriptor itself.
+		plain := desc.Desc[:len(desc.Desc)-9]
+		require.Equal(t, desc.Desc, DescriptorSumCreate(plain))
+	}
+	require.Contains(t, descriptors[0].Desc, "/44h/1h/0h]tpub")
+	require.Contains(t, descriptors[5].Desc, "/84h/1h/0h]tpub")
+	require.True(t, strings.HasSuffix(
+		descriptors[6].Desc[:len(descriptors[6].Desc)-9], "/1/*)",
+	))
+
+	// Old lnd versions used nested SegWit addresses for change.
+	require.True(t, strings.HasSuffix(
+		descriptors[4].Desc[:len(descriptors[4].Desc)-9], "/1/*))",
+	))
+
+	_, err = WatchOnlyDescriptors(
+		rootKey, &chaincfg.RegressionNetParams, 0, birthday,
+	)
+	require.ErrorContains(t, err, "recovery window")
+}
+
+func TestPrivateDescriptors(t *testing.T) {
+	rootKey, err := hdkeychain.NewKeyFromString(testRootKey)
+	require.NoError(t, err)
+
+	descriptors, err := PrivateDescriptors(
+		rootKey, &chaincfg.RegressionNetParams, 100, time.Unix(0, 0),
+	)
+	require.NoError(t, err)
+	require.Len(t, descriptors, 9)
+
+	for idx, desc := range descriptors {
+		require.Contains(t, desc.Desc, "tprv")
+		require.Zero(t, desc.Timestamp)
+
+		// The legacy descriptors and the BIP49 change descriptors are
+		// inactive as they would replace the BIP84 ones.
+		inactive := idx == 0 || idx == 1 || idx == 3 || idx == 4
+		require.Equal(t, !inactive, desc.Active)
+	}
+}
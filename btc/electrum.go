@@ -0,0 +1,571 @@
+package btc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// electrumProtocolVersion is the Electrum protocol version we
+	// negotiate with the server.
+	electrumProtocolVersion = "1.4"
+
+	// electrumTimeout is the time we wait for the server to respond to a
+	// single request.
+	electrumTimeout = 2 * time.Minute
+)
+
+var (
+	// electrumTxNotFoundMessages are the error messages the different
+	// server implementations return for an unknown transaction. Most of
+	// them just pass on the error of the bitcoind backend.
+	electrumTxNotFoundMessages = []string{
+		"no such mempool or blockchain transaction",
+		"missing transaction",
+	}
+)
+
+var (
+	// scriptClasses maps the script classes to the script type names
+	// esplora uses.
+	scriptClasses = map[txscript.ScriptClass]string{
+		txscript.PubKeyTy:              "p2pk",
+		txscript.PubKeyHashTy:          "p2pkh",
+		txscript.ScriptHashTy:          "p2sh",
+		txscript.WitnessV0PubKeyHashTy: "v0_p2wpkh",
+		txscript.WitnessV0ScriptHashTy: "v0_p2wsh",
+		txscript.WitnessV1TaprootTy:    "v1_p2tr",
+		txscript.NullDataTy:            "op_return",
+		txscript.MultiSigTy:            "multisig",
+	}
+)
+
+// ElectrumAPI is a SweepAPI that uses the protocol of an Electrum server like
+// ElectrumX, Electrs or Fulcrum. Because the protocol is based on script
+// hashes, the spending transaction of an output is found by looking at the
+// history of the output's script.
+type ElectrumAPI struct {
+	// Host is the host:port of the Electrum server.
+	Host string
+
+	// TLS enables an SSL/TLS connection to the server.
+	TLS bool
+
+	// TLSSkipVerify disables the verification of the server's TLS
+	// certificate, which is often self-signed.
+	TLSSkipVerify bool
+
+	// ChainParams are the parameters used to encode addresses.
+	ChainParams *chaincfg.Params
+
+	mu        sync.Mutex
+	conn      net.Conn
+	reader    *bufio.Reader
+	requestID uint64
+}
+
+// Enforce ElectrumAPI implements the SweepAPI interface.
+var _ SweepAPI = (*ElectrumAPI)(nil)
+
+type electrumRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type electrumError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *electrumError) Error() string {
+	return fmt.Sprintf("electrum error %d: %s", e.Code, e.Message)
+}
+
+// isTxNotFound returns true if the error is the server's way of saying that
+// it doesn't know the requested transaction.
+func (e *electrumError) isTxNotFound() bool {
+	msg := strings.ToLower(e.Message)
+	for _, notFoundMsg := range electrumTxNotFoundMessages {
+		if strings.Contains(msg, notFoundMsg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type electrumResponse struct {
+	ID     *uint64         `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *electrumError  `json:"error"`
+}
+
+type electrumHistoryEntry struct {
+	TxHash string `json:"tx_hash"`
+	Height int    `json:"height"`
+}
+
+type electrumHeader struct {
+	Height int    `json:"height"`
+	Hex    string `json:"hex"`
+}
+
+// connect opens the connection to the server and negotiates the protocol
+// version. The caller must hold the mutex.
+func (e *ElectrumAPI) connect() error {
+	dialer := &net.Dialer{Timeout: electrumTimeout}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if e.TLS {
+		tlsConfig := &tls.Config{
+			InsecureSkipVerify: e.TLSSkipVerify, //nolint:gosec
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", e.Host, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", e.Host)
+	}
+	if err != nil {
+		return fmt.Errorf("error connecting to electrum server %s: %w",
+			e.Host, err)
+	}
+
+	e.conn = conn
+	e.reader = bufio.NewReader(conn)
+
+	// Most servers require the version to be negotiated before any other
+	// request is sent.
+	err = e.request(
+		"server.version", nil, "chantools", electrumProtocolVersion,
+	)
+	if err != nil {
+		e.close()
+		return fmt.Errorf("error negotiating protocol version: %w", err)
+	}
+
+	return nil
+}
+
+// close closes the connection to the server. The caller must hold the mutex.
+func (e *ElectrumAPI) close() {
+	if e.conn != nil {
+		_ = e.conn.Close()
+	}
+	e.conn = nil
+	e.reader = nil
+}
+
+// call sends a request to the server and decodes the result into the given
+// target. The connection is opened on the first call and re-opened if it was
+// lost during a previous call.
+func (e *ElectrumAPI) call(method string, target interface{},
+	params ...interface{}) error {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn == nil {
+		if err := e.connect(); err != nil {
+			return err
+		}
+	}
+
+	return e.request(method, target, params...)
+}
+
+// request sends a single request over the open connection and waits for the
+// response, skipping any notifications in between. The caller must hold the
+// mutex.
+func (e *ElectrumAPI) request(method string, target interface{},
+	params ...interface{}) error {
+
+	if params == nil {
+		params = []interface{}{}
+	}
+	e.requestID++
+	id := e.requestID
+	reqBytes, err := json.Marshal(&electrumRequest{
+		JSONRPC: "2.0",
+		ID:      id,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	_ = e.conn.SetDeadline(time.Now().Add(electrumTimeout))
+	if _, err := e.conn.Write(append(reqBytes, '\n')); err != nil {
+		e.close()
+		return fmt.Errorf("error sending request: %w", err)
+	}
+
+	for {
+		line, err := e.reader.ReadBytes('\n')
+		if err != nil {
+			e.close()
+			return fmt.Errorf("error reading response: %w", err)
+		}
+
+		var resp electrumResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			return fmt.Errorf("error decoding response: %w", err)
+		}
+
+		// Notifications of subscriptions don't have an ID.
+		if resp.ID == nil || *resp.ID != id {
+			continue
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if target == nil {
+			return nil
+		}
+
+		return json.Unmarshal(resp.Result, target)
+	}
+}
+
+// Transaction returns the transaction with the given ID, including the spend
+// information of each of its outputs.
+func (e *ElectrumAPI) Transaction(txid string) (*TX, error) {
+	txCache := make(map[string]*wire.MsgTx)
+	msgTx, err := e.msgTx(txid, txCache)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := convertMsgTx(msgTx, e.ChainParams)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, vout := range tx.Vout {
+		vout.Outspend = &Outspend{}
+
+		history, err := e.history(msgTx.TxOut[idx].PkScript)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range history {
+			// The history of the first output also tells us the
+			// confirmation status of the transaction itself.
+			if entry.TxHash == txid {
+				if tx.Status == nil {
+					tx.Status, err = e.status(entry.Height)
+					if err != nil {
+						return nil, err
+					}
+				}
+
+				continue
+			}
+
+			spendTx, err := e.msgTx(entry.TxHash, txCache)
+			if err != nil {
+				return nil, err
+			}
+			vin := spendingInput(spendTx, txid, uint32(idx))
+			if vin < 0 {
+				continue
+			}
+
+			status, err := e.status(entry.Height)
+			if err != nil {
+				return nil, err
+			}
+			vout.Outspend = &Outspend{
+				Spent:  true,
+				Txid:   entry.TxHash,
+				Vin:    vin,
+				Status: status,
+			}
+
+			break
+		}
+	}
+
+	return tx, nil
+}
+
+// BlockHeight returns the height of the current best block.
+func (e *ElectrumAPI) BlockHeight() (uint32, error) {
+	var header electrumHeader
+	if err := e.call("blockchain.headers.subscribe", &header); err != nil {
+		return 0, err
+	}
+
+	return uint32(header.Height), nil
+}
+
+// Outpoint returns a transaction that pays to the given address and the index
+// of the output that does so.
+func (e *ElectrumAPI) Outpoint(addr string) (*TX, int, error) {
+	pkScript, err := e.addrScript(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	history, err := e.history(pkScript)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	txCache := make(map[string]*wire.MsgTx)
+	for _, entry := range history {
+		msgTx, err := e.msgTx(entry.TxHash, txCache)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for idx, txOut := range msgTx.TxOut {
+			if !bytes.Equal(txOut.PkScript, pkScript) {
+				continue
+			}
+
+			tx, err := convertMsgTx(msgTx, e.ChainParams)
+			if err != nil {
+				return nil, 0, err
+			}
+			tx.Status, err = e.status(entry.Height)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			return tx, idx, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("no tx found")
+}
+
+// Address returns the address of the given outpoint in the format
+// <txid>:<index>.
+func (e *ElectrumAPI) Address(outpoint string) (string, error) {
+	parts := strings.Split(outpoint, ":")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid outpoint: %v", outpoint)
+	}
+
+	vout, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	msgTx, err := e.msgTx(parts[0], nil)
+	if err != nil {
+		return "", err
+	}
+	tx, err := convertMsgTx(msgTx, e.ChainParams)
+	if err != nil {
+		return "", err
+	}
+	if vout < 0 || len(tx.Vout) <= vout {
+		return "", fmt.Errorf("invalid output index: %d", vout)
+	}
+
+	return tx.Vout[vout].ScriptPubkeyAddr, nil
+}
+
+// PublishTx publishes the given hex encoded raw transaction and returns its
+// transaction ID.
+func (e *ElectrumAPI) PublishTx(rawTxHex string) (string, error) {
+	var txid string
+	err := e.call("blockchain.transaction.broadcast", &txid, rawTxHex)
+	if err != nil {
+		return "", err
+	}
+
+	return txid, nil
+}
+
+// msgTx fetches and decodes the raw transaction with the given ID. If a cache
+// is given, it is used to avoid fetching the same transaction twice.
+func (e *ElectrumAPI) msgTx(txid string,
+	cache map[string]*wire.MsgTx) (*wire.MsgTx, error) {
+
+	if tx, ok := cache[txid]; ok {
+		return tx, nil
+	}
+
+	var rawTxHex string
+	err := e.call("blockchain.transaction.get", &rawTxHex, txid, false)
+	var electrumErr *electrumError
+	if errors.As(err, &electrumErr) && electrumErr.isTxNotFound() {
+		return nil, fmt.Errorf("error fetching transaction %s: %w",
+			txid, ErrTxNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching transaction %s: %w",
+			txid, err)
+	}
+	rawTx, err := hex.DecodeString(rawTxHex)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding transaction %s: %w",
+			txid, err)
+	}
+
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return nil, fmt.Errorf("error parsing transaction %s: %w",
+			txid, err)
+	}
+
+	if cache != nil {
+		cache[txid] = tx
+	}
+
+	return tx, nil
+}
+
+// history returns the confirmed and unconfirmed transactions that touch the
+// given script.
+func (e *ElectrumAPI) history(pkScript []byte) ([]*electrumHistoryEntry,
+	error) {
+
+	var history []*electrumHistoryEntry
+	err := e.call(
+		"blockchain.scripthash.get_history", &history,
+		ElectrumScriptHash(pkScript),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching script history: %w", err)
+	}
+
+	return history, nil
+}
+
+// status returns the confirmation status for a transaction at the given
+// height as reported in a script's history. Unconfirmed transactions have a
+// height of zero or below.
+func (e *ElectrumAPI) status(height int) (*Status, error) {
+	if height <= 0 {
+		return &Status{}, nil
+	}
+
+	var headerHex string
+	err := e.call("blockchain.block.header", &headerHex, height)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching block header: %w", err)
+	}
+	headerBytes, err := hex.DecodeString(headerHex)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding block header: %w", err)
+	}
+
+	header := &wire.BlockHeader{}
+	err = header.Deserialize(bytes.NewReader(headerBytes))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing block header: %w", err)
+	}
+
+	return &Status{
+		Confirmed:   true,
+		BlockHeight: height,
+		BlockHash:   header.BlockHash().String(),
+	}, nil
+}
+
+// addrScript returns the output script of the given address.
+func (e *ElectrumAPI) addrScript(addr string) ([]byte, error) {
+	parsedAddr, err := btcutil.DecodeAddress(addr, e.ChainParams)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing address %s: %w", addr,
+			err)
+	}
+
+	return txscript.PayToAddrScript(parsedAddr)
+}
+
+// convertMsgTx converts a wire transaction into the esplora format.
+func convertMsgTx(msgTx *wire.MsgTx, params *chaincfg.Params) (*TX, error) {
+	tx := &TX{
+		TXID:     msgTx.TxHash().String(),
+		Version:  msgTx.Version,
+		Locktime: msgTx.LockTime,
+		Vin:      make([]*Vin, len(msgTx.TxIn)),
+		Vout:     make([]*Vout, len(msgTx.TxOut)),
+	}
+	for idx, txIn := range msgTx.TxIn {
+		tx.Vin[idx] = &Vin{
+			Tixid:    txIn.PreviousOutPoint.Hash.String(),
+			Vout:     int(txIn.PreviousOutPoint.Index),
+			Sequence: txIn.Sequence,
+		}
+	}
+	for idx, txOut := range msgTx.TxOut {
+		asm, err := txscript.DisasmString(txOut.PkScript)
+		if err != nil {
+			return nil, fmt.Errorf("invalid script: %w", err)
+		}
+
+		class, addrs, _, _ := txscript.ExtractPkScriptAddrs(
+			txOut.PkScript, params,
+		)
+		scriptType, ok := scriptClasses[class]
+		if !ok {
+			scriptType = "unknown"
+		}
+
+		var addr string
+		if len(addrs) == 1 && class != txscript.PubKeyTy {
+			addr = addrs[0].EncodeAddress()
+		}
+
+		tx.Vout[idx] = &Vout{
+			ScriptPubkey:     hex.EncodeToString(txOut.PkScript),
+			ScriptPubkeyAsm:  asm,
+			ScriptPubkeyType: scriptType,
+			ScriptPubkeyAddr: addr,
+			Value:            uint64(txOut.Value),
+		}
+	}
+
+	return tx, nil
+}
+
+// ElectrumScriptHash returns the script hash the Electrum protocol uses to
+// identify an output script, which is the reversed SHA256 hash of the script.
+func ElectrumScriptHash(pkScript []byte) string {
+	hash := sha256.Sum256(pkScript)
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+
+	return hex.EncodeToString(hash[:])
+}
+
+// spendingInput returns the index of the input of the transaction that spends
+// the given outpoint or -1 if none does.
+func spendingInput(tx *wire.MsgTx, txid string, index uint32) int {
+	for idx, txIn := range tx.TxIn {
+		prevOut := txIn.PreviousOutPoint
+		if prevOut.Hash.String() == txid && prevOut.Index == index {
+			return idx
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,241 @@
+package btc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeElectrum is a minimal Electrum server that knows a set of transactions
+// and the history of their output scripts.
+type fakeElectrum struct {
+	txs     map[string]*wire.MsgTx
+	history map[string][]*electrumHistoryEntry
+	headers map[int]*wire.BlockHeader
+
+	// txErrors are the errors returned when fetching the transaction
+	// with the given ID.
+	txErrors map[string]*electrumError
+}
+
+func (f *fakeElectrum) serve(t *testing.T, listener net.Listener) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	negotiated := false
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+
+		var req struct {
+			ID     uint64            `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(line, &req); err != nil {
+			t.Errorf("invalid request: %v", err)
+			return
+		}
+
+		var (
+			result    interface{}
+			resultErr *electrumError
+			str       string
+			num       int
+		)
+		switch req.Method {
+		case "server.version":
+			negotiated = true
+			result = []string{"fake", electrumProtocolVersion}
+
+		case "blockchain.transaction.get":
+			_ = json.Unmarshal(req.Params[0], &str)
+			if resultErr = f.txErrors[str]; resultErr != nil {
+				break
+			}
+			var buf bytes.Buffer
+			_ = f.txs[str].Serialize(&buf)
+			result = hex.EncodeToString(buf.Bytes())
+
+		case "blockchain.scripthash.get_history":
+			_ = json.Unmarshal(req.Params[0], &str)
+			result = f.history[str]
+			if result == nil {
+				result = []*electrumHistoryEntry{}
+			}
+
+		case "blockchain.block.header":
+			_ = json.Unmarshal(req.Params[0], &num)
+			var buf bytes.Buffer
+			_ = f.headers[num].Serialize(&buf)
+			result = hex.EncodeToString(buf.Bytes())
+
+		case "blockchain.headers.subscribe":
+			result = &electrumHeader{Height: 800_123}
+
+		case "blockchain.transaction.broadcast":
+			_ = json.Unmarshal(req.Params[0], &str)
+			result = "txid-of-" + str
+		}
+		if !negotiated {
+			t.Errorf("%s called before server.version", req.Method)
+		}
+
+		// Send an unrelated notification first to make sure it is
+		// skipped.
+		_, _ = conn.Write([]byte(`{"jsonrpc":"2.0","method":` +
+			`"blockchain.headers.subscribe","params":[]}` + "\n"))
+
+		resp, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+			"result":  result,
+			"error":   resultErr,
+		})
+		_, _ = conn.Write(append(resp, '\n'))
+	}
+}
+
+func TestElectrumAPI(t *testing.T) {
+	params := &chaincfg.MainNetParams
+	fundingScript := append([]byte{0x00, 0x20}, make([]byte, 32)...)
+	changeScript := append([]byte{0x00, 0x14}, make([]byte, 20)...)
+	changeScript[2] = 0x01
+
+	fundingTx := &wire.MsgTx{
+		Version: 2,
+		TxIn:    []*wire.TxIn{{Sequence: 0xffffffff}},
+		TxOut: []*wire.TxOut{{
+			Value:    1_000_000,
+			PkScript: fundingScript,
+		}, {
+			Value:    12_345,
+			PkScript: changeScript,
+		}},
+	}
+	fundingTxid := fundingTx.TxHash()
+	spendTx := &wire.MsgTx{
+		Version: 2,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: wire.OutPoint{Index: 7},
+		}, {
+			PreviousOutPoint: wire.OutPoint{
+				Hash:  fundingTxid,
+				Index: 0,
+			},
+		}},
+		TxOut: []*wire.TxOut{{Value: 990_000, PkScript: changeScript}},
+	}
+	spendTxid := spendTx.TxHash()
+	header := &wire.BlockHeader{Version: 4, Nonce: 1234}
+
+	server := &fakeElectrum{
+		txs: map[string]*wire.MsgTx{
+			fundingTxid.String(): fundingTx,
+			spendTxid.String():   spendTx,
+		},
+		history: map[string][]*electrumHistoryEntry{
+			ElectrumScriptHash(fundingScript): {
+				{TxHash: fundingTxid.String(), Height: 100},
+				{TxHash: spendTxid.String(), Height: 0},
+			},
+			ElectrumScriptHash(changeScript): {
+				{TxHash: fundingTxid.String(), Height: 100},
+				{TxHash: spendTxid.String(), Height: 0},
+			},
+		},
+		headers: map[int]*wire.BlockHeader{100: header},
+		txErrors: map[string]*electrumError{
+			"electrs": {
+				Code: 2,
+				Message: "daemon error: DaemonError({'code': " +
+					"-5, 'message': 'No such mempool or " +
+					"blockchain transaction. Use " +
+					"gettransaction for wallet " +
+					"transactions.'})",
+			},
+			"fulcrum": {Code: 2, Message: "missing transaction"},
+			"broken":  {Code: 1, Message: "internal error"},
+		},
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go server.serve(t, listener)
+
+	api := &ElectrumAPI{
+		Host:        listener.Addr().String(),
+		ChainParams: params,
+	}
+
+	tx, err := api.Transaction(fundingTxid.String())
+	require.NoError(t, err)
+	require.Equal(t, &Status{
+		Confirmed:   true,
+		BlockHeight: 100,
+		BlockHash:   header.BlockHash().String(),
+	}, tx.Status)
+	require.Len(t, tx.Vout, 2)
+	require.Equal(t, "v0_p2wsh", tx.Vout[0].ScriptPubkeyType)
+	require.Equal(t, uint64(1_000_000), tx.Vout[0].Value)
+	require.Equal(t, &Outspend{
+		Spent:  true,
+		Txid:   spendTxid.String(),
+		Vin:    1,
+		Status: &Status{},
+	}, tx.Vout[0].Outspend)
+
+	// The change output is in the history of the spending transaction,
+	// but isn't spent by it.
+	require.Equal(t, "v0_p2wpkh", tx.Vout[1].ScriptPubkeyType)
+	require.False(t, tx.Vout[1].Outspend.Spent)
+
+	// The wire transaction can be rebuilt, as long as the ID matches.
+	msgTx, err := tx.MsgTx()
+	require.NoError(t, err)
+	require.Equal(t, fundingTx, msgTx)
+	tx.Locktime = 1
+	_, err = tx.MsgTx()
+	require.ErrorContains(t, err, "instead of "+fundingTxid.String())
+	tx.Locktime = 0
+
+	addr, err := api.Address(fundingTxid.String() + ":1")
+	require.NoError(t, err)
+	require.Equal(t, tx.Vout[1].ScriptPubkeyAddr, addr)
+
+	foundTx, idx, err := api.Outpoint(addr)
+	require.NoError(t, err)
+	require.Equal(t, fundingTxid.String(), foundTx.TXID)
+	require.Equal(t, 1, idx)
+
+	height, err := api.BlockHeight()
+	require.NoError(t, err)
+	require.EqualValues(t, 800_123, height)
+
+	txid, err := api.PublishTx("0200")
+	require.NoError(t, err)
+	require.Equal(t, "txid-of-0200", txid)
+
+	// Unknown transactions are reported as such, other errors are passed
+	// on as they are.
+	for _, unknownTxid := range []string{"electrs", "fulcrum"} {
+		_, err = api.Transaction(unknownTxid)
+		require.ErrorIs(t, err, ErrTxNotFound)
+	}
+	_, err = api.Transaction("broken")
+	require.ErrorContains(t, err, "electrum error 1: internal error")
+	require.NotErrorIs(t, err, ErrTxNotFound)
+}
@@ -2,26 +2,151 @@ package btc
 
 import (
 	"bytes"
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultExplorerRetryBackoff is the time we wait before retrying a
+	// failed request for the first time. The time is doubled after each
+	// round of retries.
+	defaultExplorerRetryBackoff = time.Second
 )
 
 var (
 	ErrTxNotFound = errors.New("transaction not found")
 )
 
+// ChainAPI is the interface a chain backend must implement to be used for
+// looking up the on-chain state of channels.
+type ChainAPI interface {
+	// Transaction returns the transaction with the given ID, including
+	// the spend information of each of its outputs.
+	Transaction(txid string) (*TX, error)
+
+	// BlockHeight returns the height of the current best block.
+	BlockHeight() (uint32, error)
+}
+
+// SweepAPI is the interface a chain backend must implement to be used by the
+// commands that sweep funds and publish transactions.
+type SweepAPI interface {
+	ChainAPI
+
+	// Outpoint returns a transaction that pays to the given address and
+	// the index of the output that does so.
+	Outpoint(addr string) (*TX, int, error)
+
+	// Address returns the address of the given outpoint in the format
+	// <txid>:<index>.
+	Address(outpoint string) (string, error)
+
+	// PublishTx publishes the given hex encoded raw transaction and
+	// returns the response of the backend.
+	PublishTx(rawTxHex string) (string, error)
+}
+
+// BatchChainAPI is implemented by chain backends that can look up many
+// transactions with a few bulk requests.
+type BatchChainAPI interface {
+	ChainAPI
+
+	// Transactions returns the transactions with the given IDs in the
+	// same order, including the spend information of each of their
+	// outputs. Transactions that don't exist are returned as nil.
+	Transactions(txids []string) ([]*TX, error)
+}
+
+// PackageAPI is a chain backend that can publish a package of dependent
+// transactions at once, so a child can pay the fees of a parent that doesn't
+// meet the minimum relay fee on its own.
+type PackageAPI interface {
+	// SubmitPackage publishes the given hex encoded raw transactions as
+	// a single package. Parents must come before their children.
+	SubmitPackage(rawTxHexes []string) (string, error)
+}
+
+// BlockAPI is a chain backend that can list the transactions of a block.
+type BlockAPI interface {
+	// BlockTxIDs returns the IDs of all transactions in the block with
+	// the given hash, in the order they appear in the block.
+	BlockTxIDs(blockHash string) ([]string, error)
+}
+
 type ExplorerAPI struct {
 	BaseURL string
+
+	// FailoverURLs are the URLs of additional esplora compatible APIs
+	// that are used if a request to the BaseURL fails.
+	FailoverURLs []string
+
+	// MaxRetries is the number of times all URLs are tried again if a
+	// request fails because the API is unreachable, rate limits us or
+	// reports an internal error. A value of zero disables retries.
+	MaxRetries int
+
+	// RetryBackoff is the time we wait before the first retry, it is
+	// doubled after each retry. Defaults to one second if not set.
+	RetryBackoff time.Duration
+
+	// RequestsPerSecond limits the number of requests sent to the API.
+	// A value of zero means no limit is applied.
+	RequestsPerSecond float64
+
+	// Proxy is the URL of a SOCKS5 proxy all requests are sent through,
+	// for example socks5://127.0.0.1:9050 for Tor. Host names are resolved
+	// by the proxy, so .onion URLs can be used as the BaseURL.
+	Proxy string
+
+	// User and Password are sent as basic authentication with every
+	// request to the BaseURL if the user is set. They are never sent to
+	// the FailoverURLs, which might be run by someone else.
+	User     string
+	Password string
+
+	// Headers are additional HTTP headers in the format "Name: value"
+	// that are sent with every request to the BaseURL, for example an API
+	// key. Like the basic authentication, they are not sent to the
+	// FailoverURLs.
+	Headers []string
+
+	// Cache is an optional on-disk cache the fetched transactions are
+	// stored in and looked up from.
+	Cache *TxCache
+
+	limiterOnce sync.Once
+	limiter     *rate.Limiter
+
+	clientOnce sync.Once
+	client     *http.Client
+	header     http.Header
+	clientErr  error
 }
 
+// Enforce ExplorerAPI implements the SweepAPI and BlockAPI interfaces.
+var _ SweepAPI = (*ExplorerAPI)(nil)
+var _ BlockAPI = (*ExplorerAPI)(nil)
+
 type TX struct {
-	TXID string  `json:"txid"`
-	Vin  []*Vin  `json:"vin"`
-	Vout []*Vout `json:"vout"`
+	TXID     string  `json:"txid"`
+	Version  int32   `json:"version"`
+	Locktime uint32  `json:"locktime"`
+	Vin      []*Vin  `json:"vin"`
+	Vout     []*Vout `json:"vout"`
+	Status   *Status `json:"status"`
 }
 
 type Vin struct {
@@ -67,18 +192,98 @@ type AddressStats struct {
 	MempoolStats *Stats `json:"mempool_stats"`
 }
 
+// UTXO is an unspent output of an address.
+type UTXO struct {
+	TXID   string  `json:"txid"`
+	Vout   uint32  `json:"vout"`
+	Value  uint64  `json:"value"`
+	Status *Status `json:"status"`
+}
+
+// MsgTx rebuilds the wire transaction without its witness data. An error is
+// returned if the rebuilt transaction doesn't have the expected ID, for example
+// because the transaction was cached before the version and lock time were
+// known.
+func (t *TX) MsgTx() (*wire.MsgTx, error) {
+	msgTx := wire.NewMsgTx(t.Version)
+	msgTx.LockTime = t.Locktime
+	for _, vin := range t.Vin {
+		hash, err := chainhash.NewHashFromStr(vin.Tixid)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input txid: %w", err)
+		}
+		msgTx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{
+				Hash:  *hash,
+				Index: uint32(vin.Vout),
+			},
+			Sequence: vin.Sequence,
+		})
+	}
+	for _, vout := range t.Vout {
+		pkScript, err := hex.DecodeString(vout.ScriptPubkey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid script: %w", err)
+		}
+		msgTx.AddTxOut(&wire.TxOut{
+			Value:    int64(vout.Value),
+			PkScript: pkScript,
+		})
+	}
+
+	if msgTx.TxHash().String() != t.TXID {
+		return nil, fmt.Errorf("rebuilt transaction has ID %v instead "+
+			"of %s", msgTx.TxHash(), t.TXID)
+	}
+
+	return msgTx, nil
+}
+
 func (a *ExplorerAPI) Transaction(txid string) (*TX, error) {
+	if a.Cache == nil {
+		return a.fetchTransaction(txid)
+	}
+
+	if tx, ok := a.Cache.get(txid); ok {
+		return tx, nil
+	}
+
+	tx, err := a.fetchTransaction(txid)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.Cache.put(tx); err != nil {
+		return nil, fmt.Errorf("error writing to cache: %w", err)
+	}
+
+	return tx, nil
+}
+
+// fetchTransaction fetches the transaction with the given ID and the spend
+// information of its outputs from the API.
+func (a *ExplorerAPI) fetchTransaction(txid string) (*TX, error) {
 	tx := &TX{}
-	err := fetchJSON(fmt.Sprintf("%s/tx/%s", a.BaseURL, txid), tx)
+	err := a.fetchJSON(fmt.Sprintf("/tx/%s", txid), tx)
 	if err != nil {
 		return nil, err
 	}
+
+	// Most APIs return the outspends of all outputs in a single request.
+	var outspends []*Outspend
+	err = a.fetchJSON(fmt.Sprintf("/tx/%s/outspends", txid), &outspends)
+	if err == nil && len(outspends) == len(tx.Vout) {
+		for idx, vout := range tx.Vout {
+			vout.Outspend = outspends[idx]
+		}
+
+		return tx, nil
+	}
+
 	for idx, vout := range tx.Vout {
-		url := fmt.Sprintf(
-			"%s/tx/%s/outspend/%d", a.BaseURL, txid, idx,
-		)
 		outspend := Outspend{}
-		err := fetchJSON(url, &outspend)
+		err := a.fetchJSON(
+			fmt.Sprintf("/tx/%s/outspend/%d", txid, idx), &outspend,
+		)
 		if err != nil {
 			return nil, err
 		}
@@ -87,11 +292,31 @@ func (a *ExplorerAPI) Transaction(txid string) (*TX, error) {
 	return tx, nil
 }
 
+func (a *ExplorerAPI) BlockHeight() (uint32, error) {
+	var height uint32
+	err := a.fetchJSON("/blocks/tip/height", &height)
+	if err != nil {
+		return 0, err
+	}
+
+	return height, nil
+}
+
+// BlockTxIDs returns the IDs of all transactions in the block with the given
+// hash, in the order they appear in the block.
+func (a *ExplorerAPI) BlockTxIDs(blockHash string) ([]string, error) {
+	var txids []string
+	err := a.fetchJSON(fmt.Sprintf("/block/%s/txids", blockHash), &txids)
+	if err != nil {
+		return nil, err
+	}
+
+	return txids, nil
+}
+
 func (a *ExplorerAPI) Outpoint(addr string) (*TX, int, error) {
 	var txs []*TX
-	err := fetchJSON(
-		fmt.Sprintf("%s/address/%s/txs", a.BaseURL, addr), &txs,
-	)
+	err := a.fetchJSON(fmt.Sprintf("/address/%s/txs", addr), &txs)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -108,9 +333,7 @@ func (a *ExplorerAPI) Outpoint(addr string) (*TX, int, error) {
 
 func (a *ExplorerAPI) Spends(addr string) ([]*TX, error) {
 	var txs []*TX
-	err := fetchJSON(
-		fmt.Sprintf("%s/address/%s/txs", a.BaseURL, addr), &txs,
-	)
+	err := a.fetchJSON(fmt.Sprintf("/address/%s/txs", addr), &txs)
 	if err != nil {
 		return nil, err
 	}
@@ -135,7 +358,7 @@ func (a *ExplorerAPI) Unspent(addr string) ([]*Vout, error) {
 		txs     []*TX
 		err     error
 	)
-	err = fetchJSON(fmt.Sprintf("%s/address/%s", a.BaseURL, addr), &stats)
+	err = a.fetchJSON(fmt.Sprintf("/address/%s", addr), &stats)
 	if err != nil {
 		return nil, err
 	}
@@ -149,7 +372,7 @@ func (a *ExplorerAPI) Unspent(addr string) ([]*Vout, error) {
 		return nil, nil
 	}
 
-	err = fetchJSON(fmt.Sprintf("%s/address/%s/txs", a.BaseURL, addr), &txs)
+	err = a.fetchJSON(fmt.Sprintf("/address/%s/txs", addr), &txs)
 	if err != nil {
 		return nil, err
 	}
@@ -168,6 +391,56 @@ func (a *ExplorerAPI) Unspent(addr string) ([]*Vout, error) {
 	return outputs, nil
 }
 
+// AddressUsed returns true if the given address has any confirmed or
+// unconfirmed transactions.
+func (a *ExplorerAPI) AddressUsed(addr string) (bool, error) {
+	stats := &AddressStats{}
+	err := a.fetchJSON(fmt.Sprintf("/address/%s", addr), &stats)
+	if err != nil {
+		return false, err
+	}
+
+	return stats.ChainStats.TXCount+stats.MempoolStats.TXCount > 0, nil
+}
+
+// UTXOs returns the confirmed and unconfirmed outputs of the given address
+// that aren't spent yet.
+func (a *ExplorerAPI) UTXOs(addr string) ([]*UTXO, error) {
+	var utxos []*UTXO
+	err := a.fetchJSON(fmt.Sprintf("/address/%s/utxo", addr), &utxos)
+	if err != nil {
+		return nil, err
+	}
+
+	return utxos, nil
+}
+
+// RawTransaction returns the full transaction with the given ID, including
+// its signature scripts and witness data.
+func (a *ExplorerAPI) RawTransaction(txid string) (*wire.MsgTx, error) {
+	body, err := a.request(
+		http.MethodGet, fmt.Sprintf("/tx/%s/hex", txid), "",
+	)
+	if err != nil {
+		return nil, err
+	}
+	rawTx, err := hex.DecodeString(strings.TrimSpace(string(body)))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding transaction: %w", err)
+	}
+
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return nil, fmt.Errorf("error parsing transaction: %w", err)
+	}
+	if tx.TxHash().String() != txid {
+		return nil, fmt.Errorf("API returned transaction %v instead "+
+			"of %s", tx.TxHash(), txid)
+	}
+
+	return tx, nil
+}
+
 func (a *ExplorerAPI) Address(outpoint string) (string, error) {
 	parts := strings.Split(outpoint, ":")
 
@@ -193,37 +466,197 @@ func (a *ExplorerAPI) Address(outpoint string) (string, error) {
 }
 
 func (a *ExplorerAPI) PublishTx(rawTxHex string) (string, error) {
-	url := fmt.Sprintf("%s/tx", a.BaseURL)
-	resp, err := http.Post(url, "text/plain", strings.NewReader(rawTxHex))
+	body, err := a.request(http.MethodPost, "/tx", rawTxHex)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-	body := new(bytes.Buffer)
-	_, err = body.ReadFrom(resp.Body)
-	if err != nil {
-		return "", err
+	return string(body), nil
+}
+
+// waitRateLimit blocks until the configured rate limit allows another request
+// to be sent to the API.
+func (a *ExplorerAPI) waitRateLimit() {
+	a.limiterOnce.Do(func() {
+		if a.RequestsPerSecond > 0 {
+			a.limiter = rate.NewLimiter(
+				rate.Limit(a.RequestsPerSecond), 1,
+			)
+		}
+	})
+
+	if a.limiter != nil {
+		_ = a.limiter.Wait(context.Background())
 	}
-	return body.String(), nil
 }
 
-func fetchJSON(url string, target interface{}) error {
-	resp, err := http.Get(url)
+// httpClient returns the HTTP client that sends the requests through the
+// configured proxy, if any.
+func (a *ExplorerAPI) httpClient() (*http.Client, error) {
+	a.clientOnce.Do(func() {
+		a.header, a.clientErr = parseHeaders(a.Headers)
+		if a.clientErr != nil {
+			return
+		}
+
+		a.client, a.clientErr = newHTTPClient(a.BaseURL, a.Proxy)
+	})
+
+	return a.client, a.clientErr
+}
+
+// parseHeaders parses HTTP headers in the format "Name: value".
+func parseHeaders(headers []string) (http.Header, error) {
+	header := make(http.Header, len(headers))
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header '%s', must be "+
+				"in the format 'Name: value'", h)
+		}
+
+		header.Add(name, strings.TrimSpace(value))
+	}
+
+	return header, nil
+}
+
+// newHTTPClient creates an HTTP client for the given base URL that uses the
+// given SOCKS5 proxy. Without a proxy the default client is returned, unless
+// the base URL is a Tor hidden service that can't be reached without one.
+func newHTTPClient(baseURL, proxy string) (*http.Client, error) {
+	parsedBase, err := url.Parse(baseURL)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("invalid API URL %s: %w", baseURL, err)
 	}
-	defer resp.Body.Close()
+	isOnion := strings.HasSuffix(parsedBase.Hostname(), ".onion")
 
-	body := new(bytes.Buffer)
-	_, err = body.ReadFrom(resp.Body)
+	switch {
+	case proxy == "" && isOnion:
+		return nil, fmt.Errorf("the API URL %s is an onion address and "+
+			"can only be reached through a Tor proxy", baseURL)
+
+	case proxy == "":
+		return http.DefaultClient, nil
+	}
+
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %s: %w", proxy, err)
+	}
+	if proxyURL.Scheme != "socks5" && proxyURL.Scheme != "socks5h" {
+		return nil, fmt.Errorf("invalid proxy URL %s: only socks5:// "+
+			"proxies are supported", proxy)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func (a *ExplorerAPI) fetchJSON(path string, target interface{}) error {
+	body, err := a.request(http.MethodGet, path, "")
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(body.Bytes(), target)
+
+	err = json.Unmarshal(body, target)
 	if err != nil {
-		if body.String() == "Transaction not found" {
+		if string(body) == "Transaction not found" {
 			return ErrTxNotFound
 		}
 	}
 	return err
 }
+
+// request sends a request for the given path to the API and returns the
+// response body. If the API can't be reached, rate limits us or reports an
+// internal error, the request is sent to the next configured URL. Once all URLs
+// failed, they are tried again with an exponential backoff.
+func (a *ExplorerAPI) request(method, path, reqBody string) ([]byte, error) {
+	client, err := a.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	baseURLs := append([]string{a.BaseURL}, a.FailoverURLs...)
+	backoff := a.RetryBackoff
+	if backoff == 0 {
+		backoff = defaultExplorerRetryBackoff
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= a.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		for idx, baseURL := range baseURLs {
+			a.waitRateLimit()
+
+			body, retry, err := a.doRequest(
+				client, method, baseURL+path, reqBody, idx == 0,
+			)
+			if err == nil || !retry {
+				return body, err
+			}
+			lastErr = err
+		}
+	}
+
+	return nil, fmt.Errorf("request to API failed after %d retries: %w",
+		a.MaxRetries, lastErr)
+}
+
+// doRequest sends a single request to the given URL and returns the response
+// body. The configured headers and basic authentication are only added if
+// withCredentials is set. The returned boolean indicates whether the request
+// failed for a reason that might go away when retrying.
+func (a *ExplorerAPI) doRequest(client *http.Client, method, reqURL,
+	reqBody string, withCredentials bool) ([]byte, bool, error) {
+
+	req, err := http.NewRequest(method, reqURL, strings.NewReader(reqBody))
+	if err != nil {
+		return nil, false, err
+	}
+	if withCredentials {
+		for name, values := range a.header {
+			req.Header[name] = values
+		}
+		if a.User != "" {
+			req.SetBasicAuth(a.User, a.Password)
+		}
+	}
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	body := new(bytes.Buffer)
+	_, err = body.ReadFrom(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized ||
+		resp.StatusCode == http.StatusForbidden {
+
+		return nil, false, fmt.Errorf("authentication at %s failed "+
+			"with status %d", reqURL, resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= http.StatusInternalServerError {
+
+		return nil, true, fmt.Errorf("%s returned status %d: %s",
+			reqURL, resp.StatusCode, body.String())
+	}
+
+	return body.Bytes(), false, nil
+}
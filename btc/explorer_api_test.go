@@ -0,0 +1,319 @@
+package btc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSocks5Proxy is a minimal SOCKS5 proxy that accepts unauthenticated
+// CONNECT requests, records the requested destination and forwards all
+// connections to the given target, regardless of the destination.
+func fakeSocks5Proxy(t *testing.T, target string) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	destinations := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				dest, err := socks5Handshake(conn)
+				if err != nil {
+					return
+				}
+				destinations <- dest
+
+				targetConn, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer targetConn.Close()
+
+				go func() { _, _ = io.Copy(targetConn, conn) }()
+				_, _ = io.Copy(conn, targetConn)
+			}()
+		}
+	}()
+
+	return "socks5://" + listener.Addr().String(), destinations
+}
+
+// socks5Handshake reads the method selection and the CONNECT request of a
+// SOCKS5 client and returns the requested destination.
+func socks5Handshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[3] != 3 {
+		return "", fmt.Errorf("expected domain name, got type %d",
+			request[3])
+	}
+	hostLen := make([]byte, 1)
+	if _, err := io.ReadFull(conn, hostLen); err != nil {
+		return "", err
+	}
+	hostAndPort := make([]byte, int(hostLen[0])+2)
+	if _, err := io.ReadFull(conn, hostAndPort); err != nil {
+		return "", err
+	}
+	host := string(hostAndPort[:hostLen[0]])
+	port := binary.BigEndian.Uint16(hostAndPort[hostLen[0]:])
+
+	_, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return fmt.Sprintf("%s:%d", host, port), err
+}
+
+func TestExplorerAPIProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("123456"))
+		},
+	))
+	defer server.Close()
+
+	// An onion address can't be reached without a proxy.
+	api := &ExplorerAPI{BaseURL: "http://explorer.onion/api"}
+	_, err := api.BlockHeight()
+	require.ErrorContains(t, err, "Tor proxy")
+
+	// Only SOCKS5 proxies are supported.
+	api = &ExplorerAPI{
+		BaseURL: "http://explorer.onion/api",
+		Proxy:   "http://127.0.0.1:8080",
+	}
+	_, err = api.BlockHeight()
+	require.ErrorContains(t, err, "only socks5://")
+
+	// The host name must be resolved by the proxy.
+	proxyURL, destinations := fakeSocks5Proxy(
+		t, server.Listener.Addr().String(),
+	)
+	api = &ExplorerAPI{
+		BaseURL: "http://explorer.onion/api",
+		Proxy:   proxyURL,
+	}
+	height, err := api.BlockHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint32(123456), height)
+	require.Equal(t, "explorer.onion:80", <-destinations)
+}
+
+func TestExplorerAPIFailover(t *testing.T) {
+	// The first API rate limits all requests.
+	var limitedRequests int32
+	limited := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&limitedRequests, 1)
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+	))
+	defer limited.Close()
+
+	// The second API fails the first request and then recovers.
+	var failoverRequests int32
+	failover := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&failoverRequests, 1) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+
+			if r.URL.Path == "/tx/unknown" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte("Transaction not found"))
+				return
+			}
+			_, _ = w.Write([]byte("123456"))
+		},
+	))
+	defer failover.Close()
+
+	api := &ExplorerAPI{
+		BaseURL:      limited.URL,
+		FailoverURLs: []string{failover.URL},
+		MaxRetries:   1,
+		RetryBackoff: time.Millisecond,
+	}
+	height, err := api.BlockHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint32(123456), height)
+	require.EqualValues(t, 2, atomic.LoadInt32(&limitedRequests))
+	require.EqualValues(t, 2, atomic.LoadInt32(&failoverRequests))
+
+	// A permanent error is returned immediately without trying the next
+	// URL.
+	api.BaseURL = failover.URL
+	api.FailoverURLs = []string{limited.URL}
+	_, err = api.Transaction("unknown")
+	require.ErrorIs(t, err, ErrTxNotFound)
+	require.EqualValues(t, 2, atomic.LoadInt32(&limitedRequests))
+
+	// Once all retries are used up, the last error is returned.
+	api = &ExplorerAPI{
+		BaseURL:      limited.URL,
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	}
+	_, err = api.BlockHeight()
+	require.ErrorContains(t, err, "failed after 2 retries")
+	require.EqualValues(t, 5, atomic.LoadInt32(&limitedRequests))
+}
+
+func TestExplorerAPIAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			if !ok || user != "alice" || pass != "secret" ||
+				r.Header.Get("X-Api-Key") != "key123" {
+
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte("123456"))
+		},
+	))
+	defer server.Close()
+
+	api := &ExplorerAPI{
+		BaseURL:  server.URL,
+		User:     "alice",
+		Password: "secret",
+		Headers:  []string{"X-Api-Key: key123"},
+	}
+	height, err := api.BlockHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint32(123456), height)
+
+	// Failed authentication is not retried.
+	api = &ExplorerAPI{
+		BaseURL:    server.URL,
+		User:       "alice",
+		Password:   "wrong",
+		Headers:    []string{"X-Api-Key: key123"},
+		MaxRetries: 3,
+	}
+	_, err = api.BlockHeight()
+	require.ErrorContains(t, err, "authentication at")
+	require.ErrorContains(t, err, "status 401")
+
+	api = &ExplorerAPI{
+		BaseURL: server.URL,
+		Headers: []string{"no-colon"},
+	}
+	_, err = api.BlockHeight()
+	require.ErrorContains(t, err, "invalid header")
+}
+
+func TestExplorerAPIAuthFailover(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	))
+	defer primary.Close()
+
+	var (
+		mu            sync.Mutex
+		failoverAuths []string
+	)
+	failover := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			failoverAuths = append(
+				failoverAuths, r.Header.Get("Authorization"),
+				r.Header.Get("X-Api-Key"),
+			)
+			mu.Unlock()
+
+			_, _ = w.Write([]byte("123456"))
+		},
+	))
+	defer failover.Close()
+
+	// The credentials of the private instance must not be leaked to the
+	// failover API.
+	api := &ExplorerAPI{
+		BaseURL:      primary.URL,
+		FailoverURLs: []string{failover.URL},
+		User:         "alice",
+		Password:     "secret",
+		Headers:      []string{"X-Api-Key: key123"},
+	}
+	height, err := api.BlockHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint32(123456), height)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"", ""}, failoverAuths)
+}
+
+func TestExplorerAPIRateLimit(t *testing.T) {
+	var numRequests int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&numRequests, 1)
+			_, _ = w.Write([]byte("123456"))
+		},
+	))
+	defer server.Close()
+
+	// With 20 requests per second and a burst of one, five requests take
+	// at least 200 milliseconds, no matter how many are sent in parallel.
+	api := &ExplorerAPI{
+		BaseURL:           server.URL,
+		RequestsPerSecond: 20,
+	}
+
+	var wg sync.WaitGroup
+	errChan := make(chan error, 5)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := api.BlockHeight()
+			errChan <- err
+		}()
+	}
+	wg.Wait()
+	close(errChan)
+
+	for err := range errChan {
+		require.NoError(t, err)
+	}
+
+	require.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+	require.EqualValues(t, 5, atomic.LoadInt32(&numRequests))
+}
@@ -0,0 +1,51 @@
+package btc
+
+// FeeEstimator is implemented by chain backends that can recommend a fee rate
+// for a transaction to confirm in a reasonable time.
+type FeeEstimator interface {
+	// EstimateFeeRate returns the recommended fee rate in sat/vByte.
+	EstimateFeeRate() (uint32, error)
+}
+
+// RecommendedFees are the fee rates in sat/vByte recommended by mempool.space
+// for different confirmation targets.
+type RecommendedFees struct {
+	FastestFee  uint32 `json:"fastestFee"`
+	HalfHourFee uint32 `json:"halfHourFee"`
+	HourFee     uint32 `json:"hourFee"`
+	EconomyFee  uint32 `json:"economyFee"`
+	MinimumFee  uint32 `json:"minimumFee"`
+}
+
+// MempoolSpaceAPI is a SweepAPI that uses the API of mempool.space or a self
+// hosted instance of it. It is a superset of the esplora API that can also
+// recommend fee rates.
+type MempoolSpaceAPI struct {
+	*ExplorerAPI
+}
+
+// Enforce MempoolSpaceAPI implements the SweepAPI and FeeEstimator interfaces.
+var _ SweepAPI = (*MempoolSpaceAPI)(nil)
+var _ FeeEstimator = (*MempoolSpaceAPI)(nil)
+
+// RecommendedFees returns the fee rates currently recommended by the API.
+func (m *MempoolSpaceAPI) RecommendedFees() (*RecommendedFees, error) {
+	fees := &RecommendedFees{}
+	err := m.fetchJSON("/v1/fees/recommended", fees)
+	if err != nil {
+		return nil, err
+	}
+
+	return fees, nil
+}
+
+// EstimateFeeRate returns the fee rate recommended for a confirmation within
+// half an hour.
+func (m *MempoolSpaceAPI) EstimateFeeRate() (uint32, error) {
+	fees, err := m.RecommendedFees()
+	if err != nil {
+		return 0, err
+	}
+
+	return fees.HalfHourFee, nil
+}
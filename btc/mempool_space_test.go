@@ -0,0 +1,57 @@
+package btc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMempoolSpaceAPI(t *testing.T) {
+	responses := map[string]string{
+		"/api/tx/abcd": `{"txid":"abcd","vout":[` +
+			`{"scriptpubkey_address":"addr0","value":1000},` +
+			`{"scriptpubkey_address":"addr1","value":2000}],` +
+			`"status":{"confirmed":true,"block_height":100}}`,
+		"/api/tx/abcd/outspends": `[{"spent":false},` +
+			`{"spent":true,"txid":"ef01","vin":3,` +
+			`"status":{"confirmed":true,"block_height":105}}]`,
+		"/api/v1/fees/recommended": `{"fastestFee":25,` +
+			`"halfHourFee":20,"hourFee":15,"economyFee":8,` +
+			`"minimumFee":4}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			response, ok := responses[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(response))
+		},
+	))
+	defer server.Close()
+
+	api := &MempoolSpaceAPI{
+		ExplorerAPI: &ExplorerAPI{BaseURL: server.URL + "/api"},
+	}
+
+	// The outspends of all outputs are fetched in a single request.
+	tx, err := api.Transaction("abcd")
+	require.NoError(t, err)
+	require.Len(t, tx.Vout, 2)
+	require.False(t, tx.Vout[0].Outspend.Spent)
+	require.True(t, tx.Vout[1].Outspend.Spent)
+	require.Equal(t, "ef01", tx.Vout[1].Outspend.Txid)
+	require.Equal(t, 3, tx.Vout[1].Outspend.Vin)
+
+	fees, err := api.RecommendedFees()
+	require.NoError(t, err)
+	require.Equal(t, uint32(25), fees.FastestFee)
+	require.Equal(t, uint32(4), fees.MinimumFee)
+
+	feeRate, err := api.EstimateFeeRate()
+	require.NoError(t, err)
+	require.Equal(t, uint32(20), feeRate)
+}
@@ -0,0 +1,209 @@
+package btc
+
+import (
+	"fmt"
+
+	"github.com/guggero/chantools/dataformat"
+)
+
+const (
+	// MonitorEventSpendable is sent when an output that is potentially
+	// ours can be swept because the closing transaction confirmed.
+	MonitorEventSpendable = "spendable"
+
+	// MonitorEventMatured is sent when the CSV delay of our to_local
+	// output expired.
+	MonitorEventMatured = "matured"
+
+	// MonitorEventSpent is sent when an output was spent.
+	MonitorEventSpent = "spent"
+)
+
+// MonitorEvent is a change in the state of a monitored closed channel output.
+type MonitorEvent struct {
+	Type         string `json:"type"`
+	ChannelPoint string `json:"channel_point"`
+	Outpoint     string `json:"outpoint"`
+	Value        uint64 `json:"value"`
+	Height       uint32 `json:"height"`
+	SpendingTXID string `json:"spending_txid,omitempty"`
+}
+
+// String returns a human readable description of the event.
+func (e *MonitorEvent) String() string {
+	desc := fmt.Sprintf("Output %s (%d sats) of channel %s is %s at "+
+		"height %d", e.Outpoint, e.Value, e.ChannelPoint, e.Type,
+		e.Height)
+	if e.SpendingTXID != "" {
+		desc += fmt.Sprintf(" by %s", e.SpendingTXID)
+	}
+
+	return desc
+}
+
+// monitoredOutput is an unspent output of a closing transaction.
+type monitoredOutput struct {
+	index int
+	value uint64
+
+	// unlockHeight is the height at which the output can be swept by us,
+	// or zero if it's not ours or we don't know.
+	unlockHeight uint32
+	unlockEvent  string
+	unlocked     bool
+}
+
+// monitoredChannel is a closed channel with unspent outputs.
+type monitoredChannel struct {
+	entry       *dataformat.SummaryEntry
+	initialized bool
+	outputs     []*monitoredOutput
+}
+
+// OutputMonitor keeps track of the unspent outputs of closed channels and
+// reports changes in their state.
+type OutputMonitor struct {
+	api      ChainAPI
+	channels []*monitoredChannel
+}
+
+// NewOutputMonitor creates a monitor for all closed channels of the given
+// summary entries that still have unspent outputs.
+func NewOutputMonitor(api ChainAPI,
+	entries []*dataformat.SummaryEntry) *OutputMonitor {
+
+	m := &OutputMonitor{api: api}
+	for _, entry := range entries {
+		if entry.ClosingTX == nil || entry.ClosingTX.AllOutsSpent {
+			continue
+		}
+
+		m.channels = append(m.channels, &monitoredChannel{
+			entry: entry,
+		})
+	}
+
+	return m
+}
+
+// NumChannels returns the number of channels that still have unspent outputs
+// to monitor.
+func (m *OutputMonitor) NumChannels() int {
+	return len(m.channels)
+}
+
+// Check looks up the current state of all monitored outputs and returns the
+// events for all outputs that changed their state since the last check.
+// Outputs that were spent are not monitored anymore.
+func (m *OutputMonitor) Check() ([]*MonitorEvent, error) {
+	height, err := m.api.BlockHeight()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching block height: %w", err)
+	}
+
+	var (
+		events    []*MonitorEvent
+		remaining = make([]*monitoredChannel, 0, len(m.channels))
+	)
+	for _, channel := range m.channels {
+		closingTXID := channel.entry.ClosingTX.TXID
+		tx, err := m.api.Transaction(closingTXID)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching closing "+
+				"transaction %s: %w", closingTXID, err)
+		}
+
+		if !channel.initialized {
+			channel.outputs = closingOutputs(channel.entry, tx)
+			channel.initialized = true
+		}
+
+		unspent := make([]*monitoredOutput, 0, len(channel.outputs))
+		for _, output := range channel.outputs {
+			event := &MonitorEvent{
+				ChannelPoint: channel.entry.ChannelPoint,
+				Outpoint: fmt.Sprintf("%s:%d", closingTXID,
+					output.index),
+				Value:  output.value,
+				Height: height,
+			}
+
+			outspend := tx.Vout[output.index].Outspend
+			switch {
+			case outspend.Spent:
+				event.Type = MonitorEventSpent
+				event.SpendingTXID = outspend.Txid
+				events = append(events, event)
+
+				continue
+
+			case !output.unlocked && output.unlockHeight > 0 &&
+				height >= output.unlockHeight:
+
+				event.Type = output.unlockEvent
+				output.unlocked = true
+				events = append(events, event)
+			}
+
+			unspent = append(unspent, output)
+		}
+		channel.outputs = unspent
+
+		if len(channel.outputs) > 0 {
+			remaining = append(remaining, channel)
+		}
+	}
+	m.channels = remaining
+
+	return events, nil
+}
+
+// closingOutputs returns all unspent outputs of a closing transaction except
+// for anchors. If an output is potentially ours, the height at which it can be
+// swept is determined as well.
+func closingOutputs(entry *dataformat.SummaryEntry,
+	tx *TX) []*monitoredOutput {
+
+	var (
+		outputs    []*monitoredOutput
+		confHeight = entry.ClosingTX.ConfHeight
+	)
+	for idx, vout := range tx.Vout {
+		if vout.Outspend.Spent || vout.Value == anchorOutputValue {
+			continue
+		}
+
+		output := &monitoredOutput{
+			index: idx,
+			value: vout.Value,
+		}
+		if confHeight > 0 && entry.LocalBalance > 0 &&
+			vout.Value == entry.LocalBalance {
+
+			switch {
+			// Our to_local output on our own commitment is locked
+			// for the CSV delay.
+			case entry.ForceClose != nil &&
+				entry.ForceClose.TXID == tx.TXID:
+
+				output.unlockHeight = confHeight +
+					uint32(entry.ForceClose.CSVDelay)
+				output.unlockEvent = MonitorEventMatured
+
+			// The to_remote output of anchor channels is locked for
+			// one block.
+			case vout.ScriptPubkeyType == "v0_p2wsh":
+				output.unlockHeight = confHeight + 1
+				output.unlockEvent = MonitorEventSpendable
+
+			default:
+				output.unlockHeight = confHeight
+				output.unlockEvent = MonitorEventSpendable
+			}
+		}
+
+		outputs = append(outputs, output)
+	}
+
+	return outputs
+}
@@ -0,0 +1,98 @@
+package btc
+
+import (
+	"testing"
+
+	"github.com/guggero/chantools/dataformat"
+	"github.com/stretchr/testify/require"
+)
+
+type mockChainAPI struct {
+	height uint32
+	txs    map[string]*TX
+}
+
+func (m *mockChainAPI) Transaction(txid string) (*TX, error) {
+	tx, ok := m.txs[txid]
+	if !ok {
+		return nil, ErrTxNotFound
+	}
+
+	return tx, nil
+}
+
+func (m *mockChainAPI) BlockHeight() (uint32, error) {
+	return m.height, nil
+}
+
+func TestOutputMonitor(t *testing.T) {
+	closingTx := &TX{TXID: "aa", Vout: []*Vout{{
+		ScriptPubkeyType: "v0_p2wsh",
+		Value:            anchorOutputValue,
+		Outspend:         &Outspend{},
+	}, {
+		ScriptPubkeyType: "v0_p2wsh",
+		Value:            50_000,
+		Outspend:         &Outspend{},
+	}, {
+		ScriptPubkeyType: "v0_p2wpkh",
+		Value:            80_000,
+		Outspend:         &Outspend{},
+	}}}
+	api := &mockChainAPI{
+		height: 100,
+		txs:    map[string]*TX{"aa": closingTx},
+	}
+	monitor := NewOutputMonitor(api, []*dataformat.SummaryEntry{{
+		ChannelPoint: "ff:0",
+		LocalBalance: 50_000,
+		ClosingTX: &dataformat.ClosingTX{
+			TXID:       "aa",
+			ForceClose: true,
+			ConfHeight: 100,
+		},
+		ForceClose: &dataformat.ForceClose{
+			TXID:     "aa",
+			CSVDelay: 144,
+		},
+	}, {
+		ChannelPoint: "ee:0",
+		ClosingTX: &dataformat.ClosingTX{
+			TXID:         "bb",
+			AllOutsSpent: true,
+		},
+	}})
+	require.Equal(t, 1, monitor.NumChannels())
+
+	// Nothing happened yet.
+	events, err := monitor.Check()
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	// Our to_local output matures after the CSV delay, but only once.
+	api.height = 244
+	events, err = monitor.Check()
+	require.NoError(t, err)
+	require.Equal(t, []*MonitorEvent{{
+		Type:         MonitorEventMatured,
+		ChannelPoint: "ff:0",
+		Outpoint:     "aa:1",
+		Value:        50_000,
+		Height:       244,
+	}}, events)
+
+	events, err = monitor.Check()
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	// Once all outputs are spent, the channel isn't monitored anymore.
+	closingTx.Vout[1].Outspend = &Outspend{Spent: true, Txid: "cc"}
+	closingTx.Vout[2].Outspend = &Outspend{Spent: true, Txid: "dd"}
+	events, err = monitor.Check()
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, MonitorEventSpent, events[0].Type)
+	require.Equal(t, "cc", events[0].SpendingTXID)
+	require.Equal(t, "dd", events[1].SpendingTXID)
+	require.Equal(t, 0, monitor.NumChannels())
+}
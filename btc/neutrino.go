@@ -0,0 +1,432 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btclog"
+	"github.com/btcsuite/btcwallet/walletdb"
+	_ "github.com/btcsuite/btcwallet/walletdb/bdb" // Register bdb driver.
+	"github.com/lightninglabs/neutrino"
+	"github.com/lightninglabs/neutrino/headerfs"
+)
+
+const (
+	// neutrinoDBTimeout is the time we wait to obtain the lock of the
+	// neutrino database.
+	neutrinoDBTimeout = time.Minute
+
+	// neutrinoSyncInterval is the interval in which the progress of the
+	// initial header and filter sync is logged.
+	neutrinoSyncInterval = 10 * time.Second
+)
+
+// HeightHinter is implemented by chain backends that can only look up a
+// transaction if they know the height of the block it was confirmed in.
+type HeightHinter interface {
+	// AddHeightHint registers the height of the block the transaction
+	// with the given ID was confirmed in.
+	AddHeightHint(txid string, height uint32)
+}
+
+// Stopper is implemented by chain backends that run a service in the
+// background that must be stopped once the backend is no longer needed.
+type Stopper interface {
+	// Stop shuts down the background service of the backend.
+	Stop() error
+}
+
+// NeutrinoAPI is a SweepAPI that uses a neutrino light client. It syncs the
+// block headers and compact block filters (BIP 157/158) from the P2P network
+// and then finds out if an output was spent by matching its script against
+// the filters locally. That way no server learns which outputs we're
+// interested in. Because there is no transaction index, a transaction can
+// only be looked up if the height of its block is known, either through a
+// height hint or because it was found as the spending transaction of another
+// output. Stop must be called once the backend is no longer needed.
+type NeutrinoAPI struct {
+	// DataDir is the directory the block headers and filters are stored
+	// in.
+	DataDir string
+
+	// Peers is the list of peers to connect to. If it is empty, peers are
+	// found through the DNS seeds.
+	Peers []string
+
+	// ChainParams are the parameters of the network to sync.
+	ChainParams *chaincfg.Params
+
+	// Log is used to report the progress of the initial sync.
+	Log btclog.Logger
+
+	startOnce sync.Once
+	startErr  error
+	db        walletdb.DB
+	cs        *neutrino.ChainService
+
+	mu          sync.Mutex
+	heightHints map[string]uint32
+	knownTxs    map[string]*neutrinoTx
+}
+
+// neutrinoTx is a transaction together with the height of the block it was
+// confirmed in.
+type neutrinoTx struct {
+	tx     *wire.MsgTx
+	height uint32
+}
+
+// Enforce NeutrinoAPI implements the SweepAPI, HeightHinter and Stopper
+// interfaces.
+var _ SweepAPI = (*NeutrinoAPI)(nil)
+var _ HeightHinter = (*NeutrinoAPI)(nil)
+var _ Stopper = (*NeutrinoAPI)(nil)
+
+// AddHeightHint registers the height of the block the transaction with the
+// given ID was confirmed in.
+func (n *NeutrinoAPI) AddHeightHint(txid string, height uint32) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.heightHints == nil {
+		n.heightHints = make(map[string]uint32)
+	}
+	n.heightHints[txid] = height
+}
+
+// start opens the database, starts the chain service and waits for the block
+// headers and filters to be synced. It is only executed once.
+func (n *NeutrinoAPI) start() error {
+	n.startOnce.Do(func() {
+		n.startErr = n.startChainService()
+	})
+
+	return n.startErr
+}
+
+// startChainService opens the database, starts the chain service and waits
+// for the block headers and filters to be synced.
+func (n *NeutrinoAPI) startChainService() error {
+	if err := os.MkdirAll(n.DataDir, 0700); err != nil {
+		return fmt.Errorf("error creating neutrino data dir: %w", err)
+	}
+
+	dbPath := filepath.Join(n.DataDir, "neutrino.db")
+	db, err := walletdb.Create("bdb", dbPath, true, neutrinoDBTimeout)
+	if errors.Is(err, walletdb.ErrDbExists) {
+		db, err = walletdb.Open("bdb", dbPath, true, neutrinoDBTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("error opening neutrino DB: %w", err)
+	}
+	n.db = db
+
+	cs, err := neutrino.NewChainService(neutrino.Config{
+		DataDir:       n.DataDir,
+		Database:      db,
+		ChainParams:   *n.ChainParams,
+		ConnectPeers:  n.Peers,
+		PersistToDisk: true,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating neutrino chain service: %w",
+			err)
+	}
+	if err := cs.Start(); err != nil {
+		return fmt.Errorf("error starting neutrino chain service: %w",
+			err)
+	}
+	n.cs = cs
+
+	ticker := time.NewTicker(neutrinoSyncInterval)
+	defer ticker.Stop()
+	for !n.cs.IsCurrent() {
+		<-ticker.C
+
+		if n.Log == nil {
+			continue
+		}
+		bestBlock, err := n.cs.BestBlock()
+		if err != nil {
+			return fmt.Errorf("error fetching best block: %w", err)
+		}
+		n.Log.Infof("Syncing block headers and filters, currently at "+
+			"height %d", bestBlock.Height)
+	}
+
+	return nil
+}
+
+// Stop stops the chain service and closes the database if they were started.
+func (n *NeutrinoAPI) Stop() error {
+	var stopErr error
+	if n.cs != nil {
+		if err := n.cs.Stop(); err != nil {
+			stopErr = fmt.Errorf("error stopping neutrino chain "+
+				"service: %w", err)
+		}
+		n.cs = nil
+	}
+	if n.db != nil {
+		if err := n.db.Close(); err != nil && stopErr == nil {
+			stopErr = fmt.Errorf("error closing neutrino DB: %w",
+				err)
+		}
+		n.db = nil
+	}
+
+	return stopErr
+}
+
+// Transaction returns the transaction with the given ID, including the spend
+// information of each of its outputs. Only confirmed spends are found.
+func (n *NeutrinoAPI) Transaction(txid string) (*TX, error) {
+	msgTx, height, err := n.findTx(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := convertMsgTx(msgTx, n.ChainParams)
+	if err != nil {
+		return nil, err
+	}
+	tx.Status, err = n.status(height)
+	if err != nil {
+		return nil, err
+	}
+
+	// The UTXO scanner of neutrino batches concurrent requests, so we
+	// look up all outputs at the same time.
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(tx.Vout))
+	)
+	for idx := range tx.Vout {
+		tx.Vout[idx].Outspend = &Outspend{}
+
+		pkScript := msgTx.TxOut[idx].PkScript
+		if txscript.GetScriptClass(pkScript) == txscript.NullDataTy {
+			continue
+		}
+
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+
+			tx.Vout[idx].Outspend, errs[idx] = n.outspend(
+				wire.OutPoint{
+					Hash:  msgTx.TxHash(),
+					Index: uint32(idx),
+				}, pkScript, tx.Status,
+			)
+		}(idx)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
+
+// BlockHeight returns the height of the current best block.
+func (n *NeutrinoAPI) BlockHeight() (uint32, error) {
+	if err := n.start(); err != nil {
+		return 0, err
+	}
+
+	bestBlock, err := n.cs.BestBlock()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(bestBlock.Height), nil
+}
+
+// Outpoint is not supported by the neutrino backend, as finding the outputs of
+// an address would require scanning the whole chain.
+func (n *NeutrinoAPI) Outpoint(string) (*TX, int, error) {
+	return nil, 0, fmt.Errorf("looking up the outputs of an address is " +
+		"not supported by the neutrino backend")
+}
+
+// Address returns the address of the given outpoint in the format
+// <txid>:<index>.
+func (n *NeutrinoAPI) Address(outpoint string) (string, error) {
+	parts := strings.Split(outpoint, ":")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid outpoint: %v", outpoint)
+	}
+
+	vout, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	msgTx, _, err := n.findTx(parts[0])
+	if err != nil {
+		return "", err
+	}
+	tx, err := convertMsgTx(msgTx, n.ChainParams)
+	if err != nil {
+		return "", err
+	}
+	if vout < 0 || len(tx.Vout) <= vout {
+		return "", fmt.Errorf("invalid output index: %d", vout)
+	}
+
+	return tx.Vout[vout].ScriptPubkeyAddr, nil
+}
+
+// PublishTx broadcasts the given hex encoded raw transaction to the connected
+// peers and returns its transaction ID.
+func (n *NeutrinoAPI) PublishTx(rawTxHex string) (string, error) {
+	rawTx, err := hex.DecodeString(rawTxHex)
+	if err != nil {
+		return "", fmt.Errorf("error decoding transaction: %w", err)
+	}
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return "", fmt.Errorf("error parsing transaction: %w", err)
+	}
+
+	if err := n.start(); err != nil {
+		return "", err
+	}
+	if err := n.cs.SendTransaction(tx); err != nil {
+		return "", err
+	}
+
+	return tx.TxHash().String(), nil
+}
+
+// findTx returns the transaction with the given ID and the height of the
+// block it was confirmed in.
+func (n *NeutrinoAPI) findTx(txid string) (*wire.MsgTx, uint32, error) {
+	n.mu.Lock()
+	known, isKnown := n.knownTxs[txid]
+	height, hasHint := n.heightHints[txid]
+	n.mu.Unlock()
+
+	if isKnown {
+		return known.tx, known.height, nil
+	}
+	if !hasHint {
+		return nil, 0, fmt.Errorf("unknown block height of "+
+			"transaction %s, the neutrino backend can only look "+
+			"up transactions with a known short channel ID: %w",
+			txid, ErrTxNotFound)
+	}
+
+	if err := n.start(); err != nil {
+		return nil, 0, err
+	}
+
+	blockHash, err := n.cs.GetBlockHash(int64(height))
+	if err != nil {
+		return nil, 0, fmt.Errorf("error fetching block hash: %w", err)
+	}
+	block, err := n.cs.GetBlock(*blockHash)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error fetching block %v: %w",
+			blockHash, err)
+	}
+	for _, tx := range block.Transactions() {
+		if tx.Hash().String() == txid {
+			n.addKnownTx(tx.MsgTx(), height)
+
+			return tx.MsgTx(), height, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("transaction %s not in block %d: %w", txid,
+		height, ErrTxNotFound)
+}
+
+// outspend finds out if the given output was spent by matching its script
+// against the compact filters of all blocks since the output was confirmed.
+func (n *NeutrinoAPI) outspend(outpoint wire.OutPoint, pkScript []byte,
+	status *Status) (*Outspend, error) {
+
+	blockHash, err := chainhash.NewHashFromStr(status.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := n.cs.GetUtxo(
+		neutrino.WatchInputs(neutrino.InputWithScript{
+			OutPoint: outpoint,
+			PkScript: pkScript,
+		}),
+		neutrino.StartBlock(&headerfs.BlockStamp{
+			Hash:   *blockHash,
+			Height: int32(status.BlockHeight),
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up spend of %v: %w",
+			outpoint, err)
+	}
+
+	if report == nil || report.SpendingTx == nil {
+		return &Outspend{}, nil
+	}
+
+	n.addKnownTx(report.SpendingTx, report.SpendingTxHeight)
+	spendStatus, err := n.status(report.SpendingTxHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Outspend{
+		Spent:  true,
+		Txid:   report.SpendingTx.TxHash().String(),
+		Vin:    int(report.SpendingInputIndex),
+		Status: spendStatus,
+	}, nil
+}
+
+// status returns the confirmation status of a transaction that was confirmed
+// in the block with the given height.
+func (n *NeutrinoAPI) status(height uint32) (*Status, error) {
+	blockHash, err := n.cs.GetBlockHash(int64(height))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching block hash: %w", err)
+	}
+
+	return &Status{
+		Confirmed:   true,
+		BlockHeight: int(height),
+		BlockHash:   blockHash.String(),
+	}, nil
+}
+
+// addKnownTx remembers a transaction and the height of its block, so it can be
+// looked up later without a height hint.
+func (n *NeutrinoAPI) addKnownTx(tx *wire.MsgTx, height uint32) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.knownTxs == nil {
+		n.knownTxs = make(map[string]*neutrinoTx)
+	}
+	n.knownTxs[tx.TxHash().String()] = &neutrinoTx{
+		tx:     tx,
+		height: height,
+	}
+}
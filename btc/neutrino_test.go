@@ -0,0 +1,47 @@
+package btc
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNeutrinoAPIHeightHint(t *testing.T) {
+	api := &NeutrinoAPI{
+		DataDir:     t.TempDir(),
+		ChainParams: &chaincfg.RegressionNetParams,
+	}
+
+	// Without a height hint the transaction can't be looked up and the
+	// chain service must not be started.
+	txid := "5b5d6a4e54c3c1ab93f3a8d2a1d1e4a94e2b7c0c2a1e0ca0f3e54ba61d" +
+		"13d8e0"
+	_, err := api.Transaction(txid)
+	require.ErrorContains(t, err, "unknown block height")
+	require.ErrorIs(t, err, ErrTxNotFound)
+	_, err = api.Address(txid + ":0")
+	require.ErrorContains(t, err, "unknown block height")
+	require.ErrorIs(t, err, ErrTxNotFound)
+	require.Nil(t, api.cs)
+
+	_, _, err = api.Outpoint("bcrt1qxyz")
+	require.ErrorContains(t, err, "not supported")
+
+	api.AddHeightHint(txid, 123)
+	require.Equal(t, uint32(123), api.heightHints[txid])
+}
+
+func TestNeutrinoAPIStop(t *testing.T) {
+	api := &NeutrinoAPI{
+		DataDir:     t.TempDir(),
+		ChainParams: &chaincfg.RegressionNetParams,
+	}
+
+	// Stopping a backend that was never started is a no-op and can be
+	// repeated.
+	require.NoError(t, api.Stop())
+	require.NoError(t, api.Stop())
+	require.Nil(t, api.cs)
+	require.Nil(t, api.db)
+}
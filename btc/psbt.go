@@ -0,0 +1,536 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// PsbtVersion0 is the original PSBT format as defined in BIP 174.
+	PsbtVersion0 uint32 = 0
+
+	// PsbtVersion2 is the PSBT format as defined in BIP 370.
+	PsbtVersion2 uint32 = 2
+)
+
+// Key types of the PSBT fields that differ between version 0 and 2.
+const (
+	psbtGlobalUnsignedTx       = 0x00
+	psbtGlobalTxVersion        = 0x02
+	psbtGlobalFallbackLocktime = 0x03
+	psbtGlobalInputCount       = 0x04
+	psbtGlobalOutputCount      = 0x05
+	psbtGlobalTxModifiable     = 0x06
+	psbtGlobalVersion          = 0xfb
+
+	psbtInPreviousTxid         = 0x0e
+	psbtInOutputIndex          = 0x0f
+	psbtInSequence             = 0x10
+	psbtInRequiredTimeLocktime = 0x11
+	psbtInRequiredHeightLock   = 0x12
+
+	psbtOutAmount = 0x03
+	psbtOutScript = 0x04
+)
+
+var (
+	psbtMagic = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+	// ErrInvalidPsbtVersion is returned if a PSBT has a version that is
+	// neither 0 nor 2.
+	ErrInvalidPsbtVersion = errors.New("unsupported PSBT version")
+)
+
+// psbtKeyValue is a single raw key-value pair of a PSBT map.
+type psbtKeyValue struct {
+	key   []byte
+	value []byte
+}
+
+// psbtMap is a raw, undecoded PSBT map.
+type psbtMap []psbtKeyValue
+
+// get returns the value of the first field with the given (single byte) key
+// type and no key data.
+func (m psbtMap) get(keyType byte) ([]byte, bool) {
+	for _, kv := range m {
+		if len(kv.key) == 1 && kv.key[0] == keyType {
+			return kv.value, true
+		}
+	}
+
+	return nil, false
+}
+
+// without returns a copy of the map without the fields of the given key types
+// that have no key data.
+func (m psbtMap) without(keyTypes ...byte) psbtMap {
+	result := make(psbtMap, 0, len(m))
+	for _, kv := range m {
+		remove := false
+		for _, keyType := range keyTypes {
+			if len(kv.key) == 1 && kv.key[0] == keyType {
+				remove = true
+				break
+			}
+		}
+		if !remove {
+			result = append(result, kv)
+		}
+	}
+
+	return result
+}
+
+// DecodePsbt decodes a base64 encoded PSBT of version 0 or 2. Version 2
+// packets are converted to version 0, as that is the only version the psbt
+// package can work with. The original version is returned so the packet can
+// be encoded in the same format again.
+func DecodePsbt(b64 string) (*psbt.Packet, uint32, error) {
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error decoding base64: %w", err)
+	}
+
+	version, err := psbtVersion(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if version == PsbtVersion2 {
+		raw, err = psbtV2ToV0(raw)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error converting PSBT "+
+				"v2: %w", err)
+		}
+	}
+
+	packet, err := psbt.NewFromRawBytes(bytes.NewReader(raw), false)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return packet, version, nil
+}
+
+// EncodePsbt encodes the given packet as base64 in the given PSBT version.
+func EncodePsbt(packet *psbt.Packet, version uint32) (string, error) {
+	switch version {
+	case PsbtVersion0:
+		return packet.B64Encode()
+
+	case PsbtVersion2:
+		var buf bytes.Buffer
+		if err := packet.Serialize(&buf); err != nil {
+			return "", err
+		}
+
+		raw, err := psbtV0ToV2(buf.Bytes())
+		if err != nil {
+			return "", fmt.Errorf("error converting PSBT to v2: %w",
+				err)
+		}
+
+		return base64.StdEncoding.EncodeToString(raw), nil
+
+	default:
+		return "", fmt.Errorf("%w: %d", ErrInvalidPsbtVersion, version)
+	}
+}
+
+// psbtVersion returns the version of the given raw PSBT.
+func psbtVersion(raw []byte) (uint32, error) {
+	r := bytes.NewReader(raw)
+	if err := readPsbtMagic(r); err != nil {
+		return 0, err
+	}
+
+	globals, err := readPsbtMap(r)
+	if err != nil {
+		return 0, err
+	}
+
+	versionBytes, ok := globals.get(psbtGlobalVersion)
+	if !ok {
+		return PsbtVersion0, nil
+	}
+	if len(versionBytes) != 4 {
+		return 0, fmt.Errorf("invalid PSBT version field")
+	}
+
+	version := binary.LittleEndian.Uint32(versionBytes)
+	if version != PsbtVersion0 && version != PsbtVersion2 {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidPsbtVersion, version)
+	}
+
+	return version, nil
+}
+
+// psbtV2ToV0 converts a raw version 2 PSBT into a raw version 0 PSBT by
+// constructing the unsigned transaction from the per input and per output
+// fields.
+func psbtV2ToV0(raw []byte) ([]byte, error) {
+	r := bytes.NewReader(raw)
+	if err := readPsbtMagic(r); err != nil {
+		return nil, err
+	}
+
+	globals, err := readPsbtMap(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &wire.MsgTx{}
+	txVersion, ok, err := uint32FieldOk(globals, psbtGlobalTxVersion)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("transaction version missing")
+	}
+	tx.Version = int32(txVersion)
+
+	numInputs, err := compactSizeField(globals, psbtGlobalInputCount)
+	if err != nil {
+		return nil, err
+	}
+	numOutputs, err := compactSizeField(globals, psbtGlobalOutputCount)
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine the lock time as described in BIP 370. If no input
+	// requires a lock time, the fallback is used. Otherwise a height based
+	// lock time is preferred if all inputs support it.
+	var (
+		inputs                      = make([]psbtMap, numInputs)
+		heightLock, timeLock        uint32
+		anyLock, allHeight, allTime = false, true, true
+	)
+	for idx := range inputs {
+		inputs[idx], err = readPsbtMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading input %d: %w",
+				idx, err)
+		}
+
+		txIn, err := psbtV2TxIn(inputs[idx])
+		if err != nil {
+			return nil, fmt.Errorf("invalid input %d: %w", idx,
+				err)
+		}
+		tx.TxIn = append(tx.TxIn, txIn)
+
+		height, hasHeight, err := uint32FieldOk(
+			inputs[idx], psbtInRequiredHeightLock,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input %d: %w", idx,
+				err)
+		}
+		lockTime, hasTime, err := uint32FieldOk(
+			inputs[idx], psbtInRequiredTimeLocktime,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input %d: %w", idx,
+				err)
+		}
+		if hasHeight || hasTime {
+			anyLock = true
+			allHeight = allHeight && hasHeight
+			allTime = allTime && hasTime
+		}
+		if hasHeight && height > heightLock {
+			heightLock = height
+		}
+		if hasTime && lockTime > timeLock {
+			timeLock = lockTime
+		}
+
+		inputs[idx] = inputs[idx].without(
+			psbtInPreviousTxid, psbtInOutputIndex, psbtInSequence,
+			psbtInRequiredTimeLocktime, psbtInRequiredHeightLock,
+		)
+	}
+
+	tx.LockTime, _, err = uint32FieldOk(globals, psbtGlobalFallbackLocktime)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	// No input requires a lock time, keep the fallback.
+	case !anyLock:
+
+	case allHeight:
+		tx.LockTime = heightLock
+
+	case allTime:
+		tx.LockTime = timeLock
+
+	default:
+		return nil, fmt.Errorf("inputs require incompatible lock " +
+			"times")
+	}
+
+	outputs := make([]psbtMap, numOutputs)
+	for idx := range outputs {
+		outputs[idx], err = readPsbtMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading output %d: %w",
+				idx, err)
+		}
+
+		amount, ok := outputs[idx].get(psbtOutAmount)
+		if !ok || len(amount) != 8 {
+			return nil, fmt.Errorf("invalid amount of output %d",
+				idx)
+		}
+		script, ok := outputs[idx].get(psbtOutScript)
+		if !ok {
+			return nil, fmt.Errorf("missing script of output %d",
+				idx)
+		}
+		tx.TxOut = append(tx.TxOut, &wire.TxOut{
+			Value:    int64(binary.LittleEndian.Uint64(amount)),
+			PkScript: script,
+		})
+
+		outputs[idx] = outputs[idx].without(
+			psbtOutAmount, psbtOutScript,
+		)
+	}
+
+	var txBuf bytes.Buffer
+	if err := tx.SerializeNoWitness(&txBuf); err != nil {
+		return nil, err
+	}
+
+	globals = globals.without(
+		psbtGlobalUnsignedTx, psbtGlobalTxVersion,
+		psbtGlobalFallbackLocktime, psbtGlobalInputCount,
+		psbtGlobalOutputCount, psbtGlobalTxModifiable,
+		psbtGlobalVersion,
+	)
+	globals = append(psbtMap{{
+		key:   []byte{psbtGlobalUnsignedTx},
+		value: txBuf.Bytes(),
+	}}, globals...)
+
+	return writePsbt(globals, inputs, outputs)
+}
+
+// psbtV0ToV2 converts a raw version 0 PSBT into a raw version 2 PSBT by moving
+// the information of the unsigned transaction into the per input and per
+// output fields.
+func psbtV0ToV2(raw []byte) ([]byte, error) {
+	r := bytes.NewReader(raw)
+	if err := readPsbtMagic(r); err != nil {
+		return nil, err
+	}
+
+	globals, err := readPsbtMap(r)
+	if err != nil {
+		return nil, err
+	}
+	txBytes, ok := globals.get(psbtGlobalUnsignedTx)
+	if !ok {
+		return nil, fmt.Errorf("unsigned transaction missing")
+	}
+	tx := &wire.MsgTx{}
+	err = tx.DeserializeNoWitness(bytes.NewReader(txBytes))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding unsigned TX: %w", err)
+	}
+
+	globals = append(psbtMap{
+		uint32KeyValue(psbtGlobalTxVersion, uint32(tx.Version)),
+		uint32KeyValue(psbtGlobalFallbackLocktime, tx.LockTime),
+		compactSizeKeyValue(psbtGlobalInputCount, len(tx.TxIn)),
+		compactSizeKeyValue(psbtGlobalOutputCount, len(tx.TxOut)),
+	}, globals.without(psbtGlobalUnsignedTx, psbtGlobalVersion)...)
+	globals = append(
+		globals, uint32KeyValue(psbtGlobalVersion, PsbtVersion2),
+	)
+
+	inputs := make([]psbtMap, len(tx.TxIn))
+	for idx, txIn := range tx.TxIn {
+		inputs[idx], err = readPsbtMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading input %d: %w",
+				idx, err)
+		}
+
+		prevOut := txIn.PreviousOutPoint
+		inputs[idx] = append(inputs[idx], psbtKeyValue{
+			key:   []byte{psbtInPreviousTxid},
+			value: prevOut.Hash[:],
+		}, uint32KeyValue(psbtInOutputIndex, prevOut.Index),
+			uint32KeyValue(psbtInSequence, txIn.Sequence))
+	}
+
+	outputs := make([]psbtMap, len(tx.TxOut))
+	for idx, txOut := range tx.TxOut {
+		outputs[idx], err = readPsbtMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading output %d: %w",
+				idx, err)
+		}
+
+		var amount [8]byte
+		binary.LittleEndian.PutUint64(amount[:], uint64(txOut.Value))
+		outputs[idx] = append(outputs[idx], psbtKeyValue{
+			key:   []byte{psbtOutAmount},
+			value: amount[:],
+		}, psbtKeyValue{
+			key:   []byte{psbtOutScript},
+			value: txOut.PkScript,
+		})
+	}
+
+	return writePsbt(globals, inputs, outputs)
+}
+
+// psbtV2TxIn creates the transaction input from the fields of a version 2
+// PSBT input.
+func psbtV2TxIn(in psbtMap) (*wire.TxIn, error) {
+	txid, ok := in.get(psbtInPreviousTxid)
+	if !ok || len(txid) != chainhash.HashSize {
+		return nil, fmt.Errorf("invalid previous TXID")
+	}
+	index, ok, err := uint32FieldOk(in, psbtInOutputIndex)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("previous output index missing")
+	}
+	sequence, ok, err := uint32FieldOk(in, psbtInSequence)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		sequence = wire.MaxTxInSequenceNum
+	}
+
+	txIn := &wire.TxIn{Sequence: sequence}
+	copy(txIn.PreviousOutPoint.Hash[:], txid)
+	txIn.PreviousOutPoint.Index = index
+
+	return txIn, nil
+}
+
+// uint32FieldOk returns the little endian uint32 value of the field with the
+// given key type and whether the field exists.
+func uint32FieldOk(m psbtMap, keyType byte) (uint32, bool, error) {
+	value, ok := m.get(keyType)
+	if !ok {
+		return 0, false, nil
+	}
+	if len(value) != 4 {
+		return 0, false, fmt.Errorf("invalid length of field %#x",
+			keyType)
+	}
+
+	return binary.LittleEndian.Uint32(value), true, nil
+}
+
+// compactSizeField returns the compact size encoded value of the mandatory
+// field with the given key type.
+func compactSizeField(m psbtMap, keyType byte) (uint64, error) {
+	value, ok := m.get(keyType)
+	if !ok {
+		return 0, fmt.Errorf("field %#x missing", keyType)
+	}
+
+	return wire.ReadVarInt(bytes.NewReader(value), 0)
+}
+
+// uint32KeyValue creates a field with the given key type and the little
+// endian encoded value.
+func uint32KeyValue(keyType byte, value uint32) psbtKeyValue {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], value)
+
+	return psbtKeyValue{key: []byte{keyType}, value: b[:]}
+}
+
+// compactSizeKeyValue creates a field with the given key type and the compact
+// size encoded value.
+func compactSizeKeyValue(keyType byte, value int) psbtKeyValue {
+	var b bytes.Buffer
+	_ = wire.WriteVarInt(&b, 0, uint64(value))
+
+	return psbtKeyValue{key: []byte{keyType}, value: b.Bytes()}
+}
+
+// readPsbtMagic reads and validates the PSBT magic bytes.
+func readPsbtMagic(r io.Reader) error {
+	magic := make([]byte, len(psbtMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return fmt.Errorf("error reading PSBT magic: %w", err)
+	}
+	if !bytes.Equal(magic, psbtMagic) {
+		return fmt.Errorf("invalid PSBT magic bytes")
+	}
+
+	return nil
+}
+
+// readPsbtMap reads a single PSBT map up to and including its separator.
+func readPsbtMap(r io.Reader) (psbtMap, error) {
+	var m psbtMap
+	for {
+		key, err := wire.ReadVarBytes(
+			r, 0, psbt.MaxPsbtKeyLength, "key",
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error reading key: %w", err)
+		}
+
+		// An empty key is the separator at the end of the map.
+		if len(key) == 0 {
+			return m, nil
+		}
+
+		value, err := wire.ReadVarBytes(
+			r, 0, psbt.MaxPsbtValueLength, "value",
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error reading value: %w", err)
+		}
+
+		m = append(m, psbtKeyValue{key: key, value: value})
+	}
+}
+
+// writePsbt serializes the given raw maps as a PSBT.
+func writePsbt(globals psbtMap, inputs, outputs []psbtMap) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(psbtMagic)
+
+	maps := append([]psbtMap{globals}, inputs...)
+	maps = append(maps, outputs...)
+	for _, m := range maps {
+		for _, kv := range m {
+			err := wire.WriteVarBytes(&buf, 0, kv.key)
+			if err != nil {
+				return nil, err
+			}
+			err = wire.WriteVarBytes(&buf, 0, kv.value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		buf.WriteByte(0x00)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,164 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPacket(t *testing.T) *psbt.Packet {
+	tx := &wire.MsgTx{
+		Version:  2,
+		LockTime: 123_456,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: wire.OutPoint{
+				Hash:  chainhash.Hash{1, 2, 3},
+				Index: 7,
+			},
+			Sequence: 0xfffffffd,
+		}, {
+			PreviousOutPoint: wire.OutPoint{
+				Hash:  chainhash.Hash{4, 5, 6},
+				Index: 0,
+			},
+		}},
+		TxOut: []*wire.TxOut{{
+			Value:    100_000,
+			PkScript: []byte{0x00, 0x14, 0x01, 0x02},
+		}},
+	}
+
+	packet, err := psbt.NewFromUnsignedTx(tx)
+	require.NoError(t, err)
+
+	packet.Inputs[0].WitnessUtxo = &wire.TxOut{
+		Value:    200_000,
+		PkScript: []byte{0x51, 0x20, 0xaa},
+	}
+	packet.Inputs[0].Unknowns = []*psbt.Unknown{{
+		Key:   []byte{0xcc},
+		Value: []byte{0x01, 0x02, 0x03},
+	}}
+
+	return packet
+}
+
+func TestPsbtVersionRoundTrip(t *testing.T) {
+	packet := newTestPacket(t)
+
+	// A version 0 packet is encoded as usual.
+	v0, err := EncodePsbt(packet, PsbtVersion0)
+	require.NoError(t, err)
+	expected, err := packet.B64Encode()
+	require.NoError(t, err)
+	require.Equal(t, expected, v0)
+
+	// A version 2 packet has no unsigned TX but the version field.
+	v2, err := EncodePsbt(packet, PsbtVersion2)
+	require.NoError(t, err)
+	raw, err := base64.StdEncoding.DecodeString(v2)
+	require.NoError(t, err)
+	version, err := psbtVersion(raw)
+	require.NoError(t, err)
+	require.Equal(t, PsbtVersion2, version)
+
+	r := bytes.NewReader(raw)
+	require.NoError(t, readPsbtMagic(r))
+	globals, err := readPsbtMap(r)
+	require.NoError(t, err)
+	_, ok := globals.get(psbtGlobalUnsignedTx)
+	require.False(t, ok)
+
+	// Decoding the version 2 packet must result in the original packet.
+	decoded, decodedVersion, err := DecodePsbt(v2)
+	require.NoError(t, err)
+	require.Equal(t, PsbtVersion2, decodedVersion)
+	require.Equal(
+		t, packet.UnsignedTx.TxHash(), decoded.UnsignedTx.TxHash(),
+	)
+	require.Equal(t, packet.Inputs, decoded.Inputs)
+	require.Equal(t, packet.Outputs, decoded.Outputs)
+
+	decoded, decodedVersion, err = DecodePsbt(v0)
+	require.NoError(t, err)
+	require.Equal(t, PsbtVersion0, decodedVersion)
+	require.Equal(
+		t, packet.UnsignedTx.TxHash(), decoded.UnsignedTx.TxHash(),
+	)
+}
+
+func TestPsbtV2LockTime(t *testing.T) {
+	packet := newTestPacket(t)
+	v2, err := EncodePsbt(packet, PsbtVersion2)
+	require.NoError(t, err)
+	raw, err := base64.StdEncoding.DecodeString(v2)
+	require.NoError(t, err)
+
+	// Parse the raw maps so we can add the per input lock times.
+	r := bytes.NewReader(raw)
+	require.NoError(t, readPsbtMagic(r))
+	globals, err := readPsbtMap(r)
+	require.NoError(t, err)
+	inputs := make([]psbtMap, 2)
+	for idx := range inputs {
+		inputs[idx], err = readPsbtMap(r)
+		require.NoError(t, err)
+	}
+	outputs := []psbtMap{nil}
+	outputs[0], err = readPsbtMap(r)
+	require.NoError(t, err)
+
+	lockTime := func(inputs []psbtMap) (uint32, error) {
+		raw, err := writePsbt(globals, inputs, outputs)
+		require.NoError(t, err)
+
+		v0, err := psbtV2ToV0(raw)
+		if err != nil {
+			return 0, err
+		}
+		packet, err := psbt.NewFromRawBytes(bytes.NewReader(v0), false)
+		require.NoError(t, err)
+
+		return packet.UnsignedTx.LockTime, nil
+	}
+
+	// Without any requirements, the fallback lock time is used.
+	result, err := lockTime(inputs)
+	require.NoError(t, err)
+	require.EqualValues(t, 123_456, result)
+
+	// If all inputs support a height based lock time, it's preferred.
+	withLocks := []psbtMap{
+		append(inputs[0][:len(inputs[0]):len(inputs[0])],
+			uint32KeyValue(psbtInRequiredHeightLock, 800_000),
+			uint32KeyValue(psbtInRequiredTimeLocktime, 600_000_000),
+		),
+		append(inputs[1][:len(inputs[1]):len(inputs[1])],
+			uint32KeyValue(psbtInRequiredHeightLock, 800_100),
+		),
+	}
+	result, err = lockTime(withLocks)
+	require.NoError(t, err)
+	require.EqualValues(t, 800_100, result)
+
+	// If one input only supports a time based lock time, it is used.
+	withLocks[1] = append(inputs[1][:len(inputs[1]):len(inputs[1])],
+		uint32KeyValue(psbtInRequiredTimeLocktime, 600_000_100),
+	)
+	result, err = lockTime(withLocks)
+	require.NoError(t, err)
+	require.EqualValues(t, 600_000_100, result)
+
+	// Inputs that only support different lock time types can't be
+	// combined.
+	withLocks[0] = append(inputs[0][:len(inputs[0]):len(inputs[0])],
+		uint32KeyValue(psbtInRequiredHeightLock, 800_000),
+	)
+	_, err = lockTime(withLocks)
+	require.ErrorContains(t, err, "incompatible lock times")
+}
@@ -2,74 +2,471 @@ package btc
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/btcsuite/btclog"
 	"github.com/guggero/chantools/dataformat"
 )
 
-func SummarizeChannels(apiURL string, channels []*dataformat.SummaryEntry,
+const (
+	// anchorOutputValue is the value of an anchor output on a commitment
+	// transaction.
+	anchorOutputValue = 330
+
+	// summaryProgressInterval is the interval in which the progress of a
+	// summary run is logged.
+	summaryProgressInterval = 10 * time.Second
+
+	// summaryBatchSize is the number of transactions that are fetched
+	// with one bulk request from backends that support it.
+	summaryBatchSize = 100
+)
+
+// SummaryFilter restricts the channels that end up in a summary.
+type SummaryFilter struct {
+	// ForceCloseOnly only keeps channels that were force closed.
+	ForceCloseOnly bool
+
+	// MinLocalBalance only keeps channels with at least this local
+	// balance in satoshis.
+	MinLocalBalance uint64
+
+	// Peer only keeps channels with the given remote node public key.
+	Peer string
+}
+
+// matchEntry returns true if the channel passes all filters that can be
+// applied before looking up its on-chain state.
+func (f *SummaryFilter) matchEntry(entry *dataformat.SummaryEntry) bool {
+	if f == nil {
+		return true
+	}
+
+	if entry.LocalBalance < f.MinLocalBalance {
+		return false
+	}
+
+	if f.Peer != "" && !strings.EqualFold(entry.RemotePubkey, f.Peer) {
+		return false
+	}
+
+	return true
+}
+
+// matchLookup returns true if the on-chain state of the channel passes all
+// filters.
+func (f *SummaryFilter) matchLookup(res *channelLookup) bool {
+	if f == nil || !f.ForceCloseOnly {
+		return true
+	}
+
+	return res.spendTx != nil && !isCoopClose(res.spendTx)
+}
+
+// SummarizeChannels looks up the on-chain state of all given channels using
+// numWorkers concurrent workers and compiles the result into a summary file.
+// If a cache is given, channels found in it are not looked up again and all new
+// lookup results are added to it. Only channels that pass the filter (if one is
+// given) are contained in the summary.
+func SummarizeChannels(api ChainAPI, channels []*dataformat.SummaryEntry,
+	numWorkers int, cache *SummaryCache, filter *SummaryFilter,
 	log btclog.Logger) (*dataformat.SummaryEntryFile, error) {
 
-	summaryFile := &dataformat.SummaryEntryFile{
-		Channels: channels,
+	// Filters that don't need any on-chain information are applied first
+	// so we don't waste any API calls on those channels.
+	candidates := make([]*dataformat.SummaryEntry, 0, len(channels))
+	for _, channel := range channels {
+		if filter.matchEntry(channel) {
+			candidates = append(candidates, channel)
+		}
+	}
+	if len(candidates) != len(channels) {
+		log.Infof("Filtered out %d of %d channels before lookup.",
+			len(channels)-len(candidates), len(channels))
 	}
-	api := &ExplorerAPI{BaseURL: apiURL}
 
-	for idx, channel := range channels {
-		tx, err := api.Transaction(channel.FundingTXID)
-		if errors.Is(err, ErrTxNotFound) {
-			log.Errorf("Funding TX %s not found. Ignoring.",
-				channel.FundingTXID)
-			channel.ChanExists = false
-			continue
+	// Backends without a transaction index need to know in which block
+	// the funding transaction was confirmed.
+	if hinter, ok := api.(HeightHinter); ok {
+		for _, channel := range candidates {
+			if channel.FundingHeight > 0 {
+				hinter.AddHeightHint(
+					channel.FundingTXID,
+					channel.FundingHeight,
+				)
+			}
 		}
+	}
+
+	// Backends that support bulk requests get all transactions up front,
+	// the workers then only need to assemble the results.
+	if batchAPI, ok := api.(BatchChainAPI); ok {
+		var err error
+		api, err = prefetchTransactions(batchAPI, candidates, cache, log)
 		if err != nil {
-			log.Errorf("Problem with channel %d (%s): %v.",
-				idx, channel.FundingTXID, err)
 			return nil, err
 		}
-		channel.ChanExists = true
-		outspend := tx.Vout[channel.FundingTXIndex].Outspend
-		if outspend.Spent {
-			summaryFile.ClosedChannels++
-			channel.ClosingTX = &dataformat.ClosingTX{
-				TXID:       outspend.Txid,
-				ConfHeight: uint32(outspend.Status.BlockHeight),
+	}
+
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	var (
+		mtx       sync.Mutex
+		wg        sync.WaitGroup
+		firstErr  error
+		results   = make([]*channelLookup, len(candidates))
+		indexChan = make(chan int)
+		quit      = make(chan struct{})
+		progress  = newSummaryProgress(len(candidates))
+	)
+
+	// Log the progress periodically until all workers are done.
+	progressDone := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(summaryProgressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				log.Info(progress)
+
+			case <-progressDone:
+				return
 			}
+		}
+	}()
+
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for idx := range indexChan {
+				channel := candidates[idx]
+				res, err := cachedLookupChannel(
+					api, cache, channel,
+				)
+				progress.done(res, err)
+				if err != nil {
+					log.Errorf("Problem with channel %d "+
+						"(%s): %v.", idx,
+						channel.FundingTXID, err)
+				}
+
+				mtx.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+						close(quit)
+					}
+					mtx.Unlock()
+
+					continue
+				}
 
-			err := reportOutspend(
-				api, summaryFile, channel, outspend, log,
-			)
-			if err != nil {
-				log.Errorf("Problem with channel %d (%s): %v.",
-					idx, channel.FundingTXID, err)
-				return nil, err
+				results[idx] = res
+				mtx.Unlock()
 			}
-		} else {
-			summaryFile.OpenChannels++
-			summaryFile.FundsOpenChannels += channel.LocalBalance
-			channel.ClosingTX = nil
-			channel.HasPotential = true
+		}()
+	}
+
+dispatch:
+	for idx := range candidates {
+		select {
+		case indexChan <- idx:
+		case <-quit:
+			break dispatch
 		}
+	}
+	close(indexChan)
+	wg.Wait()
+
+	close(progressDone)
+	log.Info(progress)
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
 
-		if idx%50 == 0 {
-			log.Infof("Queried channel %d of %d.", idx,
-				len(channels))
+	// Now that we have all the information, we can compile the summary in
+	// the original order of the channels.
+	summaryFile := &dataformat.SummaryEntryFile{}
+	for idx, channel := range candidates {
+		if !filter.matchLookup(results[idx]) {
+			continue
 		}
+
+		reportChannel(summaryFile, channel, results[idx], log)
+		summaryFile.Channels = append(summaryFile.Channels, channel)
 	}
 
 	return summaryFile, nil
 }
 
-func reportOutspend(api *ExplorerAPI,
-	summaryFile *dataformat.SummaryEntryFile,
-	entry *dataformat.SummaryEntry, os *Outspend, log btclog.Logger) error {
+// channelLookup is the on-chain information fetched for a single channel.
+type channelLookup struct {
+	fundingTx *TX
+	spendTx   *TX
+}
+
+// summaryProgress keeps track of the progress of a summary run.
+type summaryProgress struct {
+	mtx       sync.Mutex
+	start     time.Time
+	total     int
+	processed int
+	notFound  int
+	errors    int
+}
+
+// newSummaryProgress creates a progress tracker for the given number of
+// channels.
+func newSummaryProgress(total int) *summaryProgress {
+	return &summaryProgress{
+		start: time.Now(),
+		total: total,
+	}
+}
+
+// done records the result of a single channel lookup.
+func (p *summaryProgress) done(res *channelLookup, err error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.processed++
+	switch {
+	case err != nil:
+		p.errors++
+
+	case res.fundingTx == nil:
+		p.notFound++
+	}
+}
+
+// String returns a human readable description of the current progress,
+// including an estimate of the remaining time.
+func (p *summaryProgress) String() string {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	remaining := p.total - p.processed
+	eta := "unknown"
+	if p.processed > 0 {
+		perChannel := time.Since(p.start) / time.Duration(p.processed)
+		eta = (perChannel * time.Duration(remaining)).Round(
+			time.Second,
+		).String()
+	}
+
+	percent := 100.0
+	if p.total > 0 {
+		percent = float64(p.processed) * 100 / float64(p.total)
+	}
+
+	return fmt.Sprintf("Processed %d of %d channels (%.1f%%), %d "+
+		"remaining, %d not found, %d errors, ETA %s", p.processed,
+		p.total, percent, remaining, p.notFound, p.errors, eta)
+}
+
+// prefetchedAPI is a ChainAPI that returns transactions that were already
+// fetched in bulk and only asks the backend for all others.
+type prefetchedAPI struct {
+	ChainAPI
+
+	txs map[string]*TX
+}
+
+// Transaction returns the transaction with the given ID from the prefetched
+// transactions if it is one of them.
+func (p *prefetchedAPI) Transaction(txid string) (*TX, error) {
+	tx, ok := p.txs[txid]
+	switch {
+	case !ok:
+		return p.ChainAPI.Transaction(txid)
+
+	case tx == nil:
+		return nil, ErrTxNotFound
+
+	default:
+		return tx, nil
+	}
+}
+
+// prefetchTransactions fetches the funding transactions of all channels that
+// aren't in the cache and the transactions spending them with bulk requests.
+func prefetchTransactions(api BatchChainAPI,
+	channels []*dataformat.SummaryEntry, cache *SummaryCache,
+	log btclog.Logger) (*prefetchedAPI, error) {
+
+	prefetched := &prefetchedAPI{
+		ChainAPI: api,
+		txs:      make(map[string]*TX),
+	}
+
+	var fundingTxids []string
+	for _, channel := range channels {
+		if cache != nil {
+			if _, ok := cache.get(channel.ChannelPoint); ok {
+				continue
+			}
+		}
+		fundingTxids = append(fundingTxids, channel.FundingTXID)
+	}
+	err := prefetched.fetch(api, fundingTxids, log)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching funding transactions: "+
+			"%w", err)
+	}
+
+	var spendTxids []string
+	for _, channel := range channels {
+		tx := prefetched.txs[channel.FundingTXID]
+		if tx == nil || len(tx.Vout) <= int(channel.FundingTXIndex) {
+			continue
+		}
+
+		outspend := tx.Vout[channel.FundingTXIndex].Outspend
+		if outspend != nil && outspend.Spent {
+			spendTxids = append(spendTxids, outspend.Txid)
+		}
+	}
+	err = prefetched.fetch(api, spendTxids, log)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching spending "+
+			"transactions: %w", err)
+	}
+
+	return prefetched, nil
+}
+
+// fetch looks up all given transactions that weren't fetched yet in batches.
+func (p *prefetchedAPI) fetch(api BatchChainAPI, txids []string,
+	log btclog.Logger) error {
+
+	var missing []string
+	for _, txid := range txids {
+		if _, ok := p.txs[txid]; ok {
+			continue
+		}
+
+		// Mark the transaction to not add duplicates.
+		p.txs[txid] = nil
+		missing = append(missing, txid)
+	}
+
+	for start := 0; start < len(missing); start += summaryBatchSize {
+		end := start + summaryBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+
+		txs, err := api.Transactions(missing[start:end])
+		if err != nil {
+			return err
+		}
+		for idx, tx := range txs {
+			p.txs[missing[start+idx]] = tx
+		}
+
+		log.Infof("Fetched %d of %d transactions in bulk", end,
+			len(missing))
+	}
+
+	return nil
+}
+
+// cachedLookupChannel returns the lookup result from the cache if there is one
+// and otherwise looks up the channel and adds the result to the cache.
+func cachedLookupChannel(api ChainAPI, cache *SummaryCache,
+	channel *dataformat.SummaryEntry) (*channelLookup, error) {
+
+	if cache == nil {
+		return lookupChannel(api, channel)
+	}
+
+	if res, ok := cache.get(channel.ChannelPoint); ok {
+		return res, nil
+	}
 
-	spendTx, err := api.Transaction(os.Txid)
+	res, err := lookupChannel(api, channel)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	if err := cache.put(channel.ChannelPoint, res); err != nil {
+		return nil, fmt.Errorf("error writing to cache: %w", err)
+	}
+
+	return res, nil
+}
+
+// lookupChannel fetches the funding transaction of a channel and, if the
+// funding output was spent, the spending transaction as well.
+func lookupChannel(api ChainAPI,
+	channel *dataformat.SummaryEntry) (*channelLookup, error) {
+
+	tx, err := api.Transaction(channel.FundingTXID)
+	if errors.Is(err, ErrTxNotFound) {
+		return &channelLookup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &channelLookup{fundingTx: tx}
+	outspend := tx.Vout[channel.FundingTXIndex].Outspend
+	if outspend.Spent {
+		result.spendTx, err = api.Transaction(outspend.Txid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// reportChannel updates the channel entry and the summary totals with the
+// result of a channel lookup.
+func reportChannel(summaryFile *dataformat.SummaryEntryFile,
+	channel *dataformat.SummaryEntry, res *channelLookup,
+	log btclog.Logger) {
+
+	if res.fundingTx == nil {
+		log.Errorf("Funding TX %s not found. Ignoring.",
+			channel.FundingTXID)
+		channel.ChanExists = false
+
+		return
+	}
+
+	channel.ChanExists = true
+	outspend := res.fundingTx.Vout[channel.FundingTXIndex].Outspend
+	if outspend.Spent {
+		summaryFile.ClosedChannels++
+		channel.ClosingTX = &dataformat.ClosingTX{
+			TXID:       outspend.Txid,
+			ConfHeight: uint32(outspend.Status.BlockHeight),
+		}
+
+		reportOutspend(summaryFile, channel, outspend, res.spendTx, log)
+	} else {
+		summaryFile.OpenChannels++
+		summaryFile.FundsOpenChannels += channel.LocalBalance
+		channel.ClosingTX = nil
+		channel.HasPotential = true
+	}
+}
+
+func reportOutspend(summaryFile *dataformat.SummaryEntryFile,
+	entry *dataformat.SummaryEntry, os *Outspend, spendTx *TX,
+	log btclog.Logger) {
+
 	summaryFile.FundsClosedChannels += entry.LocalBalance
 	var utxo []*Vout
 	for _, vout := range spendTx.Vout {
@@ -84,13 +481,34 @@ func reportOutspend(api *ExplorerAPI,
 		entry.ClosingTX.ForceClose = false
 		entry.ClosingTX.AllOutsSpent = len(utxo) == 0
 		entry.HasPotential = entry.LocalBalance > 0 && len(utxo) != 0
-		return nil
+		return
 	}
 
 	summaryFile.ForceClosedChannels++
 	entry.ClosingTX.ForceClose = true
 	entry.HasPotential = false
 
+	// If the input didn't tell us what type of channel this was, we try to
+	// find out from the outputs of the commitment transaction.
+	if entry.CommitmentType == "" {
+		entry.CommitmentType = detectCommitmentType(spendTx)
+	}
+
+	// HTLC outputs are accounted for separately, so only the to_local and
+	// to_remote outputs are looked at below.
+	balances := newCommitBalances(entry, spendTx)
+	htlcOutputs := reportHTLCOutputs(summaryFile, entry, spendTx, balances)
+	balanceUtxo := make([]*Vout, 0, len(utxo))
+	for idx, vout := range spendTx.Vout {
+		if vout.Outspend.Spent {
+			continue
+		}
+		if _, ok := htlcOutputs[idx]; ok {
+			continue
+		}
+		balanceUtxo = append(balanceUtxo, vout)
+	}
+
 	if len(utxo) > 0 {
 		log.Debugf("Channel %s spent by %s:%d which has %d outputs of "+
 			"which %d are unspent.", entry.ChannelPoint, os.Txid,
@@ -99,31 +517,42 @@ func reportOutspend(api *ExplorerAPI,
 		entry.ClosingTX.AllOutsSpent = false
 		summaryFile.ChannelsWithUnspent++
 
-		for _, o := range utxo {
+		for _, o := range balanceUtxo {
 			if o.ScriptPubkeyType == "v0_p2wpkh" {
 				entry.ClosingTX.ToRemoteAddr = o.ScriptPubkeyAddr
 			}
 		}
 
-		if couldBeOurs(entry, utxo) {
+		if len(balanceUtxo) > 0 &&
+			couldBeOurs(entry, balances, balanceUtxo) {
+
 			summaryFile.ChannelsWithPotential++
-			summaryFile.FundsForceClose += utxo[0].Value
+			summaryFile.FundsForceClose += balanceUtxo[0].Value
 			entry.HasPotential = true
 
 			// Could maybe be brute forced.
-			if len(utxo) == 1 &&
-				utxo[0].ScriptPubkeyType == "v0_p2wpkh" &&
-				!utxo[0].Outspend.Spent {
+			first := balanceUtxo[0]
+			if len(balanceUtxo) == 1 &&
+				first.ScriptPubkeyType == "v0_p2wpkh" &&
+				!first.Outspend.Spent {
 
-				entry.ClosingTX.OurAddr = utxo[0].ScriptPubkeyAddr
+				entry.ClosingTX.OurAddr = first.ScriptPubkeyAddr
+			}
+
+			// The to_remote output of anchor channels is a 1-CSV
+			// P2WSH output, which can be brute forced as well.
+			out := anchorToRemote(balances, balanceUtxo)
+			if out != nil {
+				entry.ClosingTX.OurAddr = out.ScriptPubkeyAddr
 			}
 		} else {
 			// It's theirs, ignore.
-			if entry.LocalBalance == 0 ||
-				(len(utxo) == 1 &&
-					utxo[0].Value == entry.RemoteBalance) {
+			onlyRemote := len(balanceUtxo) == 1 &&
+				balances.isRemote(balanceUtxo[0].Value)
+			if entry.LocalBalance == 0 || len(balanceUtxo) == 0 ||
+				onlyRemote {
 
-				return nil
+				return
 			}
 
 			// We don't know what this output is, logging for debug.
@@ -145,18 +574,205 @@ func reportOutspend(api *ExplorerAPI,
 		summaryFile.FundsClosedSpent += entry.LocalBalance
 		summaryFile.FullySpentChannels++
 	}
+}
 
-	return nil
+// commitBalances are the values the to_local and to_remote outputs of a force
+// close transaction can have. The initiator of the channel pays the commitment
+// fee and, for anchor channels, the anchor outputs. Depending on where the
+// balances of the channel came from, those might not be deducted yet, so the
+// output of the initiator can be smaller than its balance by that amount.
+type commitBalances struct {
+	local  []uint64
+	remote []uint64
 }
 
-func couldBeOurs(entry *dataformat.SummaryEntry, utxo []*Vout) bool {
-	if len(utxo) == 1 && utxo[0].Value == entry.RemoteBalance {
+// newCommitBalances returns the possible values of the to_local and to_remote
+// outputs of the given force close transaction of the channel. The commitment
+// fee is the part of the channel capacity that isn't paid to any output.
+func newCommitBalances(entry *dataformat.SummaryEntry,
+	commitTx *TX) *commitBalances {
+
+	balances := &commitBalances{
+		local:  []uint64{entry.LocalBalance},
+		remote: []uint64{entry.RemoteBalance},
+	}
+
+	var outputSum, anchorSum uint64
+	for _, vout := range commitTx.Vout {
+		outputSum += vout.Value
+		if vout.Value == anchorOutputValue {
+			anchorSum += vout.Value
+		}
+	}
+	if entry.Capacity <= outputSum {
+		return balances
+	}
+
+	fee := entry.Capacity - outputSum
+	initiator := &balances.remote
+	if entry.Initiator {
+		initiator = &balances.local
+	}
+	balance := (*initiator)[0]
+	for _, deduction := range []uint64{fee, fee + anchorSum} {
+		if balance > deduction {
+			*initiator = append(*initiator, balance-deduction)
+		}
+	}
+
+	return balances
+}
+
+// isLocal returns true if an output with the given value can be the output
+// of our balance.
+func (b *commitBalances) isLocal(value uint64) bool {
+	return containsValue(b.local, value)
+}
+
+// isRemote returns true if an output with the given value can be the output
+// of the balance of the remote party.
+func (b *commitBalances) isRemote(value uint64) bool {
+	return containsValue(b.remote, value)
+}
+
+func containsValue(values []uint64, value uint64) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reportHTLCOutputs finds the HTLC outputs of a force close transaction,
+// records them in the channel entry and adds the unspent ones to the summary
+// totals. Outputs that pay to a script hash and aren't anchors or the to_local
+// or to_remote output are assumed to be HTLC outputs. Where possible, they are
+// matched to the HTLCs that were pending on our commitment by their value. The
+// indexes of all HTLC outputs are returned.
+func reportHTLCOutputs(summaryFile *dataformat.SummaryEntryFile,
+	entry *dataformat.SummaryEntry, commitTx *TX,
+	balances *commitBalances) map[int]struct{} {
+
+	pending := make([]*dataformat.HTLC, len(entry.PendingHTLCs))
+	copy(pending, entry.PendingHTLCs)
+
+	htlcOutputs := make(map[int]struct{})
+	for idx, vout := range commitTx.Vout {
+		switch {
+		case vout.ScriptPubkeyType != "v0_p2wsh" &&
+			vout.ScriptPubkeyType != "v1_p2tr":
+
+			continue
+
+		case vout.Value == anchorOutputValue,
+			balances.isLocal(vout.Value),
+			balances.isRemote(vout.Value):
+
+			continue
+		}
+
+		htlcOutput := &dataformat.HTLCOutput{
+			Index:     uint32(idx),
+			Value:     vout.Value,
+			Spent:     vout.Outspend.Spent,
+			Direction: dataformat.HTLCDirectionUnknown,
+		}
+		for htlcIdx, htlc := range pending {
+			if htlc == nil || htlc.Amount != vout.Value {
+				continue
+			}
+
+			htlcOutput.Direction = dataformat.HTLCDirectionOutgoing
+			if htlc.Incoming {
+				htlcOutput.Direction =
+					dataformat.HTLCDirectionIncoming
+			}
+			htlcOutput.ExpiryHeight = htlc.ExpiryHeight
+			pending[htlcIdx] = nil
+
+			break
+		}
+
+		htlcOutputs[idx] = struct{}{}
+		entry.ClosingTX.HTLCOutputs = append(
+			entry.ClosingTX.HTLCOutputs, htlcOutput,
+		)
+
+		if htlcOutput.Spent {
+			continue
+		}
+		summaryFile.FundsHTLC += vout.Value
+		if htlcOutput.Direction != dataformat.HTLCDirectionUnknown {
+			summaryFile.FundsHTLCOurs += vout.Value
+			entry.HasPotential = true
+		}
+	}
+
+	return htlcOutputs
+}
+
+// anchorToRemote returns the output that is potentially our to_remote output
+// of an anchor channel that was force closed by the remote party. That is an
+// unspent P2WSH output that isn't an anchor and has the value of our local
+// balance. If there is no such output or more than one, nil is returned.
+func anchorToRemote(balances *commitBalances, utxo []*Vout) *Vout {
+	var candidate *Vout
+	for _, vout := range utxo {
+		if vout.ScriptPubkeyType != "v0_p2wsh" ||
+			vout.Value == anchorOutputValue ||
+			!balances.isLocal(vout.Value) {
+
+			continue
+		}
+
+		if candidate != nil {
+			return nil
+		}
+		candidate = vout
+	}
+
+	return candidate
+}
+
+func couldBeOurs(entry *dataformat.SummaryEntry, balances *commitBalances,
+	utxo []*Vout) bool {
+
+	if len(utxo) == 1 && balances.isRemote(utxo[0].Value) {
 		return false
 	}
 
 	return entry.LocalBalance != 0
 }
 
+// detectCommitmentType tries to find out the commitment type of a channel from
+// the outputs of its force close transaction. Taproot channels only use P2TR
+// outputs, anchor channels have one or two anchor outputs of 330 satoshis.
+// Without the keys of the channel, lease channels can't be told apart from
+// anchor channels and legacy channels can't be told apart from static remote
+// key channels, so only one of those three types is returned.
+func detectCommitmentType(commitTx *TX) string {
+	hasAnchor := false
+	for _, vout := range commitTx.Vout {
+		switch {
+		case vout.ScriptPubkeyType == "v1_p2tr":
+			return dataformat.CommitmentTypeTaproot
+
+		case vout.ScriptPubkeyType == "v0_p2wsh" &&
+			vout.Value == anchorOutputValue:
+
+			hasAnchor = true
+		}
+	}
+
+	if hasAnchor {
+		return dataformat.CommitmentTypeAnchorsOrLease
+	}
+
+	return dataformat.CommitmentTypeLegacyOrStaticRemoteKey
+}
+
 func isCoopClose(tx *TX) bool {
 	return tx.Vin[0].Sequence == 0xffffffff
 }
@@ -0,0 +1,166 @@
+package btc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// summaryCacheRecord is a single line in the summary cache file.
+type summaryCacheRecord struct {
+	ChannelPoint string `json:"channel_point"`
+	FundingTx    *TX    `json:"funding_tx"`
+	SpendTx      *TX    `json:"spend_tx"`
+}
+
+// SummaryCache persists the result of each channel lookup of a summary run to
+// a file so an interrupted run can be resumed without querying the API for the
+// channels that were already looked up.
+type SummaryCache struct {
+	mtx     sync.Mutex
+	file    *os.File
+	entries map[string]*channelLookup
+}
+
+// OpenSummaryCache opens the cache file with the given name. New records are
+// always appended, an existing file is never truncated. If resume is true, all
+// records already in the file are loaded so those channels don't need to be
+// looked up again. If a channel is in the file more than once, the last record
+// wins.
+func OpenSummaryCache(fileName string, resume bool) (*SummaryCache, error) {
+	cache := &SummaryCache{
+		entries: make(map[string]*channelLookup),
+	}
+
+	if resume {
+		if err := cache.load(fileName); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(
+		fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error opening cache file %s: %w",
+			fileName, err)
+	}
+	cache.file = file
+
+	// If the last record was only written partially, we need to make sure
+	// the next record starts on a new line.
+	incomplete, err := endsWithoutNewline(fileName)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if incomplete {
+		if _, err := file.Write([]byte{'\n'}); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+
+	return cache, nil
+}
+
+// endsWithoutNewline returns true if the given file is not empty and its last
+// byte is not a newline character.
+func endsWithoutNewline(fileName string) (bool, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = file.Close() }()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if stat.Size() == 0 {
+		return false, nil
+	}
+
+	lastByte := make([]byte, 1)
+	if _, err := file.ReadAt(lastByte, stat.Size()-1); err != nil {
+		return false, err
+	}
+
+	return lastByte[0] != '\n', nil
+}
+
+// load reads all records from the given file into memory. A missing file is
+// not an error, there's just nothing to resume from.
+func (c *SummaryCache) load(fileName string) error {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening cache file %s: %w", fileName,
+			err)
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var record summaryCacheRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// The last line might be incomplete if the previous run
+			// was killed while writing it. We just look that
+			// channel up again.
+			continue
+		}
+
+		c.entries[record.ChannelPoint] = &channelLookup{
+			fundingTx: record.FundingTx,
+			spendTx:   record.SpendTx,
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Len returns the number of channels that are in the cache.
+func (c *SummaryCache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return len(c.entries)
+}
+
+// get returns the cached lookup result for the given channel point, if any.
+func (c *SummaryCache) get(chanPoint string) (*channelLookup, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	res, ok := c.entries[chanPoint]
+	return res, ok
+}
+
+// put adds a lookup result to the cache and immediately writes it to disk.
+func (c *SummaryCache) put(chanPoint string, res *channelLookup) error {
+	recordBytes, err := json.Marshal(&summaryCacheRecord{
+		ChannelPoint: chanPoint,
+		FundingTx:    res.fundingTx,
+		SpendTx:      res.spendTx,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.entries[chanPoint] = res
+	_, err = c.file.Write(append(recordBytes, '\n'))
+	return err
+}
+
+// Close closes the underlying cache file.
+func (c *SummaryCache) Close() error {
+	return c.file.Close()
+}
@@ -0,0 +1,74 @@
+package btc
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryCacheResume(t *testing.T) {
+	fileName := path.Join(t.TempDir(), "cache.jsonl")
+
+	cache, err := OpenSummaryCache(fileName, false)
+	require.NoError(t, err)
+
+	res := &channelLookup{
+		fundingTx: &TX{TXID: "aa", Vout: []*Vout{{
+			Value:    1234,
+			Outspend: &Outspend{Spent: true, Txid: "bb"},
+		}}},
+		spendTx: &TX{TXID: "bb"},
+	}
+	require.NoError(t, cache.put("aa:0", res))
+	require.NoError(t, cache.Close())
+
+	// Simulate a run that was killed while writing a record.
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"channel_point":"cc:1","fund`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	// Resuming loads the complete record and ignores the broken one.
+	cache, err = OpenSummaryCache(fileName, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, cache.Len())
+
+	cached, ok := cache.get("aa:0")
+	require.True(t, ok)
+	require.Equal(t, res, cached)
+
+	_, ok = cache.get("cc:1")
+	require.False(t, ok)
+
+	// A record added after the broken one must survive the next resume.
+	require.NoError(t, cache.put("dd:2", res))
+	require.NoError(t, cache.Close())
+
+	cache, err = OpenSummaryCache(fileName, true)
+	require.NoError(t, err)
+	require.Equal(t, 2, cache.Len())
+	require.NoError(t, cache.Close())
+
+	// Starting without resume doesn't use the cached records but also
+	// doesn't remove them. Newer records replace older ones of the same
+	// channel.
+	cache, err = OpenSummaryCache(fileName, false)
+	require.NoError(t, err)
+	require.Equal(t, 0, cache.Len())
+
+	updated := &channelLookup{fundingTx: &TX{TXID: "aa"}}
+	require.NoError(t, cache.put("aa:0", updated))
+	require.NoError(t, cache.Close())
+
+	cache, err = OpenSummaryCache(fileName, true)
+	require.NoError(t, err)
+	require.Equal(t, 2, cache.Len())
+
+	cached, ok = cache.get("aa:0")
+	require.True(t, ok)
+	require.Equal(t, updated, cached)
+	require.NoError(t, cache.Close())
+}
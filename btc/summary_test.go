@@ -0,0 +1,427 @@
+package btc
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btclog"
+
+	"github.com/guggero/chantools/dataformat"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportHTLCOutputs(t *testing.T) {
+	out := func(scriptType string, value uint64, spent bool) *Vout {
+		return &Vout{
+			ScriptPubkeyType: scriptType,
+			Value:            value,
+			Outspend:         &Outspend{Spent: spent},
+		}
+	}
+	commitTx := &TX{Vout: []*Vout{
+		out("v0_p2wsh", anchorOutputValue, false),
+		out("v0_p2wsh", anchorOutputValue, false),
+		out("v0_p2wsh", 50_000, false),
+		out("v0_p2wsh", 80_000, false),
+		out("v0_p2wsh", 1_000, false),
+		out("v0_p2wsh", 2_000, true),
+		out("v0_p2wsh", 3_000, false),
+	}}
+	entry := &dataformat.SummaryEntry{
+		LocalBalance:  50_000,
+		RemoteBalance: 80_000,
+		PendingHTLCs: []*dataformat.HTLC{
+			{Incoming: true, Amount: 1_000},
+			{Incoming: false, Amount: 2_000, ExpiryHeight: 800},
+		},
+		ClosingTX: &dataformat.ClosingTX{},
+	}
+	summaryFile := &dataformat.SummaryEntryFile{}
+
+	htlcOutputs := reportHTLCOutputs(
+		summaryFile, entry, commitTx,
+		newCommitBalances(entry, commitTx),
+	)
+	require.Equal(t, map[int]struct{}{4: {}, 5: {}, 6: {}}, htlcOutputs)
+	require.Equal(t, []*dataformat.HTLCOutput{{
+		Index:     4,
+		Value:     1_000,
+		Direction: dataformat.HTLCDirectionIncoming,
+	}, {
+		Index:        5,
+		Value:        2_000,
+		Spent:        true,
+		Direction:    dataformat.HTLCDirectionOutgoing,
+		ExpiryHeight: 800,
+	}, {
+		Index:     6,
+		Value:     3_000,
+		Direction: dataformat.HTLCDirectionUnknown,
+	}}, entry.ClosingTX.HTLCOutputs)
+
+	require.Equal(t, uint64(4_000), summaryFile.FundsHTLC)
+	require.Equal(t, uint64(1_000), summaryFile.FundsHTLCOurs)
+	require.True(t, entry.HasPotential)
+}
+
+func TestReportHTLCOutputsCommitFee(t *testing.T) {
+	out := func(value uint64) *Vout {
+		return &Vout{
+			ScriptPubkeyType: "v0_p2wsh",
+			Value:            value,
+			Outspend:         &Outspend{},
+		}
+	}
+
+	// We opened the anchor channel, so we pay the commitment fee of 2_000
+	// satoshis and the two anchor outputs. Our balance doesn't have those
+	// deducted yet.
+	commitTx := &TX{Vout: []*Vout{
+		out(anchorOutputValue),
+		out(anchorOutputValue),
+		out(47_340),
+		out(40_000),
+		out(10_000),
+	}}
+	entry := &dataformat.SummaryEntry{
+		Capacity:      100_000,
+		Initiator:     true,
+		LocalBalance:  50_000,
+		RemoteBalance: 40_000,
+		PendingHTLCs: []*dataformat.HTLC{
+			{Incoming: true, Amount: 10_000},
+		},
+		ClosingTX: &dataformat.ClosingTX{},
+	}
+	summaryFile := &dataformat.SummaryEntryFile{}
+
+	balances := newCommitBalances(entry, commitTx)
+	require.True(t, balances.isLocal(47_340))
+	require.False(t, balances.isRemote(47_340))
+
+	// Only the HTLC output is reported, not our to_local output.
+	htlcOutputs := reportHTLCOutputs(
+		summaryFile, entry, commitTx, balances,
+	)
+	require.Equal(t, map[int]struct{}{4: {}}, htlcOutputs)
+	require.Equal(t, []*dataformat.HTLCOutput{{
+		Index:     4,
+		Value:     10_000,
+		Direction: dataformat.HTLCDirectionIncoming,
+	}}, entry.ClosingTX.HTLCOutputs)
+	require.Equal(t, uint64(10_000), summaryFile.FundsHTLC)
+
+	// If the remote party opened the channel, their output is the smaller
+	// one.
+	entry.Initiator = false
+	entry.LocalBalance = 40_000
+	entry.RemoteBalance = 50_000
+	balances = newCommitBalances(entry, commitTx)
+	require.True(t, balances.isRemote(47_340))
+	require.False(t, balances.isLocal(47_340))
+	require.Equal(
+		t, commitTx.Vout[3], anchorToRemote(balances, commitTx.Vout),
+	)
+}
+
+// fakeBatchAPI is a BatchChainAPI that counts the requests it receives.
+type fakeBatchAPI struct {
+	txs map[string]*TX
+
+	numSingle int32
+	numBatch  int32
+}
+
+func (f *fakeBatchAPI) Transaction(txid string) (*TX, error) {
+	atomic.AddInt32(&f.numSingle, 1)
+
+	tx, ok := f.txs[txid]
+	if !ok {
+		return nil, ErrTxNotFound
+	}
+	return tx, nil
+}
+
+func (f *fakeBatchAPI) Transactions(txids []string) ([]*TX, error) {
+	atomic.AddInt32(&f.numBatch, 1)
+
+	txs := make([]*TX, len(txids))
+	for idx, txid := range txids {
+		txs[idx] = f.txs[txid]
+	}
+	return txs, nil
+}
+
+func (f *fakeBatchAPI) BlockHeight() (uint32, error) {
+	return 1000, nil
+}
+
+func TestDetectCommitmentType(t *testing.T) {
+	out := func(scriptType string, value uint64) *Vout {
+		return &Vout{ScriptPubkeyType: scriptType, Value: value}
+	}
+
+	testCases := []struct {
+		name     string
+		outputs  []*Vout
+		expected string
+	}{{
+		name: "taproot",
+		outputs: []*Vout{
+			out("v1_p2tr", anchorOutputValue),
+			out("v1_p2tr", 50_000),
+		},
+		expected: dataformat.CommitmentTypeTaproot,
+	}, {
+		name: "anchors or lease",
+		outputs: []*Vout{
+			out("v0_p2wsh", anchorOutputValue),
+			out("v0_p2wsh", 50_000),
+			out("v0_p2wsh", 80_000),
+		},
+		expected: dataformat.CommitmentTypeAnchorsOrLease,
+	}, {
+		name: "legacy or static remote key",
+		outputs: []*Vout{
+			out("v0_p2wpkh", 50_000),
+			out("v0_p2wsh", 80_000),
+		},
+		expected: dataformat.CommitmentTypeLegacyOrStaticRemoteKey,
+	}, {
+		// A 330 satoshi output that isn't P2WSH isn't an anchor.
+		name: "small to_remote output",
+		outputs: []*Vout{
+			out("v0_p2wpkh", anchorOutputValue),
+			out("v0_p2wsh", 80_000),
+		},
+		expected: dataformat.CommitmentTypeLegacyOrStaticRemoteKey,
+	}}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			commitmentType := detectCommitmentType(
+				&TX{Vout: tc.outputs},
+			)
+			require.Equal(t, tc.expected, commitmentType)
+			require.Equal(
+				t, "sweepremoteclosed",
+				dataformat.SweepCommandForCommitmentType(
+					commitmentType,
+				),
+			)
+		})
+	}
+}
+
+func TestSummarizeChannelsBatch(t *testing.T) {
+	api := &fakeBatchAPI{txs: make(map[string]*TX)}
+
+	var channels []*dataformat.SummaryEntry
+	for i := 0; i < 250; i++ {
+		fundingTxid := fmt.Sprintf("funding%d", i)
+		fundingTx := &TX{
+			TXID: fundingTxid,
+			Vout: []*Vout{{Value: 100_000, Outspend: &Outspend{}}},
+		}
+
+		// Every second channel was cooperatively closed.
+		if i%2 == 0 {
+			spendTxid := fmt.Sprintf("spend%d", i)
+			fundingTx.Vout[0].Outspend = &Outspend{
+				Spent:  true,
+				Txid:   spendTxid,
+				Status: &Status{Confirmed: true},
+			}
+			api.txs[spendTxid] = &TX{
+				TXID: spendTxid,
+				Vin:  []*Vin{{Sequence: 0xffffffff}},
+				Vout: []*Vout{{Outspend: &Outspend{}}},
+			}
+		}
+		api.txs[fundingTxid] = fundingTx
+
+		channels = append(channels, &dataformat.SummaryEntry{
+			ChannelPoint: fundingTxid + ":0",
+			FundingTXID:  fundingTxid,
+			LocalBalance: 50_000,
+		})
+	}
+
+	// One channel doesn't exist on chain.
+	channels = append(channels, &dataformat.SummaryEntry{
+		ChannelPoint: "unknown:0",
+		FundingTXID:  "unknown",
+	})
+
+	summary, err := SummarizeChannels(
+		api, channels, 4, nil, nil, btclog.Disabled,
+	)
+	require.NoError(t, err)
+	require.EqualValues(t, 125, summary.OpenChannels)
+	require.EqualValues(t, 125, summary.CoopClosedChannels)
+	require.False(t, summary.Channels[250].ChanExists)
+
+	// The 251 funding transactions and the 125 spending transactions are
+	// fetched in batches of 100 without any single requests.
+	require.EqualValues(t, 5, atomic.LoadInt32(&api.numBatch))
+	require.EqualValues(t, 0, atomic.LoadInt32(&api.numSingle))
+}
+
+// slowAPI is a ChainAPI that takes a while to answer each request and records
+// the maximum number of concurrent requests.
+type slowAPI struct {
+	txs map[string]*TX
+
+	// failTxid is the transaction that can't be fetched.
+	failTxid string
+
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (s *slowAPI) Transaction(txid string) (*TX, error) {
+	inFlight := atomic.AddInt32(&s.inFlight, 1)
+	defer atomic.AddInt32(&s.inFlight, -1)
+
+	for {
+		peak := &s.maxInFlight
+		old := atomic.LoadInt32(peak)
+		if inFlight <= old ||
+			atomic.CompareAndSwapInt32(peak, old, inFlight) {
+
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if txid == s.failTxid {
+		return nil, errors.New("API unreachable")
+	}
+
+	tx, ok := s.txs[txid]
+	if !ok {
+		return nil, ErrTxNotFound
+	}
+	return tx, nil
+}
+
+func (s *slowAPI) BlockHeight() (uint32, error) {
+	return 1000, nil
+}
+
+func TestSummarizeChannelsConcurrent(t *testing.T) {
+	const numWorkers = 4
+
+	api := &slowAPI{txs: make(map[string]*TX)}
+	var channels []*dataformat.SummaryEntry
+	for i := 0; i < 40; i++ {
+		fundingTxid := fmt.Sprintf("funding%d", i)
+		api.txs[fundingTxid] = &TX{
+			TXID: fundingTxid,
+			Vout: []*Vout{{Value: 100_000, Outspend: &Outspend{}}},
+		}
+		channels = append(channels, &dataformat.SummaryEntry{
+			ChannelPoint: fundingTxid + ":0",
+			FundingTXID:  fundingTxid,
+			LocalBalance: uint64(i),
+		})
+	}
+
+	summary, err := SummarizeChannels(
+		api, channels, numWorkers, nil, nil, btclog.Disabled,
+	)
+	require.NoError(t, err)
+	require.EqualValues(t, 40, summary.OpenChannels)
+
+	// The channels are looked up in parallel but never by more than the
+	// given number of workers.
+	maxInFlight := atomic.LoadInt32(&api.maxInFlight)
+	require.Greater(t, maxInFlight, int32(1))
+	require.LessOrEqual(t, maxInFlight, int32(numWorkers))
+
+	// The summary keeps the original order of the channels.
+	require.Len(t, summary.Channels, 40)
+	for idx, channel := range summary.Channels {
+		require.Equal(t, channels[idx], channel)
+	}
+
+	// A failed lookup aborts the whole summary.
+	api.failTxid = "funding17"
+	_, err = SummarizeChannels(
+		api, channels, numWorkers, nil, nil, btclog.Disabled,
+	)
+	require.ErrorContains(t, err, "API unreachable")
+}
+
+func TestSummarizeChannelsFilter(t *testing.T) {
+	api := &slowAPI{txs: map[string]*TX{
+		"open": {Vout: []*Vout{{Outspend: &Outspend{}}}},
+		"coop": {Vout: []*Vout{{Outspend: &Outspend{
+			Spent: true, Txid: "coopspend", Status: &Status{},
+		}}}},
+		"coopspend": {
+			Vin:  []*Vin{{Sequence: 0xffffffff}},
+			Vout: []*Vout{{Outspend: &Outspend{}}},
+		},
+		"force": {Vout: []*Vout{{Outspend: &Outspend{
+			Spent: true, Txid: "forcespend", Status: &Status{},
+		}}}},
+		"forcespend": {
+			Vin:  []*Vin{{Sequence: 0x80000000}},
+			Vout: []*Vout{{Outspend: &Outspend{}}},
+		},
+	}}
+	newChannel := func(txid, peer string,
+		localBalance uint64) *dataformat.SummaryEntry {
+
+		return &dataformat.SummaryEntry{
+			RemotePubkey: peer,
+			ChannelPoint: txid + ":0",
+			FundingTXID:  txid,
+			LocalBalance: localBalance,
+		}
+	}
+	channels := []*dataformat.SummaryEntry{
+		newChannel("open", "02aa", 50_000),
+		newChannel("coop", "02aa", 50_000),
+		newChannel("force", "02AA", 50_000),
+		newChannel("small", "02aa", 500),
+		newChannel("other", "03bb", 50_000),
+	}
+
+	// Channels that are filtered out before the lookup must never be
+	// requested from the API.
+	api.failTxid = "small"
+	filter := &SummaryFilter{
+		MinLocalBalance: 1_000,
+		Peer:            "02aa",
+	}
+	summary, err := SummarizeChannels(
+		api, channels, 2, nil, filter, btclog.Disabled,
+	)
+	require.NoError(t, err)
+	require.Equal(t, channels[:3], summary.Channels)
+	require.EqualValues(t, 1, summary.OpenChannels)
+	require.EqualValues(t, 1, summary.CoopClosedChannels)
+	require.EqualValues(t, 1, summary.ForceClosedChannels)
+
+	// Only force closed channels are kept, the totals only count those.
+	filter.ForceCloseOnly = true
+	summary, err = SummarizeChannels(
+		api, channels, 2, nil, filter, btclog.Disabled,
+	)
+	require.NoError(t, err)
+	require.Equal(t, channels[2:3], summary.Channels)
+	require.EqualValues(t, 0, summary.OpenChannels)
+	require.EqualValues(t, 0, summary.CoopClosedChannels)
+	require.EqualValues(t, 1, summary.ForceClosedChannels)
+
+	// Without a filter, all channels are looked up.
+	_, err = SummarizeChannels(
+		api, channels, 2, nil, nil, btclog.Disabled,
+	)
+	require.ErrorContains(t, err, "API unreachable")
+}
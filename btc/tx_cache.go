@@ -0,0 +1,127 @@
+package btc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// txCacheRecord is the content of a single file in the transaction cache.
+type txCacheRecord struct {
+	FetchedAt int64 `json:"fetched_at"`
+	Tx        *TX   `json:"tx"`
+}
+
+// TxCache is an on-disk cache of transactions fetched from a chain backend. It
+// stores each transaction in its own file, so multiple commands running at the
+// same time can share the cache.
+type TxCache struct {
+	// Dir is the directory the cached transactions are stored in. It is
+	// created when the first transaction is added.
+	Dir string
+
+	// TTL is the time after which a cached transaction is fetched again,
+	// because new spends of its outputs might have happened since. A
+	// transaction that is confirmed and whose outputs are all spent by
+	// confirmed transactions can't change anymore and never expires.
+	TTL time.Duration
+}
+
+// get returns the cached transaction with the given ID if there is one that
+// hasn't expired yet.
+func (c *TxCache) get(txid string) (*TX, bool) {
+	fileName, ok := c.fileName(txid)
+	if !ok {
+		return nil, false
+	}
+
+	recordBytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, false
+	}
+
+	var record txCacheRecord
+	if err := json.Unmarshal(recordBytes, &record); err != nil ||
+		record.Tx == nil {
+
+		return nil, false
+	}
+
+	age := time.Since(time.Unix(record.FetchedAt, 0))
+	if age > c.TTL && !isFinalTx(record.Tx) {
+		return nil, false
+	}
+
+	return record.Tx, true
+}
+
+// put adds a transaction to the cache. The file is written atomically, so a
+// concurrent reader never sees a partially written record.
+func (c *TxCache) put(tx *TX) error {
+	fileName, ok := c.fileName(tx.TXID)
+	if !ok {
+		return nil
+	}
+
+	recordBytes, err := json.Marshal(&txCacheRecord{
+		FetchedAt: time.Now().Unix(),
+		Tx:        tx,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return fmt.Errorf("error creating cache dir: %w", err)
+	}
+	tempFile, err := os.CreateTemp(c.Dir, tx.TXID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tempFile.Write(recordBytes); err != nil {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), fileName)
+}
+
+// fileName returns the name of the file the transaction with the given ID is
+// cached in. Only valid transaction IDs can be cached.
+func (c *TxCache) fileName(txid string) (string, bool) {
+	if _, err := chainhash.NewHashFromStr(txid); err != nil ||
+		len(txid) != chainhash.MaxHashStringSize {
+
+		return "", false
+	}
+
+	return filepath.Join(c.Dir, txid+".json"), true
+}
+
+// isFinalTx returns true if the transaction is confirmed and all its outputs
+// are spent by confirmed transactions.
+func isFinalTx(tx *TX) bool {
+	if tx.Status == nil || !tx.Status.Confirmed {
+		return false
+	}
+
+	for _, vout := range tx.Vout {
+		if vout.Outspend == nil || !vout.Outspend.Spent ||
+			vout.Outspend.Status == nil ||
+			!vout.Outspend.Status.Confirmed {
+
+			return false
+		}
+	}
+
+	return true
+}
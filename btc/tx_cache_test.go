@@ -0,0 +1,76 @@
+package btc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplorerAPITxCache(t *testing.T) {
+	var (
+		openTxid   = "aa" + strings.Repeat("0", 62)
+		closedTxid = "bb" + strings.Repeat("0", 62)
+	)
+
+	var numRequests int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&numRequests, 1)
+
+			txid := strings.Split(r.URL.Path, "/")[2]
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/outspends"):
+				_, _ = fmt.Fprintf(w, `[{"spent":%v,`+
+					`"status":{"confirmed":true}}]`,
+					txid == closedTxid)
+
+			default:
+				_, _ = w.Write([]byte(`{"txid":"` + txid +
+					`","vout":[{"value":1000}],` +
+					`"status":{"confirmed":true}}`))
+			}
+		},
+	))
+	defer server.Close()
+
+	cache := &TxCache{Dir: t.TempDir(), TTL: time.Hour}
+	api := &ExplorerAPI{BaseURL: server.URL, Cache: cache}
+
+	// The first lookup fetches the transaction and its outspends, the
+	// second one is served from the cache.
+	for i := 0; i < 2; i++ {
+		tx, err := api.Transaction(openTxid)
+		require.NoError(t, err)
+		require.Equal(t, openTxid, tx.TXID)
+		require.False(t, tx.Vout[0].Outspend.Spent)
+	}
+	require.EqualValues(t, 2, atomic.LoadInt32(&numRequests))
+
+	// A new API instance shares the same cache.
+	api = &ExplorerAPI{BaseURL: server.URL, Cache: cache}
+	_, err := api.Transaction(openTxid)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, atomic.LoadInt32(&numRequests))
+
+	// Once the TTL expired, a transaction with unspent outputs is fetched
+	// again, one with all outputs spent isn't.
+	_, err = api.Transaction(closedTxid)
+	require.NoError(t, err)
+	require.EqualValues(t, 4, atomic.LoadInt32(&numRequests))
+
+	cache.TTL = 0
+	_, err = api.Transaction(openTxid)
+	require.NoError(t, err)
+	require.EqualValues(t, 6, atomic.LoadInt32(&numRequests))
+
+	tx, err := api.Transaction(closedTxid)
+	require.NoError(t, err)
+	require.True(t, tx.Vout[0].Outspend.Spent)
+	require.EqualValues(t, 6, atomic.LoadInt32(&numRequests))
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/guggero/chantools/lnd"
+	"github.com/spf13/cobra"
+	"github.com/tv42/zbase32"
+)
+
+const (
+	auditSignatureFormat = `
+Report file:	%s
+Public key:	%x
+Signature:	%s
+
+To verify the report, run:
+chantools verifymessage --msg "$(cat %s)" \
+	--sig %s \
+	--pubkey %x
+`
+)
+
+// auditResult is the JSON result of the audit command.
+type auditResult struct {
+	ReportFile string           `json:"report_file"`
+	PubKey     string           `json:"pubkey"`
+	Signature  string           `json:"signature"`
+	Report     *btc.AuditReport `json:"report"`
+}
+
+type auditCommand struct {
+	SummaryFile string
+
+	chainAPI *chainAPIFlags
+	rootKey  *rootKey
+	cmd      *cobra.Command
+}
+
+func newAuditCommand() *cobra.Command {
+	cc := &auditCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "audit",
+		Short: "Create a signed report of the funds that can be " +
+			"recovered",
+		Long: `Reads a channel summary file created by the summary
+command and creates a report of how much of the local balance of each channel
+can be recovered. The funds of each channel are put into one of the following
+categories:
+  recoverable_now:    our outputs of closed channels that can be swept now
+  recoverable_later:  our outputs that are still time locked (with the block
+                      height and the estimated date they mature at) and
+                      outgoing HTLCs that can be timed out
+  needs_counterparty: the local balance of channels that are still open
+  lost:               outputs that were taken by the remote node because an
+                      old state was published, incoming HTLCs and outputs
+                      whose keys can't be derived from the seed
+
+For closed channels, the keys needed to sweep our outputs are derived from the
+seed and compared to the ones in the summary file.
+
+The report is written as a JSON file and signed with the node identity key, the
+same way lnd's signmessage command does. Recovery services can use it to quote
+a recovery and the node operator can prove the report belongs to their node.`,
+		Example: `chantools audit \
+	--summaryfile results/summary-xxxx-xx-xx.json`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.SummaryFile, "summaryfile", "", "the JSON summary file "+
+			"that contains the channels to audit",
+	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
+
+	cc.rootKey = newRootKey(cc.cmd, "checking the keys and signing")
+
+	return cc.cmd
+}
+
+func (c *auditCommand) Execute(_ *cobra.Command, _ []string) error {
+	if c.SummaryFile == "" {
+		return fmt.Errorf("summary file is required")
+	}
+
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	summaryFile, err := readSummaryFile(c.SummaryFile)
+	if err != nil {
+		return err
+	}
+
+	report, err := auditChannels(
+		c.chainAPI.api(), extendedKey, summaryFile.Channels, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Audited %d channels:", len(report.Channels))
+	log.Infof(" --> recoverable now:    %d sats", report.RecoverableNow)
+	log.Infof(" --> recoverable later:  %d sats", report.RecoverableLater)
+	log.Infof(" --> needs counterparty: %d sats",
+		report.NeedsCounterparty)
+	log.Infof(" --> lost:               %d sats", report.Lost)
+
+	reportBytes, err := json.MarshalIndent(report, "", " ")
+	if err != nil {
+		return err
+	}
+	fileName := resultFileName("audit", "json")
+	log.Infof("Writing result to %s", fileName)
+	err = ioutil.WriteFile(fileName, reportBytes, 0644)
+	if err != nil {
+		return err
+	}
+
+	// The exact content of the file is signed, so anyone can verify it
+	// wasn't changed.
+	pubKey, sig, err := signAuditReport(extendedKey, reportBytes)
+	if err != nil {
+		return err
+	}
+	if JSONOutput {
+		return printJSON(&auditResult{
+			ReportFile: fileName,
+			PubKey:     hex.EncodeToString(pubKey),
+			Signature:  sig,
+			Report:     report,
+		})
+	}
+
+	fmt.Fprintf(
+		textOut(), auditSignatureFormat, fileName, pubKey, sig,
+		fileName, sig, pubKey,
+	)
+
+	return nil
+}
+
+// auditChannels creates the audit report of the given channels and checks
+// that the keys of our outputs can be derived from the seed.
+func auditChannels(api btc.ChainAPI, extendedKey *hdkeychain.ExtendedKey,
+	entries []*dataformat.SummaryEntry, now time.Time) (*btc.AuditReport,
+	error) {
+
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	nodePubKey, err := keyRing.NodePubKey()
+	if err != nil {
+		return nil, fmt.Errorf("could not derive identity key: %w", err)
+	}
+
+	checkKeys := func(entry *dataformat.SummaryEntry) (bool, error) {
+		return checkAuditKeys(keyRing, entry)
+	}
+	report, err := btc.AuditChannels(api, entries, checkKeys, now)
+	if err != nil {
+		return nil, err
+	}
+	report.NodePubkey = hex.EncodeToString(
+		nodePubKey.SerializeCompressed(),
+	)
+	report.Network = chainParams.Name
+
+	return report, nil
+}
+
+// checkAuditKeys makes sure the keys needed to sweep our outputs of the given
+// closed channel can be derived from the seed. It returns false if the summary
+// entry doesn't contain any keys that can be checked.
+func checkAuditKeys(keyRing *lnd.HDKeyRing,
+	entry *dataformat.SummaryEntry) (bool, error) {
+
+	var checked bool
+	if entry.ForceClose != nil && entry.ForceClose.DelayBasePoint != nil {
+		basePoint := entry.ForceClose.DelayBasePoint
+		desc, err := basePoint.Desc()
+		if err != nil {
+			return false, err
+		}
+		derived, err := keyRing.DeriveKey(desc.KeyLocator)
+		if err != nil {
+			return false, fmt.Errorf("error deriving delay base "+
+				"point: %w", err)
+		}
+		if !derived.PubKey.IsEqual(desc.PubKey) {
+			return false, fmt.Errorf("delay base point %s doesn't "+
+				"match the key derived from the seed",
+				basePoint.PubKey)
+		}
+		checked = true
+	}
+
+	if entry.ClosingTX.SweepPrivkey != "" && entry.ClosingTX.OurAddr != "" {
+		wif, err := btcutil.DecodeWIF(entry.ClosingTX.SweepPrivkey)
+		if err != nil {
+			return false, fmt.Errorf("error decoding sweep "+
+				"private key: %w", err)
+		}
+		addr, err := lnd.P2WKHAddr(wif.PrivKey.PubKey(), chainParams)
+		if err != nil {
+			return false, err
+		}
+		if addr.EncodeAddress() != entry.ClosingTX.OurAddr {
+			return false, fmt.Errorf("sweep private key doesn't "+
+				"belong to address %s", entry.ClosingTX.OurAddr)
+		}
+		checked = true
+	}
+
+	return checked, nil
+}
+
+// signAuditReport signs the given report with the node identity key the same
+// way lnd's signmessage command does.
+func signAuditReport(extendedKey *hdkeychain.ExtendedKey,
+	report []byte) ([]byte, string, error) {
+
+	_, pubKey, wif, err := lnd.DeriveKey(
+		extendedKey, lnd.IdentityPath(chainParams), chainParams,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not derive identity key: %w",
+			err)
+	}
+
+	msg := append([]byte(signedMsgPrefix), report...)
+	sig, err := ecdsa.SignCompact(
+		wif.PrivKey, chainhash.DoubleHashB(msg), true,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("error signing report: %w", err)
+	}
+
+	return pubKey.SerializeCompressed(), zbase32.EncodeToString(sig), nil
+}
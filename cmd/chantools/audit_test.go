@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/stretchr/testify/require"
+	"github.com/tv42/zbase32"
+)
+
+func TestAuditKeysAndSignature(t *testing.T) {
+	_ = newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	delayKey, err := keyRing.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyDelayBase,
+		Index:  3,
+	})
+	require.NoError(t, err)
+	entry := &dataformat.SummaryEntry{
+		ClosingTX: &dataformat.ClosingTX{},
+		ForceClose: &dataformat.ForceClose{
+			DelayBasePoint: &dataformat.BasePoint{
+				Family: uint16(keychain.KeyFamilyDelayBase),
+				Index:  3,
+				PubKey: hex.EncodeToString(
+					delayKey.PubKey.SerializeCompressed(),
+				),
+			},
+		},
+	}
+
+	// Nothing to check without keys.
+	checked, err := checkAuditKeys(keyRing, &dataformat.SummaryEntry{
+		ClosingTX: &dataformat.ClosingTX{},
+	})
+	require.NoError(t, err)
+	require.False(t, checked)
+
+	checked, err = checkAuditKeys(keyRing, entry)
+	require.NoError(t, err)
+	require.True(t, checked)
+
+	// The delay base point of another seed doesn't match.
+	wrongKey, err := hdkeychain.NewKeyFromString(rootKeyBip39)
+	require.NoError(t, err)
+	_, err = checkAuditKeys(&lnd.HDKeyRing{
+		ExtendedKey: wrongKey,
+		ChainParams: chainParams,
+	}, entry)
+	require.ErrorContains(t, err, "doesn't match the key derived")
+
+	// The report is signed with the identity key like lnd's signmessage.
+	report := []byte(`{"recoverable_now": 1}`)
+	pubKey, sig, err := signAuditReport(extendedKey, report)
+	require.NoError(t, err)
+
+	nodePubKey, err := keyRing.NodePubKey()
+	require.NoError(t, err)
+	require.Equal(t, nodePubKey.SerializeCompressed(), pubKey)
+
+	sigBytes, err := zbase32.DecodeString(sig)
+	require.NoError(t, err)
+	recovered, _, err := ecdsa.RecoverCompact(
+		sigBytes, chainhash.DoubleHashB(
+			[]byte(signedMsgPrefix+string(report)),
+		),
+	)
+	require.NoError(t, err)
+	require.True(t, recovered.IsEqual(nodePubKey))
+}
+
+func TestAuditJSON(t *testing.T) {
+	h := newHarness(t)
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("800000"))
+		},
+	))
+	defer server.Close()
+
+	summaryFile := h.tempFile("summary.json")
+	err := os.WriteFile(summaryFile, []byte(`{"channels": [{
+		"channel_point": "abcd:0",
+		"chan_exists": false,
+		"local_balance": 1000
+	}]}`), 0600)
+	require.NoError(t, err)
+
+	h.captureJSON()
+	audit := &auditCommand{
+		SummaryFile: summaryFile,
+		chainAPI: &chainAPIFlags{explorerAPI: &explorerAPIFlags{
+			APIURL: []string{server.URL},
+		}},
+		rootKey: &rootKey{RootKey: rootKeyAezeed},
+	}
+	require.NoError(t, audit.Execute(nil, nil))
+
+	var result auditResult
+	h.assertJSONOutput(&result)
+	require.FileExists(t, result.ReportFile)
+	require.Len(t, result.PubKey, 66)
+	require.NotEmpty(t, result.Signature)
+	require.EqualValues(t, 800000, result.Report.Height)
+	require.Len(t, result.Report.Channels, 1)
+	require.Equal(t, "abcd:0", result.Report.Channels[0].ChannelPoint)
+}
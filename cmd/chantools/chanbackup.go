@@ -21,8 +21,20 @@ func newChanBackupCommand() *cobra.Command {
 	cc.cmd = &cobra.Command{
 		Use:   "chanbackup",
 		Short: "Create a channel.backup file from a channel database",
-		Long: `This command creates a new channel.backup from a 
-channel.db file.`,
+		Long: `This command creates a new channel.backup from a
+channel.db file. This is useful if the channel.backup file of a node was lost
+but its channel.db file survived, for example if lnd cannot be started anymore.
+
+The backup contains all open channels (including the ones waiting for a closing
+transaction to confirm) and is encrypted with the root key, the same way lnd
+does it. So it can be used to restore the channels with a fresh lnd instance
+that is created from the same seed (see "lncli restorechanbackup"). The peers
+are then asked to force close the channels with the data loss protection
+protocol. To make sure the backup can actually be restored, the command fails
+if the multisig key of any of the channels can't be derived from the root key.
+
+Use the verify sub command to check the on-chain state of the channels in an
+existing channel.backup file before restoring it.`,
 		Example: `chantools chanbackup \
 	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
 	--multi_file new_channel_backup.backup`,
@@ -39,6 +51,8 @@ channel.db file.`,
 
 	cc.rootKey = newRootKey(cc.cmd, "creating the backup")
 
+	cc.cmd.AddCommand(newChanBackupVerifyCommand())
+
 	return cc.cmd
 }
 
@@ -54,13 +68,15 @@ func (c *chanBackupCommand) Execute(_ *cobra.Command, _ []string) error {
 	}
 
 	// Check that we have a channel DB.
-	if c.ChannelDB == "" {
+	if !channelDBGiven(c.ChannelDB) {
 		return fmt.Errorf("channel DB is required")
 	}
-	db, err := lnd.OpenDB(c.ChannelDB, true)
+	db, err := openChannelDB(c.ChannelDB, true)
 	if err != nil {
 		return fmt.Errorf("error opening rescue DB: %w", err)
 	}
+	defer func() { _ = db.Close() }()
+
 	multiFile := chanbackup.NewMultiFile(c.MultiFile)
 	keyRing := &lnd.HDKeyRing{
 		ExtendedKey: extendedKey,
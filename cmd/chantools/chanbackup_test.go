@@ -1,8 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/dump"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/stretchr/testify/require"
 )
 
@@ -35,3 +45,218 @@ func TestChanBackupAndDumpBackup(t *testing.T) {
 
 	h.assertLogContains(backupContent)
 }
+
+func TestDumpBackupEncodeBackupRoundTrip(t *testing.T) {
+	h := newHarness(t)
+
+	// Create a channel backup from a channel DB file.
+	makeBackup := &chanBackupCommand{
+		ChannelDB: h.testdataFile("channel.db"),
+		MultiFile: h.tempFile("extracted.backup"),
+		rootKey:   &rootKey{RootKey: rootKeyAezeed},
+	}
+
+	err := makeBackup.Execute(nil, nil)
+	require.NoError(t, err)
+
+	extendedKey, err := makeBackup.rootKey.read()
+	require.NoError(t, err)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	// Turn the backup into JSON, then encode the JSON again.
+	original, err := chanbackup.NewMultiFile(
+		makeBackup.MultiFile,
+	).ExtractMulti(keyRing)
+	require.NoError(t, err)
+
+	jsonBytes, err := json.Marshal(&dump.BackupMulti{
+		Version:       original.Version,
+		StaticBackups: dump.BackupDump(original, chainParams),
+	})
+	require.NoError(t, err)
+
+	encodedFile := chanbackup.NewMultiFile(h.tempFile("encoded.backup"))
+	err = encodeChannelBackup(jsonBytes, keyRing, encodedFile)
+	require.NoError(t, err)
+
+	// The re-encoded backup must contain the same information.
+	encoded, err := encodedFile.ExtractMulti(keyRing)
+	require.NoError(t, err)
+	require.Equal(
+		t, dump.BackupDump(original, chainParams),
+		dump.BackupDump(encoded, chainParams),
+	)
+}
+
+func TestEncodeBackupEditedAddress(t *testing.T) {
+	h := newHarness(t)
+
+	makeBackup := &chanBackupCommand{
+		ChannelDB: h.testdataFile("channel.db"),
+		MultiFile: h.tempFile("extracted.backup"),
+		rootKey:   &rootKey{RootKey: rootKeyAezeed},
+	}
+
+	err := makeBackup.Execute(nil, nil)
+	require.NoError(t, err)
+
+	extendedKey, err := makeBackup.rootKey.read()
+	require.NoError(t, err)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	original, err := chanbackup.NewMultiFile(
+		makeBackup.MultiFile,
+	).ExtractMulti(keyRing)
+	require.NoError(t, err)
+
+	// Replace the stale peer address of the first channel.
+	content := &dump.BackupMulti{
+		Version:       original.Version,
+		StaticBackups: dump.BackupDump(original, chainParams),
+	}
+	content.StaticBackups[0].Addresses = []string{"10.0.0.1:9735"}
+	jsonBytes, err := json.Marshal(content)
+	require.NoError(t, err)
+
+	encodedFile := chanbackup.NewMultiFile(h.tempFile("encoded.backup"))
+	err = encodeChannelBackup(jsonBytes, keyRing, encodedFile)
+	require.NoError(t, err)
+
+	encoded, err := encodedFile.ExtractMulti(keyRing)
+	require.NoError(t, err)
+	require.Len(t, encoded.StaticBackups, len(original.StaticBackups))
+	require.Len(t, encoded.StaticBackups[0].Addresses, 1)
+	require.Equal(
+		t, "10.0.0.1:9735",
+		encoded.StaticBackups[0].Addresses[0].String(),
+	)
+
+	// An invalid edit must be rejected without writing a file.
+	content.StaticBackups[0].Addresses = []string{"not an address"}
+	jsonBytes, err = json.Marshal(content)
+	require.NoError(t, err)
+
+	invalidFile := h.tempFile("invalid.backup")
+	err = encodeChannelBackup(
+		jsonBytes, keyRing, chanbackup.NewMultiFile(invalidFile),
+	)
+	require.Error(t, err)
+	require.NoFileExists(t, invalidFile)
+}
+
+func TestChanBackupWrongRootKey(t *testing.T) {
+	h := newHarness(t)
+
+	// A backup encrypted with the wrong root key would be useless.
+	makeBackup := &chanBackupCommand{
+		ChannelDB: h.testdataFile("channel.db"),
+		MultiFile: h.tempFile("extracted.backup"),
+		rootKey:   &rootKey{RootKey: rootKeyBip39},
+	}
+
+	err := makeBackup.Execute(nil, nil)
+	require.ErrorContains(t, err, "cannot be derived from the root key")
+}
+
+func TestReEncryptBackup(t *testing.T) {
+	h := newHarness(t)
+
+	// Create a channel backup from a channel DB file.
+	makeBackup := &chanBackupCommand{
+		ChannelDB: h.testdataFile("channel.db"),
+		MultiFile: h.tempFile("extracted.backup"),
+		rootKey:   &rootKey{RootKey: rootKeyAezeed},
+	}
+
+	err := makeBackup.Execute(nil, nil)
+	require.NoError(t, err)
+
+	// Encrypt it with another root key.
+	reEncrypt := &reEncryptBackupCommand{
+		MultiFile:  makeBackup.MultiFile,
+		NewRootKey: rootKeyBip39,
+		Output:     h.tempFile("reencrypted.backup"),
+		rootKey:    &rootKey{RootKey: rootKeyAezeed},
+	}
+
+	err = reEncrypt.Execute(nil, nil)
+	require.NoError(t, err)
+
+	// The new backup can only be decrypted with the new key.
+	dumpBackup := &dumpBackupCommand{
+		MultiFile: reEncrypt.Output,
+		rootKey:   &rootKey{RootKey: rootKeyAezeed},
+	}
+	err = dumpBackup.Execute(nil, nil)
+	require.Error(t, err)
+
+	dumpBackup.rootKey = &rootKey{RootKey: rootKeyBip39}
+	err = dumpBackup.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(backupContent)
+}
+
+func TestMergeBackups(t *testing.T) {
+	h := newHarness(t)
+
+	extendedKey, err := (&rootKey{RootKey: rootKeyAezeed}).read()
+	require.NoError(t, err)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	// Create two backups with an overlapping channel. The second file is
+	// the most recently modified one, so its version of the channel must
+	// be kept, independent of the order the files are given in.
+	single := func(index uint32,
+		capacity btcutil.Amount) chanbackup.Single {
+
+		privKey, err := btcec.NewPrivateKey()
+		require.NoError(t, err)
+
+		return newSingle(
+			wire.OutPoint{Index: index}, lnwire.ShortChannelID{},
+			privKey.PubKey(), nil, capacity,
+		)
+	}
+	file1 := h.tempFile("first.backup")
+	err = writeBackups(
+		[]chanbackup.Single{single(1, 1000), single(2, 2000)}, keyRing,
+		chanbackup.NewMultiFile(file1),
+	)
+	require.NoError(t, err)
+
+	file2 := h.tempFile("second.backup")
+	err = writeBackups(
+		[]chanbackup.Single{single(2, 3000), single(3, 4000)}, keyRing,
+		chanbackup.NewMultiFile(file2),
+	)
+	require.NoError(t, err)
+	require.NoError(t, os.Chtimes(
+		file1, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour),
+	))
+
+	merged := h.tempFile("merged.backup")
+	err = mergeChannelBackups([]string{file2, file1}, keyRing, merged)
+	require.NoError(t, err)
+
+	multi, err := chanbackup.NewMultiFile(merged).ExtractMulti(keyRing)
+	require.NoError(t, err)
+	require.Len(t, multi.StaticBackups, 3)
+
+	capacities := make(map[uint32]btcutil.Amount)
+	for _, single := range multi.StaticBackups {
+		capacities[single.FundingOutpoint.Index] = single.Capacity
+	}
+	require.Equal(t, map[uint32]btcutil.Amount{
+		1: 1000, 2: 3000, 3: 4000,
+	}, capacities)
+}
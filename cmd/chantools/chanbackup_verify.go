@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/spf13/cobra"
+)
+
+const (
+	backupStateNotFound = "not_found"
+	backupStateOpen     = "open"
+	backupStateClosed   = "closed"
+	backupStateSwept    = "swept"
+
+	// anchorOutputValue is the value of each of the two anchor outputs of
+	// the commitment transaction of an anchor channel.
+	anchorOutputValue = 330
+)
+
+type chanBackupVerifyCommand struct {
+	MultiFile string
+
+	rootKey  *rootKey
+	chainAPI *chainAPIFlags
+	cmd      *cobra.Command
+}
+
+func newChanBackupVerifyCommand() *cobra.Command {
+	cc := &chanBackupVerifyCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "verify",
+		Short: "Check the on-chain state of all channels in a " +
+			"channel.backup file",
+		Long: `Decrypts an lnd channel.backup file and looks up the funding
+output of each channel in it on chain. This can be used to find out which
+channels can still be restored before actually restoring the backup.
+
+The following states are reported for each channel:
+  open:      the funding output is unspent, the channel can be restored
+  closed:    the channel was closed but not all outputs of the closing
+             transaction were spent yet
+  swept:     the channel was closed and all outputs of the closing transaction
+             were spent already
+  not_found: the funding transaction wasn't found on chain
+
+The anchor outputs of a force closed anchor channel are often never swept
+because they aren't worth the fees. They are therefore ignored when deciding
+whether a channel is closed or swept.`,
+		Example: `chantools chanbackup verify \
+	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.MultiFile, "multi_file", "", "lnd channel.backup file to "+
+			"verify",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "decrypting the backup")
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
+
+	return cc.cmd
+}
+
+func (c *chanBackupVerifyCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	// Check that we have a backup file.
+	if c.MultiFile == "" {
+		return fmt.Errorf("backup file is required")
+	}
+	multiFile := chanbackup.NewMultiFile(c.MultiFile)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	multi, err := multiFile.ExtractMulti(keyRing)
+	if err != nil {
+		return fmt.Errorf("could not extract multi file: %w", err)
+	}
+
+	return verifyChannelBackup(c.chainAPI.api(), multi.StaticBackups)
+}
+
+// backupChannelState is the on-chain state of a channel in a backup file.
+type backupChannelState struct {
+	single    *chanbackup.Single
+	state     string
+	closingTx string
+}
+
+// verifyChannelBackup looks up the on-chain state of all given channels and
+// prints the result.
+func verifyChannelBackup(api btc.ChainAPI, singles []chanbackup.Single) error {
+	var (
+		states = make([]*backupChannelState, 0, len(singles))
+		counts = make(map[string]int)
+	)
+	for idx := range singles {
+		single := &singles[idx]
+
+		state, closingTx, err := channelStateOnChain(api, single)
+		if err != nil {
+			return fmt.Errorf("error looking up channel %v: %w",
+				single.FundingOutpoint, err)
+		}
+		log.Infof("Channel %v: %s", single.FundingOutpoint, state)
+
+		states = append(states, &backupChannelState{
+			single:    single,
+			state:     state,
+			closingTx: closingTx,
+		})
+		counts[state]++
+	}
+
+	var (
+		buf bytes.Buffer
+		w   = tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	)
+	_, _ = fmt.Fprintln(w, "Channel point\tRemote peer\tCapacity\t"+
+		"State\tClosing TX")
+	for _, s := range states {
+		closingTx := s.closingTx
+		if closingTx == "" {
+			closingTx = na
+		}
+		_, _ = fmt.Fprintf(w, "%v\t%s\t%d\t%s\t%s\n",
+			s.single.FundingOutpoint, hex.EncodeToString(
+				s.single.RemoteNodePub.SerializeCompressed(),
+			), s.single.Capacity, s.state, closingTx)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	result := buf.String()
+	fmt.Fprintln(resultOut, result)
+
+	// For the tests, also log as trace level which is disabled by default.
+	log.Tracef(result)
+
+	log.Infof("Channels in backup: %d", len(singles))
+	for _, state := range []string{
+		backupStateOpen, backupStateClosed, backupStateSwept,
+		backupStateNotFound,
+	} {
+		log.Infof(" --> %s: %d", state, counts[state])
+	}
+
+	return nil
+}
+
+// channelStateOnChain returns the state of the channel's funding output and
+// the ID of the closing transaction if the channel was closed.
+func channelStateOnChain(api btc.ChainAPI,
+	single *chanbackup.Single) (string, string, error) {
+
+	fundingTx, err := api.Transaction(single.FundingOutpoint.Hash.String())
+	if errors.Is(err, btc.ErrTxNotFound) {
+		return backupStateNotFound, "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	index := int(single.FundingOutpoint.Index)
+	if index >= len(fundingTx.Vout) {
+		return "", "", fmt.Errorf("funding transaction only has %d "+
+			"outputs", len(fundingTx.Vout))
+	}
+
+	outspend := fundingTx.Vout[index].Outspend
+	if outspend == nil || !outspend.Spent {
+		return backupStateOpen, "", nil
+	}
+
+	closingTx, err := api.Transaction(outspend.Txid)
+	if err != nil {
+		return "", "", fmt.Errorf("error looking up closing "+
+			"transaction: %w", err)
+	}
+	for _, vout := range closingTx.Vout {
+		if isAnchorOutput(single, vout) {
+			continue
+		}
+		if vout.Outspend == nil || !vout.Outspend.Spent {
+			return backupStateClosed, outspend.Txid, nil
+		}
+	}
+
+	return backupStateSwept, outspend.Txid, nil
+}
+
+// isAnchorOutput returns true if the channel is an anchor channel and the
+// given output of its closing transaction looks like an anchor output.
+func isAnchorOutput(single *chanbackup.Single, vout *btc.Vout) bool {
+	switch single.Version {
+	case chanbackup.AnchorsCommitVersion,
+		chanbackup.AnchorsZeroFeeHtlcTxCommitVersion,
+		chanbackup.ScriptEnforcedLeaseVersion:
+
+	default:
+		return false
+	}
+
+	return vout.Value == anchorOutputValue &&
+		vout.ScriptPubkeyType == "v0_p2wsh"
+}
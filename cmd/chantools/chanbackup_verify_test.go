@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/stretchr/testify/require"
+)
+
+type mockChainAPI struct {
+	txs map[string]*btc.TX
+}
+
+func (m *mockChainAPI) Transaction(txid string) (*btc.TX, error) {
+	tx, ok := m.txs[txid]
+	if !ok {
+		return nil, btc.ErrTxNotFound
+	}
+
+	return tx, nil
+}
+
+func (m *mockChainAPI) BlockHeight() (uint32, error) {
+	return 0, nil
+}
+
+func TestChannelStateOnChain(t *testing.T) {
+	privKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	spentTo := func(txid string) *btc.Vout {
+		return &btc.Vout{Outspend: &btc.Outspend{
+			Spent: txid != "",
+			Txid:  txid,
+		}}
+	}
+	txid := func(b byte) string {
+		return chainhash.Hash{b}.String()
+	}
+	unsweptAnchor := &btc.Vout{
+		Value:            anchorOutputValue,
+		ScriptPubkeyType: "v0_p2wsh",
+		Outspend:         &btc.Outspend{},
+	}
+	api := &mockChainAPI{txs: map[string]*btc.TX{
+		txid(1): {Vout: []*btc.Vout{spentTo(""), spentTo(txid(4))}},
+		txid(2): {Vout: []*btc.Vout{spentTo(txid(5))}},
+		txid(4): {Vout: []*btc.Vout{spentTo(txid(6)), spentTo("")}},
+		txid(5): {Vout: []*btc.Vout{spentTo(txid(7))}},
+		txid(8): {Vout: []*btc.Vout{spentTo(txid(9))}},
+		txid(9): {Vout: []*btc.Vout{
+			spentTo(txid(10)), unsweptAnchor, unsweptAnchor,
+		}},
+	}}
+
+	testCases := []struct {
+		name      string
+		chanPoint wire.OutPoint
+		version   chanbackup.SingleBackupVersion
+		state     string
+		closingTx string
+	}{{
+		name:      "open",
+		chanPoint: wire.OutPoint{Hash: chainhash.Hash{1}, Index: 0},
+		state:     backupStateOpen,
+	}, {
+		name:      "closed",
+		chanPoint: wire.OutPoint{Hash: chainhash.Hash{1}, Index: 1},
+		state:     backupStateClosed,
+		closingTx: txid(4),
+	}, {
+		name:      "swept",
+		chanPoint: wire.OutPoint{Hash: chainhash.Hash{2}, Index: 0},
+		state:     backupStateSwept,
+		closingTx: txid(5),
+	}, {
+		name:      "anchors not swept",
+		chanPoint: wire.OutPoint{Hash: chainhash.Hash{8}, Index: 0},
+		version:   chanbackup.AnchorsZeroFeeHtlcTxCommitVersion,
+		state:     backupStateSwept,
+		closingTx: txid(9),
+	}, {
+		name:      "non-anchor channel with anchor sized output",
+		chanPoint: wire.OutPoint{Hash: chainhash.Hash{8}, Index: 0},
+		version:   chanbackup.TweaklessCommitVersion,
+		state:     backupStateClosed,
+		closingTx: txid(9),
+	}, {
+		name:      "not found",
+		chanPoint: wire.OutPoint{Hash: chainhash.Hash{3}, Index: 0},
+		state:     backupStateNotFound,
+	}}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			single := &chanbackup.Single{
+				Version:         tc.version,
+				FundingOutpoint: tc.chanPoint,
+				RemoteNodePub:   privKey.PubKey(),
+			}
+			state, closingTx, err := channelStateOnChain(
+				api, single,
+			)
+			require.NoError(t, err)
+			require.Equal(t, tc.state, state)
+			require.Equal(t, tc.closingTx, closingTx)
+		})
+	}
+}
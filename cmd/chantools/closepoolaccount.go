@@ -8,6 +8,7 @@ import (
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/guggero/chantools/btc"
@@ -37,7 +38,6 @@ var (
 )
 
 type closePoolAccountCommand struct {
-	APIURL        string
 	Outpoint      string
 	AuctioneerKey string
 	Publish       bool
@@ -49,8 +49,10 @@ type closePoolAccountCommand struct {
 	MaxNumAccounts  uint32
 	MaxNumBatchKeys uint32
 
-	rootKey *rootKey
-	cmd     *cobra.Command
+	chainAPI *chainAPIFlags
+	rootKey  *rootKey
+	hwSigner *hwSigner
+	cmd      *cobra.Command
 }
 
 func newClosePoolAccountCommand() *cobra.Command {
@@ -63,8 +65,13 @@ poold daemon it can be closed with this command. The account **MUST** have
 expired already, otherwise this command doesn't work since a signature from the
 auctioneer is necessary.
 
-You need to know the account's last unspent outpoint. That can either be
-obtained by running 'pool accounts list' `,
+You need to know one of the account's outpoints. That can either be obtained
+by running 'pool accounts list' or by looking up the transaction that funded the
+account in the lnd wallet. If the given outpoint was spent already (because the
+account participated in a batch or was renewed), the spending transactions are
+followed on chain until the account's latest unspent output is found. If the
+account output was spent without creating a new one, the account was closed
+already and there is nothing left to sweep.`,
 		Example: `chantools closepoolaccount \
 	--outpoint xxxxxxxxx:y \
 	--sweepaddr bc1q..... \
@@ -72,13 +79,10 @@ obtained by running 'pool accounts list' `,
   	--publish`,
 		RunE: cc.Execute,
 	}
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
 	cc.cmd.Flags().StringVar(
-		&cc.APIURL, "apiurl", defaultAPIURL, "API URL to use (must "+
-			"be esplora compatible)",
-	)
-	cc.cmd.Flags().StringVar(
-		&cc.Outpoint, "outpoint", "", "last account outpoint of the "+
-			"account to close (<txid>:<txindex>)",
+		&cc.Outpoint, "outpoint", "", "last or any earlier account "+
+			"outpoint of the account to close (<txid>:<txindex>)",
 	)
 	cc.cmd.Flags().StringVar(
 		&cc.AuctioneerKey, "auctioneerkey", mainnetAuctioneerKeyHex,
@@ -114,6 +118,7 @@ obtained by running 'pool accounts list' `,
 	)
 
 	cc.rootKey = newRootKey(cc.cmd, "deriving keys")
+	cc.hwSigner = newHWSigner(cc.cmd)
 
 	return cc.cmd
 }
@@ -145,27 +150,32 @@ func (c *closePoolAccountCommand) Execute(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("error parsing auctioneer key: %w", err)
 	}
 
-	// Set default values.
-	if c.FeeRate == 0 {
-		c.FeeRate = defaultFeeSatPerVByte
+	api := c.chainAPI.api()
+	c.FeeRate, err = sweepFeeRate(c.cmd, api, c.FeeRate)
+	if err != nil {
+		return err
 	}
 	return closePoolAccount(
-		extendedKey, c.APIURL, outpoint, auctioneerKey, c.SweepAddr,
-		c.Publish, c.FeeRate, c.MinExpiry, c.MinExpiry+c.MaxNumBlocks,
-		c.MaxNumAccounts, c.MaxNumBatchKeys,
+		extendedKey, api, outpoint, auctioneerKey,
+		c.SweepAddr, c.Publish, c.FeeRate, c.MinExpiry,
+		c.MinExpiry+c.MaxNumBlocks, c.MaxNumAccounts, c.MaxNumBatchKeys,
+		c.hwSigner,
 	)
 }
 
-func closePoolAccount(extendedKey *hdkeychain.ExtendedKey, apiURL string,
+func closePoolAccount(extendedKey *hdkeychain.ExtendedKey, api btc.SweepAPI,
 	outpoint *wire.OutPoint, auctioneerKey *btcec.PublicKey,
 	sweepAddr string, publish bool, feeRate uint16, minExpiry,
-	maxNumBlocks, maxNumAccounts, maxNumBatchKeys uint32) error {
+	maxNumBlocks, maxNumAccounts, maxNumBatchKeys uint32,
+	hw *hwSigner) error {
 
-	signer := &lnd.Signer{
+	signer, err := hw.wrap(&lnd.Signer{
 		ExtendedKey: extendedKey,
 		ChainParams: chainParams,
+	})
+	if err != nil {
+		return err
 	}
-	api := &btc.ExplorerAPI{BaseURL: apiURL}
 
 	tx, err := api.Transaction(outpoint.Hash.String())
 	if err != nil {
@@ -173,10 +183,11 @@ func closePoolAccount(extendedKey *hdkeychain.ExtendedKey, apiURL string,
 			outpoint.Hash.String(), err)
 	}
 
-	txOut := tx.Vout[outpoint.Index]
-	if txOut.Outspend.Spent {
-		return fmt.Errorf("outpoint %v is already spent", outpoint)
+	if int(outpoint.Index) >= len(tx.Vout) {
+		return fmt.Errorf("TX %s has no output %d",
+			outpoint.Hash.String(), outpoint.Index)
 	}
+	txOut := tx.Vout[outpoint.Index]
 
 	pkScript, err := hex.DecodeString(txOut.ScriptPubkey)
 	if err != nil {
@@ -186,11 +197,6 @@ func closePoolAccount(extendedKey *hdkeychain.ExtendedKey, apiURL string,
 	log.Debugf("Brute forcing pk script %x for outpoint %v", pkScript,
 		outpoint)
 
-	script, err := txscript.ParsePkScript(pkScript)
-	if err != nil {
-		return fmt.Errorf("error parsing pk script: %w", err)
-	}
-
 	// Let's derive the account key family's extended key first.
 	path := []uint32{
 		lnd.HardenedKeyStart + uint32(keychain.BIP0043Purpose),
@@ -198,28 +204,13 @@ func closePoolAccount(extendedKey *hdkeychain.ExtendedKey, apiURL string,
 		lnd.HardenedKeyStart + uint32(poolscript.AccountKeyFamily),
 		0,
 	}
-	accountBaseKey, err := lnd.DeriveChildren(extendedKey, path)
+	accountBaseKey, err := lnd.DeriveFromRoot(extendedKey, path)
 	if err != nil {
 		return fmt.Errorf("error deriving account base key: %w", err)
 	}
 
 	// Try our luck.
-	var (
-		acct           *poolAccount
-		accountVersion account.Version
-	)
-	switch script.Class() {
-	case txscript.WitnessV0ScriptHashTy:
-		accountVersion = account.VersionInitialNoVersion
-
-	case txscript.WitnessV1TaprootTy:
-		accountVersion = account.VersionTaprootEnabled
-
-	default:
-		return fmt.Errorf("unsupported script class %v", script.Class())
-	}
-
-	acct, err = bruteForceAccountScript(
+	acct, err := bruteForceAccountScript(
 		accountBaseKey, auctioneerKey, minExpiry, maxNumBlocks,
 		maxNumAccounts, maxNumBatchKeys, pkScript,
 	)
@@ -229,6 +220,26 @@ func closePoolAccount(extendedKey *hdkeychain.ExtendedKey, apiURL string,
 
 	log.Debugf("Found pool account %s", acct.String())
 
+	acct, outpoint, txOut, err = followPoolAccount(
+		api, accountBaseKey, auctioneerKey, acct, outpoint, txOut,
+		minExpiry, maxNumBlocks, maxNumBatchKeys,
+	)
+	if err != nil {
+		return err
+	}
+	pkScript, err = hex.DecodeString(txOut.ScriptPubkey)
+	if err != nil {
+		return fmt.Errorf("error decoding pk script %s: %w",
+			txOut.ScriptPubkey, err)
+	}
+
+	// The account might have been upgraded to a Taproot account in one
+	// of the batches it participated in.
+	accountVersion := account.VersionTaprootEnabled
+	if acct.version == poolscript.VersionWitnessScript {
+		accountVersion = account.VersionInitialNoVersion
+	}
+
 	sweepTx := wire.NewMsgTx(2)
 	sweepTx.LockTime = acct.expiry
 	sweepValue := int64(txOut.Value)
@@ -310,30 +321,26 @@ func closePoolAccount(extendedKey *hdkeychain.ExtendedKey, apiURL string,
 		)
 	}
 
-	var buf bytes.Buffer
-	err = sweepTx.Serialize(&buf)
-	if err != nil {
-		return err
+	if hw.exporting() {
+		return hw.export(sweepTx)
 	}
 
-	// Publish TX.
-	if publish {
-		response, err := api.PublishTx(
-			hex.EncodeToString(buf.Bytes()),
-		)
-		if err != nil {
-			return err
-		}
-		log.Infof("Published TX %s, response: %s",
-			sweepTx.TxHash().String(), response)
+	inputs := []*txResultInput{{
+		Outpoint: outpoint.String(),
+		Value:    sweepValue,
+	}}
+	results := newTxResults()
+	err = results.addTx(api, sweepTx, inputs, publish)
+	if err != nil {
+		return err
 	}
 
-	log.Infof("Transaction: %x", buf.Bytes())
-	return nil
+	return results.print()
 }
 
 type poolAccount struct {
 	keyIndex      uint32
+	batchKeyIndex uint32
 	expiry        uint32
 	sharedKey     [32]byte
 	batchKey      []byte
@@ -344,78 +351,180 @@ type poolAccount struct {
 }
 
 func (a *poolAccount) String() string {
-	return fmt.Sprintf("key_index=%d, expiry=%d, shared_key=%x, "+
-		"batch_key=%x, key_tweak=%x, witness_script=%x, version=%d",
-		a.keyIndex, a.expiry, a.sharedKey[:], a.batchKey, a.keyTweak,
+	return fmt.Sprintf("key_index=%d, batch_key_index=%d, expiry=%d, "+
+		"shared_key=%x, batch_key=%x, key_tweak=%x, "+
+		"witness_script=%x, version=%d", a.keyIndex, a.batchKeyIndex,
+		a.expiry, a.sharedKey[:], a.batchKey, a.keyTweak,
 		a.witnessScript, a.version)
 }
 
+// followPoolAccount follows the spending transactions of the given account
+// output until the account's latest unspent output is found. Every time the
+// account participates in a batch or is renewed, its output is spent and a new
+// one with a later batch key and possibly a new expiry is created.
+func followPoolAccount(api btc.ChainAPI, accountBaseKey *hdkeychain.ExtendedKey,
+	auctioneerKey *btcec.PublicKey, acct *poolAccount,
+	outpoint *wire.OutPoint, txOut *btc.Vout, minExpiry, maxExpiry,
+	maxNumBatchKeys uint32) (*poolAccount, *wire.OutPoint, *btc.Vout,
+	error) {
+
+	for txOut.Outspend != nil && txOut.Outspend.Spent {
+		spendTxid := txOut.Outspend.Txid
+		log.Infof("Account output %v was spent in TX %s, looking for "+
+			"the new account output", outpoint, spendTxid)
+
+		spendTx, err := api.Transaction(spendTxid)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error looking up "+
+				"TX %s: %w", spendTxid, err)
+		}
+
+		// The expiry only changes if the account is renewed, so we
+		// first try the current one for all outputs before brute
+		// forcing the whole expiry range.
+		newAcct, idx := findPoolAccountOutput(
+			accountBaseKey, auctioneerKey, acct, spendTx,
+			acct.expiry, acct.expiry, maxNumBatchKeys,
+		)
+		if newAcct == nil {
+			log.Infof("No account output with expiry %d found in "+
+				"TX %s, brute forcing the expiry", acct.expiry,
+				spendTxid)
+			newAcct, idx = findPoolAccountOutput(
+				accountBaseKey, auctioneerKey, acct, spendTx,
+				minExpiry, maxExpiry, maxNumBatchKeys,
+			)
+		}
+		if newAcct == nil {
+			return nil, nil, nil, fmt.Errorf("account output %v "+
+				"was spent in TX %s without creating a new "+
+				"account output, the account was closed "+
+				"already", outpoint, spendTxid)
+		}
+
+		hash, err := chainhash.NewHashFromStr(spendTxid)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error parsing TX "+
+				"ID %s: %w", spendTxid, err)
+		}
+		acct = newAcct
+		outpoint = wire.NewOutPoint(hash, uint32(idx))
+		txOut = spendTx.Vout[idx]
+		log.Infof("Found new account output %v: %s", outpoint,
+			acct.String())
+	}
+
+	return acct, outpoint, txOut, nil
+}
+
+// findPoolAccountOutput looks for an output of the given transaction that
+// belongs to the same account as the given one, but with the same or a later
+// batch key.
+func findPoolAccountOutput(accountBaseKey *hdkeychain.ExtendedKey,
+	auctioneerKey *btcec.PublicKey, acct *poolAccount, tx *btc.TX,
+	minExpiry, maxExpiry, maxNumBatchKeys uint32) (*poolAccount, int) {
+
+	for idx, txOut := range tx.Vout {
+		pkScript, err := hex.DecodeString(txOut.ScriptPubkey)
+		if err != nil {
+			continue
+		}
+
+		newAcct, err := bruteForceAccountKey(
+			accountBaseKey, auctioneerKey, acct.keyIndex,
+			acct.batchKeyIndex, minExpiry, maxExpiry,
+			maxNumBatchKeys, pkScript,
+		)
+		if err == nil {
+			return newAcct, idx
+		}
+	}
+
+	return nil, 0
+}
+
 func bruteForceAccountScript(accountBaseKey *hdkeychain.ExtendedKey,
 	auctioneerKey *btcec.PublicKey, minExpiry, maxExpiry, maxNumAccounts,
 	maxNumBatchKeys uint32, targetScript []byte) (*poolAccount, error) {
 
 	// The outermost loop is over the possible accounts.
 	for i := uint32(0); i < maxNumAccounts; i++ {
-		accountExtendedKey, err := accountBaseKey.DeriveNonStandard(i)
-		if err != nil {
-			return nil, fmt.Errorf("error deriving account key: "+
-				"%w", err)
+		acct, err := bruteForceAccountKey(
+			accountBaseKey, auctioneerKey, i, 0, minExpiry,
+			maxExpiry, maxNumBatchKeys, targetScript,
+		)
+		if err == nil {
+			return acct, nil
 		}
 
-		accountPrivKey, err := accountExtendedKey.ECPrivKey()
-		if err != nil {
-			return nil, fmt.Errorf("error deriving private key: "+
-				"%w", err)
-		}
-		log.Debugf("Trying trader key %x...",
-			accountPrivKey.PubKey().SerializeCompressed())
+		log.Debugf("Tried account index %d of %d", i, maxNumAccounts)
+	}
 
-		sharedKey, err := lnd.ECDH(accountPrivKey, auctioneerKey)
-		if err != nil {
-			return nil, fmt.Errorf("error deriving shared key: "+
-				"%w", err)
-		}
+	return nil, fmt.Errorf("account script not derived")
+}
 
-		// The next loop is over the batch keys.
-		batchKeyIndex := uint32(0)
-		currentBatchKey := initialBatchKey
-		for batchKeyIndex < maxNumBatchKeys {
-			// And then finally the loop over the actual account
-			// expiry in blocks.
-			acct, err := fastScript(
-				i, minExpiry, maxExpiry,
-				accountPrivKey.PubKey(), auctioneerKey,
-				currentBatchKey, sharedKey, targetScript,
-			)
-			if err == nil {
-				return acct, nil
-			}
+// bruteForceAccountKey tries all batch keys starting at the given batch key
+// index and all expiries to derive the target script with the account key of
+// the given index.
+func bruteForceAccountKey(accountBaseKey *hdkeychain.ExtendedKey,
+	auctioneerKey *btcec.PublicKey, keyIndex, firstBatchKeyIndex,
+	minExpiry, maxExpiry, maxNumBatchKeys uint32,
+	targetScript []byte) (*poolAccount, error) {
+
+	accountExtendedKey, err := accountBaseKey.DeriveNonStandard(keyIndex)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving account key: %w", err)
+	}
+
+	accountPrivKey, err := accountExtendedKey.ECPrivKey()
+	if err != nil {
+		return nil, fmt.Errorf("error deriving private key: %w", err)
+	}
+	log.Debugf("Trying trader key %x...",
+		accountPrivKey.PubKey().SerializeCompressed())
+
+	sharedKey, err := lnd.ECDH(accountPrivKey, auctioneerKey)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving shared key: %w", err)
+	}
+
+	// The next loop is over the batch keys.
+	currentBatchKey := initialBatchKey
+	for i := uint32(0); i < firstBatchKeyIndex; i++ {
+		currentBatchKey = poolscript.IncrementKey(currentBatchKey)
+	}
+	batchKeyIndex := firstBatchKeyIndex
+	for batchKeyIndex < maxNumBatchKeys {
+		// And then finally the loop over the actual account expiry in
+		// blocks.
+		acct, err := fastScript(
+			keyIndex, minExpiry, maxExpiry,
+			accountPrivKey.PubKey(), auctioneerKey,
+			currentBatchKey, sharedKey, targetScript,
+		)
+		if err != nil {
 			acct, err = fastScriptTaproot(
-				poolscript.VersionTaprootMuSig2, i, minExpiry,
-				maxExpiry, accountPrivKey.PubKey(),
+				poolscript.VersionTaprootMuSig2, keyIndex,
+				minExpiry, maxExpiry, accountPrivKey.PubKey(),
 				auctioneerKey, currentBatchKey, sharedKey,
 				targetScript,
 			)
-			if err == nil {
-				return acct, nil
-			}
+		}
+		if err != nil {
 			acct, err = fastScriptTaproot(
-				poolscript.VersionTaprootMuSig2V100RC2, i,
-				minExpiry, maxExpiry,
+				poolscript.VersionTaprootMuSig2V100RC2,
+				keyIndex, minExpiry, maxExpiry,
 				accountPrivKey.PubKey(), auctioneerKey,
 				currentBatchKey, sharedKey, targetScript,
 			)
-			if err == nil {
-				return acct, nil
-			}
-
-			currentBatchKey = poolscript.IncrementKey(
-				currentBatchKey,
-			)
-			batchKeyIndex++
+		}
+		if err == nil {
+			acct.batchKeyIndex = batchKeyIndex
+			return acct, nil
 		}
 
-		log.Debugf("Tried account index %d of %d", i, maxNumAccounts)
+		currentBatchKey = poolscript.IncrementKey(currentBatchKey)
+		batchKeyIndex++
 	}
 
 	return nil, fmt.Errorf("account script not derived")
@@ -7,6 +7,9 @@ import (
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/lnd"
 	"github.com/lightninglabs/pool/poolscript"
 	"github.com/lightningnetwork/lnd/keychain"
@@ -96,3 +99,128 @@ func TestClosePoolAccount(t *testing.T) {
 		})
 	}
 }
+
+func TestFollowPoolAccount(t *testing.T) {
+	path := []uint32{
+		lnd.HardenedKeyStart + uint32(keychain.BIP0043Purpose),
+		lnd.HardenedKeyStart + chaincfg.RegressionNetParams.HDCoinType,
+		lnd.HardenedKeyStart + uint32(poolscript.AccountKeyFamily),
+		0,
+	}
+	const (
+		maxBlocks    = 50
+		maxAccounts  = 5
+		maxBatchKeys = 10
+	)
+
+	// We start with the segwit (v0) account.
+	tc := testAccounts[2]
+	extendedKey, err := hdkeychain.NewKeyFromString(tc.rootKey)
+	require.NoError(t, err)
+	accountBaseKey, err := lnd.DeriveChildren(extendedKey, path)
+	require.NoError(t, err)
+	targetScriptBytes, err := hex.DecodeString(tc.pkScript)
+	require.NoError(t, err)
+	acct, err := bruteForceAccountScript(
+		accountBaseKey, auctioneerKey, tc.minExpiry,
+		tc.minExpiry+maxBlocks, maxAccounts, maxBatchKeys,
+		targetScriptBytes,
+	)
+	require.NoError(t, err)
+
+	accountKey, err := accountBaseKey.DeriveNonStandard(acct.keyIndex)
+	require.NoError(t, err)
+	traderKey, err := accountKey.ECPubKey()
+	require.NoError(t, err)
+	accountScript := func(version poolscript.Version, batchKeyIndex,
+		expiry uint32) string {
+
+		batchKey := initialBatchKey
+		for i := uint32(0); i < batchKeyIndex; i++ {
+			batchKey = poolscript.IncrementKey(batchKey)
+		}
+		script, err := poolscript.AccountScript(
+			version, expiry, traderKey, auctioneerKey, batchKey,
+			acct.sharedKey,
+		)
+		require.NoError(t, err)
+
+		return hex.EncodeToString(script)
+	}
+
+	txid := func(b byte) string {
+		return chainhash.Hash{b}.String()
+	}
+	output := func(pkScript, spentIn string) *btc.Vout {
+		return &btc.Vout{
+			ScriptPubkey: pkScript,
+			Outspend: &btc.Outspend{
+				Spent: spentIn != "",
+				Txid:  spentIn,
+			},
+		}
+	}
+	otherScript := "0020" + txid(9)
+
+	// The account participates in a batch, is then renewed and upgraded
+	// to Taproot and finally participates in another batch.
+	api := &mockChainAPI{txs: map[string]*btc.TX{
+		txid(1): {Vout: []*btc.Vout{
+			output(otherScript, ""),
+			output(tc.pkScript, txid(2)),
+		}},
+		txid(2): {Vout: []*btc.Vout{
+			output(otherScript, ""),
+			output(accountScript(
+				poolscript.VersionWitnessScript, 2,
+				acct.expiry,
+			), txid(3)),
+		}},
+		txid(3): {Vout: []*btc.Vout{
+			output(accountScript(
+				poolscript.VersionTaprootMuSig2V100RC2, 2,
+				acct.expiry+20,
+			), txid(4)),
+		}},
+		txid(4): {Vout: []*btc.Vout{
+			output(otherScript, ""),
+			output(otherScript, ""),
+			output(accountScript(
+				poolscript.VersionTaprootMuSig2V100RC2, 5,
+				acct.expiry+20,
+			), ""),
+		}},
+		txid(5): {Vout: []*btc.Vout{
+			output(tc.pkScript, txid(6)),
+		}},
+		txid(6): {Vout: []*btc.Vout{
+			output(otherScript, ""),
+		}},
+	}}
+
+	outpoint, err := lnd.ParseOutpoint(txid(1) + ":1")
+	require.NoError(t, err)
+	latest, latestOutpoint, txOut, err := followPoolAccount(
+		api, accountBaseKey, auctioneerKey, acct, outpoint,
+		api.txs[txid(1)].Vout[1], tc.minExpiry,
+		tc.minExpiry+maxBlocks, maxBatchKeys,
+	)
+	require.NoError(t, err)
+	require.Equal(t, txid(4)+":2", latestOutpoint.String())
+	require.Equal(t, api.txs[txid(4)].Vout[2], txOut)
+	require.Equal(t, acct.keyIndex, latest.keyIndex)
+	require.EqualValues(t, 5, latest.batchKeyIndex)
+	require.Equal(t, acct.expiry+20, latest.expiry)
+	require.Equal(
+		t, poolscript.VersionTaprootMuSig2V100RC2, latest.version,
+	)
+
+	// An account that was closed can't be followed.
+	outpoint = &wire.OutPoint{Hash: chainhash.Hash{5}}
+	_, _, _, err = followPoolAccount(
+		api, accountBaseKey, auctioneerKey, acct, outpoint,
+		api.txs[txid(5)].Vout[0], tc.minExpiry,
+		tc.minExpiry+maxBlocks, maxBatchKeys,
+	)
+	require.ErrorContains(t, err, "the account was closed already")
+}
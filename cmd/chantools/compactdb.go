@@ -1,21 +1,38 @@
 package main
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/coreos/bbolt"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/lncfg"
 	"github.com/spf13/cobra"
 )
 
 const (
 	dbFilePermission = 0600
 	defaultTxMaxSize = 65536
+
+	// bboltMagic, bboltMetaPageFlag and bboltPageHeaderSize describe the
+	// start of every bbolt database file.
+	bboltMagic          = 0xED0CDAED
+	bboltMetaPageFlag   = 0x04
+	bboltPageHeaderSize = 16
 )
 
 type compactDBCommand struct {
 	TxMaxSize int64
 	SourceDB  string
 	DestDB    string
+	LndDir    string
+	DestDir   string
 
 	cmd *cobra.Command
 }
@@ -24,13 +41,36 @@ func newCompactDBCommand() *cobra.Command {
 	cc := &compactDBCommand{}
 	cc.cmd = &cobra.Command{
 		Use: "compactdb",
-		Short: "Create a copy of a channel.db file in safe/read-only " +
-			"mode",
+		Short: "Create a copy of a bbolt database file in " +
+			"safe/read-only mode",
 		Long: `This command opens a database in read-only mode and tries
-to create a copy of it to a destination file, compacting it in the process.`,
+to create a copy of it to a destination file, compacting it in the process.
+Any of lnd's bbolt database files can be compacted, for example channel.db,
+wallet.db, macaroons.db, sphinxreplay.db or the watchtower databases.
+
+With --lnddir all bbolt databases in lnd's data directory are compacted at
+once. They are detected by their file header, so the file names don't matter.
+Each database is copied to the same relative path in --destdir and its backup
+to the same relative path in the working directory. A database that can't be
+compacted doesn't stop the others from being compacted.
+
+Before anything else, an exact copy of the source database is written to
+<name>-backup-<timestamp>.db in the working directory. A full bbolt consistency
+check is run on the source database before and on the destination database
+after the compaction. Errors in the source database are only reported because
+compacting is often the way to rescue the data of a corrupted database, but the
+command fails if the compacted database isn't consistent. The page and freelist
+statistics of both databases are reported at the end.
+
+The destination database must not exist yet, so the source database can never
+be overwritten.`,
 		Example: `chantools compactdb \
 	--sourcedb ~/.lnd/data/graph/mainnet/channel.db \
-	--destdb ./results/compacted.db`,
+	--destdb ./results/compacted.db
+
+chantools compactdb \
+	--lnddir ~/.lnd \
+	--destdir ./results/lnd-compacted`,
 		RunE: cc.Execute,
 	}
 	cc.cmd.Flags().Int64Var(
@@ -38,51 +78,376 @@ to create a copy of it to a destination file, compacting it in the process.`,
 			"transaction size",
 	)
 	cc.cmd.Flags().StringVar(
-		&cc.SourceDB, "sourcedb", "", "lnd channel.db file to create "+
-			"the database backup from",
+		&cc.SourceDB, "sourcedb", "", "lnd bbolt database file to "+
+			"create the database backup from",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.DestDB, "destdb", "", "new lnd bbolt database file to "+
+			"copy the compacted database to",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.LndDir, "lnddir", "", "lnd directory to compact all bbolt "+
+			"database files of instead of a single --sourcedb",
 	)
 	cc.cmd.Flags().StringVar(
-		&cc.DestDB, "destdb", "", "new lnd channel.db file to copy "+
-			"the compacted database to",
+		&cc.DestDir, "destdir", "", "new directory to copy the "+
+			"compacted databases of --lnddir to",
 	)
 
 	return cc.cmd
 }
 
 func (c *compactDBCommand) Execute(_ *cobra.Command, _ []string) error {
-	// Check that we have a source and destination channel DB.
+	if c.TxMaxSize <= 0 {
+		c.TxMaxSize = defaultTxMaxSize
+	}
+
+	if c.LndDir != "" {
+		if c.SourceDB != "" || c.DestDB != "" {
+			return fmt.Errorf("--lnddir cannot be combined with " +
+				"--sourcedb or --destdb")
+		}
+		if c.DestDir == "" {
+			return fmt.Errorf("destination directory is required")
+		}
+
+		results, err := c.compactDir(
+			lncfg.CleanAndExpandPath(c.LndDir),
+			lncfg.CleanAndExpandPath(c.DestDir),
+		)
+		if err != nil {
+			return err
+		}
+
+		// All databases are attempted, even if one of them fails.
+		if err := printJSON(results); err != nil {
+			return err
+		}
+		var numFailed int
+		for _, result := range results {
+			if result.Error != "" {
+				numFailed++
+			}
+		}
+		if numFailed > 0 {
+			return fmt.Errorf("%d of %d databases could not be "+
+				"compacted", numFailed, len(results))
+		}
+
+		return nil
+	}
+
+	// Check that we have a source and destination DB.
 	if c.SourceDB == "" {
-		return fmt.Errorf("source channel DB is required")
+		return fmt.Errorf("source DB is required")
 	}
 	if c.DestDB == "" {
-		return fmt.Errorf("destination channel DB is required")
+		return fmt.Errorf("destination DB is required")
 	}
-	if c.TxMaxSize <= 0 {
-		c.TxMaxSize = defaultTxMaxSize
+	sourceDB := lncfg.CleanAndExpandPath(c.SourceDB)
+	isBbolt, err := isBboltFile(sourceDB)
+	if err != nil {
+		return fmt.Errorf("error reading source DB: %w", err)
+	}
+	if !isBbolt {
+		return fmt.Errorf("source DB %s is not a bbolt database",
+			sourceDB)
+	}
+
+	result, err := c.compactFile(
+		sourceDB, lncfg.CleanAndExpandPath(c.DestDB), WorkDir,
+	)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(result)
+}
+
+// compactDir compacts all bbolt databases found in the given lnd data
+// directory into the same relative location in the destination directory.
+// The backups are written to the same relative location in the working
+// directory.
+func (c *compactDBCommand) compactDir(lndDir,
+	destDir string) ([]*compactDBResult, error) {
+
+	// Collect all files before writing anything, so we never compact a
+	// file we created ourselves.
+	var dbFiles []string
+	err := filepath.Walk(lndDir, func(path string, info os.FileInfo,
+		err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if path == destDir || path == WorkDir {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		isBbolt, err := isBboltFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", path, err)
+		}
+		if isBbolt {
+			dbFiles = append(dbFiles, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking lnd directory: %w", err)
+	}
+	if len(dbFiles) == 0 {
+		return nil, fmt.Errorf("no bbolt databases found in %s", lndDir)
+	}
+
+	results := make([]*compactDBResult, 0, len(dbFiles))
+	for _, dbFile := range dbFiles {
+		relPath, err := filepath.Rel(lndDir, dbFile)
+		if err != nil {
+			return nil, err
+		}
+		destDB := filepath.Join(destDir, relPath)
+		backupDir := filepath.Join(WorkDir, filepath.Dir(relPath))
+
+		log.Infof("Compacting %s", dbFile)
+		result, err := c.compactFile(dbFile, destDB, backupDir)
+		if err != nil {
+			log.Errorf("Error compacting %s: %v", dbFile, err)
+			result = &compactDBResult{
+				SourceDB: dbFile,
+				DestDB:   destDB,
+				Error:    err.Error(),
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// compactFile checks and compacts the source DB into the new destination DB
+// after writing a backup of it to the given backup directory.
+func (c *compactDBCommand) compactFile(sourceDB, destDB,
+	backupDir string) (*compactDBResult, error) {
+
+	// Make sure we never write to the source DB.
+	srcInfo, err := os.Stat(sourceDB)
+	if err != nil {
+		return nil, fmt.Errorf("error reading source DB: %w", err)
+	}
+	dstInfo, err := os.Stat(destDB)
+	switch {
+	case err == nil && os.SameFile(srcInfo, dstInfo):
+		return nil, fmt.Errorf("destination DB cannot be the source " +
+			"DB")
+
+	case err == nil:
+		return nil, fmt.Errorf("destination DB %s already exists",
+			destDB)
+
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("error reading destination DB: %w", err)
+	}
+
+	for _, dir := range []string{backupDir, filepath.Dir(destDB)} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("error creating directory %s: "+
+				"%w", dir, err)
+		}
 	}
-	src, err := c.openDB(c.SourceDB, true)
+
+	src, err := c.openDB(sourceDB, true)
 	if err != nil {
-		return fmt.Errorf("error opening source DB: %w", err)
+		return nil, fmt.Errorf("error opening source DB: %w", err)
 	}
 	defer func() { _ = src.Close() }()
 
-	dst, err := c.openDB(c.DestDB, false)
+	result := &compactDBResult{
+		SourceDB:   sourceDB,
+		DestDB:     destDB,
+		BackupFile: backupFileName(backupDir, sourceDB),
+	}
+	log.Infof("Writing backup of source DB to %s", result.BackupFile)
+	err = src.View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(result.BackupFile, dbFilePermission)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error writing backup of source DB: %w",
+			err)
+	}
+
+	// A corrupted source DB is the main reason to compact it, so we only
+	// report the errors.
+	log.Infof("Checking consistency of source DB")
+	result.SourceErrors, err = checkDB(src)
 	if err != nil {
-		return fmt.Errorf("error opening destination DB: %w", err)
+		return nil, fmt.Errorf("error checking source DB: %w", err)
+	}
+	if len(result.SourceErrors) > 0 {
+		log.Warnf("Source DB has %d consistency errors, compacting "+
+			"anyway", len(result.SourceErrors))
+	}
+	result.Before, err = statsDB(src)
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := c.openDB(destDB, false)
+	if err != nil {
+		return nil, fmt.Errorf("error opening destination DB: %w", err)
 	}
 	defer func() { _ = dst.Close() }()
 
 	err = c.compact(dst, src)
 	if err != nil {
-		return fmt.Errorf("error compacting DB: %w", err)
+		return nil, fmt.Errorf("error compacting DB: %w", err)
+	}
+
+	log.Infof("Checking consistency of destination DB")
+	dstErrors, err := checkDB(dst)
+	if err != nil {
+		return nil, fmt.Errorf("error checking destination DB: %w", err)
+	}
+	if len(dstErrors) > 0 {
+		return nil, fmt.Errorf("destination DB has %d consistency "+
+			"errors, first one: %s", len(dstErrors), dstErrors[0])
+	}
+	result.After, err = statsDB(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Source DB:      %v", result.Before)
+	log.Infof("Destination DB: %v", result.After)
+
+	return result, nil
+}
+
+// compactDBResult is the result of the compactdb command.
+type compactDBResult struct {
+	SourceDB     string   `json:"source_db"`
+	DestDB       string   `json:"dest_db"`
+	BackupFile   string   `json:"backup_file,omitempty"`
+	SourceErrors []string `json:"source_errors,omitempty"`
+	Before       *dbStats `json:"before,omitempty"`
+	After        *dbStats `json:"after,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// dbStats holds the page and freelist statistics of a bbolt database.
+type dbStats struct {
+	FileSize      int64 `json:"file_size"`
+	PageSize      int   `json:"page_size"`
+	PageCount     int64 `json:"page_count"`
+	FreePages     int   `json:"free_pages"`
+	PendingPages  int   `json:"pending_pages"`
+	FreeBytes     int   `json:"free_bytes"`
+	FreelistBytes int   `json:"freelist_bytes"`
+}
+
+// String returns a human readable summary of the statistics.
+func (s *dbStats) String() string {
+	return fmt.Sprintf("%d bytes, %d pages of %d bytes, %d free pages "+
+		"(%d bytes), %d pending pages, freelist uses %d bytes",
+		s.FileSize, s.PageCount, s.PageSize, s.FreePages, s.FreeBytes,
+		s.PendingPages, s.FreelistBytes)
+}
+
+// backupFileName returns the name of the timestamped backup of the given
+// database file in the given directory.
+func backupFileName(dir, dbFile string) string {
+	base := filepath.Base(dbFile)
+	ext := filepath.Ext(base)
+
+	return filepath.Join(dir, fmt.Sprintf("%s-backup-%s%s",
+		strings.TrimSuffix(base, ext),
+		time.Now().Format(resultTimestampFormat), ext))
+}
+
+// isBboltFile returns true if the given file starts with a bbolt meta page.
+// This allows us to find the databases of lnd, like channel.db, wallet.db,
+// macaroons.db, sphinxreplay.db or the watchtower databases, without knowing
+// their names.
+func isBboltFile(fileName string) (bool, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = f.Close() }()
+
+	// The first page starts with the page header (id, flags, count and
+	// overflow) followed by the meta data that starts with the magic.
+	var header [bboltPageHeaderSize + 4]byte
+	_, err = io.ReadFull(f, header[:])
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return false, nil
+
+	case err != nil:
+		return false, err
 	}
-	return nil
+
+	flags := binary.LittleEndian.Uint16(header[8:10])
+	magic := binary.LittleEndian.Uint32(header[bboltPageHeaderSize:])
+
+	return flags == bboltMetaPageFlag && magic == bboltMagic, nil
+}
+
+// checkDB runs the bbolt consistency check on the given database and returns
+// all errors it found.
+func checkDB(db *bbolt.DB) ([]string, error) {
+	var checkErrors []string
+	err := db.View(func(tx *bbolt.Tx) error {
+		for err := range tx.Check() {
+			log.Errorf("Consistency error in %s: %v", db.Path(),
+				err)
+			checkErrors = append(checkErrors, err.Error())
+		}
+
+		return nil
+	})
+
+	return checkErrors, err
+}
+
+// statsDB collects the page and freelist statistics of the given database.
+func statsDB(db *bbolt.DB) (*dbStats, error) {
+	fileInfo, err := os.Stat(db.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	freelist := db.Stats()
+	stats := &dbStats{
+		FileSize:      fileInfo.Size(),
+		PageSize:      db.Info().PageSize,
+		FreePages:     freelist.FreePageN,
+		PendingPages:  freelist.PendingPageN,
+		FreeBytes:     freelist.FreeAlloc,
+		FreelistBytes: freelist.FreelistInuse,
+	}
+	err = db.View(func(tx *bbolt.Tx) error {
+		stats.PageCount = tx.Size() / int64(stats.PageSize)
+		return nil
+	})
+
+	return stats, err
 }
 
 func (c *compactDBCommand) openDB(path string, ro bool) (*bbolt.DB, error) {
 	options := &bbolt.Options{
 		NoFreelistSync: false,
 		FreelistType:   bbolt.FreelistMapType,
+		Timeout:        lnd.DefaultOpenTimeout,
 		ReadOnly:       ro,
 	}
 
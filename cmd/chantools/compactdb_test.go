@@ -1,8 +1,12 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/coreos/bbolt"
 	"github.com/stretchr/testify/require"
 )
 
@@ -19,6 +23,25 @@ func TestCompactDBAndDumpChannels(t *testing.T) {
 	require.NoError(t, err)
 
 	require.FileExists(t, compact.DestDB)
+	h.assertLogContains("Checking consistency of destination DB")
+
+	// An exact copy of the source DB is written to the working directory.
+	backups, err := filepath.Glob(
+		filepath.Join(h.tempDir, "channel-backup-*.db"),
+	)
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	require.Equal(
+		t, h.fileSize(compact.SourceDB), h.fileSize(backups[0]),
+	)
+
+	// The source DB or an existing DB is never overwritten.
+	err = compact.Execute(nil, nil)
+	require.ErrorContains(t, err, "already exists")
+	compact.DestDB = compact.SourceDB
+	err = compact.Execute(nil, nil)
+	require.ErrorContains(t, err, "cannot be the source DB")
+	compact.DestDB = h.tempFile("compacted.db")
 
 	// Compacting small DBs actually increases the size slightly. But we
 	// just want to make sure the contents match.
@@ -44,3 +67,70 @@ func TestCompactDBAndDumpChannels(t *testing.T) {
 
 	h.assertLogEqual(sourceDump, destDump)
 }
+
+func TestCompactDBLndDir(t *testing.T) {
+	h := newHarness(t)
+
+	// Create an lnd directory with a channel DB, a second bbolt DB with a
+	// name we don't know and a file that isn't a database at all.
+	lndDir := filepath.Join(h.tempDir, "lnd")
+	graphDir := filepath.Join(lndDir, "data", "graph", "regtest")
+	chainDir := filepath.Join(lndDir, "data", "chain", "bitcoin", "regtest")
+	require.NoError(t, os.MkdirAll(graphDir, 0700))
+	require.NoError(t, os.MkdirAll(chainDir, 0700))
+
+	channelDB, err := ioutil.ReadFile(h.testdataFile("channel.db"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(graphDir, "channel.db"), channelDB, 0600,
+	))
+
+	macaroonDB, err := bbolt.Open(
+		filepath.Join(chainDir, "macaroons.dat"), 0600, nil,
+	)
+	require.NoError(t, err)
+	require.NoError(t, macaroonDB.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("macrootkeys"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("0"), []byte("root key"))
+	}))
+	require.NoError(t, macaroonDB.Close())
+
+	tlsCert := filepath.Join(lndDir, "tls.cert")
+	require.NoError(t, ioutil.WriteFile(tlsCert, []byte("cert"), 0600))
+	isBbolt, err := isBboltFile(tlsCert)
+	require.NoError(t, err)
+	require.False(t, isBbolt)
+
+	// The destination directory is inside the lnd directory to make sure
+	// we don't compact the files we create.
+	compact := &compactDBCommand{
+		LndDir:  lndDir,
+		DestDir: filepath.Join(lndDir, "compacted"),
+	}
+	require.NoError(t, compact.Execute(nil, nil))
+
+	destDir := compact.DestDir
+	require.FileExists(
+		t, filepath.Join(destDir, "data", "graph", "regtest",
+			"channel.db"),
+	)
+	require.FileExists(
+		t, filepath.Join(destDir, "data", "chain", "bitcoin",
+			"regtest", "macaroons.dat"),
+	)
+	require.NoFileExists(t, filepath.Join(destDir, "tls.cert"))
+
+	backups, err := filepath.Glob(filepath.Join(
+		h.tempDir, "data", "*", "*", "*", "macaroons-backup-*.dat",
+	))
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+
+	// Compacting again fails for every database, as the destination
+	// files already exist.
+	err = compact.Execute(nil, nil)
+	require.ErrorContains(t, err, "2 of 2 databases could not be compacted")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lncfg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	defaultConfigFile = "~/.chantools/config"
+
+	// configEnvPrefix is the prefix of the environment variables that can
+	// be used to set the value of any flag. The rest of the name is the
+	// upper case name of the flag, for example CHANTOOLS_APIURL.
+	configEnvPrefix = "CHANTOOLS_"
+
+	// configGlobalSection is the name of the config file section that
+	// applies to all commands.
+	configGlobalSection = ""
+)
+
+var (
+	// ConfigFile is the path to the config file that is read before
+	// running any command.
+	ConfigFile string
+)
+
+// config holds the flag values of the config file, grouped by the command they
+// apply to. The values that are set before the first section header apply to
+// all commands.
+type config map[string]map[string]string
+
+// parseConfig parses the content of a config file. The format is a simple
+// INI-like list of "flag = value" lines. Lines starting with # or ; are
+// comments. Values below a [command] section header only apply to that
+// command, sub commands use the full path, for example
+// [zombierecovery makeoffer].
+func parseConfig(content []byte) (config, error) {
+	cfg := config{configGlobalSection: make(map[string]string)}
+	section := configGlobalSection
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "", strings.HasPrefix(line, "#"),
+			strings.HasPrefix(line, ";"):
+
+			continue
+
+		case strings.HasPrefix(line, "[") &&
+			strings.HasSuffix(line, "]"):
+
+			section = strings.Join(
+				strings.Fields(line[1:len(line)-1]), " ",
+			)
+			if section == "" {
+				return nil, fmt.Errorf("line %d: empty "+
+					"section name", lineNum)
+			}
+			if _, ok := cfg[section]; !ok {
+				cfg[section] = make(map[string]string)
+			}
+
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected 'flag = "+
+				"value', got '%s'", lineNum, line)
+		}
+
+		name := strings.TrimSpace(parts[0])
+		value := strings.Trim(strings.TrimSpace(parts[1]), `"`)
+		if name == "" {
+			return nil, fmt.Errorf("line %d: missing flag name",
+				lineNum)
+		}
+		cfg[section][name] = value
+	}
+
+	return cfg, scanner.Err()
+}
+
+// readConfig reads the config file with the given name. A missing file is only
+// an error if it isn't the default config file.
+func readConfig(fileName string) (config, error) {
+	content, err := ioutil.ReadFile(lncfg.CleanAndExpandPath(fileName))
+	switch {
+	case errors.Is(err, os.ErrNotExist) && fileName == defaultConfigFile:
+		return config{}, nil
+
+	case err != nil:
+		return nil, fmt.Errorf("error reading config file %s: %w",
+			fileName, err)
+	}
+
+	cfg, err := parseConfig(content)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w",
+			fileName, err)
+	}
+
+	return cfg, nil
+}
+
+// configEnvName returns the name of the environment variable for the flag with
+// the given name.
+func configEnvName(flagName string) string {
+	return configEnvPrefix + strings.ToUpper(
+		strings.ReplaceAll(flagName, "-", "_"),
+	)
+}
+
+// commandSection returns the name of the config file section of a command,
+// which is its full path without the name of the root command.
+func commandSection(cmd *cobra.Command) string {
+	path := cmd.CommandPath()
+	if cmd.HasParent() {
+		path = strings.TrimPrefix(path, cmd.Root().Name()+" ")
+	}
+
+	return path
+}
+
+// applyConfig sets all flags of the given command that weren't set on the
+// command line. The value is taken from the CHANTOOLS_<FLAG> environment
+// variable, the section of the command in the config file or the global part
+// of the config file, in that order.
+func applyConfig(cmd *cobra.Command, cfg config) error {
+	section := commandSection(cmd)
+	flags := cmd.Flags()
+
+	// A typo in a command section would silently be ignored otherwise.
+	for name := range cfg[section] {
+		if section != configGlobalSection && flags.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag '%s' in config file "+
+				"section [%s]", name, section)
+		}
+	}
+
+	var setErr error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if setErr != nil || f.Changed || f.Name == "configfile" {
+			return
+		}
+
+		value, ok := os.LookupEnv(configEnvName(f.Name))
+		if !ok {
+			value, ok = cfg[section][f.Name]
+		}
+		if !ok {
+			value, ok = cfg[configGlobalSection][f.Name]
+		}
+		if !ok {
+			return
+		}
+
+		if err := flags.Set(f.Name, value); err != nil {
+			setErr = fmt.Errorf("invalid value '%s' for flag "+
+				"--%s: %w", value, f.Name, err)
+		}
+	})
+
+	return setErr
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+# Values for all commands.
+apiurl = https://mempool.space/api
+feerate = 5
+unknownflag = ignored
+
+[sweepremoteclosed]
+feerate = 10
+; Quotes are optional.
+sweepaddr = "bc1qsweep"
+
+[zombierecovery   makeoffer]
+feerate = 20
+`
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig([]byte(testConfig))
+	require.NoError(t, err)
+
+	require.Equal(t, config{
+		configGlobalSection: {
+			"apiurl":      "https://mempool.space/api",
+			"feerate":     "5",
+			"unknownflag": "ignored",
+		},
+		"sweepremoteclosed": {
+			"feerate":   "10",
+			"sweepaddr": "bc1qsweep",
+		},
+		"zombierecovery makeoffer": {
+			"feerate": "20",
+		},
+	}, cfg)
+
+	_, err = parseConfig([]byte("feerate 5"))
+	require.ErrorContains(t, err, "line 1: expected 'flag = value'")
+
+	_, err = parseConfig([]byte("\n[ ]"))
+	require.ErrorContains(t, err, "line 2: empty section name")
+}
+
+func TestApplyConfig(t *testing.T) {
+	_ = newHarness(t)
+
+	cfg, err := parseConfig([]byte(testConfig))
+	require.NoError(t, err)
+
+	var (
+		apiURL, sweepAddr string
+		feeRate           uint32
+		publish           bool
+	)
+	newCmd := func() *cobra.Command {
+		root := &cobra.Command{Use: "chantools"}
+		cmd := &cobra.Command{Use: "sweepremoteclosed"}
+		cmd.Flags().StringVar(&apiURL, "apiurl", "", "")
+		cmd.Flags().StringVar(&sweepAddr, "sweepaddr", "", "")
+		cmd.Flags().Uint32Var(&feeRate, "feerate", 30, "")
+		cmd.Flags().BoolVar(&publish, "publish", false, "")
+		root.AddCommand(cmd)
+		return cmd
+	}
+
+	// The command section overwrites the global values.
+	cmd := newCmd()
+	require.NoError(t, cmd.ParseFlags(nil))
+	require.NoError(t, applyConfig(cmd, cfg))
+	require.Equal(t, "https://mempool.space/api", apiURL)
+	require.Equal(t, "bc1qsweep", sweepAddr)
+	require.EqualValues(t, 10, feeRate)
+	require.False(t, publish)
+	require.True(t, cmd.Flags().Changed("feerate"))
+
+	// Environment variables overwrite the config file, the command line
+	// overwrites both.
+	require.NoError(t, os.Setenv("CHANTOOLS_FEERATE", "15"))
+	require.NoError(t, os.Setenv("CHANTOOLS_PUBLISH", "true"))
+	require.NoError(t, os.Setenv("CHANTOOLS_SWEEPADDR", "bc1qenv"))
+	cmd = newCmd()
+	require.NoError(t, cmd.ParseFlags([]string{"--sweepaddr", "bc1qcli"}))
+	require.NoError(t, applyConfig(cmd, cfg))
+	require.EqualValues(t, 15, feeRate)
+	require.True(t, publish)
+	require.Equal(t, "bc1qcli", sweepAddr)
+
+	// Invalid values are reported.
+	require.NoError(t, os.Setenv("CHANTOOLS_FEERATE", "fast"))
+	cmd = newCmd()
+	require.NoError(t, cmd.ParseFlags(nil))
+	require.ErrorContains(t, applyConfig(cmd, cfg), "invalid value "+
+		"'fast' for flag --feerate")
+
+	// Unknown flags in a command section are reported.
+	require.NoError(t, os.Unsetenv("CHANTOOLS_FEERATE"))
+	cfg["sweepremoteclosed"]["feerat"] = "1"
+	cmd = newCmd()
+	require.NoError(t, cmd.ParseFlags(nil))
+	require.ErrorContains(t, applyConfig(cmd, cfg), "unknown flag "+
+		"'feerat' in config file section [sweepremoteclosed]")
+}
+
+func TestReadConfig(t *testing.T) {
+	// Only a missing custom config file is an error.
+	cfg, err := readConfig(defaultConfigFile + ".doesnotexist")
+	require.Error(t, err)
+	require.Nil(t, cfg)
+
+	h := newHarness(t)
+	fileName := h.tempFile("config")
+	require.NoError(t, ioutil.WriteFile(
+		fileName, []byte(testConfig), 0600,
+	))
+
+	cfg, err = readConfig(fileName)
+	require.NoError(t, err)
+	require.Equal(t, "10", cfg["sweepremoteclosed"]["feerate"])
+}
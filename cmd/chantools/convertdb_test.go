@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/guggero/chantools/lnd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertDB(t *testing.T) {
+	h := newHarness(t)
+
+	// Only the SQL backends can be converted to or from.
+	convert := &convertDBCommand{
+		ChannelDB: h.tempFile("channel.sqlite"),
+		BoltDB:    h.testdataFile("channel.db"),
+	}
+	err := convert.Execute(nil, nil)
+	require.ErrorContains(t, err, "--dbbackend must be")
+
+	// The conversion itself works between any two backends, so we can
+	// test it with a new bolt DB as the destination.
+	src, err := lnd.OpenBackend(&lnd.DBConfig{
+		Path: h.testdataFile("channel.db"),
+	}, true, false)
+	require.NoError(t, err)
+
+	dstCfg := &lnd.DBConfig{Path: h.tempFile("converted.db")}
+	dst, err := lnd.OpenBackend(dstCfg, false, true)
+	require.NoError(t, err)
+
+	result, err := convertDB(dst, src)
+	require.NoError(t, err)
+	require.EqualValues(t, 48, result.Buckets)
+	require.EqualValues(t, 101, result.Keys)
+
+	// Converting into a DB that already has content is refused.
+	_, err = convertDB(dst, src)
+	require.ErrorContains(t, err, "destination DB is not empty")
+
+	require.NoError(t, src.Close())
+	require.NoError(t, dst.Close())
+
+	// Compare the content of the source and destination DB by looking at
+	// the logged dump.
+	dump := &dumpChannelsCommand{
+		ChannelDB: h.testdataFile("channel.db"),
+	}
+	h.clearLog()
+	require.NoError(t, dump.Execute(nil, nil))
+	sourceDump := h.getLog()
+
+	h.clearLog()
+	dump.ChannelDB = dstCfg.Path
+	require.NoError(t, dump.Execute(nil, nil))
+	h.assertLogEqual(sourceDump, h.getLog())
+}
@@ -0,0 +1,882 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/sweep"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lncfg"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultDaemonListen    = "localhost:8787"
+	defaultDaemonTokenFile = "daemon.token"
+
+	// daemonMaxRequestSize is the maximum size of a request body in bytes.
+	daemonMaxRequestSize = 10 * 1024 * 1024
+
+	// daemonMaxWorkers is the maximum number of concurrent channel lookups
+	// a summary request can ask for.
+	daemonMaxWorkers = 16
+
+	daemonInputSummary         = "summary"
+	daemonInputListChannels    = "listchannels"
+	daemonInputPendingChannels = "pendingchannels"
+)
+
+var (
+	// errSigningDisabled is returned if a request asks for a signed
+	// transaction but the daemon wasn't started with --allowsigning.
+	errSigningDisabled = errors.New("signing is disabled, start the " +
+		"daemon with --allowsigning to create signed transactions")
+)
+
+type daemonCommand struct {
+	Listen       string
+	AllowSigning bool
+	TokenFile    string
+	LndDir       string
+
+	chainAPI *chainAPIFlags
+	rootKey  *rootKey
+	cmd      *cobra.Command
+}
+
+func newDaemonCommand() *cobra.Command {
+	cc := &daemonCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "daemon",
+		Short: "Run chantools as a daemon that exposes the summary, " +
+			"dump and sweep operations over a REST API",
+		Long: `Starts an HTTP server with a JSON REST API that allows
+other services (for example a recovery web service) to run the summary, dump
+and sweep operations of chantools without having to parse log output. All
+requests and responses are JSON encoded, errors are returned as
+{"error": "..."} with a non-2xx status code.
+
+The following endpoints are available:
+  GET  /v1/info               network and whether signing is enabled
+  POST /v1/summary            like the summary command
+  POST /v1/dumpchannels       like the dumpchannels command with JSON output
+  POST /v1/sweeptimelock      like the sweeptimelock command
+  POST /v1/sweepremoteclosed  like the sweepremoteclosed command
+  POST /v1/combinepsbt        like the offlinesweep combine command
+
+The channels for the summary and sweeptimelock endpoints are passed in the
+"channels" field, in the format given by the "format" field (summary,
+listchannels or pendingchannels).
+
+By default, the sweep endpoints only construct unsigned PSBTs that can be
+signed with 'chantools offlinesweep sign' or a hardware wallet. For the
+sweepremoteclosed endpoint the extended public key of the payment base key
+family account must be passed as "accountxpub" in that case. Only if the
+daemon is started with --allowsigning, the seed is read on startup and requests
+with "sign": true return signed transactions. Transactions are never
+published by the daemon.
+
+On startup, a random access token is written to --tokenfile (readable by the
+current user only). Every request must contain it in the header
+"Authorization: Bearer <token>". Requests with a Host header other than the
+--listen address or with an Origin header of a different site are rejected, to
+protect against DNS rebinding attacks from web pages. The channel DB of the
+dumpchannels endpoint must be inside --lnddir or the working directory.`,
+		Example: `chantools daemon --listen localhost:8787
+
+curl -X POST localhost:8787/v1/sweeptimelock \
+	-H "Authorization: Bearer $(cat results/daemon.token)" \
+	-d '{"format":"summary","channels":{...},"sweepaddr":"bc1q..."}'`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Listen, "listen", defaultDaemonListen, "the host:port to "+
+			"listen on for REST API requests",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.AllowSigning, "allowsigning", false, "read the seed on "+
+			"startup and allow requests to create signed "+
+			"transactions",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.TokenFile, "tokenfile", "", "the file to write the access "+
+			"token to; if empty, "+defaultDaemonTokenFile+" in "+
+			"the working directory is used",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.LndDir, "lnddir", "", "lnd directory the channel DBs of "+
+			"dumpchannels requests may be read from, in addition "+
+			"to the working directory",
+	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
+	cc.rootKey = newRootKey(cc.cmd, "signing sweep transactions")
+
+	return cc.cmd
+}
+
+func (c *daemonCommand) Execute(_ *cobra.Command, _ []string) error {
+	d := &daemon{
+		api:         c.chainAPI.api(),
+		explorerAPI: c.chainAPI.explorerAPI.api(),
+		host:        c.Listen,
+		dbDirs:      []string{WorkDir},
+		cmd:         c.cmd,
+	}
+	if c.LndDir != "" {
+		d.dbDirs = append(
+			d.dbDirs, lncfg.CleanAndExpandPath(c.LndDir),
+		)
+	}
+
+	tokenFile := c.TokenFile
+	if tokenFile == "" {
+		tokenFile = filepath.Join(WorkDir, defaultDaemonTokenFile)
+	}
+	var err error
+	d.token, err = writeDaemonToken(lncfg.CleanAndExpandPath(tokenFile))
+	if err != nil {
+		return err
+	}
+	if c.AllowSigning {
+		extendedKey, err := c.rootKey.read()
+		if err != nil {
+			return fmt.Errorf("error reading root key: %w", err)
+		}
+		d.signer = &lnd.Signer{
+			ExtendedKey: extendedKey,
+			ChainParams: chainParams,
+		}
+	}
+
+	server := &http.Server{
+		Addr:              c.Listen,
+		Handler:           d.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	errChan := make(chan error, 1)
+	go func() {
+		log.Infof("Listening for REST API requests on %s (signing "+
+			"enabled: %v), access token written to %s", c.Listen,
+			c.AllowSigning, tokenFile)
+		errChan <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+
+	case <-interrupt:
+		log.Infof("Received interrupt, shutting down.")
+		ctx, cancel := context.WithTimeout(
+			context.Background(), 10*time.Second,
+		)
+		defer cancel()
+
+		return server.Shutdown(ctx)
+	}
+}
+
+// writeDaemonToken creates a new random access token and writes it to the
+// given file that only the current user can read.
+func writeDaemonToken(fileName string) (string, error) {
+	var tokenBytes [32]byte
+	if _, err := rand.Read(tokenBytes[:]); err != nil {
+		return "", fmt.Errorf("error creating access token: %w", err)
+	}
+	token := hex.EncodeToString(tokenBytes[:])
+
+	// An existing file might have been created with different
+	// permissions, which WriteFile wouldn't change.
+	err := os.Remove(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("error removing old token file: %w", err)
+	}
+	if err := os.WriteFile(fileName, []byte(token), 0600); err != nil {
+		return "", fmt.Errorf("error writing token file: %w", err)
+	}
+
+	return token, nil
+}
+
+// daemon holds the state that is shared by all REST API requests.
+type daemon struct {
+	api         btc.SweepAPI
+	explorerAPI *btc.ExplorerAPI
+
+	// signer is only set if signing is enabled.
+	signer *lnd.Signer
+
+	// token is the access token every request must contain.
+	token string
+
+	// host is the only host name requests are accepted for.
+	host string
+
+	// dbDirs are the directories channel DBs may be opened from.
+	dbDirs []string
+
+	cmd *cobra.Command
+}
+
+// handler returns the HTTP handler that serves all REST API endpoints.
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/info", endpoint(http.MethodGet, d.info))
+	mux.HandleFunc("/v1/summary", endpoint(http.MethodPost, d.summary))
+	mux.HandleFunc(
+		"/v1/dumpchannels", endpoint(http.MethodPost, d.dumpChannels),
+	)
+	mux.HandleFunc(
+		"/v1/sweeptimelock", endpoint(http.MethodPost, d.sweepTimeLock),
+	)
+	mux.HandleFunc(
+		"/v1/sweepremoteclosed",
+		endpoint(http.MethodPost, d.sweepRemoteClosed),
+	)
+	mux.HandleFunc(
+		"/v1/combinepsbt", endpoint(http.MethodPost, d.combinePsbt),
+	)
+
+	return d.authenticate(mux)
+}
+
+// authenticate returns an HTTP handler that only passes requests to the given
+// handler if they contain the access token and were sent to the configured
+// host by a client that isn't a web page of another site.
+func (d *daemon) authenticate(next http.Handler) http.Handler {
+	forbidden := func(w http.ResponseWriter, r *http.Request,
+		err error) {
+
+		log.Errorf("Rejected request %s: %v", r.URL.Path, err)
+		writeError(w, &httpError{
+			status: http.StatusForbidden,
+			err:    err,
+		})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Host, d.host) {
+			forbidden(w, r, fmt.Errorf("invalid host %s", r.Host))
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+		sameOrigin := strings.EqualFold(origin, "http://"+d.host) ||
+			strings.EqualFold(origin, "https://"+d.host)
+		if origin != "" && !sameOrigin {
+			forbidden(w, r, fmt.Errorf("invalid origin %s", origin))
+			return
+		}
+
+		token := strings.TrimPrefix(
+			r.Header.Get("Authorization"), "Bearer ",
+		)
+		if d.token == "" || subtle.ConstantTimeCompare(
+			[]byte(token), []byte(d.token),
+		) != 1 {
+
+			writeError(w, &httpError{
+				status: http.StatusUnauthorized,
+				err:    errors.New("invalid access token"),
+			})
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, daemonMaxRequestSize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// channelDBPath returns the cleaned path of the given channel DB if it is
+// inside one of the directories channel DBs may be opened from.
+func (d *daemon) channelDBPath(path string) (string, error) {
+	// The Postgres backend doesn't use a path.
+	if path == "" {
+		return "", nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(lncfg.CleanAndExpandPath(path))
+	if err != nil {
+		return "", badRequest(fmt.Errorf("invalid channel DB path: %w",
+			err))
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return "", badRequest(err)
+	}
+
+	for _, dir := range d.dbDirs {
+		dir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		dir, err = filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+
+		parent := ".." + string(filepath.Separator)
+		rel, err := filepath.Rel(dir, resolved)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, parent) {
+
+			continue
+		}
+
+		return resolved, nil
+	}
+
+	return "", &httpError{
+		status: http.StatusForbidden,
+		err: fmt.Errorf("channel DB must be inside the lnd or " +
+			"working directory"),
+	}
+}
+
+// httpError is an error with the HTTP status code it should be returned with.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func (e *httpError) Unwrap() error {
+	return e.err
+}
+
+// badRequest marks the error as caused by an invalid request.
+func badRequest(err error) error {
+	return &httpError{status: http.StatusBadRequest, err: err}
+}
+
+// endpoint returns an HTTP handler that only accepts requests with the given
+// method and writes the result of the given function as JSON.
+func endpoint(method string,
+	fn func(*http.Request) (interface{}, error)) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeError(w, &httpError{
+				status: http.StatusMethodNotAllowed,
+				err:    fmt.Errorf("method must be %s", method),
+			})
+			return
+		}
+
+		log.Infof("Handling request %s", r.URL.Path)
+		resp, err := fn(r)
+		if err != nil {
+			log.Errorf("Request %s failed: %v", r.URL.Path, err)
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// decodeRequest decodes the JSON body of the request into the given target.
+func decodeRequest(r *http.Request, target interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return &httpError{
+				status: http.StatusRequestEntityTooLarge,
+				err: fmt.Errorf("request is larger than %d "+
+					"bytes", maxBytesErr.Limit),
+			}
+		}
+
+		return badRequest(fmt.Errorf("error decoding request: %w",
+			err))
+	}
+
+	return nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		status = httpErr.status
+	}
+
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+type daemonInfoResponse struct {
+	Network        string `json:"network"`
+	SigningEnabled bool   `json:"signing_enabled"`
+}
+
+func (d *daemon) info(_ *http.Request) (interface{}, error) {
+	return &daemonInfoResponse{
+		Network:        chainParams.Name,
+		SigningEnabled: d.signer != nil,
+	}, nil
+}
+
+// channelsInput is the channel input of a request, in one of the formats the
+// --fromsummary, --listchannels and --pendingchannels flags accept.
+type channelsInput struct {
+	Format   string          `json:"format"`
+	Channels json.RawMessage `json:"channels"`
+}
+
+// entries parses the channel input into summary entries.
+func (i *channelsInput) entries() ([]*dataformat.SummaryEntry, error) {
+	var target dataformat.InputFile
+	switch i.Format {
+	case "", daemonInputSummary:
+		target = &dataformat.SummaryEntryFile{}
+
+	case daemonInputListChannels:
+		target = &dataformat.ListChannelsFile{}
+
+	case daemonInputPendingChannels:
+		target = &dataformat.PendingChannelsFile{}
+
+	default:
+		return nil, badRequest(fmt.Errorf("invalid channel format "+
+			"'%s'", i.Format))
+	}
+
+	if len(i.Channels) == 0 {
+		return nil, badRequest(fmt.Errorf("channels are required"))
+	}
+	if err := json.Unmarshal(i.Channels, target); err != nil {
+		return nil, badRequest(fmt.Errorf("error decoding channels: "+
+			"%w", err))
+	}
+
+	entries, err := target.AsSummaryEntries()
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	return entries, nil
+}
+
+type daemonSummaryRequest struct {
+	channelsInput
+
+	Workers         int    `json:"workers"`
+	ForceCloseOnly  bool   `json:"force_close_only"`
+	MinLocalBalance uint64 `json:"min_local_balance"`
+	Peer            string `json:"peer"`
+}
+
+func (d *daemon) summary(r *http.Request) (interface{}, error) {
+	req := &daemonSummaryRequest{}
+	if err := decodeRequest(r, req); err != nil {
+		return nil, err
+	}
+
+	entries, err := req.entries()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case req.Workers <= 0:
+		req.Workers = defaultSummaryWorkers
+
+	case req.Workers > daemonMaxWorkers:
+		return nil, badRequest(fmt.Errorf("workers must not be more "+
+			"than %d", daemonMaxWorkers))
+	}
+
+	return btc.SummarizeChannels(
+		d.api, entries, req.Workers, nil, &btc.SummaryFilter{
+			ForceCloseOnly:  req.ForceCloseOnly,
+			MinLocalBalance: req.MinLocalBalance,
+			Peer:            req.Peer,
+		}, log,
+	)
+}
+
+type daemonDumpChannelsRequest struct {
+	ChannelDB    string `json:"channeldb"`
+	State        string `json:"state"`
+	ChannelPoint string `json:"channel_point"`
+	Peer         string `json:"peer"`
+}
+
+func (d *daemon) dumpChannels(r *http.Request) (interface{}, error) {
+	req := &daemonDumpChannelsRequest{}
+	if err := decodeRequest(r, req); err != nil {
+		return nil, err
+	}
+
+	if !channelDBGiven(req.ChannelDB) {
+		return nil, badRequest(fmt.Errorf("channel DB is required"))
+	}
+
+	var filter *dumpFilter
+	if req.ChannelPoint != "" || req.Peer != "" {
+		filter = &dumpFilter{
+			channelPoint: req.ChannelPoint,
+			peer:         req.Peer,
+		}
+	}
+
+	dbPath, err := d.channelDBPath(req.ChannelDB)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openChannelDB(dbPath, true)
+	if err != nil {
+		return nil, fmt.Errorf("error opening channel DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	return dumpChannelState(db.ChannelStateDB(), req.State, filter)
+}
+
+// sweepOptions are the options shared by all sweep requests.
+type sweepOptions struct {
+	SweepAddr string `json:"sweepaddr"`
+	FeeRate   uint16 `json:"feerate"`
+
+	// Sign requests a signed transaction instead of an unsigned PSBT.
+	Sign bool `json:"sign"`
+
+	// Fingerprint is the hex encoded master key fingerprint to add to
+	// the BIP32 derivations of an unsigned PSBT.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// sweepResponse is the result of a sweep request. Either the signed raw
+// transaction or the unsigned PSBT is set.
+type sweepResponse struct {
+	TxID      string `json:"txid"`
+	RawTx     string `json:"raw_tx,omitempty"`
+	Psbt      string `json:"psbt,omitempty"`
+	NumInputs int    `json:"num_inputs"`
+	Fee       int64  `json:"fee"`
+}
+
+// prepare validates the sweep options and returns the pkScript to sweep to and
+// the fee rate to use.
+func (d *daemon) prepare(opts *sweepOptions) ([]byte, error) {
+	if opts.SweepAddr == "" {
+		return nil, badRequest(fmt.Errorf("sweep addr is required"))
+	}
+	if opts.Sign && d.signer == nil {
+		return nil, &httpError{
+			status: http.StatusForbidden,
+			err:    errSigningDisabled,
+		}
+	}
+
+	sweepScript, err := lnd.GetP2WPKHScript(opts.SweepAddr, chainParams)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	if opts.FeeRate == 0 {
+		opts.FeeRate, err = sweepFeeRate(d.cmd, d.api, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sweepScript, nil
+}
+
+// newDaemonPsbtSigner returns the signer that records the sign requests of a
+// sweep in an unsigned PSBT.
+func newDaemonPsbtSigner(deriver lnd.KeyDeriver,
+	fingerprint string) (*lnd.PsbtSigner, error) {
+
+	signer := &lnd.PsbtSigner{
+		KeyDeriver:  deriver,
+		ChainParams: chainParams,
+	}
+	if fingerprint != "" {
+		var err error
+		signer.Fingerprint, err = parseFingerprint(fingerprint)
+		if err != nil {
+			return nil, badRequest(err)
+		}
+	}
+
+	return signer, nil
+}
+
+// sweepResult creates the response for the given sweep transaction. If the
+// transaction was built with a PSBT signer, the unsigned PSBT is returned.
+func sweepResult(sweepTx *wire.MsgTx, values map[wire.OutPoint]int64,
+	signer *lnd.PsbtSigner) (*sweepResponse, error) {
+
+	resp := &sweepResponse{
+		TxID:      sweepTx.TxHash().String(),
+		NumInputs: len(sweepTx.TxIn),
+	}
+	for _, txIn := range sweepTx.TxIn {
+		resp.Fee += values[txIn.PreviousOutPoint]
+	}
+	for _, txOut := range sweepTx.TxOut {
+		resp.Fee -= txOut.Value
+	}
+
+	if signer != nil {
+		if err := signer.AddWitnessTemplates(sweepTx); err != nil {
+			return nil, fmt.Errorf("error adding witness "+
+				"templates: %w", err)
+		}
+		packet, err := signer.Packet()
+		if err != nil {
+			return nil, err
+		}
+		resp.Psbt, err = packet.B64Encode()
+		if err != nil {
+			return nil, fmt.Errorf("error encoding PSBT: %w", err)
+		}
+
+		return resp, nil
+	}
+
+	var buf bytes.Buffer
+	if err := sweepTx.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	resp.RawTx = hex.EncodeToString(buf.Bytes())
+
+	return resp, nil
+}
+
+type daemonSweepTimeLockRequest struct {
+	channelsInput
+	sweepOptions
+
+	MaxCsvLimit uint16 `json:"maxcsvlimit"`
+}
+
+func (d *daemon) sweepTimeLock(r *http.Request) (interface{}, error) {
+	req := &daemonSweepTimeLockRequest{}
+	if err := decodeRequest(r, req); err != nil {
+		return nil, err
+	}
+
+	sweepScript, err := d.prepare(&req.sweepOptions)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := req.entries()
+	if err != nil {
+		return nil, err
+	}
+	if req.MaxCsvLimit == 0 {
+		req.MaxCsvLimit = defaultCsvLimit
+	}
+
+	targets, _, err := sweep.TimeLockTargets(entries, log)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	values := make(map[wire.OutPoint]int64, len(targets))
+	for _, target := range targets {
+		values[wire.OutPoint{
+			Hash:  target.TxID,
+			Index: target.Index,
+		}] = target.Value
+	}
+
+	// The keys of the to_local outputs are part of the channel input, so
+	// we don't need the seed to create the unsigned PSBT.
+	var (
+		signer     sweep.Signer
+		psbtSigner *lnd.PsbtSigner
+	)
+	if req.Sign {
+		signer = d.signer
+	} else {
+		psbtSigner, err = newDaemonPsbtSigner(nil, req.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		signer = psbtSigner
+	}
+
+	sweepTx, err := sweep.TimeLock(
+		signer, targets, sweepScript, req.MaxCsvLimit, req.FeeRate,
+		log,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(sweepTx.TxIn) == 0 {
+		return nil, badRequest(fmt.Errorf("no sweepable outputs " +
+			"found"))
+	}
+
+	return sweepResult(sweepTx, values, psbtSigner)
+}
+
+type daemonSweepRemoteClosedRequest struct {
+	sweepOptions
+
+	AccountXPub    string `json:"accountxpub"`
+	RecoveryWindow uint32 `json:"recoverywindow"`
+}
+
+func (d *daemon) sweepRemoteClosed(r *http.Request) (interface{}, error) {
+	req := &daemonSweepRemoteClosedRequest{}
+	if err := decodeRequest(r, req); err != nil {
+		return nil, err
+	}
+
+	sweepScript, err := d.prepare(&req.sweepOptions)
+	if err != nil {
+		return nil, err
+	}
+	if req.RecoveryWindow == 0 {
+		req.RecoveryWindow = sweepRemoteClosedDefaultRecoveryWindow
+	}
+
+	// Without signing, the keys are derived from the account xpub of the
+	// payment base key family, if one is given.
+	var deriver sweep.Signer
+	switch {
+	case req.Sign || (req.AccountXPub == "" && d.signer != nil):
+		deriver = d.signer
+
+	case req.AccountXPub != "":
+		accountKey, err := lnd.ParseAccountXPub(req.AccountXPub)
+		if err != nil {
+			return nil, badRequest(err)
+		}
+		familyIndex := lnd.HardenedKey(
+			uint32(keychain.KeyFamilyPaymentBase),
+		)
+		if accountKey.ChildIndex() != familyIndex {
+			return nil, badRequest(fmt.Errorf("account key must "+
+				"be the one of the payment base key family "+
+				"(m/1017'/%d'/%d')", chainParams.HDCoinType,
+				keychain.KeyFamilyPaymentBase))
+		}
+		deriver = &lnd.Signer{
+			ExtendedKey: accountKey.ExtendedKey,
+			ChainParams: chainParams,
+		}
+
+	default:
+		return nil, badRequest(fmt.Errorf("accountxpub is required " +
+			"if signing is disabled"))
+	}
+
+	signer := deriver
+	var psbtSigner *lnd.PsbtSigner
+	if !req.Sign {
+		psbtSigner, err = newDaemonPsbtSigner(deriver, req.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		signer = psbtSigner
+	}
+
+	var unspentAPI sweep.UnspentAPI = d.explorerAPI
+	if api, ok := d.api.(sweep.UnspentAPI); ok {
+		unspentAPI = api
+	}
+	targets, err := sweep.FindRemoteClosed(
+		deriver, unspentAPI, req.RecoveryWindow, chainParams, log,
+	)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[wire.OutPoint]int64)
+	for _, target := range targets {
+		for _, vout := range target.Vouts {
+			txHash, err := chainhash.NewHashFromStr(
+				vout.Outspend.Txid,
+			)
+			if err != nil {
+				return nil, err
+			}
+			values[wire.OutPoint{
+				Hash:  *txHash,
+				Index: uint32(vout.Outspend.Vin),
+			}] = int64(vout.Value)
+		}
+	}
+
+	sweepTx, err := sweep.RemoteClosed(
+		signer, targets, sweepScript, req.FeeRate, chainParams, log,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return sweepResult(sweepTx, values, psbtSigner)
+}
+
+type daemonCombinePsbtRequest struct {
+	Psbt string `json:"psbt"`
+}
+
+type daemonCombinePsbtResponse struct {
+	TxID  string `json:"txid"`
+	RawTx string `json:"raw_tx"`
+}
+
+func (d *daemon) combinePsbt(r *http.Request) (interface{}, error) {
+	req := &daemonCombinePsbtRequest{}
+	if err := decodeRequest(r, req); err != nil {
+		return nil, err
+	}
+
+	packet, _, err := btc.DecodePsbt(req.Psbt)
+	if err != nil {
+		return nil, badRequest(fmt.Errorf("error decoding PSBT: %w",
+			err))
+	}
+
+	sweepTx, err := sweep.CombinePsbt(packet)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	var buf bytes.Buffer
+	if err := sweepTx.Serialize(&buf); err != nil {
+		return nil, err
+	}
+
+	return &daemonCombinePsbtResponse{
+		TxID:  sweepTx.TxHash().String(),
+		RawTx: hex.EncodeToString(buf.Bytes()),
+	}, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+// daemonTestAPI is a chain backend that only knows the unspent outputs of
+// a few addresses.
+type daemonTestAPI struct {
+	btc.SweepAPI
+
+	unspent map[string][]*btc.Vout
+}
+
+func (a *daemonTestAPI) Unspent(addr string) ([]*btc.Vout, error) {
+	return a.unspent[addr], nil
+}
+
+const (
+	daemonTestHost  = "localhost:8787"
+	daemonTestToken = "secret"
+)
+
+// newDaemonTestRequest creates a request with the access token to the host the
+// test daemon listens on.
+func newDaemonTestRequest(method, path string,
+	body io.Reader) *http.Request {
+
+	r := httptest.NewRequest(method, path, body)
+	r.Host = daemonTestHost
+	r.Header.Set("Authorization", "Bearer "+daemonTestToken)
+
+	return r
+}
+
+func daemonRequest(t *testing.T, handler http.Handler, method, path string,
+	req interface{}, expectedStatus int, resp interface{}) {
+
+	t.Helper()
+
+	var body bytes.Buffer
+	if req != nil {
+		require.NoError(t, json.NewEncoder(&body).Encode(req))
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(
+		recorder, newDaemonTestRequest(method, path, &body),
+	)
+	require.Equal(
+		t, expectedStatus, recorder.Code, recorder.Body.String(),
+	)
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(resp))
+}
+
+func TestDaemon(t *testing.T) {
+	_ = newHarness(t)
+
+	rootKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	offlineSigner := &lnd.Signer{
+		ExtendedKey: rootKey,
+		ChainParams: chainParams,
+	}
+
+	accountKey, err := lnd.DeriveChildren(rootKey, []uint32{
+		lnd.HardenedKey(uint32(keychain.BIP0043Purpose)),
+		lnd.HardenedKey(chainParams.HDCoinType),
+		lnd.HardenedKey(uint32(keychain.KeyFamilyPaymentBase)),
+	})
+	require.NoError(t, err)
+	accountXPub, err := accountKey.Neuter()
+	require.NoError(t, err)
+
+	// The to_remote output of a static remote key channel pays to the
+	// first key of the payment base family.
+	keyDesc, err := offlineSigner.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyPaymentBase,
+	})
+	require.NoError(t, err)
+	addr, err := lnd.P2WKHAddr(keyDesc.PubKey, chainParams)
+	require.NoError(t, err)
+	api := &daemonTestAPI{
+		unspent: map[string][]*btc.Vout{
+			addr.EncodeAddress(): {{
+				Value: 100_000,
+				Outspend: &btc.Outspend{
+					Txid: chainhash.Hash{1}.String(),
+					Vin:  1,
+				},
+			}},
+		},
+	}
+
+	d := &daemon{api: api, token: daemonTestToken, host: daemonTestHost}
+	handler := d.handler()
+
+	var info daemonInfoResponse
+	daemonRequest(
+		t, handler, http.MethodGet, "/v1/info", nil, http.StatusOK,
+		&info,
+	)
+	require.Equal(t, chainParams.Name, info.Network)
+	require.False(t, info.SigningEnabled)
+
+	var errResp map[string]string
+	daemonRequest(
+		t, handler, http.MethodGet, "/v1/sweepremoteclosed", nil,
+		http.StatusMethodNotAllowed, &errResp,
+	)
+
+	// Signed transactions can't be requested if signing is disabled.
+	req := &daemonSweepRemoteClosedRequest{
+		sweepOptions: sweepOptions{
+			SweepAddr: addr.EncodeAddress(),
+			FeeRate:   10,
+			Sign:      true,
+		},
+		AccountXPub:    accountXPub.String(),
+		RecoveryWindow: 2,
+	}
+	daemonRequest(
+		t, handler, http.MethodPost, "/v1/sweepremoteclosed", req,
+		http.StatusForbidden, &errResp,
+	)
+	require.Contains(t, errResp["error"], "signing is disabled")
+
+	// Without signing, the account xpub is required.
+	req.Sign = false
+	req.AccountXPub = ""
+	daemonRequest(
+		t, handler, http.MethodPost, "/v1/sweepremoteclosed", req,
+		http.StatusBadRequest, &errResp,
+	)
+	require.Contains(t, errResp["error"], "accountxpub is required")
+
+	// With the account xpub, an unsigned PSBT is created.
+	req.AccountXPub = accountXPub.String()
+	var sweepResp sweepResponse
+	daemonRequest(
+		t, handler, http.MethodPost, "/v1/sweepremoteclosed", req,
+		http.StatusOK, &sweepResp,
+	)
+	require.Equal(t, 1, sweepResp.NumInputs)
+	require.Empty(t, sweepResp.RawTx)
+	require.NotEmpty(t, sweepResp.Psbt)
+	require.Positive(t, sweepResp.Fee)
+
+	// The PSBT is signed offline and then combined by the daemon.
+	packet, version, err := btc.DecodePsbt(sweepResp.Psbt)
+	require.NoError(t, err)
+	_, err = offlineSigner.SignPsbt(packet)
+	require.NoError(t, err)
+	signedPsbt, err := btc.EncodePsbt(packet, version)
+	require.NoError(t, err)
+
+	var combineResp daemonCombinePsbtResponse
+	daemonRequest(
+		t, handler, http.MethodPost, "/v1/combinepsbt",
+		&daemonCombinePsbtRequest{Psbt: signedPsbt}, http.StatusOK,
+		&combineResp,
+	)
+	require.Equal(t, sweepResp.TxID, combineResp.TxID)
+	require.NotEmpty(t, combineResp.RawTx)
+
+	// With signing enabled, the daemon returns the signed transaction
+	// directly.
+	d.signer = offlineSigner
+	req.Sign = true
+	req.AccountXPub = ""
+	var signedResp sweepResponse
+	daemonRequest(
+		t, handler, http.MethodPost, "/v1/sweepremoteclosed", req,
+		http.StatusOK, &signedResp,
+	)
+	require.Equal(t, combineResp.TxID, signedResp.TxID)
+	require.Equal(t, combineResp.RawTx, signedResp.RawTx)
+	require.Empty(t, signedResp.Psbt)
+}
+
+func TestDaemonAuthentication(t *testing.T) {
+	h := newHarness(t)
+
+	// The token file can only be read by the current user.
+	tokenFile := filepath.Join(t.TempDir(), "daemon.token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("old"), 0644))
+	token, err := writeDaemonToken(tokenFile)
+	require.NoError(t, err)
+	require.Len(t, token, 64)
+	info, err := os.Stat(tokenFile)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	content, err := os.ReadFile(tokenFile)
+	require.NoError(t, err)
+	require.Equal(t, token, string(content))
+
+	d := &daemon{
+		token:  daemonTestToken,
+		host:   daemonTestHost,
+		dbDirs: []string{filepath.Dir(h.testdataFile("channel.db"))},
+	}
+	handler := d.handler()
+
+	send := func(r *http.Request, expectedStatus int) string {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		require.Equal(
+			t, expectedStatus, recorder.Code,
+			recorder.Body.String(),
+		)
+
+		return recorder.Body.String()
+	}
+
+	// Requests without the correct token are rejected.
+	r := newDaemonTestRequest(http.MethodGet, "/v1/info", nil)
+	r.Header.Del("Authorization")
+	send(r, http.StatusUnauthorized)
+
+	r = newDaemonTestRequest(http.MethodGet, "/v1/info", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	send(r, http.StatusUnauthorized)
+
+	send(
+		newDaemonTestRequest(http.MethodGet, "/v1/info", nil),
+		http.StatusOK,
+	)
+
+	// A DNS rebinding attack from a web page sends another host name and
+	// the origin of the page.
+	r = newDaemonTestRequest(http.MethodGet, "/v1/info", nil)
+	r.Host = "evil.example.com:8787"
+	require.Contains(t, send(r, http.StatusForbidden), "invalid host")
+
+	r = newDaemonTestRequest(http.MethodGet, "/v1/info", nil)
+	r.Header.Set("Origin", "https://evil.example.com")
+	require.Contains(t, send(r, http.StatusForbidden), "invalid origin")
+
+	// Large request bodies are rejected.
+	largeBody := `{"channels": "` +
+		strings.Repeat("a", daemonMaxRequestSize) + `"}`
+	send(newDaemonTestRequest(
+		http.MethodPost, "/v1/summary", strings.NewReader(largeBody),
+	), http.StatusRequestEntityTooLarge)
+
+	// The number of workers is capped.
+	var errResp map[string]string
+	daemonRequest(
+		t, handler, http.MethodPost, "/v1/summary",
+		&daemonSummaryRequest{
+			channelsInput: channelsInput{
+				Channels: json.RawMessage(`{"channels":[]}`),
+			},
+			Workers: daemonMaxWorkers + 1,
+		}, http.StatusBadRequest, &errResp,
+	)
+	require.Contains(t, errResp["error"], "workers must not be more")
+
+	// Channel DBs can only be opened from the allowed directories.
+	daemonRequest(
+		t, handler, http.MethodPost, "/v1/dumpchannels",
+		&daemonDumpChannelsRequest{
+			ChannelDB: filepath.Join(
+				h.tempDir, "..", "channel.db",
+			),
+		}, http.StatusBadRequest, &errResp,
+	)
+	daemonRequest(
+		t, handler, http.MethodPost, "/v1/dumpchannels",
+		&daemonDumpChannelsRequest{
+			ChannelDB: h.testdataFile("../root.go"),
+		}, http.StatusForbidden, &errResp,
+	)
+	require.Contains(t, errResp["error"], "must be inside")
+
+	var channels []interface{}
+	daemonRequest(
+		t, handler, http.MethodPost, "/v1/dumpchannels",
+		&daemonDumpChannelsRequest{
+			ChannelDB: h.testdataFile("channel.db"),
+		}, http.StatusOK, &channels,
+	)
+	require.Len(t, channels, 4)
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/spf13/cobra"
+)
+
+const (
+	commitOutputToLocal  = "to_local"
+	commitOutputToRemote = "to_remote"
+	commitOutputAnchor   = "anchor"
+	commitOutputHTLC     = "htlc"
+	commitOutputUnknown  = "unknown"
+
+	commitOwnerLocal  = "local"
+	commitOwnerRemote = "remote"
+)
+
+type decodeCommitCommand struct {
+	ChannelDB string
+	RawTx     string
+	TxID      string
+
+	chainAPI *chainAPIFlags
+	rootKey  *rootKey
+	cmd      *cobra.Command
+}
+
+func newDecodeCommitCommand() *cobra.Command {
+	cc := &decodeCommitCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "decodecommit",
+		Short: "Label all outputs of a commitment transaction and " +
+			"show which command sweeps them",
+		Long: `This command decodes a commitment transaction of one of
+the channels in the given channel.db file, either from its raw hex or by
+looking it up with the chain API. The keys of the commitment state are derived
+from the channel.db file to find out whose commitment it is (ours or the remote
+peer's) and whether it is a revoked state.
+
+Every output is then labeled as to_local, to_remote, anchor or HTLC (with its
+direction, payment hash and CLTV expiry), together with the chantools command
+that can be used to sweep it, if any. The root key is used to make sure the
+channel's keys belong to this wallet.
+
+Only the HTLCs of the latest local and remote commitments and of revoked
+remote commitments are known to the channel.db file, the HTLC outputs of other
+states are reported as unknown.`,
+		Example: `chantools decodecommit \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--rawtx 02000000000101...
+
+chantools decodecommit \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--txid abcdef01234...`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to read "+
+			"the channel and its keys from",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.RawTx, "rawtx", "", "the hex encoded raw commitment "+
+			"transaction to decode",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.TxID, "txid", "", "the ID of the on-chain commitment "+
+			"transaction to decode, it is looked up with the "+
+			"chain API",
+	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
+
+	cc.rootKey = newRootKey(cc.cmd, "checking the channel keys")
+
+	return cc.cmd
+}
+
+func (c *decodeCommitCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	if !channelDBGiven(c.ChannelDB) {
+		return fmt.Errorf("channel DB is required")
+	}
+	if (c.RawTx == "") == (c.TxID == "") {
+		return fmt.Errorf("either --rawtx or --txid is required")
+	}
+
+	commitTx := &wire.MsgTx{}
+	if c.RawTx != "" {
+		rawTx, err := hex.DecodeString(c.RawTx)
+		if err != nil {
+			return fmt.Errorf("error decoding raw TX: %w", err)
+		}
+		err = commitTx.Deserialize(bytes.NewReader(rawTx))
+		if err != nil {
+			return fmt.Errorf("error parsing raw TX: %w", err)
+		}
+	} else {
+		tx, err := c.chainAPI.api().Transaction(c.TxID)
+		if err != nil {
+			return fmt.Errorf("error looking up TX %s: %w", c.TxID,
+				err)
+		}
+		commitTx, err = tx.MsgTx()
+		if err != nil {
+			return fmt.Errorf("error rebuilding TX %s, use "+
+				"--rawtx instead: %w", c.TxID, err)
+		}
+	}
+
+	db, err := openChannelDB(c.ChannelDB, true)
+	if err != nil {
+		return fmt.Errorf("error opening channel DB: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Errorf("Error closing DB: %v", err)
+		}
+	}()
+
+	channel, err := breachedChannel(db.ChannelStateDB(), commitTx)
+	if err != nil {
+		return err
+	}
+
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	multiSigKey := channel.LocalChanCfg.MultiSigKey
+	keyDesc, err := signer.DeriveKey(multiSigKey.KeyLocator)
+	if err != nil {
+		return fmt.Errorf("error deriving multisig key: %w", err)
+	}
+	if !keyDesc.PubKey.IsEqual(multiSigKey.PubKey) {
+		log.Warnf("The multisig key of channel %v wasn't derived "+
+			"from the given root key, the sweep commands need "+
+			"the root key of the node that owns the channel",
+			channel.FundingOutpoint)
+	}
+
+	decoded, err := decodeCommitment(channel, commitTx)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("TX %s is the %s commitment at height %d of channel %s "+
+		"(%s), revoked: %v", decoded.TXID, decoded.Owner,
+		decoded.CommitHeight, decoded.ChannelPoint,
+		decoded.CommitmentType, decoded.Revoked)
+	for _, output := range decoded.Outputs {
+		log.Infof("Output %d: %s", output.Index, output)
+	}
+
+	return printJSON(decoded)
+}
+
+// decodedCommitment is a commitment transaction with all its outputs labeled.
+type decodedCommitment struct {
+	TXID           string          `json:"txid"`
+	ChannelPoint   string          `json:"channel_point"`
+	CommitmentType string          `json:"commitment_type"`
+	Owner          string          `json:"owner"`
+	CommitHeight   uint64          `json:"commit_height"`
+	Revoked        bool            `json:"revoked"`
+	Outputs        []*commitOutput `json:"outputs"`
+}
+
+// commitOutput is a labeled output of a commitment transaction. The to_local
+// and to_remote outputs are named from the point of view of the owner of the
+// commitment, the HTLC direction is from our point of view. Ours is set for the
+// balance and anchor outputs that belong to us.
+type commitOutput struct {
+	Index        uint32 `json:"index"`
+	Value        int64  `json:"value"`
+	Type         string `json:"type"`
+	Ours         bool   `json:"ours"`
+	CSVDelay     uint32 `json:"csv_delay,omitempty"`
+	Direction    string `json:"direction,omitempty"`
+	PaymentHash  string `json:"payment_hash,omitempty"`
+	CLTVExpiry   uint32 `json:"cltv_expiry,omitempty"`
+	SweepCommand string `json:"sweep_command,omitempty"`
+	Note         string `json:"note,omitempty"`
+}
+
+// String returns a human readable description of the output.
+func (o *commitOutput) String() string {
+	desc := fmt.Sprintf("%s with %d sats", o.Type, o.Value)
+	if o.Ours {
+		desc += " (ours)"
+	}
+	if o.CSVDelay > 0 {
+		desc += fmt.Sprintf(", CSV delay %d", o.CSVDelay)
+	}
+	if o.Direction != "" {
+		desc += fmt.Sprintf(", %s HTLC %s, CLTV expiry %d",
+			o.Direction, o.PaymentHash, o.CLTVExpiry)
+	}
+
+	sweepCommand := o.SweepCommand
+	if sweepCommand == "" {
+		sweepCommand = "none"
+	}
+	desc += ", sweep command: " + sweepCommand
+	if o.Note != "" {
+		desc += " (" + o.Note + ")"
+	}
+
+	return desc
+}
+
+// commitState is one of the commitment transactions that a transaction with a
+// given state number could be.
+type commitState struct {
+	ourCommit   bool
+	revoked     bool
+	commitPoint *btcec.PublicKey
+	htlcs       []channeldb.HTLC
+}
+
+// decodeCommitment finds out which commitment of the channel the given
+// transaction is and labels all its outputs.
+func decodeCommitment(channel *channeldb.OpenChannel,
+	commitTx *wire.MsgTx) (*decodedCommitment, error) {
+
+	commitmentType, err := dataformat.CommitmentTypeFromChanType(
+		channel.ChanType,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	stateNum := breachStateNum(channel, commitTx)
+	states, err := commitStates(channel, stateNum)
+	if err != nil {
+		return nil, err
+	}
+
+	// The anchor outputs are the same on all commitments, so we need at
+	// least one other output to identify the state.
+	for _, state := range states {
+		outputs, err := labelCommitOutputs(
+			channel, commitmentType, state, commitTx,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		numMatched := 0
+		for _, output := range outputs {
+			if output.Type != commitOutputUnknown &&
+				output.Type != commitOutputAnchor {
+
+				numMatched++
+			}
+		}
+		if numMatched == 0 {
+			continue
+		}
+
+		owner := commitOwnerRemote
+		if state.ourCommit {
+			owner = commitOwnerLocal
+		}
+
+		return &decodedCommitment{
+			TXID:           commitTx.TxHash().String(),
+			ChannelPoint:   channel.FundingOutpoint.String(),
+			CommitmentType: commitmentType,
+			Owner:          owner,
+			CommitHeight:   stateNum,
+			Revoked:        state.revoked,
+			Outputs:        outputs,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("TX %v is not a known commitment of channel "+
+		"%v at height %d", commitTx.TxHash(), channel.FundingOutpoint,
+		stateNum)
+}
+
+// commitStates returns our and the remote peer's commitment at the given
+// height, as far as they are known to the channel.
+func commitStates(channel *channeldb.OpenChannel,
+	stateNum uint64) ([]*commitState, error) {
+
+	var states []*commitState
+
+	// We can derive all our own commitment points. But only the HTLCs of
+	// our latest commitment are known.
+	localHeight := channel.LocalCommitment.CommitHeight
+	if channel.RevocationProducer != nil && stateNum <= localHeight {
+		secret, err := channel.RevocationProducer.AtIndex(stateNum)
+		if err != nil {
+			return nil, fmt.Errorf("error deriving commitment "+
+				"secret: %w", err)
+		}
+		state := &commitState{
+			ourCommit:   true,
+			revoked:     stateNum < localHeight,
+			commitPoint: input.ComputeCommitmentPoint(secret[:]),
+		}
+		if !state.revoked {
+			state.htlcs = channel.LocalCommitment.Htlcs
+		}
+		states = append(states, state)
+	}
+
+	remoteHeight := channel.RemoteCommitment.CommitHeight
+	switch {
+	case stateNum == remoteHeight && channel.RemoteCurrentRevocation != nil:
+		states = append(states, &commitState{
+			commitPoint: channel.RemoteCurrentRevocation,
+			htlcs:       channel.RemoteCommitment.Htlcs,
+		})
+
+	// The remote peer might not have revoked its previous commitment yet
+	// after we signed a new one.
+	case stateNum == remoteHeight+1 && channel.RemoteNextRevocation != nil:
+		state := &commitState{
+			commitPoint: channel.RemoteNextRevocation,
+		}
+		tip, err := channel.RemoteCommitChainTip()
+		if err == nil {
+			state.htlcs = tip.Commitment.Htlcs
+		}
+		states = append(states, state)
+
+	case stateNum < remoteHeight:
+		secret, err := channel.RevocationStore.LookUp(stateNum)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up revocation "+
+				"secret: %w", err)
+		}
+		state := &commitState{
+			revoked:     true,
+			commitPoint: input.ComputeCommitmentPoint(secret[:]),
+		}
+		revLog, _, err := channel.FindPreviousState(stateNum)
+		if err == nil {
+			for _, entry := range revLog.HTLCEntries {
+				htlc := channeldb.HTLC{
+					RHash:         entry.RHash,
+					RefundTimeout: entry.RefundTimeout,
+					Incoming:      entry.Incoming,
+				}
+				state.htlcs = append(state.htlcs, htlc)
+			}
+		}
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// labelCommitOutputs derives the scripts of the given commitment state and
+// labels the outputs of the transaction that match them.
+func labelCommitOutputs(channel *channeldb.OpenChannel, commitmentType string,
+	state *commitState, commitTx *wire.MsgTx) ([]*commitOutput, error) {
+
+	var (
+		chanType  = channel.ChanType
+		localCfg  = &channel.LocalChanCfg
+		remoteCfg = &channel.RemoteChanCfg
+		csvDelay  = uint32(remoteCfg.CsvDelay)
+
+		// The initiator flag of the scripts refers to the owner of the
+		// commitment.
+		initiator = !channel.IsInitiator
+
+		outputs = make(map[string]*commitOutput)
+	)
+	if state.ourCommit {
+		csvDelay = uint32(localCfg.CsvDelay)
+		initiator = channel.IsInitiator
+	}
+
+	keyRing := lnwallet.DeriveCommitmentKeys(
+		state.commitPoint, state.ourCommit, chanType, localCfg,
+		remoteCfg,
+	)
+
+	toLocal, err := lnwallet.CommitScriptToSelf(
+		chanType, initiator, keyRing.ToLocalKey, keyRing.RevocationKey,
+		csvDelay, channel.ThawHeight,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating to_local script: %w",
+			err)
+	}
+	outputs[string(toLocal.PkScript)] = &commitOutput{
+		Type:     commitOutputToLocal,
+		Ours:     state.ourCommit,
+		CSVDelay: csvDelay,
+	}
+
+	toRemote, remoteDelay, err := lnwallet.CommitScriptToRemote(
+		chanType, initiator, keyRing.ToRemoteKey, channel.ThawHeight,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating to_remote script: %w",
+			err)
+	}
+	outputs[string(toRemote.PkScript)] = &commitOutput{
+		Type:     commitOutputToRemote,
+		Ours:     !state.ourCommit,
+		CSVDelay: remoteDelay,
+	}
+
+	if chanType.HasAnchors() {
+		localAnchor, remoteAnchor, err := lnwallet.CommitScriptAnchors(
+			localCfg, remoteCfg,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error creating anchor "+
+				"scripts: %w", err)
+		}
+		outputs[string(localAnchor.PkScript)] = &commitOutput{
+			Type: commitOutputAnchor,
+			Ours: true,
+		}
+		outputs[string(remoteAnchor.PkScript)] = &commitOutput{
+			Type: commitOutputAnchor,
+		}
+	}
+
+	for _, htlc := range state.htlcs {
+		pkScript, err := htlcPkScript(
+			chanType, htlc.Incoming, state.ourCommit,
+			htlc.RefundTimeout, htlc.RHash, keyRing,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error creating HTLC script: %w",
+				err)
+		}
+
+		direction := dataformat.HTLCDirectionOutgoing
+		if htlc.Incoming {
+			direction = dataformat.HTLCDirectionIncoming
+		}
+		outputs[string(pkScript)] = &commitOutput{
+			Type:        commitOutputHTLC,
+			Direction:   direction,
+			PaymentHash: hex.EncodeToString(htlc.RHash[:]),
+			CLTVExpiry:  htlc.RefundTimeout,
+		}
+	}
+
+	result := make([]*commitOutput, len(commitTx.TxOut))
+	for idx, txOut := range commitTx.TxOut {
+		output := &commitOutput{
+			Type: commitOutputUnknown,
+		}
+		if template, ok := outputs[string(txOut.PkScript)]; ok {
+			templateCopy := *template
+			output = &templateCopy
+		}
+		output.Index = uint32(idx)
+		output.Value = txOut.Value
+		addSweepCommand(channel, commitmentType, state, output)
+
+		result[idx] = output
+	}
+
+	return result, nil
+}
+
+// htlcPkScript creates the P2WSH script of an HTLC output the same way lnd
+// does. The incoming flag is from our point of view.
+func htlcPkScript(chanType channeldb.ChannelType, incoming, ourCommit bool,
+	timeout uint32, rHash [32]byte,
+	keyRing *lnwallet.CommitmentKeyRing) ([]byte, error) {
+
+	var (
+		confirmedSpend = chanType.HasAnchors()
+		witnessScript  []byte
+		err            error
+	)
+	switch {
+	case incoming && ourCommit:
+		witnessScript, err = input.ReceiverHTLCScript(
+			timeout, keyRing.RemoteHtlcKey, keyRing.LocalHtlcKey,
+			keyRing.RevocationKey, rHash[:], confirmedSpend,
+		)
+
+	case incoming && !ourCommit:
+		witnessScript, err = input.SenderHTLCScript(
+			keyRing.RemoteHtlcKey, keyRing.LocalHtlcKey,
+			keyRing.RevocationKey, rHash[:], confirmedSpend,
+		)
+
+	case !incoming && ourCommit:
+		witnessScript, err = input.SenderHTLCScript(
+			keyRing.LocalHtlcKey, keyRing.RemoteHtlcKey,
+			keyRing.RevocationKey, rHash[:], confirmedSpend,
+		)
+
+	default:
+		witnessScript, err = input.ReceiverHTLCScript(
+			timeout, keyRing.LocalHtlcKey, keyRing.RemoteHtlcKey,
+			keyRing.RevocationKey, rHash[:], confirmedSpend,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return input.WitnessScriptHash(witnessScript)
+}
+
+// addSweepCommand sets the chantools command that can sweep the output, if
+// there is one, and a note on how the output can be spent.
+func addSweepCommand(channel *channeldb.OpenChannel, commitmentType string,
+	state *commitState, output *commitOutput) {
+
+	switch {
+	case output.Type == commitOutputUnknown:
+		if state.revoked && state.ourCommit {
+			output.Note = "HTLCs of revoked local states are " +
+				"unknown"
+		}
+
+	case output.Type == commitOutputAnchor:
+		output.Note = "only used for CPFP fee bumping"
+
+	// All outputs of a revoked remote commitment can be claimed by us.
+	case state.revoked && !state.ourCommit:
+		output.SweepCommand = "sweepbreach"
+
+	case state.revoked:
+		output.Note = "revoked state, the remote peer can claim the " +
+			"funds with the revocation key"
+
+	case !output.Ours && output.Type != commitOutputHTLC:
+		output.Note = "belongs to the remote peer"
+
+	case output.Type == commitOutputToLocal:
+		output.SweepCommand = "sweeptimelock"
+		output.Note = fmt.Sprintf("after the CSV delay of %d blocks, "+
+			"or sweeptimelockmanual without a summary file",
+			output.CSVDelay)
+
+	case output.Type == commitOutputToRemote:
+		output.SweepCommand = dataformat.SweepCommandForCommitmentType(
+			commitmentType,
+		)
+
+	case !state.ourCommit:
+		output.Note = "HTLCs on the remote commitment are not " +
+			"supported by chantools"
+
+	case channel.ChanType.HasAnchors():
+		output.Note = "HTLCs of anchor channels are not supported by " +
+			"sweephtlcs"
+
+	case output.Direction == dataformat.HTLCDirectionOutgoing:
+		output.SweepCommand = "sweephtlcs"
+		output.Note = fmt.Sprintf("HTLC-timeout TX after block %d",
+			output.CLTVExpiry)
+
+	default:
+		output.SweepCommand = "sweephtlcs"
+		output.Note = "HTLC-success TX, needs the preimage"
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeCommitment(t *testing.T) {
+	h := newHarness(t)
+
+	db, err := lnd.OpenDB(h.testdataFile("channel.db"), true)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	channels, err := db.ChannelStateDB().FetchAllChannels()
+	require.NoError(t, err)
+	require.NotEmpty(t, channels)
+
+	for _, channel := range channels {
+		// Both the latest local and remote commitment are found and
+		// all their outputs are known.
+		localTx := channel.LocalCommitment.CommitTx
+		decoded, err := decodeCommitment(channel, localTx)
+		require.NoError(t, err)
+		require.Equal(t, commitOwnerLocal, decoded.Owner)
+		require.False(t, decoded.Revoked)
+		require.Equal(t, localTx.TxHash().String(), decoded.TXID)
+		require.Equal(
+			t, dataformat.CommitmentTypeStaticRemoteKey,
+			decoded.CommitmentType,
+		)
+		require.Len(t, decoded.Outputs, len(localTx.TxOut))
+		for _, output := range decoded.Outputs {
+			require.NotEqual(t, commitOutputUnknown, output.Type)
+			if output.Type == commitOutputToLocal {
+				require.True(t, output.Ours)
+				require.Equal(
+					t, "sweeptimelock", output.SweepCommand,
+				)
+				require.EqualValues(
+					t, channel.LocalChanCfg.CsvDelay,
+					output.CSVDelay,
+				)
+			}
+		}
+
+		remoteTx := channel.RemoteCommitment.CommitTx
+		decoded, err = decodeCommitment(channel, remoteTx)
+		require.NoError(t, err)
+		require.Equal(t, commitOwnerRemote, decoded.Owner)
+		for _, output := range decoded.Outputs {
+			require.NotEqual(t, commitOutputUnknown, output.Type)
+			if output.Type == commitOutputToRemote {
+				require.True(t, output.Ours)
+				require.Equal(
+					t, "sweepremoteclosed",
+					output.SweepCommand,
+				)
+			}
+		}
+	}
+
+	// An outgoing HTLC on our commitment is labeled with its CLTV expiry.
+	channel := channels[0]
+	secret, err := channel.RevocationProducer.AtIndex(0)
+	require.NoError(t, err)
+	keyRing := lnwallet.DeriveCommitmentKeys(
+		input.ComputeCommitmentPoint(secret[:]), true,
+		channel.ChanType, &channel.LocalChanCfg,
+		&channel.RemoteChanCfg,
+	)
+	htlc := channeldb.HTLC{
+		RHash:         [32]byte{1, 2, 3},
+		RefundTimeout: 123_456,
+	}
+	htlcScript, err := htlcPkScript(
+		channel.ChanType, false, true, htlc.RefundTimeout, htlc.RHash,
+		keyRing,
+	)
+	require.NoError(t, err)
+	channel.LocalCommitment.Htlcs = append(
+		channel.LocalCommitment.Htlcs, htlc,
+	)
+
+	commitTx := channel.LocalCommitment.CommitTx.Copy()
+	commitTx.AddTxOut(&wire.TxOut{Value: 5_000, PkScript: htlcScript})
+	commitTx.AddTxOut(&wire.TxOut{Value: 1_000, PkScript: []byte{0x51}})
+
+	decoded, err := decodeCommitment(channel, commitTx)
+	require.NoError(t, err)
+	numOutputs := len(commitTx.TxOut)
+	require.Equal(t, &commitOutput{
+		Index:        uint32(numOutputs - 2),
+		Value:        5_000,
+		Type:         commitOutputHTLC,
+		Direction:    dataformat.HTLCDirectionOutgoing,
+		PaymentHash:  hex.EncodeToString(htlc.RHash[:]),
+		CLTVExpiry:   123_456,
+		SweepCommand: "sweephtlcs",
+		Note:         "HTLC-timeout TX after block 123456",
+	}, decoded.Outputs[numOutputs-2])
+	require.Equal(
+		t, commitOutputUnknown, decoded.Outputs[numOutputs-1].Type,
+	)
+
+	// A transaction that doesn't match any commitment is rejected.
+	otherTx := wire.NewMsgTx(2)
+	otherTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: channel.FundingOutpoint,
+	})
+	otherTx.AddTxOut(&wire.TxOut{Value: 1_000, PkScript: []byte{0x51}})
+	_, err = decodeCommitment(channel, otherTx)
+	require.ErrorContains(t, err, "is not a known commitment")
+
+	// We can't tell what the commitment of an unknown channel type looks
+	// like.
+	channel.ChanType |= 1 << 20
+	_, err = decodeCommitment(channel, commitTx)
+	require.ErrorContains(t, err, "unknown channel type")
+}
+
+func TestCommitmentTypeFromChanType(t *testing.T) {
+	testCases := []struct {
+		chanType       channeldb.ChannelType
+		commitmentType string
+	}{{
+		chanType:       channeldb.SingleFunderBit,
+		commitmentType: dataformat.CommitmentTypeLegacy,
+	}, {
+		chanType:       channeldb.SingleFunderTweaklessBit,
+		commitmentType: dataformat.CommitmentTypeStaticRemoteKey,
+	}, {
+		chanType: channeldb.SingleFunderTweaklessBit |
+			channeldb.AnchorOutputsBit | channeldb.ZeroHtlcTxFeeBit,
+		commitmentType: dataformat.CommitmentTypeAnchors,
+	}, {
+		chanType: channeldb.SingleFunderTweaklessBit |
+			channeldb.AnchorOutputsBit | channeldb.ZeroHtlcTxFeeBit |
+			channeldb.LeaseExpirationBit,
+		commitmentType: dataformat.CommitmentTypeLease,
+	}, {
+		chanType: channeldb.SingleFunderTweaklessBit |
+			channeldb.AnchorOutputsBit | channeldb.ZeroHtlcTxFeeBit |
+			dataformat.SimpleTaprootFeatureBit,
+		commitmentType: dataformat.CommitmentTypeTaproot,
+	}}
+	for _, tc := range testCases {
+		commitmentType, err := dataformat.CommitmentTypeFromChanType(
+			tc.chanType,
+		)
+		require.NoError(t, err)
+		require.Equal(t, tc.commitmentType, commitmentType)
+	}
+
+	_, err := dataformat.CommitmentTypeFromChanType(1 << 11)
+	require.ErrorContains(t, err, "unknown channel type")
+}
+
+func TestDecodeCommitCommand(t *testing.T) {
+	h := newHarness(t)
+
+	db, err := lnd.OpenDB(h.testdataFile("channel.db"), true)
+	require.NoError(t, err)
+	channels, err := db.ChannelStateDB().FetchAllChannels()
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	var buf bytes.Buffer
+	require.NoError(t, channels[0].LocalCommitment.CommitTx.Serialize(&buf))
+
+	decodeCommit := &decodeCommitCommand{
+		ChannelDB: h.testdataFile("channel.db"),
+		RawTx:     hex.EncodeToString(buf.Bytes()),
+		rootKey:   &rootKey{RootKey: rootKeyAezeed},
+	}
+	require.NoError(t, decodeCommit.Execute(nil, nil))
+	h.assertLogContains("is the local commitment at height 0 of channel " +
+		channels[0].FundingOutpoint.String())
+	h.assertLogContains("sweep command: sweeptimelock")
+
+	decodeCommit.TxID = "abcd"
+	require.ErrorContains(
+		t, decodeCommit.Execute(nil, nil), "either --rawtx or --txid",
+	)
+}
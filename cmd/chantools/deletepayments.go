@@ -1,15 +1,32 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 
+	"github.com/btcsuite/btcwallet/walletdb"
 	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/kvdb"
 	"github.com/spf13/cobra"
 )
 
+var (
+	// paymentsRootBucket and paymentsIndexBucket are the top level
+	// buckets of the channel DB that contain the payments.
+	paymentsRootBucket  = []byte("payments-root-bucket")
+	paymentsIndexBucket = []byte("payments-index-bucket")
+
+	// paymentHtlcsBucket is the nested bucket of a payment that contains
+	// its HTLC attempts, each stored with the htlcAttemptInfoKey prefix.
+	paymentHtlcsBucket = []byte("payment-htlcs-bucket")
+	htlcAttemptInfoKey = []byte("ai")
+)
+
 type deletePaymentsCommand struct {
-	ChannelDB  string
-	FailedOnly bool
+	ChannelDB       string
+	FailedOnly      bool
+	FailedHTLCsOnly bool
 
 	cmd *cobra.Command
 }
@@ -17,41 +34,288 @@ type deletePaymentsCommand struct {
 func newDeletePaymentsCommand() *cobra.Command {
 	cc := &deletePaymentsCommand{}
 	cc.cmd = &cobra.Command{
-		Use:   "deletepayments",
-		Short: "Remove all (failed) payments from a channel DB",
-		Long: `This command removes all payments from a channel DB.
+		Use: "deletepayments",
+		Short: "Remove all (failed) payments or failed HTLC attempts " +
+			"from a channel DB",
+		Long: `This command removes all payments from a channel DB,
+without the need for a running lnd node. Payments that are still in flight are
+never removed.
 If only the failed payments should be deleted (and not the successful ones), the
---failedonly flag can be specified.
+--failedonly flag can be specified. To keep the payments themselves but delete
+the data of all their failed HTLC attempts (which often makes up most of the
+payment data), the --failedhtlcsonly flag can be specified. Both flags can be
+combined to only delete the failed HTLC attempts of failed payments.
+
+Before anything is changed, a copy of the channel DB is written to
+<name>-backup-<timestamp>.db in the working directory (only for the bolt
+database backend). The number of payments, HTLC attempts and bytes of payment
+data before and after are reported. Use the global --dryrun flag to only see
+what would be deleted.
+
+For the bolt database backend, the space that was freed within the database
+file is reported too. The file itself doesn't get smaller, run the compactdb
+command afterwards to reclaim that space.
 
 CAUTION: Running this command will make it impossible to use the channel DB
 with an older version of lnd. Downgrading is not possible and you'll need to
 run lnd v0.16.0-beta or later after using this command!'`,
 		Example: `chantools deletepayments --failedonly \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db
+
+chantools --dryrun deletepayments --failedhtlcsonly \
 	--channeldb ~/.lnd/data/graph/mainnet/channel.db`,
 		RunE: cc.Execute,
 	}
 	cc.cmd.Flags().StringVar(
-		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to dump "+
-			"channels from",
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to delete "+
+			"the payments from",
 	)
 	cc.cmd.Flags().BoolVar(
 		&cc.FailedOnly, "failedonly", false, "don't delete all "+
 			"payments, only failed ones",
 	)
+	cc.cmd.Flags().BoolVar(
+		&cc.FailedHTLCsOnly, "failedhtlcsonly", false, "don't delete "+
+			"the payments, only their failed HTLC attempts",
+	)
 
 	return cc.cmd
 }
 
 func (c *deletePaymentsCommand) Execute(_ *cobra.Command, _ []string) error {
 	// Check that we have a channel DB.
-	if c.ChannelDB == "" {
+	if !channelDBGiven(c.ChannelDB) {
 		return fmt.Errorf("channel DB is required")
 	}
-	db, err := lnd.OpenDB(c.ChannelDB, false)
+
+	if !DryRun {
+		if err := backupChannelDB(c.ChannelDB); err != nil {
+			return err
+		}
+	}
+
+	cfg := *dbConfig
+	cfg.Path = c.ChannelDB
+	if cfg.Backend == lnd.DBBackendPostgres && cfg.PostgresDSN == "" {
+		return fmt.Errorf("postgres DSN is required")
+	}
+
+	// The free space within the file can only be measured for bolt.
+	isBolt := cfg.Backend == "" || cfg.Backend == lnd.DBBackendBolt
+	var freeBefore int
+	if isBolt {
+		var err error
+		freeBefore, err = boltFreeBytes(c.ChannelDB)
+		if err != nil {
+			return err
+		}
+	}
+
+	backend, err := lnd.OpenBackend(&cfg, false, false)
+	if err != nil {
+		return fmt.Errorf("error opening channel DB: %w", err)
+	}
+	result, err := deletePayments(
+		backend, c.FailedOnly, c.FailedHTLCsOnly,
+	)
+	if closeErr := backend.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("error closing channel DB: %w", closeErr)
+	}
 	if err != nil {
-		return fmt.Errorf("error opening rescue DB: %w", err)
+		return err
+	}
+
+	if isBolt && !DryRun {
+		freeAfter, err := boltFreeBytes(c.ChannelDB)
+		if err != nil {
+			return err
+		}
+		result.FreedBytes = freeAfter - freeBefore
+		log.Infof("Freed %d bytes within the channel DB file, run "+
+			"compactdb to reclaim them", result.FreedBytes)
+	}
+
+	return printJSON(result)
+}
+
+// deletePaymentsResult is the result of the deletepayments command.
+type deletePaymentsResult struct {
+	Before              *paymentStats `json:"before"`
+	After               *paymentStats `json:"after"`
+	DeletedPayments     uint64        `json:"deleted_payments"`
+	DeletedHTLCAttempts uint64        `json:"deleted_htlc_attempts"`
+	DeletedBytes        uint64        `json:"deleted_bytes"`
+	FreedBytes          int           `json:"freed_bytes,omitempty"`
+	DryRun              bool          `json:"dry_run"`
+}
+
+// paymentStats are the number of payments and HTLC attempts in a channel DB
+// and the size of all keys and values of the payment buckets.
+type paymentStats struct {
+	Payments     uint64 `json:"payments"`
+	HTLCAttempts uint64 `json:"htlc_attempts"`
+	Bytes        uint64 `json:"bytes"`
+}
+
+// String returns a human readable representation of the payment statistics.
+func (s *paymentStats) String() string {
+	return fmt.Sprintf("%d payments with %d HTLC attempts, %d bytes",
+		s.Payments, s.HTLCAttempts, s.Bytes)
+}
+
+// deletePayments deletes the payments or their failed HTLC attempts from the
+// channel DB in one single transaction and reports what was deleted. If the
+// global --dryrun flag is set, the transaction is rolled back instead of
+// committed.
+func deletePayments(backend kvdb.Backend, failedOnly,
+	failedHTLCsOnly bool) (*deletePaymentsResult, error) {
+
+	batch, err := lnd.NewBatchBackend(backend)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = batch.Rollback()
+		}
+	}()
+
+	db, err := channeldb.CreateWithBackend(
+		batch, channeldb.OptionSetUseGraphCache(false),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error opening channel DB: %w", err)
+	}
+
+	result := &deletePaymentsResult{
+		DryRun: DryRun,
+	}
+	result.Before, err = countPayments(batch)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Before: %v", result.Before)
+
+	err = db.DeletePayments(failedOnly, failedHTLCsOnly)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting payments: %w", err)
+	}
+
+	result.After, err = countPayments(batch)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("After: %v", result.After)
+
+	result.DeletedPayments = result.Before.Payments -
+		result.After.Payments
+	result.DeletedHTLCAttempts = result.Before.HTLCAttempts -
+		result.After.HTLCAttempts
+	result.DeletedBytes = result.Before.Bytes - result.After.Bytes
+
+	if DryRun {
+		log.Infof("Dry run, not deleting %d payments and %d HTLC "+
+			"attempts (%d bytes)", result.DeletedPayments,
+			result.DeletedHTLCAttempts, result.DeletedBytes)
+		return result, nil
+	}
+
+	if err := batch.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+	committed = true
+	log.Infof("Deleted %d payments and %d HTLC attempts (%d bytes)",
+		result.DeletedPayments, result.DeletedHTLCAttempts,
+		result.DeletedBytes)
+
+	return result, nil
+}
+
+// countPayments counts the payments and HTLC attempts in the channel DB and
+// sums up the size of all keys and values of the payment buckets.
+func countPayments(db kvdb.Backend) (*paymentStats, error) {
+	var countBytes func(bucket walletdb.ReadBucket,
+		stats *paymentStats) error
+	countBytes = func(bucket walletdb.ReadBucket,
+		stats *paymentStats) error {
+
+		return bucket.ForEach(func(k, v []byte) error {
+			stats.Bytes += uint64(len(k) + len(v))
+
+			// The SQL backends return nil for empty values too.
+			var nested walletdb.ReadBucket
+			if v == nil {
+				nested = bucket.NestedReadBucket(k)
+			}
+			if nested == nil {
+				return nil
+			}
+
+			return countBytes(nested, stats)
+		})
+	}
+
+	stats := &paymentStats{}
+	err := kvdb.View(db, func(tx kvdb.RTx) error {
+		if index := tx.ReadBucket(paymentsIndexBucket); index != nil {
+			if err := countBytes(index, stats); err != nil {
+				return err
+			}
+		}
+
+		payments := tx.ReadBucket(paymentsRootBucket)
+		if payments == nil {
+			return nil
+		}
+		if err := countBytes(payments, stats); err != nil {
+			return err
+		}
+
+		return payments.ForEach(func(k, _ []byte) error {
+			payment := payments.NestedReadBucket(k)
+			if payment == nil {
+				return nil
+			}
+			stats.Payments++
+
+			htlcs := payment.NestedReadBucket(paymentHtlcsBucket)
+			if htlcs == nil {
+				return nil
+			}
+
+			return htlcs.ForEach(func(k, _ []byte) error {
+				if bytes.HasPrefix(k, htlcAttemptInfoKey) {
+					stats.HTLCAttempts++
+				}
+
+				return nil
+			})
+		})
+	}, func() {
+		stats = &paymentStats{}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error counting payments: %w", err)
+	}
+
+	return stats, nil
+}
+
+// boltFreeBytes returns the number of bytes in free pages of the given bolt
+// database file.
+func boltFreeBytes(dbFile string) (int, error) {
+	// The freelist is only loaded in write mode.
+	db, err := (&compactDBCommand{}).openDB(dbFile, false)
+	if err != nil {
+		return 0, fmt.Errorf("error opening channel DB: %w", err)
 	}
 	defer func() { _ = db.Close() }()
 
-	return db.DeletePayments(c.FailedOnly, false)
+	stats, err := statsDB(db)
+	if err != nil {
+		return 0, fmt.Errorf("error reading channel DB stats: %w", err)
+	}
+
+	return stats.FreeBytes, nil
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/routing/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeletePayments(t *testing.T) {
+	h := newHarness(t)
+
+	channelDB, err := ioutil.ReadFile(h.testdataFile("channel.db"))
+	require.NoError(t, err)
+	dbFile := h.tempFile("channel.db")
+	require.NoError(t, ioutil.WriteFile(dbFile, channelDB, 0600))
+
+	// We add a failed payment with two failed attempts, a successful
+	// payment with one failed and one settled attempt and a payment that
+	// is still in flight.
+	db, err := lnd.OpenDB(dbFile, false)
+	require.NoError(t, err)
+	sessionKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	hop := &route.Hop{
+		ChannelID:        1,
+		OutgoingTimeLock: 100,
+		AmtToForward:     1000,
+	}
+	testRoute, err := route.NewRouteFromHops(1000, 100, route.Vertex{},
+		[]*route.Hop{hop})
+	require.NoError(t, err)
+
+	control := channeldb.NewPaymentControl(db)
+	attemptID := uint64(0)
+	addPayment := func(numFailed int, settle, fail bool) {
+		var preimage lntypes.Preimage
+		preimage[0] = byte(attemptID + 1)
+		hash := preimage.Hash()
+
+		require.NoError(t, control.InitPayment(
+			hash, &channeldb.PaymentCreationInfo{
+				PaymentIdentifier: hash,
+				Value:             1000,
+				CreationTime:      time.Unix(1000, 0),
+			},
+		))
+		addAttempt := func() uint64 {
+			attemptID++
+			_, err := control.RegisterAttempt(
+				hash, channeldb.NewHtlcAttemptInfo(
+					attemptID, sessionKey, *testRoute,
+					time.Unix(1000, 0), &hash,
+				),
+			)
+			require.NoError(t, err)
+
+			return attemptID
+		}
+		for i := 0; i < numFailed; i++ {
+			_, err := control.FailAttempt(
+				hash, addAttempt(), &channeldb.HTLCFailInfo{
+					Reason: channeldb.HTLCFailUnreadable,
+				},
+			)
+			require.NoError(t, err)
+		}
+		switch {
+		case settle:
+			_, err := control.SettleAttempt(
+				hash, addAttempt(), &channeldb.HTLCSettleInfo{
+					Preimage: preimage,
+				},
+			)
+			require.NoError(t, err)
+
+		case fail:
+			_, err := control.Fail(
+				hash, channeldb.FailureReasonNoRoute,
+			)
+			require.NoError(t, err)
+
+		default:
+			addAttempt()
+		}
+	}
+	addPayment(2, false, true)
+	addPayment(1, true, false)
+	addPayment(0, false, false)
+	require.NoError(t, db.Close())
+
+	countStats := func() *paymentStats {
+		backend, err := lnd.OpenBackend(
+			&lnd.DBConfig{Path: dbFile}, true, false,
+		)
+		require.NoError(t, err)
+		defer func() { _ = backend.Close() }()
+
+		stats, err := countPayments(backend)
+		require.NoError(t, err)
+		return stats
+	}
+	before := countStats()
+	require.EqualValues(t, 3, before.Payments)
+	require.EqualValues(t, 5, before.HTLCAttempts)
+
+	// A dry run only reports the failed HTLC attempts of the payments
+	// that are not in flight anymore.
+	DryRun = true
+	defer func() { DryRun = false }()
+
+	deletePayments := &deletePaymentsCommand{
+		ChannelDB:       dbFile,
+		FailedHTLCsOnly: true,
+	}
+	require.NoError(t, deletePayments.Execute(nil, nil))
+	h.assertLogContains("Dry run, not deleting 0 payments and 3 HTLC " +
+		"attempts")
+	require.Equal(t, before, countStats())
+
+	// Now we delete the failed attempts for real.
+	DryRun = false
+	h.clearLog()
+	require.NoError(t, deletePayments.Execute(nil, nil))
+	h.assertLogContains("Deleted 0 payments and 3 HTLC attempts")
+	h.assertLogContains("Freed ")
+	afterHTLCs := countStats()
+	require.EqualValues(t, 3, afterHTLCs.Payments)
+	require.EqualValues(t, 2, afterHTLCs.HTLCAttempts)
+	require.Less(t, afterHTLCs.Bytes, before.Bytes)
+
+	// Deleting all payments keeps the one in flight.
+	h.clearLog()
+	deletePayments.FailedHTLCsOnly = false
+	require.NoError(t, deletePayments.Execute(nil, nil))
+	h.assertLogContains("Deleted 2 payments and 1 HTLC attempts")
+	after := countStats()
+	require.EqualValues(t, 1, after.Payments)
+	require.EqualValues(t, 1, after.HTLCAttempts)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/spf13/cobra"
+)
+
+// channelKey is one of the keys of a channel together with its name.
+type channelKey struct {
+	name string
+	desc keychain.KeyDescriptor
+}
+
+// commitSecret is a per-commitment secret together with a description of how
+// the revocation root it was derived from was created.
+type commitSecret struct {
+	name   string
+	secret *chainhash.Hash
+}
+
+type deriveChannelKeysCommand struct {
+	ChannelDB    string
+	ChannelPoint string
+	Index        uint32
+	CommitHeight uint64
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newDeriveChannelKeysCommand() *cobra.Command {
+	cc := &deriveChannelKeysCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "derivechannelkeys",
+		Short: "Derive all keys of a single channel, including the " +
+			"private keys",
+		Long: `This command derives all keys of our side of a single
+channel and prints both the public and private parts. These are the funding
+(multisig) key, the revocation, HTLC, payment and delay base points as well as
+the commit point with its secret. This can be useful for manual recovery work.
+
+If a channel DB is given, the key locators of the channel with the given
+channel point are read from the DB and the derived public keys are checked
+against the ones stored for the channel. The commit point is the one of the
+current local commitment transaction.
+
+Without a channel DB, all base points are derived with the key index given with
+--index. This is the index of the channel's keys in all key families, which is
+the same for all keys of a channel in most cases. Because lnd changed the way
+the revocation root of a channel is derived over time, the commit point for the
+commit height given with --commitheight is printed for each of those ways: the
+legacy way of lnd v0.12.0-beta and earlier and the ECDH based way of later
+versions, with the same or the next revocation root key index.`,
+		Example: `chantools derivechannelkeys \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--channelpoint abcdef01234...:x
+
+chantools derivechannelkeys --index 5 --commitheight 12`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to read "+
+			"the channel's key locators from",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelPoint, "channelpoint", "", "channel point of the "+
+			"channel to derive the keys for (<txid>:<txindex>); "+
+			"required if --channeldb is set",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.Index, "index", 0, "index of the channel's keys within "+
+			"their key families; only used if no --channeldb is "+
+			"given",
+	)
+	cc.cmd.Flags().Uint64Var(
+		&cc.CommitHeight, "commitheight", 0, "commit height to derive "+
+			"the commit point for; only used if no --channeldb is "+
+			"given",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "deriving the channel keys")
+
+	return cc.cmd
+}
+
+func (c *deriveChannelKeysCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	if c.ChannelDB == "" {
+		keys, secrets, err := channelKeysFromIndex(
+			extendedKey, c.Index, c.CommitHeight,
+		)
+		if err != nil {
+			return err
+		}
+
+		return printChannelKeys(
+			extendedKey, fmt.Sprintf("Key index: %d", c.Index),
+			keys, c.CommitHeight, secrets,
+		)
+	}
+
+	if c.ChannelPoint == "" {
+		return fmt.Errorf("channel point is required when using a " +
+			"channel DB")
+	}
+	chanPoint, err := lnd.ParseOutpoint(c.ChannelPoint)
+	if err != nil {
+		return fmt.Errorf("error parsing channel point: %w", err)
+	}
+
+	db, err := openChannelDB(c.ChannelDB, true)
+	if err != nil {
+		return fmt.Errorf("error opening channel DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	channel, err := db.ChannelStateDB().FetchChannel(nil, *chanPoint)
+	if err != nil {
+		return fmt.Errorf("error fetching channel %v: %w", chanPoint,
+			err)
+	}
+
+	keys, secrets, err := channelKeysFromDB(channel)
+	if err != nil {
+		return err
+	}
+
+	return printChannelKeys(
+		extendedKey, fmt.Sprintf("Channel point: %v", chanPoint), keys,
+		channel.LocalCommitment.CommitHeight, secrets,
+	)
+}
+
+// channelKeysFromDB returns the keys of our side of the channel and the
+// commit secret of the current local commitment.
+func channelKeysFromDB(channel *channeldb.OpenChannel) ([]channelKey,
+	[]commitSecret, error) {
+
+	cfg := channel.LocalChanCfg
+	keys := []channelKey{
+		{name: "Funding (multisig) key", desc: cfg.MultiSigKey},
+		{name: "Revocation base point", desc: cfg.RevocationBasePoint},
+		{name: "HTLC base point", desc: cfg.HtlcBasePoint},
+		{name: "Payment base point", desc: cfg.PaymentBasePoint},
+		{name: "Delay base point", desc: cfg.DelayBasePoint},
+	}
+
+	secret, err := channel.RevocationProducer.AtIndex(
+		channel.LocalCommitment.CommitHeight,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error deriving commit secret: %w",
+			err)
+	}
+
+	return keys, []commitSecret{{name: "channel DB", secret: secret}}, nil
+}
+
+// channelKeysFromIndex returns the keys of a channel that uses the given index
+// in all key families and the commit secrets for the given commit height. There
+// is one commit secret for each way lnd used to derive the revocation root
+// over time.
+func channelKeysFromIndex(extendedKey *hdkeychain.ExtendedKey, index uint32,
+	commitHeight uint64) ([]channelKey, []commitSecret, error) {
+
+	keyDesc := func(family keychain.KeyFamily) keychain.KeyDescriptor {
+		return keychain.KeyDescriptor{
+			KeyLocator: keychain.KeyLocator{
+				Family: family,
+				Index:  index,
+			},
+		}
+	}
+	keys := []channelKey{{
+		name: "Funding (multisig) key",
+		desc: keyDesc(keychain.KeyFamilyMultiSig),
+	}, {
+		name: "Revocation base point",
+		desc: keyDesc(keychain.KeyFamilyRevocationBase),
+	}, {
+		name: "HTLC base point",
+		desc: keyDesc(keychain.KeyFamilyHtlcBase),
+	}, {
+		name: "Payment base point",
+		desc: keyDesc(keychain.KeyFamilyPaymentBase),
+	}, {
+		name: "Delay base point",
+		desc: keyDesc(keychain.KeyFamilyDelayBase),
+	}}
+
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	multiSigPrivKey, err := signer.FetchPrivKey(&keys[0].desc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error deriving multisig key: %w",
+			err)
+	}
+
+	// Up to lnd v0.12.0-beta the revocation root key itself was the
+	// revocation root. Since v0.13.0-beta it's an ECDH of the revocation
+	// root key and our multisig key, where the index of the revocation root
+	// key is one larger if the node was created with v0.13.0-beta or later.
+	// See sweeptimelockmanual for more details.
+	schemes := []struct {
+		name           string
+		index          uint32
+		multiSigPubKey *btcec.PublicKey
+	}{
+		{"legacy", index, nil},
+		{"ECDH", index, multiSigPrivKey.PubKey()},
+		{"ECDH", index + 1, multiSigPrivKey.PubKey()},
+	}
+
+	secrets := make([]commitSecret, 0, len(schemes))
+	for _, scheme := range schemes {
+		revRootPath := lnd.KeyLocatorPath(
+			chainParams, keychain.KeyFamilyRevocationRoot,
+			scheme.index,
+		)
+		parsedPath, err := lnd.ParsePath(revRootPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		revRoot, err := lnd.ShaChainFromPath(
+			extendedKey, parsedPath, scheme.multiSigPubKey,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error deriving "+
+				"revocation root: %w", err)
+		}
+
+		secret, err := revRoot.AtIndex(commitHeight)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error deriving commit "+
+				"secret: %w", err)
+		}
+
+		secrets = append(secrets, commitSecret{
+			name: fmt.Sprintf(
+				"%s (%s)", scheme.name, revRootPath,
+			),
+			secret: secret,
+		})
+	}
+
+	return keys, secrets, nil
+}
+
+// printChannelKeys derives the private keys of all channel keys and prints them
+// together with the commit point(s).
+func printChannelKeys(extendedKey *hdkeychain.ExtendedKey, header string,
+	keys []channelKey, commitHeight uint64, secrets []commitSecret) error {
+
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	var (
+		buf bytes.Buffer
+		w   = tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	)
+	_, _ = fmt.Fprintf(w, "%s\nNetwork: %s\n\n", header, chainParams.Name)
+	_, _ = fmt.Fprintln(w, "Key\tPath\tPublic key\tPrivate key")
+
+	for _, key := range keys {
+		privKey, err := signer.FetchPrivKey(&key.desc)
+		if err != nil {
+			return fmt.Errorf("error deriving %s: %w", key.name,
+				err)
+		}
+
+		// If we know the public key, we make sure the root key
+		// actually belongs to the channel.
+		pubKey := privKey.PubKey()
+		if key.desc.PubKey != nil && !key.desc.PubKey.IsEqual(pubKey) {
+			return fmt.Errorf("derived %s %x doesn't match the "+
+				"channel's public key %x, wrong root key?",
+				key.name, pubKey.SerializeCompressed(),
+				key.desc.PubKey.SerializeCompressed())
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%x\t%x\n", key.name,
+			lnd.KeyLocatorPath(
+				chainParams, key.desc.Family, key.desc.Index,
+			), pubKey.SerializeCompressed(), privKey.Serialize())
+	}
+
+	_, _ = fmt.Fprintf(w, "\nCommit height: %d\n\n", commitHeight)
+	_, _ = fmt.Fprintln(w, "Revocation root\tCommit point\tCommit secret")
+	for _, secret := range secrets {
+		commitPoint := input.ComputeCommitmentPoint(secret.secret[:])
+		_, _ = fmt.Fprintf(w, "%s\t%x\t%x\n", secret.name,
+			commitPoint.SerializeCompressed(), secret.secret[:])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	result := buf.String()
+	fmt.Fprintln(resultOut, result)
+
+	// For the tests, also log as trace level which is disabled by default.
+	log.Tracef(result)
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testChanPoint = "10279f62619634058b6133cb7ac6c1693a8e6df7caa91c6263ca" +
+		"3d0bf704ad4d:0"
+
+	testChanMultiSigKey = "022ebe13208aa99a3f31e9af33714fa27a374ecb3ad72b6f" +
+		"9b8dab9fe1e4ee753f"
+	testChanDelayPrivKey = "64adacc50fa0da0808573285e339681640448f915856" +
+		"90b0730baa4710865c2b"
+	testChanCommitPoint = "02de22394a9afded22d95b0ed0fd83d01805e2a248b7eb7d" +
+		"a50cc1c33dac3b4725"
+)
+
+func TestDeriveChannelKeysFromDB(t *testing.T) {
+	h := newHarness(t)
+
+	derive := &deriveChannelKeysCommand{
+		ChannelDB:    h.testdataFile("channel.db"),
+		ChannelPoint: testChanPoint,
+		rootKey:      &rootKey{RootKey: rootKeyAezeed},
+	}
+
+	err := derive.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(testChanMultiSigKey)
+	h.assertLogContains(testChanDelayPrivKey)
+	h.assertLogContains(testChanCommitPoint)
+}
+
+func TestDeriveChannelKeysFromIndex(t *testing.T) {
+	h := newHarness(t)
+
+	// The test channel was created with the legacy revocation root, so
+	// its commit point must be among the ones derived from the index.
+	derive := &deriveChannelKeysCommand{
+		Index:   2,
+		rootKey: &rootKey{RootKey: rootKeyAezeed},
+	}
+
+	err := derive.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(testChanMultiSigKey)
+	h.assertLogContains(testChanDelayPrivKey)
+	h.assertLogContains(testChanCommitPoint)
+}
+
+func TestDeriveChannelKeysWrongRootKey(t *testing.T) {
+	h := newHarness(t)
+
+	derive := &deriveChannelKeysCommand{
+		ChannelDB:    h.testdataFile("channel.db"),
+		ChannelPoint: testChanPoint,
+		rootKey:      &rootKey{RootKey: rootKeyBip39},
+	}
+
+	err := derive.Execute(nil, nil)
+	require.ErrorContains(t, err, "wrong root key")
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/keychain"
 	"github.com/spf13/cobra"
 )
 
@@ -23,8 +28,11 @@ Extended private key (xprv):	%s
 
 type deriveKeyCommand struct {
 	Path     string
+	Family   int32
+	Index    uint32
 	Neuter   bool
 	Identity bool
+	WIF      bool
 
 	rootKey *rootKey
 	cmd     *cobra.Command
@@ -36,16 +44,51 @@ func newDeriveKeyCommand() *cobra.Command {
 		Use:   "derivekey",
 		Short: "Derive a key with a specific derivation path",
 		Long: `This command derives a single key with the given BIP32
-derivation path from the root key and prints it to the console.`,
+derivation path from the root key and prints it to the console.
+
+If the last element of the path is an index range (for example
+m/84'/0'/0'/0/0-500 or m/1017'/0'/6'/0/0'-100'), all keys in that range
+(including both ends) are derived and printed as a table of index, public key,
+native SegWit (P2WKH) address and, unless --neuter is set, the private key in
+the WIF format. This is useful when searching for a specific key.
+
+Instead of a path, a key of lnd's internal keychain can be selected with its
+key family and index (--family and --index), which results in the path
+m/1017'/<coin_type>'/<family>'/0/<index>. Some of the key families are:
+0 (multisig), 1 (revocation base), 2 (HTLC base), 3 (payment base), 4 (delay
+base), 5 (revocation root) and 6 (node key).
+
+With --wif, only the private key(s) in the WIF format are printed to stdout, one
+per line, so they can directly be imported into another wallet, for example
+with bitcoin-cli's importprivkey command. Electrum expects the script type as a
+prefix, use p2wpkh:<WIF> to import a key for a native SegWit address.`,
 		Example: `chantools derivekey --path "m/1017'/0'/5'/0/0'" \
 	--neuter
 
+chantools derivekey --path "m/84'/0'/0'/0/0-500" --neuter
+
+chantools derivekey --family 0 --index 3
+
+chantools derivekey --path "m/84'/0'/0'/0/0-20" --wif > keys.txt
+
 chantools derivekey --identity`,
 		RunE: cc.Execute,
+		Annotations: map[string]string{
+			annotationStdoutFlag: "wif",
+		},
 	}
 	cc.cmd.Flags().StringVar(
 		&cc.Path, "path", "", "BIP32 derivation path to derive; must "+
-			"start with \"m/\"",
+			"start with \"m/\"; the last element can be an index "+
+			"range in the format <start>-<end>",
+	)
+	cc.cmd.Flags().Int32Var(
+		&cc.Family, "family", -1, "lnd key family of the key to derive; "+
+			"only used if no --path is given",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.Index, "index", 0, "index of the key to derive within the "+
+			"key family given with --family",
 	)
 	cc.cmd.Flags().BoolVar(
 		&cc.Neuter, "neuter", false, "don't output private key(s), "+
@@ -55,6 +98,10 @@ chantools derivekey --identity`,
 		&cc.Identity, "identity", false, "derive the lnd "+
 			"identity_pubkey",
 	)
+	cc.cmd.Flags().BoolVar(
+		&cc.WIF, "wif", false, "only print the private key(s) in "+
+			"the WIF format, one per line",
+	)
 
 	cc.rootKey = newRootKey(cc.cmd, "decrypting the backup")
 
@@ -67,16 +114,173 @@ func (c *deriveKeyCommand) Execute(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("error reading root key: %w", err)
 	}
 
-	if c.Identity {
+	switch {
+	case c.Identity:
 		c.Path = lnd.IdentityPath(chainParams)
 		c.Neuter = true
+
+	case c.Path == "" && c.Family >= 0:
+		c.Path = lnd.KeyLocatorPath(
+			chainParams, keychain.KeyFamily(c.Family), c.Index,
+		)
+	}
+	if c.WIF && c.Neuter {
+		return fmt.Errorf("cannot print private keys in the WIF " +
+			"format when --neuter or --identity is set")
+	}
+
+	basePath, start, end, hardened, isRange, err := parsePathRange(c.Path)
+	if err != nil {
+		return err
+	}
+	if isRange {
+		return deriveKeyRange(
+			extendedKey, basePath, start, end, hardened, c.Neuter,
+			c.WIF,
+		)
+	}
+
+	return deriveKey(extendedKey, c.Path, c.Neuter, c.WIF)
+}
+
+// parsePathRange checks if the last element of the given derivation path is an
+// index range in the format <start>-<end>, optionally with both indexes being
+// hardened. The path without the last element is returned as the base path.
+func parsePathRange(path string) (string, uint32, uint32, bool, bool,
+	error) {
+
+	lastSlash := strings.LastIndex(path, "/")
+	if lastSlash < 0 || !strings.Contains(path[lastSlash+1:], "-") {
+		return path, 0, 0, false, false, nil
+	}
+
+	basePath, indexRange := path[:lastSlash], path[lastSlash+1:]
+	parts := strings.Split(indexRange, "-")
+	if len(parts) != 2 {
+		return "", 0, 0, false, false, fmt.Errorf("invalid index "+
+			"range '%s'", indexRange)
+	}
+
+	hardened := strings.HasSuffix(parts[0], "'")
+	if hardened != strings.HasSuffix(parts[1], "'") {
+		return "", 0, 0, false, false, fmt.Errorf("both ends of the "+
+			"index range '%s' must either be hardened or not",
+			indexRange)
+	}
+
+	start, err := strconv.ParseUint(
+		strings.TrimSuffix(parts[0], "'"), 10, 31,
+	)
+	if err != nil {
+		return "", 0, 0, false, false, fmt.Errorf("invalid start "+
+			"index: %w", err)
+	}
+	end, err := strconv.ParseUint(
+		strings.TrimSuffix(parts[1], "'"), 10, 31,
+	)
+	if err != nil {
+		return "", 0, 0, false, false, fmt.Errorf("invalid end "+
+			"index: %w", err)
+	}
+	if end < start {
+		return "", 0, 0, false, false, fmt.Errorf("end index must " +
+			"not be smaller than start index")
+	}
+
+	return basePath, uint32(start), uint32(end), hardened, true, nil
+}
+
+func deriveKeyRange(extendedKey *hdkeychain.ExtendedKey, basePath string,
+	start, end uint32, hardened, neuter, wifOnly bool) error {
+
+	// The range might also be directly below the root key.
+	var parsedPath []uint32
+	if basePath != "m" {
+		var err error
+		parsedPath, err = lnd.ParsePath(basePath)
+		if err != nil {
+			return fmt.Errorf("could not parse derivation path: %w",
+				err)
+		}
+	}
+	parent, err := lnd.DeriveFromRoot(extendedKey, parsedPath)
+	if err != nil {
+		return fmt.Errorf("could not derive children: %w", err)
+	}
+
+	var (
+		buf     bytes.Buffer
+		w       = tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+		wifKeys []string
+	)
+	_, _ = fmt.Fprintf(w, "Path: %s/<index>\nNetwork: %s\n\n", basePath,
+		chainParams.Name)
+	_, _ = fmt.Fprintln(w, "Index\tPublic key\tAddress\tPrivate key "+
+		"(WIF)")
+
+	for idx := start; idx <= end; idx++ {
+		childIdx, indexStr := idx, strconv.FormatUint(uint64(idx), 10)
+		if hardened {
+			childIdx += lnd.HardenedKeyStart
+			indexStr += "'"
+		}
+
+		child, err := parent.DeriveNonStandard(childIdx)
+		if err != nil {
+			return fmt.Errorf("could not derive child %s: %w",
+				indexStr, err)
+		}
+		pubKey, err := child.ECPubKey()
+		if err != nil {
+			return fmt.Errorf("could not derive public key: %w",
+				err)
+		}
+		addr, err := lnd.P2WKHAddr(pubKey, chainParams)
+		if err != nil {
+			return fmt.Errorf("could not create address: %w", err)
+		}
+
+		privKey := na
+		if !neuter {
+			ecPrivKey, err := child.ECPrivKey()
+			if err != nil {
+				return fmt.Errorf("could not derive private "+
+					"key: %w", err)
+			}
+			wif, err := btcutil.NewWIF(ecPrivKey, chainParams, true)
+			if err != nil {
+				return fmt.Errorf("could not encode WIF: %w",
+					err)
+			}
+			privKey = wif.String()
+		}
+
+		if wifOnly {
+			wifKeys = append(wifKeys, privKey)
+			continue
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%x\t%s\t%s\n", indexStr,
+			pubKey.SerializeCompressed(), addr, privKey)
+	}
+	if err := w.Flush(); err != nil {
+		return err
 	}
 
-	return deriveKey(extendedKey, c.Path, c.Neuter)
+	result := buf.String()
+	if wifOnly {
+		result = strings.Join(wifKeys, "\n")
+	}
+	fmt.Fprintln(resultOut, result)
+
+	// For the tests, also log as trace level which is disabled by default.
+	log.Tracef(result)
+
+	return nil
 }
 
 func deriveKey(extendedKey *hdkeychain.ExtendedKey, path string,
-	neuter bool) error {
+	neuter, wifOnly bool) error {
 
 	child, pubKey, wif, err := lnd.DeriveKey(extendedKey, path, chainParams)
 	if err != nil {
@@ -115,7 +319,10 @@ func deriveKey(extendedKey *hdkeychain.ExtendedKey, path string,
 		pubKey.SerializeCompressed(), neutered, addrP2WKH, addrP2PKH,
 		addrP2TR, privKey, xPriv,
 	)
-	fmt.Println(result)
+	if wifOnly {
+		result = privKey
+	}
+	fmt.Fprintln(resultOut, result)
 
 	// For the tests, also log as trace level which is disabled by default.
 	log.Tracef(result)
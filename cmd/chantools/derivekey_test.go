@@ -12,6 +12,7 @@ const (
 	testPath        = "m/123'/45'/67'/8/9"
 	keyContent      = "bcrt1qnl5qfvpfcmj7y56nugpermluu46x79sfz0ku70"
 	keyContentBIP39 = "bcrt1q3pae32m7jdqm5ulf80yc3n59xy4s4xm5a28ekr"
+	keyContentWIF   = "cTu2PNArgJ9vdFBTMzYbiTGm11Px5je2tjF2a8ZYq7reNwGjWee8"
 )
 
 func TestDeriveKey(t *testing.T) {
@@ -82,3 +83,109 @@ func TestDeriveKeySeedBip39(t *testing.T) {
 
 	h.assertLogContains(keyContentBIP39)
 }
+
+func TestDeriveKeyRange(t *testing.T) {
+	h := newHarness(t)
+
+	// Derive a range of keys that contains the test key.
+	derive := &deriveKeyCommand{
+		Path:    "m/123'/45'/67'/8/7-10",
+		Neuter:  true,
+		rootKey: &rootKey{RootKey: rootKeyAezeed},
+	}
+
+	err := derive.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(keyContent)
+}
+
+func TestDeriveKeyWIF(t *testing.T) {
+	h := newHarness(t)
+
+	// Only print the private keys of a range containing the test key.
+	derive := &deriveKeyCommand{
+		Path:    "m/123'/45'/67'/8/8-9",
+		WIF:     true,
+		rootKey: &rootKey{RootKey: rootKeyAezeed},
+	}
+
+	err := derive.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(keyContentWIF)
+
+	// Private keys can't be printed for a neutered key.
+	derive.Neuter = true
+	err = derive.Execute(nil, nil)
+	require.ErrorContains(t, err, "cannot print private keys")
+}
+
+func TestDeriveKeyFamilyIndex(t *testing.T) {
+	h := newHarness(t)
+
+	// Derive the key by its lnd key family and index.
+	derive := &deriveKeyCommand{
+		Family:  1,
+		Index:   3,
+		rootKey: &rootKey{RootKey: rootKeyAezeed},
+	}
+
+	err := derive.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains("m/1017'/1'/1'/0/3")
+}
+
+func TestParsePathRange(t *testing.T) {
+	testCases := []struct {
+		path     string
+		basePath string
+		start    uint32
+		end      uint32
+		hardened bool
+		isRange  bool
+		err      string
+	}{{
+		path:     testPath,
+		basePath: testPath,
+	}, {
+		path:     "m/84'/0'/0'/0/0-500",
+		basePath: "m/84'/0'/0'/0",
+		end:      500,
+		isRange:  true,
+	}, {
+		path:     "m/1017'/0'/6'/0/3'-5'",
+		basePath: "m/1017'/0'/6'/0",
+		start:    3,
+		end:      5,
+		hardened: true,
+		isRange:  true,
+	}, {
+		path: "m/0/1'-5",
+		err:  "must either be hardened or not",
+	}, {
+		path: "m/0/5-1",
+		err:  "must not be smaller",
+	}, {
+		path: "m/0/1-2-3",
+		err:  "invalid index range",
+	}}
+
+	for _, tc := range testCases {
+		basePath, start, end, hardened, isRange, err := parsePathRange(
+			tc.path,
+		)
+		if tc.err != "" {
+			require.ErrorContains(t, err, tc.err)
+			continue
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, tc.basePath, basePath)
+		require.Equal(t, tc.start, start)
+		require.Equal(t, tc.end, end)
+		require.Equal(t, tc.hardened, hardened)
+		require.Equal(t, tc.isRange, isRange)
+	}
+}
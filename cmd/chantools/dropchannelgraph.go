@@ -3,16 +3,19 @@ package main
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/coreos/bbolt"
 	"github.com/guggero/chantools/lnd"
 	"github.com/lightningnetwork/lnd/chainreg"
 	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/kvdb"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/spf13/cobra"
 )
@@ -21,6 +24,13 @@ var (
 	nodeBucket      = []byte("graph-node")
 	edgeBucket      = []byte("graph-edge")
 	graphMetaBucket = []byte("graph-meta")
+
+	// graphBuckets are all top level buckets of the channel graph. The
+	// node update and alias indexes are nested in the node bucket, the
+	// edge, edge update, channel point, zombie and disabled edge policy
+	// indexes are nested in the edge bucket and the prune log is nested in
+	// the graph meta bucket.
+	graphBuckets = [][]byte{nodeBucket, edgeBucket, graphMetaBucket}
 )
 
 type dropChannelGraphCommand struct {
@@ -39,7 +49,15 @@ func newDropChannelGraphCommand() *cobra.Command {
 		Use:   "dropchannelgraph",
 		Short: "Remove all graph related data from a channel DB",
 		Long: `This command removes all graph data from a channel DB,
-forcing the lnd node to do a full graph sync.
+forcing the lnd node to do a full graph sync. This includes all nodes, channel
+edges and policies as well as the zombie, edge and channel point indexes and the
+prune log. All channel state (open and closed channels, invoices, payments and
+so on) is preserved. All buckets are removed in a single database transaction.
+
+Before anything is changed, a copy of the channel DB is written to
+<name>-backup-<timestamp>.db in the working directory (only for the bolt
+database backend). The own node's channels are then added to the empty graph
+again, so lnd can use them right away.
 
 Or if a single channel is specified, that channel is purged from the graph
 without removing any other data.
@@ -80,10 +98,17 @@ chantools dropchannelgraph \
 
 func (c *dropChannelGraphCommand) Execute(_ *cobra.Command, _ []string) error {
 	// Check that we have a channel DB.
-	if c.ChannelDB == "" {
+	if !channelDBGiven(c.ChannelDB) {
 		return fmt.Errorf("channel DB is required")
 	}
-	db, err := lnd.OpenDB(c.ChannelDB, false)
+
+	// Opening the DB in write mode might already apply migrations, so we
+	// create the backup before.
+	if err := backupChannelDB(c.ChannelDB); err != nil {
+		return err
+	}
+
+	db, err := openChannelDB(c.ChannelDB, false)
 	if err != nil {
 		return fmt.Errorf("error opening rescue DB: %w", err)
 	}
@@ -113,27 +138,65 @@ func (c *dropChannelGraphCommand) Execute(_ *cobra.Command, _ []string) error {
 	// Drop all channels, then insert our own channels into the graph again.
 	if !c.FixOnly {
 		log.Infof("Dropping all graph related buckets")
-
-		rwTx, err := db.BeginReadWriteTx()
-		if err != nil {
-			return err
-		}
-		if err := rwTx.DeleteTopLevelBucket(nodeBucket); err != nil {
-			return err
-		}
-		if err := rwTx.DeleteTopLevelBucket(edgeBucket); err != nil {
-			return err
-		}
-		if err := rwTx.DeleteTopLevelBucket(graphMetaBucket); err != nil {
-			return err
+		if err := dropGraphBuckets(db); err != nil {
+			return fmt.Errorf("error dropping graph: %w", err)
 		}
+	}
+
+	return insertOwnNodeAndChannels(idKey, db)
+}
 
-		if err := rwTx.Commit(); err != nil {
-			return err
+// dropGraphBuckets removes all top level buckets of the channel graph in a
+// single transaction. Missing buckets are skipped.
+func dropGraphBuckets(db *channeldb.DB) error {
+	return kvdb.Update(db, func(tx kvdb.RwTx) error {
+		for _, bucket := range graphBuckets {
+			err := tx.DeleteTopLevelBucket(bucket)
+			switch {
+			case errors.Is(err, kvdb.ErrBucketNotFound):
+				log.Infof("Bucket %s doesn't exist, skipping",
+					bucket)
+
+			case err != nil:
+				return fmt.Errorf("error deleting bucket %s: "+
+					"%w", bucket, err)
+
+			default:
+				log.Infof("Dropped bucket %s", bucket)
+			}
 		}
+
+		return nil
+	}, func() {})
+}
+
+// backupChannelDB writes a copy of the bolt channel DB to a timestamped file in
+// the working directory before it is modified.
+func backupChannelDB(dbFile string) error {
+	if dbConfig.Backend != "" && dbConfig.Backend != lnd.DBBackendBolt {
+		log.Warnf("Not creating a backup of the channel DB, only "+
+			"supported for the %s backend", lnd.DBBackendBolt)
+		return nil
 	}
 
-	return insertOwnNodeAndChannels(idKey, db)
+	compact := &compactDBCommand{}
+	src, err := compact.openDB(dbFile, true)
+	if err != nil {
+		return fmt.Errorf("error opening channel DB: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+
+	backupFile := backupFileName(WorkDir, dbFile)
+	log.Infof("Writing backup of channel DB to %s", backupFile)
+	err = src.View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(backupFile, dbFilePermission)
+	})
+	if err != nil {
+		return fmt.Errorf("error writing backup of channel DB: %w",
+			err)
+	}
+
+	return nil
 }
 
 func insertOwnNodeAndChannels(idKey *btcec.PublicKey, db *channeldb.DB) error {
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/stretchr/testify/require"
+)
+
+const testNodeIdentityKey = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2d" +
+	"ce28d959f2815b16f81798"
+
+func TestDropChannelGraph(t *testing.T) {
+	h := newHarness(t)
+
+	channelDB, err := ioutil.ReadFile(h.testdataFile("channel.db"))
+	require.NoError(t, err)
+	dbFile := h.tempFile("channel.db")
+	require.NoError(t, ioutil.WriteFile(dbFile, channelDB, 0600))
+
+	dump := &dumpChannelsCommand{
+		ChannelDB: dbFile,
+	}
+	h.clearLog()
+	require.NoError(t, dump.Execute(nil, nil))
+	channelsBefore := h.getLog()
+
+	drop := &dropChannelGraphCommand{
+		ChannelDB:       dbFile,
+		NodeIdentityKey: testNodeIdentityKey,
+	}
+	require.NoError(t, drop.Execute(nil, nil))
+	h.assertLogContains("Dropped bucket graph-edge")
+
+	// The untouched DB was backed up first.
+	backups, err := filepath.Glob(
+		filepath.Join(h.tempDir, "channel-backup-*.db"),
+	)
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	require.Equal(t, int64(len(channelDB)), h.fileSize(backups[0]))
+
+	// All channel state is preserved.
+	h.clearLog()
+	require.NoError(t, dump.Execute(nil, nil))
+	h.assertLogEqual(channelsBefore, h.getLog())
+
+	// Only our own channels are in the graph again.
+	db, err := openChannelDB(dbFile, true)
+	require.NoError(t, err)
+	openChannels, err := db.ChannelStateDB().FetchAllOpenChannels()
+	require.NoError(t, err)
+
+	var numEdges int
+	err = db.ChannelGraph().ForEachChannel(func(*channeldb.ChannelEdgeInfo,
+		*channeldb.ChannelEdgePolicy,
+		*channeldb.ChannelEdgePolicy) error {
+
+		numEdges++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(openChannels), numEdges)
+	require.NoError(t, db.Close())
+}
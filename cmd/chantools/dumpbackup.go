@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/guggero/chantools/dump"
 	"github.com/guggero/chantools/lnd"
 	"github.com/lightningnetwork/lnd/chanbackup"
@@ -13,6 +12,7 @@ import (
 
 type dumpBackupCommand struct {
 	MultiFile string
+	Format    string
 
 	rootKey *rootKey
 	cmd     *cobra.Command
@@ -24,15 +24,31 @@ func newDumpBackupCommand() *cobra.Command {
 		Use:   "dumpbackup",
 		Short: "Dump the content of a channel.backup file",
 		Long: `This command dumps all information that is inside a 
-channel.backup file in a human readable format.`,
+channel.backup file in a human readable format.
+
+With --format json, the backup is printed as JSON instead. The JSON can be
+edited (for example to fix a wrong peer address) and then be turned into an
+encrypted channel.backup file again with the encodebackup command.`,
 		Example: `chantools dumpbackup \
-	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup`,
+	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup
+
+chantools dumpbackup \
+	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup \
+	--format json > backup.json`,
 		RunE: cc.Execute,
+		Annotations: map[string]string{
+			annotationStdoutFormat: dumpFormatJSON,
+			annotationJSONFlags:    "format=" + dumpFormatJSON,
+		},
 	}
 	cc.cmd.Flags().StringVar(
 		&cc.MultiFile, "multi_file", "", "lnd channel.backup file to "+
 			"dump",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.Format, "format", dumpFormatSpew, "output format to use; "+
+			"either '"+dumpFormatSpew+"' or '"+dumpFormatJSON+"'",
+	)
 
 	cc.rootKey = newRootKey(cc.cmd, "decrypting the backup")
 
@@ -49,16 +65,26 @@ func (c *dumpBackupCommand) Execute(_ *cobra.Command, _ []string) error {
 	if c.MultiFile == "" {
 		return fmt.Errorf("backup file is required")
 	}
+	switch c.Format {
+	case "":
+		c.Format = dumpFormatSpew
+
+	case dumpFormatSpew, dumpFormatJSON:
+
+	default:
+		return fmt.Errorf("invalid format '%s'", c.Format)
+	}
+
 	multiFile := chanbackup.NewMultiFile(c.MultiFile)
 	keyRing := &lnd.HDKeyRing{
 		ExtendedKey: extendedKey,
 		ChainParams: chainParams,
 	}
-	return dumpChannelBackup(multiFile, keyRing)
+	return dumpChannelBackup(multiFile, keyRing, c.Format)
 }
 
 func dumpChannelBackup(multiFile *chanbackup.MultiFile,
-	ring keychain.KeyRing) error {
+	ring keychain.KeyRing, format string) error {
 
 	multi, err := multiFile.ExtractMulti(ring)
 	if err != nil {
@@ -68,10 +94,6 @@ func dumpChannelBackup(multiFile *chanbackup.MultiFile,
 		Version:       multi.Version,
 		StaticBackups: dump.BackupDump(multi, chainParams),
 	}
-	spew.Dump(content)
-
-	// For the tests, also log as trace level which is disabled by default.
-	log.Tracef(spew.Sdump(content))
 
-	return nil
+	return printDump(content, format)
 }
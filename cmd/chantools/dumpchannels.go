@@ -1,11 +1,15 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strings"
 
+	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/guggero/chantools/dump"
-	"github.com/guggero/chantools/lnd"
 	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/spf13/cobra"
 )
@@ -15,10 +19,25 @@ type dumpChannelsCommand struct {
 	Closed       bool
 	Pending      bool
 	WaitingClose bool
+	State        string
+	ChannelPoint string
+	Peer         string
+	Format       string
 
 	cmd *cobra.Command
 }
 
+const (
+	dumpFormatSpew = "spew"
+	dumpFormatJSON = "json"
+	dumpFormatCSV  = "csv"
+
+	dumpStateOpen         = "open"
+	dumpStatePending      = "pending"
+	dumpStatePendingClose = "pendingclose"
+	dumpStateClosed       = "closed"
+)
+
 func newDumpChannelsCommand() *cobra.Command {
 	cc := &dumpChannelsCommand{}
 	cc.cmd = &cobra.Command{
@@ -26,10 +45,53 @@ func newDumpChannelsCommand() *cobra.Command {
 		Short: "Dump all channel information from an lnd channel " +
 			"database",
 		Long: `This command dumps all open and pending channels from the
-given lnd channel.db gile in a human readable format.`,
+given lnd channel.db file in a human readable format.
+
+Use the --state flag to select which channels to dump: open (default),
+pending (funding transaction not confirmed yet), pendingclose (closing
+transaction not confirmed yet) or closed. With --channelpoint and --peer, only
+the channels with the given channel point or remote node public key are decoded
+and printed.
+
+For each channel, the address of the funding output is printed as well. For
+channels that are waiting for a closing transaction to confirm, the force or
+cooperative close transaction that was broadcast is included, if lnd stored
+one. The channels of all those states are also part of the --fromchanneldb
+input of the summary and sweep commands.
+
+Both the local and remote commitment of a channel contain the list of active
+HTLCs with their payment hash (RHash), amount, CLTV expiry (RefundTimeout),
+direction (Incoming) and index of the HTLC output in the commitment transaction
+(OutputIndex, -1 for dust HTLCs that don't have an output).
+
+For closed channels, the close summary (close type, settled and time locked
+balance) is printed together with the final state of the channel from lnd's
+historical channel bucket (which only exists for channels closed with lnd
+v0.10.0-beta or later).
+
+With --format json, the channels are printed as a JSON array instead. The field
+names of the JSON output are the snake_case versions of the field names of the
+default output and won't change between releases. Public keys, scripts and
+transactions are hex encoded, amounts ending in msat or balance (for
+commitments) are in milli-satoshis, all other amounts are in satoshis. The log
+output is written to stderr in that case so the JSON can be piped into other
+tools.`,
 		Example: `chantools dumpchannels \
-	--channeldb ~/.lnd/data/graph/mainnet/channel.db`,
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db
+
+chantools dumpchannels \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--state closed \
+	--peer 03abce...
+
+chantools dumpchannels \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--format json > channels.json`,
 		RunE: cc.Execute,
+		Annotations: map[string]string{
+			annotationStdoutFormat: dumpFormatJSON,
+			annotationJSONFlags:    "format=" + dumpFormatJSON,
+		},
 	}
 	cc.cmd.Flags().StringVar(
 		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to dump "+
@@ -47,107 +109,200 @@ given lnd channel.db gile in a human readable format.`,
 		&cc.WaitingClose, "waiting_close", false, "dump waiting close "+
 			"channels instead of open",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.State, "state", dumpStateOpen, "state of the channels to "+
+			"dump; either '"+dumpStateOpen+"', '"+
+			dumpStatePending+"', '"+dumpStatePendingClose+"' or '"+
+			dumpStateClosed+"'",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelPoint, "channelpoint", "", "only dump the channel "+
+			"with this channel point (<txid>:<txindex>)",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Peer, "peer", "", "only dump the channels with the remote "+
+			"node with this hex encoded public key",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Format, "format", dumpFormatSpew, "output format to use; "+
+			"either '"+dumpFormatSpew+"' or '"+dumpFormatJSON+"'",
+	)
 
 	return cc.cmd
 }
 
 func (c *dumpChannelsCommand) Execute(_ *cobra.Command, _ []string) error {
 	// Check that we have a channel DB.
-	if c.ChannelDB == "" {
+	if !channelDBGiven(c.ChannelDB) {
 		return fmt.Errorf("channel DB is required")
 	}
-	db, err := lnd.OpenDB(c.ChannelDB, true)
-	if err != nil {
-		return fmt.Errorf("error opening rescue DB: %w", err)
+	switch c.Format {
+	case "":
+		c.Format = dumpFormatSpew
+
+	case dumpFormatSpew, dumpFormatJSON:
+
+	default:
+		return fmt.Errorf("invalid format '%s'", c.Format)
 	}
-	defer func() { _ = db.Close() }()
 
-	if (c.Closed && c.Pending) || (c.Closed && c.WaitingClose) ||
-		(c.Pending && c.WaitingClose) ||
-		(c.Closed && c.Pending && c.WaitingClose) {
+	// The boolean flags are kept for backward compatibility and are just
+	// shortcuts for the --state flag.
+	switch {
+	case (c.Closed && c.Pending) || (c.Closed && c.WaitingClose) ||
+		(c.Pending && c.WaitingClose):
 
 		return fmt.Errorf("can only specify one flag at a time")
-	}
 
-	if c.Closed {
-		return dumpClosedChannelInfo(db.ChannelStateDB())
-	}
-	if c.Pending {
-		return dumpPendingChannelInfo(db.ChannelStateDB())
-	}
-	if c.WaitingClose {
-		return dumpWaitingCloseChannelInfo(db.ChannelStateDB())
+	case c.Closed:
+		c.State = dumpStateClosed
+
+	case c.Pending:
+		c.State = dumpStatePending
+
+	case c.WaitingClose:
+		c.State = dumpStatePendingClose
 	}
 
-	return dumpOpenChannelInfo(db.ChannelStateDB())
-}
+	var filter *dumpFilter
+	if c.ChannelPoint != "" || c.Peer != "" {
+		filter = &dumpFilter{
+			channelPoint: c.ChannelPoint,
+			peer:         c.Peer,
+		}
+	}
 
-func dumpOpenChannelInfo(chanDb *channeldb.ChannelStateDB) error {
-	channels, err := chanDb.FetchAllChannels()
+	db, err := openChannelDB(c.ChannelDB, true)
 	if err != nil {
-		return err
+		return fmt.Errorf("error opening rescue DB: %w", err)
 	}
+	defer func() { _ = db.Close() }()
 
-	dumpChannels, err := dump.OpenChannelDump(channels, chainParams)
+	dumpChannels, err := dumpChannelState(
+		db.ChannelStateDB(), c.State, filter,
+	)
 	if err != nil {
-		return fmt.Errorf("error converting to dump format: %w", err)
+		return err
 	}
 
-	spew.Dump(dumpChannels)
+	return printDump(dumpChannels, c.Format)
+}
 
-	// For the tests, also log as trace level which is disabled by default.
-	log.Tracef(spew.Sdump(dumpChannels))
+// dumpChannelState converts all channels of the given state that pass the
+// filter to the dump format.
+func dumpChannelState(chanDb *channeldb.ChannelStateDB, state string,
+	filter *dumpFilter) (interface{}, error) {
 
-	return nil
+	switch state {
+	case "", dumpStateOpen:
+		return dumpOpenChannelInfo(chanDb.FetchAllChannels, filter)
+
+	case dumpStatePending:
+		return dumpOpenChannelInfo(chanDb.FetchPendingChannels, filter)
+
+	case dumpStatePendingClose:
+		return dumpOpenChannelInfo(
+			chanDb.FetchWaitingCloseChannels, filter,
+		)
+
+	case dumpStateClosed:
+		return dumpClosedChannelInfo(chanDb, filter)
+
+	default:
+		return nil, fmt.Errorf("invalid state '%s'", state)
+	}
 }
 
-func dumpClosedChannelInfo(chanDb *channeldb.ChannelStateDB) error {
-	channels, err := chanDb.FetchClosedChannels(false)
-	if err != nil {
-		return err
+// dumpFilter restricts the channels that are dumped.
+type dumpFilter struct {
+	channelPoint string
+	peer         string
+}
+
+// match returns true if a channel with the given channel point and remote
+// node public key passes the filter.
+func (f *dumpFilter) match(chanPoint fmt.Stringer,
+	peer *btcec.PublicKey) bool {
+
+	if f == nil {
+		return true
 	}
 
-	dumpChannels, err := dump.ClosedChannelDump(channels, chainParams)
-	if err != nil {
-		return fmt.Errorf("error converting to dump format: %w", err)
+	if f.channelPoint != "" && f.channelPoint != chanPoint.String() {
+		return false
 	}
 
-	spew.Dump(dumpChannels)
+	if f.peer != "" && (peer == nil || !strings.EqualFold(
+		f.peer, hex.EncodeToString(peer.SerializeCompressed()),
+	)) {
 
-	// For the tests, also log as trace level which is disabled by default.
-	log.Tracef(spew.Sdump(dumpChannels))
+		return false
+	}
 
-	return nil
+	return true
 }
 
-func dumpPendingChannelInfo(chanDb *channeldb.ChannelStateDB) error {
-	channels, err := chanDb.FetchPendingChannels()
-	if err != nil {
-		return err
-	}
+func dumpOpenChannelInfo(fetch func() ([]*channeldb.OpenChannel, error),
+	filter *dumpFilter) (interface{}, error) {
 
-	dumpChannels, err := dump.OpenChannelDump(channels, chainParams)
+	channels, err := fetch()
 	if err != nil {
-		return fmt.Errorf("error converting to dump format: %w", err)
+		return nil, err
 	}
 
-	spew.Dump(dumpChannels)
+	// Only convert the channels we actually want to print.
+	filtered := make([]*channeldb.OpenChannel, 0, len(channels))
+	for _, channel := range channels {
+		if filter.match(&channel.FundingOutpoint, channel.IdentityPub) {
+			filtered = append(filtered, channel)
+		}
+	}
 
-	// For the tests, also log as trace level which is disabled by default.
-	log.Tracef(spew.Sdump(dumpChannels))
+	dumpChannels, err := dump.OpenChannelDump(filtered, chainParams)
+	if err != nil {
+		return nil, fmt.Errorf("error converting to dump format: %w",
+			err)
+	}
 
-	return nil
+	return dumpChannels, nil
 }
 
-func dumpWaitingCloseChannelInfo(chanDb *channeldb.ChannelStateDB) error {
-	channels, err := chanDb.FetchWaitingCloseChannels()
+func dumpClosedChannelInfo(chanDb *channeldb.ChannelStateDB,
+	filter *dumpFilter) (interface{}, error) {
+
+	channels, err := chanDb.FetchClosedChannels(false)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	filtered := make([]*channeldb.ChannelCloseSummary, 0, len(channels))
+	for _, channel := range channels {
+		if filter.match(&channel.ChanPoint, channel.RemotePub) {
+			filtered = append(filtered, channel)
+		}
 	}
 
-	dumpChannels, err := dump.OpenChannelDump(channels, chainParams)
+	dumpChannels, err := dump.ClosedChannelDump(
+		filtered, chainParams, chanDb.FetchHistoricalChannel,
+	)
 	if err != nil {
-		return fmt.Errorf("error converting to dump format: %w", err)
+		return nil, fmt.Errorf("error converting to dump format: %w",
+			err)
+	}
+
+	return dumpChannels, nil
+}
+
+// printDump prints the dumped channels in the given format.
+func printDump(dumpChannels interface{}, format string) error {
+	if format == dumpFormatJSON {
+		jsonBytes, err := json.MarshalIndent(dumpChannels, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding channels: %w", err)
+		}
+		_, err = fmt.Fprintln(resultOut, string(jsonBytes))
+
+		return err
 	}
 
 	spew.Dump(dumpChannels)
@@ -157,3 +312,19 @@ func dumpWaitingCloseChannelInfo(chanDb *channeldb.ChannelStateDB) error {
 
 	return nil
 }
+
+// printCSV prints the dumped records as CSV, including a header row.
+func printCSV(records []dump.CSVRecord, header []string) error {
+	w := csv.NewWriter(resultOut)
+	if err := w.Write(header); err != nil {
+		return fmt.Errorf("error writing CSV header: %w", err)
+	}
+	for _, record := range records {
+		if err := w.Write(record.CSVRecord()); err != nil {
+			return fmt.Errorf("error writing CSV record: %w", err)
+		}
+	}
+	w.Flush()
+
+	return w.Error()
+}
@@ -1,4 +1,245 @@
 package main
 
-// This file is empty for now, the dumpchannels command is covered by the test
-// in compactdb_test.go.
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/stretchr/testify/require"
+)
+
+// Dumping open channels is covered by the test in compactdb_test.go.
+
+func TestDumpFilter(t *testing.T) {
+	privKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	peer := privKey.PubKey()
+	peerHex := hex.EncodeToString(peer.SerializeCompressed())
+
+	chanPoint := &wire.OutPoint{Index: 1}
+
+	var noFilter *dumpFilter
+	require.True(t, noFilter.match(chanPoint, peer))
+
+	filter := &dumpFilter{channelPoint: chanPoint.String()}
+	require.True(t, filter.match(chanPoint, peer))
+	require.False(t, filter.match(&wire.OutPoint{Index: 2}, peer))
+
+	filter = &dumpFilter{peer: strings.ToUpper(peerHex)}
+	require.True(t, filter.match(chanPoint, peer))
+	require.False(t, filter.match(chanPoint, nil))
+
+	otherKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	require.False(t, filter.match(chanPoint, otherKey.PubKey()))
+}
+
+func TestDumpClosedChannels(t *testing.T) {
+	h := newHarness(t)
+
+	// Work on a copy of the test DB, as we need to close a channel.
+	compact := &compactDBCommand{
+		SourceDB: h.testdataFile("channel.db"),
+		DestDB:   h.tempFile("closed.db"),
+	}
+	err := compact.Execute(nil, nil)
+	require.NoError(t, err)
+
+	db, err := openChannelDB(compact.DestDB, false)
+	require.NoError(t, err)
+
+	channels, err := db.ChannelStateDB().FetchAllChannels()
+	require.NoError(t, err)
+	require.NotEmpty(t, channels)
+
+	channel := channels[0]
+	localBalance := channel.LocalCommitment.LocalBalance.ToSatoshis()
+	err = channel.CloseChannel(&channeldb.ChannelCloseSummary{
+		ChanPoint:      channel.FundingOutpoint,
+		ChainHash:      channel.ChainHash,
+		RemotePub:      channel.IdentityPub,
+		Capacity:       channel.Capacity,
+		SettledBalance: localBalance,
+		CloseType:      channeldb.LocalForceClose,
+		ShortChanID:    channel.ShortChannelID,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// The closed channel should be dumped with its final state from the
+	// historical channel bucket.
+	h.clearLog()
+	dump := &dumpChannelsCommand{
+		ChannelDB: compact.DestDB,
+		State:     dumpStateClosed,
+	}
+	err = dump.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(channel.FundingOutpoint.String())
+	h.assertLogContains("\"local_force_close\"")
+	h.assertLogContains("HistoricalChannel: (*dump.OpenChannel)")
+}
+
+func TestDumpOpenChannelsFormat(t *testing.T) {
+	h := newHarness(t)
+
+	// The default output is the spew dump of the lnd types.
+	dump := &dumpChannelsCommand{
+		ChannelDB: h.testdataFile("channel.db"),
+	}
+	err := dump.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(
+		"LocalCommitment: (channeldb.ChannelCommitment)",
+	)
+	h.assertLogContains("CommitTx: (*wire.MsgTx)")
+	h.assertLogContains("LocalShutdownScript: (lnwire.DeliveryAddress)")
+
+	// With --format json, transactions and scripts are hex encoded.
+	h.captureJSON()
+	dump.Format = dumpFormatJSON
+	err = dump.Execute(nil, nil)
+	require.NoError(t, err)
+
+	var channels []struct {
+		FundingAddress  string `json:"funding_address"`
+		LocalCommitment struct {
+			CommitTx  string `json:"commit_tx"`
+			CommitSig string `json:"commit_sig"`
+		} `json:"local_commitment"`
+		LocalShutdownScript *string `json:"local_shutdown_script"`
+	}
+	h.assertJSONOutput(&channels)
+	require.NotEmpty(t, channels)
+	for _, channel := range channels {
+		require.NotEmpty(t, channel.FundingAddress)
+
+		_, err := hex.DecodeString(channel.LocalCommitment.CommitTx)
+		require.NoError(t, err)
+		require.NotEmpty(t, channel.LocalCommitment.CommitTx)
+		_, err = hex.DecodeString(channel.LocalCommitment.CommitSig)
+		require.NoError(t, err)
+		require.NotNil(t, channel.LocalShutdownScript)
+	}
+}
+
+func TestDumpWaitingCloseChannels(t *testing.T) {
+	h := newHarness(t)
+
+	// Work on a copy of the test DB, as we need to close channels.
+	compact := &compactDBCommand{
+		SourceDB: h.testdataFile("channel.db"),
+		DestDB:   h.tempFile("waitingclose.db"),
+	}
+	err := compact.Execute(nil, nil)
+	require.NoError(t, err)
+
+	db, err := openChannelDB(compact.DestDB, false)
+	require.NoError(t, err)
+
+	channels, err := db.ChannelStateDB().FetchAllChannels()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(channels), 3)
+
+	// The first channel was force closed, the second one cooperatively.
+	// The third one is still open.
+	forceCloseTx := channels[0].LocalCommitment.CommitTx
+	require.NoError(
+		t, channels[0].MarkCommitmentBroadcasted(forceCloseTx, true),
+	)
+	coopCloseTx := wire.NewMsgTx(2)
+	coopCloseTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: channels[1].FundingOutpoint,
+	})
+	coopCloseTx.AddTxOut(&wire.TxOut{Value: 50_000, PkScript: []byte{0}})
+	require.NoError(
+		t, channels[1].MarkCoopBroadcasted(coopCloseTx, true),
+	)
+	require.NoError(t, db.Close())
+
+	h.captureJSON()
+	dump := &dumpChannelsCommand{
+		ChannelDB: compact.DestDB,
+		State:     dumpStatePendingClose,
+		Format:    dumpFormatJSON,
+	}
+	require.NoError(t, dump.Execute(nil, nil))
+
+	var result []struct {
+		FundingOutpoint        string `json:"funding_outpoint"`
+		FundingAddress         string `json:"funding_address"`
+		BroadcastedCommitment  string `json:"broadcasted_commitment"`
+		BroadcastedCooperative string `json:"broadcasted_cooperative"`
+	}
+	h.assertJSONOutput(&result)
+	require.Len(t, result, 2)
+
+	closingTxs := make(map[string][2]string, len(result))
+	for _, channel := range result {
+		// The funding address pays to the 2-of-2 multisig of the
+		// channel.
+		fundingAddr := testFundingAddress(
+			t, channels, channel.FundingOutpoint,
+		)
+		require.Equal(t, fundingAddr, channel.FundingAddress)
+
+		closingTxs[channel.FundingOutpoint] = [2]string{
+			channel.BroadcastedCommitment,
+			channel.BroadcastedCooperative,
+		}
+	}
+
+	serialize := func(tx *wire.MsgTx) string {
+		var buf bytes.Buffer
+		require.NoError(t, tx.Serialize(&buf))
+		return hex.EncodeToString(buf.Bytes())
+	}
+	require.Equal(t, map[string][2]string{
+		channels[0].FundingOutpoint.String(): {
+			serialize(forceCloseTx), "",
+		},
+		channels[1].FundingOutpoint.String(): {
+			"", serialize(coopCloseTx),
+		},
+	}, closingTxs)
+}
+
+// testFundingAddress returns the funding address of the channel with the given
+// channel point.
+func testFundingAddress(t *testing.T, channels []*channeldb.OpenChannel,
+	chanPoint string) string {
+
+	for _, channel := range channels {
+		if channel.FundingOutpoint.String() != chanPoint {
+			continue
+		}
+
+		localKey := channel.LocalChanCfg.MultiSigKey.PubKey
+		remoteKey := channel.RemoteChanCfg.MultiSigKey.PubKey
+		_, fundingOutput, err := input.GenFundingPkScript(
+			localKey.SerializeCompressed(),
+			remoteKey.SerializeCompressed(),
+			int64(channel.Capacity),
+		)
+		require.NoError(t, err)
+
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(
+			fundingOutput.PkScript, chainParams,
+		)
+		require.NoError(t, err)
+		require.Len(t, addrs, 1)
+
+		return addrs[0].EncodeAddress()
+	}
+
+	t.Fatalf("channel %s not found", chanPoint)
+	return ""
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/guggero/chantools/dump"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// forwardingQueryBatchSize is the number of forwarding events we
+	// fetch from the DB in one query.
+	forwardingQueryBatchSize = 10000
+)
+
+type dumpForwardsCommand struct {
+	ChannelDB string
+	StartTime int64
+	EndTime   int64
+	Format    string
+
+	cmd *cobra.Command
+}
+
+func newDumpForwardsCommand() *cobra.Command {
+	cc := &dumpForwardsCommand{}
+	cc.cmd = &cobra.Command{
+		Use:   "dumpforwards",
+		Short: "Dump the forwarding log from an lnd channel database",
+		Long: `This command dumps all successful forwarding events from
+the given lnd channel.db file. This can be used for accounting purposes (e.g.
+to sum up the routing fees earned in a time period), even if the node itself
+cannot be started anymore.
+
+The output is written to stdout as JSON (default) or CSV. The log output is
+written to stderr so the result can be piped into a file. All amounts are in
+milli-satoshis.`,
+		Example: `chantools dumpforwards \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--starttime 1672531200 \
+	--format csv > forwards.csv`,
+		RunE: cc.Execute,
+		Annotations: map[string]string{
+			annotationStdoutFormat: dumpFormatJSON + "," +
+				dumpFormatCSV,
+			annotationJSONFlags: "format=" + dumpFormatJSON,
+		},
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to dump "+
+			"the forwarding log from",
+	)
+	cc.cmd.Flags().Int64Var(
+		&cc.StartTime, "starttime", 0, "only dump events that "+
+			"happened at or after this unix timestamp (in seconds)",
+	)
+	cc.cmd.Flags().Int64Var(
+		&cc.EndTime, "endtime", 0, "only dump events that happened "+
+			"at or before this unix timestamp (in seconds); "+
+			"defaults to now",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Format, "format", dumpFormatJSON, "output format to use; "+
+			"either '"+dumpFormatJSON+"' or '"+dumpFormatCSV+"'",
+	)
+
+	return cc.cmd
+}
+
+func (c *dumpForwardsCommand) Execute(_ *cobra.Command, _ []string) error {
+	// Check that we have a channel DB.
+	if !channelDBGiven(c.ChannelDB) {
+		return fmt.Errorf("channel DB is required")
+	}
+	if err := checkAccountingFormat(c.Format); err != nil {
+		return err
+	}
+
+	endTime := time.Now()
+	if c.EndTime != 0 {
+		endTime = time.Unix(c.EndTime, 0)
+	}
+	startTime := time.Unix(c.StartTime, 0)
+	if startTime.After(endTime) {
+		return fmt.Errorf("start time must be before end time")
+	}
+
+	db, err := openChannelDB(c.ChannelDB, true)
+	if err != nil {
+		return fmt.Errorf("error opening rescue DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	events, err := fetchForwardingEvents(
+		db.ForwardingLog(), startTime, endTime,
+	)
+	if err != nil {
+		return err
+	}
+
+	dumpEvents := dump.ForwardingEventDump(events)
+	if c.Format == dumpFormatCSV {
+		records := make([]dump.CSVRecord, len(dumpEvents))
+		for idx, event := range dumpEvents {
+			records[idx] = event
+		}
+
+		return printCSV(
+			records, (&dump.ForwardingEvent{}).CSVHeader(),
+		)
+	}
+
+	return printDump(dumpEvents, c.Format)
+}
+
+// fetchForwardingEvents reads all forwarding events in the given time range
+// from the forwarding log, in batches.
+func fetchForwardingEvents(fwdLog *channeldb.ForwardingLog, startTime,
+	endTime time.Time) ([]channeldb.ForwardingEvent, error) {
+
+	var (
+		events []channeldb.ForwardingEvent
+		offset uint32
+	)
+	for {
+		resp, err := fwdLog.Query(channeldb.ForwardingEventQuery{
+			StartTime:    startTime,
+			EndTime:      endTime,
+			IndexOffset:  offset,
+			NumMaxEvents: forwardingQueryBatchSize,
+		})
+
+		// A node that never forwarded a payment doesn't have the
+		// bucket.
+		if errors.Is(err, channeldb.ErrNoForwardingEvents) {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error querying forwarding "+
+				"log: %w", err)
+		}
+
+		if len(resp.ForwardingEvents) == 0 {
+			return events, nil
+		}
+
+		events = append(events, resp.ForwardingEvents...)
+		offset = resp.LastIndexOffset
+	}
+}
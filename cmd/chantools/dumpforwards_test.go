@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/guggero/chantools/dump"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpForwards(t *testing.T) {
+	h := newHarness(t)
+
+	// Work on a copy of the test DB, as we need to add forwarding events.
+	compact := &compactDBCommand{
+		SourceDB: h.testdataFile("channel.db"),
+		DestDB:   h.tempFile("forwards.db"),
+	}
+	err := compact.Execute(nil, nil)
+	require.NoError(t, err)
+
+	dumpForwards := &dumpForwardsCommand{
+		ChannelDB: compact.DestDB,
+		Format:    dumpFormatJSON,
+	}
+
+	// A node that never forwarded anything has an empty log.
+	h.captureJSON()
+	require.NoError(t, dumpForwards.Execute(nil, nil))
+	var result []*dump.ForwardingEvent
+	h.assertJSONOutput(&result)
+	require.Empty(t, result)
+
+	// We add more events than are fetched in a single query, one per
+	// second.
+	db, err := openChannelDB(compact.DestDB, false)
+	require.NoError(t, err)
+
+	const numEvents = forwardingQueryBatchSize + 5
+	startTime := time.Unix(1_672_531_200, 0)
+	events := make([]channeldb.ForwardingEvent, numEvents)
+	for i := range events {
+		offset := time.Duration(i) * time.Second
+		events[i] = channeldb.ForwardingEvent{
+			Timestamp:      startTime.Add(offset),
+			IncomingChanID: lnwire.NewShortChanIDFromInt(1),
+			OutgoingChanID: lnwire.NewShortChanIDFromInt(2),
+			AmtIn:          lnwire.MilliSatoshi(10_000 + i),
+			AmtOut:         10_000,
+		}
+	}
+	require.NoError(t, db.ForwardingLog().AddForwardingEvents(events))
+	require.NoError(t, db.Close())
+
+	h.captureJSON()
+	require.NoError(t, dumpForwards.Execute(nil, nil))
+	h.assertJSONOutput(&result)
+	require.Len(t, result, numEvents)
+	for i, event := range result {
+		require.True(t, events[i].Timestamp.Equal(event.Timestamp))
+		require.EqualValues(t, i, event.FeeMsat)
+	}
+
+	// Only the events in the given time range are dumped, as CSV.
+	h.captureJSON()
+	dumpForwards.StartTime = startTime.Unix() + 10
+	dumpForwards.EndTime = startTime.Unix() + 12
+	dumpForwards.Format = dumpFormatCSV
+	require.NoError(t, dumpForwards.Execute(nil, nil))
+
+	content, err := ioutil.ReadFile(h.jsonFile)
+	require.NoError(t, err)
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		(&dump.ForwardingEvent{}).CSVHeader(),
+		result[10].CSVRecord(),
+		result[11].CSVRecord(),
+		result[12].CSVRecord(),
+	}, records)
+	require.Equal(t, []string{
+		result[10].Timestamp.Format(time.RFC3339Nano), "0:0:1",
+		"0:0:2", "10010", "10000", "10",
+	}, records[1])
+
+	dumpForwards.StartTime = dumpForwards.EndTime + 1
+	require.ErrorContains(
+		t, dumpForwards.Execute(nil, nil),
+		"start time must be before end time",
+	)
+}
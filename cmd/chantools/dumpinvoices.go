@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/guggero/chantools/dump"
+	"github.com/lightningnetwork/lnd/invoices"
+	"github.com/spf13/cobra"
+)
+
+type dumpInvoicesCommand struct {
+	ChannelDB   string
+	PendingOnly bool
+	Format      string
+
+	cmd *cobra.Command
+}
+
+func newDumpInvoicesCommand() *cobra.Command {
+	cc := &dumpInvoicesCommand{}
+	cc.cmd = &cobra.Command{
+		Use:   "dumpinvoices",
+		Short: "Dump all invoices from an lnd channel database",
+		Long: `This command dumps all invoices from the given lnd
+channel.db file. This can be used for accounting purposes or to prove an
+invoice was paid, even if the node itself cannot be started anymore.
+
+The output is written to stdout as JSON (default) or CSV. The log output is
+written to stderr so the result can be piped into a file. All amounts are in
+milli-satoshis.`,
+		Example: `chantools dumpinvoices \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--format csv > invoices.csv`,
+		RunE: cc.Execute,
+		Annotations: map[string]string{
+			annotationStdoutFormat: dumpFormatJSON + "," +
+				dumpFormatCSV,
+			annotationJSONFlags: "format=" + dumpFormatJSON,
+		},
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to dump "+
+			"the invoices from",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.PendingOnly, "pendingonly", false, "only dump invoices "+
+			"that are not settled or canceled yet",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Format, "format", dumpFormatJSON, "output format to use; "+
+			"either '"+dumpFormatJSON+"' or '"+dumpFormatCSV+"'",
+	)
+
+	return cc.cmd
+}
+
+func (c *dumpInvoicesCommand) Execute(_ *cobra.Command, _ []string) error {
+	// Check that we have a channel DB.
+	if !channelDBGiven(c.ChannelDB) {
+		return fmt.Errorf("channel DB is required")
+	}
+	if err := checkAccountingFormat(c.Format); err != nil {
+		return err
+	}
+
+	db, err := openChannelDB(c.ChannelDB, true)
+	if err != nil {
+		return fmt.Errorf("error opening rescue DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	resp, err := db.QueryInvoices(invoices.InvoiceQuery{
+		NumMaxInvoices: math.MaxUint64,
+		PendingOnly:    c.PendingOnly,
+	})
+
+	// A node that never created an invoice doesn't have the bucket.
+	switch {
+	case errors.Is(err, invoices.ErrNoInvoicesCreated):
+		resp.Invoices = nil
+
+	case err != nil:
+		return fmt.Errorf("error fetching invoices: %w", err)
+	}
+
+	dumpInvoices := dump.InvoiceDump(resp.Invoices, chainParams)
+	if c.Format == dumpFormatCSV {
+		records := make([]dump.CSVRecord, len(dumpInvoices))
+		for idx, invoice := range dumpInvoices {
+			records[idx] = invoice
+		}
+
+		return printCSV(records, (&dump.Invoice{}).CSVHeader())
+	}
+
+	return printDump(dumpInvoices, c.Format)
+}
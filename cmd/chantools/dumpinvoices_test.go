@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/guggero/chantools/dump"
+	"github.com/lightningnetwork/lnd/invoices"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpInvoices(t *testing.T) {
+	h := newHarness(t)
+
+	// Work on a copy of the test DB, as we need to add invoices.
+	compact := &compactDBCommand{
+		SourceDB: h.testdataFile("channel.db"),
+		DestDB:   h.tempFile("invoices.db"),
+	}
+	err := compact.Execute(nil, nil)
+	require.NoError(t, err)
+
+	db, err := openChannelDB(compact.DestDB, false)
+	require.NoError(t, err)
+
+	// The first invoice has a preimage and is canceled.
+	creationDate := time.Unix(1_672_531_200, 0)
+	preimage := lntypes.Preimage{1, 2, 3}
+	_, err = db.AddInvoice(&invoices.Invoice{
+		Memo:         []byte("coffee"),
+		CreationDate: creationDate,
+		Terms: invoices.ContractTerm{
+			FinalCltvDelta:  40,
+			Expiry:          time.Hour,
+			PaymentPreimage: &preimage,
+			Value:           50_000,
+			Features:        lnwire.EmptyFeatureVector(),
+		},
+	}, preimage.Hash())
+	require.NoError(t, err)
+	_, err = db.UpdateInvoice(
+		invoices.InvoiceRefByHash(preimage.Hash()), nil,
+		func(*invoices.Invoice) (*invoices.InvoiceUpdateDesc, error) {
+			return &invoices.InvoiceUpdateDesc{
+				State: &invoices.InvoiceStateUpdateDesc{
+					NewState: invoices.ContractCanceled,
+				},
+			}, nil
+		},
+	)
+	require.NoError(t, err)
+
+	// The second one is a hold invoice without a preimage, so its hash
+	// can only be decoded from the payment request.
+	hodlHash := lntypes.Hash{4, 5, 6}
+	payReq := encodeTestPayReq(t, hodlHash, creationDate)
+	_, err = db.AddInvoice(&invoices.Invoice{
+		PaymentRequest: []byte(payReq),
+		CreationDate:   creationDate,
+		HodlInvoice:    true,
+		Terms: invoices.ContractTerm{
+			FinalCltvDelta: 40,
+			Expiry:         time.Hour,
+			Value:          70_000,
+			Features:       lnwire.EmptyFeatureVector(),
+		},
+	}, hodlHash)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	h.captureJSON()
+	dumpInvoices := &dumpInvoicesCommand{
+		ChannelDB: compact.DestDB,
+		Format:    dumpFormatJSON,
+	}
+	err = dumpInvoices.Execute(nil, nil)
+	require.NoError(t, err)
+
+	var result []*dump.Invoice
+	h.assertJSONOutput(&result)
+	require.Len(t, result, 2)
+
+	require.EqualValues(t, 1, result[0].AddIndex)
+	require.Equal(t, preimage.Hash().String(), result[0].PaymentHash)
+	require.Equal(t, preimage.String(), result[0].PaymentPreimage)
+	require.Equal(t, "coffee", result[0].Memo)
+	require.Equal(t, "Canceled", result[0].State)
+	require.EqualValues(t, 50_000, result[0].ValueMsat)
+	require.True(t, creationDate.Equal(result[0].CreationDate))
+	require.Nil(t, result[0].SettleDate)
+	require.False(t, result[0].HodlInvoice)
+
+	require.EqualValues(t, 2, result[1].AddIndex)
+	require.Equal(t, hodlHash.String(), result[1].PaymentHash)
+	require.Empty(t, result[1].PaymentPreimage)
+	require.Equal(t, payReq, result[1].PaymentRequest)
+	require.Equal(t, "Open", result[1].State)
+	require.True(t, result[1].HodlInvoice)
+
+	// Canceled invoices aren't pending anymore.
+	h.captureJSON()
+	dumpInvoices.PendingOnly = true
+	require.NoError(t, dumpInvoices.Execute(nil, nil))
+	h.assertJSONOutput(&result)
+	require.Len(t, result, 1)
+	require.Equal(t, hodlHash.String(), result[0].PaymentHash)
+}
+
+// encodeTestPayReq creates a signed payment request for the given payment
+// hash.
+func encodeTestPayReq(t *testing.T, hash lntypes.Hash,
+	creationDate time.Time) string {
+
+	privKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	invoice, err := zpay32.NewInvoice(
+		chainParams, hash, creationDate,
+		zpay32.Amount(70_000), zpay32.Description("hodl"),
+	)
+	require.NoError(t, err)
+
+	payReq, err := invoice.Encode(zpay32.MessageSigner{
+		SignCompact: func(msg []byte) ([]byte, error) {
+			return ecdsa.SignCompact(
+				privKey, chainhash.HashB(msg), true,
+			)
+		},
+	})
+	require.NoError(t, err)
+
+	return payReq
+}
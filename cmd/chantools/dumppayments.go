@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/guggero/chantools/dump"
+	"github.com/spf13/cobra"
+)
+
+type dumpPaymentsCommand struct {
+	ChannelDB string
+	Format    string
+
+	cmd *cobra.Command
+}
+
+func newDumpPaymentsCommand() *cobra.Command {
+	cc := &dumpPaymentsCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "dumppayments",
+		Short: "Dump all outgoing payments from an lnd channel " +
+			"database",
+		Long: `This command dumps all outgoing payments (successful,
+failed and in-flight) from the given lnd channel.db file. This can be used for
+accounting purposes or to prove a payment was made (the preimage is included
+for successful payments), even if the node itself cannot be started anymore.
+
+The output is written to stdout as JSON (default) or CSV. The log output is
+written to stderr so the result can be piped into a file. All amounts are in
+milli-satoshis, the fee is the total fee of all successful HTLCs of a payment.`,
+		Example: `chantools dumppayments \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--format csv > payments.csv`,
+		RunE: cc.Execute,
+		Annotations: map[string]string{
+			annotationStdoutFormat: dumpFormatJSON + "," +
+				dumpFormatCSV,
+			annotationJSONFlags: "format=" + dumpFormatJSON,
+		},
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to dump "+
+			"the payments from",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Format, "format", dumpFormatJSON, "output format to use; "+
+			"either '"+dumpFormatJSON+"' or '"+dumpFormatCSV+"'",
+	)
+
+	return cc.cmd
+}
+
+func (c *dumpPaymentsCommand) Execute(_ *cobra.Command, _ []string) error {
+	// Check that we have a channel DB.
+	if !channelDBGiven(c.ChannelDB) {
+		return fmt.Errorf("channel DB is required")
+	}
+	if err := checkAccountingFormat(c.Format); err != nil {
+		return err
+	}
+
+	db, err := openChannelDB(c.ChannelDB, true)
+	if err != nil {
+		return fmt.Errorf("error opening rescue DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	payments, err := db.FetchPayments()
+	if err != nil {
+		return fmt.Errorf("error fetching payments: %w", err)
+	}
+
+	dumpPayments := dump.PaymentDump(payments)
+	if c.Format == dumpFormatCSV {
+		records := make([]dump.CSVRecord, len(dumpPayments))
+		for idx, payment := range dumpPayments {
+			records[idx] = payment
+		}
+
+		return printCSV(records, (&dump.Payment{}).CSVHeader())
+	}
+
+	return printDump(dumpPayments, c.Format)
+}
+
+// checkAccountingFormat makes sure the given output format is one of the
+// formats supported by the accounting dump commands.
+func checkAccountingFormat(format string) error {
+	switch format {
+	case dumpFormatJSON, dumpFormatCSV:
+		return nil
+
+	default:
+		return fmt.Errorf("invalid format '%s'", format)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/guggero/chantools/dump"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/routing/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpPayments(t *testing.T) {
+	h := newHarness(t)
+
+	// Work on a copy of the test DB, as we need to add payments.
+	compact := &compactDBCommand{
+		SourceDB: h.testdataFile("channel.db"),
+		DestDB:   h.tempFile("payments.db"),
+	}
+	err := compact.Execute(nil, nil)
+	require.NoError(t, err)
+
+	db, err := openChannelDB(compact.DestDB, false)
+	require.NoError(t, err)
+
+	// The first payment succeeds with a fee of 1 satoshi, the second one
+	// fails without any attempt.
+	control := channeldb.NewPaymentControl(db)
+	preimage := lntypes.Preimage{1, 2, 3}
+	settledHash := preimage.Hash()
+	failedHash := lntypes.Hash{4, 5, 6}
+	creationTime := time.Unix(1_672_531_200, 0)
+	settleTime := creationTime.Add(time.Minute)
+	for _, hash := range []lntypes.Hash{settledHash, failedHash} {
+		err := control.InitPayment(hash, &channeldb.PaymentCreationInfo{
+			PaymentIdentifier: hash,
+			Value:             100_000,
+			CreationTime:      creationTime,
+			PaymentRequest:    []byte("lnbcrt1" + hash.String()[:8]),
+		})
+		require.NoError(t, err)
+	}
+
+	sessionKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	_, err = control.RegisterAttempt(
+		settledHash, channeldb.NewHtlcAttemptInfo(
+			1, sessionKey, route.Route{
+				TotalAmount: 101_000,
+				Hops: []*route.Hop{{
+					PubKeyBytes: route.NewVertex(
+						sessionKey.PubKey(),
+					),
+					AmtToForward: 100_000,
+				}},
+			}, creationTime, nil,
+		),
+	)
+	require.NoError(t, err)
+	_, err = control.SettleAttempt(
+		settledHash, 1, &channeldb.HTLCSettleInfo{
+			Preimage:   preimage,
+			SettleTime: settleTime,
+		},
+	)
+	require.NoError(t, err)
+	_, err = control.Fail(failedHash, channeldb.FailureReasonNoRoute)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	h.captureJSON()
+	payments := &dumpPaymentsCommand{
+		ChannelDB: compact.DestDB,
+		Format:    dumpFormatJSON,
+	}
+	err = payments.Execute(nil, nil)
+	require.NoError(t, err)
+
+	var result []*dump.Payment
+	h.assertJSONOutput(&result)
+	require.Len(t, result, 2)
+
+	require.Equal(t, settledHash.String(), result[0].PaymentHash)
+	require.Equal(t, "Succeeded", result[0].Status)
+	require.EqualValues(t, 100_000, result[0].ValueMsat)
+	require.EqualValues(t, 1_000, result[0].FeeMsat)
+	require.True(t, creationTime.Equal(result[0].CreationTime))
+	require.NotNil(t, result[0].SettleTime)
+	require.True(t, settleTime.Equal(*result[0].SettleTime))
+	require.Equal(t, preimage.String(), result[0].PaymentPreimage)
+	require.Equal(t, 1, result[0].NumAttempts)
+	require.Empty(t, result[0].FailureReason)
+
+	require.Equal(t, failedHash.String(), result[1].PaymentHash)
+	require.Equal(t, "Failed", result[1].Status)
+	require.Equal(t, "no_route", result[1].FailureReason)
+	require.EqualValues(t, 0, result[1].FeeMsat)
+	require.Nil(t, result[1].SettleTime)
+	require.Empty(t, result[1].PaymentPreimage)
+	require.Equal(t, 0, result[1].NumAttempts)
+
+	// The CSV output contains the same payments.
+	h.captureJSON()
+	payments.Format = dumpFormatCSV
+	require.NoError(t, payments.Execute(nil, nil))
+
+	content, err := ioutil.ReadFile(h.jsonFile)
+	require.NoError(t, err)
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, (&dump.Payment{}).CSVHeader(), records[0])
+	require.Equal(t, result[0].CSVRecord(), records[1])
+	require.Equal(t, result[1].CSVRecord(), records[2])
+	require.Equal(t, "1000", records[1][4])
+	require.Equal(t, "", records[2][6])
+
+	payments.Format = "spew"
+	require.ErrorContains(
+		t, payments.Execute(nil, nil), "invalid format 'spew'",
+	)
+}
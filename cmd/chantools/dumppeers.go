@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/guggero/chantools/dump"
+	"github.com/spf13/cobra"
+)
+
+type dumpPeersCommand struct {
+	ChannelDB string
+	Peer      string
+	Format    string
+
+	cmd *cobra.Command
+}
+
+func newDumpPeersCommand() *cobra.Command {
+	cc := &dumpPeersCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "dumppeers",
+		Short: "Dump all peers and their last known network addresses " +
+			"from an lnd channel database",
+		Long: `This command dumps all peers the node has or had channels
+with from the given lnd channel.db file, together with their last known network
+addresses. This can be used to contact the counterparties of channels for a
+cooperative (zombie) recovery without a running node.
+
+For each peer, the addresses lnd last used to connect to it (only known for
+peers with open channels) and the addresses the peer announced in the channel
+graph (only known if the graph wasn't dropped) are printed, as well as the
+channel points of all open and closed channels with that peer.`,
+		Example: `chantools dumppeers \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--format json`,
+		RunE: cc.Execute,
+		Annotations: map[string]string{
+			annotationStdoutFormat: dumpFormatJSON,
+			annotationJSONFlags:    "format=" + dumpFormatJSON,
+		},
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to dump "+
+			"the peers from",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Peer, "peer", "", "only dump the peer with this hex "+
+			"encoded public key",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Format, "format", dumpFormatSpew, "output format to use; "+
+			"either '"+dumpFormatSpew+"' or '"+dumpFormatJSON+"'",
+	)
+
+	return cc.cmd
+}
+
+func (c *dumpPeersCommand) Execute(_ *cobra.Command, _ []string) error {
+	// Check that we have a channel DB.
+	if !channelDBGiven(c.ChannelDB) {
+		return fmt.Errorf("channel DB is required")
+	}
+	switch c.Format {
+	case "":
+		c.Format = dumpFormatSpew
+
+	case dumpFormatSpew, dumpFormatJSON:
+
+	default:
+		return fmt.Errorf("invalid format '%s'", c.Format)
+	}
+
+	db, err := openChannelDB(c.ChannelDB, true)
+	if err != nil {
+		return fmt.Errorf("error opening rescue DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	chanDb := db.ChannelStateDB()
+	linkNodes, err := chanDb.LinkNodeDB().FetchAllLinkNodes()
+	if err != nil {
+		return fmt.Errorf("error fetching link nodes: %w", err)
+	}
+	openChannels, err := chanDb.FetchAllChannels()
+	if err != nil {
+		return fmt.Errorf("error fetching open channels: %w", err)
+	}
+	closedChannels, err := chanDb.FetchClosedChannels(false)
+	if err != nil {
+		return fmt.Errorf("error fetching closed channels: %w", err)
+	}
+
+	peers, err := dump.PeerDump(
+		linkNodes, openChannels, closedChannels,
+		db.ChannelGraph().FetchLightningNode,
+	)
+	if err != nil {
+		return fmt.Errorf("error converting to dump format: %w", err)
+	}
+
+	filtered := make([]*dump.Peer, 0, len(peers))
+	for _, peer := range peers {
+		if c.Peer == "" || strings.EqualFold(c.Peer, peer.RemotePub) {
+			filtered = append(filtered, peer)
+		}
+	}
+
+	return printDump(filtered, c.Format)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	peerContent = "(string) (len=16) \"172.18.0.5:44848\""
+)
+
+func TestDumpPeers(t *testing.T) {
+	h := newHarness(t)
+
+	dumpPeers := &dumpPeersCommand{
+		ChannelDB: h.testdataFile("channel.db"),
+		Peer: "02aad76b7ec22006f88588f3004a7e74be22023dd51fc2c1de7" +
+			"d5e15cd8c5311b8",
+	}
+
+	err := dumpPeers.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(peerContent)
+	h.assertLogContains("10279f62619634058b6133cb7ac6c1693a8e6df7caa91c62" +
+		"63ca3d0bf704ad4d:0")
+}
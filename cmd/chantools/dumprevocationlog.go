@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/guggero/chantools/dump"
+	"github.com/spf13/cobra"
+)
+
+type dumpRevocationLogCommand struct {
+	ChannelDB    string
+	ChannelPoint string
+	Format       string
+
+	cmd *cobra.Command
+}
+
+func newDumpRevocationLogCommand() *cobra.Command {
+	cc := &dumpRevocationLogCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "dumprevocationlog",
+		Short: "Dump the revocation log of all channels from an lnd " +
+			"channel database",
+		Long: `This command dumps the revocation log of the channels in the
+given lnd channel.db file. The revocation log contains an entry for each
+revoked commitment of the remote peer with the commitment transaction hash, the
+output indexes and balances of both parties, all HTLCs and the revocation
+secret (and corresponding commit point) we received from the peer.
+
+This information can be used to audit the balance history of a channel or to
+check whether a commitment that was published by the peer was revoked.`,
+		Example: `chantools dumprevocationlog \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--channelpoint f39310xxxxxxxxxx:1 \
+	--format json`,
+		RunE: cc.Execute,
+		Annotations: map[string]string{
+			annotationStdoutFormat: dumpFormatJSON,
+			annotationJSONFlags:    "format=" + dumpFormatJSON,
+		},
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to dump "+
+			"the revocation log from",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelPoint, "channelpoint", "", "only dump the "+
+			"revocation log of the channel with this channel point "+
+			"(<txid>:<txindex>)",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Format, "format", dumpFormatSpew, "output format to use; "+
+			"either '"+dumpFormatSpew+"' or '"+dumpFormatJSON+"'",
+	)
+
+	return cc.cmd
+}
+
+func (c *dumpRevocationLogCommand) Execute(_ *cobra.Command,
+	_ []string) error {
+
+	// Check that we have a channel DB.
+	if !channelDBGiven(c.ChannelDB) {
+		return fmt.Errorf("channel DB is required")
+	}
+	switch c.Format {
+	case "":
+		c.Format = dumpFormatSpew
+
+	case dumpFormatSpew, dumpFormatJSON:
+
+	default:
+		return fmt.Errorf("invalid format '%s'", c.Format)
+	}
+
+	db, err := openChannelDB(c.ChannelDB, true)
+	if err != nil {
+		return fmt.Errorf("error opening rescue DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	channels, err := db.ChannelStateDB().FetchAllChannels()
+	if err != nil {
+		return err
+	}
+
+	filter := &dumpFilter{channelPoint: c.ChannelPoint}
+	logs := make([]*dump.ChannelRevocationLog, 0, len(channels))
+	for _, channel := range channels {
+		if !filter.match(&channel.FundingOutpoint, channel.IdentityPub) {
+			continue
+		}
+
+		revLog, err := dump.RevocationLogDump(channel)
+		if err != nil {
+			return fmt.Errorf("error dumping revocation log of "+
+				"channel %v: %w", channel.FundingOutpoint, err)
+		}
+		logs = append(logs, revLog)
+	}
+
+	return printDump(logs, c.Format)
+}
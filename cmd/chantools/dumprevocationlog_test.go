@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/guggero/chantools/dump"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpRevocationLog(t *testing.T) {
+	h := newHarness(t)
+
+	// Work on a copy of the test DB, as we need to revoke a state.
+	compact := &compactDBCommand{
+		SourceDB: h.testdataFile("channel.db"),
+		DestDB:   h.tempFile("revlog.db"),
+	}
+	err := compact.Execute(nil, nil)
+	require.NoError(t, err)
+
+	db, err := openChannelDB(compact.DestDB, false)
+	require.NoError(t, err)
+
+	channels, err := db.ChannelStateDB().FetchAllChannels()
+	require.NoError(t, err)
+	require.NotEmpty(t, channels)
+
+	// The remote party revokes their current state with an HTLC on it and
+	// moves to the next one.
+	channel := channels[0]
+	revokedCommit := channel.RemoteCommitment
+	require.EqualValues(t, 0, revokedCommit.CommitHeight)
+	require.NotNil(t, revokedCommit.CommitTx)
+
+	nextCommit := revokedCommit
+	nextCommit.CommitHeight++
+	err = channel.AppendRemoteCommitChain(&channeldb.CommitDiff{
+		Commitment: nextCommit,
+		CommitSig: &lnwire.CommitSig{
+			ChanID: lnwire.NewChanIDFromOutPoint(
+				&channel.FundingOutpoint,
+			),
+		},
+	})
+	require.NoError(t, err)
+
+	secret := chainhash.Hash{1, 2, 3}
+	rHash := [32]byte{4, 5, 6}
+	require.NoError(t, channel.RevocationStore.AddNextEntry(&secret))
+	channel.RemoteCommitment.Htlcs = []channeldb.HTLC{{
+		RHash:         rHash,
+		Amt:           lnwire.NewMSatFromSatoshis(1_000),
+		RefundTimeout: 800,
+		OutputIndex:   2,
+		Incoming:      true,
+	}}
+	err = channel.AdvanceCommitChainTail(
+		channeldb.NewFwdPkg(channel.ShortChannelID, 0, nil, nil), nil,
+		0, 1,
+	)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	h.captureJSON()
+	revLog := &dumpRevocationLogCommand{
+		ChannelDB:    compact.DestDB,
+		ChannelPoint: channel.FundingOutpoint.String(),
+		Format:       dumpFormatJSON,
+	}
+	err = revLog.Execute(nil, nil)
+	require.NoError(t, err)
+
+	var logs []*dump.ChannelRevocationLog
+	h.assertJSONOutput(&logs)
+	require.Len(t, logs, 1)
+	require.Equal(
+		t, channel.FundingOutpoint.String(), logs[0].FundingOutpoint,
+	)
+
+	ourBalance := revokedCommit.LocalBalance
+	theirBalance := revokedCommit.RemoteBalance
+	require.Equal(t, []dump.RevocationLogEntry{{
+		CommitHeight:     0,
+		CommitTxHash:     revokedCommit.CommitTx.TxHash().String(),
+		OurOutputIndex:   0,
+		TheirOutputIndex: 1,
+		OurBalance:       &ourBalance,
+		TheirBalance:     &theirBalance,
+		RevocationSecret: secret.String(),
+		CommitPoint: dump.PubKeyToString(
+			input.ComputeCommitmentPoint(secret[:]),
+		),
+		HTLCs: []dump.RevokedHTLC{{
+			RHash:         hex.EncodeToString(rHash[:]),
+			RefundTimeout: 800,
+			OutputIndex:   2,
+			Incoming:      true,
+			Amt:           1_000,
+		}},
+	}}, logs[0].Entries)
+
+	// Channels without any revoked state have an empty log.
+	revLog.ChannelPoint = channels[1].FundingOutpoint.String()
+	h.captureJSON()
+	require.NoError(t, revLog.Execute(nil, nil))
+	h.assertJSONOutput(&logs)
+	require.Len(t, logs, 1)
+	require.Empty(t, logs[0].Entries)
+
+	revLog.Format = "csv"
+	require.ErrorContains(
+		t, revLog.Execute(nil, nil), "invalid format 'csv'",
+	)
+}
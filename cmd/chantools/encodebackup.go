@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/guggero/chantools/dump"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/spf13/cobra"
+)
+
+type encodeBackupCommand struct {
+	Input     string
+	MultiFile string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newEncodeBackupCommand() *cobra.Command {
+	cc := &encodeBackupCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "encodebackup",
+		Short: "Encode and encrypt a JSON channel backup dump as a " +
+			"channel.backup file",
+		Long: `This command is the counterpart of the dumpbackup command
+with the --format json flag. It reads the (possibly edited) JSON dump of a
+channel.backup file, encodes and encrypts it with the given root key and writes
+it to a new channel.backup file that can be used with lnd's
+restorechanbackup command.
+
+This can be used to fix a wrong peer address or other field in a
+channel.backup file.
+
+Before the new file is written, the encrypted backup is decrypted and decoded
+again and compared to the JSON input to make sure the result is a valid
+channel.backup file that contains exactly the edited information.
+
+CAUTION: Only change fields you know to be wrong. Restoring a backup with
+wrong keys, outpoints or channel parameters can make it impossible to recover
+the funds of a channel.`,
+		Example: `chantools dumpbackup \
+	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup \
+	--format json > backup.json
+
+chantools encodebackup \
+	--input backup.json \
+	--multi_file fixed.backup`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Input, "input", "", "the JSON file created by the "+
+			"dumpbackup command with the --format json flag",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.MultiFile, "multi_file", "", "the channel backup file "+
+			"to create; if empty, encoded-<timestamp>.backup in "+
+			"the working directory is used",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "encrypting the backup")
+
+	return cc.cmd
+}
+
+func (c *encodeBackupCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	// Check that we have an input file.
+	if c.Input == "" {
+		return fmt.Errorf("input file is required")
+	}
+	jsonBytes, err := os.ReadFile(c.Input)
+	if err != nil {
+		return fmt.Errorf("error reading input file %s: %w", c.Input,
+			err)
+	}
+
+	if c.MultiFile == "" {
+		c.MultiFile = resultFileName("encoded", "backup")
+	}
+	multiFile := chanbackup.NewMultiFile(c.MultiFile)
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	return encodeChannelBackup(jsonBytes, keyRing, multiFile)
+}
+
+func encodeChannelBackup(jsonBytes []byte, ring keychain.KeyRing,
+	multiFile *chanbackup.MultiFile) error {
+
+	content := &dump.BackupMulti{}
+	if err := json.Unmarshal(jsonBytes, content); err != nil {
+		return fmt.Errorf("error decoding JSON backup: %w", err)
+	}
+
+	multi, err := content.ToMulti()
+	if err != nil {
+		return err
+	}
+
+	var packed bytes.Buffer
+	if err := multi.PackToWriter(&packed, ring); err != nil {
+		return fmt.Errorf("unable to multi-pack backups: %w", err)
+	}
+
+	if err := validateEncodedBackup(
+		multi, packed.Bytes(), ring,
+	); err != nil {
+		return fmt.Errorf("encoded backup is invalid: %w", err)
+	}
+
+	log.Infof("Writing %d channel backup(s)", len(multi.StaticBackups))
+
+	return multiFile.UpdateAndSwap(packed.Bytes())
+}
+
+// validateEncodedBackup makes sure the packed backup can be decrypted and
+// decoded again and still contains the same information as the multi it was
+// created from.
+func validateEncodedBackup(multi *chanbackup.Multi, packed []byte,
+	ring keychain.KeyRing) error {
+
+	var unpacked chanbackup.Multi
+	err := unpacked.UnpackFromReader(bytes.NewReader(packed), ring)
+	if err != nil {
+		return fmt.Errorf("unable to unpack backup: %w", err)
+	}
+
+	expected := dump.BackupDump(multi, chainParams)
+	decoded := dump.BackupDump(&unpacked, chainParams)
+	if len(expected) != len(decoded) {
+		return fmt.Errorf("expected %d channels, got %d",
+			len(expected), len(decoded))
+	}
+	for idx := range expected {
+		if !reflect.DeepEqual(expected[idx], decoded[idx]) {
+			return fmt.Errorf("channel %s changed after decoding",
+				expected[idx].FundingOutpoint)
+		}
+	}
+
+	return nil
+}
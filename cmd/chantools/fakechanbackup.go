@@ -8,12 +8,12 @@ import (
 	"net"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/gogo/protobuf/jsonpb"
+	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/lnd"
 	"github.com/lightningnetwork/lnd/chanbackup"
 	"github.com/lightningnetwork/lnd/channeldb"
@@ -34,8 +34,9 @@ type fakeChanBackupCommand struct {
 
 	MultiFile string
 
-	rootKey *rootKey
-	cmd     *cobra.Command
+	chainAPI *chainAPIFlags
+	rootKey  *rootKey
+	cmd      *cobra.Command
 }
 
 func newFakeChanBackupCommand() *cobra.Command {
@@ -60,6 +61,10 @@ There are two versions of this command: The first one is to create a fake
 backup for a single channel where all flags (except --from_channel_graph) need
 to be set. This is the easiest to use since it only relies on data that is
 publicly available (for example on 1ml.com) but involves more manual work.
+If only the channel point and the remote node's address are known, the
+--short_channel_id and --capacity flags can be omitted. They are then looked up
+from the funding transaction, which requires the (default) block explorer API
+since the other chain backends can't list the transactions of a block.
 The second version of the command only takes the --from_channel_graph and
 --multi_file flags and tries to assemble all channels found in the public
 network graph (must be provided in the JSON format that the 
@@ -80,6 +85,11 @@ for the rescueclosed command.
 	--short_channel_id 566222x300x1 \
 	--multi_file fake.backup
 
+chantools fakechanbackup \
+	--channelpoint f39310xxxxxxxxxx:1 \
+	--remote_node_addr 022c260xxxxxxxx@213.174.150.1:9735 \
+	--multi_file fake.backup
+
 chantools fakechanbackup --from_channel_graph lncli_describegraph.json \
 	--multi_file fake.backup`,
 		RunE: cc.Execute,
@@ -97,22 +107,24 @@ chantools fakechanbackup --from_channel_graph lncli_describegraph.json \
 	cc.cmd.Flags().StringVar(
 		&cc.ShortChanID, "short_channel_id", "", "the short channel "+
 			"ID in the format <blockheight>x<transactionindex>x"+
-			"<outputindex>",
+			"<outputindex>; looked up from the funding "+
+			"transaction if not set",
 	)
 	cc.cmd.Flags().Uint64Var(
 		&cc.Capacity, "capacity", 0, "the channel's capacity in "+
-			"satoshis",
+			"satoshis; looked up from the funding transaction if "+
+			"not set",
 	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
 	cc.cmd.Flags().StringVar(
 		&cc.FromChannelGraph, "from_channel_graph", "", "the full "+
 			"LN channel graph in the JSON format that the "+
 			"'lncli describegraph' returns",
 	)
-	multiFileName := fmt.Sprintf("results/fake-%s.backup",
-		time.Now().Format("2006-01-02-15-04-05"))
 	cc.cmd.Flags().StringVar(
-		&cc.MultiFile, "multi_file", multiFileName, "the fake channel "+
-			"backup file to create",
+		&cc.MultiFile, "multi_file", "", "the fake channel backup "+
+			"file to create; if empty, fake-<timestamp>.backup in "+
+			"the working directory is used",
 	)
 
 	cc.rootKey = newRootKey(cc.cmd, "encrypting the backup")
@@ -126,6 +138,9 @@ func (c *fakeChanBackupCommand) Execute(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("error reading root key: %w", err)
 	}
 
+	if c.MultiFile == "" {
+		c.MultiFile = resultFileName("fake", "backup")
+	}
 	multiFile := chanbackup.NewMultiFile(c.MultiFile)
 	keyRing := &lnd.HDKeyRing{
 		ExtendedKey: extendedKey,
@@ -189,42 +204,128 @@ func (c *fakeChanBackupCommand) Execute(_ *cobra.Command, _ []string) error {
 		}
 	}
 
-	// Parse the short channel ID.
-	splitChanID := strings.Split(c.ShortChanID, "x")
+	// Look up the missing channel information from the funding
+	// transaction.
+	capacity := btcutil.Amount(c.Capacity)
+	var shortChanID lnwire.ShortChannelID
+	if c.ShortChanID == "" || capacity == 0 {
+		api := c.chainAPI.api()
+		defer stopChainAPI(api)
+
+		shortChanID, capacity, err = lookupFundingTx(api, chanOp)
+		if err != nil {
+			return fmt.Errorf("error looking up funding "+
+				"transaction: %w", err)
+		}
+		log.Infof("Found channel %v with short channel ID %v and "+
+			"capacity %d on chain", chanOp, shortChanID, capacity)
+
+		if c.Capacity != 0 {
+			capacity = btcutil.Amount(c.Capacity)
+		}
+	}
+
+	if c.ShortChanID != "" {
+		shortChanID, err = parseShortChanID(c.ShortChanID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Is the outpoint and/or short channel ID correct?
+	if uint32(shortChanID.TxPosition) != chanOp.Index {
+		return fmt.Errorf("output index of --short_channel_id must " +
+			"be equal to index on --channelpoint")
+	}
+
+	singles := []chanbackup.Single{newSingle(
+		*chanOp, shortChanID, nodePubkey, []net.Addr{addr}, capacity,
+	)}
+	return writeBackups(singles, keyRing, multiFile)
+}
+
+// parseShortChanID parses a short channel ID in the format
+// <blockheight>x<transactionindex>x<outputindex>.
+func parseShortChanID(shortChanID string) (lnwire.ShortChannelID, error) {
+	var empty lnwire.ShortChannelID
+	splitChanID := strings.Split(shortChanID, "x")
 	if len(splitChanID) != 3 {
-		return fmt.Errorf("--short_channel_id expected in format: " +
-			"<blockheight>x<transactionindex>x<outputindex>",
+		return empty, fmt.Errorf("--short_channel_id expected in " +
+			"format: <blockheight>x<transactionindex>x" +
+			"<outputindex>",
 		)
 	}
 	blockHeight, err := strconv.ParseInt(splitChanID[0], 10, 32)
 	if err != nil {
-		return fmt.Errorf("could not parse block height: %w", err)
+		return empty, fmt.Errorf("could not parse block height: %w",
+			err)
 	}
 	txIndex, err := strconv.ParseInt(splitChanID[1], 10, 32)
 	if err != nil {
-		return fmt.Errorf("could not parse transaction index: %w", err)
+		return empty, fmt.Errorf("could not parse transaction index: "+
+			"%w", err)
 	}
 	chanOutputIdx, err := strconv.ParseInt(splitChanID[2], 10, 32)
 	if err != nil {
-		return fmt.Errorf("could not parse output index: %w", err)
+		return empty, fmt.Errorf("could not parse output index: %w",
+			err)
 	}
-	shortChanID := lnwire.ShortChannelID{
+
+	return lnwire.ShortChannelID{
 		BlockHeight: uint32(blockHeight),
 		TxIndex:     uint32(txIndex),
 		TxPosition:  uint16(chanOutputIdx),
+	}, nil
+}
+
+// lookupFundingTx finds the confirmed funding transaction of a channel and
+// returns the channel's short channel ID and capacity.
+func lookupFundingTx(api btc.ChainAPI,
+	chanOp *wire.OutPoint) (lnwire.ShortChannelID, btcutil.Amount, error) {
+
+	var empty lnwire.ShortChannelID
+	blockAPI, ok := api.(btc.BlockAPI)
+	if !ok {
+		return empty, 0, fmt.Errorf("the chain backend can't look up " +
+			"the short channel ID, use --short_channel_id and " +
+			"--capacity or a block explorer API")
 	}
 
-	// Is the outpoint and/or short channel ID correct?
-	if uint32(chanOutputIdx) != chanOp.Index {
-		return fmt.Errorf("output index of --short_channel_id must " +
-			"be equal to index on --channelpoint")
+	txid := chanOp.Hash.String()
+	tx, err := api.Transaction(txid)
+	if err != nil {
+		return empty, 0, err
 	}
 
-	singles := []chanbackup.Single{newSingle(
-		*chanOp, shortChanID, nodePubkey, []net.Addr{addr},
-		btcutil.Amount(c.Capacity),
-	)}
-	return writeBackups(singles, keyRing, multiFile)
+	if int(chanOp.Index) >= len(tx.Vout) {
+		return empty, 0, fmt.Errorf("funding transaction only has %d "+
+			"outputs", len(tx.Vout))
+	}
+	if tx.Status == nil || !tx.Status.Confirmed {
+		return empty, 0, fmt.Errorf("funding transaction is not " +
+			"confirmed")
+	}
+
+	// The short channel ID contains the index of the transaction within
+	// its block, so we need to look at all transactions of the block.
+	txids, err := blockAPI.BlockTxIDs(tx.Status.BlockHash)
+	if err != nil {
+		return empty, 0, err
+	}
+	for txIndex, blockTxid := range txids {
+		if blockTxid != txid {
+			continue
+		}
+
+		return lnwire.ShortChannelID{
+			BlockHeight: uint32(tx.Status.BlockHeight),
+			TxIndex:     uint32(txIndex),
+			TxPosition:  uint16(chanOp.Index),
+		}, btcutil.Amount(tx.Vout[chanOp.Index].Value), nil
+	}
+
+	return empty, 0, fmt.Errorf("funding transaction not found in block "+
+		"%s", tx.Status.BlockHash)
 }
 
 func backupFromGraph(graph *lnrpc.ChannelGraph, keyRing *lnd.HDKeyRing,
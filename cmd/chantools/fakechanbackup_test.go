@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseShortChanID(t *testing.T) {
+	shortChanID, err := parseShortChanID("566222x300x1")
+	require.NoError(t, err)
+	require.Equal(t, lnwire.ShortChannelID{
+		BlockHeight: 566222,
+		TxIndex:     300,
+		TxPosition:  1,
+	}, shortChanID)
+
+	_, err = parseShortChanID("566222x300")
+	require.Error(t, err)
+}
+
+func TestLookupFundingTx(t *testing.T) {
+	chanOp := &wire.OutPoint{Hash: chainhash.Hash{1}, Index: 1}
+	txid := chanOp.Hash.String()
+	blockHash := chainhash.Hash{2}.String()
+
+	responses := map[string]interface{}{
+		"/tx/" + txid: &btc.TX{
+			TXID: txid,
+			Vout: []*btc.Vout{{Value: 1000}, {Value: 123456}},
+			Status: &btc.Status{
+				Confirmed:   true,
+				BlockHeight: 566222,
+				BlockHash:   blockHash,
+			},
+		},
+		"/tx/" + txid + "/outspend/0": &btc.Outspend{},
+		"/tx/" + txid + "/outspend/1": &btc.Outspend{},
+		"/block/" + blockHash + "/txids": []string{
+			chainhash.Hash{3}.String(), chainhash.Hash{4}.String(),
+			txid,
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			response, ok := responses[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(response)
+		},
+	))
+	defer server.Close()
+
+	api := &btc.ExplorerAPI{BaseURL: server.URL}
+	shortChanID, capacity, err := lookupFundingTx(api, chanOp)
+	require.NoError(t, err)
+	require.Equal(t, lnwire.ShortChannelID{
+		BlockHeight: 566222,
+		TxIndex:     2,
+		TxPosition:  1,
+	}, shortChanID)
+	require.Equal(t, btcutil.Amount(123456), capacity)
+
+	// Backends that can't list the transactions of a block can't be used
+	// to find the short channel ID.
+	_, _, err = lookupFundingTx(&mockChainAPI{}, chanOp)
+	require.ErrorContains(t, err, "use --short_channel_id")
+}
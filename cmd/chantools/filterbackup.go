@@ -1,10 +1,10 @@
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/guggero/chantools/lnd"
 	"github.com/lightningnetwork/lnd/chanbackup"
@@ -13,8 +13,11 @@ import (
 )
 
 type filterBackupCommand struct {
-	MultiFile string
-	Discard   string
+	MultiFile   string
+	Discard     string
+	DiscardPeer string
+	Keep        string
+	KeepPeer    string
 
 	rootKey *rootKey
 	cmd     *cobra.Command
@@ -26,11 +29,22 @@ func newFilterBackupCommand() *cobra.Command {
 		Use: "filterbackup",
 		Short: "Filter an lnd channel.backup file and remove certain " +
 			"channels",
-		Long: `Filter an lnd channel.backup file by removing certain 
-channels (identified by their funding transaction outpoints).`,
+		Long: `Filter an lnd channel.backup file by removing certain
+channels (identified by their funding transaction outpoints or by the public
+key of the remote peer).
+
+With --keep and --keeppeer, only the channels with the given funding outpoints
+or the given remote peers are kept and all others are removed. This can be used
+to split a single channel.backup file into multiple files, for example one per
+peer, to restore the channels in stages. If both keep and discard filters are
+given, the discard filters are applied to the channels that are kept.`,
 		Example: `chantools filterbackup \
 	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup \
-	--discard 2abcdef2b2bffaaa...db0abadd:1,4abcdef2b2bffaaa...db8abadd:0`,
+	--discard 2abcdef2b2bffaaa...db0abadd:1,4abcdef2b2bffaaa...db8abadd:0
+
+chantools filterbackup \
+	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup \
+	--keeppeer 03abce...`,
 		RunE: cc.Execute,
 	}
 	cc.cmd.Flags().StringVar(
@@ -42,6 +56,22 @@ channels (identified by their funding transaction outpoints).`,
 			"funding outpoints (format <fundingTXID>:<index>) to "+
 			"remove from the backup file",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.DiscardPeer, "discardpeer", "", "comma separated list of "+
+			"remote node public keys; all channels with those "+
+			"peers are removed from the backup file",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Keep, "keep", "", "comma separated list of channel "+
+			"funding outpoints (format <fundingTXID>:<index>) to "+
+			"keep in the backup file; all other channels are "+
+			"removed",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.KeepPeer, "keeppeer", "", "comma separated list of "+
+			"remote node public keys; only the channels with those "+
+			"peers are kept in the backup file",
+	)
 
 	cc.rootKey = newRootKey(cc.cmd, "decrypting the backup")
 
@@ -54,8 +84,13 @@ func (c *filterBackupCommand) Execute(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("error reading root key: %w", err)
 	}
 
-	// Parse discard filter.
-	discard := strings.Split(c.Discard, ",")
+	// Parse the filters.
+	filter := &backupFilter{
+		discard:      splitList(c.Discard),
+		discardPeers: splitList(c.DiscardPeer),
+		keep:         splitList(c.Keep),
+		keepPeers:    splitList(c.KeepPeer),
+	}
 
 	// Check that we have a backup file.
 	if c.MultiFile == "" {
@@ -66,11 +101,65 @@ func (c *filterBackupCommand) Execute(_ *cobra.Command, _ []string) error {
 		ExtendedKey: extendedKey,
 		ChainParams: chainParams,
 	}
-	return filterChannelBackup(multiFile, keyRing, discard)
+	return filterChannelBackup(multiFile, keyRing, filter)
+}
+
+// backupFilter decides which channels of a backup file are kept.
+type backupFilter struct {
+	discard      []string
+	discardPeers []string
+	keep         []string
+	keepPeers    []string
+}
+
+// keepChannel returns true if the given channel passes the filter.
+func (f *backupFilter) keepChannel(single *chanbackup.Single) bool {
+	chanPoint := single.FundingOutpoint.String()
+	peer := ""
+	if single.RemoteNodePub != nil {
+		peer = hex.EncodeToString(
+			single.RemoteNodePub.SerializeCompressed(),
+		)
+	}
+
+	contains := func(list []string, value string) bool {
+		for _, entry := range list {
+			if strings.EqualFold(entry, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// If there are any keep filters, the channel must match at least one
+	// of them.
+	if len(f.keep) > 0 || len(f.keepPeers) > 0 {
+		if !contains(f.keep, chanPoint) &&
+			!contains(f.keepPeers, peer) {
+
+			return false
+		}
+	}
+
+	return !contains(f.discard, chanPoint) &&
+		!contains(f.discardPeers, peer)
+}
+
+// splitList splits a comma separated list and removes empty entries.
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
 }
 
 func filterChannelBackup(multiFile *chanbackup.MultiFile, ring keychain.KeyRing,
-	discard []string) error {
+	filter *backupFilter) error {
 
 	multi, err := multiFile.ExtractMulti(ring)
 	if err != nil {
@@ -78,22 +167,18 @@ func filterChannelBackup(multiFile *chanbackup.MultiFile, ring keychain.KeyRing,
 	}
 
 	keep := make([]chanbackup.Single, 0, len(multi.StaticBackups))
-	for _, single := range multi.StaticBackups {
-		found := false
-		for _, discardChanPoint := range discard {
-			if single.FundingOutpoint.String() == discardChanPoint {
-				found = true
-			}
-		}
-		if found {
+	for idx := range multi.StaticBackups {
+		single := multi.StaticBackups[idx]
+		if !filter.keepChannel(&single) {
 			continue
 		}
 		keep = append(keep, single)
 	}
+	log.Infof("Keeping %d of %d channels", len(keep),
+		len(multi.StaticBackups))
 	multi.StaticBackups = keep
 
-	fileName := fmt.Sprintf("results/backup-filtered-%s.backup",
-		time.Now().Format("2006-01-02-15-04-05"))
+	fileName := resultFileName("backup-filtered", "backup")
 	log.Infof("Writing result to %s", fileName)
 	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupFilter(t *testing.T) {
+	privKey1, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	privKey2, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	peer1 := hex.EncodeToString(privKey1.PubKey().SerializeCompressed())
+	peer2 := hex.EncodeToString(privKey2.PubKey().SerializeCompressed())
+
+	singles := []*chanbackup.Single{{
+		FundingOutpoint: wire.OutPoint{Index: 1},
+		RemoteNodePub:   privKey1.PubKey(),
+	}, {
+		FundingOutpoint: wire.OutPoint{Index: 2},
+		RemoteNodePub:   privKey1.PubKey(),
+	}, {
+		FundingOutpoint: wire.OutPoint{Index: 3},
+		RemoteNodePub:   privKey2.PubKey(),
+	}}
+	chanPoint := func(idx int) string {
+		return singles[idx].FundingOutpoint.String()
+	}
+
+	testCases := []struct {
+		name   string
+		filter *backupFilter
+		kept   []bool
+	}{{
+		name:   "no filter",
+		filter: &backupFilter{},
+		kept:   []bool{true, true, true},
+	}, {
+		name: "discard channel point",
+		filter: &backupFilter{
+			discard: []string{chanPoint(1)},
+		},
+		kept: []bool{true, false, true},
+	}, {
+		name: "discard peer",
+		filter: &backupFilter{
+			discardPeers: []string{peer1},
+		},
+		kept: []bool{false, false, true},
+	}, {
+		name: "keep channel point",
+		filter: &backupFilter{
+			keep: []string{chanPoint(0), chanPoint(2)},
+		},
+		kept: []bool{true, false, true},
+	}, {
+		name: "keep peer",
+		filter: &backupFilter{
+			keepPeers: splitList(peer2 + ", "),
+		},
+		kept: []bool{false, false, true},
+	}, {
+		name: "keep peer and discard channel point",
+		filter: &backupFilter{
+			keepPeers: []string{peer1},
+			discard:   []string{chanPoint(0)},
+		},
+		kept: []bool{false, true, false},
+	}}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			for idx, single := range singles {
+				require.Equal(
+					t, tc.kept[idx],
+					tc.filter.keepChannel(single),
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/sweep"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/spf13/cobra"
+)
+
+const (
+	findKeyDefaultMaxIndex = 500
+
+	findKeyFormat = `
+Path:		%s
+Script type:	%s
+Public key:	%s
+`
+)
+
+// findKeyResult is the derivation path and script type of the key that
+// controls the searched output.
+type findKeyResult struct {
+	Path       string `json:"path"`
+	ScriptType string `json:"script_type"`
+	PubKey     string `json:"pubkey"`
+}
+
+// keyScript is a pkScript of the given type that is controlled by a key.
+type keyScript struct {
+	scriptType string
+	pkScript   []byte
+}
+
+type findKeyCommand struct {
+	Address  string
+	Outpoint string
+	MaxIndex uint32
+
+	chainAPI *chainAPIFlags
+	rootKey  *rootKey
+	cmd      *cobra.Command
+}
+
+func newFindKeyCommand() *cobra.Command {
+	cc := &findKeyCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "findkey",
+		Short: "Find the derivation path of the key that controls an " +
+			"address or outpoint",
+		Long: `Searches the keys derived from the seed for the one that
+controls the given address or outpoint and prints its derivation path. If an
+outpoint is given, its script is looked up with the chain API.
+
+The following keys are searched, each up to --maxindex:
+ - the receive and change branches of all lnd wallet accounts
+   (m/44'/<coin_type>'/0', m/49'/<coin_type>'/0', m/84'/<coin_type>'/0' and
+   m/86'/<coin_type>'/0'), for their respective address types
+ - all key families of lnd's internal keychain
+   (m/1017'/<coin_type>'/<family>'/0/<index>), as P2WKH address (to_remote
+   output of legacy and static_remote_key channels), as to_remote output of
+   anchor and simple taproot channels and as anchor output
+
+Outputs that are locked to more than one key (for example funding outputs or
+the to_local output of a commitment) can't be found this way.`,
+		Example: `chantools findkey --address bc1q.....
+
+chantools findkey --outpoint abcdef01234...:1 --maxindex 5000`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Address, "address", "", "the address to find the key for",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Outpoint, "outpoint", "", "the outpoint (txid:index) to "+
+			"find the key for",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.MaxIndex, "maxindex", findKeyDefaultMaxIndex, "the "+
+			"highest index to derive for each wallet branch and "+
+			"key family",
+	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
+
+	cc.rootKey = newRootKey(cc.cmd, "searching the key")
+
+	return cc.cmd
+}
+
+func (c *findKeyCommand) Execute(_ *cobra.Command, _ []string) error {
+	if (c.Address == "") == (c.Outpoint == "") {
+		return fmt.Errorf("either address or outpoint is required")
+	}
+
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	var targetScript []byte
+	switch {
+	case c.Address != "":
+		addr, err := lnd.ParseAddress(c.Address, chainParams)
+		if err != nil {
+			return fmt.Errorf("error parsing address: %w", err)
+		}
+		targetScript, err = txscript.PayToAddrScript(addr)
+		if err != nil {
+			return fmt.Errorf("error creating script of address: "+
+				"%w", err)
+		}
+
+	default:
+		targetScript, err = outpointScript(
+			c.chainAPI.api(), c.Outpoint,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := findKey(extendedKey, targetScript, c.MaxIndex)
+	if err != nil {
+		return err
+	}
+
+	if JSONOutput {
+		return printJSON(result)
+	}
+
+	msg := fmt.Sprintf(
+		findKeyFormat, result.Path, result.ScriptType, result.PubKey,
+	)
+	fmt.Fprintln(textOut(), msg)
+
+	// For the tests, also log as trace level which is disabled by default.
+	log.Tracef(msg)
+
+	return nil
+}
+
+// outpointScript looks up the pkScript of the given outpoint.
+func outpointScript(api btc.ChainAPI, outpoint string) ([]byte, error) {
+	op, err := lnd.ParseOutpoint(outpoint)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing outpoint: %w", err)
+	}
+
+	tx, err := api.Transaction(op.Hash.String())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching transaction %v: %w",
+			op.Hash, err)
+	}
+	if int(op.Index) >= len(tx.Vout) {
+		return nil, fmt.Errorf("transaction %v only has %d outputs",
+			op.Hash, len(tx.Vout))
+	}
+
+	pkScript, err := hex.DecodeString(tx.Vout[op.Index].ScriptPubkey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding script of outpoint "+
+			"%v: %w", op, err)
+	}
+
+	return pkScript, nil
+}
+
+// findKey derives the keys of the lnd wallet accounts and of lnd's internal
+// keychain up to the given index and returns the first one that controls the
+// target pkScript.
+func findKey(extendedKey *hdkeychain.ExtendedKey, targetScript []byte,
+	maxIndex uint32) (*findKeyResult, error) {
+
+	// A branch is the parent of the keys to search and the function that
+	// returns the scripts a key can control in that branch.
+	type branch struct {
+		path    []uint32
+		scripts func(*btcec.PublicKey) ([]*keyScript, error)
+	}
+
+	var branches []*branch
+	for _, account := range sweep.WalletAccounts {
+		branchTypes := [][]sweep.WalletAddrType{
+			account.ExternalTypes, account.InternalTypes,
+		}
+		for idx, addrTypes := range branchTypes {
+			addrTypes := addrTypes
+			branches = append(branches, &branch{
+				path: []uint32{
+					lnd.HardenedKey(account.Purpose),
+					lnd.HardenedKey(chainParams.HDCoinType),
+					lnd.HardenedKey(0), uint32(idx),
+				},
+				scripts: func(pubKey *btcec.PublicKey) (
+					[]*keyScript, error) {
+
+					return walletKeyScripts(
+						pubKey, addrTypes,
+					)
+				},
+			})
+		}
+	}
+	purpose := uint32(keychain.BIP0043Purpose)
+	for _, family := range keychain.VersionZeroKeyFamilies {
+		branches = append(branches, &branch{
+			path: []uint32{
+				lnd.HardenedKey(purpose),
+				lnd.HardenedKey(chainParams.HDCoinType),
+				lnd.HardenedKey(uint32(family)), 0,
+			},
+			scripts: channelKeyScripts,
+		})
+	}
+
+	for _, b := range branches {
+		branchKey, err := lnd.DeriveFromRoot(extendedKey, b.path)
+		if err != nil {
+			return nil, fmt.Errorf("error deriving branch key: %w",
+				err)
+		}
+
+		for index := uint32(0); index <= maxIndex; index++ {
+			key, err := branchKey.Derive(index)
+			if err != nil {
+				return nil, fmt.Errorf("error deriving key: %w",
+					err)
+			}
+			pubKey, err := key.ECPubKey()
+			if err != nil {
+				return nil, fmt.Errorf("error deriving public "+
+					"key: %w", err)
+			}
+
+			scripts, err := b.scripts(pubKey)
+			if err != nil {
+				return nil, err
+			}
+			for _, script := range scripts {
+				if !bytes.Equal(script.pkScript, targetScript) {
+					continue
+				}
+
+				path := append(
+					append([]uint32{}, b.path...), index,
+				)
+				return &findKeyResult{
+					Path:       lnd.FormatPath(path),
+					ScriptType: script.scriptType,
+					PubKey: hex.EncodeToString(
+						pubKey.SerializeCompressed(),
+					),
+				}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no key up to index %d controls the script "+
+		"%x, try increasing --maxindex", maxIndex, targetScript)
+}
+
+// walletKeyScripts returns the scripts of the given wallet address types of
+// the public key.
+func walletKeyScripts(pubKey *btcec.PublicKey,
+	addrTypes []sweep.WalletAddrType) ([]*keyScript, error) {
+
+	scripts := make([]*keyScript, 0, len(addrTypes))
+	for _, addrType := range addrTypes {
+		addr, err := sweep.WalletAddress(pubKey, addrType, chainParams)
+		if err != nil {
+			return nil, err
+		}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, &keyScript{
+			scriptType: addrType.String(),
+			pkScript:   pkScript,
+		})
+	}
+
+	return scripts, nil
+}
+
+// channelKeyScripts returns the scripts of channel outputs that are controlled
+// by the public key alone.
+func channelKeyScripts(pubKey *btcec.PublicKey) ([]*keyScript, error) {
+	p2wkh, err := lnd.P2WKHAddr(pubKey, chainParams)
+	if err != nil {
+		return nil, err
+	}
+	p2anchor, _, err := lnd.P2AnchorStaticRemote(pubKey, chainParams)
+	if err != nil {
+		return nil, err
+	}
+	p2tr, _, _, err := lnd.P2TaprootStaticRemote(pubKey, chainParams)
+	if err != nil {
+		return nil, err
+	}
+
+	var scripts []*keyScript
+	for _, addr := range []struct {
+		scriptType string
+		addr       btcutil.Address
+	}{
+		{scriptType: "p2wkh", addr: p2wkh},
+		{scriptType: "to_remote_anchors", addr: p2anchor},
+		{scriptType: "to_remote_taproot", addr: p2tr},
+	} {
+		pkScript, err := txscript.PayToAddrScript(addr.addr)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, &keyScript{
+			scriptType: addr.scriptType,
+			pkScript:   pkScript,
+		})
+	}
+
+	anchorScript, err := input.CommitScriptAnchor(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	anchorPkScript, err := input.WitnessScriptHash(anchorScript)
+	if err != nil {
+		return nil, err
+	}
+	scripts = append(scripts, &keyScript{
+		scriptType: "anchor",
+		pkScript:   anchorPkScript,
+	})
+
+	return scripts, nil
+}
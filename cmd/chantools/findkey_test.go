@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindKey(t *testing.T) {
+	h := newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+
+	deriveKey := func(path string) *hdkeychain.ExtendedKey {
+		parsedPath, err := lnd.ParsePath(path)
+		require.NoError(t, err)
+		key, err := lnd.DeriveChildren(extendedKey, parsedPath)
+		require.NoError(t, err)
+
+		return key
+	}
+	deriveScript := func(path string,
+		addrType func(*hdkeychain.ExtendedKey) btcutil.Address) []byte {
+
+		pkScript, err := txscript.PayToAddrScript(
+			addrType(deriveKey(path)),
+		)
+		require.NoError(t, err)
+
+		return pkScript
+	}
+	p2tr := func(key *hdkeychain.ExtendedKey) btcutil.Address {
+		pubKey, err := key.ECPubKey()
+		require.NoError(t, err)
+		addr, err := lnd.P2TRAddr(pubKey, chainParams)
+		require.NoError(t, err)
+		return addr
+	}
+	np2wkh := func(key *hdkeychain.ExtendedKey) btcutil.Address {
+		pubKey, err := key.ECPubKey()
+		require.NoError(t, err)
+		addr, err := lnd.NP2WKHAddr(pubKey, chainParams)
+		require.NoError(t, err)
+		return addr
+	}
+	toRemoteAnchor := func(key *hdkeychain.ExtendedKey) btcutil.Address {
+		pubKey, err := key.ECPubKey()
+		require.NoError(t, err)
+		addr, _, err := lnd.P2AnchorStaticRemote(pubKey, chainParams)
+		require.NoError(t, err)
+		return addr
+	}
+
+	testCases := []struct {
+		path       string
+		addrType   func(*hdkeychain.ExtendedKey) btcutil.Address
+		scriptType string
+	}{{
+		path:       "m/86'/1'/0'/1/3",
+		addrType:   p2tr,
+		scriptType: "p2tr",
+	}, {
+		path:       "m/49'/1'/0'/1/7",
+		addrType:   np2wkh,
+		scriptType: "np2wkh",
+	}, {
+		path:       "m/1017'/1'/3'/0/12",
+		addrType:   toRemoteAnchor,
+		scriptType: "to_remote_anchors",
+	}}
+	for _, tc := range testCases {
+		result, err := findKey(
+			extendedKey, deriveScript(tc.path, tc.addrType), 20,
+		)
+		require.NoError(t, err)
+		require.Equal(t, tc.path, result.Path)
+		require.Equal(t, tc.scriptType, result.ScriptType)
+	}
+
+	// Keys above the maximum index are not found.
+	_, err = findKey(
+		extendedKey, deriveScript("m/1017'/1'/3'/0/12", toRemoteAnchor),
+		10,
+	)
+	require.ErrorContains(t, err, "try increasing --maxindex")
+
+	// The script of an outpoint is looked up with the chain API.
+	pkScript := deriveScript("m/86'/1'/0'/0/1", p2tr)
+	txid := chainhash.Hash{1}.String()
+	api := &mockChainAPI{txs: map[string]*btc.TX{
+		txid: {Vout: []*btc.Vout{{}, {
+			ScriptPubkey: hex.EncodeToString(pkScript),
+		}}},
+	}}
+	script, err := outpointScript(api, txid+":1")
+	require.NoError(t, err)
+	require.Equal(t, pkScript, script)
+
+	_, err = outpointScript(api, txid+":2")
+	require.ErrorContains(t, err, "only has 2 outputs")
+
+	// The command prints the path of the key.
+	addr := p2tr(deriveKey("m/86'/1'/0'/0/4"))
+	findKey := &findKeyCommand{
+		Address:  addr.EncodeAddress(),
+		MaxIndex: 10,
+		rootKey:  &rootKey{RootKey: rootKeyAezeed},
+	}
+	require.NoError(t, findKey.Execute(nil, nil))
+	h.assertLogContains("m/86'/1'/0'/0/4")
+
+	// With --json only the result is written to stdout.
+	h.captureJSON()
+	require.NoError(t, findKey.Execute(nil, nil))
+	var jsonResult findKeyResult
+	h.assertJSONOutput(&jsonResult)
+	require.Equal(t, "m/86'/1'/0'/0/4", jsonResult.Path)
+	require.Equal(t, "p2tr", jsonResult.ScriptType)
+}
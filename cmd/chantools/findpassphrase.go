@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/aezeed"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultAddressLookahead = 20
+)
+
+var (
+	// maskCharsets are the placeholders that can be used in a mask and the
+	// characters they stand for.
+	maskCharsets = map[byte]string{
+		'd': "0123456789",
+		'l': "abcdefghijklmnopqrstuvwxyz",
+		'u': "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+		's': " !\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~",
+	}
+)
+
+// findPassphraseResult is the JSON result of the findpassphrase command.
+type findPassphraseResult struct {
+	Passphrase string `json:"passphrase"`
+}
+
+type findPassphraseCommand struct {
+	Wordlist         string
+	Mask             string
+	Address          string
+	AddressLookahead uint32
+	Workers          int
+
+	cmd *cobra.Command
+}
+
+func newFindPassphraseCommand() *cobra.Command {
+	cc := &findPassphraseCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "findpassphrase",
+		Short: "Brute force the passphrase of an lnd aezeed from a " +
+			"wordlist or mask",
+		Long: `This command tries to find the passphrase of an lnd 24 word
+aezeed if the words are known but not the exact passphrase that was used.
+
+The candidates are either read from a wordlist file (one passphrase per line)
+or generated from a mask. In a mask, the following placeholders are replaced
+with all characters of their character set: ?d (digits), ?l (lowercase
+letters), ?u (uppercase letters) and ?s (special characters including space).
+Use ?? for a literal question mark. If both a wordlist and a mask are given,
+the mask is appended to each word of the wordlist.
+
+Because the aezeed is encrypted with the passphrase, a wrong passphrase is
+detected by the seed's checksum. Each try takes a considerable amount of time
+because of the key derivation function used, so the number of candidates
+should be kept as small as possible.
+
+If --address is given, the passphrase is only reported as found if one of the
+first --addresslookahead external addresses of lnd's default wallet accounts
+(np2wkh, p2wkh and p2tr) matches the address.`,
+		Example: `chantools findpassphrase --wordlist passphrases.txt
+
+chantools findpassphrase --mask "MySecret?d?d" \
+	--address bc1q...`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Wordlist, "wordlist", "", "file containing the "+
+			"passphrase candidates, one per line",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Mask, "mask", "", "mask to generate the passphrase "+
+			"candidates from; appended to each word if used in "+
+			"conjunction with --wordlist",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Address, "address", "", "optional address of the wallet "+
+			"to check a decrypted seed against",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.AddressLookahead, "addresslookahead",
+		defaultAddressLookahead, "number of addresses per wallet "+
+			"account to check against --address",
+	)
+	cc.cmd.Flags().IntVar(
+		&cc.Workers, "workers", runtime.NumCPU(), "number of "+
+			"passphrases to try in parallel",
+	)
+
+	return cc.cmd
+}
+
+func (c *findPassphraseCommand) Execute(_ *cobra.Command, _ []string) error {
+	if c.Wordlist == "" && c.Mask == "" {
+		return fmt.Errorf("either --wordlist or --mask is required")
+	}
+	if c.Workers <= 0 {
+		c.Workers = 1
+	}
+	if c.AddressLookahead == 0 {
+		c.AddressLookahead = defaultAddressLookahead
+	}
+
+	words := []string{""}
+	if c.Wordlist != "" {
+		var err error
+		words, err = readWordlist(c.Wordlist)
+		if err != nil {
+			return fmt.Errorf("error reading wordlist: %w", err)
+		}
+	}
+
+	maskParts, err := parseMask(c.Mask)
+	if err != nil {
+		return fmt.Errorf("error parsing mask: %w", err)
+	}
+
+	numCandidates := uint64(len(words))
+	for _, part := range maskParts {
+		numCandidates *= uint64(len(part))
+	}
+	log.Infof("Trying %d passphrase candidates with %d workers",
+		numCandidates, c.Workers)
+
+	mnemonic, err := lnd.ReadAezeedMnemonic()
+	if err != nil {
+		return fmt.Errorf("error reading mnemonic: %w", err)
+	}
+
+	passphrase, err := findPassphrase(
+		mnemonic, words, maskParts, c.Workers, c.checkAddress,
+	)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Found passphrase: %s", passphrase)
+	if JSONOutput {
+		return printJSON(&findPassphraseResult{
+			Passphrase: passphrase,
+		})
+	}
+	fmt.Fprintf(textOut(), "Passphrase: %s\n", passphrase)
+
+	return nil
+}
+
+// checkAddress makes sure the given root key belongs to the wallet with the
+// user provided address, if there is one.
+func (c *findPassphraseCommand) checkAddress(
+	rootKey *hdkeychain.ExtendedKey) (bool, error) {
+
+	if c.Address == "" {
+		return true, nil
+	}
+
+	return walletHasAddress(rootKey, c.Address, c.AddressLookahead)
+}
+
+// walletHasAddress returns true if the given address is one of the first
+// external addresses of lnd's default wallet accounts (np2wkh, p2wkh and p2tr)
+// derived from the root key.
+func walletHasAddress(rootKey *hdkeychain.ExtendedKey, address string,
+	lookahead uint32) (bool, error) {
+
+	for _, purpose := range []uint32{49, 84, 86} {
+		path := []uint32{
+			lnd.HardenedKey(purpose),
+			lnd.HardenedKey(chainParams.HDCoinType),
+			lnd.HardenedKey(0), 0,
+		}
+		branch, err := lnd.DeriveChildren(rootKey, path)
+		if err != nil {
+			return false, err
+		}
+
+		for i := uint32(0); i < lookahead; i++ {
+			child, err := branch.DeriveNonStandard(i)
+			if err != nil {
+				return false, err
+			}
+			pubKey, err := child.ECPubKey()
+			if err != nil {
+				return false, err
+			}
+
+			var addr fmt.Stringer
+			switch purpose {
+			case 49:
+				addr, err = lnd.NP2WKHAddr(pubKey, chainParams)
+			case 84:
+				addr, err = lnd.P2WKHAddr(pubKey, chainParams)
+			case 86:
+				addr, err = lnd.P2TRAddr(pubKey, chainParams)
+			}
+			if err != nil {
+				return false, err
+			}
+
+			if addr.String() == address {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// readWordlist reads all non-empty lines of the given file.
+func readWordlist(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		word := strings.TrimRight(scanner.Text(), "\r")
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(words) == 0 {
+		return nil, fmt.Errorf("wordlist %s is empty", fileName)
+	}
+
+	return words, nil
+}
+
+// parseMask splits a mask into the list of possible characters for each of its
+// positions.
+func parseMask(mask string) ([]string, error) {
+	var parts []string
+	for i := 0; i < len(mask); i++ {
+		if mask[i] != '?' {
+			parts = append(parts, mask[i:i+1])
+			continue
+		}
+
+		if i+1 >= len(mask) {
+			return nil, fmt.Errorf("mask must not end with a single " +
+				"question mark")
+		}
+		i++
+
+		if mask[i] == '?' {
+			parts = append(parts, "?")
+			continue
+		}
+
+		charset, ok := maskCharsets[mask[i]]
+		if !ok {
+			return nil, fmt.Errorf("unknown placeholder ?%c",
+				mask[i])
+		}
+		parts = append(parts, charset)
+	}
+
+	return parts, nil
+}
+
+// generateCandidates sends all combinations of the words with the mask parts
+// appended to the returned channel. The channel is closed once all candidates
+// were sent or the quit channel is closed.
+func generateCandidates(words, maskParts []string,
+	quit <-chan struct{}) <-chan string {
+
+	candidates := make(chan string)
+	go func() {
+		defer close(candidates)
+
+		indexes := make([]int, len(maskParts))
+		for _, word := range words {
+			// Reset the indexes for each word, they are used as an
+			// odometer over all mask positions.
+			for i := range indexes {
+				indexes[i] = 0
+			}
+
+			for {
+				var sb strings.Builder
+				sb.WriteString(word)
+				for i, part := range maskParts {
+					sb.WriteByte(part[indexes[i]])
+				}
+
+				select {
+				case candidates <- sb.String():
+				case <-quit:
+					return
+				}
+
+				// Advance the odometer, starting from the
+				// last position.
+				pos := len(indexes) - 1
+				for ; pos >= 0; pos-- {
+					indexes[pos]++
+					if indexes[pos] < len(maskParts[pos]) {
+						break
+					}
+					indexes[pos] = 0
+				}
+				if pos < 0 {
+					break
+				}
+			}
+		}
+	}()
+
+	return candidates
+}
+
+// findPassphrase tries to decrypt the mnemonic with all candidates in parallel
+// and returns the first passphrase that succeeds and passes the check.
+func findPassphrase(mnemonic *aezeed.Mnemonic, words, maskParts []string,
+	workers int,
+	check func(*hdkeychain.ExtendedKey) (bool, error)) (string, error) {
+
+	// An unknown word or a wrong checksum means the words themselves are
+	// wrong, independent of the passphrase. So there's no need to try any
+	// candidate in that case.
+	_, err := mnemonic.ToCipherSeed(nil)
+	switch {
+	case err == nil:
+		return "", fmt.Errorf("seed can be decrypted without a " +
+			"passphrase")
+
+	case !errors.Is(err, aezeed.ErrInvalidPass):
+		return "", fmt.Errorf("invalid mnemonic: %w", err)
+	}
+
+	var (
+		quit       = make(chan struct{})
+		quitOnce   sync.Once
+		wg         sync.WaitGroup
+		resultMtx  sync.Mutex
+		result     string
+		found      bool
+		resultErr  error
+		candidates = generateCandidates(words, maskParts, quit)
+	)
+	stop := func() {
+		quitOnce.Do(func() { close(quit) })
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for candidate := range candidates {
+				ok, err := tryPassphrase(
+					mnemonic, candidate, check,
+				)
+
+				resultMtx.Lock()
+				switch {
+				case err != nil && resultErr == nil:
+					resultErr = err
+					stop()
+
+				case ok && !found:
+					result, found = candidate, true
+					stop()
+				}
+				resultMtx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	switch {
+	case found:
+		return result, nil
+
+	case resultErr != nil:
+		return "", resultErr
+
+	default:
+		return "", fmt.Errorf("passphrase not found")
+	}
+}
+
+// tryPassphrase attempts to decrypt the mnemonic with the given passphrase.
+func tryPassphrase(mnemonic *aezeed.Mnemonic, passphrase string,
+	check func(*hdkeychain.ExtendedKey) (bool, error)) (bool, error) {
+
+	cipherSeed, err := mnemonic.ToCipherSeed([]byte(passphrase))
+	switch {
+	case errors.Is(err, aezeed.ErrInvalidPass):
+		return false, nil
+
+	case err != nil:
+		return false, fmt.Errorf("error decrypting seed: %w", err)
+	}
+
+	rootKey, err := hdkeychain.NewMaster(
+		cipherSeed.Entropy[:], chainParams,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error deriving master key: %w", err)
+	}
+
+	ok, err := check(rootKey)
+	if err != nil {
+		return false, fmt.Errorf("error checking address: %w", err)
+	}
+	if !ok {
+		log.Infof("Passphrase '%s' decrypts the seed but doesn't "+
+			"match the address, continuing search", passphrase)
+	}
+
+	return ok, nil
+}
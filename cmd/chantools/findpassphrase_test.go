@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/guggero/chantools/lnd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindPassphraseMask(t *testing.T) {
+	h := newHarness(t)
+
+	find := &findPassphraseCommand{
+		Mask:    "testnet?d",
+		Workers: 4,
+	}
+
+	t.Setenv(lnd.MnemonicEnvName, seedAezeedWithPassphrase)
+
+	err := find.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains("Found passphrase: " + testPassPhrase)
+
+	// With --json only the result is written to stdout.
+	h.captureJSON()
+	require.NoError(t, find.Execute(nil, nil))
+	var result findPassphraseResult
+	h.assertJSONOutput(&result)
+	require.Equal(t, testPassPhrase, result.Passphrase)
+}
+
+func TestFindPassphraseWordlistAddress(t *testing.T) {
+	_ = newHarness(t)
+
+	wordlist := filepath.Join(t.TempDir(), "wordlist.txt")
+	err := os.WriteFile(
+		wordlist, []byte("foo\n"+testPassPhrase+"\nbar\n"), 0644,
+	)
+	require.NoError(t, err)
+
+	// The passphrase decrypts the seed but the wallet doesn't contain the
+	// address, so the search must fail.
+	find := &findPassphraseCommand{
+		Wordlist: wordlist,
+		Address:  "bcrt1qnl5qfvpfcmj7y56nugpermluu46x79sfz0ku70",
+		Workers:  2,
+	}
+
+	t.Setenv(lnd.MnemonicEnvName, seedAezeedWithPassphrase)
+
+	err = find.Execute(nil, nil)
+	require.ErrorContains(t, err, "passphrase not found")
+
+	// A seed without a passphrase doesn't need to be brute forced.
+	t.Setenv(lnd.MnemonicEnvName, seedAezeedNoPassphrase)
+
+	err = find.Execute(nil, nil)
+	require.ErrorContains(t, err, "without a passphrase")
+}
+
+func TestParseMask(t *testing.T) {
+	parts, err := parseMask("a??b?d")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "?", "b", "0123456789"}, parts)
+
+	_, err = parseMask("abc?")
+	require.ErrorContains(t, err, "single question mark")
+
+	_, err = parseMask("?x")
+	require.ErrorContains(t, err, "unknown placeholder")
+
+	// Make sure all combinations are generated exactly once.
+	parts, err = parseMask("?d?d")
+	require.NoError(t, err)
+
+	quit := make(chan struct{})
+	seen := make(map[string]struct{})
+	candidates := generateCandidates([]string{"a", "b"}, parts, quit)
+	for candidate := range candidates {
+		seen[candidate] = struct{}{}
+	}
+	require.Len(t, seen, 200)
+	require.Contains(t, seen, "a00")
+	require.Contains(t, seen, "b99")
+}
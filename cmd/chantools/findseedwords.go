@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/aezeed"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// aezeedChecksumOffset is the offset of the checksum within the
+	// enciphered aezeed.
+	aezeedChecksumOffset = aezeed.EncipheredCipherSeedSize - 4
+
+	// unknownWord is the placeholder for an unknown word in the mnemonic.
+	unknownWord = "?"
+)
+
+var (
+	aezeedCRCTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// findSeedWordsResult is the JSON result of the findseedwords command.
+type findSeedWordsResult struct {
+	Mnemonics []string `json:"mnemonics"`
+}
+
+type findSeedWordsCommand struct {
+	Address          string
+	AddressLookahead uint32
+	NodePubKey       string
+
+	passphrase []byte
+	cmd        *cobra.Command
+}
+
+func newFindSeedWordsCommand() *cobra.Command {
+	cc := &findSeedWordsCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "findseedwords",
+		Short: "Find missing or illegible words of an lnd aezeed by " +
+			"brute forcing them",
+		Long: `This command tries to recover the missing or illegible words
+of an lnd 24 word aezeed.
+
+When asked for the mnemonic, enter all known words and replace each unknown
+word with a single question mark (?). If only the beginning of a word is
+readable, enter the known letters followed by a question mark (for example
+ab?), then only the words starting with those letters are tried.
+
+All combinations of candidate words are first validated against the checksum
+of the aezeed, which is fast. Only the combinations with a valid checksum are
+then decrypted with the passphrase, which takes a moment each. With two fully
+unknown words, about 4 million combinations are checked.
+
+Because the checksum is only 32 bits long, it is recommended to confirm the
+result with a known address of the wallet (--address, see findpassphrase) or
+the node's identity public key (--nodepubkey).`,
+		Example: `chantools findseedwords \
+	--nodepubkey 03abcd...`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Address, "address", "", "optional address of the wallet "+
+			"to check a decrypted seed against",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.AddressLookahead, "addresslookahead",
+		defaultAddressLookahead, "number of addresses per wallet "+
+			"account to check against --address",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.NodePubKey, "nodepubkey", "", "optional identity public "+
+			"key of the node to check a decrypted seed against",
+	)
+
+	return cc.cmd
+}
+
+func (c *findSeedWordsCommand) Execute(_ *cobra.Command, _ []string) error {
+	if c.AddressLookahead == 0 {
+		c.AddressLookahead = defaultAddressLookahead
+	}
+
+	mnemonic, err := lnd.ReadAezeedMnemonic()
+	if err != nil {
+		return fmt.Errorf("error reading mnemonic: %w", err)
+	}
+	candidates, err := seedWordCandidates(mnemonic)
+	if err != nil {
+		return err
+	}
+
+	numCombinations := uint64(1)
+	for _, words := range candidates {
+		numCombinations *= uint64(len(words))
+	}
+	log.Infof("Checking %d word combinations", numCombinations)
+
+	c.passphrase, err = lnd.ReadAezeedPassphrase()
+	if err != nil {
+		return fmt.Errorf("error reading passphrase: %w", err)
+	}
+
+	results, err := findSeedWords(candidates, c.checkMnemonic)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no matching mnemonic found")
+	}
+
+	mnemonics := make([]string, len(results))
+	for idx, result := range results {
+		mnemonics[idx] = strings.Join(result[:], " ")
+		log.Infof("Found mnemonic: %s", mnemonics[idx])
+	}
+	if JSONOutput {
+		return printJSON(&findSeedWordsResult{Mnemonics: mnemonics})
+	}
+	for _, mnemonic := range mnemonics {
+		fmt.Fprintf(textOut(), "Mnemonic: %s\n", mnemonic)
+	}
+
+	return nil
+}
+
+// checkMnemonic decrypts a mnemonic with a valid checksum and makes sure it
+// matches the address or node public key given by the user.
+func (c *findSeedWordsCommand) checkMnemonic(
+	mnemonic *aezeed.Mnemonic) (bool, error) {
+
+	cipherSeed, err := mnemonic.ToCipherSeed(c.passphrase)
+	switch {
+	case errors.Is(err, aezeed.ErrInvalidPass):
+		log.Infof("Checksum of mnemonic '%s' is valid but it can't be "+
+			"decrypted with the passphrase, continuing search",
+			strings.Join(mnemonic[:], " "))
+		return false, nil
+
+	case err != nil:
+		return false, fmt.Errorf("error decrypting seed: %w", err)
+	}
+
+	rootKey, err := hdkeychain.NewMaster(
+		cipherSeed.Entropy[:], chainParams,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error deriving master key: %w", err)
+	}
+
+	if c.Address != "" {
+		ok, err := walletHasAddress(
+			rootKey, c.Address, c.AddressLookahead,
+		)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	if c.NodePubKey != "" {
+		_, pubKey, _, err := lnd.DeriveKey(
+			rootKey, lnd.IdentityPath(chainParams), chainParams,
+		)
+		if err != nil {
+			return false, err
+		}
+
+		pubKeyHex := hex.EncodeToString(pubKey.SerializeCompressed())
+		if !strings.EqualFold(pubKeyHex, c.NodePubKey) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// seedWordCandidates returns the list of candidate word indexes for each of the
+// words in the mnemonic. Known words only have a single candidate, a question
+// mark stands for all words and a prefix followed by a question mark for all
+// words starting with that prefix.
+func seedWordCandidates(mnemonic *aezeed.Mnemonic) ([][]uint16, error) {
+	candidates := make([][]uint16, len(mnemonic))
+	for idx, word := range mnemonic {
+		if index, ok := aezeed.ReverseWordMap[word]; ok {
+			candidates[idx] = []uint16{uint16(index)}
+			continue
+		}
+
+		if !strings.HasSuffix(word, unknownWord) {
+			return nil, fmt.Errorf("unknown word '%s' at position "+
+				"%d, replace it with '%s' or with the known "+
+				"letters followed by '%s'", word, idx+1,
+				unknownWord, unknownWord)
+		}
+
+		prefix := strings.TrimSuffix(word, unknownWord)
+		for index, candidate := range aezeed.DefaultWordList {
+			if strings.HasPrefix(candidate, prefix) {
+				candidates[idx] = append(
+					candidates[idx], uint16(index),
+				)
+			}
+		}
+
+		if len(candidates[idx]) == 0 {
+			return nil, fmt.Errorf("no word starts with '%s' (at "+
+				"position %d)", prefix, idx+1)
+		}
+	}
+
+	return candidates, nil
+}
+
+// findSeedWords iterates over all combinations of the candidate words and
+// returns the mnemonics that have a valid checksum and pass the check.
+func findSeedWords(candidates [][]uint16,
+	check func(*aezeed.Mnemonic) (bool, error)) ([]*aezeed.Mnemonic,
+	error) {
+
+	var (
+		indexes   = make([]uint16, len(candidates))
+		positions = make([]int, len(candidates))
+		results   []*aezeed.Mnemonic
+	)
+	for {
+		for i, words := range candidates {
+			indexes[i] = words[positions[i]]
+		}
+
+		if aezeedChecksumValid(indexes) {
+			var mnemonic aezeed.Mnemonic
+			for i, index := range indexes {
+				mnemonic[i] = aezeed.DefaultWordList[index]
+			}
+
+			ok, err := check(&mnemonic)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				results = append(results, &mnemonic)
+			}
+		}
+
+		// Advance to the next combination, starting from the last
+		// word.
+		pos := len(positions) - 1
+		for ; pos >= 0; pos-- {
+			positions[pos]++
+			if positions[pos] < len(candidates[pos]) {
+				break
+			}
+			positions[pos] = 0
+		}
+		if pos < 0 {
+			return results, nil
+		}
+	}
+}
+
+// aezeedChecksumValid packs the given word indexes into the enciphered aezeed
+// and checks its version and checksum.
+func aezeedChecksumValid(indexes []uint16) bool {
+	var (
+		cipherText [aezeed.EncipheredCipherSeedSize]byte
+		bitPos     int
+	)
+	for _, index := range indexes {
+		for bit := aezeed.BitsPerWord - 1; bit >= 0; bit-- {
+			if (index>>bit)&1 == 1 {
+				cipherText[bitPos/8] |= 0x80 >> (bitPos % 8)
+			}
+			bitPos++
+		}
+	}
+
+	if cipherText[0] != aezeed.CipherSeedVersion {
+		return false
+	}
+
+	checksum := crc32.Checksum(
+		cipherText[:aezeedChecksumOffset], aezeedCRCTable,
+	)
+	return checksum == binary.BigEndian.Uint32(
+		cipherText[aezeedChecksumOffset:],
+	)
+}
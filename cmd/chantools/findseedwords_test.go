@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/guggero/chantools/lnd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindSeedWords(t *testing.T) {
+	h := newHarness(t)
+
+	// Replace one word completely and one with just its first two letters.
+	words := strings.Split(seedAezeedNoPassphrase, " ")
+	words[2] = unknownWord
+	words[9] = words[9][:2] + unknownWord
+
+	find := &findSeedWordsCommand{}
+
+	t.Setenv(lnd.MnemonicEnvName, strings.Join(words, " "))
+	t.Setenv(lnd.PassphraseEnvName, "-")
+
+	err := find.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains("Found mnemonic: " + seedAezeedNoPassphrase)
+
+	// With --json only the result is written to stdout.
+	h.captureJSON()
+	require.NoError(t, find.Execute(nil, nil))
+	var result findSeedWordsResult
+	h.assertJSONOutput(&result)
+	require.Equal(t, []string{seedAezeedNoPassphrase}, result.Mnemonics)
+
+	// With a node public key that doesn't belong to the seed, nothing
+	// should be found.
+	find.NodePubKey = "03" + strings.Repeat("ab", 32)
+	err = find.Execute(nil, nil)
+	require.ErrorContains(t, err, "no matching mnemonic")
+}
+
+func TestSeedWordCandidates(t *testing.T) {
+	words := strings.Split(seedAezeedNoPassphrase, " ")
+	words[0] = "xyz"
+
+	t.Setenv(lnd.MnemonicEnvName, strings.Join(words, " "))
+	mnemonic, err := lnd.ReadAezeedMnemonic()
+	require.NoError(t, err)
+
+	_, err = seedWordCandidates(mnemonic)
+	require.ErrorContains(t, err, "unknown word 'xyz' at position 1")
+
+	mnemonic[0] = "xyz" + unknownWord
+	_, err = seedWordCandidates(mnemonic)
+	require.ErrorContains(t, err, "no word starts with 'xyz'")
+
+	mnemonic[0] = unknownWord
+	candidates, err := seedWordCandidates(mnemonic)
+	require.NoError(t, err)
+	require.Len(t, candidates[0], 2048)
+	require.Len(t, candidates[1], 1)
+}
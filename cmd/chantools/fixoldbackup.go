@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"time"
 
 	"github.com/guggero/chantools/lnd"
 	"github.com/lightningnetwork/lnd/chanbackup"
@@ -108,8 +107,7 @@ func fixOldChannelBackup(multiFile *chanbackup.MultiFile,
 	}
 
 	log.Infof("Fixed shachain root of %d channels.", fixedChannels)
-	fileName := fmt.Sprintf("results/backup-fixed-%s.backup",
-		time.Now().Format("2006-01-02-15-04-05"))
+	fileName := resultFileName("backup-fixed", "backup")
 	log.Infof("Writing result to %s", fileName)
 	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
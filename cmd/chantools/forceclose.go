@@ -4,29 +4,35 @@ import (
 	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"time"
 
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/dataformat"
 	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/sweep"
 	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/input"
 	"github.com/spf13/cobra"
 )
 
 type forceCloseCommand struct {
-	APIURL    string
-	ChannelDB string
-	Publish   bool
+	ChannelDB    string
+	ChannelPoint string
+	Publish      bool
+	CPFPFeeRate  uint16
+	CPFPUtxo     string
+	CPFPUtxoPath string
 
-	rootKey *rootKey
-	inputs  *inputFlags
-	cmd     *cobra.Command
+	chainAPI *chainAPIFlags
+	rootKey  *rootKey
+	inputs   *inputFlags
+	cmd      *cobra.Command
 }
 
 func newForceCloseCommand() *cobra.Command {
@@ -37,7 +43,30 @@ func newForceCloseCommand() *cobra.Command {
 			"provided",
 		Long: `If you are certain that a node is offline for good (AFTER
 you've tried SCB!) and a channel is still open, you can use this method to
-force-close your latest state that you have in your channel.db.
+force-close your latest state that you have in your channel.db. This also works
+if lnd doesn't start anymore but its channel.db is still intact.
+
+The latest local commitment transaction and the signature of the remote node
+for it are read from the channel.db. The witness is completed with the funding
+key derived from the seed and verified against the funding output before
+anything is published.
+
+If none of the channel input flags (--fromsummary, --listchannels,
+--pendingchannels or --fromchanneldb) is given, all channels of the channel.db
+whose funding output is still unspent are force-closed. Use --channelpoint to
+only force-close a single channel.
+
+At high mempool fee levels, the commitment transaction of an anchor channel
+might not pay enough fees to be relayed on its own. With --cpfpfeerate, a child
+transaction is created that spends our anchor output and the wallet UTXO given
+with --cpfputxo (a P2WKH output of the key at --cpfputxopath) to bump the fee of
+the package of both transactions to the given fee rate. The change is sent back
+to the address of the UTXO. If the chain backend is bitcoind (--bitcoindrpc),
+both transactions are submitted as a package with submitpackage (bitcoind 28 or
+later). Other backends publish the commitment transaction first and the child
+afterwards, which only works if the commitment transaction pays the minimum
+relay fee on its own. CPFP can only be used for a single channel selected with
+--channelpoint.
 
 **!!! WARNING !!! DANGER !!! WARNING !!!**
 
@@ -46,25 +75,61 @@ the remote node *could* punish you by taking the whole channel amount *if* they
 come online before you can sweep the funds from the time locked (144 - 2000
 blocks) transaction *or* they have a watch tower looking out for them.
 
+Never use a channel.db that was restored from a file backup or copied from
+another machine while lnd was running, its state is very likely outdated!
+Channels that lnd itself already detected to have an outdated state are never
+force-closed.
+
 **This should absolutely be the last resort and you have been warned!**`,
 		Example: `chantools forceclose \
 	--fromsummary results/summary-xxxx-yyyy.json
 	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--publish
+
+chantools forceclose \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--channelpoint abcdef01234...:1 \
+	--publish
+
+chantools forceclose \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--channelpoint abcdef01234...:1 \
+	--cpfpfeerate 50 \
+	--cpfputxo fedcba09876...:0 \
+	--cpfputxopath "m/84'/0'/0'/0/3" \
+	--bitcoindrpc localhost:8332 \
 	--publish`,
 		RunE: cc.Execute,
 	}
-	cc.cmd.Flags().StringVar(
-		&cc.APIURL, "apiurl", defaultAPIURL, "API URL to use (must "+
-			"be esplora compatible)",
-	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
 	cc.cmd.Flags().StringVar(
 		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to use "+
 			"for force-closing channels",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelPoint, "channelpoint", "", "only force-close the "+
+			"channel with the given channel point "+
+			"(<txid>:<txindex>)",
+	)
 	cc.cmd.Flags().BoolVar(
 		&cc.Publish, "publish", false, "publish force-closing TX to "+
 			"the chain API instead of just printing the TX",
 	)
+	cc.cmd.Flags().Uint16Var(
+		&cc.CPFPFeeRate, "cpfpfeerate", 0, "fee rate in sat/vByte "+
+			"the package of the commitment TX and an anchor CPFP "+
+			"child TX should pay; 0 disables CPFP",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.CPFPUtxo, "cpfputxo", "", "outpoint (<txid>:<txindex>) of "+
+			"a P2WKH wallet UTXO that pays the fees of the anchor "+
+			"CPFP child TX",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.CPFPUtxoPath, "cpfputxopath", "", "BIP32 derivation path "+
+			"of the key of the UTXO given with --cpfputxo, for "+
+			"example m/84'/0'/0'/0/3",
+	)
 
 	cc.rootKey = newRootKey(cc.cmd, "decrypting the backup")
 	cc.inputs = newInputFlags(cc.cmd)
@@ -79,33 +144,86 @@ func (c *forceCloseCommand) Execute(_ *cobra.Command, _ []string) error {
 	}
 
 	// Check that we have a channel DB.
-	if c.ChannelDB == "" {
+	if !channelDBGiven(c.ChannelDB) {
 		return fmt.Errorf("rescue DB is required")
 	}
-	db, err := lnd.OpenDB(c.ChannelDB, true)
+	db, err := openChannelDB(c.ChannelDB, true)
 	if err != nil {
 		return fmt.Errorf("error opening rescue DB: %w", err)
 	}
+	defer func() { _ = db.Close() }()
 
-	// Parse channel entries from any of the possible input files.
-	entries, err := c.inputs.parseInputType()
+	// Parse channel entries from any of the possible input files or use
+	// the channels of the channel DB itself if none is given.
+	api := c.chainAPI.api()
+	var entries []*dataformat.SummaryEntry
+	if c.inputs.empty() {
+		entries, err = channelDBEntries(api, db.ChannelStateDB())
+	} else {
+		entries, err = c.inputs.parseInputType()
+	}
 	if err != nil {
 		return err
 	}
-	return forceCloseChannels(
-		c.APIURL, extendedKey, entries, db.ChannelStateDB(), c.Publish,
+	if c.ChannelPoint != "" {
+		entries, err = filterChannelPoint(entries, c.ChannelPoint)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Publish {
+		log.Warnf("!!! Publishing the local commitment of %d "+
+			"channel(s) !!! If any of them is NOT the latest "+
+			"state, the remote node can take ALL funds of that "+
+			"channel!", len(entries))
+	}
+
+	var cpfp *forceCloseCPFP
+	if c.CPFPFeeRate > 0 {
+		if c.ChannelPoint == "" {
+			return fmt.Errorf("--cpfpfeerate can only be used " +
+				"together with --channelpoint")
+		}
+		feeInput, err := cpfpFeeInput(
+			api, extendedKey, c.CPFPUtxo, c.CPFPUtxoPath,
+		)
+		if err != nil {
+			return err
+		}
+		cpfp = &forceCloseCPFP{
+			feeRate:  c.CPFPFeeRate,
+			feeInput: feeInput,
+		}
+	}
+
+	results := newTxResults()
+	err = forceCloseChannels(
+		api, extendedKey, entries, db.ChannelStateDB(), c.Publish, cpfp,
+		results,
 	)
+	if err != nil {
+		return err
+	}
+
+	return results.print()
 }
 
-func forceCloseChannels(apiURL string, extendedKey *hdkeychain.ExtendedKey,
+// forceCloseCPFP is the fee rate and wallet UTXO used to bump the fee of a
+// commitment transaction with an anchor CPFP child transaction.
+type forceCloseCPFP struct {
+	feeRate  uint16
+	feeInput *sweep.AnchorFeeInput
+}
+
+func forceCloseChannels(api btc.SweepAPI, extendedKey *hdkeychain.ExtendedKey,
 	entries []*dataformat.SummaryEntry, chanDb *channeldb.ChannelStateDB,
-	publish bool) error {
+	publish bool, cpfp *forceCloseCPFP, results *txResults) error {
 
 	channels, err := chanDb.FetchAllChannels()
 	if err != nil {
 		return err
 	}
-	api := &btc.ExplorerAPI{BaseURL: apiURL}
 	signer := &lnd.Signer{
 		ExtendedKey: extendedKey,
 		ChainParams: chainParams,
@@ -127,97 +245,58 @@ func forceCloseChannels(apiURL string, extendedKey *hdkeychain.ExtendedKey,
 			continue
 		}
 
-		localCommit := channel.LocalCommitment
-		localCommitTx := localCommit.CommitTx
-		if localCommitTx == nil {
-			log.Errorf("Cannot force-close, no local commit TX "+
-				"for channel %s", channelEntry.ChannelPoint)
+		// If lnd found out that its state is outdated, the remote
+		// node would be able to punish us.
+		if channel.HasChanStatus(channeldb.ChanStatusLocalDataLoss) {
+			log.Errorf("Refusing to force-close channel %s, lnd "+
+				"detected that its local state is outdated",
+				channelPoint)
+			results.skip(channelPoint, "local state is outdated")
 
 			continue
 		}
 
-		// Create signed transaction.
-		lc := &lnd.LightningChannel{
-			LocalChanCfg:  channel.LocalChanCfg,
-			RemoteChanCfg: channel.RemoteChanCfg,
-			ChannelState:  channel,
-			TXSigner:      signer,
-		}
-		err := lc.CreateSignDesc()
-		if err != nil {
-			return err
-		}
+		if channel.LocalCommitment.CommitTx == nil {
+			log.Errorf("Cannot force-close, no local commit TX "+
+				"for channel %s", channelEntry.ChannelPoint)
+			results.skip(channelPoint, "no local commit TX")
 
-		// Serialize transaction.
-		signedTx, err := lc.SignedCommitTx()
-		if err != nil {
-			return err
-		}
-		var buf bytes.Buffer
-		err = signedTx.Serialize(io.Writer(&buf))
-		if err != nil {
-			return err
+			continue
 		}
-		hash := signedTx.TxHash()
-		serialized := hex.EncodeToString(buf.Bytes())
 
-		// Calculate commit point.
-		basepoint := channel.LocalChanCfg.DelayBasePoint
-		revpoint := channel.RemoteChanCfg.RevocationBasePoint
-		revocationPreimage, err := channel.RevocationProducer.AtIndex(
-			localCommit.CommitHeight,
-		)
+		forceClose, signedTx, err := localForceClose(channel, signer)
 		if err != nil {
 			return err
 		}
-		point := input.ComputeCommitmentPoint(revocationPreimage[:])
 
 		// Store all information that we collected into the channel
 		// entry file so we don't need to use the channel.db file for
 		// the next step.
-		channelEntry.ForceClose = &dataformat.ForceClose{
-			TXID:       hash.String(),
-			Serialized: serialized,
-			DelayBasePoint: &dataformat.BasePoint{
-				Family: uint16(basepoint.Family),
-				Index:  basepoint.Index,
-				PubKey: hex.EncodeToString(
-					basepoint.PubKey.SerializeCompressed(),
-				),
-			},
-			RevocationBasePoint: &dataformat.BasePoint{
-				PubKey: hex.EncodeToString(
-					revpoint.PubKey.SerializeCompressed(),
-				),
-			},
-			CommitPoint: hex.EncodeToString(
-				point.SerializeCompressed(),
-			),
-			Outs: make(
-				[]*dataformat.Out, len(localCommitTx.TxOut),
-			),
-			CSVDelay: channel.LocalChanCfg.CsvDelay,
-		}
-		for idx, out := range localCommitTx.TxOut {
-			script, err := txscript.DisasmString(out.PkScript)
-			if err != nil {
-				return err
-			}
-			channelEntry.ForceClose.Outs[idx] = &dataformat.Out{
-				Script:    hex.EncodeToString(out.PkScript),
-				ScriptAsm: script,
-				Value:     uint64(out.Value),
-			}
-		}
+		channelEntry.ForceClose = forceClose
 
 		// Publish TX.
 		if publish {
-			response, err := api.PublishTx(serialized)
-			if err != nil {
-				return err
-			}
-			log.Infof("Published TX %s, response: %s",
-				hash.String(), response)
+			log.Warnf("Publishing local commitment of channel %s "+
+				"at height %d, the remote node can punish us "+
+				"if this is not the latest state!",
+				channelPoint,
+				channel.LocalCommitment.CommitHeight)
+		}
+		inputs := []*txResultInput{{
+			Outpoint:     channelPoint,
+			Value:        int64(channel.Capacity),
+			ChannelPoint: channelPoint,
+		}}
+		if cpfp != nil {
+			err = addAnchorCPFP(
+				api, signer, channel, signedTx, inputs, cpfp,
+				publish, results,
+			)
+		} else {
+			err = results.addTx(api, signedTx, inputs, publish)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
@@ -227,8 +306,257 @@ func forceCloseChannels(apiURL string, extendedKey *hdkeychain.ExtendedKey,
 	if err != nil {
 		return err
 	}
-	fileName := fmt.Sprintf("results/forceclose-%s.json",
-		time.Now().Format("2006-01-02-15-04-05"))
+	fileName := resultFileName("forceclose", "json")
 	log.Infof("Writing result to %s", fileName)
 	return ioutil.WriteFile(fileName, summaryBytes, 0644)
 }
+
+// cpfpFeeInput looks up the given wallet UTXO that pays the fees of an anchor
+// CPFP child transaction and derives its private key.
+func cpfpFeeInput(api btc.ChainAPI, extendedKey *hdkeychain.ExtendedKey,
+	utxo, path string) (*sweep.AnchorFeeInput, error) {
+
+	if utxo == "" || path == "" {
+		return nil, fmt.Errorf("--cpfputxo and --cpfputxopath are " +
+			"required for CPFP")
+	}
+
+	return walletFeeInput(api, extendedKey, utxo, path)
+}
+
+// walletFeeInput looks up the given wallet UTXO that is added to a transaction
+// to pay its fees and derives its private key from the given path.
+func walletFeeInput(api btc.ChainAPI, extendedKey *hdkeychain.ExtendedKey,
+	utxo, path string) (*sweep.AnchorFeeInput, error) {
+
+	outpoint, err := lnd.ParseOutpoint(utxo)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing fee UTXO: %w", err)
+	}
+	tx, err := api.Transaction(outpoint.Hash.String())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching TX of fee UTXO: %w",
+			err)
+	}
+	if int(outpoint.Index) >= len(tx.Vout) {
+		return nil, fmt.Errorf("fee UTXO %v does not exist", outpoint)
+	}
+	vout := tx.Vout[outpoint.Index]
+	if vout.Outspend != nil && vout.Outspend.Spent {
+		return nil, fmt.Errorf("fee UTXO %v was already spent by %s",
+			outpoint, vout.Outspend.Txid)
+	}
+	pkScript, err := hex.DecodeString(vout.ScriptPubkey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding fee UTXO script: %w",
+			err)
+	}
+
+	parsedPath, err := lnd.ParsePath(path)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing fee UTXO path: %w", err)
+	}
+	key, err := lnd.DeriveFromRoot(extendedKey, parsedPath)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving fee UTXO key: %w", err)
+	}
+	privKey, err := key.ECPrivKey()
+	if err != nil {
+		return nil, fmt.Errorf("error deriving fee UTXO key: %w", err)
+	}
+
+	return &sweep.AnchorFeeInput{
+		Outpoint: *outpoint,
+		Utxo: &wire.TxOut{
+			Value:    int64(vout.Value),
+			PkScript: pkScript,
+		},
+		PrivKey: privKey,
+	}, nil
+}
+
+// addAnchorCPFP creates an anchor CPFP child transaction for the given signed
+// commitment transaction and adds both transactions as a package to the
+// results.
+func addAnchorCPFP(api btc.SweepAPI, signer *lnd.Signer,
+	channel *channeldb.OpenChannel, commitTx *wire.MsgTx,
+	commitInputs []*txResultInput, cpfp *forceCloseCPFP, publish bool,
+	results *txResults) error {
+
+	channelPoint := channel.FundingOutpoint.String()
+	if !channel.ChanType.HasAnchors() {
+		return fmt.Errorf("channel %s has no anchor outputs, CPFP is "+
+			"not possible", channelPoint)
+	}
+
+	commitFee := int64(channel.Capacity)
+	for _, txOut := range commitTx.TxOut {
+		commitFee -= txOut.Value
+	}
+	childTx, err := sweep.AnchorCPFP(
+		signer, commitTx, commitFee, &channel.LocalChanCfg.MultiSigKey,
+		cpfp.feeInput, cpfp.feeRate, log,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating CPFP TX for channel %s: %w",
+			channelPoint, err)
+	}
+
+	anchorOutpoint := childTx.TxIn[0].PreviousOutPoint
+	childInputs := []*txResultInput{{
+		Outpoint:     anchorOutpoint.String(),
+		Value:        commitTx.TxOut[anchorOutpoint.Index].Value,
+		ChannelPoint: channelPoint,
+	}, {
+		Outpoint: cpfp.feeInput.Outpoint.String(),
+		Value:    cpfp.feeInput.Utxo.Value,
+	}}
+
+	return results.addPackage(
+		api, []*wire.MsgTx{commitTx, childTx},
+		[][]*txResultInput{commitInputs, childInputs}, publish,
+	)
+}
+
+// channelDBEntries returns the channels of the given channel DB as summary
+// entries. Channels whose funding output was already spent are marked as
+// closed, channels whose funding transaction was never published are left
+// out.
+func channelDBEntries(api btc.ChainAPI,
+	chanDb *channeldb.ChannelStateDB) ([]*dataformat.SummaryEntry, error) {
+
+	dbFile := &dataformat.ChannelDBFile{DB: chanDb}
+	allEntries, err := dbFile.AsSummaryEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*dataformat.SummaryEntry, 0, len(allEntries))
+	for _, entry := range allEntries {
+		tx, err := api.Transaction(entry.FundingTXID)
+		switch {
+		case errors.Is(err, btc.ErrTxNotFound):
+			log.Infof("Funding TX of channel %s not found, it was "+
+				"never published", entry.ChannelPoint)
+			continue
+
+		case err != nil:
+			return nil, fmt.Errorf("error fetching funding TX of "+
+				"channel %s: %w", entry.ChannelPoint, err)
+		}
+
+		if int(entry.FundingTXIndex) >= len(tx.Vout) {
+			return nil, fmt.Errorf("funding output %s does not "+
+				"exist", entry.ChannelPoint)
+		}
+		outspend := tx.Vout[entry.FundingTXIndex].Outspend
+		if outspend != nil && outspend.Spent {
+			log.Infof("Channel %s was already closed by %s",
+				entry.ChannelPoint, outspend.Txid)
+			entry.ClosingTX = &dataformat.ClosingTX{
+				TXID: outspend.Txid,
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// filterChannelPoint returns only the entry of the channel with the given
+// channel point.
+func filterChannelPoint(entries []*dataformat.SummaryEntry,
+	channelPoint string) ([]*dataformat.SummaryEntry, error) {
+
+	for _, entry := range entries {
+		if entry.ChannelPoint == channelPoint {
+			return []*dataformat.SummaryEntry{entry}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("channel %s not found in channel input",
+		channelPoint)
+}
+
+// localForceClose signs the latest local commitment transaction of the given
+// channel and collects all information that is needed to sweep the time
+// locked to_local output after it confirmed.
+func localForceClose(channel *channeldb.OpenChannel,
+	signer *lnd.Signer) (*dataformat.ForceClose, *wire.MsgTx, error) {
+
+	localCommit := channel.LocalCommitment
+	localCommitTx := localCommit.CommitTx
+
+	// Create signed transaction.
+	lc := &lnd.LightningChannel{
+		LocalChanCfg:  channel.LocalChanCfg,
+		RemoteChanCfg: channel.RemoteChanCfg,
+		ChannelState:  channel,
+		TXSigner:      signer,
+	}
+	err := lc.CreateSignDesc()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Serialize transaction.
+	signedTx, err := lc.SignedCommitTx()
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	err = signedTx.Serialize(io.Writer(&buf))
+	if err != nil {
+		return nil, nil, err
+	}
+	hash := signedTx.TxHash()
+	serialized := hex.EncodeToString(buf.Bytes())
+
+	// Calculate commit point.
+	basepoint := channel.LocalChanCfg.DelayBasePoint
+	revpoint := channel.RemoteChanCfg.RevocationBasePoint
+	revocationPreimage, err := channel.RevocationProducer.AtIndex(
+		localCommit.CommitHeight,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	point := input.ComputeCommitmentPoint(revocationPreimage[:])
+
+	forceClose := &dataformat.ForceClose{
+		TXID:       hash.String(),
+		Serialized: serialized,
+		DelayBasePoint: &dataformat.BasePoint{
+			Family: uint16(basepoint.Family),
+			Index:  basepoint.Index,
+			PubKey: hex.EncodeToString(
+				basepoint.PubKey.SerializeCompressed(),
+			),
+		},
+		RevocationBasePoint: &dataformat.BasePoint{
+			PubKey: hex.EncodeToString(
+				revpoint.PubKey.SerializeCompressed(),
+			),
+		},
+		CommitPoint: hex.EncodeToString(
+			point.SerializeCompressed(),
+		),
+		Outs: make(
+			[]*dataformat.Out, len(localCommitTx.TxOut),
+		),
+		CSVDelay: channel.LocalChanCfg.CsvDelay,
+	}
+	for idx, out := range localCommitTx.TxOut {
+		script, err := txscript.DisasmString(out.PkScript)
+		if err != nil {
+			return nil, nil, err
+		}
+		forceClose.Outs[idx] = &dataformat.Out{
+			Script:    hex.EncodeToString(out.PkScript),
+			ScriptAsm: script,
+			Value:     uint64(out.Value),
+		}
+	}
+
+	return forceClose, signedTx, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForceCloseFromChannelDB(t *testing.T) {
+	h := newHarness(t)
+
+	db, err := lnd.OpenDB(h.testdataFile("channel.db"), true)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	chanDb := db.ChannelStateDB()
+
+	channels, err := chanDb.FetchAllChannels()
+	require.NoError(t, err)
+	require.Len(t, channels, 4)
+
+	// The funding output of the first channel is still unspent, the one
+	// of the second channel was spent and the funding TX of the third
+	// channel was never published.
+	api := &mockChainAPI{txs: make(map[string]*btc.TX)}
+	addFundingTx := func(chanIdx int, spentBy string) {
+		chanPoint := channels[chanIdx].FundingOutpoint
+		vouts := make([]*btc.Vout, chanPoint.Index+1)
+		for idx := range vouts {
+			vouts[idx] = &btc.Vout{Outspend: &btc.Outspend{
+				Spent: spentBy != "",
+				Txid:  spentBy,
+			}}
+		}
+		api.txs[chanPoint.Hash.String()] = &btc.TX{Vout: vouts}
+	}
+	addFundingTx(0, "")
+	addFundingTx(1, "cafe")
+	addFundingTx(3, "")
+
+	entries, err := channelDBEntries(api, chanDb)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Nil(t, entries[0].ClosingTX)
+	require.Equal(t, "cafe", entries[1].ClosingTX.TXID)
+	h.assertLogContains("never published")
+
+	// Only the still open channels are force closed. The witness of each
+	// commitment TX is verified before it is returned.
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	results := newTxResults()
+	err = forceCloseChannels(
+		nil, extendedKey, entries, chanDb, false, nil, results,
+	)
+	require.NoError(t, err)
+	require.Len(t, results.Transactions, 2)
+	require.NotNil(t, entries[0].ForceClose)
+	require.Nil(t, entries[1].ForceClose)
+	require.Equal(
+		t, channels[0].LocalCommitment.CommitTx.TxHash().String(),
+		entries[0].ForceClose.TXID,
+	)
+
+	// A single channel can be selected.
+	single, err := filterChannelPoint(
+		entries, channels[3].FundingOutpoint.String(),
+	)
+	require.NoError(t, err)
+	require.Len(t, single, 1)
+	_, err = filterChannelPoint(
+		entries, channels[2].FundingOutpoint.String(),
+	)
+	require.ErrorContains(t, err, "not found")
+
+	// The commitment can't be signed with the wrong seed.
+	wrongKey, err := hdkeychain.NewKeyFromString(rootKeyBip39)
+	require.NoError(t, err)
+	err = forceCloseChannels(
+		nil, wrongKey, single, chanDb, false, nil,
+		newTxResults(),
+	)
+	require.ErrorContains(t, err, "wrong seed?")
+}
+
+// fakePackageRelay is a fake chain backend that supports package relay.
+type fakePackageRelay struct {
+	*fakeRegtest
+
+	packages [][]string
+}
+
+func (f *fakePackageRelay) SubmitPackage(rawTxHexes []string) (string,
+	error) {
+
+	f.packages = append(f.packages, rawTxHexes)
+	return "success", nil
+}
+
+// fakeMinRelayFee is a fake chain backend that rejects all transactions
+// because they don't pay the minimum relay fee.
+type fakeMinRelayFee struct {
+	btc.SweepAPI
+}
+
+func (f *fakeMinRelayFee) PublishTx(string) (string, error) {
+	return "", errors.New("min relay fee not met")
+}
+
+func TestForceCloseAnchorCPFP(t *testing.T) {
+	h := newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	// The fee input is a P2WKH output of the wallet.
+	feeInput, err := cpfpFeeInput(
+		&mockChainAPI{}, extendedKey, "", "m/84'/1'/0'/0/0",
+	)
+	require.Nil(t, feeInput)
+	require.ErrorContains(t, err, "required for CPFP")
+
+	feeTx := wire.NewMsgTx(2)
+	feeTx.AddTxIn(&wire.TxIn{})
+	feeKey, err := lnd.DeriveChildren(extendedKey, []uint32{
+		lnd.HardenedKey(84), lnd.HardenedKey(1), lnd.HardenedKey(0), 0,
+		0,
+	})
+	require.NoError(t, err)
+	feePubKey, err := feeKey.ECPubKey()
+	require.NoError(t, err)
+	feeScript, err := input.WitnessPubKeyHash(
+		feePubKey.SerializeCompressed(),
+	)
+	require.NoError(t, err)
+	feeTx.AddTxOut(&wire.TxOut{Value: 50_000, PkScript: feeScript})
+	api := &mockChainAPI{txs: map[string]*btc.TX{
+		feeTx.TxHash().String(): {Vout: []*btc.Vout{{
+			ScriptPubkey: hex.EncodeToString(feeScript),
+			Value:        50_000,
+		}}},
+	}}
+	feeInput, err = cpfpFeeInput(
+		api, extendedKey, feeTx.TxHash().String()+":0",
+		"m/84'/1'/0'/0/0",
+	)
+	require.NoError(t, err)
+
+	// The commitment TX of an anchor channel with a very low fee.
+	fundingKey, err := signer.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyMultiSig,
+		Index:  3,
+	})
+	require.NoError(t, err)
+	anchorScript, err := input.CommitScriptAnchor(fundingKey.PubKey)
+	require.NoError(t, err)
+	anchorPkScript, err := input.WitnessScriptHash(anchorScript)
+	require.NoError(t, err)
+	commitTx := wire.NewMsgTx(2)
+	commitTx.AddTxIn(&wire.TxIn{})
+	commitTx.AddTxOut(&wire.TxOut{Value: 99_470, PkScript: []byte{0x51}})
+	commitTx.AddTxOut(&wire.TxOut{Value: 330, PkScript: anchorPkScript})
+	channel := &channeldb.OpenChannel{
+		ChanType: channeldb.SingleFunderTweaklessBit |
+			channeldb.AnchorOutputsBit,
+		Capacity: 100_000,
+		LocalChanCfg: channeldb.ChannelConfig{
+			MultiSigKey: *fundingKey,
+		},
+	}
+	commitInputs := []*txResultInput{{Value: 100_000}}
+	cpfp := &forceCloseCPFP{feeRate: 20, feeInput: feeInput}
+
+	// With package relay, both TXs are submitted together.
+	relay := &fakePackageRelay{fakeRegtest: newFakeRegtest("regtest")}
+	results := newTxResults()
+	err = addAnchorCPFP(
+		relay, signer, channel, commitTx, commitInputs, cpfp, true,
+		results,
+	)
+	require.NoError(t, err)
+	require.Len(t, relay.packages, 1)
+	require.Len(t, results.Transactions, 2)
+	require.True(t, results.Transactions[1].Published)
+
+	// The child spends the anchor and the fee input correctly and the
+	// package pays the requested fee rate.
+	rawChild, err := hex.DecodeString(relay.packages[0][1])
+	require.NoError(t, err)
+	childTx := &wire.MsgTx{}
+	require.NoError(t, childTx.Deserialize(bytes.NewReader(rawChild)))
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	prevOutFetcher.AddPrevOut(
+		childTx.TxIn[0].PreviousOutPoint, commitTx.TxOut[1],
+	)
+	prevOutFetcher.AddPrevOut(feeInput.Outpoint, feeInput.Utxo)
+	sigHashes := txscript.NewTxSigHashes(childTx, prevOutFetcher)
+	for idx, txIn := range childTx.TxIn {
+		prevOut := prevOutFetcher.FetchPrevOutput(txIn.PreviousOutPoint)
+		vm, err := txscript.NewEngine(
+			prevOut.PkScript, childTx, idx,
+			txscript.StandardVerifyFlags, nil, sigHashes,
+			prevOut.Value, prevOutFetcher,
+		)
+		require.NoError(t, err)
+		require.NoError(t, vm.Execute())
+	}
+	require.Equal(t, feeScript, childTx.TxOut[0].PkScript)
+
+	packageFee := *results.Transactions[0].Fee +
+		*results.Transactions[1].Fee
+	packageVSize := results.Transactions[0].VSize +
+		results.Transactions[1].VSize
+	require.GreaterOrEqual(t, packageFee, 20*packageVSize)
+	require.Less(t, packageFee, 21*packageVSize)
+
+	// Without package relay, the commitment TX is published on its own
+	// first.
+	err = addAnchorCPFP(
+		&fakeMinRelayFee{}, signer, channel, commitTx, commitInputs,
+		cpfp, true, newTxResults(),
+	)
+	require.ErrorContains(t, err, "doesn't support package relay")
+
+	// Channels without anchors can't be bumped.
+	channel.ChanType = channeldb.SingleFunderTweaklessBit
+	err = addAnchorCPFP(
+		relay, signer, channel, commitTx, commitInputs, cpfp, true,
+		newTxResults(),
+	)
+	require.ErrorContains(t, err, "has no anchor outputs")
+	h.assertLogContains("CPFP fee")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/guggero/chantools/btc"
+	"github.com/spf13/cobra"
+)
+
+type genDescriptorsCommand struct {
+	RecoveryWindow uint32
+	Stdout         bool
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newGenDescriptorsCommand() *cobra.Command {
+	cc := &genDescriptorsCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "gendescriptors",
+		Short: "Generate the watch-only output descriptors of an lnd " +
+			"wallet for Bitcoin Core",
+		Long: `Generates the ranged output descriptors of all on-chain
+accounts of lnd's internal wallet (np2wkh, p2wkh and p2tr, m/49', m/84' and
+m/86') with their checksums. Only the extended public keys of the accounts are
+exported, so the result can be imported into a Bitcoin Core watch-only wallet
+(created with disable_private_keys=true) to track all on-chain funds of a node
+that can't be started anymore.
+
+The result is a JSON array that can directly be passed to the importdescriptors
+RPC. If the lnd 24 word aezeed is entered, the wallet birthday is used as the
+rescan timestamp, otherwise the whole chain is rescanned.
+
+NOTE: Bitcoin Core v22.0 or later is required for the p2tr descriptors. Funds
+in channels or in keys of the m/1017' branch are not covered.`,
+		Example: `chantools gendescriptors --recoverywindow 5000 \
+	--stdout > descriptors.json
+
+bitcoin-cli -rpcwallet=lnd-watchonly importdescriptors \
+	"$(cat descriptors.json)"`,
+		RunE: cc.Execute,
+		Annotations: map[string]string{
+			annotationJSONFlags: "stdout=true",
+		},
+	}
+	cc.cmd.Flags().Uint32Var(
+		&cc.RecoveryWindow, "recoverywindow", defaultRecoveryWindow,
+		"number of keys to watch per internal/external branch",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Stdout, "stdout", false, "write the descriptors to "+
+			"standard out instead of writing them to a file",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "deriving the account keys")
+
+	return cc.cmd
+}
+
+func (c *genDescriptorsCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, birthday, err := c.rootKey.readWithBirthday()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	// The btcwallet gives the birthday a slack of 48 hours, let's do the
+	// same. If we don't know the birthday, the timestamp is zero which
+	// means the whole chain is rescanned.
+	if birthday.Unix() > 0 {
+		birthday = birthday.Add(-48 * time.Hour)
+	}
+
+	descriptors, err := btc.WatchOnlyDescriptors(
+		extendedKey, chainParams, c.RecoveryWindow, birthday,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating descriptors: %w", err)
+	}
+
+	jsonBytes, err := json.MarshalIndent(descriptors, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding descriptors: %w", err)
+	}
+
+	// For the tests, also log as trace level which is disabled by default.
+	log.Tracef(string(jsonBytes))
+
+	if !c.Stdout {
+		fileName := resultFileName("gendescriptors", "json")
+		log.Infof("Writing descriptors to %s", fileName)
+
+		return os.WriteFile(fileName, jsonBytes, 0644)
+	}
+
+	_, err = fmt.Fprintln(resultOut, string(jsonBytes))
+	return err
+}
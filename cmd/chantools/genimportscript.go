@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"time"
@@ -52,21 +53,39 @@ The following script formats are currently supported:
   bitcoind's importwallet command.
 * electrum: Creates a text output that contains one private key per line with
   the address type as the prefix, the way Electrum expects them.
+* electrum-masterkey: Creates a text output that contains the extended private
+  and public keys of the np2wkh and p2wkh accounts (m/49' and m/84') in the
+  format Electrum expects when restoring a wallet from a master key. The public
+  keys can be used for a watch-only wallet. The --lndpaths, --derivationpath
+  and --recoverywindow flags are ignored for this format.
 * bitcoin-descriptors: Create a list of bitcoin-cli importdescriptors commands
   that can be used in combination with a bitcoind full node that has a
   descriptor wallet to recover the funds locked in those private keys.
-  NOTE: This will only work for descriptor wallets and only for
-  p2sh-segwit, bech32 and bech32m (np2wkh, p2wkh and p2tr) addresses.`,
+  NOTE: This will only work for descriptor wallets and only for legacy,
+  p2sh-segwit, bech32 and bech32m (p2pkh, np2wkh, p2wkh and p2tr) addresses.
+* descriptors: Creates the JSON payload for a single importdescriptors call
+  that contains the ranged descriptors (with the extended private keys) of the
+  external and internal branch of the p2pkh, np2wkh, p2wkh and p2tr accounts
+  (m/44', m/49', m/84' and m/86'), including the np2wkh change addresses of old
+  lnd versions. The range is set by --recoverywindow, the timestamp is the
+  wallet birthday if the lnd 24 word aezeed is entered or zero (rescan the
+  whole chain) otherwise. The --lndpaths, --derivationpath and --rescanfrom
+  flags are ignored for this format.`,
 		Example: `chantools genimportscript --format bitcoin-cli \
-	--recoverywindow 5000`,
+	--recoverywindow 5000
+
+chantools genimportscript --format electrum-masterkey --stdout
+
+chantools genimportscript --format descriptors --stdout > descriptors.json
+bitcoin-cli importdescriptors "$(cat descriptors.json)"`,
 		RunE: cc.Execute,
 	}
 	cc.cmd.Flags().StringVar(
 		&cc.Format, "format", "bitcoin-importwallet", "format of the "+
 			"generated import script; currently supported are: "+
 			"bitcoin-importwallet, bitcoin-cli, "+
-			"bitcoin-cli-watchonly, bitcoin-descriptors and "+
-			"electrum",
+			"bitcoin-cli-watchonly, bitcoin-descriptors, "+
+			"descriptors, electrum and electrum-masterkey",
 	)
 	cc.cmd.Flags().BoolVar(
 		&cc.LndPaths, "lndpaths", false, "use all derivation paths "+
@@ -111,11 +130,10 @@ func (c *genImportScriptCommand) Execute(_ *cobra.Command, _ []string) error {
 	}
 
 	// The btcwallet gives the birthday a slack of 48 hours, let's do the
-	// same.
-	if !birthday.IsZero() {
-		c.RescanFrom = btc.SeedBirthdayToBlock(
-			chainParams, birthday.Add(-48*time.Hour),
-		)
+	// same. If we don't know the birthday, it's set to the unix epoch.
+	if birthday.Unix() > 0 {
+		birthday = birthday.Add(-48 * time.Hour)
+		c.RescanFrom = btc.SeedBirthdayToBlock(chainParams, birthday)
 	}
 
 	// Set default values.
@@ -155,10 +173,9 @@ func (c *genImportScriptCommand) Execute(_ *cobra.Command, _ []string) error {
 		}
 	}
 
-	writer := os.Stdout
+	writer := resultOut
 	if !c.Stdout {
-		fileName := fmt.Sprintf("results/genimportscript-%s.txt",
-			time.Now().Format("2006-01-02-15-04-05"))
+		fileName := resultFileName("genimportscript", "txt")
 		log.Infof("Writing import script with format '%s' to %s",
 			c.Format, fileName)
 
@@ -170,6 +187,30 @@ func (c *genImportScriptCommand) Execute(_ *cobra.Command, _ []string) error {
 		}
 	}
 
+	// The master key and descriptor formats don't export the individual
+	// keys but the extended keys of the wallet accounts.
+	switch c.Format {
+	case btc.FormatElectrumMasterKey:
+		return btc.ExportElectrumMasterKeys(
+			extendedKey, chainParams, writer,
+		)
+
+	case btc.FormatImportDescriptors:
+		descriptors, err := btc.PrivateDescriptors(
+			extendedKey, chainParams, c.RecoveryWindow, birthday,
+		)
+		if err != nil {
+			return fmt.Errorf("error creating descriptors: %w", err)
+		}
+
+		jsonBytes, err := json.MarshalIndent(descriptors, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding descriptors: %w", err)
+		}
+		_, err = fmt.Fprintln(writer, string(jsonBytes))
+		return err
+	}
+
 	exporter, err := btc.ParseFormat(c.Format)
 	if err != nil {
 		return fmt.Errorf("error parsing format: %w", err)
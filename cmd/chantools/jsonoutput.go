@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/spf13/cobra"
+)
+
+// annotationJSONFlags is the cobra command annotation that lists the flags
+// that are set if the global --json flag is used, as comma separated
+// flag=value pairs. This is used by commands that can already write a machine
+// readable result.
+const annotationJSONFlags = "json_flags"
+
+var (
+	// JSONOutput indicates that the result of a command should be written
+	// to stdout as JSON instead of only being logged.
+	JSONOutput bool
+
+	// DryRun indicates that no transaction should be published, even if
+	// a command is asked to. A decoded preview of each transaction is
+	// printed instead. Commands that modify a database only show the
+	// changes they would make.
+	DryRun bool
+
+	// stdoutReserved is set if the command writes its result to stdout,
+	// so all other output needs to go to stderr.
+	stdoutReserved bool
+)
+
+// applyJSONFlags sets the flags the command lists in its annotationJSONFlags
+// annotation if the global --json flag is used.
+func applyJSONFlags(cmd *cobra.Command) error {
+	jsonFlags, ok := cmd.Annotations[annotationJSONFlags]
+	if !JSONOutput || !ok {
+		return nil
+	}
+
+	for _, pair := range strings.Split(jsonFlags, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid JSON flag annotation %s",
+				pair)
+		}
+
+		name, value := parts[0], parts[1]
+		f := cmd.Flags().Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown JSON flag %s", name)
+		}
+
+		if f.Changed && f.Value.String() != value {
+			return fmt.Errorf("--json cannot be used together "+
+				"with --%s=%s", name, f.Value.String())
+		}
+		if err := cmd.Flags().Set(name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// textOut returns the writer the human readable output of a command is written
+// to. If stdout is reserved for the result of the command (for example with
+// the global --json flag), the text goes to stderr instead.
+func textOut() io.Writer {
+	if JSONOutput || stdoutReserved {
+		return os.Stderr
+	}
+
+	return os.Stdout
+}
+
+// printJSON writes the given value as indented JSON to the result output if
+// the global --json flag is used.
+func printJSON(v interface{}) error {
+	if !JSONOutput {
+		return nil
+	}
+
+	jsonBytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding JSON: %w", err)
+	}
+
+	_, err = fmt.Fprintln(resultOut, string(jsonBytes))
+	return err
+}
+
+// txResultInput is an input of a transaction created by chantools.
+type txResultInput struct {
+	Outpoint     string `json:"outpoint"`
+	Value        int64  `json:"value,omitempty"`
+	ChannelPoint string `json:"channel_point,omitempty"`
+	Addr         string `json:"addr,omitempty"`
+}
+
+// txResultOutput is an output of a transaction created by chantools.
+type txResultOutput struct {
+	Value int64  `json:"value"`
+	Addr  string `json:"addr"`
+}
+
+// txResult is a transaction created by chantools.
+type txResult struct {
+	TxID            string            `json:"txid"`
+	RawTx           string            `json:"raw_tx"`
+	Fee             *int64            `json:"fee,omitempty"`
+	FeeRate         float64           `json:"fee_rate,omitempty"`
+	Weight          int64             `json:"weight"`
+	VSize           int64             `json:"vsize"`
+	Inputs          []*txResultInput  `json:"inputs"`
+	Outputs         []*txResultOutput `json:"outputs"`
+	Published       bool              `json:"published"`
+	PublishResponse string            `json:"publish_response,omitempty"`
+}
+
+// skippedChannel is a channel that was not included in a transaction.
+type skippedChannel struct {
+	ChannelPoint string `json:"channel_point"`
+	Reason       string `json:"reason"`
+}
+
+// txResults is the JSON result of all commands that create transactions.
+type txResults struct {
+	Transactions    []*txResult       `json:"transactions"`
+	SkippedChannels []*skippedChannel `json:"skipped_channels,omitempty"`
+}
+
+// newTxResults creates an empty result.
+func newTxResults() *txResults {
+	return &txResults{
+		Transactions: make([]*txResult, 0),
+	}
+}
+
+// skip adds a channel that was not included in a transaction to the result.
+func (r *txResults) skip(channelPoint, reason string) {
+	r.SkippedChannels = append(r.SkippedChannels, &skippedChannel{
+		ChannelPoint: channelPoint,
+		Reason:       reason,
+	})
+}
+
+// addTx logs and optionally publishes the given transaction and adds it to the
+// result. The inputs must describe the inputs of the transaction in the same
+// order. If they are nil, only the outpoints are added and the fee is unknown.
+// With the global --dryrun flag, the transaction is never published and a
+// decoded preview is printed instead.
+func (r *txResults) addTx(api btc.SweepAPI, tx *wire.MsgTx,
+	inputs []*txResultInput, publish bool) error {
+
+	result, err := newTxResult(tx, inputs)
+	if err != nil {
+		return err
+	}
+
+	if DryRun {
+		publish = false
+		if !JSONOutput {
+			printTxPreview(result)
+		}
+	}
+
+	// Publish TX.
+	if publish {
+		if err := publishTxResult(api, result); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Transaction: %s", result.RawTx)
+	r.Transactions = append(r.Transactions, result)
+
+	return nil
+}
+
+// addPackage logs and optionally publishes the given package of a parent
+// transaction and its child and adds them to the result, the same way addTx
+// does for a single transaction. If the chain backend supports package relay,
+// both transactions are submitted as one package. Otherwise they are published
+// one after the other, which only works if the parent pays the minimum relay
+// fee on its own.
+func (r *txResults) addPackage(api btc.SweepAPI, txs []*wire.MsgTx,
+	inputs [][]*txResultInput, publish bool) error {
+
+	results := make([]*txResult, len(txs))
+	for idx, tx := range txs {
+		result, err := newTxResult(tx, inputs[idx])
+		if err != nil {
+			return err
+		}
+		results[idx] = result
+
+		if DryRun && !JSONOutput {
+			printTxPreview(result)
+		}
+	}
+	if DryRun {
+		publish = false
+	}
+
+	packageAPI, supportsPackages := api.(btc.PackageAPI)
+	switch {
+	case publish && supportsPackages:
+		rawTxs := make([]string, len(results))
+		for idx, result := range results {
+			rawTxs[idx] = result.RawTx
+		}
+		response, err := packageAPI.SubmitPackage(rawTxs)
+		if err != nil {
+			return fmt.Errorf("error submitting package: %w", err)
+		}
+		log.Infof("Published package of %d TXs, response: %s",
+			len(results), response)
+
+		for _, result := range results {
+			result.Published = true
+			result.PublishResponse = response
+		}
+
+	case publish:
+		for _, result := range results {
+			err := publishTxResult(api, result)
+			if err != nil {
+				return fmt.Errorf("error publishing TX %s, the "+
+					"chain backend doesn't support package "+
+					"relay, try --bitcoindrpc: %w",
+					result.TxID, err)
+			}
+		}
+	}
+
+	for _, result := range results {
+		log.Infof("Transaction: %s", result.RawTx)
+		r.Transactions = append(r.Transactions, result)
+	}
+
+	return nil
+}
+
+// publishTxResult publishes the raw transaction of the given result and marks
+// it as published.
+func publishTxResult(api btc.SweepAPI, result *txResult) error {
+	response, err := api.PublishTx(result.RawTx)
+	if err != nil {
+		return err
+	}
+	log.Infof("Published TX %s, response: %s", result.TxID, response)
+
+	result.Published = true
+	result.PublishResponse = response
+
+	return nil
+}
+
+// newTxResult creates the result of the given transaction. The inputs must
+// describe the inputs of the transaction in the same order. If they are nil,
+// only the outpoints are added and the fee is unknown.
+func newTxResult(tx *wire.MsgTx, inputs []*txResultInput) (*txResult,
+	error) {
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return nil, err
+	}
+
+	weight := int64(tx.SerializeSizeStripped()*3 + tx.SerializeSize())
+	result := &txResult{
+		TxID:    tx.TxHash().String(),
+		RawTx:   hex.EncodeToString(buf.Bytes()),
+		Weight:  weight,
+		VSize:   (weight + 3) / 4,
+		Inputs:  inputs,
+		Outputs: make([]*txResultOutput, len(tx.TxOut)),
+	}
+
+	for idx, txOut := range tx.TxOut {
+		addr := "unknown"
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(
+			txOut.PkScript, chainParams,
+		)
+		if err == nil && len(addrs) == 1 {
+			addr = addrs[0].EncodeAddress()
+		}
+		result.Outputs[idx] = &txResultOutput{
+			Value: txOut.Value,
+			Addr:  addr,
+		}
+	}
+
+	if inputs == nil {
+		for _, txIn := range tx.TxIn {
+			result.Inputs = append(result.Inputs, &txResultInput{
+				Outpoint: txIn.PreviousOutPoint.String(),
+			})
+		}
+	} else {
+		fee := int64(0)
+		for _, in := range inputs {
+			fee += in.Value
+		}
+		for _, out := range tx.TxOut {
+			fee -= out.Value
+		}
+		result.Fee = &fee
+		result.FeeRate = float64(fee) / float64(result.VSize)
+	}
+
+	return result, nil
+}
+
+// printTxPreview prints a decoded view of the transaction so it can be checked
+// before it is published.
+func printTxPreview(result *txResult) {
+	fmt.Printf("\nTransaction preview (dry run, not published):\n"+
+		"TXID: %s\nInputs:\n", result.TxID)
+	for _, in := range result.Inputs {
+		fmt.Printf("  %s", in.Outpoint)
+		if in.Value != 0 {
+			fmt.Printf(", %d sats", in.Value)
+		}
+		if in.ChannelPoint != "" {
+			fmt.Printf(", channel %s", in.ChannelPoint)
+		}
+		if in.Addr != "" {
+			fmt.Printf(", address %s", in.Addr)
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("Outputs:\n")
+	for idx, out := range result.Outputs {
+		fmt.Printf("  %d: %d sats to %s\n", idx, out.Value, out.Addr)
+	}
+
+	if result.Fee != nil {
+		fmt.Printf("Fee: %d sats (%.2f sat/vByte)\n", *result.Fee,
+			result.FeeRate)
+	} else {
+		fmt.Printf("Fee: unknown\n")
+	}
+	fmt.Printf("Weight: %d WU (%d vBytes)\n\n", result.Weight,
+		result.VSize)
+}
+
+// txSpends returns true if the transaction spends the given outpoint.
+func txSpends(tx *wire.MsgTx, outpoint wire.OutPoint) bool {
+	for _, txIn := range tx.TxIn {
+		if txIn.PreviousOutPoint == outpoint {
+			return true
+		}
+	}
+
+	return false
+}
+
+// print writes the result to stdout if the global --json flag is used.
+func (r *txResults) print() error {
+	return printJSON(r)
+}
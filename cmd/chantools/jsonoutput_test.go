@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyJSONFlags(t *testing.T) {
+	var (
+		format string
+		stdout bool
+	)
+	newCmd := func() *cobra.Command {
+		cmd := &cobra.Command{
+			Use: "summary",
+			Annotations: map[string]string{
+				annotationJSONFlags: "format=json,stdout=true",
+			},
+		}
+		cmd.Flags().StringVar(&format, "format", "csv", "")
+		cmd.Flags().BoolVar(&stdout, "stdout", false, "")
+		return cmd
+	}
+
+	JSONOutput = true
+	defer func() { JSONOutput = false }()
+
+	cmd := newCmd()
+	require.NoError(t, cmd.ParseFlags(nil))
+	require.NoError(t, applyJSONFlags(cmd))
+	require.Equal(t, "json", format)
+	require.True(t, stdout)
+	require.True(t, resultToStdout(cmd))
+
+	cmd = newCmd()
+	require.NoError(t, cmd.ParseFlags([]string{"--format", "csv"}))
+	require.ErrorContains(
+		t, applyJSONFlags(cmd), "--json cannot be used together with "+
+			"--format=csv",
+	)
+}
+
+func TestTxResults(t *testing.T) {
+	h := newHarness(t)
+
+	out, err := os.Create(h.tempFile("result.json"))
+	require.NoError(t, err)
+	resultOut = out
+	JSONOutput = true
+	defer func() {
+		resultOut = os.Stdout
+		JSONOutput = false
+	}()
+
+	tx := wire.NewMsgTx(2)
+	tx.TxIn = []*wire.TxIn{{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}},
+	}, {
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{2}},
+	}}
+	tx.TxOut = []*wire.TxOut{{Value: 1_500}}
+
+	results := newTxResults()
+	results.skip("chan:1", "all spent")
+	err = results.addTx(nil, tx, []*txResultInput{{
+		Outpoint: tx.TxIn[0].PreviousOutPoint.String(),
+		Value:    1_000,
+	}, {
+		Outpoint: tx.TxIn[1].PreviousOutPoint.String(),
+		Value:    700,
+	}}, false)
+	require.NoError(t, err)
+	require.NoError(t, results.addTx(nil, tx, nil, false))
+	require.NoError(t, results.print())
+
+	h.assertLogContains("Transaction: 02000000")
+
+	require.NoError(t, out.Close())
+	content, err := ioutil.ReadFile(out.Name())
+	require.NoError(t, err)
+
+	var decoded txResults
+	require.NoError(t, json.Unmarshal(content, &decoded))
+	require.Len(t, decoded.Transactions, 2)
+	require.Equal(t, tx.TxHash().String(), decoded.Transactions[0].TxID)
+	require.EqualValues(t, 200, *decoded.Transactions[0].Fee)
+	require.EqualValues(
+		t, 4*tx.SerializeSize(), decoded.Transactions[0].Weight,
+	)
+	require.EqualValues(
+		t, tx.SerializeSize(), decoded.Transactions[0].VSize,
+	)
+	require.Equal(t, []*txResultOutput{{
+		Value: 1_500,
+		Addr:  "unknown",
+	}}, decoded.Transactions[0].Outputs)
+	require.False(t, decoded.Transactions[0].Published)
+	require.Len(t, decoded.Transactions[1].Inputs, 2)
+	require.Nil(t, decoded.Transactions[1].Fee)
+	require.Equal(t, []*skippedChannel{{
+		ChannelPoint: "chan:1",
+		Reason:       "all spent",
+	}}, decoded.SkippedChannels)
+}
+
+func TestTxResultsDryRun(t *testing.T) {
+	_ = newHarness(t)
+
+	DryRun = true
+	defer func() { DryRun = false }()
+
+	tx := wire.NewMsgTx(2)
+	tx.TxIn = []*wire.TxIn{{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}},
+	}}
+	tx.TxOut = []*wire.TxOut{{Value: 900}}
+
+	// The API is nil, so publishing would panic.
+	results := newTxResults()
+	err := results.addTx(nil, tx, []*txResultInput{{
+		Outpoint: tx.TxIn[0].PreviousOutPoint.String(),
+		Value:    1_000,
+	}}, true)
+	require.NoError(t, err)
+	require.False(t, results.Transactions[0].Published)
+	require.EqualValues(t, 100, *results.Transactions[0].Fee)
+	require.InDelta(
+		t, 100/float64(tx.SerializeSize()),
+		results.Transactions[0].FeeRate, 0.001,
+	)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/spf13/cobra"
+)
+
+type mergeBackupsCommand struct {
+	Output string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newMergeBackupsCommand() *cobra.Command {
+	cc := &mergeBackupsCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "mergebackups backup1 backup2 [backup...]",
+		Short: "Merge multiple channel.backup files into a single " +
+			"one",
+		Long: `This command decrypts all given lnd channel.backup files
+(which must all be encrypted with the same root key) and writes all channels
+found in them into a single new channel.backup file.
+
+If a channel is contained in multiple files, the version from the most recently
+modified file is kept. This can be used to combine backup snapshots from
+different dates or machines before restoring them.`,
+		Example: `chantools mergebackups \
+	old-machine/channel.backup new-machine/channel.backup \
+	--output merged.backup`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Output, "output", "", "the merged channel backup file to "+
+			"create; if empty, merged-<timestamp>.backup in the "+
+			"working directory is used",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "decrypting the backups")
+
+	return cc.cmd
+}
+
+func (c *mergeBackupsCommand) Execute(_ *cobra.Command, args []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	if c.Output == "" {
+		c.Output = resultFileName("merged", "backup")
+	}
+
+	return mergeChannelBackups(args, keyRing, c.Output)
+}
+
+// mergeChannelBackups combines the channels of all given backup files. The
+// files are processed from the oldest to the most recently modified one, so
+// later versions of a channel replace earlier ones.
+func mergeChannelBackups(fileNames []string, ring keychain.KeyRing,
+	outputFile string) error {
+
+	type backupFile struct {
+		name    string
+		modTime time.Time
+	}
+	files := make([]backupFile, len(fileNames))
+	for idx, fileName := range fileNames {
+		info, err := os.Stat(fileName)
+		if err != nil {
+			return fmt.Errorf("error reading backup file %s: %w",
+				fileName, err)
+		}
+		files[idx] = backupFile{name: fileName, modTime: info.ModTime()}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	var (
+		singles []chanbackup.Single
+		index   = make(map[string]int)
+	)
+	for _, file := range files {
+		multi, err := chanbackup.NewMultiFile(file.name).ExtractMulti(
+			ring,
+		)
+		if err != nil {
+			return fmt.Errorf("could not extract multi file %s: %w",
+				file.name, err)
+		}
+		log.Infof("Read %d channels from %s", len(multi.StaticBackups),
+			file.name)
+
+		for _, single := range multi.StaticBackups {
+			chanPoint := single.FundingOutpoint.String()
+			if idx, ok := index[chanPoint]; ok {
+				log.Debugf("Replacing channel %s with version "+
+					"from %s", chanPoint, file.name)
+				singles[idx] = single
+				continue
+			}
+
+			index[chanPoint] = len(singles)
+			singles = append(singles, single)
+		}
+	}
+
+	log.Infof("Writing %d channels to %s", len(singles), outputFile)
+	return writeBackups(
+		singles, ring, chanbackup.NewMultiFile(outputFile),
+	)
+}
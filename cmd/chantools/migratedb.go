@@ -2,13 +2,17 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/spf13/cobra"
 )
 
 type migrateDBCommand struct {
 	ChannelDB string
+	DestDB    string
+	InPlace   bool
 
 	cmd *cobra.Command
 }
@@ -18,35 +22,129 @@ func newMigrateDBCommand() *cobra.Command {
 	cc.cmd = &cobra.Command{
 		Use:   "migratedb",
 		Short: "Apply all recent lnd channel database migrations",
-		Long: `This command opens an lnd channel database in write mode
-and applies all recent database migrations to it. This can be used to update
-an old database file to be compatible with the current version that chantools
-needs to read the database content.
-
-CAUTION: Running this command will make it impossible to use the channel DB
-with an older version of lnd. Downgrading is not possible and you'll need to
-run lnd v0.16.0-beta or later after using this command!'`,
+		Long: `This command applies all lnd channel database migrations
+that are required to bring an old database (created by lnd v0.8 or even older)
+up to the version that chantools and lnd v0.16.0-beta need to read it.
+
+By default, the database is first copied to the file specified with --destdb
+and the migrations are applied to that copy, one by one, in a single database
+transaction. The original file is not modified. The database version before
+and after and every migration that is applied is logged. Use --inplace to
+migrate the given database file directly instead, which is the only option for
+the SQLite and Postgres database backends.
+
+CAUTION: Running this command will make it impossible to use the migrated
+channel DB with an older version of lnd. Downgrading is not possible and you'll
+need to run lnd v0.16.0-beta or later after using this command!'`,
 		Example: `chantools migratedb \
-	--channeldb ~/.lnd/data/graph/mainnet/channel.db`,
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--destdb ./results/migrated.db`,
 		RunE: cc.Execute,
 	}
 	cc.cmd.Flags().StringVar(
 		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to "+
 			"migrate",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.DestDB, "destdb", "", "new lnd channel.db file to copy "+
+			"the database to before migrating it; if empty, "+
+			"migrated-<timestamp>.db in the working directory is "+
+			"used",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.InPlace, "inplace", false, "apply the migrations to the "+
+			"given channel DB directly instead of a copy",
+	)
 
 	return cc.cmd
 }
 
 func (c *migrateDBCommand) Execute(_ *cobra.Command, _ []string) error {
 	// Check that we have a channel DB.
-	if c.ChannelDB == "" {
+	if !channelDBGiven(c.ChannelDB) {
 		return fmt.Errorf("channel DB is required")
 	}
-	db, err := lnd.OpenDB(c.ChannelDB, false)
+
+	dbPath := c.ChannelDB
+	if !c.InPlace {
+		if err := c.copyDB(); err != nil {
+			return err
+		}
+		dbPath = c.DestDB
+	}
+
+	// Find out what version the DB currently is at, without applying any
+	// migrations yet.
+	db, err := openChannelDB(dbPath, true)
 	if err != nil {
 		return fmt.Errorf("error opening DB: %w", err)
 	}
+	meta, err := db.FetchMeta()
+	_ = db.Close()
+	if err != nil {
+		return fmt.Errorf("error reading DB version: %w", err)
+	}
+
+	latestVersion := channeldb.LatestDBVersion()
+	log.Infof("DB is at version %d, latest version is %d",
+		meta.DbVersionNumber, latestVersion)
+
+	switch {
+	case meta.DbVersionNumber == latestVersion:
+		log.Infof("DB is already up to date, no migration needed")
+		return nil
+
+	case meta.DbVersionNumber > latestVersion:
+		return fmt.Errorf("DB version %d is newer than the latest "+
+			"version %d known to chantools", meta.DbVersionNumber,
+			latestVersion)
+	}
+
+	// Opening the DB in write mode applies all migrations, each one is
+	// logged by the channeldb sub logger.
+	db, err = openChannelDB(dbPath, false)
+	if err != nil {
+		return fmt.Errorf("error migrating DB: %w", err)
+	}
+
+	log.Infof("Successfully migrated DB from version %d to %d",
+		meta.DbVersionNumber, latestVersion)
 
 	return db.Close()
 }
+
+// copyDB creates a copy of the source bolt DB that can be migrated without
+// touching the original file.
+func (c *migrateDBCommand) copyDB() error {
+	if dbConfig.Backend != "" && dbConfig.Backend != lnd.DBBackendBolt {
+		return fmt.Errorf("copying the DB is only supported for the "+
+			"%s backend, use --inplace instead", dbConfig.Backend)
+	}
+	if c.DestDB == "" {
+		c.DestDB = resultFileName("migrated", "db")
+	}
+	if _, err := os.Stat(c.DestDB); err == nil {
+		return fmt.Errorf("destination channel DB %s already exists",
+			c.DestDB)
+	}
+
+	compact := &compactDBCommand{TxMaxSize: defaultTxMaxSize}
+	src, err := compact.openDB(c.ChannelDB, true)
+	if err != nil {
+		return fmt.Errorf("error opening source DB: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+
+	dst, err := compact.openDB(c.DestDB, false)
+	if err != nil {
+		return fmt.Errorf("error opening destination DB: %w", err)
+	}
+	defer func() { _ = dst.Close() }()
+
+	log.Infof("Copying %s to %s", c.ChannelDB, c.DestDB)
+	if err := compact.compact(dst, src); err != nil {
+		return fmt.Errorf("error copying DB: %w", err)
+	}
+
+	return nil
+}
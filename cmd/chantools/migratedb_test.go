@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateDBCopy(t *testing.T) {
+	h := newHarness(t)
+
+	// Migrate a copy of the test DB.
+	migrate := &migrateDBCommand{
+		ChannelDB: h.testdataFile("channel.db"),
+		DestDB:    h.tempFile("migrated.db"),
+	}
+
+	err := migrate.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains("Successfully migrated DB")
+
+	// The copy must be at the latest version, the original must not have
+	// been touched.
+	assertDBVersion := func(path string, expected bool) {
+		db, err := openChannelDB(path, true)
+		require.NoError(t, err)
+		defer func() { _ = db.Close() }()
+
+		meta, err := db.FetchMeta()
+		require.NoError(t, err)
+		require.Equal(
+			t, expected,
+			meta.DbVersionNumber == channeldb.LatestDBVersion(),
+		)
+	}
+	assertDBVersion(migrate.DestDB, true)
+	assertDBVersion(migrate.ChannelDB, false)
+
+	// Migrating to an existing file must fail.
+	err = migrate.Execute(nil, nil)
+	require.ErrorContains(t, err, "already exists")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/guggero/chantools/btc"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultMonitorInterval = 10 * time.Minute
+)
+
+type monitorCommand struct {
+	SummaryFile string
+	Interval    time.Duration
+	Webhook     string
+
+	chainAPI *chainAPIFlags
+	cmd      *cobra.Command
+}
+
+func newMonitorCommand() *cobra.Command {
+	cc := &monitorCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "monitor",
+		Short: "Continuously monitor the unspent outputs of closed " +
+			"channels",
+		Long: `Reads a channel summary file created by the summary
+command and periodically checks all unspent outputs of closed channels. An
+alert is logged (and optionally sent to a webhook) whenever one of the
+following happens:
+  spendable: an output that is potentially ours can be swept
+  matured:   the CSV delay of our to_local output expired
+  spent:     an output was spent (by us or by someone else)
+
+If a webhook URL is given, each alert is sent to it as a JSON encoded HTTP POST
+request. The command runs until all outputs are spent or it is interrupted.`,
+		Example: `chantools monitor \
+	--summaryfile results/summary-xxxx-xx-xx.json \
+	--interval 30m \
+	--webhook https://example.com/chantools-alert`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.SummaryFile, "summaryfile", "", "the JSON summary file "+
+			"that contains the closed channels to monitor",
+	)
+	cc.cmd.Flags().DurationVar(
+		&cc.Interval, "interval", defaultMonitorInterval, "the "+
+			"interval in which the outputs are checked",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Webhook, "webhook", "", "optional URL to send each alert "+
+			"to as a JSON encoded HTTP POST request",
+	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
+
+	return cc.cmd
+}
+
+func (c *monitorCommand) Execute(_ *cobra.Command, _ []string) error {
+	if c.SummaryFile == "" {
+		return fmt.Errorf("summary file is required")
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	summaryFile, err := readSummaryFile(c.SummaryFile)
+	if err != nil {
+		return err
+	}
+
+	monitor := btc.NewOutputMonitor(c.chainAPI.api(), summaryFile.Channels)
+	log.Infof("Monitoring %d closed channels with unspent outputs every "+
+		"%v", monitor.NumChannels(), c.Interval)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		events, err := monitor.Check()
+		if err != nil {
+			// A failed check is not fatal, the API might just be
+			// temporarily unavailable.
+			log.Errorf("Error checking outputs: %v", err)
+		}
+
+		for _, event := range events {
+			log.Infof("ALERT: %v", event)
+
+			if err := c.sendWebhook(event); err != nil {
+				log.Errorf("Error sending alert to webhook: %v",
+					err)
+			}
+		}
+
+		if monitor.NumChannels() == 0 {
+			log.Infof("All monitored outputs are spent, exiting.")
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+
+		case <-interrupt:
+			log.Infof("Received interrupt, exiting.")
+			return nil
+		}
+	}
+}
+
+// sendWebhook sends the event as a JSON encoded POST request to the webhook,
+// if one is configured.
+func (c *monitorCommand) sendWebhook(event *btc.MonitorEvent) error {
+	if c.Webhook == "" {
+		return nil
+	}
+
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(
+		c.Webhook, "application/json", bytes.NewReader(eventBytes),
+	)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+
+	return nil
+}
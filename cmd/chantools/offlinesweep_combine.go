@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/sweep"
+	"github.com/spf13/cobra"
+)
+
+type offlineSweepCombineCommand struct {
+	Psbt    string
+	Publish bool
+
+	explorerAPI *explorerAPIFlags
+	cmd         *cobra.Command
+}
+
+func newOfflineSweepCombineCommand() *cobra.Command {
+	cc := &offlineSweepCombineCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "combine",
+		Short: "[3/3] Combine the signatures of a signed sweep PSBT " +
+			"into the final transaction and publish it",
+		Long: `Add the signatures of a PSBT that was signed with
+'offlinesweep sign' (or by a hardware wallet) to the witnesses of the sweep
+transaction, verify them and optionally publish the final transaction.`,
+		Example: `chantools offlinesweep combine \
+	--psbt <signed_psbt_base64> \
+	--publish`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Psbt, "psbt", "", "the base64 encoded signed PSBT",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Publish, "publish", false, "publish sweep TX to the chain "+
+			"API instead of just printing the TX",
+	)
+	cc.explorerAPI = newExplorerAPIFlags(cc.cmd)
+
+	return cc.cmd
+}
+
+func (c *offlineSweepCombineCommand) Execute(_ *cobra.Command,
+	_ []string) error {
+
+	packet, _, err := btc.DecodePsbt(c.Psbt)
+	if err != nil {
+		return fmt.Errorf("error decoding PSBT: %w", err)
+	}
+
+	sweepTx, err := sweep.CombinePsbt(packet)
+	if err != nil {
+		return err
+	}
+
+	inputs := make([]*txResultInput, len(packet.Inputs))
+	for idx, pIn := range packet.Inputs {
+		inputs[idx] = &txResultInput{
+			Outpoint: sweepTx.TxIn[idx].PreviousOutPoint.String(),
+			Value:    pIn.WitnessUtxo.Value,
+		}
+	}
+
+	results := newTxResults()
+	err = results.addTx(
+		c.explorerAPI.api(), sweepTx, inputs, c.Publish,
+	)
+	if err != nil {
+		return err
+	}
+
+	return results.print()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/spf13/cobra"
+)
+
+type offlineSweepCreateUnsignedCommand struct {
+	AccountXPub    string
+	Fingerprint    string
+	RecoveryWindow uint32
+	SweepAddr      string
+	FeeRate        uint16
+
+	explorerAPI *explorerAPIFlags
+	cmd         *cobra.Command
+}
+
+func newOfflineSweepCreateUnsignedCommand() *cobra.Command {
+	cc := &offlineSweepCreateUnsignedCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "createunsigned",
+		Short: "[1/3] Create the unsigned PSBT that sweeps the " +
+			"funds of channels that were force-closed by the " +
+			"remote party",
+		Long: `Go through all the addresses that could have funds of
+channels that were force-closed by the remote party, exactly like
+sweepremoteclosed does, and create an unsigned PSBT that sweeps all funds found
+to the given address.
+
+Instead of the seed, only the extended public key of the payment base key
+family account (m/1017'/<coin_type>'/3') is needed. It can be derived on the
+offline machine with:
+  chantools derivekey --path "m/1017'/0'/3'" --neuter`,
+		Example: `chantools offlinesweep createunsigned \
+	--accountxpub xpub... \
+	--feerate 10 \
+	--sweepaddr bc1q.....`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.AccountXPub, "accountxpub", "", "the extended public key "+
+			"of the payment base key family account "+
+			"(m/1017'/<coin_type>'/3')",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Fingerprint, "fingerprint", "00000000", "hex encoded "+
+			"master key fingerprint to add to the BIP32 "+
+			"derivations; only needed if the PSBT should also be "+
+			"signed by a hardware wallet",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.RecoveryWindow, "recoverywindow",
+		sweepRemoteClosedDefaultRecoveryWindow, "number of keys to "+
+			"scan per derivation path",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SweepAddr, "sweepaddr", "", "address to sweep the funds to",
+	)
+	cc.cmd.Flags().Uint16Var(
+		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
+			"use for the sweep transaction in sat/vByte",
+	)
+	cc.explorerAPI = newExplorerAPIFlags(cc.cmd)
+
+	return cc.cmd
+}
+
+func (c *offlineSweepCreateUnsignedCommand) Execute(_ *cobra.Command,
+	_ []string) error {
+
+	if c.SweepAddr == "" {
+		return fmt.Errorf("sweep addr is required")
+	}
+
+	accountKey, err := lnd.ParseAccountXPub(c.AccountXPub)
+	if err != nil {
+		return err
+	}
+	familyIndex := lnd.HardenedKey(uint32(keychain.KeyFamilyPaymentBase))
+	if accountKey.ChildIndex() != familyIndex {
+		return fmt.Errorf("account key must be the one of the payment "+
+			"base key family (m/1017'/%d'/%d')",
+			chainParams.HDCoinType, keychain.KeyFamilyPaymentBase)
+	}
+
+	hw := &hwSigner{
+		ExportPsbt:  true,
+		Fingerprint: c.Fingerprint,
+		offline:     true,
+	}
+	signer, err := hw.wrap(&lnd.Signer{
+		ExtendedKey: accountKey.ExtendedKey,
+		ChainParams: chainParams,
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.RecoveryWindow == 0 {
+		c.RecoveryWindow = sweepRemoteClosedDefaultRecoveryWindow
+	}
+	c.FeeRate, err = sweepFeeRate(
+		c.cmd, c.explorerAPI.sweepAPI(), c.FeeRate,
+	)
+	if err != nil {
+		return err
+	}
+
+	return sweepRemoteClosed(
+		signer, c.explorerAPI.api(), c.SweepAddr, c.RecoveryWindow,
+		c.FeeRate, false, hw,
+	)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+type offlineSweepCommand struct {
+	cmd *cobra.Command
+}
+
+func newOfflineSweepCommand() *cobra.Command {
+	cc := &offlineSweepCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "offlinesweep",
+		Short: "Sweep funds with the seed only ever being entered on " +
+			"an offline (air-gapped) machine",
+		Long: `A sub command that hosts a set of further sub commands
+to sweep funds without the seed ever touching a machine that is online.
+
+The sweep is split into three steps:
+  1. The online machine looks up the outputs to sweep and creates an unsigned
+     PSBT that contains everything needed to sign it (createunsigned). Only
+     the extended public key of the key family account is needed for that.
+  2. The offline machine signs the PSBT with the seed (sign).
+  3. The online machine combines the signatures into the final transaction
+     and publishes it (combine).
+
+The PSBTs exported with --hwexport by the other sweep commands (for example
+sweeptimelock or sweepbreach) can also be signed and combined with the sign
+and combine steps. Creating those requires the seed, unless the extended
+public keys of the key families are given with --hwxpub.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				_ = cmd.Help()
+				os.Exit(0)
+			}
+		},
+	}
+
+	cobra.EnableCommandSorting = false
+	cc.cmd.AddCommand(
+		// Here the order matters, we don't want them to be
+		// alphabetically sorted but by step number.
+		newOfflineSweepCreateUnsignedCommand(),
+		newOfflineSweepSignCommand(),
+		newOfflineSweepCombineCommand(),
+	)
+
+	return cc.cmd
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/spf13/cobra"
+)
+
+type offlineSweepSignCommand struct {
+	Psbt string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newOfflineSweepSignCommand() *cobra.Command {
+	cc := &offlineSweepSignCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "sign",
+		Short: "[2/3] Sign an unsigned sweep PSBT on the offline " +
+			"machine",
+		Long: `Sign all inputs of a PSBT that was created with
+'offlinesweep createunsigned' (or --hwexport of another sweep command) with the
+seed. This command doesn't need any network access.
+
+Make sure the outputs and the fee shown are what you expect before copying the
+signed PSBT back to the online machine.`,
+		Example: `chantools offlinesweep sign \
+	--psbt <unsigned_psbt_base64>`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Psbt, "psbt", "", "the base64 encoded unsigned PSBT",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "signing the PSBT")
+
+	return cc.cmd
+}
+
+func (c *offlineSweepSignCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	packet, version, err := btc.DecodePsbt(c.Psbt)
+	if err != nil {
+		return fmt.Errorf("error decoding PSBT: %w", err)
+	}
+
+	signedPsbt, err := signOfflineSweep(&lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}, packet, version)
+	if err != nil {
+		return err
+	}
+
+	if JSONOutput {
+		return printJSON(&psbtResult{Psbt: signedPsbt})
+	}
+
+	fmt.Fprintf(textOut(), "Signed PSBT, combine and publish it on the "+
+		"online machine with\n'chantools offlinesweep "+
+		"combine':\n\n%s\n\n", signedPsbt)
+
+	return nil
+}
+
+// signOfflineSweep signs all inputs of the sweep PSBT, prints a summary of the
+// transaction and returns the signed PSBT in the same version as the input.
+func signOfflineSweep(signer *lnd.Signer, packet *psbt.Packet,
+	version uint32) (string, error) {
+
+	signed, err := signer.SignPsbt(packet)
+	if err != nil {
+		return "", err
+	}
+	if len(signed) == 0 {
+		return "", fmt.Errorf("PSBT has no inputs to sign")
+	}
+
+	var totalIn, totalOut int64
+	for _, pIn := range packet.Inputs {
+		totalIn += pIn.WitnessUtxo.Value
+	}
+	fmt.Fprintf(textOut(), "Signed %d of %d inputs with a total value "+
+		"of %d sats.\n", len(signed), len(packet.Inputs), totalIn)
+	for _, txOut := range packet.UnsignedTx.TxOut {
+		totalOut += txOut.Value
+
+		addr := "unknown"
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(
+			txOut.PkScript, chainParams,
+		)
+		if err == nil && len(addrs) == 1 {
+			addr = addrs[0].EncodeAddress()
+		}
+		fmt.Fprintf(textOut(), "Output: %d sats to %s\n", txOut.Value,
+			addr)
+	}
+	fmt.Fprintf(
+		textOut(), "Fee: %v\n\n", btcutil.Amount(totalIn-totalOut),
+	)
+
+	return btc.EncodePsbt(packet, version)
+}
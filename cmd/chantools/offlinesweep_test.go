@@ -0,0 +1,203 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/sweep"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineSweep(t *testing.T) {
+	h := newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	offlineSigner := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	// The online machine only knows the extended public key of the
+	// payment base account.
+	accountKey, err := lnd.DeriveChildren(extendedKey, []uint32{
+		lnd.HardenedKey(uint32(keychain.BIP0043Purpose)),
+		lnd.HardenedKey(chainParams.HDCoinType),
+		lnd.HardenedKey(uint32(keychain.KeyFamilyPaymentBase)),
+	})
+	require.NoError(t, err)
+	_, err = lnd.ParseAccountXPub(accountKey.String())
+	require.ErrorContains(t, err, "must be an extended public key")
+	accountXPub, err := accountKey.Neuter()
+	require.NoError(t, err)
+	parsedXPub, err := lnd.ParseAccountXPub(accountXPub.String())
+	require.NoError(t, err)
+
+	hw := &hwSigner{ExportPsbt: true, Fingerprint: "00000000"}
+	onlineSigner, err := hw.wrap(&lnd.Signer{
+		ExtendedKey: parsedXPub.ExtendedKey,
+		ChainParams: chainParams,
+	})
+	require.NoError(t, err)
+
+	deriveKey := func(index uint32) *keychain.KeyDescriptor {
+		keyLoc := keychain.KeyLocator{
+			Family: keychain.KeyFamilyPaymentBase,
+			Index:  index,
+		}
+		keyDesc, err := onlineSigner.DeriveKey(keyLoc)
+		require.NoError(t, err)
+
+		offlineKeyDesc, err := offlineSigner.DeriveKey(keyLoc)
+		require.NoError(t, err)
+		require.Equal(t, offlineKeyDesc.PubKey, keyDesc.PubKey)
+
+		return keyDesc
+	}
+
+	// We sweep all three types of to_remote outputs: A P2WKH output of a
+	// static remote key channel, a P2WSH output of an anchor channel and
+	// a P2TR output of a simple taproot channel.
+	p2wkhKey := deriveKey(0)
+	p2wkhAddr, err := lnd.P2WKHAddr(p2wkhKey.PubKey, chainParams)
+	require.NoError(t, err)
+	p2wkhScript, err := txscript.PayToAddrScript(p2wkhAddr)
+	require.NoError(t, err)
+
+	anchorKey := deriveKey(1)
+	anchorAddr, anchorScript, err := lnd.P2AnchorStaticRemote(
+		anchorKey.PubKey, chainParams,
+	)
+	require.NoError(t, err)
+	anchorPkScript, err := txscript.PayToAddrScript(anchorAddr)
+	require.NoError(t, err)
+
+	taprootKey := deriveKey(2)
+	taprootAddr, taprootScript, controlBlock, err :=
+		lnd.P2TaprootStaticRemote(taprootKey.PubKey, chainParams)
+	require.NoError(t, err)
+	taprootPkScript, err := txscript.PayToAddrScript(taprootAddr)
+	require.NoError(t, err)
+
+	prevOuts := []*wire.TxOut{
+		{Value: 100_000, PkScript: p2wkhScript},
+		{Value: 200_000, PkScript: anchorPkScript},
+		{Value: 300_000, PkScript: taprootPkScript},
+	}
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	sweepTx := wire.NewMsgTx(2)
+	for idx, prevOut := range prevOuts {
+		outpoint := wire.OutPoint{
+			Hash:  chainhash.Hash{byte(idx + 1)},
+			Index: uint32(idx),
+		}
+		sweepTx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: outpoint,
+			Sequence:         1,
+		})
+		prevOutFetcher.AddPrevOut(outpoint, prevOut)
+	}
+	sweepTx.AddTxOut(&wire.TxOut{Value: 590_000, PkScript: p2wkhScript})
+
+	sigHashes := txscript.NewTxSigHashes(sweepTx, prevOutFetcher)
+	signDesc := func(idx int, keyDesc *keychain.KeyDescriptor,
+		script []byte) *input.SignDescriptor {
+
+		return &input.SignDescriptor{
+			KeyDesc:           *keyDesc,
+			WitnessScript:     script,
+			Output:            prevOuts[idx],
+			HashType:          txscript.SigHashAll,
+			SigHashes:         sigHashes,
+			PrevOutputFetcher: prevOutFetcher,
+			InputIndex:        idx,
+		}
+	}
+
+	// Step 1: The online machine builds the transaction with placeholder
+	// signatures and creates the PSBT.
+	witness, err := input.CommitSpendNoDelay(
+		onlineSigner, signDesc(0, p2wkhKey, p2wkhScript), sweepTx, true,
+	)
+	require.NoError(t, err)
+	sweepTx.TxIn[0].Witness = witness
+
+	witness, err = input.CommitSpendToRemoteConfirmed(
+		onlineSigner, signDesc(1, anchorKey, anchorScript), sweepTx,
+	)
+	require.NoError(t, err)
+	sweepTx.TxIn[1].Witness = witness
+
+	taprootDesc := signDesc(2, taprootKey, taprootScript)
+	taprootDesc.HashType = txscript.SigHashDefault
+	taprootDesc.SignMethod = input.TaprootScriptSpendSignMethod
+	witness, err = lnd.TaprootScriptSpendWitness(
+		onlineSigner, taprootDesc, sweepTx, controlBlock,
+	)
+	require.NoError(t, err)
+	sweepTx.TxIn[2].Witness = witness
+
+	require.NoError(t, hw.signer.AddWitnessTemplates(sweepTx))
+	packet, err := hw.signer.Packet()
+	require.NoError(t, err)
+	packet = roundTripPSBT(t, packet)
+	unsignedPsbt, err := btc.EncodePsbt(packet, btc.PsbtVersion0)
+	require.NoError(t, err)
+
+	// The unsigned PSBT can't be combined yet.
+	_, err = sweep.CombinePsbt(roundTripPSBT(t, packet))
+	require.ErrorContains(t, err, "must have exactly one signature")
+
+	// Step 2: The offline machine signs the PSBT. A wrong seed is
+	// detected.
+	wrongKey, err := hdkeychain.NewKeyFromString(rootKeyBip39)
+	require.NoError(t, err)
+	_, err = (&lnd.Signer{
+		ExtendedKey: wrongKey,
+		ChainParams: chainParams,
+	}).SignPsbt(roundTripPSBT(t, packet))
+	require.ErrorContains(t, err, "wrong seed?")
+
+	signedPsbt, err := signOfflineSweep(
+		offlineSigner, packet, btc.PsbtVersion2,
+	)
+	require.NoError(t, err)
+	signedPacket, _, err := btc.DecodePsbt(signedPsbt)
+	require.NoError(t, err)
+
+	// Step 3: The online machine combines the signatures. The script
+	// execution of all inputs is verified.
+	finalTx, err := sweep.CombinePsbt(signedPacket)
+	require.NoError(t, err)
+	require.Equal(t, sweepTx.TxHash(), finalTx.TxHash())
+	require.Len(t, finalTx.TxIn[2].Witness, 3)
+	require.Equal(t, controlBlock, finalTx.TxIn[2].Witness[2])
+
+	fee := btcutil.Amount(600_000 - finalTx.TxOut[0].Value)
+	require.Equal(t, btcutil.Amount(10_000), fee)
+
+	// With --json, the summary of the transaction doesn't end up on
+	// stdout.
+	h.captureJSON()
+	sign := &offlineSweepSignCommand{
+		Psbt:    unsignedPsbt,
+		rootKey: &rootKey{RootKey: rootKeyAezeed},
+	}
+	require.NoError(t, sign.Execute(nil, nil))
+
+	var result psbtResult
+	h.assertJSONOutput(&result)
+	jsonPacket, _, err := btc.DecodePsbt(result.Psbt)
+	require.NoError(t, err)
+	jsonTx, err := sweep.CombinePsbt(jsonPacket)
+	require.NoError(t, err)
+	require.Equal(t, finalTx.TxHash(), jsonTx.TxHash())
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/rescue"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/spf13/cobra"
+)
+
+type recoverCommand struct {
+	ChannelDB   string
+	SweepAddr   string
+	MaxCsvLimit uint16
+	FeeRate     uint16
+	Publish     bool
+
+	CommitPointRange uint64
+	NumKeys          int
+
+	chainAPI *chainAPIFlags
+	rootKey  *rootKey
+	inputs   *inputFlags
+	cmd      *cobra.Command
+}
+
+func newRecoverCommand() *cobra.Command {
+	cc := &recoverCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "recover",
+		Short: "Run summary, rescueclosed and sweeptimelock in one " +
+			"go and write a combined report",
+		Long: `Chains the most common recovery workflow into a single
+command so the result files don't need to be passed from one command to the
+next manually:
+
+1. The state of all channels is looked up on chain, just like the summary
+   command does.
+2. For channels that were force closed by the remote peer, the private key of
+   our to_remote output is searched, just like the rescueclosed command does.
+3. For channels that were force closed by us with the latest local commitment
+   in the channel DB, the time locked to_local outputs of which the CSV delay
+   has already expired are swept, just like the sweeptimelock command does.
+   Outputs that are still time locked are listed with the block height at which
+   they can be swept.
+
+The channels are read from the channel DB, unless one of the channel input
+flags is given. The combined report is written to recover-<timestamp>.json in
+the working directory.`,
+		Example: `chantools recover \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--sweepaddr bc1q..... \
+	--feerate 10 \
+	--publish
+
+lncli listchannels | chantools recover --listchannels - \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--sweepaddr bc1q.....`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to read "+
+			"the channels, commit points and commitment "+
+			"transactions from",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SweepAddr, "sweepaddr", "", "address to sweep the funds "+
+			"of the time locked outputs to",
+	)
+	cc.cmd.Flags().Uint16Var(
+		&cc.MaxCsvLimit, "maxcsvlimit", defaultCsvLimit, "maximum CSV "+
+			"limit to use",
+	)
+	cc.cmd.Flags().Uint16Var(
+		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
+			"use for the sweep transaction in sat/vByte",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Publish, "publish", false, "publish sweep TX to the chain "+
+			"API instead of just printing the TX",
+	)
+	cc.cmd.Flags().Uint64Var(
+		&cc.CommitPointRange, "commit_point_range", 0, "number of "+
+			"previous remote states to derive commit points for "+
+			"from the remote revocation secrets in the channel DB",
+	)
+	cc.cmd.Flags().IntVar(
+		&cc.NumKeys, "num_keys", defaultRescueNumKeys, "the number "+
+			"of keys to derive for the brute force attack",
+	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
+	cc.rootKey = newRootKey(cc.cmd, "deriving keys")
+	cc.inputs = newInputFlags(cc.cmd)
+
+	return cc.cmd
+}
+
+func (c *recoverCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	if !channelDBGiven(c.ChannelDB) {
+		return fmt.Errorf("channel DB is required")
+	}
+	if c.SweepAddr == "" {
+		return fmt.Errorf("sweep addr is required")
+	}
+
+	db, err := openChannelDB(c.ChannelDB, true)
+	if err != nil {
+		return fmt.Errorf("error opening channel DB: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	// Without any channel input, we use all channels of the DB.
+	var entries []*dataformat.SummaryEntry
+	if c.inputs.empty() {
+		entries, err = allEntriesFromDB(db.ChannelStateDB())
+	} else {
+		entries, err = c.inputs.parseInputType()
+	}
+	if err != nil {
+		return err
+	}
+
+	api := c.chainAPI.api()
+	c.FeeRate, err = sweepFeeRate(c.cmd, api, c.FeeRate)
+	if err != nil {
+		return err
+	}
+
+	report, err := recoverChannels(
+		api, extendedKey, db.ChannelStateDB(), entries, &recoverConfig{
+			sweepAddr:        c.SweepAddr,
+			maxCsvLimit:      c.MaxCsvLimit,
+			feeRate:          c.FeeRate,
+			publish:          c.Publish,
+			commitPointRange: c.CommitPointRange,
+			numKeys:          c.NumKeys,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	reportBytes, err := json.MarshalIndent(report, "", " ")
+	if err != nil {
+		return err
+	}
+	fileName := resultFileName("recover", "json")
+	log.Infof("Writing result to %s", fileName)
+	if err := ioutil.WriteFile(fileName, reportBytes, 0644); err != nil {
+		return err
+	}
+
+	return printJSON(report)
+}
+
+// recoverConfig holds the options of the sweep and rescue steps of the recover
+// command.
+type recoverConfig struct {
+	sweepAddr        string
+	maxCsvLimit      uint16
+	feeRate          uint16
+	publish          bool
+	commitPointRange uint64
+	numKeys          int
+}
+
+// recoverLocalClose is a channel that was force closed by us.
+type recoverLocalClose struct {
+	ChannelPoint   string `json:"channel_point"`
+	Value          uint64 `json:"value"`
+	CSVDelay       uint16 `json:"csv_delay"`
+	MaturityHeight uint32 `json:"maturity_height"`
+	Matured        bool   `json:"matured"`
+}
+
+// recoverRemoteClose is a channel that was force closed by the remote peer.
+type recoverRemoteClose struct {
+	ChannelPoint  string `json:"channel_point"`
+	Addr          string `json:"addr"`
+	KeyFound      bool   `json:"key_found"`
+	SweepPrivkey  string `json:"sweep_privkey,omitempty"`
+	ImportCommand string `json:"import_command,omitempty"`
+}
+
+// recoverReport is the combined result of all steps of the recover command.
+type recoverReport struct {
+	*txResults
+
+	Summary *dataformat.SummaryEntryFile `json:"summary"`
+
+	LocalForceCloses  []*recoverLocalClose  `json:"local_force_closes"`
+	RemoteForceCloses []*recoverRemoteClose `json:"remote_force_closes"`
+}
+
+// recoverChannels looks up the on-chain state of the given channels, searches
+// the keys of the to_remote outputs of remote force closes and sweeps the
+// matured to_local outputs of local force closes.
+func recoverChannels(api btc.SweepAPI, extendedKey *hdkeychain.ExtendedKey,
+	chanDb *channeldb.ChannelStateDB, entries []*dataformat.SummaryEntry,
+	cfg *recoverConfig) (*recoverReport, error) {
+
+	// Step 1: Find out the state of all channels.
+	summaryFile, err := btc.SummarizeChannels(
+		api, entries, defaultSummaryWorkers, nil, &btc.SummaryFilter{},
+		log,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error running summary: %w", err)
+	}
+	log.Infof("Found %d open and %d closed channels, %d of them force "+
+		"closed", summaryFile.OpenChannels, summaryFile.ClosedChannels,
+		summaryFile.ForceClosedChannels)
+
+	height, err := api.BlockHeight()
+	if err != nil {
+		return nil, fmt.Errorf("error querying block height: %w", err)
+	}
+
+	// A channel was force closed by us if the closing transaction is the
+	// latest local commitment of the channel DB. We need the same
+	// information as the forceclose command collects to sweep it.
+	err = addLocalForceCloses(extendedKey, chanDb, summaryFile.Channels)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &recoverReport{
+		txResults: newTxResults(),
+		Summary:   summaryFile,
+	}
+	localCloses, matured, remoteCloses := classifyForceCloses(
+		summaryFile.Channels, height,
+	)
+	report.LocalForceCloses = localCloses
+
+	// Step 2: Find the keys of our outputs in remote force closes.
+	if len(remoteCloses) > 0 {
+		report.RemoteForceCloses, err = recoverRemoteCloses(
+			extendedKey, chanDb, remoteCloses, cfg,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Step 3: Sweep the time locked outputs of our own force closes.
+	for _, localClose := range localCloses {
+		if !localClose.Matured {
+			log.Infof("Output of channel %s can be swept after "+
+				"block %d", localClose.ChannelPoint,
+				localClose.MaturityHeight)
+		}
+	}
+	if len(matured) > 0 {
+		signer := &lnd.Signer{
+			ExtendedKey: extendedKey,
+			ChainParams: chainParams,
+		}
+		err = sweepTimeLockFromSummary(
+			signer, api, matured, cfg.sweepAddr,
+			cfg.maxCsvLimit, cfg.publish, cfg.feeRate,
+			&hwSigner{}, report.txResults,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Infof("Recovery finished: %d local force closes (%d swept), %d "+
+		"remote force closes", len(localCloses), len(matured),
+		len(report.RemoteForceCloses))
+
+	return report, nil
+}
+
+// addLocalForceCloses adds the force close information to all entries that
+// were closed with the latest local commitment transaction in the channel DB.
+func addLocalForceCloses(extendedKey *hdkeychain.ExtendedKey,
+	chanDb *channeldb.ChannelStateDB,
+	entries []*dataformat.SummaryEntry) error {
+
+	channels, err := chanDb.FetchAllChannels()
+	if err != nil {
+		return err
+	}
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	byChannelPoint := make(map[string]*dataformat.SummaryEntry)
+	for _, entry := range entries {
+		byChannelPoint[entry.ChannelPoint] = entry
+	}
+	for _, channel := range channels {
+		entry := byChannelPoint[channel.FundingOutpoint.String()]
+		commitTx := channel.LocalCommitment.CommitTx
+		if entry == nil || entry.ClosingTX == nil ||
+			!entry.ClosingTX.ForceClose || commitTx == nil ||
+			entry.ClosingTX.TXID != commitTx.TxHash().String() {
+
+			continue
+		}
+
+		entry.ForceClose, _, err = localForceClose(channel, signer)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// classifyForceCloses sorts the force closed channels that still have unspent
+// outputs into the ones closed by us and the ones closed by the remote peer.
+// The entries of local force closes that can be swept at the next block are
+// returned separately.
+func classifyForceCloses(entries []*dataformat.SummaryEntry,
+	height uint32) ([]*recoverLocalClose, []*dataformat.SummaryEntry,
+	[]*dataformat.SummaryEntry) {
+
+	var (
+		localCloses  []*recoverLocalClose
+		matured      []*dataformat.SummaryEntry
+		remoteCloses []*dataformat.SummaryEntry
+	)
+	for _, entry := range entries {
+		if entry.ClosingTX == nil || !entry.ClosingTX.ForceClose ||
+			entry.ClosingTX.AllOutsSpent {
+
+			continue
+		}
+
+		if entry.ForceClose == nil {
+			remoteCloses = append(remoteCloses, entry)
+			continue
+		}
+
+		// The sweep transaction can be included in the next block if
+		// the commitment transaction has enough confirmations by then.
+		confHeight := entry.ClosingTX.ConfHeight
+		csvDelay := entry.ForceClose.CSVDelay
+		localClose := &recoverLocalClose{
+			ChannelPoint:   entry.ChannelPoint,
+			Value:          entry.LocalBalance,
+			CSVDelay:       csvDelay,
+			MaturityHeight: confHeight + uint32(csvDelay),
+		}
+		localClose.Matured = confHeight != 0 &&
+			height+1 >= localClose.MaturityHeight
+		localCloses = append(localCloses, localClose)
+
+		if localClose.Matured {
+			matured = append(matured, entry)
+		}
+	}
+
+	return localCloses, matured, remoteCloses
+}
+
+// recoverRemoteCloses tries to find the private keys of our to_remote outputs
+// in the given remote force closed channels.
+func recoverRemoteCloses(extendedKey *hdkeychain.ExtendedKey,
+	chanDb *channeldb.ChannelStateDB, entries []*dataformat.SummaryEntry,
+	cfg *recoverConfig) ([]*recoverRemoteClose, error) {
+
+	commitPoints, err := commitPointsFromDB(chanDb, cfg.commitPointRange)
+	if err != nil {
+		return nil, fmt.Errorf("error reading commit points from db: "+
+			"%w", err)
+	}
+
+	keyCache, err := rescue.NewKeyCache(
+		extendedKey, cfg.numKeys, chainParams, log,
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = keyCache.RescueChannels(entries, commitPoints)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*recoverRemoteClose, 0, len(entries))
+	for _, entry := range entries {
+		remoteClose := &recoverRemoteClose{
+			ChannelPoint: entry.ChannelPoint,
+			Addr:         entry.ClosingTX.OurAddr,
+			SweepPrivkey: entry.ClosingTX.SweepPrivkey,
+		}
+		if remoteClose.Addr == "" {
+			remoteClose.Addr = entry.ClosingTX.ToRemoteAddr
+		}
+
+		if remoteClose.SweepPrivkey == "" {
+			log.Warnf("No private key found for output %s of "+
+				"channel %s", remoteClose.Addr,
+				entry.ChannelPoint)
+			result = append(result, remoteClose)
+
+			continue
+		}
+
+		remoteClose.KeyFound = true
+		remoteClose.ImportCommand, err = rescue.ImportCommand(
+			remoteClose.Addr, remoteClose.SweepPrivkey, chainParams,
+		)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Found private key for output %s of channel %s, "+
+			"import it into bitcoind with: %s", remoteClose.Addr,
+			entry.ChannelPoint, remoteClose.ImportCommand)
+
+		result = append(result, remoteClose)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/guggero/chantools/dataformat"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyForceCloses(t *testing.T) {
+	forceClose := func(chanPoint string, confHeight uint32,
+		local bool) *dataformat.SummaryEntry {
+
+		entry := &dataformat.SummaryEntry{
+			ChannelPoint: chanPoint,
+			LocalBalance: 100_000,
+			ClosingTX: &dataformat.ClosingTX{
+				ForceClose: true,
+				ConfHeight: confHeight,
+			},
+		}
+		if local {
+			entry.ForceClose = &dataformat.ForceClose{
+				CSVDelay: 144,
+			}
+		}
+		return entry
+	}
+
+	spent := forceClose("spent:0", 100, true)
+	spent.ClosingTX.AllOutsSpent = true
+	coopClose := forceClose("coop:0", 100, false)
+	coopClose.ClosingTX.ForceClose = false
+
+	entries := []*dataformat.SummaryEntry{
+		{ChannelPoint: "open:0"},
+		coopClose,
+		spent,
+		forceClose("matured:0", 856, true),
+		forceClose("locked:0", 857, true),
+		forceClose("unconfirmed:0", 0, true),
+		forceClose("remote:0", 500, false),
+	}
+
+	localCloses, matured, remoteCloses := classifyForceCloses(entries, 999)
+	require.Equal(t, []*recoverLocalClose{{
+		ChannelPoint:   "matured:0",
+		Value:          100_000,
+		CSVDelay:       144,
+		MaturityHeight: 1_000,
+		Matured:        true,
+	}, {
+		ChannelPoint:   "locked:0",
+		Value:          100_000,
+		CSVDelay:       144,
+		MaturityHeight: 1_001,
+	}, {
+		ChannelPoint:   "unconfirmed:0",
+		Value:          100_000,
+		CSVDelay:       144,
+		MaturityHeight: 144,
+	}}, localCloses)
+	require.Equal(t, []*dataformat.SummaryEntry{entries[3]}, matured)
+	require.Equal(t, []*dataformat.SummaryEntry{entries[6]}, remoteCloses)
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// loopKeyFamily is the key family the Loop client derives its HTLC
+	// keys from.
+	loopKeyFamily = 99
+
+	defaultLoopNumKeys = 2000
+
+	// loopInWaitInterval is the interval in which we check the block
+	// height when waiting for the HTLC to time out.
+	loopInWaitInterval = time.Minute
+)
+
+type recoverLoopInCommand struct {
+	HtlcAddr    string
+	SwapHash    string
+	CltvExpiry  uint32
+	ReceiverKey string
+	NumKeys     uint32
+	Wait        bool
+	Publish     bool
+	SweepAddr   string
+	FeeRate     uint16
+
+	chainAPI *chainAPIFlags
+	rootKey  *rootKey
+	hwSigner *hwSigner
+	cmd      *cobra.Command
+}
+
+func newRecoverLoopInCommand() *cobra.Command {
+	cc := &recoverLoopInCommand{}
+	cc.cmd = &cobra.Command{
+		Use:   "recoverloopin",
+		Short: "Sweep the on-chain HTLC of a stuck Loop In swap",
+		Long: `If a Loop In swap never completed (for example because
+the Loop client's data was lost), the funds remain in the on-chain HTLC until
+its CLTV timeout is reached. After that, the HTLC can be swept back to the
+wallet through the timeout path with just the seed of the lnd node that ran the
+Loop client.
+
+This command reconstructs the HTLC script from the given swap parameters and
+the Loop client's HTLC key, which is derived from the seed (key family 99). The
+key index is brute forced, so only the following swap parameters are needed:
+the HTLC address (shown when the swap was initiated and in 'loop listswaps'),
+the swap hash, the CLTV expiry height and the Loop server's public HTLC key.
+All of them are stored in the Loop client's database too.
+
+Both the segwit v0 (P2WSH and nested P2SH-P2WSH) and the Taproot (v3) HTLCs are
+supported. If the CLTV timeout isn't reached yet, the command fails unless
+--wait is specified, in which case it waits until the HTLC can be swept.`,
+		Example: `chantools recoverloopin \
+	--htlcaddr bc1q..... \
+	--swaphash 0123456789abcdef... \
+	--cltvexpiry 812345 \
+	--receiverkey 03xxxxxxx \
+	--sweepaddr bc1q..... \
+	--feerate 10 \
+	--publish`,
+		RunE: cc.Execute,
+	}
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
+	cc.cmd.Flags().StringVar(
+		&cc.HtlcAddr, "htlcaddr", "", "address of the on-chain HTLC "+
+			"of the swap",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SwapHash, "swaphash", "", "hex encoded hash of the swap",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.CltvExpiry, "cltvexpiry", 0, "block height at which the "+
+			"HTLC can be swept through the timeout path",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.ReceiverKey, "receiverkey", "", "hex encoded public HTLC "+
+			"key of the Loop server",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.NumKeys, "numkeys", defaultLoopNumKeys, "the number of "+
+			"HTLC key indices to try at most",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Wait, "wait", false, "wait until the CLTV timeout is "+
+			"reached instead of failing",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Publish, "publish", false, "publish sweep TX to the chain "+
+			"API instead of just printing the TX",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SweepAddr, "sweepaddr", "", "address to sweep the funds to",
+	)
+	cc.cmd.Flags().Uint16Var(
+		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
+			"use for the sweep transaction in sat/vByte",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "deriving keys")
+	cc.hwSigner = newHWSigner(cc.cmd)
+	cc.hwSigner.registerXPubFlag(cc.cmd)
+
+	return cc.cmd
+}
+
+func (c *recoverLoopInCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.hwSigner.readRootKey(c.rootKey)
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	if c.HtlcAddr == "" {
+		return fmt.Errorf("HTLC addr is required")
+	}
+	if c.SweepAddr == "" {
+		return fmt.Errorf("sweep addr is required")
+	}
+	if c.CltvExpiry == 0 {
+		return fmt.Errorf("CLTV expiry is required")
+	}
+	swapHash, err := lntypes.MakeHashFromStr(c.SwapHash)
+	if err != nil {
+		return fmt.Errorf("invalid swap hash: %w", err)
+	}
+	receiverKey, err := pubKeyFromHex(c.ReceiverKey)
+	if err != nil {
+		return fmt.Errorf("invalid receiver key: %w", err)
+	}
+
+	api := c.chainAPI.api()
+	c.FeeRate, err = sweepFeeRate(c.cmd, api, c.FeeRate)
+	if err != nil {
+		return err
+	}
+
+	if err := waitForLoopInTimeout(api, c.CltvExpiry, c.Wait); err != nil {
+		return err
+	}
+
+	return recoverLoopIn(
+		extendedKey, api, c.HtlcAddr, swapHash, c.CltvExpiry,
+		receiverKey, c.NumKeys, c.SweepAddr, c.Publish, c.FeeRate,
+		c.hwSigner,
+	)
+}
+
+// waitForLoopInTimeout makes sure the HTLC can be swept through the timeout
+// path in the next block. If wait is true, we wait until that's the case.
+func waitForLoopInTimeout(api btc.ChainAPI, cltvExpiry uint32,
+	wait bool) error {
+
+	for {
+		height, err := api.BlockHeight()
+		if err != nil {
+			return fmt.Errorf("error getting block height: %w", err)
+		}
+
+		// A transaction with the CLTV expiry as its lock time can be
+		// included in the block after that height.
+		if height >= cltvExpiry {
+			return nil
+		}
+
+		if !wait {
+			return fmt.Errorf("HTLC can only be swept after block "+
+				"%d, that's %d blocks from now; use --wait to "+
+				"wait for it", cltvExpiry, cltvExpiry-height)
+		}
+
+		log.Infof("Waiting for block %d to sweep HTLC, current "+
+			"height is %d", cltvExpiry, height)
+		time.Sleep(loopInWaitInterval)
+	}
+}
+
+func recoverLoopIn(extendedKey *hdkeychain.ExtendedKey, api btc.SweepAPI,
+	htlcAddr string, swapHash lntypes.Hash, cltvExpiry uint32,
+	receiverKey *btcec.PublicKey, numKeys uint32, sweepAddr string,
+	publish bool, feeRate uint16, hw *hwSigner) error {
+
+	signer, err := hw.wrap(&lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	})
+	if err != nil {
+		return err
+	}
+
+	addr, err := lnd.ParseAddress(htlcAddr, chainParams)
+	if err != nil {
+		return fmt.Errorf("invalid HTLC addr: %w", err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return fmt.Errorf("error creating HTLC pk script: %w", err)
+	}
+
+	htlc, err := findLoopInHTLC(
+		signer, pkScript, swapHash, cltvExpiry, receiverKey, numKeys,
+	)
+	if err != nil {
+		return err
+	}
+	log.Infof("Found %s HTLC with key index %d", htlc.version,
+		htlc.keyDesc.Index)
+
+	tx, txIndex, err := api.Outpoint(htlcAddr)
+	if err != nil {
+		return fmt.Errorf("error looking up HTLC address %s on chain: "+
+			"%w", htlcAddr, err)
+	}
+	txHash, err := chainhash.NewHashFromStr(tx.TXID)
+	if err != nil {
+		return fmt.Errorf("error parsing tx hash: %w", err)
+	}
+	sweepValue := int64(tx.Vout[txIndex].Value)
+
+	// The timeout path requires the lock time to be set to the CLTV expiry
+	// and the sequence to be below the maximum to enable it.
+	sweepTx := wire.NewMsgTx(2)
+	sweepTx.LockTime = cltvExpiry
+	sweepTx.TxIn = []*wire.TxIn{{
+		PreviousOutPoint: wire.OutPoint{
+			Hash:  *txHash,
+			Index: uint32(txIndex),
+		},
+		SignatureScript: htlc.sigScript,
+		Sequence:        wire.MaxTxInSequenceNum - 1,
+	}}
+
+	// Calculate the fee based on the given fee rate and our weight
+	// estimation.
+	var estimator input.TxWeightEstimator
+	if htlc.sigScript != nil {
+		estimator.AddNestedP2WSHInput(htlc.witnessSize)
+	} else {
+		estimator.AddWitnessInput(htlc.witnessSize)
+	}
+	estimator.AddP2WKHOutput()
+	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
+	totalFee := feeRateKWeight.FeeForWeight(int64(estimator.Weight()))
+
+	// Add our sweep destination output.
+	sweepScript, err := lnd.GetP2WPKHScript(sweepAddr, chainParams)
+	if err != nil {
+		return err
+	}
+	sweepTx.TxOut = []*wire.TxOut{{
+		Value:    sweepValue - int64(totalFee),
+		PkScript: sweepScript,
+	}}
+
+	log.Infof("Fee %d sats of %d total amount (estimated weight %d)",
+		totalFee, sweepValue, estimator.Weight())
+
+	signDesc := &input.SignDescriptor{
+		KeyDesc:       *htlc.keyDesc,
+		WitnessScript: htlc.witnessScript,
+		Output: &wire.TxOut{
+			PkScript: pkScript,
+			Value:    sweepValue,
+		},
+		InputIndex: 0,
+		PrevOutputFetcher: txscript.NewCannedPrevOutputFetcher(
+			pkScript, sweepValue,
+		),
+	}
+	if htlc.controlBlock != nil {
+		signDesc.HashType = txscript.SigHashDefault
+		signDesc.SignMethod = input.TaprootScriptSpendSignMethod
+	} else {
+		signDesc.HashType = txscript.SigHashAll
+		signDesc.SignMethod = input.WitnessV0SignMethod
+		signDesc.SigHashes = input.NewTxSigHashesV0Only(sweepTx)
+	}
+
+	sig, err := signer.SignOutputRaw(sweepTx, signDesc)
+	if err != nil {
+		return fmt.Errorf("error signing sweep tx: %w", err)
+	}
+	sweepTx.TxIn[0].Witness = htlc.timeoutWitness(sig)
+
+	if hw.exporting() {
+		return hw.export(sweepTx)
+	}
+
+	inputs := []*txResultInput{{
+		Outpoint: sweepTx.TxIn[0].PreviousOutPoint.String(),
+		Value:    sweepValue,
+		Addr:     htlcAddr,
+	}}
+	results := newTxResults()
+	err = results.addTx(api, sweepTx, inputs, publish)
+	if err != nil {
+		return err
+	}
+
+	return results.print()
+}
+
+// loopInHTLC is a reconstructed Loop In HTLC.
+type loopInHTLC struct {
+	version       string
+	keyDesc       *keychain.KeyDescriptor
+	pkScript      []byte
+	witnessScript []byte
+	sigScript     []byte
+	controlBlock  []byte
+	witnessSize   int
+}
+
+// timeoutWitness returns the witness that spends the HTLC through the timeout
+// path with the given signature of the sender key.
+func (h *loopInHTLC) timeoutWitness(sig input.Signature) wire.TxWitness {
+	if h.controlBlock != nil {
+		return wire.TxWitness{
+			sig.Serialize(), h.witnessScript, h.controlBlock,
+		}
+	}
+
+	// The empty element makes the receiver's signature check fail, which
+	// selects the timeout path.
+	return wire.TxWitness{
+		append(sig.Serialize(), byte(txscript.SigHashAll)),
+		h.keyDesc.PubKey.SerializeCompressed(),
+		nil,
+		h.witnessScript,
+	}
+}
+
+// findLoopInHTLC tries all HTLC key indices of the Loop client until one of
+// the possible HTLC scripts matches the given pk script.
+func findLoopInHTLC(signer walletSigner, pkScript []byte,
+	swapHash lntypes.Hash, cltvExpiry uint32, receiverKey *btcec.PublicKey,
+	numKeys uint32) (*loopInHTLC, error) {
+
+	for i := uint32(0); i < numKeys; i++ {
+		keyDesc, err := signer.DeriveKey(keychain.KeyLocator{
+			Family: loopKeyFamily,
+			Index:  i,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error deriving HTLC key: %w",
+				err)
+		}
+
+		htlcs, err := loopInHTLCs(
+			keyDesc, receiverKey, swapHash, cltvExpiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for _, htlc := range htlcs {
+			if bytes.Equal(htlc.pkScript, pkScript) {
+				return htlc, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("HTLC script not derived with %d keys, check "+
+		"the swap parameters", numKeys)
+}
+
+// loopInHTLCs returns all HTLCs a Loop client could have created for a Loop In
+// swap with the given sender key.
+func loopInHTLCs(senderKey *keychain.KeyDescriptor,
+	receiverKey *btcec.PublicKey, swapHash lntypes.Hash,
+	cltvExpiry uint32) ([]*loopInHTLC, error) {
+
+	witnessScript, err := loopInHTLCScriptV2(
+		senderKey.PubKey, receiverKey, swapHash, cltvExpiry,
+	)
+	if err != nil {
+		return nil, err
+	}
+	p2wshScript, err := input.WitnessScriptHash(witnessScript)
+	if err != nil {
+		return nil, err
+	}
+	np2wshScript, err := input.GenerateP2SH(p2wshScript)
+	if err != nil {
+		return nil, err
+	}
+	sigScript, err := txscript.NewScriptBuilder().AddData(
+		p2wshScript,
+	).Script()
+	if err != nil {
+		return nil, err
+	}
+
+	// Signature, sender key, empty element and witness script.
+	witnessSize := 1 + 1 + 73 + 1 + 33 + 1 + 1 +
+		len(witnessScript)
+
+	htlcs := []*loopInHTLC{{
+		version:       "P2WSH (v2)",
+		keyDesc:       senderKey,
+		pkScript:      p2wshScript,
+		witnessScript: witnessScript,
+		witnessSize:   witnessSize,
+	}, {
+		version:       "NP2WSH (v2)",
+		keyDesc:       senderKey,
+		pkScript:      np2wshScript,
+		witnessScript: witnessScript,
+		sigScript:     sigScript,
+		witnessSize:   witnessSize,
+	}}
+
+	// Older clients used the MuSig2 v0.4.0 key aggregation for the
+	// Taproot HTLCs.
+	muSig2Versions := []input.MuSig2Version{
+		input.MuSig2Version100RC2, input.MuSig2Version040,
+	}
+	for _, muSig2Version := range muSig2Versions {
+		htlc, err := loopInHTLCV3(
+			muSig2Version, senderKey, receiverKey, swapHash,
+			cltvExpiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+		htlcs = append(htlcs, htlc)
+	}
+
+	return htlcs, nil
+}
+
+// loopInHTLCScriptV2 returns the witness script of a segwit v0 HTLC:
+//
+//	<receiverHtlcKey> OP_CHECKSIG OP_NOTIF
+//	  OP_DUP OP_HASH160 <HASH160(senderHtlcKey)> OP_EQUALVERIFY
+//	  OP_CHECKSIGVERIFY <cltv timeout> OP_CHECKLOCKTIMEVERIFY
+//	OP_ELSE
+//	  OP_SIZE <32> OP_EQUALVERIFY OP_HASH160 <ripemd(swapHash)>
+//	  OP_EQUALVERIFY 1
+//	OP_ENDIF
+func loopInHTLCScriptV2(senderKey, receiverKey *btcec.PublicKey,
+	swapHash lntypes.Hash, cltvExpiry uint32) ([]byte, error) {
+
+	builder := txscript.NewScriptBuilder()
+
+	builder.AddData(receiverKey.SerializeCompressed())
+	builder.AddOp(txscript.OP_CHECKSIG)
+
+	builder.AddOp(txscript.OP_NOTIF)
+
+	builder.AddOp(txscript.OP_DUP)
+	builder.AddOp(txscript.OP_HASH160)
+	builder.AddData(btcutil.Hash160(senderKey.SerializeCompressed()))
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddOp(txscript.OP_CHECKSIGVERIFY)
+
+	builder.AddInt64(int64(cltvExpiry))
+	builder.AddOp(txscript.OP_CHECKLOCKTIMEVERIFY)
+
+	builder.AddOp(txscript.OP_ELSE)
+
+	builder.AddOp(txscript.OP_SIZE)
+	builder.AddInt64(32)
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddOp(txscript.OP_HASH160)
+	builder.AddData(input.Ripemd160H(swapHash[:]))
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddInt64(1)
+
+	builder.AddOp(txscript.OP_ENDIF)
+
+	return builder.Script()
+}
+
+// loopInHTLCV3 returns the Taproot HTLC. Its internal key is the MuSig2
+// combined key of the sender and receiver key and it has two script leaves:
+//
+//	success: <receiverHtlcKey> OP_CHECKSIGVERIFY OP_SIZE 32 OP_EQUALVERIFY
+//	         OP_HASH160 <ripemd(swapHash)> OP_EQUALVERIFY 1
+//	         OP_CHECKSEQUENCEVERIFY
+//	timeout: <senderHtlcKey> OP_CHECKSIGVERIFY <cltv timeout>
+//	         OP_CHECKLOCKTIMEVERIFY
+func loopInHTLCV3(muSig2Version input.MuSig2Version,
+	senderKey *keychain.KeyDescriptor, receiverKey *btcec.PublicKey,
+	swapHash lntypes.Hash, cltvExpiry uint32) (*loopInHTLC, error) {
+
+	builder := txscript.NewScriptBuilder()
+	builder.AddData(schnorr.SerializePubKey(receiverKey))
+	builder.AddOp(txscript.OP_CHECKSIGVERIFY)
+	builder.AddOp(txscript.OP_SIZE)
+	builder.AddInt64(32)
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddOp(txscript.OP_HASH160)
+	builder.AddData(input.Ripemd160H(swapHash[:]))
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddInt64(1)
+	builder.AddOp(txscript.OP_CHECKSEQUENCEVERIFY)
+	successScript, err := builder.Script()
+	if err != nil {
+		return nil, err
+	}
+
+	builder = txscript.NewScriptBuilder()
+	builder.AddData(schnorr.SerializePubKey(senderKey.PubKey))
+	builder.AddOp(txscript.OP_CHECKSIGVERIFY)
+	builder.AddInt64(int64(cltvExpiry))
+	builder.AddOp(txscript.OP_CHECKLOCKTIMEVERIFY)
+	timeoutScript, err := builder.Script()
+	if err != nil {
+		return nil, err
+	}
+
+	tapTree := txscript.AssembleTaprootScriptTree(
+		txscript.NewBaseTapLeaf(successScript),
+		txscript.NewBaseTapLeaf(timeoutScript),
+	)
+	rootHash := tapTree.RootNode.TapHash()
+
+	// The v0.4.0 MuSig2 implementation requires the keys to be serialized
+	// using the Schnorr (32-byte x-only) serialization format.
+	keys := []*btcec.PublicKey{senderKey.PubKey, receiverKey}
+	if muSig2Version == input.MuSig2Version040 {
+		for idx, key := range keys {
+			keys[idx], err = schnorr.ParsePubKey(
+				schnorr.SerializePubKey(key),
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	aggregateKey, err := input.MuSig2CombineKeys(
+		muSig2Version, keys, true, &input.MuSig2Tweaks{
+			TaprootTweak: rootHash[:],
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error combining keys: %w", err)
+	}
+	pkScript, err := txscript.PayToTaprootScript(aggregateKey.FinalKey)
+	if err != nil {
+		return nil, err
+	}
+
+	timeoutProof := tapTree.LeafMerkleProofs[1]
+	controlBlock := timeoutProof.ToControlBlock(aggregateKey.PreTweakedKey)
+	controlBlockBytes, err := controlBlock.ToBytes()
+	if err != nil {
+		return nil, fmt.Errorf("error serializing control block: %w",
+			err)
+	}
+
+	return &loopInHTLC{
+		version:       "Taproot (v3)",
+		keyDesc:       senderKey,
+		pkScript:      pkScript,
+		witnessScript: timeoutScript,
+		controlBlock:  controlBlockBytes,
+
+		// Signature, timeout script and control block.
+		witnessSize: 1 + 1 + schnorr.SignatureSize + 1 +
+			len(timeoutScript) + 1 + len(controlBlockBytes),
+	}, nil
+}
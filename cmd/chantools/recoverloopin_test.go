@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/require"
+)
+
+// loopInTestAPI is a fake regtest node that can look up the HTLC outputs by
+// their address.
+type loopInTestAPI struct {
+	*fakeRegtest
+}
+
+func (a *loopInTestAPI) BlockHeight() (uint32, error) {
+	return a.height, nil
+}
+
+func (a *loopInTestAPI) Outpoint(addr string) (*btc.TX, int, error) {
+	parsedAddr, err := lnd.ParseAddress(addr, chainParams)
+	if err != nil {
+		return nil, 0, err
+	}
+	pkScript, err := txscript.PayToAddrScript(parsedAddr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for txid, tx := range a.txs {
+		for idx, txOut := range tx.TxOut {
+			if bytes.Equal(txOut.PkScript, pkScript) {
+				result, err := a.Transaction(txid)
+				return result, idx, err
+			}
+		}
+	}
+
+	return nil, 0, btc.ErrTxNotFound
+}
+
+func TestRecoverLoopIn(t *testing.T) {
+	_ = newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	receiverPrivKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	receiverKey := receiverPrivKey.PubKey()
+	preimage := lntypes.Preimage{1, 2, 3}
+	swapHash := preimage.Hash()
+	const (
+		cltvExpiry = 1_000
+		sweepAddr  = "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"
+	)
+
+	senderKey, err := signer.DeriveKey(keychain.KeyLocator{
+		Family: loopKeyFamily,
+		Index:  7,
+	})
+	require.NoError(t, err)
+	htlcs, err := loopInHTLCs(senderKey, receiverKey, swapHash, cltvExpiry)
+	require.NoError(t, err)
+	require.Len(t, htlcs, 4)
+
+	for _, htlc := range htlcs {
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(
+			htlc.pkScript, chainParams,
+		)
+		require.NoError(t, err)
+		htlcAddr := addrs[0].String()
+
+		api := &loopInTestAPI{fakeRegtest: newFakeRegtest("regtest")}
+		fundingTx := wire.NewMsgTx(2)
+		fundingTx.AddTxIn(&wire.TxIn{})
+		fundingTx.AddTxOut(&wire.TxOut{Value: 1_000, PkScript: []byte{
+			txscript.OP_TRUE,
+		}})
+		fundingTx.AddTxOut(&wire.TxOut{
+			Value:    250_000,
+			PkScript: htlc.pkScript,
+		})
+		api.addTx(fundingTx)
+		api.confirmed[fundingTx.TxHash().String()] = 1
+		api.height = cltvExpiry - 1
+
+		// The HTLC can't be swept before the timeout.
+		err = waitForLoopInTimeout(api, cltvExpiry, false)
+		require.ErrorContains(t, err, "that's 1 blocks from now")
+
+		// Wrong swap parameters don't result in a matching script.
+		err = recoverLoopIn(
+			extendedKey, api, htlcAddr, lntypes.Hash{}, cltvExpiry,
+			receiverKey, 10, sweepAddr, true, 10, &hwSigner{},
+		)
+		require.ErrorContains(t, err, "HTLC script not derived")
+
+		// The sweep transaction is validated by the fake node.
+		api.height = cltvExpiry
+		require.NoError(t, waitForLoopInTimeout(api, cltvExpiry, false))
+		err = recoverLoopIn(
+			extendedKey, api, htlcAddr, swapHash, cltvExpiry,
+			receiverKey, 10, sweepAddr, true, 10, &hwSigner{},
+		)
+		require.NoError(t, err, htlc.version)
+		require.Len(t, api.txs, 2)
+
+		htlcOutpoint := wire.OutPoint{
+			Hash:  fundingTx.TxHash(),
+			Index: 1,
+		}
+		var sweepTx *wire.MsgTx
+		for txid, tx := range api.txs {
+			if txid != fundingTx.TxHash().String() {
+				sweepTx = tx
+			}
+		}
+		require.Equal(t, htlcOutpoint, sweepTx.TxIn[0].PreviousOutPoint)
+		require.EqualValues(t, cltvExpiry, sweepTx.LockTime)
+		require.Less(t, sweepTx.TxOut[0].Value, int64(250_000))
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/spf13/cobra"
+)
+
+type reEncryptBackupCommand struct {
+	MultiFile  string
+	NewRootKey string
+	Output     string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newReEncryptBackupCommand() *cobra.Command {
+	cc := &reEncryptBackupCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "reencryptbackup",
+		Short: "Decrypt a channel.backup file and encrypt it with " +
+			"another root key",
+		Long: `This command decrypts an lnd channel.backup file with the
+root key of the old seed (given with --rootkey or entered as the seed) and
+encrypts it again with the root key given with --newrootkey. The root key of a
+seed can be obtained with the showrootkey command.
+
+This allows storing the backup together with (and protected by) the new seed
+after a seed rotation.
+
+CAUTION: Only the encryption of the file changes. The channel keys in the
+backup are still derived from the old seed, so the channels can only be
+restored by a node that runs with the old seed. Keep the old seed until all
+channels in the backup are closed and their funds are swept.`,
+		Example: `chantools reencryptbackup \
+	--multi_file ~/.lnd/data/chain/bitcoin/mainnet/channel.backup \
+	--newrootkey xprv...`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.MultiFile, "multi_file", "", "lnd channel.backup file to "+
+			"re-encrypt",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.NewRootKey, "newrootkey", "", "BIP32 HD root key of the "+
+			"new seed to encrypt the backup with",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Output, "output", "", "the re-encrypted channel backup "+
+			"file to create; if empty, "+
+			"reencrypted-<timestamp>.backup in the working "+
+			"directory is used",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "decrypting the backup")
+
+	return cc.cmd
+}
+
+func (c *reEncryptBackupCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	// Check that we have a backup file and the new key.
+	if c.MultiFile == "" {
+		return fmt.Errorf("backup file is required")
+	}
+	if c.NewRootKey == "" {
+		return fmt.Errorf("new root key is required")
+	}
+	newExtendedKey, err := hdkeychain.NewKeyFromString(c.NewRootKey)
+	if err != nil {
+		return fmt.Errorf("error parsing new root key: %w", err)
+	}
+	if newExtendedKey.String() == extendedKey.String() {
+		return fmt.Errorf("new root key is the same as the old one")
+	}
+
+	oldKeyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	newKeyRing := &lnd.HDKeyRing{
+		ExtendedKey: newExtendedKey,
+		ChainParams: chainParams,
+	}
+
+	multi, err := chanbackup.NewMultiFile(c.MultiFile).ExtractMulti(
+		oldKeyRing,
+	)
+	if err != nil {
+		return fmt.Errorf("could not extract multi file: %w", err)
+	}
+
+	if c.Output == "" {
+		c.Output = resultFileName("reencrypted", "backup")
+	}
+	log.Infof("Writing %d channels encrypted with the new root key to %s",
+		len(multi.StaticBackups), c.Output)
+	return writeBackups(
+		multi.StaticBackups, newKeyRing,
+		chanbackup.NewMultiFile(c.Output),
+	)
+}
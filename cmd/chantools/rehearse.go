@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/sweep"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// rehearseMaxFundingRounds is the maximum number of times 101 blocks
+	// are mined to fund the regtest wallet.
+	rehearseMaxFundingRounds = 10
+
+	// rehearseFeeReserve is the amount the regtest wallet needs on top of
+	// the channel balances to pay the fees of the funding transactions.
+	rehearseFeeReserve = btcutil.Amount(1_000_000)
+)
+
+// rehearseAPI is the regtest bitcoind backend a rehearsal runs against.
+type rehearseAPI interface {
+	btc.SweepAPI
+
+	Chain() (string, error)
+	NewAddress() (string, error)
+	Balance() (btcutil.Amount, error)
+	SendToAddress(addr string, amount btcutil.Amount) (string, error)
+	GenerateToAddress(numBlocks uint32, addr string) ([]string, error)
+}
+
+type rehearseCommand struct {
+	ChannelDB      string
+	MaxCsvLimit    uint16
+	FeeRate        uint16
+	BitcoindRPC    string
+	BitcoindUser   string
+	BitcoindPass   string
+	BitcoindCookie string
+
+	rootKey *rootKey
+	inputs  *inputFlags
+	cmd     *cobra.Command
+}
+
+func newRehearseCommand() *cobra.Command {
+	cc := &rehearseCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "rehearse",
+		Short: "Rehearse sweeping the time locked outputs of force " +
+			"closed channels on regtest",
+		Long: `Runs the sweep of the time locked outputs of channels
+that were force closed by us end-to-end against a bitcoind node in regtest mode,
+so the whole procedure (seed, channel data, scripts and signatures) can be
+validated before anything is published on mainnet.
+
+For every channel with force close information in the given summary (the
+result of the forceclose command), the to_local output of the commitment
+transaction is recreated on regtest by sending the same amount to the same
+script from the regtest wallet. If a channel.db is given, the force close
+information is created from it first, without publishing anything. Then blocks
+are mined until the CSV delays of all outputs have expired, the sweep
+transaction is created and signed with the seed just like sweeptimelock does,
+published to the regtest node and mined. The rehearsal is successful if all
+outputs were swept.
+
+The bitcoind node must run in regtest mode, have txindex=1 and a loaded wallet.
+The wallet is funded by mining blocks if needed.`,
+		Example: `chantools rehearse \
+	--fromsummary results/forceclose-xxxx-yyyy.json \
+	--bitcoindrpc localhost:18443 \
+	--bitcoinduser user --bitcoindpass pass
+
+chantools rehearse \
+	--fromchanneldb ~/.lnd/data/graph/mainnet/channel.db \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--bitcoindrpc localhost:18443 \
+	--bitcoindcookie ~/.bitcoin/regtest/.cookie`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to "+
+			"create the force close information from; only "+
+			"needed if the summary doesn't contain it yet",
+	)
+	cc.cmd.Flags().Uint16Var(
+		&cc.MaxCsvLimit, "maxcsvlimit", defaultCsvLimit, "maximum CSV "+
+			"limit to use",
+	)
+	cc.cmd.Flags().Uint16Var(
+		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
+			"use for the sweep transaction in sat/vByte",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.BitcoindRPC, "bitcoindrpc", "localhost:18443", "host:port "+
+			"of the JSON-RPC interface of the regtest bitcoind",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.BitcoindUser, "bitcoinduser", "", "user name for the "+
+			"bitcoind JSON-RPC interface",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.BitcoindPass, "bitcoindpass", "", "password for the "+
+			"bitcoind JSON-RPC interface",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.BitcoindCookie, "bitcoindcookie", "", "cookie file to "+
+			"read the bitcoind JSON-RPC credentials from instead "+
+			"of using --bitcoinduser and --bitcoindpass",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "signing the sweep transaction")
+	cc.inputs = newInputFlags(cc.cmd)
+
+	return cc.cmd
+}
+
+func (c *rehearseCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	// Parse channel entries from any of the possible input files.
+	entries, err := c.inputs.parseInputType()
+	if err != nil {
+		return err
+	}
+
+	// The force close information can be created from the channel DB
+	// without publishing anything.
+	if channelDBGiven(c.ChannelDB) {
+		db, err := openChannelDB(c.ChannelDB, true)
+		if err != nil {
+			return fmt.Errorf("error opening channel DB: %w", err)
+		}
+		defer func() { _ = db.Close() }()
+
+		err = forceCloseChannels(
+			nil, extendedKey, entries, db.ChannelStateDB(), false,
+			nil, newTxResults(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	api := &btc.BitcoindAPI{
+		Host:       c.BitcoindRPC,
+		User:       c.BitcoindUser,
+		Password:   c.BitcoindPass,
+		CookieFile: c.BitcoindCookie,
+	}
+
+	// The keys are derived for the network the channels were opened on,
+	// only the backend runs on regtest.
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	results := newTxResults()
+	err = rehearseTimeLockSweep(
+		api, signer, entries, c.MaxCsvLimit, c.FeeRate, results,
+	)
+	if err != nil {
+		return err
+	}
+
+	return results.print()
+}
+
+// rehearseTimeLockSweep recreates the to_local outputs of the given force
+// closed channels on regtest, waits for their CSV delays to expire and then
+// sweeps them.
+func rehearseTimeLockSweep(api rehearseAPI, signer *lnd.Signer,
+	entries []*dataformat.SummaryEntry, maxCsvTimeout, feeRate uint16,
+	results *txResults) error {
+
+	// Make absolutely sure we never send real coins to the scripts.
+	chain, err := api.Chain()
+	if err != nil {
+		return fmt.Errorf("error querying bitcoind chain: %w", err)
+	}
+	if chain != chaincfg.RegressionNetParams.Name {
+		return fmt.Errorf("bitcoind must run in regtest mode, but "+
+			"runs on %s", chain)
+	}
+	regtestParams := &chaincfg.RegressionNetParams
+
+	targets, skipped, err := sweep.TimeLockTargets(entries, log)
+	if err != nil {
+		return err
+	}
+	for _, s := range skipped {
+		results.skip(s.ChannelPoint, s.Reason)
+	}
+
+	// Find the CSV delay of each output, we need to mine enough blocks for
+	// the longest one to expire.
+	var (
+		rehearseTargets []*sweep.TimeLockTarget
+		maxCsvDelay     int32
+		totalValue      btcutil.Amount
+	)
+	for _, target := range targets {
+		csvDelay, _, _, err := sweep.BruteForceDelay(
+			input.TweakPubKey(
+				target.DelayBasePointDesc.PubKey,
+				target.CommitPoint,
+			), input.DeriveRevocationPubkey(
+				target.RevocationBasePoint,
+				target.CommitPoint,
+			), target.LockScript, maxCsvTimeout,
+		)
+		if err != nil {
+			log.Errorf("Could not create matching script for %s "+
+				"or csv too high: %v", target.ChannelPoint, err)
+			results.skip(
+				target.ChannelPoint, "no matching script "+
+					"found, CSV delay too high",
+			)
+			continue
+		}
+
+		if csvDelay > maxCsvDelay {
+			maxCsvDelay = csvDelay
+		}
+		totalValue += btcutil.Amount(target.Value)
+		rehearseTargets = append(rehearseTargets, target)
+	}
+	if len(rehearseTargets) == 0 {
+		return fmt.Errorf("no channels with sweepable time locked " +
+			"outputs found")
+	}
+
+	// Make sure the regtest wallet has enough coins to recreate all
+	// outputs.
+	walletAddr, err := api.NewAddress()
+	if err != nil {
+		return fmt.Errorf("error creating wallet address: %w", err)
+	}
+	for round := 0; ; round++ {
+		balance, err := api.Balance()
+		if err != nil {
+			return fmt.Errorf("error querying balance: %w", err)
+		}
+		if balance >= totalValue+rehearseFeeReserve {
+			break
+		}
+		if round == rehearseMaxFundingRounds {
+			return fmt.Errorf("regtest wallet balance %v not "+
+				"sufficient to fund %v", balance, totalValue)
+		}
+
+		log.Infof("Mining 101 blocks to fund regtest wallet")
+		_, err = api.GenerateToAddress(101, walletAddr)
+		if err != nil {
+			return fmt.Errorf("error mining blocks: %w", err)
+		}
+	}
+
+	// Recreate the to_local outputs. The rest of the target stays the same
+	// so the sweep uses exactly the same keys and scripts as on mainnet.
+	for _, target := range rehearseTargets {
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(
+			target.LockScript, regtestParams,
+		)
+		if err != nil || len(addrs) != 1 {
+			return fmt.Errorf("error parsing lock script of %s: %v",
+				target.ChannelPoint, err)
+		}
+
+		txid, err := api.SendToAddress(
+			addrs[0].EncodeAddress(), btcutil.Amount(target.Value),
+		)
+		if err != nil {
+			return fmt.Errorf("error recreating output of %s: %w",
+				target.ChannelPoint, err)
+		}
+		if err := rehearseFindOutput(api, txid, target); err != nil {
+			return err
+		}
+
+		log.Infof("Recreated to_local output of channel %s as %s:%d",
+			target.ChannelPoint, txid, target.Index)
+	}
+
+	// Mine the "force close" transactions and wait out the CSV delays.
+	log.Infof("Mining %d blocks to confirm the outputs and let the CSV "+
+		"delays expire", maxCsvDelay+1)
+	_, err = api.GenerateToAddress(uint32(maxCsvDelay)+1, walletAddr)
+	if err != nil {
+		return fmt.Errorf("error mining blocks: %w", err)
+	}
+
+	// Sweep the outputs just like sweeptimelock does.
+	sweepScript, err := lnd.GetP2WPKHScript(walletAddr, regtestParams)
+	if err != nil {
+		return err
+	}
+	sweepTx, err := sweep.TimeLock(
+		signer, rehearseTargets, sweepScript, maxCsvTimeout, feeRate,
+		log,
+	)
+	if err != nil {
+		return err
+	}
+
+	inputs := make([]*txResultInput, len(rehearseTargets))
+	for idx, target := range rehearseTargets {
+		inputs[idx] = &txResultInput{
+			Outpoint: wire.OutPoint{
+				Hash:  target.TxID,
+				Index: target.Index,
+			}.String(),
+			Value:        target.Value,
+			ChannelPoint: target.ChannelPoint,
+		}
+	}
+	if err := results.addTx(api, sweepTx, inputs, true); err != nil {
+		return fmt.Errorf("rehearsal failed, regtest node rejected "+
+			"sweep transaction: %w", err)
+	}
+	if DryRun {
+		return nil
+	}
+
+	if _, err := api.GenerateToAddress(1, walletAddr); err != nil {
+		return fmt.Errorf("error mining blocks: %w", err)
+	}
+
+	// Check that all outputs were swept by our transaction.
+	sweepTxID := sweepTx.TxHash().String()
+	for _, target := range rehearseTargets {
+		tx, err := api.Transaction(target.TxID.String())
+		if err != nil {
+			return err
+		}
+
+		outspend := tx.Vout[target.Index].Outspend
+		if outspend == nil || !outspend.Spent ||
+			outspend.Txid != sweepTxID {
+
+			return fmt.Errorf("rehearsal failed, output of "+
+				"channel %s was not swept", target.ChannelPoint)
+		}
+	}
+
+	log.Infof("Rehearsal successful, swept %d time locked outputs with "+
+		"a total value of %v in transaction %s", len(rehearseTargets),
+		totalValue, sweepTxID)
+
+	return nil
+}
+
+// rehearseFindOutput looks up the output of the given regtest transaction
+// that pays to the lock script of the target and points the target to it.
+func rehearseFindOutput(api rehearseAPI, txid string,
+	target *sweep.TimeLockTarget) error {
+
+	tx, err := api.Transaction(txid)
+	if err != nil {
+		return fmt.Errorf("error looking up regtest transaction %s: %w",
+			txid, err)
+	}
+
+	lockScript := hex.EncodeToString(target.LockScript)
+	for idx, vout := range tx.Vout {
+		if vout.ScriptPubkey != lockScript {
+			continue
+		}
+
+		txHash, err := chainhash.NewHashFromStr(txid)
+		if err != nil {
+			return err
+		}
+		target.TxID = *txHash
+		target.Index = uint32(idx)
+
+		return nil
+	}
+
+	return fmt.Errorf("output of channel %s not found in regtest "+
+		"transaction %s", target.ChannelPoint, txid)
+}
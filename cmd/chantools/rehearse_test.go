@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRegtest is an in-memory regtest node that validates the scripts and
+// relative time locks of all published transactions.
+type fakeRegtest struct {
+	btc.SweepAPI
+
+	chain     string
+	balance   btcutil.Amount
+	height    uint32
+	txs       map[string]*wire.MsgTx
+	confirmed map[string]uint32
+	prevOuts  *txscript.MultiPrevOutFetcher
+}
+
+func newFakeRegtest(chain string) *fakeRegtest {
+	return &fakeRegtest{
+		chain:     chain,
+		txs:       make(map[string]*wire.MsgTx),
+		confirmed: make(map[string]uint32),
+		prevOuts:  txscript.NewMultiPrevOutFetcher(nil),
+	}
+}
+
+func (f *fakeRegtest) Chain() (string, error) {
+	return f.chain, nil
+}
+
+func (f *fakeRegtest) NewAddress() (string, error) {
+	return "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080", nil
+}
+
+func (f *fakeRegtest) Balance() (btcutil.Amount, error) {
+	return f.balance, nil
+}
+
+func (f *fakeRegtest) SendToAddress(addr string,
+	amount btcutil.Amount) (string, error) {
+
+	pkScript, err := lnd.GetP2WSHScript(
+		addr, &chaincfg.RegressionNetParams,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	// The change output comes first to make sure the output index is
+	// looked up and not assumed.
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{
+		Hash: chainhash.Hash{byte(len(f.txs))},
+	}})
+	tx.AddTxOut(&wire.TxOut{Value: 1_000, PkScript: []byte{0x51}})
+	tx.AddTxOut(&wire.TxOut{Value: int64(amount), PkScript: pkScript})
+	f.addTx(tx)
+	f.balance -= amount
+
+	return tx.TxHash().String(), nil
+}
+
+func (f *fakeRegtest) GenerateToAddress(numBlocks uint32,
+	_ string) ([]string, error) {
+
+	// Mining the first blocks funds the wallet.
+	if f.height == 0 {
+		f.balance += 50 * btcutil.SatoshiPerBitcoin
+	}
+
+	for txid := range f.txs {
+		if _, ok := f.confirmed[txid]; !ok {
+			f.confirmed[txid] = f.height + 1
+		}
+	}
+	f.height += numBlocks
+
+	return make([]string, numBlocks), nil
+}
+
+func (f *fakeRegtest) Transaction(txid string) (*btc.TX, error) {
+	tx, ok := f.txs[txid]
+	if !ok {
+		return nil, btc.ErrTxNotFound
+	}
+
+	result := &btc.TX{TXID: txid}
+	for idx, txOut := range tx.TxOut {
+		vout := &btc.Vout{
+			ScriptPubkey: hex.EncodeToString(txOut.PkScript),
+			Value:        uint64(txOut.Value),
+			Outspend:     &btc.Outspend{},
+		}
+		outpoint := wire.OutPoint{Hash: tx.TxHash(), Index: uint32(idx)}
+		for spendID, spendTx := range f.txs {
+			if txSpends(spendTx, outpoint) {
+				vout.Outspend.Spent = true
+				vout.Outspend.Txid = spendID
+			}
+		}
+		result.Vout = append(result.Vout, vout)
+	}
+
+	return result, nil
+}
+
+func (f *fakeRegtest) PublishTx(rawTxHex string) (string, error) {
+	rawTx, err := hex.DecodeString(rawTxHex)
+	if err != nil {
+		return "", err
+	}
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return "", err
+	}
+
+	sigHashes := txscript.NewTxSigHashes(tx, f.prevOuts)
+	for idx, txIn := range tx.TxIn {
+		prevTxID := txIn.PreviousOutPoint.Hash.String()
+		confHeight, ok := f.confirmed[prevTxID]
+		if !ok {
+			return "", fmt.Errorf("input %d not confirmed", idx)
+		}
+		csvDelay := txIn.Sequence & wire.SequenceLockTimeMask
+		if txIn.Sequence&wire.SequenceLockTimeDisabled != 0 {
+			csvDelay = 0
+		}
+		if f.height-confHeight+1 < csvDelay {
+			return "", fmt.Errorf("non-BIP68-final")
+		}
+
+		prevOut := f.prevOuts.FetchPrevOutput(txIn.PreviousOutPoint)
+		vm, err := txscript.NewEngine(
+			prevOut.PkScript, tx, idx,
+			txscript.StandardVerifyFlags, nil, sigHashes,
+			prevOut.Value, f.prevOuts,
+		)
+		if err != nil {
+			return "", err
+		}
+		if err := vm.Execute(); err != nil {
+			return "", err
+		}
+	}
+	f.addTx(tx)
+
+	return tx.TxHash().String(), nil
+}
+
+func (f *fakeRegtest) addTx(tx *wire.MsgTx) {
+	f.txs[tx.TxHash().String()] = tx
+	for idx, txOut := range tx.TxOut {
+		f.prevOuts.AddPrevOut(wire.OutPoint{
+			Hash:  tx.TxHash(),
+			Index: uint32(idx),
+		}, txOut)
+	}
+}
+
+// rehearseEntry creates a summary entry of a force closed channel with a
+// to_local output that can be swept with the test root key.
+func rehearseEntry(t *testing.T, extendedKey *hdkeychain.ExtendedKey,
+	keyIndex uint32, csvDelay uint32,
+	value uint64) *dataformat.SummaryEntry {
+
+	keyRing := &lnd.HDKeyRing{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	delayBase, err := keyRing.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyDelayBase,
+		Index:  keyIndex,
+	})
+	require.NoError(t, err)
+
+	commitKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	revocationKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	commitPoint := commitKey.PubKey()
+	revocationBase := revocationKey.PubKey()
+
+	script, err := input.CommitScriptToSelf(
+		csvDelay, input.TweakPubKey(delayBase.PubKey, commitPoint),
+		input.DeriveRevocationPubkey(revocationBase, commitPoint),
+	)
+	require.NoError(t, err)
+	pkScript, err := input.WitnessScriptHash(script)
+	require.NoError(t, err)
+
+	return &dataformat.SummaryEntry{
+		ChannelPoint: fmt.Sprintf("%s:%d", chainhash.Hash{
+			byte(keyIndex),
+		}, keyIndex),
+		LocalBalance: value,
+		ForceClose: &dataformat.ForceClose{
+			TXID: chainhash.Hash{0xff, byte(keyIndex)}.String(),
+			DelayBasePoint: &dataformat.BasePoint{
+				Family: uint16(keychain.KeyFamilyDelayBase),
+				Index:  keyIndex,
+				PubKey: hex.EncodeToString(
+					delayBase.PubKey.SerializeCompressed(),
+				),
+			},
+			RevocationBasePoint: &dataformat.BasePoint{
+				PubKey: hex.EncodeToString(
+					revocationBase.SerializeCompressed(),
+				),
+			},
+			CommitPoint: hex.EncodeToString(
+				commitPoint.SerializeCompressed(),
+			),
+			Outs: []*dataformat.Out{{
+				Script: hex.EncodeToString(pkScript),
+				Value:  value,
+			}},
+		},
+	}
+}
+
+func TestRehearseTimeLockSweep(t *testing.T) {
+	_ = newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	entries := []*dataformat.SummaryEntry{
+		rehearseEntry(t, extendedKey, 0, 144, 500_000),
+		rehearseEntry(t, extendedKey, 3, 2016, 1_200_000),
+		{ChannelPoint: "chan:missing"},
+	}
+
+	// Coins are never sent to the scripts if the node isn't on regtest.
+	api := newFakeRegtest("main")
+	err = rehearseTimeLockSweep(
+		api, signer, entries, 2016, 10, newTxResults(),
+	)
+	require.ErrorContains(t, err, "bitcoind must run in regtest mode")
+	require.Empty(t, api.txs)
+
+	api = newFakeRegtest("regtest")
+	results := newTxResults()
+	err = rehearseTimeLockSweep(api, signer, entries, 2016, 10, results)
+	require.NoError(t, err)
+
+	require.Len(t, results.Transactions, 1)
+	sweepTx := results.Transactions[0]
+	require.True(t, sweepTx.Published)
+	require.Len(t, sweepTx.Inputs, 2)
+	require.Equal(
+		t, entries[1].ChannelPoint, sweepTx.Inputs[1].ChannelPoint,
+	)
+	require.EqualValues(t, 1_200_000, sweepTx.Inputs[1].Value)
+	require.Equal(t, []*skippedChannel{{
+		ChannelPoint: "chan:missing",
+		Reason:       "info missing or all spent",
+	}}, results.SkippedChannels)
+
+	// Outputs with a CSV delay above the limit are skipped.
+	api = newFakeRegtest("regtest")
+	results = newTxResults()
+	err = rehearseTimeLockSweep(api, signer, entries, 144, 10, results)
+	require.NoError(t, err)
+	require.Len(t, results.Transactions[0].Inputs, 1)
+	require.Equal(t, entries[1].ChannelPoint,
+		results.SkippedChannels[1].ChannelPoint)
+}
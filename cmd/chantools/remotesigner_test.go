@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnrpc/signrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/walletrpc"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeRemoteSigner is a minimal lnd gRPC server that derives keys and signs
+// with a local signer.
+type fakeRemoteSigner struct {
+	signrpc.UnimplementedSignerServer
+	walletrpc.UnimplementedWalletKitServer
+
+	signer *lnd.Signer
+}
+
+func (f *fakeRemoteSigner) DeriveKey(_ context.Context,
+	req *signrpc.KeyLocator) (*signrpc.KeyDescriptor, error) {
+
+	keyDesc, err := f.signer.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamily(req.KeyFamily),
+		Index:  uint32(req.KeyIndex),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &signrpc.KeyDescriptor{
+		RawKeyBytes: keyDesc.PubKey.SerializeCompressed(),
+		KeyLoc:      req,
+	}, nil
+}
+
+func (f *fakeRemoteSigner) SignOutputRaw(_ context.Context,
+	req *signrpc.SignReq) (*signrpc.SignResp, error) {
+
+	tx := &wire.MsgTx{}
+	err := tx.Deserialize(bytes.NewReader(req.RawTxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	rpcDesc := req.SignDescs[0]
+	signDesc := &input.SignDescriptor{
+		KeyDesc: keychain.KeyDescriptor{
+			KeyLocator: keychain.KeyLocator{
+				Family: keychain.KeyFamily(
+					rpcDesc.KeyDesc.KeyLoc.KeyFamily,
+				),
+				Index: uint32(rpcDesc.KeyDesc.KeyLoc.KeyIndex),
+			},
+		},
+		WitnessScript: rpcDesc.WitnessScript,
+		Output: &wire.TxOut{
+			Value:    rpcDesc.Output.Value,
+			PkScript: rpcDesc.Output.PkScript,
+		},
+		HashType:   txscript.SigHashType(rpcDesc.Sighash),
+		InputIndex: int(rpcDesc.InputIndex),
+	}
+	sig, err := f.signer.SignOutputRaw(tx, signDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signrpc.SignResp{RawSigs: [][]byte{sig.Serialize()}}, nil
+}
+
+func (f *fakeRemoteSigner) DeriveSharedKey(_ context.Context,
+	req *signrpc.SharedKeyRequest) (*signrpc.SharedKeyResponse, error) {
+
+	privKey, err := f.signer.FetchPrivKey(&keychain.KeyDescriptor{
+		KeyLocator: keychain.KeyLocator{
+			Family: keychain.KeyFamily(req.KeyDesc.KeyLoc.KeyFamily),
+			Index:  uint32(req.KeyDesc.KeyLoc.KeyIndex),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ephemeralKey, err := btcec.ParsePubKey(req.EphemeralPubkey)
+	if err != nil {
+		return nil, err
+	}
+	sharedKey, err := lnd.ECDH(privKey, ephemeralKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signrpc.SharedKeyResponse{SharedKey: sharedKey[:]}, nil
+}
+
+// newTestSigners returns a signer with a fixed seed and a remote signer that
+// is connected to a fake lnd node that uses the same seed.
+func newTestSigners(t *testing.T) (*lnd.Signer, *lnd.RemoteSigner) {
+	seed := bytes.Repeat([]byte{0x42}, 32)
+	extendedKey, err := hdkeychain.NewMaster(
+		seed, &chaincfg.RegressionNetParams,
+	)
+	require.NoError(t, err)
+	localSigner := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: &chaincfg.RegressionNetParams,
+	}
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	fake := &fakeRemoteSigner{signer: localSigner}
+	signrpc.RegisterSignerServer(server, fake)
+	walletrpc.RegisterWalletKitServer(server, fake)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet", grpc.WithContextDialer(
+			func(context.Context, string) (net.Conn, error) {
+				return listener.Dial()
+			},
+		), grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	remote := lnd.NewRemoteSigner(conn)
+	t.Cleanup(func() { _ = remote.Close() })
+
+	return localSigner, remote
+}
+
+func TestRemoteSigner(t *testing.T) {
+	localSigner, remote := newTestSigners(t)
+
+	// The remote signer derives the same keys as the seed.
+	keyLoc := keychain.KeyLocator{
+		Family: keychain.KeyFamilyPaymentBase,
+		Index:  3,
+	}
+	remoteKey, err := remote.DeriveKey(keyLoc)
+	require.NoError(t, err)
+	localKey, err := localSigner.DeriveKey(keyLoc)
+	require.NoError(t, err)
+	require.Equal(t, localKey.PubKey, remoteKey.PubKey)
+
+	// Spend a P2WKH output of that key with a signature of the remote
+	// signer.
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(
+		btcutil.Hash160(remoteKey.PubKey.SerializeCompressed()),
+		&chaincfg.RegressionNetParams,
+	)
+	require.NoError(t, err)
+	pkScript, err := txscript.PayToAddrScript(addr)
+	require.NoError(t, err)
+
+	prevOut := &wire.TxOut{Value: 100_000, PkScript: pkScript}
+	sweepTx := wire.NewMsgTx(2)
+	sweepTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}},
+	})
+	sweepTx.AddTxOut(&wire.TxOut{Value: 99_000, PkScript: pkScript})
+
+	signDesc := &input.SignDescriptor{
+		KeyDesc:       *remoteKey,
+		WitnessScript: pkScript,
+		Output:        prevOut,
+		HashType:      txscript.SigHashAll,
+		SigHashes:     input.NewTxSigHashesV0Only(sweepTx),
+	}
+	witness, err := input.CommitSpendNoDelay(
+		remote, signDesc, sweepTx, true,
+	)
+	require.NoError(t, err)
+	sweepTx.TxIn[0].Witness = witness
+
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(
+		prevOut.PkScript, prevOut.Value,
+	)
+	engine, err := txscript.NewEngine(
+		pkScript, sweepTx, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(sweepTx, prevOutFetcher),
+		prevOut.Value, prevOutFetcher,
+	)
+	require.NoError(t, err)
+	require.NoError(t, engine.Execute())
+}
+
+func TestRemoteSignerShaChainRoot(t *testing.T) {
+	localSigner, remote := newTestSigners(t)
+
+	basePath, err := lnd.ParsePath(fmt.Sprintf(
+		keyBasePath, chaincfg.RegressionNetParams.HDCoinType,
+	))
+	require.NoError(t, err)
+	baseKey, err := lnd.DeriveChildren(localSigner.ExtendedKey, basePath)
+	require.NoError(t, err)
+	seedRing := &seedKeyRing{baseKey: baseKey}
+
+	multiSigDesc, err := remote.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyMultiSig,
+		Index:  2,
+	})
+	require.NoError(t, err)
+
+	// The remote signer derives the same revocation root with ECDH as the
+	// seed does.
+	revLoc := keychain.KeyLocator{
+		Family: keychain.KeyFamilyRevocationRoot,
+		Index:  3,
+	}
+	remoteRoot, err := remote.ShaChainRoot(revLoc, multiSigDesc.PubKey)
+	require.NoError(t, err)
+	seedRoot, err := seedRing.ShaChainRoot(revLoc, multiSigDesc.PubKey)
+	require.NoError(t, err)
+
+	remoteSecret, err := remoteRoot.AtIndex(0)
+	require.NoError(t, err)
+	seedSecret, err := seedRoot.AtIndex(0)
+	require.NoError(t, err)
+	require.Equal(t, seedSecret, remoteSecret)
+
+	// The legacy scheme needs the private key and can't be used with the
+	// remote signer.
+	_, err = remote.ShaChainRoot(revLoc, nil)
+	require.ErrorIs(t, err, lnd.ErrLegacyRevocationRoot)
+}
@@ -1,20 +1,38 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"strconv"
+	"sort"
 	"strings"
+	"unicode"
 
-	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/walletdb"
 	"github.com/guggero/chantools/lnd"
 	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/kvdb"
 	"github.com/spf13/cobra"
 )
 
+var (
+	// channelStateBuckets are the top level buckets of the channel DB
+	// that are changed when removing a channel.
+	channelStateBuckets = [][]byte{
+		[]byte("open-chan-bucket"),
+		[]byte("closed-chan-bucket"),
+		[]byte("historical-chan-bucket"),
+		[]byte("outpoint-bucket"),
+		[]byte("chan-id-bucket"),
+		[]byte("fwd-packages"),
+	}
+)
+
 type removeChannelCommand struct {
-	ChannelDB string
-	Channel   string
+	ChannelDB   string
+	Channel     string
+	ChannelFile string
 
 	cmd *cobra.Command
 }
@@ -23,65 +41,224 @@ func newRemoveChannelCommand() *cobra.Command {
 	cc := &removeChannelCommand{}
 	cc.cmd = &cobra.Command{
 		Use:   "removechannel",
-		Short: "Remove a single channel from the given channel DB",
+		Short: "Remove one or more channels from the given channel DB",
 		Long: `Opens the given channel DB in write mode and removes one
-single channel from it. This means giving up on any state (and therefore coins)
-of that channel and should only be used if the funding transaction of the
-channel was never confirmed on chain!
+or more channels from it. This means giving up on any state (and therefore
+coins) of those channels and should only be used if the funding transaction of
+the channels was never confirmed on chain!
+
+Multiple channels can be removed at once by listing their channel points in a
+file, one per line, with --channelfile. Empty lines and lines starting with #
+are ignored. All channels are removed in one single database transaction, so
+either all or none of them are removed.
+
+Before anything is changed, a copy of the channel DB is written to
+<name>-backup-<timestamp>.db in the working directory (only for the bolt
+database backend). Every bucket and key that is deleted, added or changed is
+logged. Use --dry-run (or the global --dryrun flag) to only see those changes
+without modifying the channel DB.
 
 CAUTION: Running this command will make it impossible to use the channel DB
 with an older version of lnd. Downgrading is not possible and you'll need to
 run lnd v0.16.0-beta or later after using this command!`,
 		Example: `chantools removechannel \
 	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
-	--channel 3149764effbe82718b280de425277e5e7b245a4573aa4a0203ac12cee1c37816:0`,
+	--channel 3149764effbe82718b280de425277e5e7b245a4573aa4a0203ac12cee1c37816:0
+
+chantools removechannel --dry-run \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--channelfile ./channels-to-remove.txt`,
 		RunE: cc.Execute,
 	}
 	cc.cmd.Flags().StringVar(
-		&cc.ChannelDB, "channeldb", "", "lnd channel.backup file to "+
-			"remove the channel from",
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to "+
+			"remove the channels from",
 	)
 	cc.cmd.Flags().StringVar(
 		&cc.Channel, "channel", "", "channel to remove from the DB "+
 			"file, identified by its channel point "+
 			"(<txid>:<txindex>)",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelFile, "channelfile", "", "file with the channel "+
+			"points of all channels to remove, one per line; "+
+			"specify '-' to read from stdin",
+	)
+	cc.cmd.Flags().BoolVar(
+		&DryRun, "dry-run", false, "only log the buckets and keys "+
+			"that would be changed without modifying the "+
+			"channel DB; same as the global --dryrun flag",
+	)
 
 	return cc.cmd
 }
 
 func (c *removeChannelCommand) Execute(_ *cobra.Command, _ []string) error {
 	// Check that we have a channel DB.
-	if c.ChannelDB == "" {
+	if !channelDBGiven(c.ChannelDB) {
 		return fmt.Errorf("channel DB is required")
 	}
-	db, err := lnd.OpenDB(c.ChannelDB, false)
+
+	chanPoints, err := c.channelPoints()
+	if err != nil {
+		return err
+	}
+
+	if !DryRun {
+		if err := backupChannelDB(c.ChannelDB); err != nil {
+			return err
+		}
+	}
+
+	cfg := *dbConfig
+	cfg.Path = c.ChannelDB
+	if cfg.Backend == lnd.DBBackendPostgres && cfg.PostgresDSN == "" {
+		return fmt.Errorf("postgres DSN is required")
+	}
+	backend, err := lnd.OpenBackend(&cfg, false, false)
 	if err != nil {
 		return fmt.Errorf("error opening channel DB: %w", err)
 	}
 	defer func() {
-		if err := db.Close(); err != nil {
-			log.Errorf("Error closing DB: %w", err)
+		if err := backend.Close(); err != nil {
+			log.Errorf("Error closing DB: %v", err)
 		}
 	}()
 
-	parts := strings.Split(c.Channel, ":")
-	if len(parts) != 2 {
-		return fmt.Errorf("invalid channel point format: %v", c.Channel)
-	}
-	hash, err := chainhash.NewHashFromStr(parts[0])
+	result, err := removeChannels(backend, chanPoints)
 	if err != nil {
 		return err
 	}
-	index, err := strconv.ParseUint(parts[1], 10, 64)
+
+	return printJSON(result)
+}
+
+// channelPoints returns the channel points given with the --channel and
+// --channelfile flags.
+func (c *removeChannelCommand) channelPoints() ([]*wire.OutPoint, error) {
+	var lines []string
+	if c.Channel != "" {
+		lines = append(lines, c.Channel)
+	}
+	if c.ChannelFile != "" {
+		content, err := readInput(c.ChannelFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading channel file: "+
+				"%w", err)
+		}
+		lines = append(lines, strings.Split(string(content), "\n")...)
+	}
+
+	var (
+		chanPoints []*wire.OutPoint
+		known      = make(map[wire.OutPoint]bool)
+	)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		chanPoint, err := lnd.ParseOutpoint(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid channel point %s: %w",
+				line, err)
+		}
+		if known[*chanPoint] {
+			continue
+		}
+		known[*chanPoint] = true
+		chanPoints = append(chanPoints, chanPoint)
+	}
+	if len(chanPoints) == 0 {
+		return nil, fmt.Errorf("at least one channel is required")
+	}
+
+	return chanPoints, nil
+}
+
+// removeChannelResult is the result of the removechannel command.
+type removeChannelResult struct {
+	Channels []string `json:"channels"`
+	Deleted  []string `json:"deleted"`
+	Added    []string `json:"added"`
+	Changed  []string `json:"changed"`
+	DryRun   bool     `json:"dry_run"`
+}
+
+// removeChannels removes all given channels from the channel DB in one single
+// transaction and returns all buckets and keys that were changed. If the
+// global --dryrun flag is set, the transaction is rolled back instead of
+// committed.
+func removeChannels(backend kvdb.Backend,
+	chanPoints []*wire.OutPoint) (*removeChannelResult, error) {
+
+	batch, err := lnd.NewBatchBackend(backend)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error starting transaction: %w", err)
 	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = batch.Rollback()
+		}
+	}()
 
-	return removeChannel(db.ChannelStateDB(), &wire.OutPoint{
-		Hash:  *hash,
-		Index: uint32(index),
-	})
+	db, err := channeldb.CreateWithBackend(
+		batch, channeldb.OptionSetUseGraphCache(false),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error opening channel DB: %w", err)
+	}
+
+	before, err := snapshotBuckets(batch, channelStateBuckets)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &removeChannelResult{
+		DryRun: DryRun,
+	}
+	for _, chanPoint := range chanPoints {
+		log.Infof("Removing channel %v", chanPoint)
+		err := removeChannel(db.ChannelStateDB(), chanPoint)
+		if err != nil {
+			return nil, fmt.Errorf("error removing channel %v, "+
+				"no channel was removed: %w", chanPoint, err)
+		}
+		result.Channels = append(result.Channels, chanPoint.String())
+	}
+
+	after, err := snapshotBuckets(batch, channelStateBuckets)
+	if err != nil {
+		return nil, err
+	}
+	result.Deleted, result.Added, result.Changed = diffSnapshots(
+		before, after,
+	)
+	for _, key := range result.Deleted {
+		log.Infof("Deleted %s", key)
+	}
+	for _, key := range result.Added {
+		log.Infof("Added %s", key)
+	}
+	for _, key := range result.Changed {
+		log.Infof("Changed %s", key)
+	}
+
+	if DryRun {
+		log.Infof("Dry run, not removing %d channels",
+			len(result.Channels))
+		return result, nil
+	}
+
+	if err := batch.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+	committed = true
+	log.Infof("Removed %d channels", len(result.Channels))
+
+	return result, nil
 }
 
 func removeChannel(db *channeldb.ChannelStateDB,
@@ -103,3 +280,94 @@ func removeChannel(db *channeldb.ChannelStateDB,
 	// intended to be idempotent.
 	return db.AbandonChannel(chanPoint, uint32(100000))
 }
+
+// snapshotBuckets returns the path of every bucket and key within the given
+// top level buckets, mapped to the hash of the key's value. Buckets are mapped
+// to an empty string.
+func snapshotBuckets(db kvdb.Backend,
+	topLevelBuckets [][]byte) (map[string]string, error) {
+
+	snapshot := make(map[string]string)
+	var walk func(bucket walletdb.ReadBucket, path string) error
+	walk = func(bucket walletdb.ReadBucket, path string) error {
+		snapshot[path] = ""
+		return bucket.ForEach(func(k, v []byte) error {
+			keyPath := path + "/" + formatKey(k)
+
+			// The SQL backends return nil for empty values too.
+			var nested walletdb.ReadBucket
+			if v == nil {
+				nested = bucket.NestedReadBucket(k)
+			}
+			if nested != nil {
+				return walk(nested, keyPath)
+			}
+
+			hash := sha256.Sum256(v)
+			snapshot[keyPath] = hex.EncodeToString(hash[:])
+			return nil
+		})
+	}
+
+	err := kvdb.View(db, func(tx kvdb.RTx) error {
+		for _, name := range topLevelBuckets {
+			bucket := tx.ReadBucket(name)
+			if bucket == nil {
+				continue
+			}
+			if err := walk(bucket, formatKey(name)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func() {
+		snapshot = make(map[string]string)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading channel DB: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// diffSnapshots returns the sorted paths of all buckets and keys that were
+// deleted, added or changed between the two snapshots.
+func diffSnapshots(before, after map[string]string) ([]string, []string,
+	[]string) {
+
+	var deleted, added, changed []string
+	for path, value := range before {
+		newValue, ok := after[path]
+		switch {
+		case !ok:
+			deleted = append(deleted, path)
+
+		case newValue != value:
+			changed = append(changed, path)
+		}
+	}
+	for path := range after {
+		if _, ok := before[path]; !ok {
+			added = append(added, path)
+		}
+	}
+
+	sort.Strings(deleted)
+	sort.Strings(added)
+	sort.Strings(changed)
+
+	return deleted, added, changed
+}
+
+// formatKey returns the key as a string if it only consists of printable
+// characters and hex encoded otherwise.
+func formatKey(key []byte) string {
+	for _, r := range string(key) {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) || r == '/' {
+			return hex.EncodeToString(key)
+		}
+	}
+
+	return string(key)
+}
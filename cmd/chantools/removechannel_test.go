@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/guggero/chantools/lnd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveChannels(t *testing.T) {
+	h := newHarness(t)
+
+	channelDB, err := ioutil.ReadFile(h.testdataFile("channel.db"))
+	require.NoError(t, err)
+	dbFile := h.tempFile("channel.db")
+	require.NoError(t, ioutil.WriteFile(dbFile, channelDB, 0600))
+
+	const (
+		chan1 = "10279f62619634058b6133cb7ac6c1693a8e6df7caa91c6263ca" +
+			"3d0bf704ad4d:0"
+		chan2 = "9e7004ccf0cb19eb2d967aa0142e3476b4d27874da7048e61b61" +
+			"fdbacf9200d3:0"
+		unknownChan = "0000000000000000000000000000000000000000000000" +
+			"000000000000000001:0"
+	)
+	channelFile := h.tempFile("channels.txt")
+	require.NoError(t, ioutil.WriteFile(channelFile, []byte(strings.Join(
+		[]string{"# channels to remove", chan1, "", chan2, chan1},
+		"\n",
+	)), 0600))
+
+	numOpenChannels := func() int {
+		db, err := openChannelDB(dbFile, true)
+		require.NoError(t, err)
+		defer func() { _ = db.Close() }()
+
+		channels, err := db.ChannelStateDB().FetchAllOpenChannels()
+		require.NoError(t, err)
+		return len(channels)
+	}
+	require.Equal(t, 4, numOpenChannels())
+
+	// A dry run shows the changes without writing them. The --dry-run
+	// flag of the command is the same as the global --dryrun flag.
+	defer func() { DryRun = false }()
+	err = newRemoveChannelCommand().ParseFlags([]string{"--dry-run"})
+	require.NoError(t, err)
+	require.True(t, DryRun)
+
+	remove := &removeChannelCommand{
+		ChannelDB:   dbFile,
+		ChannelFile: channelFile,
+	}
+	chanPoints, err := remove.channelPoints()
+	require.NoError(t, err)
+	require.Len(t, chanPoints, 2)
+
+	backend, err := lnd.OpenBackend(
+		&lnd.DBConfig{Path: dbFile}, false, false,
+	)
+	require.NoError(t, err)
+	result, err := removeChannels(backend, chanPoints)
+	require.NoError(t, err)
+	require.NoError(t, backend.Close())
+
+	require.True(t, result.DryRun)
+	require.Equal(t, []string{chan1, chan2}, result.Channels)
+	require.NotEmpty(t, result.Deleted)
+	require.NotEmpty(t, result.Added)
+	require.NotEmpty(t, result.Changed)
+	for _, path := range result.Deleted {
+		require.True(t, strings.HasPrefix(path, "open-chan-bucket/") ||
+			strings.HasPrefix(path, "fwd-packages/"), path)
+	}
+	h.assertLogContains("Dry run, not removing 2 channels")
+	require.Equal(t, 4, numOpenChannels())
+
+	// If any of the channels can't be removed, none of them is.
+	DryRun = false
+	remove.Channel = unknownChan
+	require.Error(t, remove.Execute(nil, nil))
+	require.Equal(t, 4, numOpenChannels())
+
+	remove.Channel = ""
+	require.NoError(t, remove.Execute(nil, nil))
+	require.Equal(t, 2, numOpenChannels())
+
+	// The DB was backed up before every attempt to remove channels.
+	backups, err := filepath.Glob(
+		filepath.Join(h.tempDir, "channel-backup-*.db"),
+	)
+	require.NoError(t, err)
+	require.NotEmpty(t, backups)
+}
@@ -1,49 +1,54 @@
 package main
 
 import (
-	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"regexp"
-	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/dataformat"
 	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/rescue"
+	"github.com/guggero/chantools/sweep"
 	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/input"
-	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
 	"github.com/spf13/cobra"
 )
 
-var (
-	cacheSize = 5000
-	cache     []*cacheEntry
-
-	errAddrNotFound = errors.New("addr not found")
+const (
+	defaultRescueNumKeys = 5000
+)
 
+var (
 	patternCommitPoint = regexp.MustCompile(`commit_point=([0-9a-f]{66})`)
 )
 
-type cacheEntry struct {
-	privKey *btcec.PrivateKey
-	pubKey  *btcec.PublicKey
-}
-
 type rescueClosedCommand struct {
 	ChannelDB   string
 	Addr        string
 	CommitPoint string
 	LndLog      string
 
-	rootKey *rootKey
-	inputs  *inputFlags
-	cmd     *cobra.Command
+	CommitPointRange uint64
+	NumKeys          int
+
+	SweepAddr string
+	FeeRate   uint16
+	Publish   bool
+
+	rootKey  *rootKey
+	inputs   *inputFlags
+	chainAPI *chainAPIFlags
+	cmd      *cobra.Command
 }
 
 func newRescueClosedCommand() *cobra.Command {
@@ -60,6 +65,30 @@ funds from those channels. But this method can help if the other node doesn't
 know about the channels any more but we still have the channel.db from the
 moment they force-closed.
 
+For anchor channels, the to_remote output is a P2WSH output that can only be
+spent after one confirmation. Private keys found for such outputs are printed as
+a descriptor that can be imported into a bitcoind descriptor wallet.
+
+If the channel DB is partially corrupted, the commit points stored in it might
+not match the force close transaction. With --commit_point_range the commit
+points of previous remote states are derived from the remote revocation secrets
+(shachain) in the channel DB and tried as well.
+
+If only the --channeldb flag is given without any of the channel input flags,
+all channels stored in the channel DB (open and closed ones) are looked up on
+chain directly and the recovery is attempted for every channel that was force
+closed, all in one pass. The result of all channels is written to a single
+results file.
+
+Channels that were opened before the static_remote_key feature was available
+have their to_remote output tweaked with the commit point of the remote state.
+To find the private key of such an output, every commit point is combined with
+the first --num_keys payment base point keys of the wallet. Increase this value
+if the node opened or accepted a lot of channels. If --sweepaddr is set, the
+P2WPKH to_remote outputs the private key was found for are swept to that address
+directly. The to_remote outputs of anchor channels still need to be imported
+into bitcoind.
+
 The alternative use case for this command is if you got the commit point by
 running the fund-recovery branch of my guggero/lnd fork (see 
 https://github.com/guggero/lnd/releases for a binary release) in combination
@@ -76,6 +105,21 @@ debuglevel is set to debug (lnd.conf, set 'debuglevel=debug').`,
 	--fromsummary results/summary-xxxxxx.json \
 	--channeldb ~/.lnd/data/graph/mainnet/channel.db
 
+chantools rescueclosed \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db
+
+chantools rescueclosed \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--num_keys 20000 \
+	--sweepaddr bc1q..... \
+	--feerate 10 \
+	--publish
+
+chantools rescueclosed \
+	--fromsummary results/summary-xxxxxx.json \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--commit_point_range 1000
+
 chantools rescueclosed --force_close_addr bc1q... --commit_point 03xxxx
 
 chantools rescueclosed --fromsummary results/summary-xxxxxx.json \
@@ -99,8 +143,34 @@ chantools rescueclosed --fromsummary results/summary-xxxxxx.json \
 		&cc.LndLog, "lnd_log", "", "the lnd log file to read to get "+
 			"the commit_point values when rescuing multiple "+
 			"channels at the same time")
+	cc.cmd.Flags().Uint64Var(
+		&cc.CommitPointRange, "commit_point_range", 0, "if the "+
+			"commit points stored in the channel DB are wrong, "+
+			"also try the commit points of this many previous "+
+			"remote states, derived from the remote revocation "+
+			"secrets (shachain) in the channel DB; only used "+
+			"together with --channeldb")
+	cc.cmd.Flags().IntVar(
+		&cc.NumKeys, "num_keys", defaultRescueNumKeys, "the number of "+
+			"payment base point key indices to combine with each "+
+			"commit point when brute forcing the private key of a "+
+			"tweaked to_remote output")
+	cc.cmd.Flags().StringVar(
+		&cc.SweepAddr, "sweepaddr", "", "address to sweep the P2WPKH "+
+			"to_remote outputs the private key was found for to; "+
+			"only used together with --channeldb or --lnd_log",
+	)
+	cc.cmd.Flags().Uint16Var(
+		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
+			"use for the sweep transaction in sat/vByte",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Publish, "publish", false, "publish sweep TX to the chain "+
+			"API instead of just printing the TX",
+	)
 	cc.rootKey = newRootKey(cc.cmd, "decrypting the backup")
 	cc.inputs = newInputFlags(cc.cmd)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
 
 	return cc.cmd
 }
@@ -111,27 +181,47 @@ func (c *rescueClosedCommand) Execute(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("error reading root key: %w", err)
 	}
 
+	var sweepScript []byte
+	if c.SweepAddr != "" {
+		sweepScript, err = lnd.GetP2WPKHScript(c.SweepAddr, chainParams)
+		if err != nil {
+			return err
+		}
+	}
+
 	// What way of recovery has the user chosen? From summary and DB or from
 	// address and commit point?
+	var (
+		entries      []*dataformat.SummaryEntry
+		commitPoints []*btcec.PublicKey
+	)
 	switch {
-	case c.ChannelDB != "":
-		db, err := lnd.OpenDB(c.ChannelDB, true)
+	case channelDBGiven(c.ChannelDB):
+		db, err := openChannelDB(c.ChannelDB, true)
 		if err != nil {
 			return fmt.Errorf("error opening rescue DB: %w", err)
 		}
 
-		// Parse channel entries from any of the possible input files.
-		entries, err := c.inputs.parseInputType()
+		// Without any channel input, we look up all channels of the DB
+		// on chain ourselves.
+		if c.inputs.empty() {
+			entries, err = closedEntriesFromDB(
+				c.chainAPI.api(), db.ChannelStateDB(),
+			)
+		} else {
+			entries, err = c.inputs.parseInputType()
+		}
 		if err != nil {
 			return err
 		}
 
-		commitPoints, err := commitPointsFromDB(db.ChannelStateDB())
+		commitPoints, err = commitPointsFromDB(
+			db.ChannelStateDB(), c.CommitPointRange,
+		)
 		if err != nil {
 			return fmt.Errorf("error reading commit points from "+
 				"db: %w", err)
 		}
-		return rescueClosedChannels(extendedKey, entries, commitPoints)
 
 	case c.Addr != "":
 		// First parse address to get targetPubKeyHash from it later.
@@ -151,31 +241,58 @@ func (c *rescueClosedCommand) Execute(_ *cobra.Command, _ []string) error {
 			return fmt.Errorf("error parsing commit point: %w", err)
 		}
 
-		return rescueClosedChannel(extendedKey, targetAddr, commitPoint)
+		return rescueClosedChannel(
+			extendedKey, targetAddr, commitPoint, c.NumKeys,
+		)
 
 	case c.LndLog != "":
 		// Parse channel entries from any of the possible input files.
-		entries, err := c.inputs.parseInputType()
+		entries, err = c.inputs.parseInputType()
 		if err != nil {
 			return err
 		}
 
-		commitPoints, err := commitPointsFromLogFile(c.LndLog)
+		commitPoints, err = commitPointsFromLogFile(c.LndLog)
 		if err != nil {
 			return fmt.Errorf("error parsing commit points from "+
 				"log file: %w", err)
 		}
-		return rescueClosedChannels(extendedKey, entries, commitPoints)
 
 	default:
 		return fmt.Errorf("you either need to specify --channeldb and " +
 			"--fromsummary or --force_close_addr and " +
 			"--commit_point but not a mixture of them")
 	}
+
+	err = rescueClosedChannels(
+		extendedKey, entries, commitPoints, c.NumKeys,
+	)
+	if err != nil || sweepScript == nil {
+		return err
+	}
+
+	api := c.chainAPI.api()
+	c.FeeRate, err = sweepFeeRate(c.cmd, api, c.FeeRate)
+	if err != nil {
+		return err
+	}
+	sweepTx, inputs, err := sweepRescuedOutputs(
+		api, entries, sweepScript, c.FeeRate,
+	)
+	if err != nil {
+		return err
+	}
+
+	results := newTxResults()
+	if err := results.addTx(api, sweepTx, inputs, c.Publish); err != nil {
+		return err
+	}
+
+	return results.print()
 }
 
-func commitPointsFromDB(chanDb *channeldb.ChannelStateDB) ([]*btcec.PublicKey,
-	error) {
+func commitPointsFromDB(chanDb *channeldb.ChannelStateDB,
+	commitPointRange uint64) ([]*btcec.PublicKey, error) {
 
 	var result []*btcec.PublicKey
 
@@ -195,9 +312,141 @@ func commitPointsFromDB(chanDb *channeldb.ChannelStateDB) ([]*btcec.PublicKey,
 		}
 	}
 
+	// Channels that lnd already knows to be closed have their last commit
+	// points in the close summary.
+	closedChannels, err := chanDb.FetchClosedChannels(false)
+	if err != nil {
+		return nil, err
+	}
+	for _, channel := range closedChannels {
+		if channel.RemoteNextRevocation != nil {
+			result = append(result, channel.RemoteNextRevocation)
+		}
+
+		if channel.RemoteCurrentRevocation != nil {
+			result = append(result, channel.RemoteCurrentRevocation)
+		}
+	}
+
+	if commitPointRange == 0 {
+		return result, nil
+	}
+
+	// If the stored commit points are wrong, the remote party might have
+	// force closed with a previous state. We can derive the commit points
+	// of all previous remote states from the revocation secrets they gave
+	// us.
+	numBefore := len(result)
+	for _, channel := range channels {
+		result = append(result, revokedCommitPoints(
+			channel, commitPointRange,
+		)...)
+	}
+
+	log.Infof("Derived %d additional commit points from the remote "+
+		"revocation secrets", len(result)-numBefore)
+
 	return result, nil
 }
 
+// closedEntriesFromDB looks up all channels of the channel DB on chain and
+// returns the ones that were force closed.
+func closedEntriesFromDB(api btc.ChainAPI,
+	chanDb *channeldb.ChannelStateDB) ([]*dataformat.SummaryEntry, error) {
+
+	entries, err := allEntriesFromDB(chanDb)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Looking up %d channels of the channel DB on chain",
+		len(entries))
+
+	summaryFile, err := btc.SummarizeChannels(
+		api, entries, defaultSummaryWorkers, nil,
+		&btc.SummaryFilter{ForceCloseOnly: true}, log,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up channels: %w", err)
+	}
+
+	log.Infof("Found %d force closed channels", len(summaryFile.Channels))
+
+	return summaryFile.Channels, nil
+}
+
+// allEntriesFromDB returns the channels of the channel DB, including the ones
+// lnd already knows to be closed.
+func allEntriesFromDB(
+	chanDb *channeldb.ChannelStateDB) ([]*dataformat.SummaryEntry, error) {
+
+	dbFile := &dataformat.ChannelDBFile{DB: chanDb}
+	entries, err := dbFile.AsSummaryEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	// The DB of a node that went offline usually still has all channels
+	// as open, but we also add the ones lnd knows to be closed.
+	known := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		known[entry.ChannelPoint] = struct{}{}
+	}
+	closedChannels, err := chanDb.FetchClosedChannels(false)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching closed channels: %w",
+			err)
+	}
+	for _, channel := range closedChannels {
+		chanPoint := channel.ChanPoint.String()
+		if _, ok := known[chanPoint]; ok {
+			continue
+		}
+
+		entries = append(entries, &dataformat.SummaryEntry{
+			RemotePubkey: hex.EncodeToString(
+				channel.RemotePub.SerializeCompressed(),
+			),
+			ChannelPoint:   chanPoint,
+			FundingTXID:    channel.ChanPoint.Hash.String(),
+			FundingTXIndex: channel.ChanPoint.Index,
+			Capacity:       uint64(channel.Capacity),
+			LocalBalance:   uint64(channel.SettledBalance),
+		})
+	}
+
+	return entries, nil
+}
+
+// revokedCommitPoints derives the commit points of up to commitPointRange of
+// the most recent revoked remote states of a channel from the remote
+// revocation secrets stored in the channel's shachain store. Secrets that
+// can't be derived (for example because the store is corrupted) are skipped.
+func revokedCommitPoints(channel *channeldb.OpenChannel,
+	commitPointRange uint64) []*btcec.PublicKey {
+
+	if channel.RevocationStore == nil {
+		return nil
+	}
+
+	// The current remote commitment isn't revoked yet, so the most recent
+	// secret we can have is the one of the state before.
+	height := channel.RemoteCommitment.CommitHeight
+	var result []*btcec.PublicKey
+	for i := uint64(0); i < commitPointRange && height > 0; i++ {
+		height--
+
+		secret, err := channel.RevocationStore.LookUp(height)
+		if err != nil {
+			continue
+		}
+
+		result = append(result, input.ComputeCommitmentPoint(secret[:]))
+	}
+
+	return result
+}
+
 func commitPointsFromLogFile(lndLog string) ([]*btcec.PublicKey, error) {
 	logFileBytes, err := ioutil.ReadFile(lndLog)
 	if err != nil {
@@ -238,62 +487,29 @@ func commitPointsFromLogFile(lndLog string) ([]*btcec.PublicKey, error) {
 
 func rescueClosedChannels(extendedKey *hdkeychain.ExtendedKey,
 	entries []*dataformat.SummaryEntry,
-	possibleCommitPoints []*btcec.PublicKey) error {
+	possibleCommitPoints []*btcec.PublicKey, numKeys int) error {
 
-	err := fillCache(extendedKey)
+	keyCache, err := rescue.NewKeyCache(
+		extendedKey, numKeys, chainParams, log,
+	)
 	if err != nil {
 		return err
 	}
 
-	// Add a nil commit point to the list of possible commit points to also
-	// try brute forcing a static_remote_key address.
-	possibleCommitPoints = append(possibleCommitPoints, nil)
-
-	// We'll also keep track of all rescued keys for an additional log
-	// output.
-	resultMap := make(map[string]string)
-
-	// Try naive/lucky guess by trying out all combinations.
-outer:
-	for _, entry := range entries {
-		// Don't try anything with open channels, fully closed channels
-		// or channels where we already have the private key.
-		if entry.ClosingTX == nil ||
-			entry.ClosingTX.AllOutsSpent ||
-			(entry.ClosingTX.OurAddr == "" &&
-				entry.ClosingTX.ToRemoteAddr == "") ||
-			entry.ClosingTX.SweepPrivkey != "" {
-
-			continue
-		}
-
-		// Try with every possible commit point now.
-		for _, commitPoint := range possibleCommitPoints {
-			addr := entry.ClosingTX.OurAddr
-			if addr == "" {
-				addr = entry.ClosingTX.ToRemoteAddr
-			}
-
-			wif, err := addrInCache(addr, commitPoint)
-			switch {
-			case err == nil:
-				entry.ClosingTX.SweepPrivkey = wif
-				resultMap[addr] = wif
-
-				continue outer
-
-			case errors.Is(err, errAddrNotFound):
-
-			default:
-				return err
-			}
-		}
+	resultMap, err := keyCache.RescueChannels(
+		entries, possibleCommitPoints,
+	)
+	if err != nil {
+		return err
 	}
 
 	importStr := ""
 	for addr, wif := range resultMap {
-		importStr += fmt.Sprintf(`importprivkey "%s" "%s" false%s`, wif,
-			addr, "\n")
+		cmd, err := rescue.ImportCommand(addr, wif, chainParams)
+		if err != nil {
+			return err
+		}
+		importStr += cmd + "\n"
 	}
 	log.Infof("Found %d private keys! Import them into bitcoind through "+
 		"the console by pasting: \n%srescanblockchain 481824\n",
@@ -305,174 +521,151 @@ outer:
 	if err != nil {
 		return err
 	}
-	fileName := fmt.Sprintf("results/rescueclosed-%s.json",
-		time.Now().Format("2006-01-02-15-04-05"))
+	fileName := resultFileName("rescueclosed", "json")
 	log.Infof("Writing result to %s", fileName)
 	return ioutil.WriteFile(fileName, summaryBytes, 0644)
 }
 
 func rescueClosedChannel(extendedKey *hdkeychain.ExtendedKey,
-	addr btcutil.Address, commitPoint *btcec.PublicKey) error {
+	addr btcutil.Address, commitPoint *btcec.PublicKey,
+	numKeys int) error {
 
-	// Make the check on the decoded address according to the active
-	// network (testnet or mainnet only).
-	if !addr.IsForNet(chainParams) {
-		return fmt.Errorf("address: %v is not valid for this network: "+
-			"%v", addr, chainParams.Name)
-	}
-
-	// Must be a bech32 native SegWit address.
-	switch addr.(type) {
-	case *btcutil.AddressWitnessPubKeyHash:
-		log.Infof("Brute forcing private key for tweaked public key "+
-			"hash %x\n", addr.ScriptAddress())
-
-	default:
-		return fmt.Errorf("address: must be a bech32 P2WPKH address")
-	}
-
-	err := fillCache(extendedKey)
+	keyCache, err := rescue.NewKeyCache(
+		extendedKey, numKeys, chainParams, log,
+	)
 	if err != nil {
 		return err
 	}
 
-	wif, err := addrInCache(addr.String(), commitPoint)
-	switch {
-	case err == nil:
-		log.Infof("Found private key %s for address %v!", wif, addr)
-
-		return nil
-
-	case errors.Is(err, errAddrNotFound):
-		// Try again as a static_remote_key.
-
-	default:
+	wif, err := keyCache.RescueAddress(addr, commitPoint)
+	if err != nil {
 		return err
 	}
 
-	// Try again as a static_remote_key address.
-	wif, err = addrInCache(addr.String(), nil)
-	switch {
-	case err == nil:
-		log.Infof("Found private key %s for address %v!", wif, addr)
-
-		return nil
-
-	case errors.Is(err, errAddrNotFound):
-		return fmt.Errorf("did not find private key for address %v",
-			addr)
+	log.Infof("Found private key %s for address %v!", wif, addr)
 
-	default:
-		return err
-	}
+	return nil
 }
 
-func addrInCache(addr string, perCommitPoint *btcec.PublicKey) (string, error) {
-	targetPubKeyHash, scriptHash, err := lnd.DecodeAddressHash(
-		addr, chainParams,
-	)
-	if err != nil {
-		return "", fmt.Errorf("error parsing addr: %w", err)
-	}
-	if scriptHash {
-		return "", fmt.Errorf("address must be a P2WPKH address")
-	}
+// sweepRescuedOutputs creates and signs a transaction that sweeps the unspent
+// P2WPKH to_remote outputs of all closed channels the private key was found
+// for. The 1-CSV encumbered to_remote outputs of anchor channels are skipped,
+// those need to be imported into bitcoind.
+func sweepRescuedOutputs(api btc.ChainAPI, entries []*dataformat.SummaryEntry,
+	sweepScript []byte, feeRate uint16) (*wire.MsgTx, []*txResultInput,
+	error) {
 
-	// If the commit point is nil, we try with plain private keys to match
-	// static_remote_key outputs.
-	if perCommitPoint == nil {
-		for i := 0; i < cacheSize; i++ {
-			cacheEntry := cache[i]
-			hashedPubKey := btcutil.Hash160(
-				cacheEntry.pubKey.SerializeCompressed(),
-			)
-			equal := subtle.ConstantTimeCompare(
-				targetPubKeyHash, hashedPubKey,
-			)
-			if equal == 1 {
-				wif, err := btcutil.NewWIF(
-					cacheEntry.privKey, chainParams, true,
-				)
-				if err != nil {
-					return "", err
-				}
-				log.Infof("The private key for addr %s "+
-					"(static_remote_key) found after "+
-					"%d tries: %s", addr, i, wif.String(),
-				)
-				return wif.String(), nil
-			}
+	var (
+		estimator      input.TxWeightEstimator
+		sweepTx        = wire.NewMsgTx(2)
+		inputs         []*txResultInput
+		privKeys       []*btcec.PrivateKey
+		prevOutFetcher = txscript.NewMultiPrevOutFetcher(nil)
+		totalValue     int64
+	)
+	for _, entry := range entries {
+		if entry.ClosingTX == nil || entry.ClosingTX.SweepPrivkey == "" {
+			continue
 		}
 
-		return "", errAddrNotFound
-	}
-
-	// Loop through all cached payment base point keys, tweak each of it
-	// with the per_commit_point and see if the hashed public key
-	// corresponds to the target pubKeyHash of the given address.
-	for i := 0; i < cacheSize; i++ {
-		cacheEntry := cache[i]
-		basePoint := cacheEntry.pubKey
-		tweakedPubKey := input.TweakPubKey(basePoint, perCommitPoint)
-		tweakBytes := input.SingleTweakBytes(perCommitPoint, basePoint)
-		tweakedPrivKey := input.TweakPrivKey(
-			cacheEntry.privKey, tweakBytes,
-		)
-		hashedPubKey := btcutil.Hash160(
-			tweakedPubKey.SerializeCompressed(),
-		)
-		equal := subtle.ConstantTimeCompare(
-			targetPubKeyHash, hashedPubKey,
-		)
-		if equal == 1 {
-			wif, err := btcutil.NewWIF(
-				tweakedPrivKey, chainParams, true,
-			)
-			if err != nil {
-				return "", err
-			}
-			log.Infof("The private key for addr %s found after "+
-				"%d tries: %s", addr, i, wif.String(),
-			)
-			return wif.String(), nil
+		addr := entry.ClosingTX.OurAddr
+		if addr == "" {
+			addr = entry.ClosingTX.ToRemoteAddr
 		}
-	}
-
-	return "", errAddrNotFound
-}
-
-func fillCache(extendedKey *hdkeychain.ExtendedKey) error {
-	cache = make([]*cacheEntry, cacheSize)
-
-	for i := 0; i < cacheSize; i++ {
-		key, err := lnd.DeriveChildren(extendedKey, []uint32{
-			lnd.HardenedKeyStart + uint32(keychain.BIP0043Purpose),
-			lnd.HardenedKeyStart + chainParams.HDCoinType,
-			lnd.HardenedKeyStart +
-				uint32(keychain.KeyFamilyPaymentBase),
-			0,
-			uint32(i),
-		})
+		parsedAddr, err := btcutil.DecodeAddress(addr, chainParams)
 		if err != nil {
-			return err
+			return nil, nil, fmt.Errorf("error parsing addr: %w",
+				err)
+		}
+		if _, ok := parsedAddr.(*btcutil.AddressWitnessPubKeyHash); !ok {
+			log.Infof("Not sweeping %s of channel %s, import the "+
+				"private key into bitcoind instead", addr,
+				entry.ChannelPoint)
+			continue
 		}
-		privKey, err := key.ECPrivKey()
+		pkScript, err := txscript.PayToAddrScript(parsedAddr)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		pubKey, err := key.ECPubKey()
+		wif, err := btcutil.DecodeWIF(entry.ClosingTX.SweepPrivkey)
 		if err != nil {
-			return err
+			return nil, nil, fmt.Errorf("error decoding private "+
+				"key: %w", err)
+		}
+
+		tx, err := api.Transaction(entry.ClosingTX.TXID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error fetching closing "+
+				"transaction %s: %w", entry.ClosingTX.TXID, err)
+		}
+		txHash, err := chainhash.NewHashFromStr(entry.ClosingTX.TXID)
+		if err != nil {
+			return nil, nil, err
 		}
-		cache[i] = &cacheEntry{
-			privKey: privKey,
-			pubKey:  pubKey,
+		for idx, vout := range tx.Vout {
+			if vout.ScriptPubkeyAddr != addr ||
+				(vout.Outspend != nil && vout.Outspend.Spent) {
+
+				continue
+			}
+
+			outpoint := wire.OutPoint{
+				Hash:  *txHash,
+				Index: uint32(idx),
+			}
+			sweepTx.AddTxIn(&wire.TxIn{
+				PreviousOutPoint: outpoint,
+				Sequence:         wire.MaxTxInSequenceNum,
+			})
+			prevOutFetcher.AddPrevOut(outpoint, &wire.TxOut{
+				Value:    int64(vout.Value),
+				PkScript: pkScript,
+			})
+			estimator.AddP2WKHInput()
+			totalValue += int64(vout.Value)
+			privKeys = append(privKeys, wif.PrivKey)
+			inputs = append(inputs, &txResultInput{
+				Outpoint:     outpoint.String(),
+				Value:        int64(vout.Value),
+				ChannelPoint: entry.ChannelPoint,
+				Addr:         addr,
+			})
 		}
+	}
+	if len(sweepTx.TxIn) == 0 {
+		return nil, nil, fmt.Errorf("no unspent P2WPKH outputs found " +
+			"to sweep")
+	}
 
-		if i > 0 && i%10000 == 0 {
-			fmt.Printf("Filled cache with %d of %d keys.\n",
-				i, cacheSize)
+	estimator.AddP2WKHOutput()
+	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
+	totalFee := feeRateKWeight.FeeForWeight(int64(estimator.Weight()))
+	if totalValue-int64(totalFee) < sweep.DustLimit {
+		return nil, nil, fmt.Errorf("total value of %d satoshis minus "+
+			"the fee is below the dust limit", totalValue)
+	}
+	log.Infof("Fee %d sats of %d total amount (estimated weight %d)",
+		totalFee, totalValue, estimator.Weight())
+
+	sweepTx.AddTxOut(&wire.TxOut{
+		Value:    totalValue - int64(totalFee),
+		PkScript: sweepScript,
+	})
+
+	sigHashes := txscript.NewTxSigHashes(sweepTx, prevOutFetcher)
+	for idx, txIn := range sweepTx.TxIn {
+		prevOut := prevOutFetcher.FetchPrevOutput(txIn.PreviousOutPoint)
+		witness, err := txscript.WitnessSignature(
+			sweepTx, sigHashes, idx, prevOut.Value,
+			prevOut.PkScript, txscript.SigHashAll, privKeys[idx],
+			true,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error signing input %d: "+
+				"%w", idx, err)
 		}
+		txIn.Witness = witness
 	}
-	return nil
+
+	return sweepTx, inputs, nil
 }
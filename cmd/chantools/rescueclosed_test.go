@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/rescue"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/shachain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevokedCommitPoints(t *testing.T) {
+	producer := shachain.NewRevocationProducer(chainhash.Hash{1, 2, 3})
+	store := shachain.NewRevocationStore()
+
+	// The remote party revoked the states 0 to 9 and is now at state 10.
+	for i := uint64(0); i < 10; i++ {
+		secret, err := producer.AtIndex(i)
+		require.NoError(t, err)
+		require.NoError(t, store.AddNextEntry(secret))
+	}
+	channel := &channeldb.OpenChannel{
+		RevocationStore: store,
+		RemoteCommitment: channeldb.ChannelCommitment{
+			CommitHeight: 10,
+		},
+	}
+
+	points := revokedCommitPoints(channel, 3)
+	require.Len(t, points, 3)
+	for i, height := range []uint64{9, 8, 7} {
+		secret, err := producer.AtIndex(height)
+		require.NoError(t, err)
+		require.Equal(
+			t, input.ComputeCommitmentPoint(secret[:]), points[i],
+		)
+	}
+
+	// We can't go further back than the first state.
+	require.Len(t, revokedCommitPoints(channel, 100), 10)
+}
+
+func TestAnchorAddrInCache(t *testing.T) {
+	h := newHarness(t)
+
+	rootKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	keyCache, err := rescue.NewKeyCache(rootKey, 3, chainParams, h.logger)
+	require.NoError(t, err)
+
+	signer := &lnd.Signer{
+		ExtendedKey: rootKey,
+		ChainParams: chainParams,
+	}
+	privKey, err := signer.FetchPrivKey(&keychain.KeyDescriptor{
+		KeyLocator: keychain.KeyLocator{
+			Family: keychain.KeyFamilyPaymentBase,
+			Index:  2,
+		},
+	})
+	require.NoError(t, err)
+
+	addr, _, err := lnd.P2AnchorStaticRemote(privKey.PubKey(), chainParams)
+	require.NoError(t, err)
+
+	// The anchor to_remote output isn't tweaked with a commit point.
+	_, err = keyCache.FindKey(addr.String(), privKey.PubKey())
+	require.ErrorIs(t, err, rescue.ErrAddrNotFound)
+
+	wif, err := keyCache.FindKey(addr.String(), nil)
+	require.NoError(t, err)
+
+	expectedWIF, err := btcutil.NewWIF(privKey, chainParams, true)
+	require.NoError(t, err)
+	require.Equal(t, expectedWIF.String(), wif)
+
+	cmd, err := rescue.ImportCommand(addr.String(), wif, chainParams)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(cmd, "importdescriptors"))
+
+	desc := btc.DescriptorSumCreate(
+		"wsh(and_v(v:pk(" + wif + "),older(1)))",
+	)
+	require.Contains(t, cmd, `"desc":"`+desc+`"`)
+}
+
+func TestRescueClosedTweakedSweep(t *testing.T) {
+	h := newHarness(t)
+
+	rootKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	signer := &lnd.Signer{
+		ExtendedKey: rootKey,
+		ChainParams: chainParams,
+	}
+	baseKey, err := signer.FetchPrivKey(&keychain.KeyDescriptor{
+		KeyLocator: keychain.KeyLocator{
+			Family: keychain.KeyFamilyPaymentBase,
+			Index:  7,
+		},
+	})
+	require.NoError(t, err)
+
+	// The to_remote output of a legacy channel is tweaked with the commit
+	// point of the remote state.
+	commitSecret, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	commitPoint := commitSecret.PubKey()
+	tweakedKey := input.TweakPubKey(baseKey.PubKey(), commitPoint)
+	addr, err := lnd.P2WKHAddr(tweakedKey, chainParams)
+	require.NoError(t, err)
+	pkScript, err := txscript.PayToAddrScript(addr)
+	require.NoError(t, err)
+
+	closingTxid := chainhash.Hash{1}.String()
+	api := &mockChainAPI{txs: map[string]*btc.TX{
+		closingTxid: {Vout: []*btc.Vout{{
+			ScriptPubkeyAddr: "bcrt1qother",
+			Value:            50_000,
+			Outspend:         &btc.Outspend{},
+		}, {
+			ScriptPubkeyAddr: addr.String(),
+			Value:            100_000,
+			Outspend:         &btc.Outspend{},
+		}}},
+	}}
+	entries := []*dataformat.SummaryEntry{{
+		ChannelPoint: "f0:0",
+		ClosingTX: &dataformat.ClosingTX{
+			TXID:       closingTxid,
+			ForceClose: true,
+			OurAddr:    addr.String(),
+		},
+	}}
+
+	// The key isn't found if the key index is above the number of keys.
+	keyCache, err := rescue.NewKeyCache(rootKey, 7, chainParams, h.logger)
+	require.NoError(t, err)
+	found, err := keyCache.RescueChannels(
+		entries, []*btcec.PublicKey{commitPoint},
+	)
+	require.NoError(t, err)
+	require.Empty(t, found)
+
+	keyCache, err = rescue.NewKeyCache(rootKey, 8, chainParams, h.logger)
+	require.NoError(t, err)
+	found, err = keyCache.RescueChannels(
+		entries, []*btcec.PublicKey{commitPoint},
+	)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+
+	wif, err := btcutil.DecodeWIF(found[addr.String()])
+	require.NoError(t, err)
+	require.Equal(t, tweakedKey, wif.PrivKey.PubKey())
+	require.Equal(t, found[addr.String()], entries[0].ClosingTX.SweepPrivkey)
+
+	// The found output is swept to the sweep address.
+	sweepAddr, err := lnd.P2WKHAddr(baseKey.PubKey(), chainParams)
+	require.NoError(t, err)
+	sweepScript, err := txscript.PayToAddrScript(sweepAddr)
+	require.NoError(t, err)
+	sweepTx, inputs, err := sweepRescuedOutputs(
+		api, entries, sweepScript, 10,
+	)
+	require.NoError(t, err)
+	require.Len(t, sweepTx.TxIn, 1)
+	require.Equal(t, closingTxid+":1", inputs[0].Outpoint)
+	require.Equal(t, "f0:0", inputs[0].ChannelPoint)
+	require.Len(t, sweepTx.TxOut, 1)
+	require.Equal(t, sweepScript, sweepTx.TxOut[0].PkScript)
+	require.Less(t, sweepTx.TxOut[0].Value, int64(100_000))
+	require.Greater(t, sweepTx.TxOut[0].Value, int64(98_000))
+
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(
+		pkScript, 100_000,
+	)
+	engine, err := txscript.NewEngine(
+		pkScript, sweepTx, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(sweepTx, prevOutFetcher), 100_000,
+		prevOutFetcher,
+	)
+	require.NoError(t, err)
+	require.NoError(t, engine.Execute())
+
+	// Spent outputs are not swept again.
+	api.txs[closingTxid].Vout[1].Outspend.Spent = true
+	_, _, err = sweepRescuedOutputs(api, entries, sweepScript, 10)
+	require.ErrorContains(t, err, "no unspent P2WPKH outputs")
+}
+
+func TestRescueClosedEntriesFromDB(t *testing.T) {
+	h := newHarness(t)
+
+	// Work on a copy of the test DB, as we need to close a channel.
+	compact := &compactDBCommand{
+		SourceDB: h.testdataFile("channel.db"),
+		DestDB:   h.tempFile("rescueclosed.db"),
+	}
+	err := compact.Execute(nil, nil)
+	require.NoError(t, err)
+
+	db, err := openChannelDB(compact.DestDB, false)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	chanDb := db.ChannelStateDB()
+	channels, err := chanDb.FetchAllChannels()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(channels), 2)
+
+	// The first channel is already known to lnd as being closed. The close
+	// summary contains the last commit points of the channel.
+	closedChannel, openChannel := channels[0], channels[1]
+	require.NotNil(t, closedChannel.RemoteCurrentRevocation)
+	require.NotNil(t, closedChannel.RemoteNextRevocation)
+	lastCommitPoints := []*btcec.PublicKey{
+		closedChannel.RemoteCurrentRevocation,
+		closedChannel.RemoteNextRevocation,
+	}
+	err = closedChannel.CloseChannel(&channeldb.ChannelCloseSummary{
+		ChanPoint:      closedChannel.FundingOutpoint,
+		ChainHash:      closedChannel.ChainHash,
+		RemotePub:      closedChannel.IdentityPub,
+		Capacity:       closedChannel.Capacity,
+		SettledBalance: 12_345,
+		CloseType:      channeldb.RemoteForceClose,
+		ShortChanID:    closedChannel.ShortChannelID,
+	})
+	require.NoError(t, err)
+
+	// The commit points of the closed channel are only found in the close
+	// summary.
+	points, err := commitPointsFromDB(chanDb, 0)
+	require.NoError(t, err)
+	var serialized []string
+	for _, point := range points {
+		pointBytes := point.SerializeCompressed()
+		serialized = append(serialized, hex.EncodeToString(pointBytes))
+	}
+	for _, point := range lastCommitPoints {
+		require.Contains(
+			t, serialized,
+			hex.EncodeToString(point.SerializeCompressed()),
+		)
+	}
+
+	// Both channels were force closed on chain, all other channels of the
+	// DB aren't found.
+	api := &mockChainAPI{txs: make(map[string]*btc.TX)}
+	for _, channel := range []*channeldb.OpenChannel{
+		closedChannel, openChannel,
+	} {
+		fundingTxid := channel.FundingOutpoint.Hash.String()
+		spendTxid := "spend" + fundingTxid
+
+		fundingTx := &btc.TX{}
+		for i := uint32(0); i <= channel.FundingOutpoint.Index; i++ {
+			fundingTx.Vout = append(fundingTx.Vout, &btc.Vout{
+				Outspend: &btc.Outspend{
+					Spent:  true,
+					Txid:   spendTxid,
+					Status: &btc.Status{Confirmed: true},
+				},
+			})
+		}
+		api.txs[fundingTxid] = fundingTx
+		api.txs[spendTxid] = &btc.TX{
+			Vin:  []*btc.Vin{{Sequence: 0x80000000}},
+			Vout: []*btc.Vout{{Outspend: &btc.Outspend{}}},
+		}
+	}
+
+	entries, err := closedEntriesFromDB(api, chanDb)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// The channel that is still open in the DB comes first, then the one
+	// from the close summaries.
+	require.Equal(
+		t, openChannel.FundingOutpoint.String(), entries[0].ChannelPoint,
+	)
+	require.Equal(
+		t, closedChannel.FundingOutpoint.String(),
+		entries[1].ChannelPoint,
+	)
+	require.EqualValues(t, 12_345, entries[1].LocalBalance)
+	for _, entry := range entries {
+		require.True(t, entry.ClosingTX.ForceClose)
+		require.Equal(
+			t, "spend"+entry.FundingTXID, entry.ClosingTX.TXID,
+		)
+	}
+	h.assertLogContains(fmt.Sprintf(
+		"Looking up %d channels of the channel DB on chain",
+		len(channels),
+	))
+	h.assertLogContains("Found 2 force closed channels")
+}
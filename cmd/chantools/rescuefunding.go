@@ -8,9 +8,11 @@ import (
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/input"
 	"github.com/lightningnetwork/lnd/keychain"
 	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
@@ -37,19 +39,22 @@ var (
 )
 
 type rescueFundingCommand struct {
-	ChannelDB         string
-	DBChannelPoint    string
-	ConfirmedOutPoint string
+	ChannelDB          string
+	DBChannelPoints    []string
+	ConfirmedOutPoints []string
+	Abandoned          bool
 
-	LocalKeyIndex uint32
-	RemotePubKey  string
+	LocalKeyIndexes []uint
+	RemotePubKeys   []string
 
 	SweepAddr string
 	FeeRate   uint16
-	APIURL    string
+	NonceFile string
+	PsbtV2    bool
 
-	rootKey *rootKey
-	cmd     *cobra.Command
+	chainAPI *chainAPIFlags
+	rootKey  *rootKey
+	cmd      *cobra.Command
 }
 
 func newRescueFundingCommand() *cobra.Command {
@@ -68,7 +73,32 @@ the 2-of-2 multisig.
 work**! They need to run the second command of this process: signrescuefunding
 
 If successful, this will create a PSBT that then has to be sent to the channel
-partner (remote node operator).`,
+partner (remote node operator).
+
+Simple taproot channels use a MuSig2 aggregated key for the funding output
+instead of a 2-of-2 multisig script. For those channels the PSBT only contains
+the public MuSig2 nonce of the initiator, the secret nonce is written to the
+file specified with --noncefile. After the remote node added its nonce and
+partial signature with the signrescuefunding command, the initiator needs to
+run signrescuefunding with the returned PSBT and the same --noncefile to create
+the final transaction. The nonce file is deleted after it was used once and
+must never be re-used.
+
+Multiple funding outputs with the same remote node can be rescued in a single
+transaction by specifying the channel related flags multiple times. All funds
+are then swept to the same address in one combined PSBT. The values of the flags
+are matched by their position, so the n-th --confirmedchannelpoint belongs to
+the n-th --dbchannelpoint or the n-th --localkeyindex and --remotepubkey.
+Batching is not supported for simple taproot channels.
+
+With --abandoned, the channel DB is searched for channels whose funding
+transaction confirmed but that never became fully open, for example because the
+peer vanished before the channel was ready. Those are the pending channels and
+the channels that were canceled or abandoned before their funding output was
+spent. One PSBT is created for each remote node, containing all its abandoned
+funding outputs that are still unspent. If a remote node can only be contacted
+through the match maker, the same outputs can be recovered with the
+zombierecovery commands instead.`,
 		Example: `chantools rescuefunding \
 	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
 	--dbchannelpoint xxxxxxx:xx \
@@ -80,6 +110,18 @@ chantools rescuefunding \
 	--localkeyindex x \
 	--remotepubkey 0xxxxxxxxxxxxxxxx \
 	--sweepaddr bc1qxxxxxxxxx \
+	--feerate 10
+
+chantools rescuefunding \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--dbchannelpoint xxxxxxx:xx \
+	--dbchannelpoint yyyyyyy:yy \
+	--sweepaddr bc1qxxxxxxxxx \
+	--feerate 10
+
+chantools rescuefunding --abandoned \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--sweepaddr bc1qxxxxxxxxx \
 	--feerate 10`,
 		RunE: cc.Execute,
 	}
@@ -88,29 +130,39 @@ chantools rescuefunding \
 			"rescue a channel from; must contain the pending "+
 			"channel specified with --channelpoint",
 	)
-	cc.cmd.Flags().StringVar(
-		&cc.DBChannelPoint, "dbchannelpoint", "", "funding transaction "+
-			"outpoint of the channel to rescue (<txid>:<txindex>) "+
-			"as it is recorded in the DB",
+	cc.cmd.Flags().StringArrayVar(
+		&cc.DBChannelPoints, "dbchannelpoint", nil, "funding "+
+			"transaction outpoint of the channel to rescue "+
+			"(<txid>:<txindex>) as it is recorded in the DB; can "+
+			"be specified multiple times to rescue multiple "+
+			"channels in one transaction",
 	)
-	cc.cmd.Flags().StringVar(
-		&cc.ConfirmedOutPoint, "confirmedchannelpoint", "", "channel "+
+	cc.cmd.Flags().StringArrayVar(
+		&cc.ConfirmedOutPoints, "confirmedchannelpoint", nil, "channel "+
 			"outpoint that got confirmed on chain "+
 			"(<txid>:<txindex>); normally this is the same as the "+
-			"--dbchannelpoint so it will be set to that value if"+
-			"this is left empty",
+			"--dbchannelpoint so it will be set to that value if "+
+			"this is left empty; can be specified multiple times",
 	)
-	cc.cmd.Flags().Uint32Var(
-		&cc.LocalKeyIndex, "localkeyindex", 0, "in case a channel DB "+
-			"is not available (but perhaps a channel backup "+
+	cc.cmd.Flags().BoolVar(
+		&cc.Abandoned, "abandoned", false, "search the channel DB "+
+			"for all channels that never became fully open but "+
+			"whose funding output confirmed and is still unspent "+
+			"and rescue them instead of the channels specified "+
+			"with --dbchannelpoint",
+	)
+	cc.cmd.Flags().UintSliceVar(
+		&cc.LocalKeyIndexes, "localkeyindex", nil, "in case a channel "+
+			"DB is not available (but perhaps a channel backup "+
 			"file), the derivation index of the local multisig "+
-			"public key can be specified manually",
+			"public key can be specified manually; can be "+
+			"specified multiple times",
 	)
-	cc.cmd.Flags().StringVar(
-		&cc.RemotePubKey, "remotepubkey", "", "in case a channel DB "+
+	cc.cmd.Flags().StringArrayVar(
+		&cc.RemotePubKeys, "remotepubkey", nil, "in case a channel DB "+
 			"is not available (but perhaps a channel backup "+
 			"file), the remote multisig public key can be "+
-			"specified manually",
+			"specified manually; can be specified multiple times",
 	)
 	cc.cmd.Flags().StringVar(
 		&cc.SweepAddr, "sweepaddr", "", "address to sweep the funds to",
@@ -119,9 +171,17 @@ chantools rescuefunding \
 		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
 			"use for the sweep transaction in sat/vByte",
 	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
 	cc.cmd.Flags().StringVar(
-		&cc.APIURL, "apiurl", defaultAPIURL, "API URL to use (must "+
-			"be esplora compatible)",
+		&cc.NonceFile, "noncefile", "", "file to store the secret "+
+			"MuSig2 nonce in; only used for simple taproot "+
+			"channels; if empty, "+
+			"rescuefunding-nonce-<timestamp>.hex in the working "+
+			"directory is used",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.PsbtV2, "psbtv2", false, "create a version 2 PSBT (BIP "+
+			"370) instead of version 0",
 	)
 
 	cc.rootKey = newRootKey(cc.cmd, "deriving keys")
@@ -130,13 +190,6 @@ chantools rescuefunding \
 }
 
 func (c *rescueFundingCommand) Execute(_ *cobra.Command, _ []string) error {
-	var (
-		chainOp      *wire.OutPoint
-		databaseOp   *wire.OutPoint
-		localKeyDesc *keychain.KeyDescriptor
-		remotePubKey *btcec.PublicKey
-	)
-
 	extendedKey, err := c.rootKey.read()
 	if err != nil {
 		return fmt.Errorf("error reading root key: %w", err)
@@ -147,83 +200,112 @@ func (c *rescueFundingCommand) Execute(_ *cobra.Command, _ []string) error {
 		ChainParams: chainParams,
 	}
 
+	api := c.chainAPI.api()
+
 	// Check that we have a channel DB or manual keys.
+	var channels []*fundingRescueChannel
 	switch {
-	case (c.ChannelDB == "" || c.DBChannelPoint == "") &&
-		c.RemotePubKey == "":
+	case c.Abandoned:
+		if !channelDBGiven(c.ChannelDB) {
+			return fmt.Errorf("need to specify the channel DB to " +
+				"search for abandoned channels")
+		}
+		if len(c.DBChannelPoints) > 0 || len(c.RemotePubKeys) > 0 ||
+			len(c.ConfirmedOutPoints) > 0 {
 
-		return fmt.Errorf("need to specify either channel DB and " +
-			"channel point or both local and remote pubkey")
+			return fmt.Errorf("cannot specify channels together " +
+				"with --abandoned")
+		}
 
-	case c.ChannelDB != "" && c.DBChannelPoint != "":
-		db, err := lnd.OpenDB(c.ChannelDB, true)
+		db, err := openChannelDB(c.ChannelDB, true)
 		if err != nil {
 			return fmt.Errorf("error opening rescue DB: %w", err)
 		}
 
-		// Parse channel point of channel to rescue as known to the DB.
-		databaseOp, err = lnd.ParseOutpoint(c.DBChannelPoint)
+		channels, err = findAbandonedFunding(db.ChannelStateDB(), api)
 		if err != nil {
-			return fmt.Errorf("error parsing channel point: %w",
-				err)
+			return err
 		}
-
-		// First, make sure the channel can be found in the DB.
-		pendingChan, err := db.ChannelStateDB().FetchChannel(
-			nil, *databaseOp,
-		)
-		if err != nil {
-			return fmt.Errorf("error loading pending channel %s "+
-				"from DB: %w", databaseOp, err)
+		if len(channels) == 0 {
+			return fmt.Errorf("no abandoned funding outputs found")
 		}
 
-		if pendingChan.LocalChanCfg.MultiSigKey.PubKey == nil {
-			return fmt.Errorf("invalid channel data in DB, local " +
-				"multisig pubkey is nil")
-		}
-		if pendingChan.LocalChanCfg.MultiSigKey.PubKey == nil {
-			return fmt.Errorf("invalid channel data in DB, remote " +
-				"multisig pubkey is nil")
-		}
+	case (!channelDBGiven(c.ChannelDB) || len(c.DBChannelPoints) == 0) &&
+		len(c.RemotePubKeys) == 0:
 
-		localKeyDesc = &pendingChan.LocalChanCfg.MultiSigKey
-		remotePubKey = pendingChan.RemoteChanCfg.MultiSigKey.PubKey
+		return fmt.Errorf("need to specify either channel DB and " +
+			"channel point or both local and remote pubkey")
 
-	case c.RemotePubKey != "":
-		remoteKeyBytes, err := hex.DecodeString(c.RemotePubKey)
+	case channelDBGiven(c.ChannelDB) && len(c.DBChannelPoints) > 0:
+		db, err := openChannelDB(c.ChannelDB, true)
 		if err != nil {
-			return fmt.Errorf("error hex decoding remote pubkey: "+
-				"%w", err)
+			return fmt.Errorf("error opening rescue DB: %w", err)
 		}
 
-		remotePubKey, err = btcec.ParsePubKey(remoteKeyBytes)
-		if err != nil {
-			return fmt.Errorf("error parsing remote pubkey: %w",
-				err)
+		for _, dbChannelPoint := range c.DBChannelPoints {
+			channel, err := fundingRescueChannelFromDB(
+				db, dbChannelPoint,
+			)
+			if err != nil {
+				return err
+			}
+
+			channels = append(channels, channel)
 		}
 
-		localKeyDesc = &keychain.KeyDescriptor{
-			KeyLocator: keychain.KeyLocator{
-				Family: keychain.KeyFamilyMultiSig,
-				Index:  c.LocalKeyIndex,
-			},
+	case len(c.RemotePubKeys) > 0:
+		// For a single channel the local key index defaults to 0.
+		localKeyIndexes := c.LocalKeyIndexes
+		if len(localKeyIndexes) == 0 && len(c.RemotePubKeys) == 1 {
+			localKeyIndexes = []uint{0}
 		}
-		privKey, err := signer.FetchPrivKey(localKeyDesc)
-		if err != nil {
-			return fmt.Errorf("error deriving local key: %w", err)
+		if len(localKeyIndexes) != len(c.RemotePubKeys) {
+			return fmt.Errorf("need to specify the same number of "+
+				"local key indexes and remote pubkeys, got %d "+
+				"and %d", len(localKeyIndexes),
+				len(c.RemotePubKeys))
+		}
+
+		for idx, remotePubKey := range c.RemotePubKeys {
+			channel, err := fundingRescueChannelFromKeys(
+				signer, uint32(localKeyIndexes[idx]),
+				remotePubKey,
+			)
+			if err != nil {
+				return err
+			}
+
+			channels = append(channels, channel)
 		}
-		localKeyDesc.PubKey = privKey.PubKey()
 	}
 
 	// Parse channel point of channel to rescue as confirmed on chain (if
 	// different).
-	if len(c.ConfirmedOutPoint) == 0 {
-		chainOp = databaseOp
-	} else {
-		chainOp, err = lnd.ParseOutpoint(c.ConfirmedOutPoint)
-		if err != nil {
-			return fmt.Errorf("error parsing confirmed channel "+
-				"point: %w", err)
+	switch {
+	case len(c.ConfirmedOutPoints) == 0:
+		for _, channel := range channels {
+			if channel.databaseOp == nil {
+				return fmt.Errorf("need to specify the " +
+					"confirmed channel point")
+			}
+
+			channel.chainOp = channel.databaseOp
+		}
+
+	case len(c.ConfirmedOutPoints) != len(channels):
+		return fmt.Errorf("need to specify one confirmed channel point "+
+			"per channel, got %d for %d channels",
+			len(c.ConfirmedOutPoints), len(channels))
+
+	default:
+		for idx, confirmedOutPoint := range c.ConfirmedOutPoints {
+			channels[idx].chainOp, err = lnd.ParseOutpoint(
+				confirmedOutPoint,
+			)
+			if err != nil {
+				return fmt.Errorf("error parsing confirmed "+
+					"channel point: %w", err)
+			}
 		}
 	}
 
@@ -234,110 +316,351 @@ func (c *rescueFundingCommand) Execute(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("error parsing sweep addr: %w", err)
 	}
 
-	return rescueFunding(
-		localKeyDesc, remotePubKey, signer, chainOp,
-		sweepScript, btcutil.Amount(c.FeeRate), c.APIURL,
-	)
+	psbtVersion := btc.PsbtVersion0
+	if c.PsbtV2 {
+		psbtVersion = btc.PsbtVersion2
+	}
+
+	c.FeeRate, err = sweepFeeRate(c.cmd, api, c.FeeRate)
+	if err != nil {
+		return err
+	}
+
+	// The nonce file isn't the result of the command, so --resultfile
+	// doesn't apply to it.
+	if c.NonceFile == "" {
+		c.NonceFile = workDirFileName("rescuefunding-nonce", "hex")
+	}
+
+	if !c.Abandoned {
+		result, err := rescueFunding(
+			channels, signer, sweepScript,
+			btcutil.Amount(c.FeeRate), api, c.NonceFile,
+			psbtVersion,
+		)
+		if err != nil {
+			return err
+		}
+
+		if JSONOutput {
+			return printJSON([]*rescueFundingResult{result})
+		}
+		result.print()
+
+		return nil
+	}
+
+	// Only the funding outputs of the same remote node can be rescued in
+	// one transaction.
+	batches := rescueBatchesByNode(channels)
+	results := make([]*rescueFundingResult, 0, len(batches))
+	for idx, batch := range batches {
+		result, err := rescueFunding(
+			batch, signer, sweepScript, btcutil.Amount(c.FeeRate),
+			api, c.NonceFile, psbtVersion,
+		)
+		if err != nil {
+			return err
+		}
+		result.RemoteNode = hex.EncodeToString(
+			batch[0].remoteNode.SerializeCompressed(),
+		)
+		results = append(results, result)
+
+		if !JSONOutput {
+			fmt.Fprintf(textOut(), "Rescue %d of %d, remote "+
+				"node %s:\n", idx+1, len(batches),
+				result.RemoteNode)
+			result.print()
+		}
+	}
+
+	return printJSON(results)
 }
 
-func rescueFunding(localKeyDesc *keychain.KeyDescriptor,
-	remoteKey *btcec.PublicKey, signer *lnd.Signer,
-	chainPoint *wire.OutPoint, sweepPKScript []byte, feeRate btcutil.Amount,
-	apiURL string) error {
+// rescueFundingResult is a PSBT created by the rescuefunding command that
+// needs to be sent to the remote node.
+type rescueFundingResult struct {
+	RemoteNode string `json:"remote_node,omitempty"`
+	Psbt       string `json:"psbt"`
 
-	// Prepare the wire part of the PSBT.
-	txIn := &wire.TxIn{
-		PreviousOutPoint: *chainPoint,
-		Sequence:         0,
+	// NonceFile is the file the secret MuSig2 nonce was written to, it is
+	// only set for simple taproot channels.
+	NonceFile string `json:"nonce_file,omitempty"`
+}
+
+// print prints the PSBT and the instructions for the next step.
+func (r *rescueFundingResult) print() {
+	if r.NonceFile != "" {
+		fmt.Fprintf(textOut(), "Taproot channel detected, the secret "+
+			"MuSig2 nonce was written to %s.\nSend this PSBT to "+
+			"the other peer and ask them to run the "+
+			"'chantools\nsignrescuefunding' command, then run "+
+			"'chantools signrescuefunding\n--noncefile %s' with "+
+			"the PSBT they send back:\n\n%s\n\n", r.NonceFile,
+			r.NonceFile, r.Psbt)
+
+		return
 	}
-	txOut := &wire.TxOut{
-		PkScript: sweepPKScript,
+
+	fmt.Fprintf(textOut(), "Partially signed transaction created. Send "+
+		"this to the other peer \nand ask them to run the 'chantools "+
+		"signrescuefunding' command: \n\n%s\n\n", r.Psbt)
+}
+
+// fundingRescueChannel holds the keys and outpoints of a single funding output
+// that should be rescued.
+type fundingRescueChannel struct {
+	localKeyDesc *keychain.KeyDescriptor
+	remotePubKey *btcec.PublicKey
+	remoteNode   *btcec.PublicKey
+	databaseOp   *wire.OutPoint
+	chainOp      *wire.OutPoint
+}
+
+// fundingRescueChannelFromDB loads the multisig keys of the pending channel
+// with the given channel point from the channel DB.
+func fundingRescueChannelFromDB(db *channeldb.DB,
+	dbChannelPoint string) (*fundingRescueChannel, error) {
+
+	// Parse channel point of channel to rescue as known to the DB.
+	databaseOp, err := lnd.ParseOutpoint(dbChannelPoint)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing channel point: %w", err)
+	}
+
+	// First, make sure the channel can be found in the DB.
+	pendingChan, err := db.ChannelStateDB().FetchChannel(nil, *databaseOp)
+	if err != nil {
+		return nil, fmt.Errorf("error loading pending channel %s "+
+			"from DB: %w", databaseOp, err)
 	}
 
-	// Locate the output in the funding TX.
-	api := &btc.ExplorerAPI{BaseURL: apiURL}
-	tx, err := api.Transaction(chainPoint.Hash.String())
+	return newFundingRescueChannel(pendingChan)
+}
+
+// newFundingRescueChannel creates the rescue information of the funding output
+// of the given channel from the DB.
+func newFundingRescueChannel(
+	pendingChan *channeldb.OpenChannel) (*fundingRescueChannel, error) {
+
+	if pendingChan.LocalChanCfg.MultiSigKey.PubKey == nil {
+		return nil, fmt.Errorf("invalid channel data in DB, local " +
+			"multisig pubkey is nil")
+	}
+	if pendingChan.RemoteChanCfg.MultiSigKey.PubKey == nil {
+		return nil, fmt.Errorf("invalid channel data in DB, remote " +
+			"multisig pubkey is nil")
+	}
+
+	return &fundingRescueChannel{
+		localKeyDesc: &pendingChan.LocalChanCfg.MultiSigKey,
+		remotePubKey: pendingChan.RemoteChanCfg.MultiSigKey.PubKey,
+		remoteNode:   pendingChan.IdentityPub,
+		databaseOp:   &pendingChan.FundingOutpoint,
+	}, nil
+}
+
+// fundingRescueChannelFromKeys derives the local multisig key with the given
+// index and parses the hex encoded remote multisig key.
+func fundingRescueChannelFromKeys(signer *lnd.Signer, localKeyIndex uint32,
+	remotePubKeyHex string) (*fundingRescueChannel, error) {
+
+	remoteKeyBytes, err := hex.DecodeString(remotePubKeyHex)
 	if err != nil {
-		return fmt.Errorf("error fetching UTXO info for outpoint %s: "+
-			"%v", chainPoint.String(), err)
+		return nil, fmt.Errorf("error hex decoding remote pubkey: %w",
+			err)
 	}
-	apiUtxo := tx.Vout[chainPoint.Index]
 
-	pkScript, err := hex.DecodeString(apiUtxo.ScriptPubkey)
+	remotePubKey, err := btcec.ParsePubKey(remoteKeyBytes)
 	if err != nil {
-		return fmt.Errorf("error decoding pk script %s: %w",
-			apiUtxo.ScriptPubkey, err)
+		return nil, fmt.Errorf("error parsing remote pubkey: %w", err)
 	}
-	utxo := &wire.TxOut{
-		Value:    int64(apiUtxo.Value),
-		PkScript: pkScript,
+
+	localKeyDesc := &keychain.KeyDescriptor{
+		KeyLocator: keychain.KeyLocator{
+			Family: keychain.KeyFamilyMultiSig,
+			Index:  localKeyIndex,
+		},
+	}
+	privKey, err := signer.FetchPrivKey(localKeyDesc)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving local key: %w", err)
+	}
+	localKeyDesc.PubKey = privKey.PubKey()
+
+	return &fundingRescueChannel{
+		localKeyDesc: localKeyDesc,
+		remotePubKey: remotePubKey,
+	}, nil
+}
+
+func rescueFunding(channels []*fundingRescueChannel, signer *lnd.Signer,
+	sweepPKScript []byte, feeRate btcutil.Amount, api btc.SweepAPI,
+	nonceFile string, psbtVersion uint32) (*rescueFundingResult, error) {
+
+	// Locate the outputs in the funding TXs.
+	utxos := make([]*wire.TxOut, len(channels))
+	for idx, channel := range channels {
+		chainPoint := channel.chainOp
+		tx, err := api.Transaction(chainPoint.Hash.String())
+		if err != nil {
+			return nil, fmt.Errorf("error fetching UTXO info for "+
+				"outpoint %s: %v", chainPoint.String(), err)
+		}
+		if int(chainPoint.Index) >= len(tx.Vout) {
+			return nil, fmt.Errorf("outpoint %s does not exist",
+				chainPoint.String())
+		}
+		apiUtxo := tx.Vout[chainPoint.Index]
+
+		pkScript, err := hex.DecodeString(apiUtxo.ScriptPubkey)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding pk script %s: %w",
+				apiUtxo.ScriptPubkey, err)
+		}
+		utxos[idx] = &wire.TxOut{
+			Value:    int64(apiUtxo.Value),
+			PkScript: pkScript,
+		}
+	}
+
+	// Simple taproot channels use a MuSig2 aggregated key, so we can't
+	// add our signature yet but need to exchange nonces first.
+	if len(utxos) == 1 && txscript.IsPayToTaproot(utxos[0].PkScript) {
+		txIn := &wire.TxIn{
+			PreviousOutPoint: *channels[0].chainOp,
+			Sequence:         0,
+		}
+		txOut := &wire.TxOut{
+			PkScript: sweepPKScript,
+		}
+		packet, err := createTaprootRescuePSBT(
+			channels[0].localKeyDesc, channels[0].remotePubKey,
+			signer, txIn, txOut, utxos[0], feeRate, nonceFile,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		base64, err := btc.EncodePsbt(packet, psbtVersion)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding PSBT: %w", err)
+		}
+
+		return &rescueFundingResult{
+			Psbt:      base64,
+			NonceFile: nonceFile,
+		}, nil
 	}
 
-	// We should also be able to create the funding script from the two
-	// multisig keys.
-	witnessScript, fundingTxOut, err := input.GenFundingPkScript(
-		localKeyDesc.PubKey.SerializeCompressed(),
-		remoteKey.SerializeCompressed(), utxo.Value,
+	packet, err := createRescueFundingPSBT(
+		channels, utxos, signer, sweepPKScript, feeRate,
 	)
 	if err != nil {
-		return fmt.Errorf("could not derive funding script: %w", err)
+		return nil, err
 	}
 
-	// Some last sanity check that we're working with the correct data.
-	if !bytes.Equal(fundingTxOut.PkScript, utxo.PkScript) {
-		return fmt.Errorf("funding output script does not match UTXO")
+	// We're done, we can now output the finished PSBT.
+	base64, err := btc.EncodePsbt(packet, psbtVersion)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding PSBT: %w", err)
 	}
 
-	// Now the rest of the known data for the PSBT.
-	pIn := psbt.PInput{
-		WitnessUtxo:   utxo,
-		WitnessScript: witnessScript,
-		Unknowns: []*psbt.Unknown{{
-			// We add the public key the other party needs to sign
-			// with as a proprietary field so we can easily read it
-			// out with the signrescuefunding command.
-			Key:   PsbtKeyTypeOutputMissingSigPubkey,
-			Value: remoteKey.SerializeCompressed(),
-		}},
+	return &rescueFundingResult{Psbt: base64}, nil
+}
+
+// createRescueFundingPSBT creates a PSBT that spends all the given 2-of-2
+// multisig funding outputs to the sweep script and adds our partial signature
+// to each of the inputs.
+func createRescueFundingPSBT(channels []*fundingRescueChannel,
+	utxos []*wire.TxOut, signer *lnd.Signer, sweepPKScript []byte,
+	feeRate btcutil.Amount) (*psbt.Packet, error) {
+
+	var (
+		estimator      input.TxWeightEstimator
+		totalValue     int64
+		witnessScripts = make([][]byte, len(channels))
+		wireTx         = &wire.MsgTx{Version: 2}
+	)
+	for idx, channel := range channels {
+		utxo := utxos[idx]
+		if txscript.IsPayToTaproot(utxo.PkScript) {
+			return nil, fmt.Errorf("funding output %v is a taproot "+
+				"output, those can only be rescued one at a "+
+				"time", channel.chainOp)
+		}
+
+		// We should also be able to create the funding script from
+		// the two multisig keys.
+		witnessScript, fundingTxOut, err := input.GenFundingPkScript(
+			channel.localKeyDesc.PubKey.SerializeCompressed(),
+			channel.remotePubKey.SerializeCompressed(), utxo.Value,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not derive funding "+
+				"script: %w", err)
+		}
+
+		// Some last sanity check that we're working with the correct
+		// data.
+		if !bytes.Equal(fundingTxOut.PkScript, utxo.PkScript) {
+			return nil, fmt.Errorf("funding output script of %v "+
+				"does not match UTXO", channel.chainOp)
+		}
+
+		witnessScripts[idx] = witnessScript
+		totalValue += utxo.Value
+		estimator.AddWitnessInput(MultiSigWitnessSize)
+		wireTx.TxIn = append(wireTx.TxIn, &wire.TxIn{
+			PreviousOutPoint: *channel.chainOp,
+			Sequence:         0,
+		})
 	}
 
 	// Estimate the transaction weight so we can do the fee estimation.
-	var estimator input.TxWeightEstimator
-	estimator.AddWitnessInput(MultiSigWitnessSize)
 	estimator.AddP2WKHOutput()
 	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
 	totalFee := feeRateKWeight.FeeForWeight(int64(estimator.Weight()))
-	txOut.Value = utxo.Value - int64(totalFee)
+	wireTx.TxOut = []*wire.TxOut{{
+		Value:    totalValue - int64(totalFee),
+		PkScript: sweepPKScript,
+	}}
 
 	// Let's now create the PSBT as we have everything we need so far.
-	wireTx := &wire.MsgTx{
-		Version: 2,
-		TxIn:    []*wire.TxIn{txIn},
-		TxOut:   []*wire.TxOut{txOut},
-	}
 	packet, err := psbt.NewFromUnsignedTx(wireTx)
 	if err != nil {
-		return fmt.Errorf("error creating PSBT: %w", err)
+		return nil, fmt.Errorf("error creating PSBT: %w", err)
 	}
-	packet.Inputs[0] = pIn
 
-	// Now we add our partial signature.
-	err = signer.AddPartialSignature(
-		packet, *localKeyDesc, utxo, witnessScript, 0,
-	)
-	if err != nil {
-		return fmt.Errorf("error adding partial signature: %w", err)
+	for idx, channel := range channels {
+		// Now the rest of the known data for the PSBT.
+		packet.Inputs[idx] = psbt.PInput{
+			WitnessUtxo:   utxos[idx],
+			WitnessScript: witnessScripts[idx],
+			Unknowns: []*psbt.Unknown{{
+				// We add the public key the other party needs
+				// to sign with as a proprietary field so we
+				// can easily read it out with the
+				// signrescuefunding command.
+				Key: PsbtKeyTypeOutputMissingSigPubkey,
+				Value: channel.remotePubKey.
+					SerializeCompressed(),
+			}},
+		}
 	}
 
-	// We're done, we can now output the finished PSBT.
-	base64, err := packet.B64Encode()
-	if err != nil {
-		return fmt.Errorf("error encoding PSBT: %w", err)
+	// Now we add our partial signatures.
+	for idx, channel := range channels {
+		err = signer.AddPartialSignature(
+			packet, *channel.localKeyDesc, utxos[idx],
+			witnessScripts[idx], idx,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error adding partial "+
+				"signature: %w", err)
+		}
 	}
 
-	fmt.Printf("Partially signed transaction created. Send this to the "+
-		"other peer \nand ask them to run the 'chantools "+
-		"signrescuefunding' command: \n\n%s\n\n", base64)
-
-	return nil
+	return packet, nil
 }
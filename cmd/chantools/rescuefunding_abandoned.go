@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/guggero/chantools/btc"
+	"github.com/lightningnetwork/lnd/channeldb"
+)
+
+// findAbandonedFunding returns the funding outputs of all channels in the DB
+// that never became fully open but whose funding transaction confirmed and
+// whose funding output is still unspent.
+func findAbandonedFunding(db *channeldb.ChannelStateDB,
+	api btc.ChainAPI) ([]*fundingRescueChannel, error) {
+
+	candidates, err := abandonedFundingCandidates(db)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Checking the funding outputs of %d pending, canceled or "+
+		"abandoned channels", len(candidates))
+
+	return unspentFundingOutputs(candidates, api)
+}
+
+// abandonedFundingCandidates returns all channels of the DB that could have an
+// abandoned funding output: the pending channels and the channels that were
+// closed because the funding flow was canceled or that were abandoned.
+func abandonedFundingCandidates(
+	db *channeldb.ChannelStateDB) ([]*channeldb.OpenChannel, error) {
+
+	candidates, err := db.FetchPendingChannels()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching pending channels: %w",
+			err)
+	}
+
+	closedChannels, err := db.FetchClosedChannels(false)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching closed channels: %w",
+			err)
+	}
+	for _, summary := range closedChannels {
+		if summary.CloseType != channeldb.FundingCanceled &&
+			summary.CloseType != channeldb.Abandoned {
+
+			continue
+		}
+
+		// The channel configuration with the multisig keys is only
+		// available if lnd kept the historical channel state.
+		channel, err := db.FetchHistoricalChannel(&summary.ChanPoint)
+		if err != nil {
+			log.Warnf("Skipping channel %v, could not load its "+
+				"historical state: %v", summary.ChanPoint, err)
+			continue
+		}
+		candidates = append(candidates, channel)
+	}
+
+	return candidates, nil
+}
+
+// unspentFundingOutputs returns the rescue information of the channels whose
+// funding transaction confirmed and whose funding output is still unspent.
+func unspentFundingOutputs(channels []*channeldb.OpenChannel,
+	api btc.ChainAPI) ([]*fundingRescueChannel, error) {
+
+	var result []*fundingRescueChannel
+	for _, channel := range channels {
+		chanPoint := channel.FundingOutpoint
+		tx, err := api.Transaction(chanPoint.Hash.String())
+		switch {
+		case errors.Is(err, btc.ErrTxNotFound):
+			log.Infof("Funding TX of channel %v not found, it was "+
+				"never published", chanPoint)
+			continue
+
+		case err != nil:
+			return nil, fmt.Errorf("error fetching funding TX of "+
+				"channel %v: %w", chanPoint, err)
+		}
+
+		if tx.Status != nil && !tx.Status.Confirmed {
+			log.Infof("Funding TX of channel %v is not confirmed "+
+				"yet", chanPoint)
+			continue
+		}
+		if int(chanPoint.Index) >= len(tx.Vout) {
+			log.Warnf("Funding output %v does not exist", chanPoint)
+			continue
+		}
+		vout := tx.Vout[chanPoint.Index]
+		if vout.Outspend != nil && vout.Outspend.Spent {
+			log.Infof("Funding output %v was already spent by %s",
+				chanPoint, vout.Outspend.Txid)
+			continue
+		}
+
+		rescueChannel, err := newFundingRescueChannel(channel)
+		if err != nil {
+			return nil, fmt.Errorf("error loading channel %v: %w",
+				chanPoint, err)
+		}
+
+		log.Infof("Found abandoned funding output %v with %d sats of "+
+			"remote node %x", chanPoint, vout.Value,
+			channel.IdentityPub.SerializeCompressed())
+		result = append(result, rescueChannel)
+	}
+
+	return result, nil
+}
+
+// rescueBatchesByNode groups the channels by their remote node, keeping the
+// order in which the nodes first appear.
+func rescueBatchesByNode(
+	channels []*fundingRescueChannel) [][]*fundingRescueChannel {
+
+	var (
+		batches   [][]*fundingRescueChannel
+		nodeBatch = make(map[string]int)
+	)
+	for _, channel := range channels {
+		node := string(channel.remoteNode.SerializeCompressed())
+		idx, ok := nodeBatch[node]
+		if !ok {
+			idx = len(batches)
+			nodeBatch[node] = idx
+			batches = append(batches, nil)
+		}
+		batches[idx] = append(batches[idx], channel)
+	}
+
+	return batches
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+)
+
+var (
+	// PsbtKeyTypeInputMuSig2Nonce is the proprietary PSBT input key type
+	// for the public MuSig2 nonce of a signer. The key is followed by the
+	// signer's compressed public key.
+	PsbtKeyTypeInputMuSig2Nonce = []byte{0xcd}
+
+	// PsbtKeyTypeInputMuSig2PartialSig is the proprietary PSBT input key
+	// type for the MuSig2 partial signature of a signer. The key is
+	// followed by the signer's compressed public key.
+	PsbtKeyTypeInputMuSig2PartialSig = []byte{0xce}
+)
+
+// taprootRescueFields are the MuSig2 related fields of a taproot funding
+// rescue PSBT input.
+type taprootRescueFields struct {
+	initiatorKey   *btcec.PublicKey
+	initiatorNonce *[musig2.PubNonceSize]byte
+
+	remoteKey   *btcec.PublicKey
+	remoteNonce *[musig2.PubNonceSize]byte
+	remoteSig   *musig2.PartialSignature
+}
+
+// parseTaprootRescueFields extracts the MuSig2 related fields from the given
+// PSBT input.
+func parseTaprootRescueFields(pIn *psbt.PInput) (*taprootRescueFields,
+	error) {
+
+	type keyedValue struct {
+		key   *btcec.PublicKey
+		value []byte
+	}
+	var (
+		fields   = &taprootRescueFields{}
+		nonces   []keyedValue
+		sigs     []keyedValue
+		parseKey = func(keyType, key []byte) (*btcec.PublicKey, error) {
+			return btcec.ParsePubKey(key[len(keyType):])
+		}
+	)
+	for _, unknown := range pIn.Unknowns {
+		switch {
+		case bytes.Equal(
+			unknown.Key, PsbtKeyTypeOutputMissingSigPubkey,
+		):
+			key, err := btcec.ParsePubKey(unknown.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid remote "+
+					"pubkey: %w", err)
+			}
+			fields.remoteKey = key
+
+		case bytes.HasPrefix(unknown.Key, PsbtKeyTypeInputMuSig2Nonce):
+			key, err := parseKey(
+				PsbtKeyTypeInputMuSig2Nonce, unknown.Key,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("invalid nonce "+
+					"pubkey: %w", err)
+			}
+			if len(unknown.Value) != musig2.PubNonceSize {
+				return nil, fmt.Errorf("invalid nonce length "+
+					"%d", len(unknown.Value))
+			}
+			nonces = append(nonces, keyedValue{key, unknown.Value})
+
+		case bytes.HasPrefix(
+			unknown.Key, PsbtKeyTypeInputMuSig2PartialSig,
+		):
+			key, err := parseKey(
+				PsbtKeyTypeInputMuSig2PartialSig, unknown.Key,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("invalid partial sig "+
+					"pubkey: %w", err)
+			}
+			sigs = append(sigs, keyedValue{key, unknown.Value})
+
+		default:
+			return nil, fmt.Errorf("unknown PSBT input key %x",
+				unknown.Key)
+		}
+	}
+
+	if fields.remoteKey == nil {
+		return nil, fmt.Errorf("remote pubkey missing")
+	}
+	for _, nonce := range nonces {
+		var pubNonce [musig2.PubNonceSize]byte
+		copy(pubNonce[:], nonce.value)
+
+		if nonce.key.IsEqual(fields.remoteKey) {
+			fields.remoteNonce = &pubNonce
+			continue
+		}
+
+		if fields.initiatorKey != nil {
+			return nil, fmt.Errorf("more than two nonces found")
+		}
+		fields.initiatorKey = nonce.key
+		fields.initiatorNonce = &pubNonce
+	}
+	if fields.initiatorKey == nil {
+		return nil, fmt.Errorf("nonce of initiator missing")
+	}
+	for _, sig := range sigs {
+		if !sig.key.IsEqual(fields.remoteKey) {
+			return nil, fmt.Errorf("unexpected partial signature "+
+				"of key %x", sig.key.SerializeCompressed())
+		}
+
+		fields.remoteSig = &musig2.PartialSignature{}
+		err := fields.remoteSig.Decode(bytes.NewReader(sig.value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid partial signature: %w",
+				err)
+		}
+	}
+	if fields.remoteSig != nil && fields.remoteNonce == nil {
+		return nil, fmt.Errorf("nonce of remote signer missing")
+	}
+
+	return fields, nil
+}
+
+// taprootFundingTweaks are the tweaks of the aggregated key of a simple
+// taproot channel funding output, which is a BIP86 style key spend output of
+// the aggregated key of both channel parties.
+var taprootFundingTweaks = &input.MuSig2Tweaks{
+	TaprootBIP0086Tweak: true,
+}
+
+// checkTaprootFundingKey makes sure the given combined key is the key of the
+// funding output with the given script.
+func checkTaprootFundingKey(combinedKey *btcec.PublicKey,
+	pkScript []byte) error {
+
+	expectedScript, err := txscript.PayToTaprootScript(combinedKey)
+	if err != nil {
+		return fmt.Errorf("error creating taproot script: %w", err)
+	}
+	if !bytes.Equal(expectedScript, pkScript) {
+		return fmt.Errorf("funding output script does not match " +
+			"UTXO")
+	}
+
+	return nil
+}
+
+// taprootKeySpendSigHash returns the BIP341 signature hash for spending the
+// first input of the PSBT through the key spend path.
+func taprootKeySpendSigHash(packet *psbt.Packet) ([32]byte, error) {
+	var sigHash [32]byte
+
+	utxo := packet.Inputs[0].WitnessUtxo
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(
+		utxo.PkScript, utxo.Value,
+	)
+	sigHashes := txscript.NewTxSigHashes(packet.UnsignedTx, prevOutFetcher)
+	hash, err := txscript.CalcTaprootSignatureHash(
+		sigHashes, txscript.SigHashDefault, packet.UnsignedTx, 0,
+		prevOutFetcher,
+	)
+	if err != nil {
+		return sigHash, fmt.Errorf("error calculating sighash: %w", err)
+	}
+	copy(sigHash[:], hash)
+
+	return sigHash, nil
+}
+
+// createTaprootRescuePSBT creates the PSBT for rescuing a simple taproot
+// channel funding output. Because MuSig2 requires both parties to exchange
+// nonces before signing, the PSBT only contains our public nonce. The secret
+// nonce is written to the given file (followed by the public nonce) and is
+// needed again to finalize the transaction once the remote node has added its
+// partial signature.
+func createTaprootRescuePSBT(localKeyDesc *keychain.KeyDescriptor,
+	remoteKey *btcec.PublicKey, signer *lnd.Signer, txIn *wire.TxIn,
+	txOut *wire.TxOut, utxo *wire.TxOut, feeRate btcutil.Amount,
+	nonceFile string) (*psbt.Packet, error) {
+
+	combinedKey, err := input.MuSig2CombineKeys(
+		input.MuSig2Version100RC2, []*btcec.PublicKey{
+			localKeyDesc.PubKey, remoteKey,
+		}, true, taprootFundingTweaks,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error combining keys: %w", err)
+	}
+	err = checkTaprootFundingKey(combinedKey.FinalKey, utxo.PkScript)
+	if err != nil {
+		return nil, err
+	}
+
+	// Estimate the transaction weight so we can do the fee estimation.
+	var estimator input.TxWeightEstimator
+	estimator.AddTaprootKeySpendInput(txscript.SigHashDefault)
+	estimator.AddP2WKHOutput()
+	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
+	totalFee := feeRateKWeight.FeeForWeight(int64(estimator.Weight()))
+	txOut.Value = utxo.Value - int64(totalFee)
+
+	wireTx := &wire.MsgTx{
+		Version: 2,
+		TxIn:    []*wire.TxIn{txIn},
+		TxOut:   []*wire.TxOut{txOut},
+	}
+	packet, err := psbt.NewFromUnsignedTx(wireTx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating PSBT: %w", err)
+	}
+
+	nonces, err := signer.MuSig2GenNonces(localKeyDesc.KeyLocator)
+	if err != nil {
+		return nil, err
+	}
+	nonceBytes := append(nonces.SecNonce[:], nonces.PubNonce[:]...)
+	err = os.WriteFile(
+		nonceFile, []byte(hex.EncodeToString(nonceBytes)), 0600,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error writing nonce file: %w", err)
+	}
+
+	packet.Inputs[0] = psbt.PInput{
+		WitnessUtxo: utxo,
+		Unknowns: []*psbt.Unknown{{
+			Key:   PsbtKeyTypeOutputMissingSigPubkey,
+			Value: remoteKey.SerializeCompressed(),
+		}, {
+			Key: append(
+				PsbtKeyTypeInputMuSig2Nonce,
+				localKeyDesc.PubKey.SerializeCompressed()...,
+			),
+			Value: nonces.PubNonce[:],
+		}},
+	}
+
+	return packet, nil
+}
+
+// signTaprootRescuePSBT adds the nonce and partial signature of the remote
+// node to a taproot funding rescue PSBT.
+func signTaprootRescuePSBT(multisigBranch *hdkeychain.ExtendedKey,
+	packet *psbt.Packet, signer *lnd.Signer) error {
+
+	pIn := &packet.Inputs[0]
+	fields, err := parseTaprootRescueFields(pIn)
+	if err != nil {
+		return fmt.Errorf("invalid PSBT: %w", err)
+	}
+	if fields.remoteNonce != nil {
+		return fmt.Errorf("invalid PSBT, already contains a nonce " +
+			"for the remote key")
+	}
+
+	localKeyDesc, err := findLocalMultisigKey(
+		multisigBranch, fields.remoteKey,
+	)
+	if err != nil {
+		return fmt.Errorf("could not find local multisig key: %w", err)
+	}
+	session, err := signer.MuSig2CreateSession(
+		input.MuSig2Version100RC2, localKeyDesc.KeyLocator,
+		[]*btcec.PublicKey{fields.initiatorKey, fields.remoteKey},
+		taprootFundingTweaks,
+		[][musig2.PubNonceSize]byte{*fields.initiatorNonce},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating MuSig2 session: %w", err)
+	}
+	err = checkTaprootFundingKey(
+		session.CombinedKey, pIn.WitnessUtxo.PkScript,
+	)
+	if err != nil {
+		_ = signer.MuSig2Cleanup(session.SessionID)
+		return err
+	}
+
+	sigHash, err := taprootKeySpendSigHash(packet)
+	if err != nil {
+		_ = signer.MuSig2Cleanup(session.SessionID)
+		return err
+	}
+
+	// The initiator combines the signatures, so we don't need the session
+	// anymore after signing.
+	nonce := session.PublicNonce
+	partialSig, err := signer.MuSig2Sign(session.SessionID, sigHash, true)
+	if err != nil {
+		return fmt.Errorf("error signing: %w", err)
+	}
+
+	var sigBuf bytes.Buffer
+	if err := partialSig.Encode(&sigBuf); err != nil {
+		return fmt.Errorf("error encoding partial signature: %w", err)
+	}
+	localKey := localKeyDesc.PubKey.SerializeCompressed()
+	pIn.Unknowns = append(pIn.Unknowns, &psbt.Unknown{
+		Key:   append(PsbtKeyTypeInputMuSig2Nonce, localKey...),
+		Value: nonce[:],
+	}, &psbt.Unknown{
+		Key:   append(PsbtKeyTypeInputMuSig2PartialSig, localKey...),
+		Value: sigBuf.Bytes(),
+	})
+
+	return nil
+}
+
+// finalizeTaprootRescuePSBT adds the initiator's partial signature to a
+// taproot funding rescue PSBT that was signed by the remote node, combines
+// both signatures and extracts the final transaction. The secret nonce file is
+// removed before signing to make sure the nonce is never used twice.
+func finalizeTaprootRescuePSBT(multisigBranch *hdkeychain.ExtendedKey,
+	packet *psbt.Packet, signer *lnd.Signer,
+	nonceFile string) (*wire.MsgTx, error) {
+
+	pIn := &packet.Inputs[0]
+	fields, err := parseTaprootRescueFields(pIn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PSBT: %w", err)
+	}
+	if fields.remoteSig == nil {
+		return nil, fmt.Errorf("invalid PSBT, partial signature of " +
+			"remote node missing")
+	}
+
+	localKeyDesc, err := findLocalMultisigKey(
+		multisigBranch, fields.initiatorKey,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not find local multisig key: %w",
+			err)
+	}
+	nonceHex, err := os.ReadFile(nonceFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading nonce file: %w", err)
+	}
+	nonceBytes, err := hex.DecodeString(
+		strings.TrimSpace(string(nonceHex)),
+	)
+	if err != nil ||
+		len(nonceBytes) != musig2.SecNonceSize+musig2.PubNonceSize {
+
+		return nil, fmt.Errorf("invalid nonce file %s", nonceFile)
+	}
+	nonces := &musig2.Nonces{}
+	copy(nonces.SecNonce[:], nonceBytes[:musig2.SecNonceSize])
+	copy(nonces.PubNonce[:], nonceBytes[musig2.SecNonceSize:])
+	if nonces.PubNonce != *fields.initiatorNonce {
+		return nil, fmt.Errorf("secret nonce in %s doesn't belong to "+
+			"this PSBT", nonceFile)
+	}
+	if err := os.Remove(nonceFile); err != nil {
+		return nil, fmt.Errorf("error removing nonce file: %w", err)
+	}
+
+	session, err := signer.MuSig2ResumeSession(
+		localKeyDesc.KeyLocator,
+		[]*btcec.PublicKey{fields.initiatorKey, fields.remoteKey},
+		taprootFundingTweaks, nonces,
+		[][musig2.PubNonceSize]byte{*fields.remoteNonce},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating MuSig2 session: %w", err)
+	}
+	defer func() { _ = signer.MuSig2Cleanup(session.SessionID) }()
+
+	err = checkTaprootFundingKey(
+		session.CombinedKey, pIn.WitnessUtxo.PkScript,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sigHash, err := taprootKeySpendSigHash(packet)
+	if err != nil {
+		return nil, err
+	}
+	_, err = signer.MuSig2Sign(session.SessionID, sigHash, false)
+	if err != nil {
+		return nil, fmt.Errorf("error signing: %w", err)
+	}
+	finalSig, haveAllSigs, err := signer.MuSig2CombineSig(
+		session.SessionID, []*musig2.PartialSignature{fields.remoteSig},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error combining signatures: %w", err)
+	}
+	if !haveAllSigs {
+		return nil, fmt.Errorf("signature of remote node missing")
+	}
+
+	if !finalSig.Verify(sigHash[:], session.CombinedKey) {
+		return nil, fmt.Errorf("final signature is invalid, the " +
+			"remote node's partial signature might be wrong")
+	}
+
+	pIn.TaprootKeySpendSig = finalSig.Serialize()
+	pIn.Unknowns = nil
+	if err := psbt.MaybeFinalizeAll(packet); err != nil {
+		return nil, fmt.Errorf("error finalizing PSBT: %w", err)
+	}
+	finalTx, err := psbt.Extract(packet)
+	if err != nil {
+		return nil, fmt.Errorf("unable to extract final TX: %w", err)
+	}
+
+	return finalTx, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+type rescueParty struct {
+	signer  *lnd.Signer
+	branch  *hdkeychain.ExtendedKey
+	keyDesc *keychain.KeyDescriptor
+}
+
+func newRescueParty(t *testing.T, key string, index uint32) *rescueParty {
+	extendedKey, err := (&rootKey{RootKey: key}).read()
+	require.NoError(t, err)
+
+	branch, err := lnd.DeriveChildren(extendedKey, []uint32{
+		lnd.HardenedKeyStart + uint32(keychain.BIP0043Purpose),
+		lnd.HardenedKeyStart + chainParams.HDCoinType,
+		lnd.HardenedKeyStart + uint32(keychain.KeyFamilyMultiSig),
+		0,
+	})
+	require.NoError(t, err)
+
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	keyDesc := &keychain.KeyDescriptor{
+		KeyLocator: keychain.KeyLocator{
+			Family: keychain.KeyFamilyMultiSig,
+			Index:  index,
+		},
+	}
+	privKey, err := signer.FetchPrivKey(keyDesc)
+	require.NoError(t, err)
+	keyDesc.PubKey = privKey.PubKey()
+
+	return &rescueParty{
+		signer:  signer,
+		branch:  branch,
+		keyDesc: keyDesc,
+	}
+}
+
+func TestRescueTaprootFunding(t *testing.T) {
+	h := newHarness(t)
+
+	initiator := newRescueParty(t, rootKeyAezeed, 3)
+	remote := newRescueParty(t, rootKeyBip39, 7)
+
+	// Create the simple taproot funding output of both keys.
+	combinedKey, _, _, err := musig2.AggregateKeys(
+		[]*btcec.PublicKey{
+			initiator.keyDesc.PubKey, remote.keyDesc.PubKey,
+		}, true, musig2.WithBIP86KeyTweak(),
+	)
+	require.NoError(t, err)
+	pkScript, err := txscript.PayToTaprootScript(combinedKey.FinalKey)
+	require.NoError(t, err)
+	utxo := &wire.TxOut{Value: 1_000_000, PkScript: pkScript}
+
+	sweepScript := append([]byte{0x00, 0x14}, make([]byte, 20)...)
+
+	// Step 1: The initiator creates the PSBT with its nonce.
+	nonceFile := h.tempFile("nonce.hex")
+	packet, err := createTaprootRescuePSBT(
+		initiator.keyDesc, remote.keyDesc.PubKey, initiator.signer,
+		&wire.TxIn{}, &wire.TxOut{PkScript: sweepScript}, utxo, 10,
+		nonceFile,
+	)
+	require.NoError(t, err)
+	require.FileExists(t, nonceFile)
+	packet = roundTripPSBT(t, packet)
+
+	// Finalizing is not possible before the remote node signed.
+	_, err = finalizeTaprootRescuePSBT(
+		initiator.branch, packet, initiator.signer, nonceFile,
+	)
+	require.ErrorContains(t, err, "partial signature of remote node")
+
+	// Step 2: The remote node adds its nonce and partial signature.
+	err = signTaprootRescuePSBT(remote.branch, packet, remote.signer)
+	require.NoError(t, err)
+	packet = roundTripPSBT(t, packet)
+
+	// The remote node must refuse to sign the same PSBT twice.
+	err = signTaprootRescuePSBT(remote.branch, packet, remote.signer)
+	require.ErrorContains(t, err, "already contains a nonce")
+
+	// Step 3: The initiator adds its signature and extracts the TX.
+	signedPacket := roundTripPSBT(t, packet)
+	finalTx, err := finalizeTaprootRescuePSBT(
+		initiator.branch, packet, initiator.signer, nonceFile,
+	)
+	require.NoError(t, err)
+	require.NoFileExists(t, nonceFile)
+
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(
+		utxo.PkScript, utxo.Value,
+	)
+	vm, err := txscript.NewEngine(
+		utxo.PkScript, finalTx, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(finalTx, prevOutFetcher), utxo.Value,
+		prevOutFetcher,
+	)
+	require.NoError(t, err)
+	require.NoError(t, vm.Execute())
+
+	// The nonce can't be used a second time.
+	_, err = finalizeTaprootRescuePSBT(
+		initiator.branch, signedPacket, initiator.signer, nonceFile,
+	)
+	require.ErrorContains(t, err, "error reading nonce file")
+}
+
+// roundTripPSBT encodes and decodes the packet as a version 2 PSBT, like it
+// would be exchanged with newer tooling.
+func roundTripPSBT(t *testing.T, packet *psbt.Packet) *psbt.Packet {
+	encoded, err := btc.EncodePsbt(packet, btc.PsbtVersion2)
+	require.NoError(t, err)
+
+	decoded, version, err := btc.DecodePsbt(encoded)
+	require.NoError(t, err)
+	require.Equal(t, btc.PsbtVersion2, version)
+
+	return decoded
+}
+
+func TestSignerMuSig2Session(t *testing.T) {
+	_ = newHarness(t)
+
+	alice := newRescueParty(t, rootKeyAezeed, 1)
+	bob := newRescueParty(t, rootKeyBip39, 2)
+	allKeys := []*btcec.PublicKey{alice.keyDesc.PubKey, bob.keyDesc.PubKey}
+	tweaks := &input.MuSig2Tweaks{TaprootBIP0086Tweak: true}
+	msg := [32]byte{1, 2, 3}
+
+	aliceSession, err := alice.signer.MuSig2CreateSession(
+		input.MuSig2Version100RC2, alice.keyDesc.KeyLocator, allKeys,
+		tweaks, nil,
+	)
+	require.NoError(t, err)
+	require.False(t, aliceSession.HaveAllNonces)
+
+	// Signing without the nonce of the other party is not possible.
+	_, err = alice.signer.MuSig2Sign(aliceSession.SessionID, msg, false)
+	require.ErrorContains(t, err, "only have 1 of 2 required nonces")
+
+	// Bob uses a nonce that was generated earlier, for example in a
+	// previous run.
+	bobNonces, err := bob.signer.MuSig2GenNonces(bob.keyDesc.KeyLocator)
+	require.NoError(t, err)
+	bobSession, err := bob.signer.MuSig2ResumeSession(
+		bob.keyDesc.KeyLocator, allKeys, tweaks, bobNonces,
+		[][musig2.PubNonceSize]byte{aliceSession.PublicNonce},
+	)
+	require.NoError(t, err)
+	require.True(t, bobSession.HaveAllNonces)
+	require.Equal(t, bobNonces.PubNonce, bobSession.PublicNonce)
+	require.Equal(t, aliceSession.CombinedKey, bobSession.CombinedKey)
+	require.NotNil(t, bobSession.TaprootInternalKey)
+
+	// The same nonce can't be used for a second session.
+	_, err = bob.signer.MuSig2ResumeSession(
+		bob.keyDesc.KeyLocator, allKeys, tweaks, bobNonces, nil,
+	)
+	require.ErrorContains(t, err, "nonces must not be reused")
+
+	haveAllNonces, err := alice.signer.MuSig2RegisterNonces(
+		aliceSession.SessionID,
+		[][musig2.PubNonceSize]byte{bobSession.PublicNonce},
+	)
+	require.NoError(t, err)
+	require.True(t, haveAllNonces)
+
+	// Bob doesn't combine the signatures, so his session is removed after
+	// signing.
+	bobSig, err := bob.signer.MuSig2Sign(bobSession.SessionID, msg, true)
+	require.NoError(t, err)
+	err = bob.signer.MuSig2Cleanup(bobSession.SessionID)
+	require.ErrorContains(t, err, "not found")
+
+	_, err = alice.signer.MuSig2Sign(aliceSession.SessionID, msg, false)
+	require.NoError(t, err)
+	finalSig, haveAllSigs, err := alice.signer.MuSig2CombineSig(
+		aliceSession.SessionID, []*musig2.PartialSignature{bobSig},
+	)
+	require.NoError(t, err)
+	require.True(t, haveAllSigs)
+	require.True(t, finalSig.Verify(msg[:], aliceSession.CombinedKey))
+
+	_, _, err = alice.signer.MuSig2CombineSig(
+		aliceSession.SessionID, []*musig2.PartialSignature{bobSig},
+	)
+	require.ErrorContains(t, err, "not found")
+}
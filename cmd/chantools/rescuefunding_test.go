@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRescueFundingBatch(t *testing.T) {
+	initiator := newRescueParty(t, rootKeyAezeed, 3)
+	remote := newRescueParty(t, rootKeyBip39, 7)
+	initiator2 := newRescueParty(t, rootKeyAezeed, 4)
+	remote2 := newRescueParty(t, rootKeyBip39, 9)
+
+	// Create two multisig funding outputs with different keys.
+	var (
+		channels []*fundingRescueChannel
+		utxos    []*wire.TxOut
+	)
+	for idx, parties := range [][2]*rescueParty{
+		{initiator, remote}, {initiator2, remote2},
+	} {
+		_, fundingOut, err := input.GenFundingPkScript(
+			parties[0].keyDesc.PubKey.SerializeCompressed(),
+			parties[1].keyDesc.PubKey.SerializeCompressed(),
+			int64(500_000*(idx+1)),
+		)
+		require.NoError(t, err)
+
+		channels = append(channels, &fundingRescueChannel{
+			localKeyDesc: parties[0].keyDesc,
+			remotePubKey: parties[1].keyDesc.PubKey,
+			chainOp: &wire.OutPoint{
+				Hash:  chainhash.Hash{byte(idx + 1)},
+				Index: uint32(idx),
+			},
+		})
+		utxos = append(utxos, fundingOut)
+	}
+
+	sweepScript := append([]byte{0x00, 0x14}, make([]byte, 20)...)
+	packet, err := createRescueFundingPSBT(
+		channels, utxos, initiator.signer, sweepScript,
+		btcutil.Amount(10),
+	)
+	require.NoError(t, err)
+	require.Len(t, packet.UnsignedTx.TxIn, 2)
+	require.Len(t, packet.UnsignedTx.TxOut, 1)
+	require.Less(t, packet.UnsignedTx.TxOut[0].Value, int64(1_500_000))
+	packet = roundTripPSBT(t, packet)
+
+	// The remote node signs both inputs in one go.
+	finalTx, err := signRescueMultisigPSBT(
+		remote.branch, packet, remote.signer,
+	)
+	require.NoError(t, err)
+
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut)
+	for idx, channel := range channels {
+		prevOuts[*channel.chainOp] = utxos[idx]
+	}
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
+	sigHashes := txscript.NewTxSigHashes(finalTx, prevOutFetcher)
+	for idx, utxo := range utxos {
+		vm, err := txscript.NewEngine(
+			utxo.PkScript, finalTx, idx,
+			txscript.StandardVerifyFlags, nil, sigHashes,
+			utxo.Value, prevOutFetcher,
+		)
+		require.NoError(t, err)
+		require.NoError(t, vm.Execute())
+	}
+}
+
+func TestRescueFundingExternalSigner(t *testing.T) {
+	initiator := newRescueParty(t, rootKeyAezeed, 3)
+	remote := newRescueParty(t, rootKeyBip39, 7)
+
+	_, fundingOut, err := input.GenFundingPkScript(
+		initiator.keyDesc.PubKey.SerializeCompressed(),
+		remote.keyDesc.PubKey.SerializeCompressed(), 1_000_000,
+	)
+	require.NoError(t, err)
+	channels := []*fundingRescueChannel{{
+		localKeyDesc: initiator.keyDesc,
+		remotePubKey: remote.keyDesc.PubKey,
+		chainOp:      &wire.OutPoint{Index: 1},
+	}}
+	utxos := []*wire.TxOut{fundingOut}
+
+	sweepScript := append([]byte{0x00, 0x14}, make([]byte, 20)...)
+	packet, err := createRescueFundingPSBT(
+		channels, utxos, initiator.signer, sweepScript,
+		btcutil.Amount(10),
+	)
+	require.NoError(t, err)
+
+	// The hardware signer only exposes the xpub of the multisig account.
+	accountKey, err := lnd.DeriveChildren(
+		remote.signer.ExtendedKey, []uint32{
+			lnd.HardenedKeyStart + uint32(keychain.BIP0043Purpose),
+			lnd.HardenedKeyStart + chainParams.HDCoinType,
+			lnd.HardenedKeyStart + uint32(
+				keychain.KeyFamilyMultiSig,
+			),
+		},
+	)
+	require.NoError(t, err)
+	accountXPub, err := accountKey.Neuter()
+	require.NoError(t, err)
+
+	err = addExternalSignerDerivation(accountXPub, 0x01020304, packet)
+	require.NoError(t, err)
+	packet = roundTripPSBT(t, packet)
+
+	derivations := packet.Inputs[0].Bip32Derivation
+	require.Len(t, derivations, 1)
+	require.Equal(
+		t, remote.keyDesc.PubKey.SerializeCompressed(),
+		derivations[0].PubKey,
+	)
+	require.EqualValues(t, 0x01020304, derivations[0].MasterKeyFingerprint)
+	require.Equal(t, []uint32{
+		lnd.HardenedKeyStart + uint32(keychain.BIP0043Purpose),
+		lnd.HardenedKeyStart + chainParams.HDCoinType,
+		lnd.HardenedKeyStart + uint32(keychain.KeyFamilyMultiSig),
+		0, 7,
+	}, derivations[0].Bip32Path)
+
+	// Let the "hardware signer" sign and then finalize the PSBT.
+	err = remote.signer.AddPartialSignature(
+		packet, *remote.keyDesc, fundingOut,
+		packet.Inputs[0].WitnessScript, 0,
+	)
+	require.NoError(t, err)
+	require.NoError(t, psbt.MaybeFinalizeAll(packet))
+	_, err = psbt.Extract(packet)
+	require.NoError(t, err)
+}
+
+func TestRescueFundingAbandoned(t *testing.T) {
+	h := newHarness(t)
+
+	db, err := lnd.OpenDB(h.testdataFile("channel.db"), true)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	channels, err := db.ChannelStateDB().FetchAllChannels()
+	require.NoError(t, err)
+	require.Len(t, channels, 4)
+
+	// The funding output of the first channel is unspent, the second one
+	// is spent, the third one is unconfirmed and the funding TX of the
+	// fourth one was never published.
+	api := &mockChainAPI{txs: make(map[string]*btc.TX)}
+	for idx, channel := range channels[:3] {
+		chanPoint := channel.FundingOutpoint
+		vouts := make([]*btc.Vout, chanPoint.Index+1)
+		for voutIdx := range vouts {
+			vouts[voutIdx] = &btc.Vout{
+				Value:    100_000,
+				Outspend: &btc.Outspend{Spent: idx == 1},
+			}
+		}
+		api.txs[chanPoint.Hash.String()] = &btc.TX{
+			Vout:   vouts,
+			Status: &btc.Status{Confirmed: idx != 2},
+		}
+	}
+
+	abandoned, err := unspentFundingOutputs(channels, api)
+	require.NoError(t, err)
+	require.Len(t, abandoned, 1)
+	require.Equal(t, channels[0].FundingOutpoint, *abandoned[0].databaseOp)
+	require.Equal(t, channels[0].IdentityPub, abandoned[0].remoteNode)
+	require.Equal(
+		t, channels[0].RemoteChanCfg.MultiSigKey.PubKey,
+		abandoned[0].remotePubKey,
+	)
+	h.assertLogContains("was already spent")
+	h.assertLogContains("is not confirmed yet")
+	h.assertLogContains("it was never published")
+
+	// The channels are batched by their remote node.
+	otherNode, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	batches := rescueBatchesByNode([]*fundingRescueChannel{
+		{remoteNode: channels[0].IdentityPub},
+		{remoteNode: otherNode.PubKey()},
+		{remoteNode: channels[0].IdentityPub},
+	})
+	require.Len(t, batches, 2)
+	require.Len(t, batches[0], 2)
+	require.Len(t, batches[1], 1)
+}
+
+func TestRescueFundingJSON(t *testing.T) {
+	h := newHarness(t)
+
+	initiator := newRescueParty(t, rootKeyAezeed, 3)
+	remote := newRescueParty(t, rootKeyBip39, 7)
+	_, fundingOut, err := input.GenFundingPkScript(
+		initiator.keyDesc.PubKey.SerializeCompressed(),
+		remote.keyDesc.PubKey.SerializeCompressed(), 1_000_000,
+	)
+	require.NoError(t, err)
+
+	txid := chainhash.Hash{1}.String()
+	responses := map[string]interface{}{
+		"/tx/" + txid: &btc.TX{
+			TXID: txid,
+			Vout: []*btc.Vout{{
+				ScriptPubkey: hex.EncodeToString(
+					fundingOut.PkScript,
+				),
+				Value: uint64(fundingOut.Value),
+			}},
+			Status: &btc.Status{Confirmed: true},
+		},
+		"/tx/" + txid + "/outspends": []*btc.Outspend{{}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			response, ok := responses[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(response)
+		},
+	))
+	defer server.Close()
+
+	sweepAddr, err := lnd.P2WKHAddr(remote.keyDesc.PubKey, chainParams)
+	require.NoError(t, err)
+
+	// With --json only the PSBT is written to stdout.
+	h.captureJSON()
+	rescue := newRescueFundingCommand()
+	rescue.SetArgs([]string{
+		"--rootkey", rootKeyAezeed,
+		"--apiurl", server.URL,
+		"--confirmedchannelpoint", txid + ":0",
+		"--localkeyindex", "3",
+		"--remotepubkey", hex.EncodeToString(
+			remote.keyDesc.PubKey.SerializeCompressed(),
+		),
+		"--sweepaddr", sweepAddr.String(),
+		"--feerate", "10",
+	})
+	require.NoError(t, rescue.Execute())
+	var results []*rescueFundingResult
+	h.assertJSONOutput(&results)
+	require.Len(t, results, 1)
+	require.Empty(t, results[0].NonceFile)
+
+	// The remote node counter signs the PSBT and gets the final
+	// transaction.
+	h.captureJSON()
+	sign := &signRescueFundingCommand{
+		Psbt:    results[0].Psbt,
+		rootKey: &rootKey{RootKey: rootKeyBip39},
+	}
+	require.NoError(t, sign.Execute(nil, nil))
+	var signResult rawTxResult
+	h.assertJSONOutput(&signResult)
+
+	rawTx, err := hex.DecodeString(signResult.RawTx)
+	require.NoError(t, err)
+	finalTx := wire.NewMsgTx(2)
+	require.NoError(t, finalTx.Deserialize(bytes.NewReader(rawTx)))
+	require.Equal(t, finalTx.TxHash().String(), signResult.TxID)
+	require.Equal(t, txid+":0", finalTx.TxIn[0].PreviousOutPoint.String())
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/hex"
 	"fmt"
 
 	"github.com/btcsuite/btcd/btcec/v2"
@@ -18,6 +19,12 @@ var (
 	ErrAddrNotFound = fmt.Errorf("address not found")
 )
 
+// rescueTweakedKeyResult is the JSON result of the rescuetweakedkey command.
+type rescueTweakedKeyResult struct {
+	Address string `json:"address"`
+	PrivKey string `json:"privkey"`
+}
+
 type rescueTweakedKeyCommand struct {
 	Path       string
 	TargetAddr string
@@ -84,40 +91,47 @@ func (c *rescueTweakedKeyCommand) Execute(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("error parsing target addr: %w", err)
 	}
 
-	return testPattern(startKey, targetAddr, c.NumTries)
+	privKey, err := testPattern(startKey, targetAddr, c.NumTries)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Success! Found private key %x for address %v",
+		privKey.Serialize(), targetAddr)
+
+	return printJSON(&rescueTweakedKeyResult{
+		Address: targetAddr.String(),
+		PrivKey: hex.EncodeToString(privKey.Serialize()),
+	})
 }
 
+// testPattern mutates the start key in the pattern of the lnd bug until its
+// public key matches the target address and returns the found private key.
 func testPattern(startKey *btcec.PrivateKey, targetAddr btcutil.Address,
-	max uint64) error {
+	max uint64) (*btcec.PrivateKey, error) {
 
 	currentKey := copyPrivKey(startKey)
 	for idx := uint64(0); idx <= max; idx++ {
 		match, err := pubKeyMatchesAddr(currentKey.PubKey(), targetAddr)
 		if err != nil {
-			return fmt.Errorf("error matching key to address: %w",
-				err)
+			return nil, fmt.Errorf("error matching key to address: "+
+				"%w", err)
 		}
 
 		if match {
-			log.Infof("Success! Found private key %x for "+
-				"address %v\n", currentKey.Serialize(),
-				targetAddr)
-			return nil
+			return currentKey, nil
 		}
 
 		mutateWithTweak(currentKey)
 
 		match, err = pubKeyMatchesAddr(currentKey.PubKey(), targetAddr)
 		if err != nil {
-			return fmt.Errorf("error matching key to address: %w",
-				err)
+			return nil, fmt.Errorf("error matching key to address: "+
+				"%w", err)
 		}
 
 		if match {
-			log.Infof("Success! Found private key %x for "+
-				"address %v\n", currentKey.Serialize(),
-				targetAddr)
-			return nil
+			return currentKey, nil
 		}
 
 		keyCopy := copyPrivKey(currentKey)
@@ -125,35 +139,31 @@ func testPattern(startKey *btcec.PrivateKey, targetAddr btcutil.Address,
 
 		match, err = pubKeyMatchesAddr(keyCopy.PubKey(), targetAddr)
 		if err != nil {
-			return fmt.Errorf("error matching key to address: %w",
-				err)
+			return nil, fmt.Errorf("error matching key to address: "+
+				"%w", err)
 		}
 
 		if match {
-			log.Infof("Success! Found private key %x for "+
-				"address %v\n", keyCopy.Serialize(),
-				targetAddr)
-			return nil
+			return keyCopy, nil
 		}
 
 		if idx != 0 && idx%5000 == 0 {
-			fmt.Printf("Tested %d of %d mutations\n", idx, max)
+			fmt.Fprintf(textOut(), "Tested %d of %d mutations\n",
+				idx, max)
 		}
 	}
 
 	match, err := pubKeyMatchesAddr(currentKey.PubKey(), targetAddr)
 	if err != nil {
-		return fmt.Errorf("error matching key to address: %w", err)
+		return nil, fmt.Errorf("error matching key to address: %w", err)
 	}
 
 	if match {
-		log.Infof("Success! Found private key %x for address %v\n",
-			currentKey.Serialize(), targetAddr)
-		return nil
+		return currentKey, nil
 	}
 
-	return fmt.Errorf("%w: key for address %v not found after %d attempts",
-		ErrAddrNotFound, targetAddr.String(), max)
+	return nil, fmt.Errorf("%w: key for address %v not found after %d "+
+		"attempts", ErrAddrNotFound, targetAddr.String(), max)
 }
 
 func pubKeyMatchesAddr(pubKey *btcec.PublicKey, addr btcutil.Address) (bool,
@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/guggero/chantools/lnd"
 	"github.com/stretchr/testify/require"
 )
 
@@ -61,3 +63,38 @@ func TestTweak(t *testing.T) {
 		"%x", privKeyCopy.PubKey().SerializeCompressed(),
 	)
 }
+
+func TestRescueTweakedKeyJSON(t *testing.T) {
+	h := newHarness(t)
+
+	const path = "m/1017'/1'/5'/0/0"
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	childKey, _, _, err := lnd.DeriveKey(extendedKey, path, chainParams)
+	require.NoError(t, err)
+	privKey, err := childKey.ECPrivKey()
+	require.NoError(t, err)
+
+	// The funds are locked in an address of the once tweaked key.
+	tweakedKey := copyPrivKey(privKey)
+	mutateWithTweak(tweakedKey)
+	addr, err := lnd.P2WKHAddr(tweakedKey.PubKey(), chainParams)
+	require.NoError(t, err)
+
+	rescue := &rescueTweakedKeyCommand{
+		Path:       path,
+		TargetAddr: addr.String(),
+		NumTries:   10,
+		rootKey:    &rootKey{RootKey: rootKeyAezeed},
+	}
+
+	// With --json only the result is written to stdout.
+	h.captureJSON()
+	require.NoError(t, rescue.Execute(nil, nil))
+	var result rescueTweakedKeyResult
+	h.assertJSONOutput(&result)
+	require.Equal(t, addr.String(), result.Address)
+	require.Equal(
+		t, hex.EncodeToString(tweakedKey.Serialize()), result.PrivKey,
+	)
+}
@@ -3,32 +3,48 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btclog"
 	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/dataformat"
 	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/sweep"
+	"github.com/jrick/logrotate/rotator"
 	"github.com/lightningnetwork/lnd/build"
 	"github.com/lightningnetwork/lnd/chanbackup"
 	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lncfg"
 	"github.com/lightningnetwork/lnd/peer"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
 const (
-	defaultAPIURL = "https://blockstream.info/api"
-	version       = "0.10.7"
-	na            = "n/a"
+	defaultAPIURL       = "https://blockstream.info/api"
+	defaultMempoolURL   = "https://mempool.space/api"
+	defaultAPIRateLimit = 10
+	defaultAPIRetries   = 5
+	defaultNeutrinoDir  = "~/.chantools/neutrino"
+	defaultAPICacheDir  = "~/.chantools/apicache"
+	defaultTLSCertPath  = "~/.lnd/tls.cert"
+	version             = "0.10.7"
+	na                  = "n/a"
 
 	Commit = ""
 )
@@ -37,11 +53,69 @@ var (
 	Testnet bool
 	Regtest bool
 
+	// dbConfig is the database backend configuration used for opening
+	// lnd's channel DB. The path is set by each command individually.
+	dbConfig = &lnd.DBConfig{}
+
+	// logWriter only keeps track of the sub loggers and their levels, the
+	// log itself is written to logOut.
 	logWriter   = build.NewRotatingLogWriter()
-	log         = build.NewSubLogger("CHAN", genSubLogger(logWriter))
+	logOut      = &logOutput{console: os.Stdout}
+	logBackend  = btclog.NewBackend(logOut)
+	log         = build.NewSubLogger("CHAN", genSubLogger)
 	chainParams = &chaincfg.MainNetParams
+
+	// resultOut is the standard output that commands can write their
+	// result to if they're asked to. The log and all other text output
+	// is written to stderr in that case.
+	resultOut io.Writer = os.Stdout
 )
 
+// annotationStdoutFormat is the cobra command annotation that names the
+// comma separated values of the command's --format flag that make it write its
+// result to stdout.
+const annotationStdoutFormat = "stdout_format"
+
+// annotationStdoutFlag is the cobra command annotation that names a boolean flag
+// of the command that makes it write its result to stdout if set.
+const annotationStdoutFlag = "stdout_flag"
+
+// resultToStdout returns true if the command was asked to write its result to
+// stdout, either with the --stdout flag or by choosing a machine readable
+// output format.
+func resultToStdout(cmd *cobra.Command) bool {
+	if JSONOutput {
+		return true
+	}
+
+	if f := cmd.Flags().Lookup("stdout"); f != nil &&
+		f.Value.String() == "true" {
+
+		return true
+	}
+
+	if name, ok := cmd.Annotations[annotationStdoutFlag]; ok {
+		f := cmd.Flags().Lookup(name)
+		if f != nil && f.Value.String() == "true" {
+			return true
+		}
+	}
+
+	formats, ok := cmd.Annotations[annotationStdoutFormat]
+	f := cmd.Flags().Lookup("format")
+	if !ok || f == nil {
+		return false
+	}
+
+	for _, format := range strings.Split(formats, ",") {
+		if f.Value.String() == format {
+			return true
+		}
+	}
+
+	return false
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "chantools",
 	Short: "Chantools helps recover funds from lightning channels",
@@ -49,7 +123,20 @@ var rootCmd = &cobra.Command{
 funds locked in lnd channels in case lnd itself cannot run properly anymore.
 Complete documentation is available at https://github.com/guggero/chantools/.`,
 	Version: fmt.Sprintf("v%s, commit %s", version, Commit),
-	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Flags that weren't set on the command line can be set in the
+		// config file or through environment variables.
+		cfg, err := readConfig(ConfigFile)
+		if err != nil {
+			return err
+		}
+		if err := applyConfig(cmd, cfg); err != nil {
+			return err
+		}
+		if err := applyJSONFlags(cmd); err != nil {
+			return err
+		}
+
 		switch {
 		case Testnet:
 			chainParams = &chaincfg.TestNet3Params
@@ -61,15 +148,60 @@ Complete documentation is available at https://github.com/guggero/chantools/.`,
 			chainParams = &chaincfg.MainNetParams
 		}
 
+		// The log is written to stdout. If a command writes its result
+		// there, we need to move the log out of the way.
+		stdoutReserved = resultToStdout(cmd)
+		if stdoutReserved {
+			logOut.console = os.Stderr
+		}
+
+		if err := createWorkDir(); err != nil {
+			return err
+		}
+
 		setupLogging()
 
 		log.Infof("chantools version v%s commit %s", version,
 			Commit)
+
+		return nil
 	},
 	DisableAutoGenTag: true,
 }
 
 func main() {
+	rootCmd.PersistentFlags().StringVar(
+		&ConfigFile, "configfile", defaultConfigFile, "config file "+
+			"to read the default values of all flags from; "+
+			"flags can also be set with "+configEnvPrefix+
+			"<FLAG> environment variables",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&WorkDir, "workdir", defaultWorkDir, "directory to write all "+
+			"result files and the log file to",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&NoTimestamp, "notimestamp", false, "don't add a timestamp "+
+			"to the names of result files, so each command "+
+			"always writes to <workdir>/<command>.<ext> and "+
+			"repeated runs overwrite the previous result",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&ResultFile, "resultfile", "", "file to write the main "+
+			"result of the command to instead of a file in the "+
+			"working directory",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&JSONOutput, "json", false, "write the result of the "+
+			"command (for example the created transaction) to "+
+			"stdout as JSON; all log output is written to stderr",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&DryRun, "dryrun", false, "never publish any transaction, "+
+			"print a decoded preview of it instead (inputs, "+
+			"outputs, fee, fee rate and weight); commands that "+
+			"modify a database only show the changes",
+	)
 	rootCmd.PersistentFlags().BoolVarP(
 		&Testnet, "testnet", "t", false, "Indicates if testnet "+
 			"parameters should be used",
@@ -79,35 +211,87 @@ func main() {
 			"parameters should be used",
 	)
 
+	rootCmd.PersistentFlags().StringVar(
+		&dbConfig.Backend, "dbbackend", lnd.DBBackendBolt, "the "+
+			"database backend of the lnd channel DB; either '"+
+			lnd.DBBackendBolt+"', '"+lnd.DBBackendSqlite+"' or '"+
+			lnd.DBBackendPostgres+"'; for '"+
+			lnd.DBBackendSqlite+"' the channel DB flag of a "+
+			"command must point to the channel.sqlite file, for '"+
+			lnd.DBBackendPostgres+"' the channel DB flag is "+
+			"not needed",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&dbConfig.PostgresDSN, "postgresdsn", "", "the connection "+
+			"string of the Postgres database when using the '"+
+			lnd.DBBackendPostgres+"' database backend",
+	)
+	rootCmd.PersistentFlags().DurationVar(
+		&dbConfig.Timeout, "dbtimeout", lnd.DefaultOpenTimeout, "the "+
+			"timeout for connecting to the database when using "+
+			"the '"+lnd.DBBackendSqlite+"' or '"+
+			lnd.DBBackendPostgres+"' database backend",
+	)
+
 	rootCmd.AddCommand(
+		newAuditCommand(),
 		newChanBackupCommand(),
 		newClosePoolAccountCommand(),
 		newCompactDBCommand(),
+		newConvertDBCommand(),
+		newDaemonCommand(),
+		newDecodeCommitCommand(),
 		newDeletePaymentsCommand(),
+		newDeriveChannelKeysCommand(),
 		newDeriveKeyCommand(),
 		newDropChannelGraphCommand(),
 		newDumpBackupCommand(),
 		newDumpChannelsCommand(),
+		newDumpForwardsCommand(),
+		newDumpInvoicesCommand(),
+		newDumpPaymentsCommand(),
+		newDumpPeersCommand(),
+		newDumpRevocationLogCommand(),
 		newDocCommand(),
+		newEncodeBackupCommand(),
 		newFakeChanBackupCommand(),
 		newFilterBackupCommand(),
+		newFindPassphraseCommand(),
+		newFindKeyCommand(),
+		newFindSeedWordsCommand(),
 		newFixOldBackupCommand(),
 		newForceCloseCommand(),
+		newGenDescriptorsCommand(),
 		newGenImportScriptCommand(),
+		newMergeBackupsCommand(),
 		newMigrateDBCommand(),
+		newMonitorCommand(),
+		newOfflineSweepCommand(),
+		newRecoverCommand(),
+		newRecoverLoopInCommand(),
+		newReEncryptBackupCommand(),
+		newRehearseCommand(),
 		newRemoveChannelCommand(),
 		newRescueClosedCommand(),
 		newRescueFundingCommand(),
 		newRescueTweakedKeyCommand(),
+		newSalvageDBCommand(),
 		newShowRootKeyCommand(),
+		newSignMessageCommand(),
+		newSignPsbtCommand(),
 		newSignRescueFundingCommand(),
 		newSummaryCommand(),
+		newSweepBreachCommand(),
+		newSweepHTLCsCommand(),
 		newSweepTimeLockCommand(),
 		newSweepTimeLockManualCommand(),
 		newSweepRemoteClosedCommand(),
+		newSweepWalletCommand(),
 		newTriggerForceCloseCommand(),
 		newVanityGenCommand(),
+		newVerifyMessageCommand(),
 		newWalletInfoCommand(),
+		newWizardCommand(),
 		newZombieRecoveryCommand(),
 	)
 
@@ -117,9 +301,25 @@ func main() {
 	}
 }
 
+// rootKeyEnvName is the environment variable the BIP32 HD root key can be
+// read from.
+const rootKeyEnvName = "ROOT_KEY"
+
+// presetRootKey is the root key the wizard already read. It is used by the
+// commands the wizard runs so the seed only needs to be entered once.
+var presetRootKey *hdkeychain.ExtendedKey
+
 type rootKey struct {
-	RootKey string
-	BIP39   bool
+	RootKey     string
+	BIP39       bool
+	SeedFile    string
+	Stdin       bool
+	AccountXPrv string
+
+	// mnemonic and passphrase are the seed and its passphrase read from
+	// the seed file or stdin.
+	mnemonic   string
+	passphrase string
 }
 
 func newRootKey(cmd *cobra.Command, desc string) *rootKey {
@@ -134,10 +334,362 @@ func newRootKey(cmd *cobra.Command, desc string) *rootKey {
 			"passphrase from the terminal instead of asking for "+
 			"lnd seed format or providing the --rootkey flag",
 	)
+	cmd.Flags().StringVar(
+		&r.SeedFile, "seedfile", "", "file to read the BIP32 HD root "+
+			"key or the seed from instead of the terminal; the "+
+			"first line must contain the root key or the seed "+
+			"words, the optional second line the seed passphrase",
+	)
+	cmd.Flags().BoolVar(
+		&r.Stdin, "stdin", false, "read the BIP32 HD root key or the "+
+			"seed from stdin instead of the terminal; same format "+
+			"as --seedfile",
+	)
+	cmd.Flags().StringVar(
+		&r.AccountXPrv, "accountxprv", "", "extended private key of "+
+			"a single lnd key family account "+
+			"(m/1017'/<coin_type>'/<key_family>') to use instead "+
+			"of the root key; only keys of that key family can "+
+			"be derived",
+	)
 
 	return r
 }
 
+// walletSigner derives the public keys of the node's wallet and signs inputs
+// spending outputs locked to them.
+type walletSigner = sweep.Signer
+
+// remoteSigner are the flags for signing with the keys of a remote lnd node
+// instead of the seed.
+type remoteSigner struct {
+	Host         string
+	TLSCertPath  string
+	MacaroonPath string
+}
+
+func newRemoteSigner(cmd *cobra.Command) *remoteSigner {
+	r := &remoteSigner{}
+	cmd.Flags().StringVar(
+		&r.Host, "remotesigner", "", "host:port of the gRPC "+
+			"interface of an lnd node (for example the signer "+
+			"node of a remote signing setup) to derive the keys "+
+			"and sign with instead of the seed, which then never "+
+			"needs to be entered",
+	)
+	cmd.Flags().StringVar(
+		&r.TLSCertPath, "remotesignertlscert", defaultTLSCertPath,
+		"the TLS certificate of the remote signer lnd node",
+	)
+	cmd.Flags().StringVar(
+		&r.MacaroonPath, "remotesignermacaroon", "", "the macaroon "+
+			"to authenticate at the remote signer lnd node with; "+
+			"needs the permission to derive keys and sign, e.g. "+
+			"the admin.macaroon",
+	)
+
+	return r
+}
+
+// enabled returns true if a remote signer should be used.
+func (r *remoteSigner) enabled() bool {
+	return r.Host != ""
+}
+
+// connect connects to the remote signer lnd node.
+func (r *remoteSigner) connect() (*lnd.RemoteSigner, error) {
+	if r.MacaroonPath == "" {
+		return nil, fmt.Errorf("the remote signer macaroon is " +
+			"required")
+	}
+
+	return lnd.DialRemoteSigner(
+		r.Host, lncfg.CleanAndExpandPath(r.TLSCertPath),
+		lncfg.CleanAndExpandPath(r.MacaroonPath),
+	)
+}
+
+// walletSigner returns the signer a sweep command derives its keys and signs
+// with: The remote signer if one is configured, otherwise a signer that uses
+// the root key. The returned function closes the connection to the remote
+// signer and must always be called once the signer is no longer needed.
+func (r *remoteSigner) walletSigner(rootKey *rootKey,
+	hw *hwSigner) (walletSigner, func(), error) {
+
+	if r.enabled() {
+		remoteSigner, err := r.connect()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return remoteSigner, func() { _ = remoteSigner.Close() }, nil
+	}
+
+	extendedKey, err := hw.readRootKey(rootKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading root key: %w", err)
+	}
+
+	return &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}, func() {}, nil
+}
+
+// hwSigner are the flags for delegating the signing step of a command to a
+// hardware wallet through a PSBT. The command is run twice: First to export
+// the PSBT, then again with the same flags and the PSBT signed by the
+// hardware wallet to create the final transaction.
+//
+// The inputs of the PSBT contain the standard BIP32 derivation and witness
+// script fields. Inputs that are locked to a tweaked key (for example to_local,
+// HTLC or revoked outputs) additionally need the tweak, which there is no
+// standard field for. Those are stored in the same fields lnd uses, so only lnd
+// (SignPsbt) and 'chantools offlinesweep sign' can sign them. Hardware wallets
+// can only sign inputs with untweaked keys, like the to_remote output of
+// static_remote_key, anchor and simple taproot channels, Loop In HTLCs and
+// wallet outputs.
+type hwSigner struct {
+	ExportPsbt  bool
+	SignedPsbt  string
+	Fingerprint string
+	XPubs       []string
+
+	// offline is set if the PSBT is signed by chantools on an offline
+	// machine instead of a hardware wallet.
+	offline bool
+
+	signer *lnd.PsbtSigner
+}
+
+func newHWSigner(cmd *cobra.Command) *hwSigner {
+	h := &hwSigner{}
+	cmd.Flags().BoolVar(
+		&h.ExportPsbt, "hwexport", false, "don't sign the sweep "+
+			"transaction but export it as a PSBT that can be "+
+			"signed by a hardware wallet (e.g. with HWI); inputs "+
+			"with tweaked keys (to_local, HTLC and revoked "+
+			"outputs) can only be signed by lnd or 'chantools "+
+			"offlinesweep sign'",
+	)
+	cmd.Flags().StringVar(
+		&h.SignedPsbt, "hwsignedpsbt", "", "the PSBT exported with "+
+			"--hwexport after it was signed by the hardware "+
+			"wallet; all other flags must be the same as for the "+
+			"export",
+	)
+	cmd.Flags().StringVar(
+		&h.Fingerprint, "hwfingerprint", "", "hex encoded master key "+
+			"fingerprint of the hardware wallet; defaults to the "+
+			"fingerprint of the root key",
+	)
+
+	return h
+}
+
+// registerXPubFlag adds the flag for the extended public keys of the hardware
+// wallet. It is only registered by commands that don't need any private key
+// besides the ones the hardware wallet signs with.
+func (h *hwSigner) registerXPubFlag(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(
+		&h.XPubs, "hwxpub", nil, "extended public key of an lnd key "+
+			"family account (m/1017'/<coin_type>'/<key_family>') "+
+			"of the hardware wallet; if set, the PSBT is created "+
+			"from the extended public keys alone and the seed "+
+			"isn't needed; can be specified multiple times, "+
+			"requires --hwfingerprint",
+	)
+}
+
+// readRootKey reads the root key, unless the extended public keys of the
+// hardware wallet are given. Those are enough to export the PSBT and to create
+// the final transaction, so no root key is returned in that case.
+func (h *hwSigner) readRootKey(r *rootKey) (*hdkeychain.ExtendedKey, error) {
+	if len(h.XPubs) == 0 {
+		return r.read()
+	}
+
+	if !h.ExportPsbt && h.SignedPsbt == "" {
+		return nil, fmt.Errorf("--hwxpub can only be used together " +
+			"with --hwexport or --hwsignedpsbt")
+	}
+
+	return nil, nil
+}
+
+// exporting returns true if the sweep transaction should be exported as a PSBT
+// instead of being signed.
+func (h *hwSigner) exporting() bool {
+	return h.ExportPsbt
+}
+
+// wrap returns the signer the command should sign with. If a hardware wallet
+// is used, the given signer is only used to derive the public keys.
+func (h *hwSigner) wrap(signer walletSigner) (walletSigner, error) {
+	if !h.ExportPsbt && h.SignedPsbt == "" {
+		return signer, nil
+	}
+	if h.ExportPsbt && h.SignedPsbt != "" {
+		return nil, fmt.Errorf("cannot use --hwexport together with " +
+			"--hwsignedpsbt")
+	}
+
+	var (
+		keyDeriver  lnd.KeyDeriver = signer
+		fingerprint uint32
+	)
+	if len(h.XPubs) > 0 {
+		if h.Fingerprint == "" {
+			return nil, fmt.Errorf("--hwfingerprint is required " +
+				"with --hwxpub")
+		}
+
+		deriver := &lnd.AccountXPubDeriver{}
+		for _, xpub := range h.XPubs {
+			accountKey, err := lnd.ParseAccountXPub(xpub)
+			if err != nil {
+				return nil, err
+			}
+			deriver.AccountKeys = append(
+				deriver.AccountKeys, accountKey,
+			)
+		}
+		keyDeriver = deriver
+	}
+
+	switch {
+	case h.Fingerprint != "":
+		var err error
+		fingerprint, err = parseFingerprint(h.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		localSigner, ok := signer.(*lnd.Signer)
+		if !ok || localSigner.ExtendedKey.Depth() != 0 {
+			return nil, fmt.Errorf("the hardware wallet " +
+				"fingerprint is required")
+		}
+		pubKey, err := localSigner.ExtendedKey.ECPubKey()
+		if err != nil {
+			return nil, fmt.Errorf("error deriving root public "+
+				"key: %w", err)
+		}
+		fingerprint = binary.LittleEndian.Uint32(
+			btcutil.Hash160(pubKey.SerializeCompressed())[:4],
+		)
+	}
+
+	h.signer = &lnd.PsbtSigner{
+		KeyDeriver:  keyDeriver,
+		ChainParams: chainParams,
+		Fingerprint: fingerprint,
+	}
+	if h.SignedPsbt != "" {
+		packet, _, err := btc.DecodePsbt(h.SignedPsbt)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding signed PSBT: %w",
+				err)
+		}
+		h.signer.SignedPacket = packet
+	}
+
+	return h.signer, nil
+}
+
+// parseFingerprint parses a hex encoded master key fingerprint into the byte
+// order used by the psbt package.
+func parseFingerprint(fingerprint string) (uint32, error) {
+	fingerprintBytes, err := hex.DecodeString(fingerprint)
+	if err != nil || len(fingerprintBytes) != 4 {
+		return 0, fmt.Errorf("invalid fingerprint %s, must be 4 bytes "+
+			"hex encoded", fingerprint)
+	}
+
+	return binary.LittleEndian.Uint32(fingerprintBytes), nil
+}
+
+// psbtResult is the JSON result of a command that exports a PSBT.
+type psbtResult struct {
+	Psbt string `json:"psbt"`
+}
+
+// rawTxResult is the JSON result of a command that extracts a final
+// transaction.
+type rawTxResult struct {
+	TxID  string `json:"txid"`
+	RawTx string `json:"raw_tx"`
+}
+
+// newRawTxResult creates the result of the given transaction and its
+// serialization.
+func newRawTxResult(tx *wire.MsgTx, rawTx []byte) *rawTxResult {
+	return &rawTxResult{
+		TxID:  tx.TxHash().String(),
+		RawTx: hex.EncodeToString(rawTx),
+	}
+}
+
+// export prints the PSBT with all inputs the hardware wallet needs to sign. The
+// given transaction must be the one built with the placeholder signatures.
+func (h *hwSigner) export(tx *wire.MsgTx) error {
+	if err := h.signer.AddWitnessTemplates(tx); err != nil {
+		return fmt.Errorf("error adding witness templates: %w", err)
+	}
+	packet, err := h.signer.Packet()
+	if err != nil {
+		return err
+	}
+
+	// There is no standard PSBT field for key tweaks, so hardware wallets
+	// can't sign inputs with tweaked keys.
+	for idx, pIn := range packet.Inputs {
+		for _, unknown := range pIn.Unknowns {
+			isTweak := bytes.Equal(
+				unknown.Key,
+				lnd.PsbtKeyTypeInputSignatureTweakSingle,
+			) || bytes.Equal(
+				unknown.Key,
+				lnd.PsbtKeyTypeInputSignatureTweakDouble,
+			)
+			if isTweak && !h.offline {
+				log.Warnf("Input %d is locked to a tweaked "+
+					"key, hardware wallets can't sign it. "+
+					"Sign the PSBT with lnd (SignPsbt) or "+
+					"'chantools offlinesweep sign' "+
+					"instead.", idx)
+			}
+		}
+	}
+
+	base64, err := packet.B64Encode()
+	if err != nil {
+		return fmt.Errorf("error encoding PSBT: %w", err)
+	}
+
+	if JSONOutput {
+		return printJSON(&psbtResult{Psbt: base64})
+	}
+
+	if h.offline {
+		fmt.Fprintf(textOut(), "Unsigned PSBT created. Sign it on the "+
+			"offline machine with\n'chantools offlinesweep sign', "+
+			"then combine and publish it with\n'chantools "+
+			"offlinesweep combine':\n\n%s\n\n", base64)
+
+		return nil
+	}
+
+	fmt.Fprintf(textOut(), "PSBT for hardware wallet created. Sign it "+
+		"with your hardware wallet,\nthen run this command again with "+
+		"the same flags and --hwsignedpsbt\ninstead of --hwexport or "+
+		"combine it with 'chantools offlinesweep combine':\n\n%s\n\n",
+		base64)
+
+	return nil
+}
+
 func (r *rootKey) read() (*hdkeychain.ExtendedKey, error) {
 	extendedKey, _, err := r.readWithBirthday()
 	return extendedKey, err
@@ -146,19 +698,171 @@ func (r *rootKey) read() (*hdkeychain.ExtendedKey, error) {
 func (r *rootKey) readWithBirthday() (*hdkeychain.ExtendedKey, time.Time,
 	error) {
 
+	if r.AccountXPrv != "" {
+		if r.RootKey != "" || r.BIP39 || r.SeedFile != "" || r.Stdin {
+			return nil, time.Unix(0, 0), fmt.Errorf("cannot use " +
+				"--accountxprv together with another root " +
+				"key source")
+		}
+
+		accountKey, err := lnd.ParseAccountKey(r.AccountXPrv)
+		if err != nil {
+			return nil, time.Unix(0, 0), err
+		}
+
+		return accountKey.ExtendedKey, time.Unix(0, 0), nil
+	}
+
+	if err := r.checkSources(); err != nil {
+		return nil, time.Unix(0, 0), err
+	}
+
+	// A seed file or stdin can either contain the root key or the seed
+	// that is then passed on to the seed readers.
+	if r.SeedFile != "" || r.Stdin {
+		if err := r.readSeedInput(); err != nil {
+			return nil, time.Unix(0, 0), err
+		}
+	}
+
 	// Check that root key is valid or fall back to console input.
 	switch {
+	case presetRootKey != nil:
+		return presetRootKey, time.Unix(0, 0), nil
+
 	case r.RootKey != "":
 		extendedKey, err := hdkeychain.NewKeyFromString(r.RootKey)
 		return extendedKey, time.Unix(0, 0), err
 
+	case os.Getenv(rootKeyEnvName) != "":
+		extendedKey, err := hdkeychain.NewKeyFromString(
+			strings.TrimSpace(os.Getenv(rootKeyEnvName)),
+		)
+		return extendedKey, time.Unix(0, 0), err
+
 	case r.BIP39:
-		extendedKey, err := btc.ReadMnemonicFromTerminal(chainParams)
+		extendedKey, err := btc.ReadMnemonic(
+			r.mnemonic, r.passphrase, chainParams,
+		)
 		return extendedKey, time.Unix(0, 0), err
 
 	default:
-		return lnd.ReadAezeed(chainParams)
+		return lnd.ReadAezeedWithSeed(
+			r.mnemonic, r.passphrase, chainParams,
+		)
+	}
+}
+
+// checkSources makes sure at most one source of the root key or seed is set,
+// so we never silently pick a different key than the user meant to use.
+func (r *rootKey) checkSources() error {
+	mnemonicEnv := lnd.MnemonicEnvName
+	if r.BIP39 {
+		mnemonicEnv = btc.BIP39MnemonicEnvName
+	}
+
+	var sources []string
+	if presetRootKey != nil {
+		sources = append(sources, "the root key of the wizard")
+	}
+	if r.RootKey != "" {
+		sources = append(sources, "--rootkey")
+	}
+	if os.Getenv(rootKeyEnvName) != "" {
+		sources = append(sources, rootKeyEnvName)
+	}
+	if r.SeedFile != "" {
+		sources = append(sources, "--seedfile")
+	}
+	if r.Stdin {
+		sources = append(sources, "--stdin")
+	}
+	if os.Getenv(mnemonicEnv) != "" {
+		sources = append(sources, mnemonicEnv)
+	}
+	if len(sources) > 1 {
+		return fmt.Errorf("only one root key or seed source can be "+
+			"used, got %s", strings.Join(sources, ", "))
 	}
+
+	// A root key doesn't need a seed format, so the --bip39 flag would be
+	// ignored.
+	if r.BIP39 && (r.RootKey != "" || os.Getenv(rootKeyEnvName) != "") {
+		return fmt.Errorf("cannot use --bip39 together with a root " +
+			"key")
+	}
+
+	return nil
+}
+
+// readSeedInput reads the root key or the seed and its passphrase from the seed
+// file or stdin. A root key is used as if it was given with the --rootkey flag,
+// a seed is handed to the seed readers as values.
+func (r *rootKey) readSeedInput() error {
+	var (
+		content []byte
+		err     error
+	)
+	if r.SeedFile != "" {
+		content, err = ioutil.ReadFile(lncfg.CleanAndExpandPath(
+			r.SeedFile,
+		))
+	} else {
+		content, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading seed input: %w", err)
+	}
+
+	lines := strings.Split(strings.ReplaceAll(
+		string(content), "\r\n", "\n",
+	), "\n")
+	first := strings.TrimSpace(lines[0])
+	if first == "" {
+		return fmt.Errorf("seed input is empty")
+	}
+
+	if _, err := hdkeychain.NewKeyFromString(first); err == nil {
+		r.RootKey = first
+		return nil
+	}
+
+	passphraseEnv := lnd.PassphraseEnvName
+	if r.BIP39 {
+		passphraseEnv = btc.BIP39PassphraseEnvName
+	}
+
+	// We can't ask for the passphrase if the seed came from stdin, so a
+	// missing second line means there is no passphrase. The same is true
+	// for a seed file, unless the passphrase is set in the environment.
+	r.mnemonic = first
+	r.passphrase = "-"
+	if len(lines) > 1 && lines[1] != "" {
+		r.passphrase = lines[1]
+	} else if os.Getenv(passphraseEnv) != "" && !r.Stdin {
+		r.passphrase = os.Getenv(passphraseEnv)
+	}
+
+	return nil
+}
+
+// channelDBGiven returns true if the user specified a channel DB, either with
+// the given flag value or by selecting the Postgres backend which doesn't need
+// a path.
+func channelDBGiven(path string) bool {
+	return path != "" || dbConfig.Backend == lnd.DBBackendPostgres
+}
+
+// openChannelDB opens lnd's channel DB at the given path using the database
+// backend selected by the global flags.
+func openChannelDB(path string, readonly bool) (*channeldb.DB, error) {
+	cfg := *dbConfig
+	cfg.Path = path
+	if cfg.Backend == lnd.DBBackendPostgres && cfg.PostgresDSN == "" {
+		return nil, fmt.Errorf("postgres DSN is required")
+	}
+
+	return lnd.OpenChannelDB(&cfg, readonly)
 }
 
 type inputFlags struct {
@@ -189,6 +893,12 @@ func newInputFlags(cmd *cobra.Command) *inputFlags {
 	return f
 }
 
+// empty returns true if none of the channel input flags is set.
+func (f *inputFlags) empty() bool {
+	return f.ListChannels == "" && f.PendingChannels == "" &&
+		f.FromSummary == "" && f.FromChannelDB == ""
+}
+
 func (f *inputFlags) parseInputType() ([]*dataformat.SummaryEntry, error) {
 	var (
 		content []byte
@@ -210,7 +920,7 @@ func (f *inputFlags) parseInputType() ([]*dataformat.SummaryEntry, error) {
 		target = &dataformat.SummaryEntryFile{}
 
 	case f.FromChannelDB != "":
-		db, err := lnd.OpenDB(f.FromChannelDB, true)
+		db, err := openChannelDB(f.FromChannelDB, true)
 		if err != nil {
 			return nil, fmt.Errorf("error opening channel DB: %w",
 				err)
@@ -233,6 +943,286 @@ func (f *inputFlags) parseInputType() ([]*dataformat.SummaryEntry, error) {
 	return target.AsSummaryEntries()
 }
 
+// explorerAPIFlags are the flags that configure the esplora compatible block
+// explorer API.
+type explorerAPIFlags struct {
+	APIURL     []string
+	RateLimit  float64
+	APIRetries int
+	Proxy      string
+	APIHeader  []string
+	APIUser    string
+	APIPass    string
+
+	APICacheDir string
+	APICacheTTL time.Duration
+
+	MempoolSpace bool
+}
+
+func newExplorerAPIFlags(cmd *cobra.Command) *explorerAPIFlags {
+	f := &explorerAPIFlags{}
+	cmd.Flags().StringArrayVar(
+		&f.APIURL, "apiurl", []string{defaultAPIURL}, "API URL to "+
+			"use (must be esplora compatible); can be specified "+
+			"multiple times to fail over to the next API if a "+
+			"request fails",
+	)
+	cmd.Flags().Float64Var(
+		&f.RateLimit, "ratelimit", defaultAPIRateLimit, "maximum "+
+			"number of requests per second to send to the API; "+
+			"set to 0 to disable the limit",
+	)
+	cmd.Flags().IntVar(
+		&f.APIRetries, "apiretries", defaultAPIRetries, "number of "+
+			"times a failed request is retried with an "+
+			"exponential backoff after all API URLs failed",
+	)
+	cmd.Flags().StringVar(
+		&f.Proxy, "proxy", "", "SOCKS5 proxy to send the block "+
+			"explorer API requests through, e.g. "+
+			"socks5://127.0.0.1:9050 for Tor",
+	)
+	cmd.Flags().StringArrayVar(
+		&f.APIHeader, "apiheader", nil, "additional HTTP header in "+
+			"the format 'Name: value' to send with every request "+
+			"to the first --apiurl, for example an API key; can "+
+			"be specified multiple times",
+	)
+	cmd.Flags().StringVar(
+		&f.APIUser, "apiuser", "", "user name for the basic "+
+			"authentication of a private API instance; only sent "+
+			"to the first --apiurl",
+	)
+	cmd.Flags().StringVar(
+		&f.APIPass, "apipass", "", "password for the basic "+
+			"authentication of a private API instance; only sent "+
+			"to the first --apiurl",
+	)
+	cmd.Flags().StringVar(
+		&f.APICacheDir, "apicachedir", defaultAPICacheDir, "the "+
+			"directory to cache the transactions fetched from the "+
+			"API in, shared by all commands",
+	)
+	cmd.Flags().DurationVar(
+		&f.APICacheTTL, "apicachettl", 0, "time after which a cached "+
+			"transaction is fetched again, e.g. 1h; transactions "+
+			"with all outputs spent never expire; set to 0 to "+
+			"disable the cache",
+	)
+	cmd.Flags().BoolVar(
+		&f.MempoolSpace, "mempoolspace", false, "use the mempool.space "+
+			"API (or the self hosted instance given by --apiurl), "+
+			"which also recommends the fee rate to use if "+
+			"--feerate isn't set",
+	)
+
+	return f
+}
+
+// api returns the block explorer API configured by the flags.
+func (f *explorerAPIFlags) api() *btc.ExplorerAPI {
+	api := &btc.ExplorerAPI{
+		BaseURL:           defaultAPIURL,
+		RequestsPerSecond: f.RateLimit,
+		MaxRetries:        f.APIRetries,
+		Proxy:             f.Proxy,
+		User:              f.APIUser,
+		Password:          f.APIPass,
+		Headers:           f.APIHeader,
+	}
+	if f.APICacheTTL > 0 {
+		api.Cache = &btc.TxCache{
+			Dir: lncfg.CleanAndExpandPath(f.APICacheDir),
+			TTL: f.APICacheTTL,
+		}
+	}
+	switch {
+	case f.MempoolSpace && isDefaultAPIURL(f.APIURL):
+		api.BaseURL = defaultMempoolURL
+
+	case len(f.APIURL) > 0:
+		api.BaseURL = f.APIURL[0]
+		api.FailoverURLs = f.APIURL[1:]
+	}
+
+	return api
+}
+
+// sweepAPI returns the block explorer API configured by the flags as a chain
+// backend for the sweep commands.
+func (f *explorerAPIFlags) sweepAPI() btc.SweepAPI {
+	if f.MempoolSpace {
+		return &btc.MempoolSpaceAPI{ExplorerAPI: f.api()}
+	}
+
+	return f.api()
+}
+
+// isDefaultAPIURL returns true if the --apiurl flag was left at its default.
+func isDefaultAPIURL(apiURLs []string) bool {
+	return len(apiURLs) == 1 && apiURLs[0] == defaultAPIURL
+}
+
+// sweepFeeRate returns the fee rate to use for a sweep transaction. If the
+// --feerate flag wasn't set explicitly and the chain backend can estimate fees,
+// the fee rate it recommends is used. Otherwise a fee rate of zero is replaced
+// by the default.
+func sweepFeeRate(cmd *cobra.Command, api btc.SweepAPI,
+	feeRate uint16) (uint16, error) {
+
+	estimator, ok := api.(btc.FeeEstimator)
+	if ok && !cmd.Flags().Changed("feerate") {
+		estimate, err := estimator.EstimateFeeRate()
+		if err != nil {
+			return 0, fmt.Errorf("error estimating fee rate: %w",
+				err)
+		}
+		if estimate > math.MaxUint16 {
+			estimate = math.MaxUint16
+		}
+
+		log.Infof("Using fee rate of %d sat/vByte recommended by the "+
+			"chain backend", estimate)
+
+		return uint16(estimate), nil
+	}
+
+	if feeRate == 0 {
+		return defaultFeeSatPerVByte, nil
+	}
+
+	return feeRate, nil
+}
+
+type chainAPIFlags struct {
+	explorerAPI *explorerAPIFlags
+
+	BitcoindRPC    string
+	BitcoindUser   string
+	BitcoindPass   string
+	BitcoindCookie string
+
+	ElectrumServer        string
+	ElectrumTLS           bool
+	ElectrumTLSSkipVerify bool
+
+	Neutrino     bool
+	NeutrinoDir  string
+	NeutrinoPeer []string
+}
+
+func newChainAPIFlags(cmd *cobra.Command) *chainAPIFlags {
+	f := &chainAPIFlags{
+		explorerAPI: newExplorerAPIFlags(cmd),
+	}
+	cmd.Flags().StringVar(
+		&f.BitcoindRPC, "bitcoindrpc", "", "host:port of a bitcoind "+
+			"JSON-RPC interface to use instead of the block "+
+			"explorer API; bitcoind needs txindex=1",
+	)
+	cmd.Flags().StringVar(
+		&f.BitcoindUser, "bitcoinduser", "", "user name for the "+
+			"bitcoind JSON-RPC interface",
+	)
+	cmd.Flags().StringVar(
+		&f.BitcoindPass, "bitcoindpass", "", "password for the "+
+			"bitcoind JSON-RPC interface",
+	)
+	cmd.Flags().StringVar(
+		&f.BitcoindCookie, "bitcoindcookie", "", "cookie file to "+
+			"read the bitcoind JSON-RPC credentials from instead "+
+			"of using --bitcoinduser and --bitcoindpass",
+	)
+	cmd.Flags().StringVar(
+		&f.ElectrumServer, "electrumserver", "", "host:port of an "+
+			"Electrum server (ElectrumX, Electrs or Fulcrum) to "+
+			"use instead of the block explorer API",
+	)
+	cmd.Flags().BoolVar(
+		&f.ElectrumTLS, "electrumtls", false, "use an SSL/TLS "+
+			"connection to the Electrum server",
+	)
+	cmd.Flags().BoolVar(
+		&f.ElectrumTLSSkipVerify, "electrumtlsskipverify", false,
+		"don't verify the TLS certificate of the Electrum server, "+
+			"for example if it is self-signed",
+	)
+
+	return f
+}
+
+// registerNeutrinoFlags adds the flags of the embedded neutrino light client.
+// It can only look up transactions of which the block height is known, so it
+// is only offered by the commands that provide height hints.
+func (f *chainAPIFlags) registerNeutrinoFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(
+		&f.Neutrino, "neutrino", false, "use an embedded neutrino "+
+			"light client that syncs the compact block filters "+
+			"from the P2P network instead of the block explorer "+
+			"API; can only look up channels with a known short "+
+			"channel ID",
+	)
+	cmd.Flags().StringVar(
+		&f.NeutrinoDir, "neutrinodir", defaultNeutrinoDir, "the "+
+			"directory the neutrino light client stores the "+
+			"block headers and filters in",
+	)
+	cmd.Flags().StringArrayVar(
+		&f.NeutrinoPeer, "neutrinopeer", nil, "host:port of a peer "+
+			"the neutrino light client should connect to instead "+
+			"of using the DNS seeds; can be specified multiple "+
+			"times",
+	)
+}
+
+// stopChainAPI stops the background service of the given chain backend, if it
+// has one.
+func stopChainAPI(api btc.ChainAPI) {
+	stopper, ok := api.(btc.Stopper)
+	if !ok {
+		return
+	}
+	if err := stopper.Stop(); err != nil {
+		log.Errorf("Error stopping chain backend: %v", err)
+	}
+}
+
+// api returns the chain backend selected by the flags.
+func (f *chainAPIFlags) api() btc.SweepAPI {
+	if f.Neutrino {
+		return &btc.NeutrinoAPI{
+			DataDir: filepath.Join(
+				lncfg.CleanAndExpandPath(f.NeutrinoDir),
+				chainParams.Name,
+			),
+			Peers:       f.NeutrinoPeer,
+			ChainParams: chainParams,
+			Log:         log,
+		}
+	}
+
+	if f.ElectrumServer != "" {
+		return &btc.ElectrumAPI{
+			Host:          f.ElectrumServer,
+			TLS:           f.ElectrumTLS || f.ElectrumTLSSkipVerify,
+			TLSSkipVerify: f.ElectrumTLSSkipVerify,
+			ChainParams:   chainParams,
+		}
+	}
+
+	if f.BitcoindRPC != "" {
+		return &btc.BitcoindAPI{
+			Host:       f.BitcoindRPC,
+			User:       f.BitcoindUser,
+			Password:   f.BitcoindPass,
+			CookieFile: lncfg.CleanAndExpandPath(f.BitcoindCookie),
+		}
+	}
+
+	return f.explorerAPI.sweepAPI()
+}
+
 func readInput(input string) ([]byte, error) {
 	if strings.TrimSpace(input) == "-" {
 		return ioutil.ReadAll(os.Stdin)
@@ -243,12 +1233,12 @@ func readInput(input string) ([]byte, error) {
 func passwordFromConsole(userQuery string) ([]byte, error) {
 	// Read from terminal (if there is one).
 	if terminal.IsTerminal(int(syscall.Stdin)) { //nolint
-		fmt.Print(userQuery)
+		fmt.Fprint(textOut(), userQuery)
 		pw, err := terminal.ReadPassword(int(syscall.Stdin)) //nolint
 		if err != nil {
 			return nil, err
 		}
-		fmt.Println()
+		fmt.Fprintln(textOut())
 		return pw, nil
 	}
 
@@ -266,7 +1256,9 @@ func setupLogging() {
 	addSubLogger("CHDB", channeldb.UseLogger)
 	addSubLogger("BCKP", chanbackup.UseLogger)
 	addSubLogger("PEER", peer.UseLogger)
-	err := logWriter.InitLogRotator("./results/chantools.log", 10, 3)
+	err := logOut.initLogRotator(
+		filepath.Join(WorkDir, "chantools.log"), 10, 3,
+	)
 	if err != nil {
 		panic(err)
 	}
@@ -276,11 +1268,60 @@ func setupLogging() {
 	}
 }
 
-// genSubLogger creates a sub logger with an empty shutdown function.
-func genSubLogger(logWriter *build.RotatingLogWriter) func(string) btclog.Logger {
-	return func(s string) btclog.Logger {
-		return logWriter.GenSubLogger(s, func() {})
+// logOutput writes the log to the console and, once the log rotator is
+// initialized, to the log file. Unlike lnd's log writer it doesn't always
+// write to stdout, so the console can be switched to stderr if stdout is
+// reserved for the result of a command.
+type logOutput struct {
+	console     io.Writer
+	rotatorPipe *io.PipeWriter
+}
+
+// Write writes the log line to the console and the log file.
+func (o *logOutput) Write(b []byte) (int, error) {
+	_, _ = o.console.Write(b)
+	if o.rotatorPipe != nil {
+		_, _ = o.rotatorPipe.Write(b)
+	}
+
+	return len(b), nil
+}
+
+// initLogRotator starts writing the log to the given log file and rolls it
+// over once it reaches the given size in KB.
+func (o *logOutput) initLogRotator(logFile string, maxLogFileSize,
+	maxLogFiles int) error {
+
+	if o.rotatorPipe != nil {
+		return nil
 	}
+
+	logDir, _ := filepath.Split(logFile)
+	if err := os.MkdirAll(logDir, 0700); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	logRotator, err := rotator.New(
+		logFile, int64(maxLogFileSize*1024), false, maxLogFiles,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create file rotator: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		if err := logRotator.Run(pr); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to run file "+
+				"rotator: %v\n", err)
+		}
+	}()
+	o.rotatorPipe = pw
+
+	return nil
+}
+
+// genSubLogger creates a sub logger that writes to the log output.
+func genSubLogger(subsystem string) btclog.Logger {
+	return logBackend.Logger(subsystem)
 }
 
 // addSubLogger is a helper method to conveniently create and register the
@@ -288,7 +1329,7 @@ func genSubLogger(logWriter *build.RotatingLogWriter) func(string) btclog.Logger
 func addSubLogger(subsystem string, useLoggers ...func(btclog.Logger)) {
 	// Create and register just a single logger to prevent them from
 	// overwriting each other internally.
-	logger := build.NewSubLogger(subsystem, genSubLogger(logWriter))
+	logger := build.NewSubLogger(subsystem, genSubLogger)
 	setSubLogger(subsystem, logger, useLoggers...)
 }
 
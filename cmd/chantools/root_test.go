@@ -2,16 +2,28 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"regexp"
 	"testing"
 
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/btcutil/psbt"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btclog"
+	"github.com/guggero/chantools/lnd"
 	"github.com/lightningnetwork/lnd/chanbackup"
 	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/require"
 )
 
@@ -37,7 +49,7 @@ var (
 	datePattern = regexp.MustCompile(
 		`\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3} `,
 	)
-	addressPattern = regexp.MustCompile(`\(0x[0-9a-f]{10}\)`)
+	addressPattern = regexp.MustCompile(`\(0x[0-9a-f]+\)`)
 )
 
 type harness struct {
@@ -45,6 +57,7 @@ type harness struct {
 	logBuffer *bytes.Buffer
 	logger    btclog.Logger
 	tempDir   string
+	jsonFile  string
 }
 
 func newHarness(t *testing.T) *harness {
@@ -69,6 +82,7 @@ func newHarness(t *testing.T) *harness {
 
 	os.Clearenv()
 	chainParams = &chaincfg.RegressionNetParams
+	WorkDir = tempDir
 
 	return h
 }
@@ -87,6 +101,60 @@ func (h *harness) assertLogContains(format string) {
 	require.Contains(h.t, h.logBuffer.String(), format)
 }
 
+// captureJSON turns on the global --json flag and redirects stdout and the
+// result output into a file until the test ends.
+func (h *harness) captureJSON() {
+	h.t.Helper()
+
+	out, err := os.CreateTemp(h.tempDir, "stdout-*.json")
+	require.NoError(h.t, err)
+	h.jsonFile = out.Name()
+
+	oldStdout := os.Stdout
+	os.Stdout = out
+	resultOut = out
+	JSONOutput = true
+	h.t.Cleanup(func() {
+		os.Stdout = oldStdout
+		resultOut = oldStdout
+		JSONOutput = false
+		_ = out.Close()
+	})
+}
+
+// assertJSONOutput asserts that everything written to stdout since the last
+// call to captureJSON is a single JSON value and decodes it into v.
+func (h *harness) assertJSONOutput(v interface{}) {
+	h.t.Helper()
+
+	content, err := os.ReadFile(h.jsonFile)
+	require.NoError(h.t, err)
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	require.NoError(h.t, decoder.Decode(v), string(content))
+	require.False(h.t, decoder.More(), string(content))
+}
+
+// setStdin replaces stdin with a file that contains the given input for
+// commands that ask the user interactively.
+func (h *harness) setStdin(input string) {
+	h.t.Helper()
+
+	in, err := os.CreateTemp(h.tempDir, "stdin-*")
+	require.NoError(h.t, err)
+	_, err = in.WriteString(input)
+	require.NoError(h.t, err)
+	_, err = in.Seek(0, io.SeekStart)
+	require.NoError(h.t, err)
+
+	oldStdin := os.Stdin
+	os.Stdin = in
+	h.t.Cleanup(func() {
+		os.Stdin = oldStdin
+		_ = in.Close()
+	})
+}
+
 func (h *harness) assertLogEqual(a, b string) {
 	// Remove all timestamps and all memory addresses from dumps as those
 	// are always different.
@@ -116,3 +184,252 @@ func (h *harness) fileSize(name string) int64 {
 
 	return stat.Size()
 }
+
+func TestHWSigner(t *testing.T) {
+	h := newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	localSigner := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	// We sweep a time locked to_local output with a tweaked delay key.
+	delayDesc, err := localSigner.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyDelayBase,
+		Index:  1,
+	})
+	require.NoError(t, err)
+	commitPoint, err := localSigner.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyRevocationRoot,
+		Index:  1,
+	})
+	require.NoError(t, err)
+	script, err := input.CommitScriptToSelf(
+		144, input.TweakPubKey(delayDesc.PubKey, commitPoint.PubKey),
+		commitPoint.PubKey,
+	)
+	require.NoError(t, err)
+	pkScript, err := input.WitnessScriptHash(script)
+	require.NoError(t, err)
+	prevOut := &wire.TxOut{Value: 100_000, PkScript: pkScript}
+	singleTweak := input.SingleTweakBytes(
+		commitPoint.PubKey, delayDesc.PubKey,
+	)
+
+	sweepTx := func(value int64) (*wire.MsgTx, *input.SignDescriptor) {
+		tx := wire.NewMsgTx(2)
+		tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}},
+			Sequence:         input.LockTimeToSequence(false, 144),
+		})
+		tx.AddTxOut(&wire.TxOut{Value: value, PkScript: pkScript})
+
+		return tx, &input.SignDescriptor{
+			KeyDesc:       *delayDesc,
+			SingleTweak:   singleTweak,
+			WitnessScript: script,
+			Output:        prevOut,
+			HashType:      txscript.SigHashAll,
+			SigHashes:     input.NewTxSigHashesV0Only(tx),
+		}
+	}
+
+	// The hardware wallet only hands out the extended public key of the
+	// key family account, the PSBT is created without the seed.
+	accountKey, err := lnd.DeriveChildren(extendedKey, []uint32{
+		lnd.HardenedKey(uint32(keychain.BIP0043Purpose)),
+		lnd.HardenedKey(chainParams.HDCoinType),
+		lnd.HardenedKey(uint32(keychain.KeyFamilyDelayBase)),
+	})
+	require.NoError(t, err)
+	accountXPub, err := accountKey.Neuter()
+	require.NoError(t, err)
+	xpubs := []string{accountXPub.String()}
+
+	_, err = (&hwSigner{XPubs: xpubs}).readRootKey(&rootKey{})
+	require.ErrorContains(t, err, "--hwxpub can only be used")
+
+	// The first pass exports the PSBT.
+	hw := &hwSigner{
+		ExportPsbt:  true,
+		Fingerprint: "aabbccdd",
+		XPubs:       xpubs,
+	}
+	rootKey, err := hw.readRootKey(&rootKey{})
+	require.NoError(t, err)
+	require.Nil(t, rootKey)
+	signer, err := hw.wrap(&lnd.Signer{ChainParams: chainParams})
+	require.NoError(t, err)
+	tx, signDesc := sweepTx(99_000)
+	_, err = input.CommitSpendTimeout(signer, signDesc, tx)
+	require.NoError(t, err)
+	require.NoError(t, hw.export(tx))
+	h.assertLogContains("Input 0 is locked to a tweaked key")
+
+	packet, err := hw.signer.Packet()
+	require.NoError(t, err)
+	pIn := packet.Inputs[0]
+	require.Equal(t, script, pIn.WitnessScript)
+	require.Equal(t, prevOut, pIn.WitnessUtxo)
+	require.Len(t, pIn.Bip32Derivation, 1)
+	require.Equal(t, []uint32{
+		lnd.HardenedKeyStart + uint32(keychain.BIP0043Purpose),
+		lnd.HardenedKeyStart + chainParams.HDCoinType,
+		lnd.HardenedKeyStart + uint32(keychain.KeyFamilyDelayBase),
+		0, 1,
+	}, pIn.Bip32Derivation[0].Bip32Path)
+	require.Equal(
+		t, binary.LittleEndian.Uint32([]byte{0xaa, 0xbb, 0xcc, 0xdd}),
+		pIn.Bip32Derivation[0].MasterKeyFingerprint,
+	)
+	require.Len(t, pIn.Unknowns, 2)
+	require.Equal(
+		t, lnd.PsbtKeyTypeInputSignatureTweakSingle,
+		pIn.Unknowns[0].Key,
+	)
+	require.Equal(t, singleTweak, pIn.Unknowns[0].Value)
+
+	// The external signer only uses the standard fields and the tweak to
+	// sign the PSBT.
+	signingKey, err := lnd.DeriveChildren(
+		extendedKey, pIn.Bip32Derivation[0].Bip32Path,
+	)
+	require.NoError(t, err)
+	privKey, err := signingKey.ECPrivKey()
+	require.NoError(t, err)
+	tweakedPrivKey := input.TweakPrivKey(privKey, pIn.Unknowns[0].Value)
+	sig, err := txscript.RawTxInWitnessSignature(
+		packet.UnsignedTx,
+		input.NewTxSigHashesV0Only(packet.UnsignedTx), 0,
+		pIn.WitnessUtxo.Value, pIn.WitnessScript, pIn.SighashType,
+		tweakedPrivKey,
+	)
+	require.NoError(t, err)
+	updater, err := psbt.NewUpdater(packet)
+	require.NoError(t, err)
+	_, err = updater.Sign(
+		0, sig, tweakedPrivKey.PubKey().SerializeCompressed(), nil,
+		pIn.WitnessScript,
+	)
+	require.NoError(t, err)
+	signedPsbt, err := packet.B64Encode()
+	require.NoError(t, err)
+
+	// The second pass creates the final transaction with the signature
+	// of the hardware wallet.
+	hw = &hwSigner{
+		SignedPsbt:  signedPsbt,
+		Fingerprint: "aabbccdd",
+		XPubs:       xpubs,
+	}
+	signer, err = hw.wrap(&lnd.Signer{ChainParams: chainParams})
+	require.NoError(t, err)
+	tx, signDesc = sweepTx(99_000)
+	witness, err := input.CommitSpendTimeout(signer, signDesc, tx)
+	require.NoError(t, err)
+	tx.TxIn[0].Witness = witness
+
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(
+		prevOut.PkScript, prevOut.Value,
+	)
+	engine, err := txscript.NewEngine(
+		pkScript, tx, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(tx, prevOutFetcher), prevOut.Value,
+		prevOutFetcher,
+	)
+	require.NoError(t, err)
+	require.NoError(t, engine.Execute())
+
+	// A transaction that differs from the exported one can't be signed.
+	tx, signDesc = sweepTx(98_000)
+	_, err = input.CommitSpendTimeout(signer, signDesc, tx)
+	require.ErrorContains(t, err, "differs from the one in the signed")
+}
+
+func TestRootKeyAccountXPrv(t *testing.T) {
+	_ = newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	accountKey, err := lnd.DeriveChildren(extendedKey, []uint32{
+		lnd.HardenedKey(uint32(keychain.BIP0043Purpose)),
+		lnd.HardenedKey(chainParams.HDCoinType),
+		lnd.HardenedKey(uint32(keychain.KeyFamilyNodeKey)),
+	})
+	require.NoError(t, err)
+
+	r := &rootKey{AccountXPrv: accountKey.String()}
+	readKey, err := r.read()
+	require.NoError(t, err)
+
+	// The account key derives the same keys of its key family as the
+	// root key.
+	rootSigner := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	accountSigner := &lnd.Signer{
+		ExtendedKey: readKey,
+		ChainParams: chainParams,
+	}
+	keyLoc := keychain.KeyLocator{
+		Family: keychain.KeyFamilyNodeKey,
+		Index:  3,
+	}
+	expected, err := rootSigner.DeriveKey(keyLoc)
+	require.NoError(t, err)
+	derived, err := accountSigner.DeriveKey(keyLoc)
+	require.NoError(t, err)
+	require.Equal(t, expected.PubKey, derived.PubKey)
+
+	// Keys of other key families or outside of lnd's key tree can't be
+	// derived.
+	_, err = accountSigner.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyMultiSig,
+	})
+	require.ErrorContains(t, err, "account key is of key family 6")
+	_, _, _, err = lnd.DeriveKey(readKey, "m/84'/1'/0'/0/0", chainParams)
+	require.ErrorContains(t, err, "cannot be derived from an account")
+	_, err = lnd.DeriveFromRoot(readKey, []uint32{
+		lnd.HardenedKey(uint32(keychain.BIP0043Purpose)),
+		lnd.HardenedKey(0),
+		lnd.HardenedKey(uint32(keychain.KeyFamilyNodeKey)), 0, 3,
+	})
+	require.ErrorContains(t, err, "account key is of coin type 1")
+
+	// Only DeriveFromRoot treats the key as an account key, DeriveChildren
+	// always derives below the given key.
+	expectedChild, err := accountKey.DeriveNonStandard(
+		lnd.HardenedKey(uint32(keychain.BIP0043Purpose)),
+	)
+	require.NoError(t, err)
+	child, err := lnd.DeriveChildren(readKey, []uint32{
+		lnd.HardenedKey(uint32(keychain.BIP0043Purpose)),
+	})
+	require.NoError(t, err)
+	require.Equal(t, expectedChild.String(), child.String())
+
+	// Only account keys are accepted.
+	r = &rootKey{AccountXPrv: rootKeyAezeed}
+	_, err = r.read()
+	require.ErrorContains(t, err, "depth of an lnd account")
+
+	r = &rootKey{AccountXPrv: accountKey.String(), RootKey: rootKeyAezeed}
+	_, err = r.read()
+	require.ErrorContains(t, err, "cannot use --accountxprv")
+}
+
+func TestNeutrinoFlags(t *testing.T) {
+	// The neutrino backend can only look up transactions with a height
+	// hint, so only the summary offers it.
+	require.NotNil(t, newSummaryCommand().Flags().Lookup("neutrino"))
+	for _, cmd := range []*cobra.Command{
+		newSweepTimeLockCommand(), newSweepRemoteClosedCommand(),
+		newSweepHTLCsCommand(), newSummaryCalendarCommand(),
+	} {
+		require.Nil(t, cmd.Flags().Lookup("neutrino"), cmd.Name())
+		require.Nil(t, cmd.Flags().Lookup("neutrinodir"), cmd.Name())
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+
+	"github.com/coreos/bbolt"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/lncfg"
+	"github.com/spf13/cobra"
+)
+
+type salvageDBCommand struct {
+	SourceDB string
+	DestDB   string
+	Dump     bool
+
+	cmd *cobra.Command
+}
+
+func newSalvageDBCommand() *cobra.Command {
+	cc := &salvageDBCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "salvagedb",
+		Short: "Extract all readable data from a corrupted bbolt " +
+			"database",
+		Long: `This command opens a corrupted database (for example a
+channel.db that every other command fails to open because of a corrupted page)
+in read-only mode and extracts everything that can still be read into a new,
+clean database and/or a JSON dump.
+
+Every bucket is read from the start until a corrupted page is hit and then from
+the end backward until the same page is hit again, so only the keys stored on
+the corrupted pages themselves are lost. All unrecoverable key ranges and
+buckets are listed in the result. If the new database is a channel DB that can
+be opened, the number of salvaged open and closed channels is reported too and
+the database can be used with all other commands, for example dumpchannels.
+
+The JSON dump (--dump) contains all salvaged buckets with their hex encoded keys
+and values and is written to salvage-dump-<timestamp>.json in the working
+directory. It is kept in memory completely, so it should only be used for
+smaller databases.`,
+		Example: `chantools salvagedb \
+	--sourcedb ~/.lnd/data/graph/mainnet/channel.db \
+	--destdb ./results/salvaged.db`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.SourceDB, "sourcedb", "", "corrupted bbolt database file "+
+			"to salvage",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.DestDB, "destdb", "", "new bbolt database file to write "+
+			"the salvaged data to",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Dump, "dump", false, "write all salvaged data to a JSON "+
+			"file in the working directory",
+	)
+
+	return cc.cmd
+}
+
+func (c *salvageDBCommand) Execute(_ *cobra.Command, _ []string) error {
+	if c.SourceDB == "" {
+		return fmt.Errorf("source DB is required")
+	}
+	if c.DestDB == "" && !c.Dump {
+		return fmt.Errorf("either destination DB or --dump is required")
+	}
+	if dbConfig.Backend != "" && dbConfig.Backend != lnd.DBBackendBolt {
+		return fmt.Errorf("only bbolt databases can be salvaged")
+	}
+	c.SourceDB = lncfg.CleanAndExpandPath(c.SourceDB)
+
+	compact := &compactDBCommand{}
+	var (
+		src *bbolt.DB
+		err error
+	)
+	panicErr := salvageCall(func() {
+		src, err = compact.openDB(c.SourceDB, true)
+	})
+	if panicErr != nil {
+		err = panicErr
+	}
+	if err != nil {
+		return fmt.Errorf("error opening source DB, the meta pages "+
+			"are corrupted and nothing can be salvaged: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+
+	result := &salvageResult{
+		SourceDB: c.SourceDB,
+	}
+	var dst *bbolt.DB
+	if c.DestDB != "" {
+		result.DestDB = lncfg.CleanAndExpandPath(c.DestDB)
+		if _, err := os.Stat(result.DestDB); err == nil {
+			return fmt.Errorf("destination DB %s already exists",
+				result.DestDB)
+		}
+
+		dst, err = compact.openDB(result.DestDB, false)
+		if err != nil {
+			return fmt.Errorf("error opening destination DB: %w",
+				err)
+		}
+		defer func() { _ = dst.Close() }()
+	}
+
+	var dump *salvageDumpBucket
+	if c.Dump {
+		dump = &salvageDumpBucket{}
+	}
+
+	if err := salvageDB(src, dst, dump, result); err != nil {
+		return err
+	}
+
+	log.Infof("Salvaged %d buckets and %d keys, %d parts are "+
+		"unrecoverable", result.Buckets, result.Keys,
+		len(result.Unrecoverable))
+	for _, unrecoverable := range result.Unrecoverable {
+		log.Warnf("Unrecoverable: %v", unrecoverable)
+	}
+
+	if dump != nil {
+		result.DumpFile = resultFileName("salvage-dump", "json")
+		dumpBytes, err := json.MarshalIndent(dump.Buckets, "", "  ")
+		if err != nil {
+			return err
+		}
+		log.Infof("Writing salvaged data to %s", result.DumpFile)
+		err = ioutil.WriteFile(result.DumpFile, dumpBytes, 0644)
+		if err != nil {
+			return fmt.Errorf("error writing dump: %w", err)
+		}
+	}
+
+	if dst != nil {
+		if err := dst.Close(); err != nil {
+			return fmt.Errorf("error closing destination DB: %w",
+				err)
+		}
+		countSalvagedChannels(result)
+	}
+
+	return printJSON(result)
+}
+
+// salvageResult is the result of the salvagedb command.
+type salvageResult struct {
+	SourceDB       string          `json:"source_db"`
+	DestDB         string          `json:"dest_db,omitempty"`
+	DumpFile       string          `json:"dump_file,omitempty"`
+	Buckets        uint64          `json:"buckets"`
+	Keys           uint64          `json:"keys"`
+	Unrecoverable  []*salvageError `json:"unrecoverable"`
+	OpenChannels   int             `json:"open_channels"`
+	ClosedChannels int             `json:"closed_channels"`
+	ChannelDBError string          `json:"channel_db_error,omitempty"`
+}
+
+// salvageError describes a part of a database that couldn't be read. If a
+// range of keys is lost, After and Before are the last readable keys around
+// the corrupted pages.
+type salvageError struct {
+	Path   string `json:"path"`
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+	Error  string `json:"error"`
+}
+
+// String returns a human readable description of the unrecoverable part.
+func (e *salvageError) String() string {
+	switch {
+	case e.After == "" && e.Before == "":
+		return fmt.Sprintf("all keys of %s: %s", e.Path, e.Error)
+
+	case e.Before == "":
+		return fmt.Sprintf("keys of %s after %s: %s", e.Path, e.After,
+			e.Error)
+
+	case e.After == "":
+		return fmt.Sprintf("keys of %s before %s: %s", e.Path,
+			e.Before, e.Error)
+
+	default:
+		return fmt.Sprintf("keys of %s between %s and %s: %s", e.Path,
+			e.After, e.Before, e.Error)
+	}
+}
+
+// salvageDumpBucket is a bucket of the JSON dump.
+type salvageDumpBucket struct {
+	Name     string               `json:"name"`
+	Sequence uint64               `json:"sequence,omitempty"`
+	Keys     map[string]string    `json:"keys,omitempty"`
+	Buckets  []*salvageDumpBucket `json:"buckets,omitempty"`
+}
+
+// salvageSink is where the salvaged buckets and keys are written to.
+type salvageSink interface {
+	put(k, v []byte) error
+	bucket(k []byte, seq uint64) (salvageSink, error)
+}
+
+// boltSalvageSink writes the salvaged data to a bucket of a new database.
+type boltSalvageSink struct {
+	b *bbolt.Bucket
+}
+
+func (s *boltSalvageSink) put(k, v []byte) error {
+	return s.b.Put(k, v)
+}
+
+func (s *boltSalvageSink) bucket(k []byte, seq uint64) (salvageSink, error) {
+	b, err := s.b.CreateBucket(k)
+	if err != nil {
+		return nil, err
+	}
+	b.FillPercent = 1.0
+
+	return &boltSalvageSink{b: b}, b.SetSequence(seq)
+}
+
+// dumpSalvageSink writes the salvaged data to a bucket of the JSON dump.
+type dumpSalvageSink struct {
+	b *salvageDumpBucket
+}
+
+func (s *dumpSalvageSink) put(k, v []byte) error {
+	if s.b.Keys == nil {
+		s.b.Keys = make(map[string]string)
+	}
+	s.b.Keys[hex.EncodeToString(k)] = hex.EncodeToString(v)
+
+	return nil
+}
+
+func (s *dumpSalvageSink) bucket(k []byte, seq uint64) (salvageSink, error) {
+	b := &salvageDumpBucket{
+		Name:     hex.EncodeToString(k),
+		Sequence: seq,
+	}
+	s.b.Buckets = append(s.b.Buckets, b)
+
+	return &dumpSalvageSink{b: b}, nil
+}
+
+// multiSalvageSink writes the salvaged data to multiple sinks.
+type multiSalvageSink []salvageSink
+
+func (s multiSalvageSink) put(k, v []byte) error {
+	for _, sink := range s {
+		if err := sink.put(k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s multiSalvageSink) bucket(k []byte, seq uint64) (salvageSink, error) {
+	nested := make(multiSalvageSink, len(s))
+	for idx, sink := range s {
+		var err error
+		nested[idx], err = sink.bucket(k, seq)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nested, nil
+}
+
+// salvageDB copies all readable top level buckets of the source DB to the
+// destination DB and/or the dump. Every top level bucket is written in its own
+// transaction.
+func salvageDB(src, dst *bbolt.DB, dump *salvageDumpBucket,
+	result *salvageResult) error {
+
+	// Reading a corrupted page can lead to a memory fault that we want to
+	// recover from like from any other panic.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+
+	return src.View(func(tx *bbolt.Tx) error {
+		salvageErr, err := salvageCursor(
+			tx.Cursor(), "", func(k, v []byte) error {
+				return salvageTopLevelBucket(
+					tx, dst, dump, k, v, result,
+				)
+			},
+		)
+		if salvageErr != nil {
+			result.Unrecoverable = append(
+				result.Unrecoverable, salvageErr,
+			)
+		}
+
+		return err
+	})
+}
+
+// salvageTopLevelBucket copies the readable content of a single top level
+// bucket.
+func salvageTopLevelBucket(tx *bbolt.Tx, dst *bbolt.DB,
+	dump *salvageDumpBucket, k, v []byte, result *salvageResult) error {
+
+	path := formatKey(k)
+	var bucket *bbolt.Bucket
+	if v == nil {
+		err := salvageCall(func() { bucket = tx.Bucket(k) })
+		if err != nil {
+			result.Unrecoverable = append(
+				result.Unrecoverable, &salvageError{
+					Path:  path,
+					Error: err.Error(),
+				},
+			)
+			return nil
+		}
+	}
+	if bucket == nil {
+		result.Unrecoverable = append(
+			result.Unrecoverable, &salvageError{
+				Path:  path,
+				Error: "not a bucket",
+			},
+		)
+		return nil
+	}
+
+	result.Buckets++
+	log.Infof("Salvaging bucket %s", path)
+
+	salvage := func(dstTx *bbolt.Tx) error {
+		var sinks multiSalvageSink
+		if dstTx != nil {
+			b, err := dstTx.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			if err := b.SetSequence(bucket.Sequence()); err != nil {
+				return err
+			}
+			b.FillPercent = 1.0
+			sinks = append(sinks, &boltSalvageSink{b: b})
+		}
+		if dump != nil {
+			sink, err := (&dumpSalvageSink{b: dump}).bucket(
+				k, bucket.Sequence(),
+			)
+			if err != nil {
+				return err
+			}
+			sinks = append(sinks, sink)
+		}
+
+		return salvageBucket(bucket, sinks, path, result)
+	}
+
+	if dst == nil {
+		return salvage(nil)
+	}
+
+	return dst.Update(salvage)
+}
+
+// salvageBucket recursively copies all readable keys and nested buckets of the
+// given bucket to the sink.
+func salvageBucket(bucket *bbolt.Bucket, sink salvageSink, path string,
+	result *salvageResult) error {
+
+	salvageErr, err := salvageCursor(
+		bucket.Cursor(), path, func(k, v []byte) error {
+			if v != nil {
+				result.Keys++
+				return sink.put(k, v)
+			}
+
+			keyPath := path + "/" + formatKey(k)
+			var nested *bbolt.Bucket
+			panicErr := salvageCall(func() {
+				nested = bucket.Bucket(k)
+			})
+			if panicErr != nil || nested == nil {
+				errMsg := "nested bucket not readable"
+				if panicErr != nil {
+					errMsg = panicErr.Error()
+				}
+				result.Unrecoverable = append(
+					result.Unrecoverable, &salvageError{
+						Path:  keyPath,
+						Error: errMsg,
+					},
+				)
+				return nil
+			}
+
+			nestedSink, err := sink.bucket(k, nested.Sequence())
+			if err != nil {
+				return err
+			}
+
+			result.Buckets++
+			return salvageBucket(
+				nested, nestedSink, keyPath, result,
+			)
+		},
+	)
+	if salvageErr != nil {
+		result.Unrecoverable = append(result.Unrecoverable, salvageErr)
+	}
+
+	return err
+}
+
+// salvageCursor calls fn for every readable key of the cursor. If a corrupted
+// page stops the iteration, the keys are read backward from the end until the
+// corrupted page is hit again. The part that couldn't be read is returned as
+// a salvage error. Errors returned by fn abort the iteration.
+func salvageCursor(c *bbolt.Cursor, path string,
+	fn func(k, v []byte) error) (*salvageError, error) {
+
+	var (
+		lastKey []byte
+		fnErr   error
+	)
+	panicErr := salvageCall(func() {
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if fnErr = fn(k, v); fnErr != nil {
+				return
+			}
+			lastKey = k
+		}
+	})
+	if fnErr != nil || panicErr == nil {
+		return nil, fnErr
+	}
+
+	salvageErr := &salvageError{
+		Path:  path,
+		Error: panicErr.Error(),
+	}
+	if lastKey != nil {
+		salvageErr.After = formatKey(lastKey)
+	}
+	_ = salvageCall(func() {
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if lastKey != nil && bytes.Compare(k, lastKey) <= 0 {
+				return
+			}
+			if fnErr = fn(k, v); fnErr != nil {
+				return
+			}
+			salvageErr.Before = formatKey(k)
+		}
+	})
+
+	return salvageErr, fnErr
+}
+
+// salvageCall calls fn and turns any panic into an error.
+func salvageCall(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("corrupted page: %v", r)
+		}
+	}()
+
+	fn()
+	return nil
+}
+
+// countSalvagedChannels tries to open the salvaged database as a channel DB
+// and counts the channels in it.
+func countSalvagedChannels(result *salvageResult) {
+	db, err := openChannelDB(result.DestDB, true)
+	if err != nil {
+		result.ChannelDBError = err.Error()
+		return
+	}
+	defer func() { _ = db.Close() }()
+
+	openChannels, err := db.ChannelStateDB().FetchAllOpenChannels()
+	if err != nil {
+		result.ChannelDBError = err.Error()
+		return
+	}
+	closedChannels, err := db.ChannelStateDB().FetchClosedChannels(false)
+	if err != nil {
+		result.ChannelDBError = err.Error()
+		return
+	}
+
+	result.OpenChannels = len(openChannels)
+	result.ClosedChannels = len(closedChannels)
+	log.Infof("Salvaged channel DB contains %d open and %d closed "+
+		"channels", result.OpenChannels, result.ClosedChannels)
+}
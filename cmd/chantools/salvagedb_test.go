@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/coreos/bbolt"
+	"github.com/guggero/chantools/lnd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSalvageDB(t *testing.T) {
+	h := newHarness(t)
+
+	channelDB, err := ioutil.ReadFile(h.testdataFile("channel.db"))
+	require.NoError(t, err)
+	dbFile := h.tempFile("channel.db")
+	require.NoError(t, ioutil.WriteFile(dbFile, channelDB, 0600))
+
+	// An intact DB is salvaged completely.
+	salvage := &salvageDBCommand{
+		SourceDB: dbFile,
+		DestDB:   h.tempFile("intact.db"),
+		Dump:     true,
+	}
+	require.NoError(t, salvage.Execute(nil, nil))
+	h.assertLogContains("Salvaged 48 buckets and 101 keys, 0 parts are " +
+		"unrecoverable")
+	h.assertLogContains("Salvaged channel DB contains 4 open and 0 " +
+		"closed channels")
+
+	// Mark the root page of the graph edge bucket as a freelist page, which
+	// makes bbolt panic when reading it.
+	db, err := bbolt.Open(dbFile, 0600, &bbolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	var corruptPage uint64
+	require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		corruptPage = uint64(tx.Bucket(edgeBucket).Root())
+		return nil
+	}))
+	pageSize := uint64(db.Info().PageSize)
+	require.NoError(t, db.Close())
+	require.NotZero(t, corruptPage)
+
+	f, err := os.OpenFile(dbFile, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	var flags [2]byte
+	binary.LittleEndian.PutUint16(flags[:], 0x10)
+	_, err = f.WriteAt(flags[:], int64(corruptPage*pageSize+8))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	h.clearLog()
+	salvage.DestDB = h.tempFile("salvaged.db")
+	require.NoError(t, salvage.Execute(nil, nil))
+	require.FileExists(t, salvage.DestDB)
+	h.assertLogContains("Unrecoverable: all keys of graph-edge: " +
+		"corrupted page: ")
+
+	// Everything else, including all channels, could be salvaged.
+	h.assertLogContains("1 parts are unrecoverable")
+	h.assertLogContains("Salvaged channel DB contains 4 open and 0 " +
+		"closed channels")
+
+	// Only bbolt files can be salvaged.
+	dbConfig.Backend = lnd.DBBackendSqlite
+	defer func() { dbConfig.Backend = "" }()
+	salvage.DestDB = h.tempFile("sqlite.db")
+	require.ErrorContains(
+		t, salvage.Execute(nil, nil), "only bbolt databases",
+	)
+}
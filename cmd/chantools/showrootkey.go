@@ -10,6 +10,11 @@ const showRootKeyFormat = `
 Your BIP32 HD root key is: %v
 `
 
+// showRootKeyResult is the JSON result of the showrootkey command.
+type showRootKeyResult struct {
+	RootKey string `json:"root_key"`
+}
+
 type showRootKeyCommand struct {
 	rootKey *rootKey
 	cmd     *cobra.Command
@@ -39,8 +44,14 @@ func (c *showRootKeyCommand) Execute(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("error reading root key: %w", err)
 	}
 
+	if JSONOutput {
+		return printJSON(&showRootKeyResult{
+			RootKey: extendedKey.String(),
+		})
+	}
+
 	result := fmt.Sprintf(showRootKeyFormat, extendedKey)
-	fmt.Println(result)
+	fmt.Fprintln(textOut(), result)
 
 	// For the tests, also log as trace level which is disabled by default.
 	log.Tracef(result)
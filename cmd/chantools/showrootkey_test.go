@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"testing"
 
 	"github.com/guggero/chantools/btc"
@@ -58,3 +59,102 @@ func TestShowRootKeyBIP39WithPassphrase(t *testing.T) {
 
 	h.assertLogContains(rootKeyBip39Passphrase)
 }
+
+func TestShowRootKeySeedFile(t *testing.T) {
+	h := newHarness(t)
+
+	// Read the aezeed and its passphrase from a file.
+	seedFile := h.tempFile("seed.txt")
+	err := os.WriteFile(
+		seedFile, []byte(seedAezeedWithPassphrase+"\n"+testPassPhrase),
+		0600,
+	)
+	require.NoError(t, err)
+
+	show := &showRootKeyCommand{
+		rootKey: &rootKey{SeedFile: seedFile},
+	}
+
+	err = show.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(rootKeyAezeed)
+
+	// A BIP39 seed without a passphrase line doesn't have a passphrase.
+	h.clearLog()
+	err = os.WriteFile(seedFile, []byte(seedBip39+"\n"), 0600)
+	require.NoError(t, err)
+
+	show.rootKey = &rootKey{SeedFile: seedFile, BIP39: true}
+	err = show.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(rootKeyBip39)
+
+	// The seed is never written to the environment.
+	require.Empty(t, os.Getenv(btc.BIP39MnemonicEnvName))
+	require.Empty(t, os.Getenv(btc.BIP39PassphraseEnvName))
+	require.Empty(t, os.Getenv(lnd.MnemonicEnvName))
+	require.Empty(t, os.Getenv(lnd.PassphraseEnvName))
+
+	// More than one seed source is an error.
+	t.Setenv(rootKeyEnvName, rootKeyAezeed)
+	show.rootKey = &rootKey{SeedFile: seedFile}
+	err = show.Execute(nil, nil)
+	require.ErrorContains(t, err, "only one root key or seed source")
+
+	// A root key from the environment can't be read as BIP39 seed.
+	show.rootKey = &rootKey{BIP39: true}
+	err = show.Execute(nil, nil)
+	require.ErrorContains(t, err, "cannot use --bip39 together")
+}
+
+func TestShowRootKeyStdinAndEnv(t *testing.T) {
+	h := newHarness(t)
+
+	// Read the root key from stdin.
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	_, err = writer.WriteString(rootKeyBip39 + "\n")
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	oldStdin := os.Stdin
+	os.Stdin = reader
+	t.Cleanup(func() {
+		os.Stdin = oldStdin
+	})
+
+	show := &showRootKeyCommand{
+		rootKey: &rootKey{Stdin: true},
+	}
+
+	err = show.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(rootKeyBip39)
+
+	// Read the root key from the environment.
+	h.clearLog()
+	t.Setenv(rootKeyEnvName, rootKeyAezeed)
+
+	show.rootKey = &rootKey{}
+	err = show.Execute(nil, nil)
+	require.NoError(t, err)
+
+	h.assertLogContains(rootKeyAezeed)
+}
+
+func TestShowRootKeyJSON(t *testing.T) {
+	h := newHarness(t)
+
+	h.captureJSON()
+	show := &showRootKeyCommand{
+		rootKey: &rootKey{RootKey: rootKeyAezeed},
+	}
+	require.NoError(t, show.Execute(nil, nil))
+
+	var result showRootKeyResult
+	h.assertJSONOutput(&result)
+	require.Equal(t, rootKeyAezeed, result.RootKey)
+}
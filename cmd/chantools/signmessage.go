@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/guggero/chantools/lnd"
+	"github.com/spf13/cobra"
+	"github.com/tv42/zbase32"
+)
+
+const (
+	// signedMsgPrefix is the prefix lnd adds to any message before signing
+	// or verifying it, so the signature can't be used for anything else
+	// (for example a transaction).
+	signedMsgPrefix = "Lightning Signed Message:"
+
+	signMessageFormat = `
+Message:	%s
+Public key:	%x
+Signature:	%s
+`
+)
+
+// signMessageResult is the JSON result of the signmessage command.
+type signMessageResult struct {
+	Msg       string `json:"msg"`
+	PubKey    string `json:"pubkey"`
+	Signature string `json:"signature"`
+}
+
+type signMessageCommand struct {
+	Msg string
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newSignMessageCommand() *cobra.Command {
+	cc := &signMessageCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "signmessage",
+		Short: "Sign a message with the node identity key, the same " +
+			"way lnd does",
+		Long: `Signs the given message with the node's identity private key
+derived from the seed. The signature is created and encoded (zbase32) in the
+same format as lnd's signmessage command uses, so it can be verified with
+lncli verifymessage or chantools verifymessage.
+
+This can be used to prove the ownership of a node to peers or services while
+the node itself can't be started.`,
+		Example: `chantools signmessage --msg "I own this node"`,
+		RunE:    cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Msg, "msg", "", "the message to sign",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "signing the message")
+
+	return cc.cmd
+}
+
+func (c *signMessageCommand) Execute(_ *cobra.Command, _ []string) error {
+	if c.Msg == "" {
+		return fmt.Errorf("message to sign is required")
+	}
+
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	_, pubKey, wif, err := lnd.DeriveKey(
+		extendedKey, lnd.IdentityPath(chainParams), chainParams,
+	)
+	if err != nil {
+		return fmt.Errorf("could not derive identity key: %w", err)
+	}
+
+	// Like lnd, we sign the double SHA256 hash of the prefixed message.
+	msg := []byte(signedMsgPrefix + c.Msg)
+	sig, err := ecdsa.SignCompact(
+		wif.PrivKey, chainhash.DoubleHashB(msg), true,
+	)
+	if err != nil {
+		return fmt.Errorf("error signing message: %w", err)
+	}
+
+	if JSONOutput {
+		return printJSON(&signMessageResult{
+			Msg: c.Msg,
+			PubKey: hex.EncodeToString(
+				pubKey.SerializeCompressed(),
+			),
+			Signature: zbase32.EncodeToString(sig),
+		})
+	}
+
+	result := fmt.Sprintf(
+		signMessageFormat, c.Msg, pubKey.SerializeCompressed(),
+		zbase32.EncodeToString(sig),
+	)
+	fmt.Fprintln(textOut(), result)
+
+	// For the tests, also log as trace level which is disabled by default.
+	log.Tracef(result)
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	sigRegex    = regexp.MustCompile(`Signature:\s+(\w+)`)
+	pubKeyRegex = regexp.MustCompile(`Public key:\s+([0-9a-f]{66})`)
+)
+
+func TestSignVerifyMessage(t *testing.T) {
+	h := newHarness(t)
+
+	sign := &signMessageCommand{
+		Msg:     "chantools test message",
+		rootKey: &rootKey{RootKey: rootKeyAezeed},
+	}
+
+	err := sign.Execute(nil, nil)
+	require.NoError(t, err)
+
+	sigMatch := sigRegex.FindStringSubmatch(h.getLog())
+	require.Len(t, sigMatch, 2)
+	pubKeyMatch := pubKeyRegex.FindStringSubmatch(h.getLog())
+	require.Len(t, pubKeyMatch, 2)
+
+	// The signature must be valid for the identity key.
+	h.clearLog()
+	verify := &verifyMessageCommand{
+		Msg:    sign.Msg,
+		Sig:    sigMatch[1],
+		PubKey: pubKeyMatch[1],
+	}
+	err = verify.Execute(nil, nil)
+	require.NoError(t, err)
+	h.assertLogContains(pubKeyMatch[1])
+
+	// A different message results in a different public key.
+	verify.Msg = "another message"
+	err = verify.Execute(nil, nil)
+	require.ErrorContains(t, err, "and not by "+pubKeyMatch[1])
+}
+
+func TestSignVerifyMessageJSON(t *testing.T) {
+	h := newHarness(t)
+
+	h.captureJSON()
+	sign := &signMessageCommand{
+		Msg:     "chantools test message",
+		rootKey: &rootKey{RootKey: rootKeyAezeed},
+	}
+	require.NoError(t, sign.Execute(nil, nil))
+
+	var signResult signMessageResult
+	h.assertJSONOutput(&signResult)
+	require.Equal(t, sign.Msg, signResult.Msg)
+	require.Len(t, signResult.PubKey, 66)
+
+	h.captureJSON()
+	verify := &verifyMessageCommand{
+		Msg: sign.Msg,
+		Sig: signResult.Signature,
+	}
+	require.NoError(t, verify.Execute(nil, nil))
+
+	var verifyResult verifyMessageResult
+	h.assertJSONOutput(&verifyResult)
+	require.Equal(t, signResult.PubKey, verifyResult.PubKey)
+}
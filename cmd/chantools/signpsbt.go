@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/spf13/cobra"
+)
+
+type signPsbtCommand struct {
+	Psbt     string
+	Finalize bool
+
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newSignPsbtCommand() *cobra.Command {
+	cc := &signPsbtCommand{}
+	cc.cmd = &cobra.Command{
+		Use:   "signpsbt",
+		Short: "Sign all inputs of a PSBT that belong to the root key",
+		Long: `Sign the inputs of an externally constructed PSBT with
+keys derived from the root key. This command doesn't need any network access,
+so it can be used on an offline machine.
+
+The key of each input is derived from its BIP32 derivation path, which can be
+any path of the root key. That includes the lnd wallet paths (m/49', m/84' and
+m/86') as well as the lnd key families (m/1017'/<coin_type>'/<key_family>').
+Inputs with derivations of a different master key fingerprint are skipped.
+
+Supported are P2WKH, NP2WKH, P2WSH (with the witness script in the PSBT), P2TR
+key spend (BIP86 or with the merkle root in the PSBT) and P2TR script spend
+inputs (with the leaf script in the PSBT). Legacy P2PKH and P2SH inputs are
+supported if the PSBT contains the full previous transaction.
+
+Keys that need to be tweaked, like the ones of the outputs of a commitment
+transaction, can be signed by adding the tweak to the input as an unknown field
+with the same key type lnd uses: 0x51 for a single tweak and 0x52 for a double
+tweak (the revocation secret).
+
+With --finalize the final transaction is extracted, this only works if all
+inputs are signed and are of a standard type (P2PKH, P2WKH, NP2WKH or P2TR key
+spend).`,
+		Example: `chantools signpsbt \
+	--psbt <unsigned_psbt_base64>
+
+chantools signpsbt --finalize \
+	--psbt <unsigned_psbt_base64>`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Psbt, "psbt", "", "the base64 encoded PSBT to sign",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Finalize, "finalize", false, "finalize all inputs and "+
+			"print the final raw transaction as well",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "signing the PSBT")
+
+	return cc.cmd
+}
+
+func (c *signPsbtCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	packet, version, err := btc.DecodePsbt(c.Psbt)
+	if err != nil {
+		return fmt.Errorf("error decoding PSBT: %w", err)
+	}
+
+	result, err := signPsbt(&lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}, packet, version, c.Finalize)
+	if err != nil {
+		return err
+	}
+
+	if JSONOutput {
+		return printJSON(result)
+	}
+
+	fmt.Fprintf(textOut(), "Signed PSBT:\n\n%s\n\n", result.Psbt)
+	if result.RawTx != "" {
+		fmt.Fprintf(textOut(), "Final transaction:\n\n%s\n\n",
+			result.RawTx)
+	}
+
+	return nil
+}
+
+// signPsbtResult is the JSON result of the signpsbt command.
+type signPsbtResult struct {
+	Psbt         string `json:"psbt"`
+	SignedInputs []int  `json:"signed_inputs"`
+	RawTx        string `json:"raw_tx,omitempty"`
+}
+
+// signPsbt signs all inputs of the PSBT that belong to the signer and
+// optionally finalizes it. The signed PSBT is returned in the same version as
+// the input.
+func signPsbt(signer *lnd.Signer, packet *psbt.Packet, version uint32,
+	finalize bool) (*signPsbtResult, error) {
+
+	signed, err := signer.SignPsbt(packet)
+	if err != nil {
+		return nil, err
+	}
+	if len(signed) == 0 {
+		return nil, fmt.Errorf("none of the inputs can be signed " +
+			"with the root key")
+	}
+	log.Infof("Signed %d of %d inputs: %v", len(signed),
+		len(packet.Inputs), signed)
+
+	result := &signPsbtResult{
+		SignedInputs: signed,
+	}
+	if finalize {
+		if err := psbt.MaybeFinalizeAll(packet); err != nil {
+			return nil, fmt.Errorf("error finalizing PSBT: %w", err)
+		}
+		finalTx, err := psbt.Extract(packet)
+		if err != nil {
+			return nil, fmt.Errorf("error extracting final TX: %w",
+				err)
+		}
+
+		var buf bytes.Buffer
+		if err := finalTx.Serialize(&buf); err != nil {
+			return nil, fmt.Errorf("error serializing final TX: %w",
+				err)
+		}
+		result.RawTx = hex.EncodeToString(buf.Bytes())
+	}
+
+	result.Psbt, err = btc.EncodePsbt(packet, version)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding PSBT: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignPsbt(t *testing.T) {
+	h := newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	rootPubKey, err := extendedKey.ECPubKey()
+	require.NoError(t, err)
+	fingerprint := binary.LittleEndian.Uint32(
+		btcutil.Hash160(rootPubKey.SerializeCompressed())[:4],
+	)
+
+	deriveKey := func(path string) ([]uint32, *btcec.PublicKey) {
+		parsedPath, err := lnd.ParsePath(path)
+		require.NoError(t, err)
+		key, err := lnd.DeriveChildren(extendedKey, parsedPath)
+		require.NoError(t, err)
+		pubKey, err := key.ECPubKey()
+		require.NoError(t, err)
+
+		return parsedPath, pubKey
+	}
+	p2wkhScript := func(pubKey *btcec.PublicKey) []byte {
+		script, err := input.WitnessPubKeyHash(
+			pubKey.SerializeCompressed(),
+		)
+		require.NoError(t, err)
+		return script
+	}
+
+	// We spend a P2WKH, an NP2WKH, a P2TR and a legacy P2PKH wallet output
+	// and an output to a tweaked lnd key.
+	var (
+		utxos       []*wire.TxOut
+		derivations []*psbt.Bip32Derivation
+	)
+	addInput := func(pkScript []byte, path []uint32,
+		pubKey *btcec.PublicKey) {
+
+		utxos = append(utxos, &wire.TxOut{
+			Value:    100_000,
+			PkScript: pkScript,
+		})
+		derivations = append(derivations, &psbt.Bip32Derivation{
+			PubKey:               pubKey.SerializeCompressed(),
+			MasterKeyFingerprint: fingerprint,
+			Bip32Path:            path,
+		})
+	}
+
+	path, pubKey := deriveKey("m/84'/1'/0'/0/0")
+	addInput(p2wkhScript(pubKey), path, pubKey)
+
+	path, pubKey = deriveKey("m/49'/1'/0'/0/1")
+	redeemScript := p2wkhScript(pubKey)
+	np2wkhScript, err := input.GenerateP2SH(redeemScript)
+	require.NoError(t, err)
+	addInput(np2wkhScript, path, pubKey)
+
+	taprootPath, taprootKey := deriveKey("m/86'/1'/0'/0/2")
+	p2trScript, err := txscript.PayToTaprootScript(
+		txscript.ComputeTaprootKeyNoScript(taprootKey),
+	)
+	require.NoError(t, err)
+	addInput(p2trScript, taprootPath, taprootKey)
+
+	path, pubKey = deriveKey("m/1017'/1'/2'/0/5")
+	tweak := bytes.Repeat([]byte{0x42}, 32)
+	addInput(
+		p2wkhScript(input.TweakPubKeyWithTweak(pubKey, tweak)), path,
+		pubKey,
+	)
+
+	path, pubKey = deriveKey("m/44'/1'/0'/1/3")
+	p2pkhAddr, err := lnd.P2PKHAddr(pubKey, chainParams)
+	require.NoError(t, err)
+	p2pkhScript, err := txscript.PayToAddrScript(p2pkhAddr)
+	require.NoError(t, err)
+	addInput(p2pkhScript, path, pubKey)
+
+	prevTx := wire.NewMsgTx(2)
+	prevTx.AddTxIn(&wire.TxIn{})
+	prevTx.TxOut = utxos
+	outpoints := make([]*wire.OutPoint, len(utxos))
+	for idx := range utxos {
+		outpoints[idx] = &wire.OutPoint{
+			Hash:  prevTx.TxHash(),
+			Index: uint32(idx),
+		}
+	}
+
+	newPacket := func() *psbt.Packet {
+		packet, err := psbt.New(
+			outpoints, []*wire.TxOut{{
+				Value:    490_000,
+				PkScript: p2wkhScript(taprootKey),
+			}}, 2, 0, make([]uint32, len(outpoints)),
+		)
+		require.NoError(t, err)
+
+		for idx := range packet.Inputs {
+			derivation := *derivations[idx]
+			pIn := &packet.Inputs[idx]
+			pIn.WitnessUtxo = utxos[idx]
+			pIn.Bip32Derivation = []*psbt.Bip32Derivation{
+				&derivation,
+			}
+		}
+
+		// The NP2WKH input only has the full previous transaction.
+		packet.Inputs[1].WitnessUtxo = nil
+		packet.Inputs[1].NonWitnessUtxo = prevTx
+		packet.Inputs[1].RedeemScript = redeemScript
+
+		xOnlyKey := schnorr.SerializePubKey(taprootKey)
+		packet.Inputs[2].Bip32Derivation = nil
+		packet.Inputs[2].TaprootBip32Derivation = []*psbt.
+			TaprootBip32Derivation{{
+			XOnlyPubKey:          xOnlyKey,
+			MasterKeyFingerprint: fingerprint,
+			Bip32Path:            taprootPath,
+		}}
+
+		packet.Inputs[3].Unknowns = []*psbt.Unknown{{
+			Key:   lnd.PsbtKeyTypeInputSignatureTweakSingle,
+			Value: tweak,
+		}}
+
+		// The legacy input also only has the full previous
+		// transaction.
+		packet.Inputs[4].WitnessUtxo = nil
+		packet.Inputs[4].NonWitnessUtxo = prevTx
+
+		return packet
+	}
+
+	result, err := signPsbt(signer, newPacket(), btc.PsbtVersion0, true)
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 2, 3, 4}, result.SignedInputs)
+
+	// All inputs of the final transaction are valid.
+	rawTx, err := hex.DecodeString(result.RawTx)
+	require.NoError(t, err)
+	finalTx := &wire.MsgTx{}
+	require.NoError(t, finalTx.Deserialize(bytes.NewReader(rawTx)))
+
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for idx, txIn := range finalTx.TxIn {
+		prevOutFetcher.AddPrevOut(txIn.PreviousOutPoint, utxos[idx])
+	}
+	sigHashes := txscript.NewTxSigHashes(finalTx, prevOutFetcher)
+	for idx := range finalTx.TxIn {
+		vm, err := txscript.NewEngine(
+			utxos[idx].PkScript, finalTx, idx,
+			txscript.StandardVerifyFlags, nil, sigHashes,
+			utxos[idx].Value, prevOutFetcher,
+		)
+		require.NoError(t, err)
+		require.NoError(t, vm.Execute(), "input %d", idx)
+	}
+
+	// Inputs with the derivation of another master key are skipped.
+	packet := newPacket()
+	packet.Inputs[0].Bip32Derivation[0].MasterKeyFingerprint++
+	result, err = signPsbt(signer, packet, btc.PsbtVersion2, false)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 3, 4}, result.SignedInputs)
+	require.Empty(t, result.RawTx)
+
+	// A key that doesn't match the derivation path is detected.
+	packet = newPacket()
+	packet.Inputs[0].Bip32Derivation[0].Bip32Path = taprootPath
+	_, err = signPsbt(signer, packet, btc.PsbtVersion0, false)
+	require.ErrorContains(t, err, "wrong seed?")
+
+	// With --json, the result is written to stdout as JSON.
+	encodedPsbt, err := btc.EncodePsbt(newPacket(), btc.PsbtVersion0)
+	require.NoError(t, err)
+	h.captureJSON()
+	sign := &signPsbtCommand{
+		Psbt:     encodedPsbt,
+		Finalize: true,
+		rootKey:  &rootKey{RootKey: rootKeyAezeed},
+	}
+	require.NoError(t, sign.Execute(nil, nil))
+
+	var jsonResult signPsbtResult
+	h.assertJSONOutput(&jsonResult)
+	require.Equal(t, []int{0, 1, 2, 3, 4}, jsonResult.SignedInputs)
+	rawTx, err = hex.DecodeString(jsonResult.RawTx)
+	require.NoError(t, err)
+	jsonTx := &wire.MsgTx{}
+	require.NoError(t, jsonTx.Deserialize(bytes.NewReader(rawTx)))
+	require.Equal(t, finalTx.TxHash(), jsonTx.TxHash())
+}
@@ -2,18 +2,27 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/lnd"
 	"github.com/lightningnetwork/lnd/keychain"
 	"github.com/spf13/cobra"
 )
 
 type signRescueFundingCommand struct {
-	Psbt string
+	Psbt        string
+	NonceFile   string
+	XPub        string
+	Fingerprint string
+	SignedPsbt  string
 
 	rootKey *rootKey
 	cmd     *cobra.Command
@@ -32,10 +41,42 @@ funding output that was created on chain by accident but never resulted in a
 proper channel and no commitment transactions exist to spend the funds locked in
 the 2-of-2 multisig.
 
+The PSBT can spend multiple funding outputs at once if the initiator batched
+them. Our signature is added to each of them.
+
+The PSBT can be in the version 0 (BIP 174) or version 2 (BIP 370) format.
+
 If successful, this will create a final on-chain transaction that can be
-broadcast by any Bitcoin node.`,
+broadcast by any Bitcoin node.
+
+For simple taproot channels the funds are locked to a MuSig2 aggregated key and
+signing requires an additional round: The remote node runs this command first
+to add its nonce and partial signature and sends the resulting PSBT back to the
+initiator. The initiator then runs this command with that PSBT and the
+--noncefile that was created by the rescuefunding command to add the last
+signature and create the final transaction.
+
+If the multisig key of the remote node lives on a hardware signer, the seed is
+not needed. Instead, the --xpub of the multisig key family account
+(m/1017'/<coin_type>'/0') and the --fingerprint of the signer's master key can
+be specified. The command then looks up the key to sign with and outputs the
+PSBT with the BIP32 derivation information added, so it can be signed by the
+hardware signer. The PSBT returned by the signer can then be finalized with the
+--signedpsbt flag. This is only supported for 2-of-2 multisig funding outputs.`,
 		Example: `chantools signrescuefunding \
-	--psbt <the_base64_encoded_psbt_from_step_1>`,
+	--psbt <the_base64_encoded_psbt_from_step_1>
+
+chantools signrescuefunding \
+	--psbt <the_base64_encoded_psbt_from_step_2> \
+	--noncefile results/rescuefunding-nonce-xxxx.hex
+
+chantools signrescuefunding \
+	--psbt <the_base64_encoded_psbt_from_step_1> \
+	--xpub xpub6xxxxxxx \
+	--fingerprint aabbccdd
+
+chantools signrescuefunding \
+	--signedpsbt <the_psbt_signed_by_the_hardware_signer>`,
 		RunE: cc.Execute,
 	}
 	cc.cmd.Flags().StringVar(
@@ -43,6 +84,27 @@ broadcast by any Bitcoin node.`,
 			"that was provided by the initiator of the channel to "+
 			"rescue",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.NonceFile, "noncefile", "", "the file with the secret "+
+			"MuSig2 nonce created by the rescuefunding command; "+
+			"only needed by the initiator to finalize the rescue "+
+			"of a simple taproot channel",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.XPub, "xpub", "", "extended public key of the multisig "+
+			"key family account (m/1017'/<coin_type>'/0') of a "+
+			"hardware signer; if set, the PSBT is not signed but "+
+			"exported for the hardware signer instead",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Fingerprint, "fingerprint", "", "hex encoded master key "+
+			"fingerprint of the hardware signer; required with "+
+			"--xpub",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SignedPsbt, "signedpsbt", "", "PSBT that was signed by "+
+			"the hardware signer and only needs to be finalized",
+	)
 
 	cc.rootKey = newRootKey(cc.cmd, "deriving keys")
 
@@ -50,6 +112,14 @@ broadcast by any Bitcoin node.`,
 }
 
 func (c *signRescueFundingCommand) Execute(_ *cobra.Command, _ []string) error {
+	switch {
+	case c.SignedPsbt != "":
+		return c.finalizeExternal()
+
+	case c.XPub != "":
+		return c.exportExternal()
+	}
+
 	extendedKey, err := c.rootKey.read()
 	if err != nil {
 		return fmt.Errorf("error reading root key: %w", err)
@@ -61,21 +131,22 @@ func (c *signRescueFundingCommand) Execute(_ *cobra.Command, _ []string) error {
 	}
 
 	// Decode the PSBT.
-	packet, err := psbt.NewFromRawBytes(
-		bytes.NewReader([]byte(c.Psbt)), true,
-	)
+	packet, psbtVersion, err := btc.DecodePsbt(c.Psbt)
 	if err != nil {
 		return fmt.Errorf("error decoding PSBT: %w", err)
 	}
 
-	return signRescueFunding(extendedKey, packet, signer)
+	return signRescueFunding(
+		extendedKey, packet, psbtVersion, signer, c.NonceFile,
+	)
 }
 
 func signRescueFunding(rootKey *hdkeychain.ExtendedKey,
-	packet *psbt.Packet, signer *lnd.Signer) error {
+	packet *psbt.Packet, psbtVersion uint32, signer *lnd.Signer,
+	nonceFile string) error {
 
 	// First, we need to derive the correct branch from the local root key.
-	localMultisig, err := lnd.DeriveChildren(rootKey, []uint32{
+	localMultisig, err := lnd.DeriveFromRoot(rootKey, []uint32{
 		lnd.HardenedKeyStart + uint32(keychain.BIP0043Purpose),
 		lnd.HardenedKeyStart + chainParams.HDCoinType,
 		lnd.HardenedKeyStart + uint32(keychain.KeyFamilyMultiSig),
@@ -86,52 +157,159 @@ func signRescueFunding(rootKey *hdkeychain.ExtendedKey,
 			err)
 	}
 
-	// Now let's check that the packet has the expected proprietary key with
-	// our pubkey that we need to sign with.
-	if len(packet.Inputs) != 1 {
-		return fmt.Errorf("invalid PSBT, expected 1 input, got %d",
-			len(packet.Inputs))
+	if len(packet.Inputs) == 0 {
+		return fmt.Errorf("invalid PSBT, no inputs")
 	}
-	if len(packet.Inputs[0].Unknowns) != 1 {
-		return fmt.Errorf("invalid PSBT, expected 1 unknown in input, "+
-			"got %d", len(packet.Inputs[0].Unknowns))
+
+	// Simple taproot channels need a MuSig2 signing session instead.
+	utxo := packet.Inputs[0].WitnessUtxo
+	if utxo != nil && txscript.IsPayToTaproot(utxo.PkScript) {
+		if len(packet.Inputs) != 1 {
+			return fmt.Errorf("invalid PSBT, expected 1 taproot "+
+				"input, got %d", len(packet.Inputs))
+		}
+
+		return signRescueTaprootFunding(
+			localMultisig, packet, psbtVersion, signer, nonceFile,
+		)
 	}
-	unknown := packet.Inputs[0].Unknowns[0]
-	if !bytes.Equal(unknown.Key, PsbtKeyTypeOutputMissingSigPubkey) {
-		return fmt.Errorf("invalid PSBT, unknown has invalid key %x, "+
-			"expected %x", unknown.Key,
-			PsbtKeyTypeOutputMissingSigPubkey)
+
+	finalTx, err := signRescueMultisigPSBT(localMultisig, packet, signer)
+	if err != nil {
+		return err
 	}
-	targetKey, err := btcec.ParsePubKey(unknown.Value)
+	var buf bytes.Buffer
+	err = finalTx.Serialize(&buf)
 	if err != nil {
-		return fmt.Errorf("invalid PSBT, proprietary key has invalid "+
-			"pubkey: %w", err)
+		return fmt.Errorf("unable to serialize final TX: %w", err)
+	}
+
+	if JSONOutput {
+		return printJSON(newRawTxResult(finalTx, buf.Bytes()))
 	}
+	fmt.Fprintf(textOut(), "Success, we counter signed the PSBT and "+
+		"extracted the final\ntransaction. Please publish this using "+
+		"any bitcoin node:\n\n%x\n\n", buf.Bytes())
 
-	// Now we can look up the local key and check the PSBT further, then
-	// add our signature.
-	localKeyDesc, err := findLocalMultisigKey(localMultisig, targetKey)
+	return nil
+}
+
+// signRescueMultisigPSBT adds our signature to each 2-of-2 multisig input of
+// the rescue PSBT and then extracts the final transaction.
+func signRescueMultisigPSBT(multisigBranch *hdkeychain.ExtendedKey,
+	packet *psbt.Packet, signer *lnd.Signer) (*wire.MsgTx, error) {
+
+	for idx, pIn := range packet.Inputs {
+		// Now let's check that the input has the expected proprietary
+		// key with our pubkey that we need to sign with.
+		if len(pIn.Unknowns) != 1 {
+			return nil, fmt.Errorf("invalid PSBT, expected 1 "+
+				"unknown in input %d, got %d", idx,
+				len(pIn.Unknowns))
+		}
+		unknown := pIn.Unknowns[0]
+		expectedKey := PsbtKeyTypeOutputMissingSigPubkey
+		if !bytes.Equal(unknown.Key, expectedKey) {
+			return nil, fmt.Errorf("invalid PSBT, unknown has "+
+				"invalid key %x, expected %x", unknown.Key,
+				expectedKey)
+		}
+		targetKey, err := btcec.ParsePubKey(unknown.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PSBT, proprietary key "+
+				"has invalid pubkey: %w", err)
+		}
+
+		// Now we can look up the local key and check the PSBT further,
+		// then add our signature.
+		localKeyDesc, err := findLocalMultisigKey(
+			multisigBranch, targetKey,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not find local multisig "+
+				"key: %w", err)
+		}
+		if len(pIn.WitnessScript) == 0 {
+			return nil, fmt.Errorf("invalid PSBT, missing " +
+				"witness script")
+		}
+		if pIn.WitnessUtxo == nil {
+			return nil, fmt.Errorf("invalid PSBT, witness UTXO " +
+				"missing")
+		}
+
+		err = signer.AddPartialSignature(
+			packet, *localKeyDesc, pIn.WitnessUtxo,
+			pIn.WitnessScript, idx,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error adding partial "+
+				"signature: %w", err)
+		}
+	}
+
+	// We're almost done. Now we just need to make sure we can finalize and
+	// extract the final TX.
+	err := psbt.MaybeFinalizeAll(packet)
 	if err != nil {
-		return fmt.Errorf("could not find local multisig key: %w", err)
+		return nil, fmt.Errorf("error finalizing PSBT: %w", err)
 	}
-	if len(packet.Inputs[0].WitnessScript) == 0 {
-		return fmt.Errorf("invalid PSBT, missing witness script")
+	finalTx, err := psbt.Extract(packet)
+	if err != nil {
+		return nil, fmt.Errorf("unable to extract final TX: %w", err)
 	}
-	witnessScript := packet.Inputs[0].WitnessScript
-	if packet.Inputs[0].WitnessUtxo == nil {
-		return fmt.Errorf("invalid PSBT, witness UTXO missing")
+
+	return finalTx, nil
+}
+
+// exportExternal adds the BIP32 derivation information of the hardware
+// signer's multisig keys to the PSBT so it can be signed externally.
+func (c *signRescueFundingCommand) exportExternal() error {
+	accountKey, err := hdkeychain.NewKeyFromString(c.XPub)
+	if err != nil {
+		return fmt.Errorf("error parsing xpub: %w", err)
 	}
-	utxo := packet.Inputs[0].WitnessUtxo
+	fingerprintBytes, err := hex.DecodeString(c.Fingerprint)
+	if err != nil || len(fingerprintBytes) != 4 {
+		return fmt.Errorf("invalid fingerprint %s, must be 4 bytes "+
+			"hex encoded", c.Fingerprint)
+	}
+	fingerprint := binary.LittleEndian.Uint32(fingerprintBytes)
 
-	err = signer.AddPartialSignature(
-		packet, *localKeyDesc, utxo, witnessScript, 0,
-	)
+	packet, psbtVersion, err := btc.DecodePsbt(c.Psbt)
 	if err != nil {
-		return fmt.Errorf("error adding partial signature: %w", err)
+		return fmt.Errorf("error decoding PSBT: %w", err)
+	}
+
+	err = addExternalSignerDerivation(accountKey, fingerprint, packet)
+	if err != nil {
+		return err
+	}
+
+	base64, err := btc.EncodePsbt(packet, psbtVersion)
+	if err != nil {
+		return fmt.Errorf("error encoding PSBT: %w", err)
+	}
+
+	if JSONOutput {
+		return printJSON(&psbtResult{Psbt: base64})
+	}
+	fmt.Fprintf(textOut(), "PSBT for hardware signer created. Sign it "+
+		"with your hardware signer, then\nrun 'chantools "+
+		"signrescuefunding --signedpsbt' with the signed "+
+		"PSBT:\n\n%s\n\n", base64)
+
+	return nil
+}
+
+// finalizeExternal finalizes a rescue PSBT that was signed by a hardware
+// signer and prints the final transaction.
+func (c *signRescueFundingCommand) finalizeExternal() error {
+	packet, _, err := btc.DecodePsbt(c.SignedPsbt)
+	if err != nil {
+		return fmt.Errorf("error decoding PSBT: %w", err)
 	}
 
-	// We're almost done. Now we just need to make sure we can finalize and
-	// extract the final TX.
 	err = psbt.MaybeFinalizeAll(packet)
 	if err != nil {
 		return fmt.Errorf("error finalizing PSBT: %w", err)
@@ -146,9 +324,125 @@ func signRescueFunding(rootKey *hdkeychain.ExtendedKey,
 		return fmt.Errorf("unable to serialize final TX: %w", err)
 	}
 
-	fmt.Printf("Success, we counter signed the PSBT and extracted the "+
-		"final\ntransaction. Please publish this using any bitcoin "+
-		"node:\n\n%x\n\n", buf.Bytes())
+	if JSONOutput {
+		return printJSON(newRawTxResult(finalTx, buf.Bytes()))
+	}
+	fmt.Fprintf(textOut(), "Success, we extracted the final transaction. "+
+		"Please publish this using\nany bitcoin node:\n\n%x\n\n",
+		buf.Bytes())
+
+	return nil
+}
+
+// addExternalSignerDerivation looks up the multisig key each input needs to
+// be signed with in the given multisig account key and adds its BIP32
+// derivation path to the input.
+func addExternalSignerDerivation(accountKey *hdkeychain.ExtendedKey,
+	fingerprint uint32, packet *psbt.Packet) error {
+
+	multisigBranch, err := accountKey.DeriveNonStandard(0)
+	if err != nil {
+		return fmt.Errorf("could not derive multisig branch: %w", err)
+	}
+
+	for idx := range packet.Inputs {
+		pIn := &packet.Inputs[idx]
+		if pIn.WitnessUtxo == nil || len(pIn.WitnessScript) == 0 {
+			return fmt.Errorf("invalid PSBT, input %d is missing "+
+				"witness UTXO or script", idx)
+		}
+		if txscript.IsPayToTaproot(pIn.WitnessUtxo.PkScript) {
+			return fmt.Errorf("signing taproot funding outputs " +
+				"with a hardware signer is not supported")
+		}
+		if len(pIn.Unknowns) != 1 || !bytes.Equal(
+			pIn.Unknowns[0].Key, PsbtKeyTypeOutputMissingSigPubkey,
+		) {
+
+			return fmt.Errorf("invalid PSBT, input %d is missing "+
+				"the public key to sign with", idx)
+		}
+		targetKey, err := btcec.ParsePubKey(pIn.Unknowns[0].Value)
+		if err != nil {
+			return fmt.Errorf("invalid PSBT, proprietary key has "+
+				"invalid pubkey: %w", err)
+		}
+
+		keyDesc, err := findLocalMultisigKey(multisigBranch, targetKey)
+		if err != nil {
+			return fmt.Errorf("could not find multisig key in "+
+				"xpub: %w", err)
+		}
+
+		const hardened = lnd.HardenedKeyStart
+		path := []uint32{
+			hardened + uint32(keychain.BIP0043Purpose),
+			hardened + chainParams.HDCoinType,
+			hardened + uint32(keychain.KeyFamilyMultiSig),
+			0, keyDesc.Index,
+		}
+		pubKey := keyDesc.PubKey.SerializeCompressed()
+		derivation := &psbt.Bip32Derivation{
+			PubKey:               pubKey,
+			MasterKeyFingerprint: fingerprint,
+			Bip32Path:            path,
+		}
+		pIn.SighashType = txscript.SigHashAll
+		pIn.Bip32Derivation = append(pIn.Bip32Derivation, derivation)
+	}
+
+	return nil
+}
+
+// signRescueTaprootFunding either adds the remote node's nonce and partial
+// signature to a taproot funding rescue PSBT or, if a nonce file is given,
+// finalizes the PSBT as the initiator. The partially signed PSBT is encoded in
+// the given PSBT version.
+func signRescueTaprootFunding(multisigBranch *hdkeychain.ExtendedKey,
+	packet *psbt.Packet, psbtVersion uint32, signer *lnd.Signer,
+	nonceFile string) error {
+
+	if nonceFile == "" {
+		err := signTaprootRescuePSBT(multisigBranch, packet, signer)
+		if err != nil {
+			return err
+		}
+
+		base64, err := btc.EncodePsbt(packet, psbtVersion)
+		if err != nil {
+			return fmt.Errorf("error encoding PSBT: %w", err)
+		}
+
+		if JSONOutput {
+			return printJSON(&psbtResult{Psbt: base64})
+		}
+		fmt.Fprintf(textOut(), "Partially signed transaction created. "+
+			"Send this back to the initiator\nof the channel and "+
+			"ask them to run the 'chantools "+
+			"signrescuefunding'\ncommand with their --noncefile: "+
+			"\n\n%s\n\n", base64)
+
+		return nil
+	}
+
+	finalTx, err := finalizeTaprootRescuePSBT(
+		multisigBranch, packet, signer, nonceFile,
+	)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = finalTx.Serialize(&buf)
+	if err != nil {
+		return fmt.Errorf("unable to serialize final TX: %w", err)
+	}
+
+	if JSONOutput {
+		return printJSON(newRawTxResult(finalTx, buf.Bytes()))
+	}
+	fmt.Fprintf(textOut(), "Success, we combined both signatures and "+
+		"extracted the final\ntransaction. Please publish this using "+
+		"any bitcoin node:\n\n%x\n\n", buf.Bytes())
 
 	return nil
 }
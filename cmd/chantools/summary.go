@@ -1,21 +1,34 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"time"
+	"io"
 
 	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/dataformat"
+	"github.com/lightningnetwork/lnd/lncfg"
 	"github.com/spf13/cobra"
 )
 
+const (
+	defaultSummaryWorkers = 4
+)
+
 type summaryCommand struct {
-	APIURL string
+	Format    string
+	Workers   int
+	CacheFile string
+	Resume    bool
+	Output    string
+	Stdout    bool
 
-	inputs *inputFlags
-	cmd    *cobra.Command
+	ForceCloseOnly  bool
+	MinLocalBalance uint64
+	Peer            string
+
+	chainAPI *chainAPIFlags
+	inputs   *inputFlags
+	cmd      *cobra.Command
 }
 
 func newSummaryCommand() *cobra.Command {
@@ -25,35 +38,181 @@ func newSummaryCommand() *cobra.Command {
 		Short: "Compile a summary about the current state of " +
 			"channels",
 		Long: `From a list of channels, find out what their state is by
-querying the funding transaction on a block explorer API.`,
+querying the funding transaction on a block explorer API.
+
+Instead of an esplora compatible block explorer, the JSON-RPC interface of a
+bitcoind full node can be used by specifying --bitcoindrpc. The node must have
+the transaction index enabled (txindex=1). Enabling the block filter index
+(blockfilterindex=1) as well makes finding the spends of closed channels much
+faster.
+
+An Electrum server (ElectrumX, Electrs or Fulcrum) can be used by specifying
+--electrumserver, optionally with --electrumtls for an SSL/TLS connection.
+
+To not reveal the channels to any server, --neutrino starts an embedded light
+client that downloads the compact block filters (BIP 157/158) from the P2P
+network and matches them locally. The initial sync takes a while and only
+channels with a known short channel ID (read from the channel DB or the chan_id
+of lncli listchannels) can be looked up.
+
+The commitment type of force closed channels is read from the channel DB. For
+other inputs it is detected from the outputs of the closing transaction, which
+only tells taproot, anchors_or_script_enforced_lease and
+legacy_or_static_remote_key channels apart.`,
 		Example: `lncli listchannels | chantools summary --listchannels -
 
-chantools summary --fromchanneldb ~/.lnd/data/graph/mainnet/channel.db`,
+lncli listchannels | chantools summary --listchannels - --stdout \
+	--format csv > summary.csv
+
+chantools summary --fromchanneldb ~/.lnd/data/graph/mainnet/channel.db
+
+chantools summary --fromchanneldb ~/.lnd/data/graph/mainnet/channel.db \
+	--bitcoindrpc localhost:8332 \
+	--bitcoindcookie ~/.bitcoin/.cookie
+
+chantools summary --fromchanneldb ~/.lnd/data/graph/mainnet/channel.db \
+	--electrumserver electrum.example.com:50002 --electrumtls
+
+chantools summary --fromchanneldb ~/.lnd/data/graph/mainnet/channel.db \
+	--neutrino`,
 		RunE: cc.Execute,
+		Annotations: map[string]string{
+			annotationJSONFlags: "format=" +
+				dataformat.ReportFormatJSON + ",stdout=true",
+		},
 	}
 	cc.cmd.Flags().StringVar(
-		&cc.APIURL, "apiurl", defaultAPIURL, "API URL to use (must "+
-			"be esplora compatible)",
+		&cc.Format, "format", dataformat.ReportFormatJSON, "format "+
+			"of the summary file to write; can be json, csv or "+
+			"html",
 	)
-
+	cc.cmd.Flags().IntVar(
+		&cc.Workers, "workers", defaultSummaryWorkers, "number of "+
+			"channels to look up concurrently",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.CacheFile, "cachefile", "", "optional file to append "+
+			"the result of each channel lookup to so an "+
+			"interrupted run can be resumed with --resume; an "+
+			"existing file is never overwritten",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Resume, "resume", false, "resume a previous run by only "+
+			"looking up channels that are not yet in the file "+
+			"given with --cachefile",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Output, "output", "", "file to write the summary to; "+
+			"if empty, summary-<timestamp>.<format> in the "+
+			"working directory is used",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.Stdout, "stdout", false, "write the summary to stdout "+
+			"instead of a file; all log output is written to "+
+			"stderr",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.ForceCloseOnly, "forcecloseonly", false, "only include "+
+			"channels that were force closed in the summary",
+	)
+	cc.cmd.Flags().Uint64Var(
+		&cc.MinLocalBalance, "minlocalbalance", 0, "only include "+
+			"channels with at least this local balance in "+
+			"satoshis in the summary",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Peer, "peer", "", "only include channels with the peer "+
+			"identified by this node public key in the summary",
+	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
+	cc.chainAPI.registerNeutrinoFlags(cc.cmd)
 	cc.inputs = newInputFlags(cc.cmd)
 
+	cc.cmd.AddCommand(newSummaryDiffCommand())
+	cc.cmd.AddCommand(newSummaryCalendarCommand())
+
 	return cc.cmd
 }
 
 func (c *summaryCommand) Execute(_ *cobra.Command, _ []string) error {
+	switch c.Format {
+	case dataformat.ReportFormatJSON, dataformat.ReportFormatCSV,
+		dataformat.ReportFormatHTML:
+
+	default:
+		return fmt.Errorf("invalid format: %s", c.Format)
+	}
+
+	if c.Stdout && c.Output != "" {
+		return fmt.Errorf("cannot use --stdout and --output at the " +
+			"same time")
+	}
+	if c.Resume && c.CacheFile == "" {
+		return fmt.Errorf("--resume requires --cachefile")
+	}
+
 	// Parse channel entries from any of the possible input files.
 	entries, err := c.inputs.parseInputType()
 	if err != nil {
 		return err
 	}
-	return summarizeChannels(c.APIURL, entries)
+	api := c.chainAPI.api()
+	defer stopChainAPI(api)
+
+	var cache *btc.SummaryCache
+	if c.CacheFile != "" {
+		cache, err = btc.OpenSummaryCache(
+			lncfg.CleanAndExpandPath(c.CacheFile), c.Resume,
+		)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = cache.Close() }()
+
+		if c.Resume {
+			log.Infof("Resuming from cache file %s with %d "+
+				"channels already looked up", c.CacheFile,
+				cache.Len())
+		}
+	}
+
+	filter := &btc.SummaryFilter{
+		ForceCloseOnly:  c.ForceCloseOnly,
+		MinLocalBalance: c.MinLocalBalance,
+		Peer:            c.Peer,
+	}
+	summarize := func(out io.Writer) error {
+		return summarizeChannels(
+			api, entries, c.Workers, cache, filter, c.Format, out,
+		)
+	}
+
+	// Write the summary to stdout, the given file or the default file in
+	// the results directory.
+	if c.Stdout {
+		return summarize(resultOut)
+	}
+
+	fileName := lncfg.CleanAndExpandPath(c.Output)
+	if c.Output == "" {
+		fileName = resultFileName("summary", c.Format)
+	}
+	if err := writeResultFile(fileName, summarize); err != nil {
+		return err
+	}
+	log.Infof("Wrote result to %s", fileName)
+
+	return nil
 }
 
-func summarizeChannels(apiURL string,
-	channels []*dataformat.SummaryEntry) error {
+func summarizeChannels(api btc.ChainAPI,
+	channels []*dataformat.SummaryEntry, numWorkers int,
+	cache *btc.SummaryCache, filter *btc.SummaryFilter,
+	format string, out io.Writer) error {
 
-	summaryFile, err := btc.SummarizeChannels(apiURL, channels, log)
+	summaryFile, err := btc.SummarizeChannels(
+		api, channels, numWorkers, cache, filter, log,
+	)
 	if err != nil {
 		return fmt.Errorf("error running summary: %w", err)
 	}
@@ -79,13 +238,27 @@ func summarizeChannels(apiURL string,
 		summaryFile.FundsForceClose)
 	log.Infof(" --> closed channel sats that are in coop close outputs: %d",
 		summaryFile.FundsCoopClose)
+	log.Infof("Sats stuck in HTLC outputs: %d", summaryFile.FundsHTLC)
+	log.Infof(" --> HTLC output sats that are potentially ours: %d",
+		summaryFile.FundsHTLCOurs)
+
+	commitmentTypes := make(map[string]int)
+	for _, channel := range summaryFile.Channels {
+		if channel.ClosingTX == nil || !channel.ClosingTX.ForceClose {
+			continue
+		}
+		commitmentTypes[channel.CommitmentType]++
+	}
+	for commitmentType, num := range commitmentTypes {
+		log.Infof(" --> force closed %s channels: %d (sweep with %s)",
+			commitmentType, num,
+			dataformat.SweepCommandForCommitmentType(commitmentType))
+	}
 
-	summaryBytes, err := json.MarshalIndent(summaryFile, "", " ")
+	err = dataformat.WriteReport(out, summaryFile, format)
 	if err != nil {
-		return err
+		return fmt.Errorf("error writing report: %w", err)
 	}
-	fileName := fmt.Sprintf("results/summary-%s.json",
-		time.Now().Format("2006-01-02-15-04-05"))
-	log.Infof("Writing result to %s", fileName)
-	return ioutil.WriteFile(fileName, summaryBytes, 0644)
+
+	return nil
 }
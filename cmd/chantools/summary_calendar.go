@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/guggero/chantools/btc"
+	"github.com/spf13/cobra"
+)
+
+type summaryCalendarCommand struct {
+	chainAPI *chainAPIFlags
+	cmd      *cobra.Command
+}
+
+func newSummaryCalendarCommand() *cobra.Command {
+	cc := &summaryCalendarCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "calendar summary.json",
+		Short: "Show when the time locked outputs of closed channels " +
+			"can be swept",
+		Long: `Reads a JSON summary file created by the summary command
+and lists all unspent time locked outputs of closed channels, sorted by the
+block height at which they can be swept. This helps to plan the sweeps in
+batches.
+
+The following locks are listed:
+  csv:  our to_local output of our own force close (locked for the CSV delay
+        of the channel) and the to_remote output of anchor channels (locked
+        for one block)
+  cltv: our outgoing HTLC outputs that can be timed out after their CLTV
+        expiry height; the expiry is only known if the summary was created
+        from a channel DB or lncli listchannels
+
+The dates are estimated from the current block height, assuming one block every
+10 minutes.`,
+		Example: `chantools summary calendar \
+	results/summary-xxxx-xx-xx.json`,
+		Args: cobra.ExactArgs(1),
+		RunE: cc.Execute,
+	}
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
+
+	return cc.cmd
+}
+
+func (c *summaryCalendarCommand) Execute(_ *cobra.Command,
+	args []string) error {
+
+	summaryFile, err := readSummaryFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	height, calendar, err := btc.MaturityCalendar(
+		c.chainAPI.api(), summaryFile.Channels, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Found %d time locked outputs at block height %d",
+		len(calendar), height)
+	if JSONOutput {
+		return printJSON(calendar)
+	}
+	if len(calendar) == 0 {
+		return nil
+	}
+
+	var (
+		buf bytes.Buffer
+		w   = tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	)
+	_, _ = fmt.Fprintln(w, "Height\tEstimated date\tBlocks left\tLock\t"+
+		"Value\tOutpoint\tChannel point")
+	for _, entry := range calendar {
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%d\t%s\t%s\n",
+			entry.Height, entry.Date, entry.BlocksLeft, entry.Lock,
+			entry.Value, entry.Outpoint, entry.ChannelPoint)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	result := buf.String()
+	fmt.Fprintln(resultOut, result)
+
+	// For the tests, also log as trace level which is disabled by default.
+	log.Tracef(result)
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/guggero/chantools/dataformat"
+	"github.com/spf13/cobra"
+)
+
+type summaryDiffCommand struct {
+	cmd *cobra.Command
+}
+
+func newSummaryDiffCommand() *cobra.Command {
+	cc := &summaryDiffCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "diff old_summary.json new_summary.json",
+		Short: "Show which channels changed their state between two " +
+			"summary runs",
+		Long: `Compares two JSON summary files created by the summary
+command and lists all channels that changed their state in the meantime. This
+is useful for tracking the progress of a long-running recovery.
+
+The following changes are reported:
+  new:             the channel is only in the new summary
+  removed:         the channel is only in the old summary
+  found_onchain:   the funding transaction was found on chain
+  newly_closed:    the channel was closed
+  newly_swept:     all outputs of the closing transaction were spent
+  newly_spendable: the channel potentially has funds that can be swept`,
+		Example: `chantools summary diff \
+	results/summary-2023-01-01-00-00-00.json \
+	results/summary-2023-02-01-00-00-00.json`,
+		Args: cobra.ExactArgs(2),
+		RunE: cc.Execute,
+	}
+
+	return cc.cmd
+}
+
+func (c *summaryDiffCommand) Execute(_ *cobra.Command, args []string) error {
+	oldFile, err := readSummaryFile(args[0])
+	if err != nil {
+		return err
+	}
+	newFile, err := readSummaryFile(args[1])
+	if err != nil {
+		return err
+	}
+
+	diff := dataformat.DiffSummaries(oldFile, newFile)
+	for _, change := range diff.Changes {
+		log.Infof("Channel %s: %s (%s -> %s)", change.ChannelPoint,
+			strings.Join(change.Changes, ", "), change.OldState,
+			change.NewState)
+	}
+
+	log.Infof("Channels with changed state: %d", len(diff.Changes))
+	for _, change := range []string{
+		dataformat.SummaryChangeNew, dataformat.SummaryChangeRemoved,
+		dataformat.SummaryChangeFound, dataformat.SummaryChangeClosed,
+		dataformat.SummaryChangeSwept,
+		dataformat.SummaryChangeSpendable,
+	} {
+		log.Infof(" --> %s: %d", change, diff.Count(change))
+	}
+
+	diffBytes, err := json.MarshalIndent(diff, "", " ")
+	if err != nil {
+		return err
+	}
+	fileName := resultFileName("summary-diff", "json")
+	log.Infof("Writing result to %s", fileName)
+	return ioutil.WriteFile(fileName, diffBytes, 0644)
+}
+
+// readSummaryFile reads and decodes a JSON summary file.
+func readSummaryFile(fileName string) (*dataformat.SummaryEntryFile, error) {
+	content, err := readInput(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading summary file %s: %w",
+			fileName, err)
+	}
+
+	summaryFile := &dataformat.SummaryEntryFile{}
+	if err := json.Unmarshal(content, summaryFile); err != nil {
+		return nil, fmt.Errorf("error decoding summary file %s: %w",
+			fileName, err)
+	}
+
+	return summaryFile, nil
+}
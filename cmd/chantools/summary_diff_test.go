@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/guggero/chantools/dataformat"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryDiff(t *testing.T) {
+	h := newHarness(t)
+
+	defer func() {
+		ResultFile = ""
+	}()
+
+	writeSummary := func(name string,
+		channels ...*dataformat.SummaryEntry) string {
+
+		content, err := json.Marshal(&dataformat.SummaryEntryFile{
+			Channels: channels,
+		})
+		require.NoError(t, err)
+
+		fileName := h.tempFile(name)
+		require.NoError(t, ioutil.WriteFile(fileName, content, 0644))
+		return fileName
+	}
+
+	oldFile := writeSummary(
+		"old.json",
+		&dataformat.SummaryEntry{ChannelPoint: "unchanged:0"},
+		&dataformat.SummaryEntry{ChannelPoint: "found:0"},
+		&dataformat.SummaryEntry{
+			ChannelPoint: "closed:0",
+			ChanExists:   true,
+		},
+		&dataformat.SummaryEntry{
+			ChannelPoint: "spendable:0",
+			ChanExists:   true,
+			ClosingTX:    &dataformat.ClosingTX{ForceClose: true},
+		},
+		&dataformat.SummaryEntry{ChannelPoint: "removed:0"},
+	)
+	newFile := writeSummary(
+		"new.json",
+		&dataformat.SummaryEntry{ChannelPoint: "unchanged:0"},
+		&dataformat.SummaryEntry{
+			ChannelPoint: "found:0",
+			ChanExists:   true,
+		},
+		&dataformat.SummaryEntry{
+			ChannelPoint: "closed:0",
+			ChanExists:   true,
+			ClosingTX: &dataformat.ClosingTX{
+				AllOutsSpent: true,
+			},
+		},
+		&dataformat.SummaryEntry{
+			ChannelPoint: "spendable:0",
+			ChanExists:   true,
+			ClosingTX:    &dataformat.ClosingTX{ForceClose: true},
+			HasPotential: true,
+		},
+		&dataformat.SummaryEntry{
+			ChannelPoint: "new:0",
+			ChanExists:   true,
+		},
+	)
+
+	ResultFile = h.tempFile("diff.json")
+	diff := &summaryDiffCommand{}
+	err := diff.Execute(nil, []string{oldFile, newFile})
+	require.NoError(t, err)
+
+	h.assertLogContains("Channel found:0: found_onchain (not found -> open)")
+	h.assertLogContains(
+		"Channel closed:0: newly_closed, newly_swept (open -> coop " +
+			"closed)",
+	)
+	h.assertLogContains(
+		"Channel spendable:0: newly_spendable (force closed -> force " +
+			"closed)",
+	)
+	h.assertLogContains("Channel new:0: new ( -> open)")
+	h.assertLogContains("Channel removed:0: removed (not found -> )")
+	h.assertLogContains("Channels with changed state: 5")
+	h.assertLogContains(" --> newly_spendable: 1")
+	require.NotContains(t, h.getLog(), "unchanged:0")
+
+	content, err := ioutil.ReadFile(ResultFile)
+	require.NoError(t, err)
+	result := &dataformat.SummaryDiff{}
+	require.NoError(t, json.Unmarshal(content, result))
+	require.Equal(t, []*dataformat.SummaryChange{{
+		ChannelPoint: "found:0",
+		OldState:     "not found",
+		NewState:     "open",
+		Changes:      []string{dataformat.SummaryChangeFound},
+	}, {
+		ChannelPoint: "closed:0",
+		OldState:     "open",
+		NewState:     "coop closed",
+		Changes: []string{
+			dataformat.SummaryChangeClosed,
+			dataformat.SummaryChangeSwept,
+		},
+	}, {
+		ChannelPoint: "spendable:0",
+		OldState:     "force closed",
+		NewState:     "force closed",
+		Changes: []string{
+			dataformat.SummaryChangeSpendable,
+		},
+	}, {
+		ChannelPoint: "new:0",
+		NewState:     "open",
+		Changes: []string{
+			dataformat.SummaryChangeNew,
+		},
+	}, {
+		ChannelPoint: "removed:0",
+		OldState:     "not found",
+		Changes: []string{
+			dataformat.SummaryChangeRemoved,
+		},
+	}}, result.Changes)
+
+	// Invalid summary files are rejected.
+	err = diff.Execute(nil, []string{oldFile, h.tempFile("missing.json")})
+	require.ErrorContains(t, err, "error reading summary file")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/stretchr/testify/require"
+)
+
+// newSummaryTestChannels returns a chain API and channels in all states a
+// summary can report: open, coop closed, force closed and not found.
+func newSummaryTestChannels() (*mockChainAPI, []*dataformat.SummaryEntry) {
+	unspent := func(value uint64) *btc.Vout {
+		return &btc.Vout{Value: value, Outspend: &btc.Outspend{}}
+	}
+	spentBy := func(txid string) *btc.Vout {
+		return &btc.Vout{Outspend: &btc.Outspend{
+			Spent:  true,
+			Txid:   txid,
+			Status: &btc.Status{Confirmed: true, BlockHeight: 123},
+		}}
+	}
+
+	api := &mockChainAPI{txs: map[string]*btc.TX{
+		"f1": {Vout: []*btc.Vout{unspent(100_000)}},
+		"f2": {Vout: []*btc.Vout{spentBy("s2")}},
+		"s2": {
+			Vin:  []*btc.Vin{{Sequence: 0xffffffff}},
+			Vout: []*btc.Vout{unspent(60_000), spentBy("x")},
+		},
+		"f3": {Vout: []*btc.Vout{spentBy("s3")}},
+		"s3": {
+			Vin:  []*btc.Vin{{Sequence: 0x80000000}},
+			Vout: []*btc.Vout{spentBy("y"), spentBy("z")},
+		},
+	}}
+
+	var channels []*dataformat.SummaryEntry
+	for idx, peer := range []string{"<b>peer</b>", "02aa", "03bb", "02aa"} {
+		fundingTxid := []string{"f1", "f2", "f3", "f4"}[idx]
+		channels = append(channels, &dataformat.SummaryEntry{
+			RemotePubkey:  peer,
+			ChannelPoint:  fundingTxid + ":0",
+			FundingTXID:   fundingTxid,
+			Capacity:      100_000,
+			LocalBalance:  uint64(idx+1) * 20_000,
+			RemoteBalance: 10_000,
+		})
+	}
+
+	return api, channels
+}
+
+func TestSummaryReportCSV(t *testing.T) {
+	_ = newHarness(t)
+
+	api, channels := newSummaryTestChannels()
+	var buf bytes.Buffer
+	err := summarizeChannels(
+		api, channels, 2, nil, nil, dataformat.ReportFormatCSV, &buf,
+	)
+	require.NoError(t, err)
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+
+	// One header row, one row per channel and one totals row.
+	require.Len(t, records, 6)
+	column := make(map[string]int)
+	for idx, name := range records[0] {
+		column[name] = idx
+	}
+	value := func(row int, name string) string {
+		idx, ok := column[name]
+		require.True(t, ok, name)
+		return records[row][idx]
+	}
+
+	// The channels are in the order of the input.
+	for row, chanPoint := range []string{"f1:0", "f2:0", "f3:0", "f4:0"} {
+		require.Equal(t, chanPoint, value(row+1, "channel_point"))
+	}
+
+	// Open channel.
+	require.Equal(t, "<b>peer</b>", value(1, "remote_pubkey"))
+	require.Equal(t, "true", value(1, "chan_exists_onchain"))
+	require.Equal(t, "false", value(1, "closed"))
+	require.Equal(t, "", value(1, "closing_txid"))
+
+	// Coop closed channel with an unspent output.
+	require.Equal(t, "true", value(2, "closed"))
+	require.Equal(t, "false", value(2, "force_close"))
+	require.Equal(t, "false", value(2, "all_outputs_spent"))
+	require.Equal(t, "true", value(2, "has_potential_funds"))
+	require.Equal(t, "s2", value(2, "closing_txid"))
+	require.Equal(t, "123", value(2, "closing_conf_height"))
+
+	// Force closed channel that was swept already.
+	require.Equal(t, "true", value(3, "force_close"))
+	require.Equal(t, "true", value(3, "all_outputs_spent"))
+	require.Equal(t, "false", value(3, "has_potential_funds"))
+	require.Equal(t, "s3", value(3, "closing_txid"))
+
+	// Channel that wasn't found on chain.
+	require.Equal(t, "false", value(4, "chan_exists_onchain"))
+	require.Equal(t, "false", value(4, "closed"))
+
+	// The last row contains the totals.
+	require.Equal(t, "total", value(5, "channel_point"))
+	require.Equal(t, "400000", value(5, "capacity"))
+	require.Equal(t, "200000", value(5, "local_balance"))
+	require.Equal(t, "40000", value(5, "remote_balance"))
+}
+
+func TestSummaryReportHTML(t *testing.T) {
+	_ = newHarness(t)
+
+	api, channels := newSummaryTestChannels()
+	var buf bytes.Buffer
+	err := summarizeChannels(
+		api, channels, 2, nil, nil, dataformat.ReportFormatHTML, &buf,
+	)
+	require.NoError(t, err)
+
+	html := buf.String()
+	require.Contains(
+		t, html, "<tr><th>Open channels</th>"+
+			"<td class=\"num\">1</td></tr>",
+	)
+	require.Contains(
+		t, html, "<tr><th>Force closed channels</th>"+
+			"<td class=\"num\">1</td></tr>",
+	)
+	require.Contains(
+		t, html, "<tr><th>Coop closed channels</th>"+
+			"<td class=\"num\">1</td></tr>",
+	)
+	for _, state := range []string{
+		"open", "coop closed", "force closed", "not found",
+	} {
+		require.Contains(t, html, "<td>"+state+"</td>")
+	}
+	require.Contains(t, html, "<td class=\"mono\">s2</td>")
+
+	// Values from the input must be escaped.
+	require.Contains(t, html, "&lt;b&gt;peer&lt;/b&gt;")
+	require.NotContains(t, html, "<b>peer</b>")
+
+	// Unknown formats are rejected.
+	err = summarizeChannels(api, channels, 2, nil, nil, "xml", &buf)
+	require.ErrorContains(t, err, "invalid report format")
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/sweep"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+	"github.com/spf13/cobra"
+)
+
+type sweepBreachCommand struct {
+	ChannelDB string
+	BreachTx  string
+	Publish   bool
+	SweepAddr string
+	FeeRate   uint16
+
+	chainAPI     *chainAPIFlags
+	rootKey      *rootKey
+	remoteSigner *remoteSigner
+	hwSigner     *hwSigner
+	cmd          *cobra.Command
+}
+
+func newSweepBreachCommand() *cobra.Command {
+	cc := &sweepBreachCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "sweepbreach",
+		Short: "Create the justice transaction that sweeps all " +
+			"outputs of a revoked commitment published by the " +
+			"remote peer",
+		Long: `If the remote peer published an old, revoked commitment
+transaction, all its outputs can be claimed by us. This command reads the
+revocation log of the channel from the channel.db file, derives the revocation
+keys and creates a justice transaction that sweeps our balance, the peer's
+balance and all HTLC outputs of the breached commitment to the given address.
+If the peer already published the HTLC-success or HTLC-timeout transaction of
+an HTLC, the output of that second-level transaction is swept instead.
+
+The justice transaction must be published before the CSV delay of the peer's
+to_local output expires, otherwise the peer can sweep their balance themselves.
+
+If the seed isn't available on this machine because the node uses a remote
+signer, the justice transaction can be signed by the remote signer lnd node
+through its gRPC interface with --remotesigner.
+
+Channels with a script enforced lease are not supported.`,
+		Example: `chantools sweepbreach \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--breachtx 02000000000101... \
+	--sweepaddr bc1q..... \
+	--feerate 50 \
+	--publish`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to read "+
+			"the revocation log from",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.BreachTx, "breachtx", "", "the hex encoded raw revoked "+
+			"commitment transaction that was published by the "+
+			"remote peer",
+	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
+	cc.cmd.Flags().BoolVar(
+		&cc.Publish, "publish", false, "publish sweep TX to the chain "+
+			"API instead of just printing the TX",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SweepAddr, "sweepaddr", "", "address to sweep the funds to",
+	)
+	cc.cmd.Flags().Uint16Var(
+		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
+			"use for the sweep transaction in sat/vByte",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "deriving the revocation keys")
+	cc.remoteSigner = newRemoteSigner(cc.cmd)
+	cc.hwSigner = newHWSigner(cc.cmd)
+
+	return cc.cmd
+}
+
+func (c *sweepBreachCommand) Execute(_ *cobra.Command, _ []string) error {
+	signer, closeSigner, err := c.remoteSigner.walletSigner(
+		c.rootKey, c.hwSigner,
+	)
+	if err != nil {
+		return err
+	}
+	defer closeSigner()
+
+	if !channelDBGiven(c.ChannelDB) {
+		return fmt.Errorf("channel DB is required")
+	}
+	if c.SweepAddr == "" {
+		return fmt.Errorf("sweep addr is required")
+	}
+
+	breachTxBytes, err := hex.DecodeString(c.BreachTx)
+	if err != nil {
+		return fmt.Errorf("error decoding breach TX: %w", err)
+	}
+	breachTx := &wire.MsgTx{}
+	err = breachTx.Deserialize(bytes.NewReader(breachTxBytes))
+	if err != nil {
+		return fmt.Errorf("error parsing breach TX: %w", err)
+	}
+
+	db, err := openChannelDB(c.ChannelDB, true)
+	if err != nil {
+		return fmt.Errorf("error opening channel DB: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Errorf("Error closing DB: %v", err)
+		}
+	}()
+
+	api := c.chainAPI.api()
+	c.FeeRate, err = sweepFeeRate(c.cmd, api, c.FeeRate)
+	if err != nil {
+		return err
+	}
+
+	return sweepBreach(
+		signer, api, db.ChannelStateDB(), breachTx,
+		c.SweepAddr, c.Publish, c.FeeRate, c.hwSigner,
+	)
+}
+
+// breachedChannel finds the channel that is spent by the given commitment
+// transaction.
+func breachedChannel(chanDb *channeldb.ChannelStateDB,
+	breachTx *wire.MsgTx) (*channeldb.OpenChannel, error) {
+
+	if len(breachTx.TxIn) != 1 {
+		return nil, fmt.Errorf("breach TX must have exactly one input")
+	}
+
+	channels, err := chanDb.FetchAllChannels()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching channels: %w", err)
+	}
+
+	fundingOutpoint := breachTx.TxIn[0].PreviousOutPoint
+	for _, channel := range channels {
+		if channel.FundingOutpoint == fundingOutpoint {
+			return channel, nil
+		}
+	}
+
+	return nil, fmt.Errorf("channel with funding outpoint %v not found",
+		fundingOutpoint)
+}
+
+// breachStateNum extracts the commitment height that is encoded in the lock
+// time and sequence of the breach transaction.
+func breachStateNum(channel *channeldb.OpenChannel,
+	breachTx *wire.MsgTx) uint64 {
+
+	localKey := channel.LocalChanCfg.PaymentBasePoint.PubKey
+	remoteKey := channel.RemoteChanCfg.PaymentBasePoint.PubKey
+
+	// The obfuscator is always derived with the payment base point of the
+	// channel initiator first.
+	obfuscator := lnwallet.DeriveStateHintObfuscator(remoteKey, localKey)
+	if channel.IsInitiator {
+		obfuscator = lnwallet.DeriveStateHintObfuscator(
+			localKey, remoteKey,
+		)
+	}
+
+	return lnwallet.GetStateNumHint(breachTx, obfuscator)
+}
+
+// breachedOutput is an output of the breach transaction or of one of the
+// remote peer's second-level HTLC transactions that we can sweep.
+type breachedOutput struct {
+	outpoint    wire.OutPoint
+	sequence    uint32
+	witnessType input.StandardWitnessType
+	signDesc    *input.SignDescriptor
+}
+
+// htlcBreachOutputs returns the outputs to sweep for the HTLCs of the breach
+// transaction. If the remote peer already published the HTLC-success or
+// HTLC-timeout transaction of an HTLC, its output is swept with the revocation
+// key instead, the same way lnd's breach arbitrator does it.
+func htlcBreachOutputs(api btc.ChainAPI, breachTxHash chainhash.Hash,
+	htlcs []lnwallet.HtlcRetribution) ([]*breachedOutput, error) {
+
+	if len(htlcs) == 0 {
+		return nil, nil
+	}
+
+	// If the breach transaction isn't known to the chain backend yet,
+	// none of its outputs can be spent.
+	breachTx, err := api.Transaction(breachTxHash.String())
+	switch {
+	case errors.Is(err, btc.ErrTxNotFound):
+		breachTx = nil
+
+	case err != nil:
+		return nil, fmt.Errorf("error fetching breach TX %v: %w",
+			breachTxHash, err)
+	}
+
+	outputs := make([]*breachedOutput, 0, len(htlcs))
+	for idx := range htlcs {
+		htlc := &htlcs[idx]
+		witnessType := input.HtlcOfferedRevoke
+		if htlc.IsIncoming {
+			witnessType = input.HtlcAcceptedRevoke
+		}
+		output := &breachedOutput{
+			outpoint:    htlc.OutPoint,
+			witnessType: witnessType,
+			signDesc:    &htlc.SignDesc,
+		}
+
+		index := int(htlc.OutPoint.Index)
+		if breachTx == nil || index >= len(breachTx.Vout) ||
+			breachTx.Vout[index].Outspend == nil ||
+			!breachTx.Vout[index].Outspend.Spent {
+
+			outputs = append(outputs, output)
+			continue
+		}
+
+		secondLevel, err := secondLevelRevokeOutput(
+			api, htlc, breachTx.Vout[index].Outspend,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if secondLevel != nil {
+			outputs = append(outputs, secondLevel)
+		}
+	}
+
+	return outputs, nil
+}
+
+// secondLevelRevokeOutput returns the output of the remote peer's second-level
+// transaction that spent the given HTLC of the breach transaction. The
+// second-level output has the same index as the HTLC input. If the HTLC output
+// was spent by any other transaction or the second-level output was already
+// spent, nil is returned.
+func secondLevelRevokeOutput(api btc.ChainAPI, htlc *lnwallet.HtlcRetribution,
+	outspend *btc.Outspend) (*breachedOutput, error) {
+
+	spendTx, err := api.Transaction(outspend.Txid)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching spending TX %s of HTLC "+
+			"output %v: %w", outspend.Txid, htlc.OutPoint, err)
+	}
+
+	pkScript, err := input.WitnessScriptHash(htlc.SecondLevelWitnessScript)
+	if err != nil {
+		return nil, fmt.Errorf("error creating second-level script: "+
+			"%w", err)
+	}
+	if outspend.Vin >= len(spendTx.Vout) ||
+		spendTx.Vout[outspend.Vin].ScriptPubkey !=
+			hex.EncodeToString(pkScript) {
+
+		log.Infof("HTLC output %v was already spent by %s, skipping",
+			htlc.OutPoint, outspend.Txid)
+		return nil, nil
+	}
+
+	vout := spendTx.Vout[outspend.Vin]
+	if vout.Outspend != nil && vout.Outspend.Spent {
+		log.Infof("Second-level output %s:%d of HTLC output %v was "+
+			"already spent by %s, skipping", outspend.Txid,
+			outspend.Vin, htlc.OutPoint, vout.Outspend.Txid)
+		return nil, nil
+	}
+
+	hash, err := chainhash.NewHashFromStr(outspend.Txid)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing TXID %s: %w",
+			outspend.Txid, err)
+	}
+
+	// The revocation key of the second-level output is the same as the
+	// one of the HTLC output, only the script is different.
+	signDesc := htlc.SignDesc
+	signDesc.WitnessScript = htlc.SecondLevelWitnessScript
+	signDesc.Output = &wire.TxOut{
+		Value:    int64(vout.Value),
+		PkScript: pkScript,
+	}
+
+	return &breachedOutput{
+		outpoint: wire.OutPoint{
+			Hash:  *hash,
+			Index: uint32(outspend.Vin),
+		},
+		witnessType: input.HtlcSecondLevelRevoke,
+		signDesc:    &signDesc,
+	}, nil
+}
+
+func sweepBreach(signer walletSigner, api btc.SweepAPI,
+	chanDb *channeldb.ChannelStateDB, breachTx *wire.MsgTx,
+	sweepAddr string, publish bool, feeRate uint16, hw *hwSigner) error {
+
+	channel, err := breachedChannel(chanDb, breachTx)
+	if err != nil {
+		return err
+	}
+	if channel.ChanType.HasLeaseExpiration() {
+		return fmt.Errorf("channels with script enforced lease are " +
+			"not supported")
+	}
+
+	stateNum := breachStateNum(channel, breachTx)
+	remoteHeight := channel.RemoteCommitment.CommitHeight
+	if stateNum >= remoteHeight {
+		return fmt.Errorf("commitment at height %d of channel %v is "+
+			"not revoked, current remote height is %d", stateNum,
+			channel.FundingOutpoint, remoteHeight)
+	}
+	log.Infof("Found revoked commitment at height %d of channel %v",
+		stateNum, channel.FundingOutpoint)
+
+	retribution, err := lnwallet.NewBreachRetribution(
+		channel, stateNum, 0, breachTx,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating breach retribution: %w", err)
+	}
+
+	// Collect all outputs of the breach transaction we can sweep, the same
+	// way lnd's breach arbitrator does it.
+	var outputs []*breachedOutput
+	if retribution.LocalOutputSignDesc != nil {
+		witnessType := input.CommitmentNoDelay
+		if retribution.LocalOutputSignDesc.SingleTweak == nil {
+			witnessType = input.CommitSpendNoDelayTweakless
+		}
+
+		// The to_remote output of anchor channels is locked for one
+		// block.
+		if retribution.LocalDelay != 0 {
+			witnessType = input.CommitmentToRemoteConfirmed
+		}
+
+		outputs = append(outputs, &breachedOutput{
+			outpoint:    retribution.LocalOutpoint,
+			sequence:    retribution.LocalDelay,
+			witnessType: witnessType,
+			signDesc:    retribution.LocalOutputSignDesc,
+		})
+	}
+	if retribution.RemoteOutputSignDesc != nil {
+		outputs = append(outputs, &breachedOutput{
+			outpoint:    retribution.RemoteOutpoint,
+			witnessType: input.CommitmentRevoke,
+			signDesc:    retribution.RemoteOutputSignDesc,
+		})
+	}
+	htlcOutputs, err := htlcBreachOutputs(
+		api, breachTx.TxHash(), retribution.HtlcRetributions,
+	)
+	if err != nil {
+		return err
+	}
+	outputs = append(outputs, htlcOutputs...)
+
+	sweepTx := wire.NewMsgTx(2)
+	totalOutputValue := int64(0)
+	inputs := make([]*txResultInput, 0, len(outputs))
+	var estimator input.TxWeightEstimator
+	for _, output := range outputs {
+		witnessSize, _, err := output.witnessType.SizeUpperBound()
+		if err != nil {
+			return err
+		}
+
+		sweepTx.TxIn = append(sweepTx.TxIn, &wire.TxIn{
+			PreviousOutPoint: output.outpoint,
+			Sequence:         output.sequence,
+		})
+		totalOutputValue += output.signDesc.Output.Value
+		estimator.AddWitnessInput(witnessSize)
+		inputs = append(inputs, &txResultInput{
+			Outpoint:     output.outpoint.String(),
+			Value:        output.signDesc.Output.Value,
+			ChannelPoint: channel.FundingOutpoint.String(),
+		})
+
+		log.Infof("Sweeping %v output %v with %d sats",
+			output.witnessType, output.outpoint,
+			output.signDesc.Output.Value)
+	}
+
+	if len(outputs) == 0 || totalOutputValue < sweep.DustLimit {
+		return fmt.Errorf("found %d sweep targets with total value "+
+			"of %d satoshis which is below the dust limit of %d",
+			len(outputs), totalOutputValue, sweep.DustLimit)
+	}
+
+	// Add our sweep destination output.
+	sweepScript, err := lnd.GetP2WPKHScript(sweepAddr, chainParams)
+	if err != nil {
+		return err
+	}
+	estimator.AddP2WKHOutput()
+
+	// Calculate the fee based on the given fee rate and our weight
+	// estimation.
+	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
+	totalFee := feeRateKWeight.FeeForWeight(int64(estimator.Weight()))
+
+	log.Infof("Fee %d sats of %d total amount (estimated weight %d)",
+		totalFee, totalOutputValue, estimator.Weight())
+
+	sweepTx.TxOut = []*wire.TxOut{{
+		Value:    totalOutputValue - int64(totalFee),
+		PkScript: sweepScript,
+	}}
+
+	// Sign the transaction now.
+	signer, err = hw.wrap(signer)
+	if err != nil {
+		return err
+	}
+	sigHashes := input.NewTxSigHashesV0Only(sweepTx)
+	for idx, output := range outputs {
+		// The fetcher of the sign descriptor only knows the outputs of
+		// the breach transaction.
+		output.signDesc.PrevOutputFetcher = nil
+
+		witnessFunc := output.witnessType.WitnessGenerator(
+			signer, output.signDesc,
+		)
+		script, err := witnessFunc(sweepTx, sigHashes, idx)
+		if err != nil {
+			return fmt.Errorf("error signing input %d: %w", idx,
+				err)
+		}
+		sweepTx.TxIn[idx].Witness = script.Witness
+	}
+
+	if hw.exporting() {
+		return hw.export(sweepTx)
+	}
+
+	results := newTxResults()
+	err = results.addTx(api, sweepTx, inputs, publish)
+	if err != nil {
+		return err
+	}
+
+	return results.print()
+}
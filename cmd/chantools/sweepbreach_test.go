@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreachStateNum(t *testing.T) {
+	localKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	remoteKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	channel := &channeldb.OpenChannel{}
+	channel.LocalChanCfg.PaymentBasePoint.PubKey = localKey.PubKey()
+	channel.RemoteChanCfg.PaymentBasePoint.PubKey = remoteKey.PubKey()
+
+	for _, initiator := range []bool{true, false} {
+		channel.IsInitiator = initiator
+
+		// The remote peer's commitment uses the same obfuscator, which
+		// is always derived with the initiator's key first.
+		obfuscator := lnwallet.DeriveStateHintObfuscator(
+			remoteKey.PubKey(), localKey.PubKey(),
+		)
+		if initiator {
+			obfuscator = lnwallet.DeriveStateHintObfuscator(
+				localKey.PubKey(), remoteKey.PubKey(),
+			)
+		}
+
+		breachTx := wire.NewMsgTx(2)
+		breachTx.AddTxIn(&wire.TxIn{})
+		err := lnwallet.SetStateNumHint(breachTx, 1234, obfuscator)
+		require.NoError(t, err)
+
+		require.EqualValues(t, 1234, breachStateNum(channel, breachTx))
+	}
+}
+
+func TestHTLCBreachOutputs(t *testing.T) {
+	h := newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	// The revocation key is derived from our revocation base point and
+	// the commitment secret the peer revealed.
+	revocationBase, err := signer.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyRevocationBase,
+	})
+	require.NoError(t, err)
+	commitSecret, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	revocationKey := input.DeriveRevocationPubkey(
+		revocationBase.PubKey, commitSecret.PubKey(),
+	)
+	delayKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	secondLevelScript, err := input.SecondLevelHtlcScript(
+		revocationKey, delayKey.PubKey(), 144,
+	)
+	require.NoError(t, err)
+	secondLevelPkScript, err := input.WitnessScriptHash(secondLevelScript)
+	require.NoError(t, err)
+
+	breachTxHash := chainhash.Hash{1}
+	htlc := func(index uint32) lnwallet.HtlcRetribution {
+		return lnwallet.HtlcRetribution{
+			SignDesc: input.SignDescriptor{
+				KeyDesc:     *revocationBase,
+				DoubleTweak: commitSecret,
+				Output:      &wire.TxOut{Value: 10_000},
+				HashType:    txscript.SigHashAll,
+			},
+			OutPoint: wire.OutPoint{
+				Hash:  breachTxHash,
+				Index: index,
+			},
+			SecondLevelWitnessScript: secondLevelScript,
+		}
+	}
+	spentTo := func(txid string, vin int) *btc.Vout {
+		return &btc.Vout{Outspend: &btc.Outspend{
+			Spent: txid != "",
+			Txid:  txid,
+			Vin:   vin,
+		}}
+	}
+	txid := func(b byte) string {
+		return chainhash.Hash{b}.String()
+	}
+	secondLevelOut := spentTo("", 0)
+	secondLevelOut.ScriptPubkey = hex.EncodeToString(secondLevelPkScript)
+	secondLevelOut.Value = 9_000
+	sweptSecondLevelOut := spentTo(txid(5), 0)
+	sweptSecondLevelOut.ScriptPubkey = secondLevelOut.ScriptPubkey
+	api := &mockChainAPI{txs: map[string]*btc.TX{
+		breachTxHash.String(): {Vout: []*btc.Vout{
+			spentTo("", 0), spentTo(txid(2), 1), spentTo(txid(3), 0),
+			spentTo(txid(4), 0),
+		}},
+		txid(2): {Vout: []*btc.Vout{{}, secondLevelOut}},
+		txid(3): {Vout: []*btc.Vout{{ScriptPubkey: "0014aa"}}},
+		txid(4): {Vout: []*btc.Vout{sweptSecondLevelOut}},
+	}}
+	htlcs := []lnwallet.HtlcRetribution{htlc(0), htlc(1), htlc(2), htlc(3)}
+	htlcs[1].IsIncoming = true
+
+	// The unspent HTLC output is swept directly and the output of the
+	// peer's second-level TX with the revocation key. HTLC outputs that
+	// were spent otherwise and swept second-level outputs are skipped.
+	outputs, err := htlcBreachOutputs(api, breachTxHash, htlcs)
+	require.NoError(t, err)
+	require.Len(t, outputs, 2)
+	require.Equal(t, htlcs[0].OutPoint, outputs[0].outpoint)
+	require.Equal(t, input.HtlcOfferedRevoke, outputs[0].witnessType)
+
+	secondLevel := outputs[1]
+	require.Equal(t, wire.OutPoint{Hash: chainhash.Hash{2}, Index: 1},
+		secondLevel.outpoint)
+	require.Equal(t, input.HtlcSecondLevelRevoke, secondLevel.witnessType)
+	require.Equal(t, secondLevelScript, secondLevel.signDesc.WitnessScript)
+	require.Equal(t, &wire.TxOut{
+		Value:    9_000,
+		PkScript: secondLevelPkScript,
+	}, secondLevel.signDesc.Output)
+	h.assertLogContains("already spent by " + txid(3))
+	h.assertLogContains("already spent by " + txid(5))
+
+	// The second-level output can be spent with the revocation key.
+	sweepTx := wire.NewMsgTx(2)
+	sweepTx.AddTxIn(&wire.TxIn{PreviousOutPoint: secondLevel.outpoint})
+	sweepTx.AddTxOut(&wire.TxOut{Value: 8_000, PkScript: []byte{0x51}})
+	sigHashes := input.NewTxSigHashesV0Only(sweepTx)
+	script, err := secondLevel.witnessType.WitnessGenerator(
+		signer, secondLevel.signDesc,
+	)(sweepTx, sigHashes, 0)
+	require.NoError(t, err)
+	sweepTx.TxIn[0].Witness = script.Witness
+	vm, err := txscript.NewEngine(
+		secondLevelPkScript, sweepTx, 0, txscript.StandardVerifyFlags,
+		nil, nil, 9_000, txscript.NewCannedPrevOutputFetcher(
+			secondLevelPkScript, 9_000,
+		),
+	)
+	require.NoError(t, err)
+	require.NoError(t, vm.Execute())
+
+	// Without the breach TX on chain, all HTLC outputs are swept directly
+	// but other errors of the backend are returned.
+	outputs, err = htlcBreachOutputs(&mockChainAPI{}, breachTxHash, htlcs)
+	require.NoError(t, err)
+	require.Len(t, outputs, 4)
+
+	_, err = htlcBreachOutputs(
+		&errChainAPI{err: errors.New("connection refused")},
+		breachTxHash, htlcs,
+	)
+	require.ErrorContains(t, err, "connection refused")
+}
@@ -0,0 +1,762 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/sweep"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+	"github.com/spf13/cobra"
+)
+
+type sweepHTLCsCommand struct {
+	ChannelDB   string
+	ChanPoint   string
+	Preimages   string
+	SecondLevel bool
+	Publish     bool
+	SweepAddr   string
+	FeeRate     uint16
+	FeeUtxo     string
+	FeeUtxoPath string
+
+	chainAPI     *chainAPIFlags
+	rootKey      *rootKey
+	remoteSigner *remoteSigner
+	hwSigner     *hwSigner
+	cmd          *cobra.Command
+}
+
+func newSweepHTLCsCommand() *cobra.Command {
+	cc := &sweepHTLCsCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "sweephtlcs",
+		Short: "Claim the HTLC outputs of our own force-closed " +
+			"commitment transaction",
+		Long: `This command claims the HTLC outputs of a commitment
+transaction that was published with the forceclose command. This happens in
+two steps:
+
+1. Without the --secondlevel flag, the fully signed HTLC-timeout (outgoing
+   HTLCs) and HTLC-success (incoming HTLCs) transactions are created from the
+   data in the channel.db file. An HTLC-timeout transaction can only be
+   published after the HTLC's expiry height, with --publish the ones that
+   aren't mature yet are only printed. An HTLC-success transaction needs the
+   preimage of the HTLC, which is looked up in the channel.db file or can be
+   supplied with the --preimages flag.
+2. With the --secondlevel flag, the outputs of all confirmed HTLC-timeout and
+   HTLC-success transactions are swept to the given address. This is only
+   possible after the CSV delay of the channel has passed.
+
+The HTLC transactions of anchor channels don't pay any fees on their own. For
+those, a P2WKH UTXO of the wallet must be given with --feeutxo and
+--feeutxopath. It is added to each HTLC transaction to pay the fee given with
+--feerate and the change is sent back to the same address. The change output
+is used as the fee input of the next HTLC transaction, so all of them have to
+be published in the printed order. Because the HTLC-timeout transactions are
+chained that way, they are only created once their expiry height is reached.
+
+If the seed isn't available on this machine because the node uses a remote
+signer, the transactions can be signed by the remote signer lnd node through
+its gRPC interface with --remotesigner. The fee input of anchor channels is
+derived from the seed, so --feeutxo can't be used together with
+--remotesigner.`,
+		Example: `chantools sweephtlcs \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--preimages 0011223344...,5566778899... \
+	--publish
+
+chantools sweephtlcs \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--feeutxo fedcba09876...:0 \
+	--feeutxopath "m/84'/0'/0'/0/3" \
+	--feerate 10 \
+	--publish
+
+chantools sweephtlcs \
+	--channeldb ~/.lnd/data/graph/mainnet/channel.db \
+	--secondlevel \
+	--sweepaddr bc1q..... \
+	--feerate 10 \
+	--publish`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.ChannelDB, "channeldb", "", "lnd channel.db file to read "+
+			"the channels and their HTLCs from",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.ChanPoint, "chanpoint", "", "only claim the HTLCs of the "+
+			"channel with this channel point; leave empty to claim "+
+			"the HTLCs of all channels",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Preimages, "preimages", "", "comma separated list of hex "+
+			"encoded preimages of incoming HTLCs that are not "+
+			"known to the channel.db file",
+	)
+	cc.cmd.Flags().BoolVar(
+		&cc.SecondLevel, "secondlevel", false, "sweep the outputs of "+
+			"the confirmed HTLC-timeout and HTLC-success "+
+			"transactions instead of creating them",
+	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
+	cc.cmd.Flags().BoolVar(
+		&cc.Publish, "publish", false, "publish the TXs to the chain "+
+			"API instead of just printing them",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SweepAddr, "sweepaddr", "", "address to sweep the funds to "+
+			"when using --secondlevel",
+	)
+	cc.cmd.Flags().Uint16Var(
+		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
+			"use for the sweep transaction and the HTLC "+
+			"transactions of anchor channels in sat/vByte",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.FeeUtxo, "feeutxo", "", "outpoint (<txid>:<txindex>) of "+
+			"a P2WKH UTXO of the wallet that pays the fees of the "+
+			"HTLC transactions of anchor channels",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.FeeUtxoPath, "feeutxopath", "", "BIP32 derivation path "+
+			"of the key of the UTXO given with --feeutxo, for "+
+			"example m/84'/0'/0'/0/3",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "signing the transactions")
+	cc.remoteSigner = newRemoteSigner(cc.cmd)
+	cc.hwSigner = newHWSigner(cc.cmd)
+
+	return cc.cmd
+}
+
+func (c *sweepHTLCsCommand) Execute(_ *cobra.Command, _ []string) error {
+	if !channelDBGiven(c.ChannelDB) {
+		return fmt.Errorf("channel DB is required")
+	}
+	if c.SecondLevel && c.SweepAddr == "" {
+		return fmt.Errorf("sweep addr is required")
+	}
+	if !c.SecondLevel && (c.hwSigner.ExportPsbt ||
+		c.hwSigner.SignedPsbt != "") {
+
+		return fmt.Errorf("signing with a hardware wallet is only " +
+			"supported with --secondlevel")
+	}
+
+	preimages, err := parsePreimages(c.Preimages)
+	if err != nil {
+		return err
+	}
+
+	signer, closeSigner, err := c.remoteSigner.walletSigner(
+		c.rootKey, c.hwSigner,
+	)
+	if err != nil {
+		return err
+	}
+	defer closeSigner()
+
+	db, err := openChannelDB(c.ChannelDB, true)
+	if err != nil {
+		return fmt.Errorf("error opening channel DB: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Errorf("Error closing DB: %v", err)
+		}
+	}()
+
+	summaries, err := htlcForceCloseSummaries(db, signer, c.ChanPoint)
+	if err != nil {
+		return err
+	}
+
+	api := c.chainAPI.api()
+	c.FeeRate, err = sweepFeeRate(c.cmd, api, c.FeeRate)
+	if err != nil {
+		return err
+	}
+	if c.SecondLevel {
+		return sweepSecondLevelHTLCs(
+			signer, api, summaries, c.SweepAddr, c.Publish,
+			c.FeeRate, c.hwSigner,
+		)
+	}
+
+	fees := &anchorHTLCFees{feeRate: c.FeeRate}
+	if c.FeeUtxo != "" || c.FeeUtxoPath != "" {
+		if c.FeeUtxo == "" || c.FeeUtxoPath == "" {
+			return fmt.Errorf("--feeutxo and --feeutxopath must " +
+				"be used together")
+		}
+		seedSigner, ok := signer.(*lnd.Signer)
+		if !ok {
+			return fmt.Errorf("--feeutxo can't be used with " +
+				"--remotesigner")
+		}
+		fees.feeInput, err = walletFeeInput(
+			api, seedSigner.ExtendedKey, c.FeeUtxo, c.FeeUtxoPath,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// HTLC-timeout transactions can only be published once their expiry
+	// height is reached.
+	height, err := api.BlockHeight()
+	if err != nil {
+		return fmt.Errorf("error fetching block height: %w", err)
+	}
+
+	results := newTxResults()
+	witnessCache := db.NewWitnessCache()
+	for _, summary := range summaries {
+		err := publishHTLCTransactions(
+			api, signer, summary, preimages, witnessCache, fees,
+			height, c.Publish, results,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return results.print()
+}
+
+// htlcForceCloseSummary is the force close summary of a channel together with
+// the HTLCs of its local commitment.
+type htlcForceCloseSummary struct {
+	channel *channeldb.OpenChannel
+	*lnwallet.LocalForceCloseSummary
+}
+
+// parsePreimages parses a comma separated list of hex encoded preimages and
+// indexes them by their payment hash.
+func parsePreimages(list string) (map[lntypes.Hash]lntypes.Preimage, error) {
+	preimages := make(map[lntypes.Hash]lntypes.Preimage)
+	for _, preimageHex := range strings.Split(list, ",") {
+		preimageHex = strings.TrimSpace(preimageHex)
+		if preimageHex == "" {
+			continue
+		}
+
+		preimage, err := lntypes.MakePreimageFromStr(preimageHex)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing preimage %s: %w",
+				preimageHex, err)
+		}
+		preimages[preimage.Hash()] = preimage
+	}
+
+	return preimages, nil
+}
+
+// htlcForceCloseSummaries creates the force close summaries of all channels in
+// the DB that have HTLCs on their local commitment transaction.
+func htlcForceCloseSummaries(db *channeldb.DB, signer input.Signer,
+	chanPoint string) ([]*htlcForceCloseSummary, error) {
+
+	channels, err := db.ChannelStateDB().FetchAllChannels()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching channels: %w", err)
+	}
+
+	var summaries []*htlcForceCloseSummary
+	for _, channel := range channels {
+		channelPoint := channel.FundingOutpoint.String()
+		if chanPoint != "" && chanPoint != channelPoint {
+			continue
+		}
+
+		localCommit := channel.LocalCommitment
+		if localCommit.CommitTx == nil || len(localCommit.Htlcs) == 0 {
+			continue
+		}
+
+		summary, err := lnwallet.NewLocalForceCloseSummary(
+			channel, signer, localCommit.CommitTx,
+			localCommit.CommitHeight,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error creating force close "+
+				"summary for channel %s: %w", channelPoint, err)
+		}
+
+		summaries = append(summaries, &htlcForceCloseSummary{
+			channel:                channel,
+			LocalForceCloseSummary: summary,
+		})
+	}
+
+	return summaries, nil
+}
+
+// anchorHTLCFees is the wallet UTXO that pays the fees of the HTLC
+// transactions of anchor channels. After each transaction, the fee input is
+// replaced by its change output.
+type anchorHTLCFees struct {
+	feeInput *sweep.AnchorFeeInput
+	feeRate  uint16
+}
+
+// addAnchorHTLCTx adds the fee input to the HTLC transaction of an anchor
+// channel and adds the result to the results. The preimage is nil for
+// HTLC-timeout transactions. If there is no fee input, the transaction is
+// skipped.
+func addAnchorHTLCTx(api btc.SweepAPI, signer input.Signer,
+	channelPoint string, htlcTx *wire.MsgTx, signDetails *input.SignDetails,
+	preimage []byte, fees *anchorHTLCFees, publish bool,
+	results *txResults) error {
+
+	if fees.feeInput == nil {
+		log.Errorf("HTLC TX of anchor channel %s needs a fee input, "+
+			"use --feeutxo and --feeutxopath", channelPoint)
+		results.skip(channelPoint, "HTLC TX of anchor channel needs "+
+			"--feeutxo")
+
+		return nil
+	}
+
+	tx, err := sweep.AnchorHTLCTx(
+		signer, htlcTx, signDetails, preimage, fees.feeInput,
+		fees.feeRate, log,
+	)
+	if err != nil {
+		return fmt.Errorf("error adding fee input to HTLC TX of "+
+			"channel %s: %w", channelPoint, err)
+	}
+
+	inputs := []*txResultInput{{
+		Outpoint:     tx.TxIn[0].PreviousOutPoint.String(),
+		Value:        signDetails.SignDesc.Output.Value,
+		ChannelPoint: channelPoint,
+	}, {
+		Outpoint: fees.feeInput.Outpoint.String(),
+		Value:    fees.feeInput.Utxo.Value,
+	}}
+	if err := results.addTx(api, tx, inputs, publish); err != nil {
+		return err
+	}
+
+	// The change of this transaction pays the fees of the next one.
+	fees.feeInput = &sweep.AnchorFeeInput{
+		Outpoint: wire.OutPoint{Hash: tx.TxHash(), Index: 1},
+		Utxo:     tx.TxOut[1],
+		PrivKey:  fees.feeInput.PrivKey,
+	}
+
+	return nil
+}
+
+// publishHTLCTransactions prints and optionally publishes the HTLC-timeout and
+// HTLC-success transactions of a channel and adds them to the results.
+// HTLC-timeout transactions that expire after the given block height are never
+// published.
+func publishHTLCTransactions(api btc.SweepAPI, signer input.Signer,
+	summary *htlcForceCloseSummary,
+	preimages map[lntypes.Hash]lntypes.Preimage,
+	witnessCache *channeldb.WitnessCache, fees *anchorHTLCFees,
+	height uint32, publish bool, results *txResults) error {
+
+	var (
+		channelPoint = summary.channel.FundingOutpoint.String()
+		resolutions  = summary.HtlcResolutions
+		htlcs        = summary.channel.LocalCommitment.Htlcs
+	)
+	for _, resolution := range resolutions.OutgoingHTLCs {
+		if resolution.SignedTimeoutTx == nil {
+			continue
+		}
+
+		if resolution.SignDetails != nil {
+			// The change of the HTLC-timeout TX is the fee input
+			// of the next one, so it must be publishable now.
+			if resolution.Expiry > height {
+				log.Infof("HTLC-timeout TX of channel %s can "+
+					"only be created after block height %d",
+					channelPoint, resolution.Expiry)
+				results.skip(channelPoint, fmt.Sprintf(
+					"HTLC-timeout TX can only be created "+
+						"after block height %d",
+					resolution.Expiry,
+				))
+
+				continue
+			}
+
+			err := addAnchorHTLCTx(
+				api, signer, channelPoint,
+				resolution.SignedTimeoutTx,
+				resolution.SignDetails, nil, fees, publish,
+				results,
+			)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// The backend would reject an immature HTLC-timeout TX as
+		// non-final, so we only print it.
+		publishTimeout := publish
+		if resolution.Expiry > height {
+			log.Infof("HTLC-timeout TX of channel %s can only be "+
+				"published after block height %d, not "+
+				"publishing it", channelPoint,
+				resolution.Expiry)
+			publishTimeout = false
+		}
+
+		err := results.addTx(
+			api, resolution.SignedTimeoutTx, nil, publishTimeout,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, resolution := range resolutions.IncomingHTLCs {
+		successTx := resolution.SignedSuccessTx
+		if successTx == nil {
+			continue
+		}
+
+		// Find the payment hash of the HTLC that is spent by the
+		// success transaction.
+		var (
+			outputIndex = successTx.TxIn[0].PreviousOutPoint.Index
+			paymentHash *lntypes.Hash
+		)
+		for _, htlc := range htlcs {
+			if htlc.Incoming &&
+				htlc.OutputIndex == int32(outputIndex) {
+
+				hash := lntypes.Hash(htlc.RHash)
+				paymentHash = &hash
+			}
+		}
+		if paymentHash == nil {
+			log.Errorf("Could not find HTLC for output %d of "+
+				"channel %s", outputIndex, channelPoint)
+			results.skip(channelPoint, fmt.Sprintf("HTLC for "+
+				"output %d not found", outputIndex))
+
+			continue
+		}
+
+		preimage, ok := preimages[*paymentHash]
+		if !ok {
+			var err error
+			preimage, err = witnessCache.LookupSha256Witness(
+				*paymentHash,
+			)
+			if err != nil {
+				log.Errorf("No preimage for incoming HTLC %v "+
+					"of channel %s, cannot claim it",
+					paymentHash, channelPoint)
+				results.skip(channelPoint, fmt.Sprintf("no "+
+					"preimage for incoming HTLC %v",
+					paymentHash))
+
+				continue
+			}
+		}
+		if sha256.Sum256(preimage[:]) != *paymentHash {
+			return fmt.Errorf("preimage %v doesn't match payment "+
+				"hash %v", preimage, paymentHash)
+		}
+
+		if resolution.SignDetails != nil {
+			err := addAnchorHTLCTx(
+				api, signer, channelPoint, successTx,
+				resolution.SignDetails, preimage[:], fees,
+				publish, results,
+			)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// The preimage is the only part of the witness that is not
+		// filled in by lnd.
+		successTx.TxIn[0].Witness[3] = preimage[:]
+
+		log.Infof("HTLC-success TX of channel %s can be published "+
+			"now", channelPoint)
+
+		err := results.addTx(api, successTx, nil, publish)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sweepSecondLevelHTLCs sweeps the outputs of all confirmed and unspent
+// HTLC-timeout and HTLC-success transactions into a single transaction.
+func sweepSecondLevelHTLCs(signer walletSigner, api btc.SweepAPI,
+	summaries []*htlcForceCloseSummary, sweepAddr string, publish bool,
+	feeRate uint16, hw *hwSigner) error {
+
+	type secondLevelOutput struct {
+		channelPoint string
+		outpoint     wire.OutPoint
+		csvDelay     uint32
+		signDesc     input.SignDescriptor
+
+		// htlcOutpoint is only set for anchor channels. Their
+		// second-level transactions contain our fee input, so the
+		// claim outpoint lnd knows is different from the one on chain.
+		htlcOutpoint *wire.OutPoint
+	}
+
+	var outputs []*secondLevelOutput
+	for _, summary := range summaries {
+		channelPoint := summary.channel.FundingOutpoint.String()
+		resolutions := summary.HtlcResolutions
+		for _, resolution := range resolutions.OutgoingHTLCs {
+			if resolution.SignedTimeoutTx == nil {
+				continue
+			}
+			output := &secondLevelOutput{
+				channelPoint: channelPoint,
+				outpoint:     resolution.ClaimOutpoint,
+				csvDelay:     resolution.CsvDelay,
+				signDesc:     resolution.SweepSignDesc,
+			}
+			if resolution.SignDetails != nil {
+				output.htlcOutpoint = &resolution.
+					SignedTimeoutTx.TxIn[0].PreviousOutPoint
+			}
+			outputs = append(outputs, output)
+		}
+		for _, resolution := range resolutions.IncomingHTLCs {
+			if resolution.SignedSuccessTx == nil {
+				continue
+			}
+			output := &secondLevelOutput{
+				channelPoint: channelPoint,
+				outpoint:     resolution.ClaimOutpoint,
+				csvDelay:     resolution.CsvDelay,
+				signDesc:     resolution.SweepSignDesc,
+			}
+			if resolution.SignDetails != nil {
+				output.htlcOutpoint = &resolution.
+					SignedSuccessTx.TxIn[0].PreviousOutPoint
+			}
+			outputs = append(outputs, output)
+		}
+	}
+
+	results := newTxResults()
+	sweepTx := wire.NewMsgTx(2)
+	totalOutputValue := int64(0)
+	inputs := make([]*txResultInput, 0, len(outputs))
+	signDescs := make([]*input.SignDescriptor, 0, len(outputs))
+	csvDelays := make([]uint32, 0, len(outputs))
+	var estimator input.TxWeightEstimator
+	for _, output := range outputs {
+		if output.htlcOutpoint != nil {
+			outpoint, reason, err := anchorSecondLevelOutpoint(
+				api, *output.htlcOutpoint,
+				output.signDesc.Output.PkScript,
+			)
+			if err != nil {
+				return err
+			}
+			if outpoint == nil {
+				log.Infof("Skipping HTLC output %v: %s",
+					output.htlcOutpoint, reason)
+				results.skip(output.channelPoint, reason)
+
+				continue
+			}
+			output.outpoint = *outpoint
+		}
+
+		// Only outputs of published second-level transactions that
+		// weren't swept yet can be spent.
+		tx, err := api.Transaction(output.outpoint.Hash.String())
+		switch {
+		case errors.Is(err, btc.ErrTxNotFound):
+			log.Infof("Second-level TX %v not found, skipping",
+				output.outpoint.Hash)
+			results.skip(output.channelPoint, fmt.Sprintf(
+				"second-level TX %v not found",
+				output.outpoint.Hash,
+			))
+
+			continue
+
+		case err != nil:
+			return fmt.Errorf("error fetching second-level TX %v: "+
+				"%w", output.outpoint.Hash, err)
+		}
+		if int(output.outpoint.Index) >= len(tx.Vout) {
+			return fmt.Errorf("second-level TX %v has no output %d",
+				output.outpoint.Hash, output.outpoint.Index)
+		}
+		vout := tx.Vout[output.outpoint.Index]
+		if vout.Outspend != nil && vout.Outspend.Spent {
+			log.Infof("Output %v already spent, skipping",
+				output.outpoint)
+			results.skip(output.channelPoint, fmt.Sprintf(
+				"output %v already spent", output.outpoint,
+			))
+
+			continue
+		}
+
+		sweepTx.TxIn = append(sweepTx.TxIn, &wire.TxIn{
+			PreviousOutPoint: output.outpoint,
+			Sequence: input.LockTimeToSequence(
+				false, output.csvDelay,
+			),
+		})
+
+		signDesc := output.signDesc
+		totalOutputValue += signDesc.Output.Value
+		signDescs = append(signDescs, &signDesc)
+		csvDelays = append(csvDelays, output.csvDelay)
+		inputs = append(inputs, &txResultInput{
+			Outpoint:     output.outpoint.String(),
+			Value:        signDesc.Output.Value,
+			ChannelPoint: output.channelPoint,
+		})
+
+		estimator.AddWitnessInput(input.ToLocalTimeoutWitnessSize)
+	}
+
+	if len(signDescs) == 0 || totalOutputValue < sweep.DustLimit {
+		return fmt.Errorf("found %d sweep targets with total value "+
+			"of %d satoshis which is below the dust limit of %d",
+			len(signDescs), totalOutputValue, sweep.DustLimit)
+	}
+
+	// Add our sweep destination output.
+	sweepScript, err := lnd.GetP2WPKHScript(sweepAddr, chainParams)
+	if err != nil {
+		return err
+	}
+	estimator.AddP2WKHOutput()
+
+	// Calculate the fee based on the given fee rate and our weight
+	// estimation.
+	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
+	totalFee := feeRateKWeight.FeeForWeight(int64(estimator.Weight()))
+
+	log.Infof("Fee %d sats of %d total amount (estimated weight %d)",
+		totalFee, totalOutputValue, estimator.Weight())
+
+	sweepTx.TxOut = []*wire.TxOut{{
+		Value:    totalOutputValue - int64(totalFee),
+		PkScript: sweepScript,
+	}}
+
+	// Sign the transaction now.
+	signer, err = hw.wrap(signer)
+	if err != nil {
+		return err
+	}
+	sigHashes := input.NewTxSigHashesV0Only(sweepTx)
+	for idx, desc := range signDescs {
+		desc.SigHashes = sigHashes
+		desc.InputIndex = idx
+
+		// The fetcher of the sign descriptor only knows the outputs of
+		// the original second-level transaction.
+		desc.PrevOutputFetcher = nil
+
+		witness, err := input.HtlcSpendSuccess(
+			signer, desc, sweepTx, csvDelays[idx],
+		)
+		if err != nil {
+			return err
+		}
+		sweepTx.TxIn[idx].Witness = witness
+	}
+
+	if hw.exporting() {
+		return hw.export(sweepTx)
+	}
+
+	err = results.addTx(api, sweepTx, inputs, publish)
+	if err != nil {
+		return err
+	}
+
+	return results.print()
+}
+
+// anchorSecondLevelOutpoint looks up the second-level transaction that spends
+// the given HTLC output of the commitment transaction of an anchor channel.
+// Because our fee input was added to it, its TXID is not known in advance.
+// The HTLC input and the second-level output always have the same index
+// because the remote peer signed them with SIGHASH_SINGLE. If the HTLC output
+// wasn't spent by our second-level transaction, the reason is returned instead
+// of the outpoint.
+func anchorSecondLevelOutpoint(api btc.ChainAPI, htlcOutpoint wire.OutPoint,
+	pkScript []byte) (*wire.OutPoint, string, error) {
+
+	commitTx, err := api.Transaction(htlcOutpoint.Hash.String())
+	switch {
+	case errors.Is(err, btc.ErrTxNotFound):
+		return nil, fmt.Sprintf("commitment TX %v not found",
+			htlcOutpoint.Hash), nil
+
+	case err != nil:
+		return nil, "", fmt.Errorf("error fetching commitment TX %v: "+
+			"%w", htlcOutpoint.Hash, err)
+	}
+	if int(htlcOutpoint.Index) >= len(commitTx.Vout) {
+		return nil, "", fmt.Errorf("commitment TX %v has no output %d",
+			htlcOutpoint.Hash, htlcOutpoint.Index)
+	}
+
+	outspend := commitTx.Vout[htlcOutpoint.Index].Outspend
+	if outspend == nil || !outspend.Spent {
+		return nil, fmt.Sprintf("HTLC output %v not spent yet",
+			htlcOutpoint), nil
+	}
+
+	spendTx, err := api.Transaction(outspend.Txid)
+	if err != nil {
+		return nil, "", fmt.Errorf("error fetching spending TX %s of "+
+			"HTLC output %v: %w", outspend.Txid, htlcOutpoint, err)
+	}
+	if outspend.Vin >= len(spendTx.Vout) ||
+		spendTx.Vout[outspend.Vin].ScriptPubkey !=
+			hex.EncodeToString(pkScript) {
+
+		return nil, fmt.Sprintf("HTLC output %v was spent by the "+
+			"remote node in %s", htlcOutpoint, outspend.Txid), nil
+	}
+
+	hash, err := chainhash.NewHashFromStr(outspend.Txid)
+	if err != nil {
+		return nil, "", fmt.Errorf("error parsing TXID %s: %w",
+			outspend.Txid, err)
+	}
+
+	return &wire.OutPoint{Hash: *hash, Index: uint32(outspend.Vin)}, "",
+		nil
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/sweep"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwallet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePreimages(t *testing.T) {
+	preimage1 := lntypes.Preimage{1, 2, 3}
+	preimage2 := lntypes.Preimage{4, 5, 6}
+
+	preimages, err := parsePreimages(
+		preimage1.String() + ", " + preimage2.String() + ",",
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[lntypes.Hash]lntypes.Preimage{
+		preimage1.Hash(): preimage1,
+		preimage2.Hash(): preimage2,
+	}, preimages)
+
+	preimages, err = parsePreimages("")
+	require.NoError(t, err)
+	require.Empty(t, preimages)
+
+	_, err = parsePreimages("abcd")
+	require.Error(t, err)
+}
+
+// errChainAPI is a chain backend that fails to look up any transaction with
+// the given error.
+type errChainAPI struct {
+	btc.SweepAPI
+	err error
+}
+
+func (e *errChainAPI) Transaction(string) (*btc.TX, error) {
+	return nil, e.err
+}
+
+func TestAnchorHTLCTx(t *testing.T) {
+	h := newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	localKey, err := signer.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyHtlcBase,
+		Index:  1,
+	})
+	require.NoError(t, err)
+	remotePrivKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	revocationPrivKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	preimage := lntypes.Preimage{1, 2, 3}
+	paymentHash := preimage.Hash()
+	offeredScript, err := input.SenderHTLCScript(
+		localKey.PubKey, remotePrivKey.PubKey(),
+		revocationPrivKey.PubKey(), paymentHash[:], true,
+	)
+	require.NoError(t, err)
+	acceptedScript, err := input.ReceiverHTLCScript(
+		500, remotePrivKey.PubKey(), localKey.PubKey,
+		revocationPrivKey.PubKey(), paymentHash[:], true,
+	)
+	require.NoError(t, err)
+
+	feeKey, err := lnd.DeriveChildren(extendedKey, []uint32{
+		lnd.HardenedKey(84), lnd.HardenedKey(1), lnd.HardenedKey(0), 0,
+		0,
+	})
+	require.NoError(t, err)
+	feePrivKey, err := feeKey.ECPrivKey()
+	require.NoError(t, err)
+	feeScript, err := input.WitnessPubKeyHash(
+		feePrivKey.PubKey().SerializeCompressed(),
+	)
+	require.NoError(t, err)
+	feeInput := &sweep.AnchorFeeInput{
+		Outpoint: wire.OutPoint{Hash: chainhash.Hash{9}},
+		Utxo:     &wire.TxOut{Value: 50_000, PkScript: feeScript},
+		PrivKey:  feePrivKey,
+	}
+
+	testCases := []struct {
+		name          string
+		witnessScript []byte
+		lockTime      uint32
+		preimage      []byte
+		change        int64
+	}{{
+		name:          "timeout",
+		witnessScript: offeredScript,
+		lockTime:      500,
+		change:        50_000 - 2657,
+	}, {
+		name:          "success",
+		witnessScript: acceptedScript,
+		preimage:      preimage[:],
+		change:        50_000 - 2757,
+	}}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			pkScript, err := input.WitnessScriptHash(
+				tc.witnessScript,
+			)
+			require.NoError(t, err)
+			htlcOut := &wire.TxOut{
+				Value:    100_000,
+				PkScript: pkScript,
+			}
+
+			// The HTLC TX doesn't pay any fees and is signed by
+			// the remote peer with SIGHASH_SINGLE|ANYONECANPAY.
+			htlcTx := wire.NewMsgTx(2)
+			htlcTx.LockTime = tc.lockTime
+			htlcTx.AddTxIn(&wire.TxIn{
+				PreviousOutPoint: wire.OutPoint{
+					Hash:  chainhash.Hash{1},
+					Index: 2,
+				},
+				Sequence: 1,
+			})
+			htlcTx.AddTxOut(&wire.TxOut{
+				Value:    100_000,
+				PkScript: []byte{0x00, 0x20, 0x01},
+			})
+			htlcFetcher := txscript.NewCannedPrevOutputFetcher(
+				htlcOut.PkScript, htlcOut.Value,
+			)
+			sigHashType := txscript.SigHashSingle |
+				txscript.SigHashAnyOneCanPay
+			remoteSig, err := txscript.RawTxInWitnessSignature(
+				htlcTx, txscript.NewTxSigHashes(
+					htlcTx, htlcFetcher,
+				), 0, htlcOut.Value, tc.witnessScript,
+				sigHashType, remotePrivKey,
+			)
+			require.NoError(t, err)
+			peerSig, err := ecdsa.ParseDERSignature(
+				remoteSig[:len(remoteSig)-1],
+			)
+			require.NoError(t, err)
+
+			signDetails := &input.SignDetails{
+				SignDesc: input.SignDescriptor{
+					KeyDesc:       *localKey,
+					WitnessScript: tc.witnessScript,
+					Output:        htlcOut,
+					HashType:      txscript.SigHashAll,
+				},
+				PeerSig:     peerSig,
+				SigHashType: sigHashType,
+			}
+
+			tx, err := sweep.AnchorHTLCTx(
+				signer, htlcTx, signDetails, tc.preimage,
+				feeInput, 10, log,
+			)
+			require.NoError(t, err)
+			h.assertLogContains("Fee")
+
+			require.Len(t, tx.TxIn, 2)
+			require.Len(t, tx.TxOut, 2)
+			require.Equal(t, htlcTx.TxOut[0], tx.TxOut[0])
+			require.Equal(t, tc.change, tx.TxOut[1].Value)
+			require.Equal(t, feeScript, tx.TxOut[1].PkScript)
+
+			// Both inputs must be valid.
+			fetcher := txscript.NewMultiPrevOutFetcher(nil)
+			fetcher.AddPrevOut(
+				htlcTx.TxIn[0].PreviousOutPoint, htlcOut,
+			)
+			fetcher.AddPrevOut(feeInput.Outpoint, feeInput.Utxo)
+			sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+			for idx, prevOut := range []*wire.TxOut{
+				htlcOut, feeInput.Utxo,
+			} {
+				vm, err := txscript.NewEngine(
+					prevOut.PkScript, tx, idx,
+					txscript.StandardVerifyFlags, nil,
+					sigHashes, prevOut.Value, fetcher,
+				)
+				require.NoError(t, err)
+				require.NoError(t, vm.Execute())
+			}
+		})
+	}
+
+	// Only HTLC TXs as created by lnd are supported.
+	_, err = sweep.AnchorHTLCTx(
+		signer, wire.NewMsgTx(2), nil, nil, feeInput, 10, log,
+	)
+	require.ErrorContains(t, err, "exactly one input and output")
+}
+
+func TestAnchorSecondLevelOutpoint(t *testing.T) {
+	txid := func(b byte) string {
+		return chainhash.Hash{b}.String()
+	}
+	spentTo := func(txid string, vin int) *btc.Vout {
+		return &btc.Vout{Outspend: &btc.Outspend{
+			Spent: txid != "",
+			Txid:  txid,
+			Vin:   vin,
+		}}
+	}
+	pkScript := []byte{0x00, 0x20, 0x01}
+	api := &mockChainAPI{txs: map[string]*btc.TX{
+		txid(1): {Vout: []*btc.Vout{
+			spentTo("", 0), spentTo(txid(2), 1), spentTo(txid(3), 0),
+		}},
+		txid(2): {Vout: []*btc.Vout{{}, {
+			ScriptPubkey: hex.EncodeToString(pkScript),
+		}}},
+		txid(3): {Vout: []*btc.Vout{{ScriptPubkey: "0014aa"}}},
+	}}
+	htlcOutpoint := func(index uint32) wire.OutPoint {
+		return wire.OutPoint{Hash: chainhash.Hash{1}, Index: index}
+	}
+
+	// Our second-level TX spends the HTLC output and has the second-level
+	// output at the same index.
+	outpoint, _, err := anchorSecondLevelOutpoint(
+		api, htlcOutpoint(1), pkScript,
+	)
+	require.NoError(t, err)
+	require.Equal(t, &wire.OutPoint{Hash: chainhash.Hash{2}, Index: 1},
+		outpoint)
+
+	outpoint, reason, err := anchorSecondLevelOutpoint(
+		api, htlcOutpoint(0), pkScript,
+	)
+	require.NoError(t, err)
+	require.Nil(t, outpoint)
+	require.Contains(t, reason, "not spent yet")
+
+	outpoint, reason, err = anchorSecondLevelOutpoint(
+		api, htlcOutpoint(2), pkScript,
+	)
+	require.NoError(t, err)
+	require.Nil(t, outpoint)
+	require.Contains(t, reason, "spent by the remote node")
+
+	// Only a missing TX means the commitment isn't published, other
+	// errors of the backend are returned.
+	outpoint, reason, err = anchorSecondLevelOutpoint(
+		&errChainAPI{err: btc.ErrTxNotFound}, htlcOutpoint(1),
+		pkScript,
+	)
+	require.NoError(t, err)
+	require.Nil(t, outpoint)
+	require.Contains(t, reason, "not found")
+
+	_, _, err = anchorSecondLevelOutpoint(
+		&errChainAPI{err: errors.New("connection refused")},
+		htlcOutpoint(1), pkScript,
+	)
+	require.ErrorContains(t, err, "connection refused")
+}
+
+func TestSweepSecondLevelHTLCsBackendError(t *testing.T) {
+	h := newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+
+	claimOutpoint := wire.OutPoint{Hash: chainhash.Hash{2}}
+	resolutions := []lnwallet.OutgoingHtlcResolution{{
+		SignedTimeoutTx: wire.NewMsgTx(2),
+		ClaimOutpoint:   claimOutpoint,
+	}}
+	summaries := []*htlcForceCloseSummary{{
+		channel: &channeldb.OpenChannel{
+			FundingOutpoint: wire.OutPoint{Hash: chainhash.Hash{1}},
+		},
+		LocalForceCloseSummary: &lnwallet.LocalForceCloseSummary{
+			HtlcResolutions: &lnwallet.HtlcResolutions{
+				OutgoingHTLCs: resolutions,
+			},
+		},
+	}}
+
+	// A second-level TX that wasn't published is skipped.
+	err = sweepSecondLevelHTLCs(
+		signer, &errChainAPI{err: btc.ErrTxNotFound}, summaries,
+		"", false, 10, &hwSigner{},
+	)
+	require.ErrorContains(t, err, "found 0 sweep targets")
+	h.assertLogContains("Second-level TX " +
+		claimOutpoint.Hash.String() + " not found")
+
+	// Any other error of the backend aborts the sweep.
+	err = sweepSecondLevelHTLCs(
+		signer, &errChainAPI{err: errors.New("connection refused")},
+		summaries, "", false, 10, &hwSigner{},
+	)
+	require.ErrorContains(t, err, "connection refused")
+}
+
+// recordingSweepAPI is a fake chain backend that records all published
+// transactions.
+type recordingSweepAPI struct {
+	btc.SweepAPI
+	published []string
+}
+
+func (r *recordingSweepAPI) PublishTx(rawTxHex string) (string, error) {
+	r.published = append(r.published, rawTxHex)
+	return "ok", nil
+}
+
+func TestPublishHTLCTransactionsExpiry(t *testing.T) {
+	h := newHarness(t)
+
+	timeoutTx := func(lockTime uint32) *wire.MsgTx {
+		tx := wire.NewMsgTx(2)
+		tx.LockTime = lockTime
+		return tx
+	}
+	matureTx, immatureTx := timeoutTx(90), timeoutTx(110)
+	chanPoint := wire.OutPoint{Hash: chainhash.Hash{1}}
+	resolutions := []lnwallet.OutgoingHtlcResolution{{
+		Expiry:          90,
+		SignedTimeoutTx: matureTx,
+	}, {
+		Expiry:          110,
+		SignedTimeoutTx: immatureTx,
+	}, {
+		// Anchor channel HTLCs that expired are not skipped as
+		// immature.
+		Expiry:          50,
+		SignedTimeoutTx: timeoutTx(50),
+		SignDetails:     &input.SignDetails{},
+	}, {
+		Expiry:          150,
+		SignedTimeoutTx: timeoutTx(150),
+		SignDetails:     &input.SignDetails{},
+	}}
+	summary := &htlcForceCloseSummary{
+		channel: &channeldb.OpenChannel{FundingOutpoint: chanPoint},
+		LocalForceCloseSummary: &lnwallet.LocalForceCloseSummary{
+			HtlcResolutions: &lnwallet.HtlcResolutions{
+				OutgoingHTLCs: resolutions,
+			},
+		},
+	}
+
+	api := &recordingSweepAPI{}
+	results := newTxResults()
+	err := publishHTLCTransactions(
+		api, nil, summary, nil, nil, &anchorHTLCFees{}, 100, true,
+		results,
+	)
+	require.NoError(t, err)
+
+	// Both non-anchor HTLC-timeout TXs are printed but only the mature one
+	// is published.
+	require.Len(t, results.Transactions, 2)
+	require.Equal(
+		t, matureTx.TxHash().String(), results.Transactions[0].TxID,
+	)
+	require.True(t, results.Transactions[0].Published)
+	require.Equal(
+		t, immatureTx.TxHash().String(), results.Transactions[1].TxID,
+	)
+	require.False(t, results.Transactions[1].Published)
+	require.Equal(
+		t, []string{results.Transactions[0].RawTx}, api.published,
+	)
+	h.assertLogContains("can only be published after block height 110")
+
+	// The expired anchor HTLC only misses the fee input, the other one is
+	// immature.
+	require.Len(t, results.SkippedChannels, 2)
+	require.Equal(
+		t, "HTLC TX of anchor channel needs --feeutxo",
+		results.SkippedChannels[0].Reason,
+	)
+	require.Equal(
+		t, "HTLC-timeout TX can only be created after block height 150",
+		results.SkippedChannels[1].Reason,
+	)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/sweep"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSweepTaprootOutputs(t *testing.T) {
+	_ = newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	deriveKey := func(index uint32) *keychain.KeyDescriptor {
+		keyDesc, err := signer.DeriveKey(keychain.KeyLocator{
+			Family: keychain.KeyFamilyPaymentBase,
+			Index:  index,
+		})
+		require.NoError(t, err)
+
+		return keyDesc
+	}
+
+	// The first output is the to_remote output of a simple taproot
+	// channel that can only be spent through the script path.
+	toRemoteKey := deriveKey(0)
+	toRemoteAddr, toRemoteScript, controlBlock, err :=
+		lnd.P2TaprootStaticRemote(toRemoteKey.PubKey, chainParams)
+	require.NoError(t, err)
+	toRemotePkScript, err := txscript.PayToAddrScript(toRemoteAddr)
+	require.NoError(t, err)
+
+	// The second output is a BIP 86 key spend output.
+	bip86Key := deriveKey(1)
+	bip86Addr, err := lnd.P2TRAddr(bip86Key.PubKey, chainParams)
+	require.NoError(t, err)
+	bip86PkScript, err := txscript.PayToAddrScript(bip86Addr)
+	require.NoError(t, err)
+
+	// The third output commits to a script tree but is spent through
+	// the key spend path.
+	tweakedKey := deriveKey(2)
+	rootHash := txscript.NewBaseTapLeaf(toRemoteScript).TapHash()
+	tweakedPkScript, err := txscript.PayToTaprootScript(
+		txscript.ComputeTaprootOutputKey(
+			tweakedKey.PubKey, rootHash[:],
+		),
+	)
+	require.NoError(t, err)
+
+	prevOuts := []*wire.TxOut{
+		{Value: 100_000, PkScript: toRemotePkScript},
+		{Value: 200_000, PkScript: bip86PkScript},
+		{Value: 300_000, PkScript: tweakedPkScript},
+	}
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	sweepTx := wire.NewMsgTx(2)
+	for idx, prevOut := range prevOuts {
+		outpoint := wire.OutPoint{
+			Hash:  chainhash.Hash{byte(idx + 1)},
+			Index: uint32(idx),
+		}
+		sweepTx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: outpoint,
+			Sequence:         1,
+		})
+		prevOutFetcher.AddPrevOut(outpoint, prevOut)
+	}
+	sweepTx.AddTxOut(&wire.TxOut{Value: 590_000, PkScript: bip86PkScript})
+
+	signDescs := []*input.SignDescriptor{{
+		KeyDesc:       *toRemoteKey,
+		WitnessScript: toRemoteScript,
+		SignMethod:    input.TaprootScriptSpendSignMethod,
+	}, {
+		KeyDesc:    *bip86Key,
+		SignMethod: input.TaprootKeySpendBIP0086SignMethod,
+	}, {
+		KeyDesc:    *tweakedKey,
+		TapTweak:   rootHash[:],
+		SignMethod: input.TaprootKeySpendSignMethod,
+	}}
+
+	// Taproot inputs can't be signed without knowing all previous
+	// outputs.
+	signDescs[1].Output = prevOuts[1]
+	signDescs[1].InputIndex = 1
+	_, err = lnd.TaprootKeySpendWitness(signer, signDescs[1], sweepTx)
+	require.ErrorContains(t, err, "previous output fetcher required")
+
+	sigHashes := txscript.NewTxSigHashes(sweepTx, prevOutFetcher)
+	for idx, signDesc := range signDescs {
+		signDesc.Output = prevOuts[idx]
+		signDesc.InputIndex = idx
+		signDesc.HashType = txscript.SigHashDefault
+		signDesc.SigHashes = sigHashes
+		signDesc.PrevOutputFetcher = prevOutFetcher
+
+		var witness wire.TxWitness
+		if idx == 0 {
+			witness, err = lnd.TaprootScriptSpendWitness(
+				signer, signDesc, sweepTx, controlBlock,
+			)
+		} else {
+			witness, err = lnd.TaprootKeySpendWitness(
+				signer, signDesc, sweepTx,
+			)
+		}
+		require.NoError(t, err)
+		sweepTx.TxIn[idx].Witness = witness
+	}
+
+	for idx, prevOut := range prevOuts {
+		engine, err := txscript.NewEngine(
+			prevOut.PkScript, sweepTx, idx,
+			txscript.StandardVerifyFlags, nil, sigHashes,
+			prevOut.Value, prevOutFetcher,
+		)
+		require.NoError(t, err)
+		require.NoError(t, engine.Execute(), "input %d", idx)
+	}
+
+	// The script spend witness consists of the signature, the script and
+	// the control block.
+	require.Len(t, sweepTx.TxIn[0].Witness, 3)
+	require.Len(t, sweepTx.TxIn[0].Witness[0], schnorr.SignatureSize)
+	require.Equal(
+		t, sweep.TaprootScriptSpendWitnessSize(
+			toRemoteScript, controlBlock,
+		),
+		sweepTx.TxIn[0].Witness.SerializeSize(),
+	)
+}
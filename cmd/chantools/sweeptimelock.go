@@ -1,21 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"encoding/hex"
 	"fmt"
 
 	"github.com/btcsuite/btcd/btcec/v2"
-	"github.com/btcsuite/btcd/btcutil/hdkeychain"
-	"github.com/btcsuite/btcd/chaincfg/chainhash"
-	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/dataformat"
 	"github.com/guggero/chantools/lnd"
-	"github.com/lightningnetwork/lnd/input"
-	"github.com/lightningnetwork/lnd/keychain"
-	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+	"github.com/guggero/chantools/sweep"
 	"github.com/spf13/cobra"
 )
 
@@ -25,15 +19,17 @@ const (
 )
 
 type sweepTimeLockCommand struct {
-	APIURL      string
 	Publish     bool
 	SweepAddr   string
 	MaxCsvLimit uint16
 	FeeRate     uint16
 
-	rootKey *rootKey
-	inputs  *inputFlags
-	cmd     *cobra.Command
+	chainAPI     *chainAPIFlags
+	rootKey      *rootKey
+	inputs       *inputFlags
+	remoteSigner *remoteSigner
+	hwSigner     *hwSigner
+	cmd          *cobra.Command
 }
 
 func newSweepTimeLockCommand() *cobra.Command {
@@ -48,18 +44,40 @@ that was created with the forceclose command, otherwise it won't work. You also
 have to wait until the highest time lock (can be up to 2016 blocks which is more
 than two weeks) of all the channels has passed. If you only want to sweep
 channels that have the default CSV limit of 1 day, you can set the --maxcsvlimit
-parameter to 144.`,
+parameter to 144.
+
+To sign the sweep transaction with a hardware wallet, first run the command with
+--hwexport to get a PSBT, sign it with the hardware wallet, then run the command
+again with the same flags and --hwsignedpsbt to create the final transaction.
+The to_local outputs are locked to a tweaked key, hardware wallets can't sign
+those. The PSBT can be signed with lnd (SignPsbt) or 'chantools offlinesweep
+sign' instead. With --hwxpub set to the extended public key of the delay base
+key family (m/1017'/<coin_type>'/4') and --hwfingerprint, the seed isn't needed
+to export the PSBT and create the final transaction.
+
+If the seed isn't available on this machine because the node uses a remote
+signer, the sweep can be signed by the remote signer lnd node through its gRPC
+interface with --remotesigner.`,
 		Example: `chantools sweeptimelock \
 	--fromsummary results/forceclose-xxxx-yyyy.json \
 	--sweepaddr bc1q..... \
 	--feerate 10 \
-  	--publish`,
+  	--publish
+
+chantools sweeptimelock \
+	--fromsummary results/forceclose-xxxx-yyyy.json \
+	--sweepaddr bc1q..... \
+	--feerate 10 \
+	--hwexport
+
+chantools sweeptimelock \
+	--fromsummary results/forceclose-xxxx-yyyy.json \
+	--sweepaddr bc1q..... \
+	--remotesigner signer.example.com:10009 \
+	--remotesignermacaroon signer-admin.macaroon`,
 		RunE: cc.Execute,
 	}
-	cc.cmd.Flags().StringVar(
-		&cc.APIURL, "apiurl", defaultAPIURL, "API URL to use (must "+
-			"be esplora compatible)",
-	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
 	cc.cmd.Flags().BoolVar(
 		&cc.Publish, "publish", false, "publish sweep TX to the chain "+
 			"API instead of just printing the TX",
@@ -78,15 +96,21 @@ parameter to 144.`,
 
 	cc.rootKey = newRootKey(cc.cmd, "deriving keys")
 	cc.inputs = newInputFlags(cc.cmd)
+	cc.remoteSigner = newRemoteSigner(cc.cmd)
+	cc.hwSigner = newHWSigner(cc.cmd)
+	cc.hwSigner.registerXPubFlag(cc.cmd)
 
 	return cc.cmd
 }
 
 func (c *sweepTimeLockCommand) Execute(_ *cobra.Command, _ []string) error {
-	extendedKey, err := c.rootKey.read()
+	signer, closeSigner, err := c.remoteSigner.walletSigner(
+		c.rootKey, c.hwSigner,
+	)
 	if err != nil {
-		return fmt.Errorf("error reading root key: %w", err)
+		return err
 	}
+	defer closeSigner()
 
 	// Make sure sweep addr is set.
 	if c.SweepAddr == "" {
@@ -103,232 +127,80 @@ func (c *sweepTimeLockCommand) Execute(_ *cobra.Command, _ []string) error {
 	if c.MaxCsvLimit == 0 {
 		c.MaxCsvLimit = defaultCsvLimit
 	}
-	if c.FeeRate == 0 {
-		c.FeeRate = defaultFeeSatPerVByte
+	api := c.chainAPI.api()
+	c.FeeRate, err = sweepFeeRate(c.cmd, api, c.FeeRate)
+	if err != nil {
+		return err
 	}
-	return sweepTimeLockFromSummary(
-		extendedKey, c.APIURL, entries, c.SweepAddr, c.MaxCsvLimit,
-		c.Publish, c.FeeRate,
+	results := newTxResults()
+	err = sweepTimeLockFromSummary(
+		signer, api, entries, c.SweepAddr, c.MaxCsvLimit, c.Publish,
+		c.FeeRate, c.hwSigner, results,
 	)
-}
+	if err != nil || c.hwSigner.exporting() {
+		return err
+	}
 
-type sweepTarget struct {
-	channelPoint        string
-	txid                chainhash.Hash
-	index               uint32
-	lockScript          []byte
-	value               int64
-	commitPoint         *btcec.PublicKey
-	revocationBasePoint *btcec.PublicKey
-	delayBasePointDesc  *keychain.KeyDescriptor
+	return results.print()
 }
 
-func sweepTimeLockFromSummary(extendedKey *hdkeychain.ExtendedKey, apiURL string,
+func sweepTimeLockFromSummary(signer walletSigner, api btc.SweepAPI,
 	entries []*dataformat.SummaryEntry, sweepAddr string,
-	maxCsvTimeout uint16, publish bool, feeRate uint16) error {
-
-	targets := make([]*sweepTarget, 0, len(entries))
-	for _, entry := range entries {
-		// Skip entries that can't be swept.
-		if entry.ForceClose == nil ||
-			(entry.ClosingTX != nil && entry.ClosingTX.AllOutsSpent) ||
-			entry.LocalBalance == 0 {
-
-			log.Infof("Not sweeping %s, info missing or all spent",
-				entry.ChannelPoint)
-
-			continue
-		}
-
-		fc := entry.ForceClose
-
-		// Find index of sweepable output of commitment TX.
-		txindex := -1
-		if len(fc.Outs) == 1 {
-			txindex = 0
-			if fc.Outs[0].Value != entry.LocalBalance {
-				log.Errorf("Potential value mismatch! %d vs "+
-					"%d (%s)",
-					fc.Outs[0].Value, entry.LocalBalance,
-					entry.ChannelPoint)
-			}
-		} else {
-			for idx, out := range fc.Outs {
-				if out.Value == entry.LocalBalance {
-					txindex = idx
-				}
-			}
-		}
-		if txindex == -1 {
-			log.Errorf("Could not find sweep output for chan %s",
-				entry.ChannelPoint)
-			continue
-		}
+	maxCsvTimeout uint16, publish bool, feeRate uint16, hw *hwSigner,
+	results *txResults) error {
 
-		// Prepare sweep script parameters.
-		commitPoint, err := pubKeyFromHex(fc.CommitPoint)
-		if err != nil {
-			return fmt.Errorf("error parsing commit point: %w", err)
-		}
-		revBase, err := pubKeyFromHex(fc.RevocationBasePoint.PubKey)
-		if err != nil {
-			return fmt.Errorf("error parsing revocation base "+
-				"point: %w", err)
-		}
-		delayDesc, err := fc.DelayBasePoint.Desc()
-		if err != nil {
-			return fmt.Errorf("error parsing delay base point: %w",
-				err)
-		}
-
-		lockScript, err := hex.DecodeString(fc.Outs[txindex].Script)
-		if err != nil {
-			return fmt.Errorf("error parsing target script: %w",
-				err)
-		}
-
-		// Create the transaction input.
-		txHash, err := chainhash.NewHashFromStr(fc.TXID)
-		if err != nil {
-			return fmt.Errorf("error parsing tx hash: %w", err)
-		}
-
-		targets = append(targets, &sweepTarget{
-			channelPoint:        entry.ChannelPoint,
-			txid:                *txHash,
-			index:               uint32(txindex),
-			lockScript:          lockScript,
-			value:               int64(fc.Outs[txindex].Value),
-			commitPoint:         commitPoint,
-			revocationBasePoint: revBase,
-			delayBasePointDesc:  delayDesc,
-		})
+	targets, skipped, err := sweep.TimeLockTargets(entries, log)
+	if err != nil {
+		return err
 	}
 
-	return sweepTimeLock(
-		extendedKey, apiURL, targets, sweepAddr, maxCsvTimeout, publish,
-		feeRate,
-	)
-}
-
-func sweepTimeLock(extendedKey *hdkeychain.ExtendedKey, apiURL string,
-	targets []*sweepTarget, sweepAddr string, maxCsvTimeout uint16,
-	publish bool, feeRate uint16) error {
-
-	// Create signer and transaction template.
-	signer := &lnd.Signer{
-		ExtendedKey: extendedKey,
-		ChainParams: chainParams,
+	for _, s := range skipped {
+		results.skip(s.ChannelPoint, s.Reason)
 	}
-	api := &btc.ExplorerAPI{BaseURL: apiURL}
-
-	sweepTx := wire.NewMsgTx(2)
-	totalOutputValue := int64(0)
-	signDescs := make([]*input.SignDescriptor, 0)
-	var estimator input.TxWeightEstimator
 
-	for _, target := range targets {
-		// We can't rely on the CSV delay of the channel DB to be
-		// correct. But it doesn't cost us a lot to just brute force it.
-		csvTimeout, script, scriptHash, err := bruteForceDelay(
-			input.TweakPubKey(
-				target.delayBasePointDesc.PubKey,
-				target.commitPoint,
-			), input.DeriveRevocationPubkey(
-				target.revocationBasePoint,
-				target.commitPoint,
-			), target.lockScript, maxCsvTimeout,
-		)
-		if err != nil {
-			log.Errorf("Could not create matching script for %s "+
-				"or csv too high: %w", target.channelPoint, err)
-			continue
-		}
-
-		// Create the transaction input.
-		sweepTx.TxIn = append(sweepTx.TxIn, &wire.TxIn{
-			PreviousOutPoint: wire.OutPoint{
-				Hash:  target.txid,
-				Index: target.index,
-			},
-			Sequence: input.LockTimeToSequence(
-				false, uint32(csvTimeout),
-			),
-		})
-
-		// Create the sign descriptor for the input.
-		signDesc := &input.SignDescriptor{
-			KeyDesc: *target.delayBasePointDesc,
-			SingleTweak: input.SingleTweakBytes(
-				target.commitPoint,
-				target.delayBasePointDesc.PubKey,
-			),
-			WitnessScript: script,
-			Output: &wire.TxOut{
-				PkScript: scriptHash,
-				Value:    target.value,
-			},
-			HashType: txscript.SigHashAll,
-		}
-		totalOutputValue += target.value
-		signDescs = append(signDescs, signDesc)
-
-		// Account for the input weight.
-		estimator.AddWitnessInput(input.ToLocalTimeoutWitnessSize)
+	// Create signer and transaction template.
+	signer, err = hw.wrap(signer)
+	if err != nil {
+		return err
 	}
 
-	// Add our sweep destination output.
 	sweepScript, err := lnd.GetP2WPKHScript(sweepAddr, chainParams)
 	if err != nil {
 		return err
 	}
-	estimator.AddP2WKHOutput()
-
-	// Calculate the fee based on the given fee rate and our weight
-	// estimation.
-	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
-	totalFee := feeRateKWeight.FeeForWeight(int64(estimator.Weight()))
-
-	log.Infof("Fee %d sats of %d total amount (estimated weight %d)",
-		totalFee, totalOutputValue, estimator.Weight())
-
-	sweepTx.TxOut = []*wire.TxOut{{
-		Value:    totalOutputValue - int64(totalFee),
-		PkScript: sweepScript,
-	}}
 
-	// Sign the transaction now.
-	sigHashes := input.NewTxSigHashesV0Only(sweepTx)
-	for idx, desc := range signDescs {
-		desc.SigHashes = sigHashes
-		desc.InputIndex = idx
-		witness, err := input.CommitSpendTimeout(signer, desc, sweepTx)
-		if err != nil {
-			return err
-		}
-		sweepTx.TxIn[idx].Witness = witness
-	}
-
-	var buf bytes.Buffer
-	err = sweepTx.Serialize(&buf)
+	sweepTx, err := sweep.TimeLock(
+		signer, targets, sweepScript, maxCsvTimeout, feeRate, log,
+	)
 	if err != nil {
 		return err
 	}
 
-	// Publish TX.
-	if publish {
-		response, err := api.PublishTx(
-			hex.EncodeToString(buf.Bytes()),
-		)
-		if err != nil {
-			return err
+	if hw.exporting() {
+		return hw.export(sweepTx)
+	}
+
+	// Targets for which no matching script was found are not part of the
+	// transaction.
+	var inputs []*txResultInput
+	for _, target := range targets {
+		outpoint := wire.OutPoint{Hash: target.TxID, Index: target.Index}
+		if !txSpends(sweepTx, outpoint) {
+			results.skip(
+				target.ChannelPoint, "no matching script "+
+					"found, CSV delay too high",
+			)
+			continue
 		}
-		log.Infof("Published TX %s, response: %s",
-			sweepTx.TxHash().String(), response)
+
+		inputs = append(inputs, &txResultInput{
+			Outpoint:     outpoint.String(),
+			Value:        target.Value,
+			ChannelPoint: target.ChannelPoint,
+		})
 	}
 
-	log.Infof("Transaction: %x", buf.Bytes())
-	return nil
+	return results.addTx(api, sweepTx, inputs, publish)
 }
 
 func pubKeyFromHex(pubKeyHex string) (*btcec.PublicKey, error) {
@@ -338,32 +210,3 @@ func pubKeyFromHex(pubKeyHex string) (*btcec.PublicKey, error) {
 	}
 	return btcec.ParsePubKey(pointBytes)
 }
-
-func bruteForceDelay(delayPubkey, revocationPubkey *btcec.PublicKey,
-	targetScript []byte, maxCsvTimeout uint16) (int32, []byte, []byte,
-	error) {
-
-	if len(targetScript) != 34 {
-		return 0, nil, nil, fmt.Errorf("invalid target script: %s",
-			targetScript)
-	}
-	for i := uint16(0); i <= maxCsvTimeout; i++ {
-		s, err := input.CommitScriptToSelf(
-			uint32(i), delayPubkey, revocationPubkey,
-		)
-		if err != nil {
-			return 0, nil, nil, fmt.Errorf("error creating "+
-				"script: %w", err)
-		}
-		sh, err := input.WitnessScriptHash(s)
-		if err != nil {
-			return 0, nil, nil, fmt.Errorf("error hashing script: "+
-				"%w", err)
-		}
-		if bytes.Equal(targetScript[0:8], sh[0:8]) {
-			return int32(i), s, sh, nil
-		}
-	}
-	return 0, nil, nil, fmt.Errorf("csv timeout not found for target "+
-		"script %s", targetScript)
-}
@@ -1,8 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/hex"
+	"errors"
 	"fmt"
 
 	"github.com/btcsuite/btcd/btcec/v2"
@@ -12,6 +11,7 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/sweep"
 	"github.com/lightningnetwork/lnd/input"
 	"github.com/lightningnetwork/lnd/keychain"
 	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
@@ -26,7 +26,6 @@ const (
 )
 
 type sweepTimeLockManualCommand struct {
-	APIURL                    string
 	Publish                   bool
 	SweepAddr                 string
 	MaxCsvLimit               uint16
@@ -37,9 +36,12 @@ type sweepTimeLockManualCommand struct {
 	MaxNumChansTotal  uint16
 	MaxNumChanUpdates uint64
 
-	rootKey *rootKey
-	inputs  *inputFlags
-	cmd     *cobra.Command
+	chainAPI     *chainAPIFlags
+	rootKey      *rootKey
+	remoteSigner *remoteSigner
+	inputs       *inputFlags
+	hwSigner     *hwSigner
+	cmd          *cobra.Command
 }
 
 func newSweepTimeLockManualCommand() *cobra.Command {
@@ -58,19 +60,34 @@ then look up the value for RemoteChanCfg -> RevocationBasePoint -> PubKey.
 
 To get the value for --timelockaddr you must look up the channel's funding
 output on chain, then follow it to the force close output. The time locked
-address is always the one that's longer (because it's P2WSH and not P2PKH).`,
+address is always the one that's longer (because it's P2WSH and not P2PKH).
+
+If a bitcoind node is used with --bitcoindrpc, the time locked output is looked
+up by scanning the UTXO set, which can take a few minutes.
+
+If the seed isn't available on this machine because the node uses a remote
+signer, the keys can be derived and the transaction be signed by the remote
+signer lnd node through its gRPC interface with --remotesigner. Only channels
+that use the revocation root scheme of lnd v0.13.0-beta and later can be swept
+that way.`,
 		Example: `chantools sweeptimelockmanual \
 	--sweepaddr bc1q..... \
 	--timelockaddr bc1q............ \
 	--remoterevbasepoint 03xxxxxxx \
 	--feerate 10 \
+	--publish
+
+chantools sweeptimelockmanual \
+	--sweepaddr bc1q..... \
+	--timelockaddr bc1q............ \
+	--remoterevbasepoint 03xxxxxxx \
+	--remotesigner localhost:10009 \
+	--remotesignermacaroon ~/.lnd/data/chain/bitcoin/mainnet/admin.macaroon \
+	--feerate 10 \
 	--publish`,
 		RunE: cc.Execute,
 	}
-	cc.cmd.Flags().StringVar(
-		&cc.APIURL, "apiurl", defaultAPIURL, "API URL to use (must "+
-			"be esplora compatible)",
-	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
 	cc.cmd.Flags().BoolVar(
 		&cc.Publish, "publish", false, "publish sweep TX to the chain "+
 			"API instead of just printing the TX",
@@ -107,17 +124,14 @@ address is always the one that's longer (because it's P2WSH and not P2PKH).`,
 	)
 
 	cc.rootKey = newRootKey(cc.cmd, "deriving keys")
+	cc.remoteSigner = newRemoteSigner(cc.cmd)
 	cc.inputs = newInputFlags(cc.cmd)
+	cc.hwSigner = newHWSigner(cc.cmd)
 
 	return cc.cmd
 }
 
 func (c *sweepTimeLockManualCommand) Execute(_ *cobra.Command, _ []string) error {
-	extendedKey, err := c.rootKey.read()
-	if err != nil {
-		return fmt.Errorf("error reading root key: %w", err)
-	}
-
 	// Make sure the sweep and time lock addrs are set.
 	if c.SweepAddr == "" {
 		return fmt.Errorf("sweep addr is required")
@@ -134,17 +148,31 @@ func (c *sweepTimeLockManualCommand) Execute(_ *cobra.Command, _ []string) error
 			err)
 	}
 
+	signer, closeSigner, err := c.remoteSigner.walletSigner(
+		c.rootKey, c.hwSigner,
+	)
+	if err != nil {
+		return err
+	}
+	defer closeSigner()
+
+	api := c.chainAPI.api()
+	c.FeeRate, err = sweepFeeRate(c.cmd, api, c.FeeRate)
+	if err != nil {
+		return err
+	}
+
 	return sweepTimeLockManual(
-		extendedKey, c.APIURL, c.SweepAddr, c.TimeLockAddr,
+		signer, api, c.SweepAddr, c.TimeLockAddr,
 		remoteRevPoint, c.MaxCsvLimit, c.MaxNumChansTotal,
-		c.MaxNumChanUpdates, c.Publish, c.FeeRate,
+		c.MaxNumChanUpdates, c.Publish, c.FeeRate, c.hwSigner,
 	)
 }
 
-func sweepTimeLockManual(extendedKey *hdkeychain.ExtendedKey, apiURL string,
+func sweepTimeLockManual(signer walletSigner, api btc.SweepAPI,
 	sweepAddr, timeLockAddr string, remoteRevPoint *btcec.PublicKey,
 	maxCsvTimeout, maxNumChannels uint16, maxNumChanUpdates uint64,
-	publish bool, feeRate uint16) error {
+	publish bool, feeRate uint16, hw *hwSigner) error {
 
 	// First of all, we need to parse the lock addr and make sure we can
 	// brute force the script with the information we have. If not, we can't
@@ -154,17 +182,33 @@ func sweepTimeLockManual(extendedKey *hdkeychain.ExtendedKey, apiURL string,
 		return fmt.Errorf("invalid time lock addr: %w", err)
 	}
 
-	// We need to go through a lot of our keys so it makes sense to
-	// pre-derive the static part of our key path.
-	basePath, err := lnd.ParsePath(fmt.Sprintf(
-		keyBasePath, chainParams.HDCoinType,
-	))
-	if err != nil {
-		return fmt.Errorf("could not derive base path: %w", err)
-	}
-	baseKey, err := lnd.DeriveChildren(extendedKey, basePath)
-	if err != nil {
-		return fmt.Errorf("could not derive base key: %w", err)
+	// The remote signer derives the keys itself. With the seed, we need to
+	// go through a lot of our keys so it makes sense to pre-derive the
+	// static part of our key path.
+	var keyRing timeLockKeyRing
+	switch s := signer.(type) {
+	case *lnd.RemoteSigner:
+		keyRing = s
+
+	case *lnd.Signer:
+		if s.ExtendedKey == nil {
+			return fmt.Errorf("the root key is required to derive " +
+				"the revocation root")
+		}
+		basePath, err := lnd.ParsePath(fmt.Sprintf(
+			keyBasePath, chainParams.HDCoinType,
+		))
+		if err != nil {
+			return fmt.Errorf("could not derive base path: %w", err)
+		}
+		baseKey, err := lnd.DeriveFromRoot(s.ExtendedKey, basePath)
+		if err != nil {
+			return fmt.Errorf("could not derive base key: %w", err)
+		}
+		keyRing = &seedKeyRing{baseKey: baseKey}
+
+	default:
+		return fmt.Errorf("unsupported signer %T", signer)
 	}
 
 	// Go through all our keys now and try to find the ones that can derive
@@ -181,7 +225,7 @@ func sweepTimeLockManual(extendedKey *hdkeychain.ExtendedKey, apiURL string,
 	)
 	for i := uint16(0); i < maxNumChannels; i++ {
 		csvTimeout, script, scriptHash, commitPoint, delayDesc, err = tryKey(
-			baseKey, remoteRevPoint, maxCsvTimeout, lockScript,
+			keyRing, remoteRevPoint, maxCsvTimeout, lockScript,
 			uint32(i), maxNumChanUpdates,
 		)
 
@@ -202,11 +246,10 @@ func sweepTimeLockManual(extendedKey *hdkeychain.ExtendedKey, apiURL string,
 	}
 
 	// Create signer and transaction template.
-	signer := &lnd.Signer{
-		ExtendedKey: extendedKey,
-		ChainParams: chainParams,
+	signer, err = hw.wrap(signer)
+	if err != nil {
+		return err
 	}
-	api := &btc.ExplorerAPI{BaseURL: apiURL}
 
 	// We now know everything we need to construct the sweep transaction,
 	// except for what outpoint to sweep. We'll ask the chain API to give
@@ -278,179 +321,140 @@ func sweepTimeLockManual(extendedKey *hdkeychain.ExtendedKey, apiURL string,
 	}
 	sweepTx.TxIn[0].Witness = witness
 
-	var buf bytes.Buffer
-	err = sweepTx.Serialize(&buf)
-	if err != nil {
-		return err
+	if hw.exporting() {
+		return hw.export(sweepTx)
 	}
 
-	// Publish TX.
-	if publish {
-		response, err := api.PublishTx(
-			hex.EncodeToString(buf.Bytes()),
-		)
-		if err != nil {
-			return err
-		}
-		log.Infof("Published TX %s, response: %s",
-			sweepTx.TxHash().String(), response)
+	inputs := []*txResultInput{{
+		Outpoint: sweepTx.TxIn[0].PreviousOutPoint.String(),
+		Value:    sweepValue,
+		Addr:     timeLockAddr,
+	}}
+	results := newTxResults()
+	err = results.addTx(api, sweepTx, inputs, publish)
+	if err != nil {
+		return err
 	}
 
-	log.Infof("Transaction: %x", buf.Bytes())
-	return nil
+	return results.print()
 }
 
-func tryKey(baseKey *hdkeychain.ExtendedKey, remoteRevPoint *btcec.PublicKey,
-	maxCsvTimeout uint16, lockScript []byte, idx uint32,
-	maxNumChanUpdates uint64) (int32, []byte, []byte, *btcec.PublicKey,
-	*keychain.KeyDescriptor, error) {
-
-	// The easy part first, let's derive the delay base point.
-	delayPath := []uint32{
-		lnd.HardenedKey(uint32(keychain.KeyFamilyDelayBase)),
-		0, idx,
-	}
-	delayPrivKey, err := lnd.PrivKeyFromPath(baseKey, delayPath)
-	if err != nil {
-		return 0, nil, nil, nil, nil, err
-	}
+// timeLockKeyRing derives the keys that are needed to brute force the time
+// locked output of a channel.
+type timeLockKeyRing interface {
+	// DeriveKey returns the public key at the given key locator.
+	DeriveKey(keyLoc keychain.KeyLocator) (*keychain.KeyDescriptor, error)
+
+	// ShaChainRoot returns the revocation producer of the revocation root
+	// key at the given key locator. If multiSigPubKey is nil, the legacy
+	// scheme is used.
+	ShaChainRoot(keyLoc keychain.KeyLocator,
+		multiSigPubKey *btcec.PublicKey) (*shachain.RevocationProducer,
+		error)
+}
 
-	// Get the revocation base point first, so we can calculate our
-	// commit point. We start with the old way where the revocation index
-	// was the same as the other indices. This applies to all channels
-	// opened with versions prior to and including lnd v0.12.0-beta.
-	revPath := []uint32{
-		lnd.HardenedKey(uint32(
-			keychain.KeyFamilyRevocationRoot,
-		)), 0, idx,
-	}
-	revRoot, err := lnd.ShaChainFromPath(baseKey, revPath, nil)
-	if err != nil {
-		return 0, nil, nil, nil, nil, err
-	}
+// seedKeyRing is a timeLockKeyRing that derives the keys from the base key
+// m/1017'/<coin_type>' of the seed.
+type seedKeyRing struct {
+	baseKey *hdkeychain.ExtendedKey
+}
 
-	// We now have everything to brute force the lock script. This
-	// will take a long while as we both have to go through commit
-	// points and CSV values.
-	csvTimeout, script, scriptHash, commitPoint, err := bruteForceDelayPoint(
-		delayPrivKey.PubKey(), remoteRevPoint, revRoot, lockScript,
-		maxCsvTimeout, maxNumChanUpdates,
-	)
-	if err == nil {
-		return csvTimeout, script, scriptHash, commitPoint,
-			&keychain.KeyDescriptor{
-				PubKey: delayPrivKey.PubKey(),
-				KeyLocator: keychain.KeyLocator{
-					Family: keychain.KeyFamilyDelayBase,
-					Index:  idx,
-				},
-			}, nil
+// path returns the path of the key locator relative to the base key.
+func (s *seedKeyRing) path(keyLoc keychain.KeyLocator) []uint32 {
+	return []uint32{
+		lnd.HardenedKey(uint32(keyLoc.Family)), 0, keyLoc.Index,
 	}
+}
 
-	// We could not derive the secrets to sweep the to_local output using
-	// the old shachain root creation. Starting with lnd release
-	// v0.13.0-beta the index for the revocation path creating the shachain
-	// root changed. Now the shachain root is created using ECDH
-	// with the local multisig public key
-	// (for mainnet: m/1017'/0'/1'/0/idx). But we need to account for a
-	// special case here. If the node was started with a version prior to
-	// and including v0.12.0-beta the idx for the new shachain root
-	// revocation is not one larger because idx 0 was already used for the
-	// old creation scheme hence we need to replicate this behaviour here.
-	// First trying the shachain root creation with the same index and if
-	// this does not derive the secrets we increase the index of the
-	// revocation key path by one (for mainnet: m/1017'/0'/5'/0/idx+1).
-	// The exact path which was used for the shachain root can be seen
-	// in the channel.backup file for every specific channel. The old
-	// scheme has always a public key specified.The new one uses a key
-	// locator and does not have a public key specified (nil).
-	// Example
-	//     ShaChainRootDesc: (dump.KeyDescriptor) {
-	// 	Path: (string) (len=17) "m/1017'/1'/5'/0/1",
-	// 	PubKey: (string) (len=5) "<nil>"
-	//
-	// For more details:
-	// https://github.com/lightningnetwork/lnd/commit/bb84f0ebc88620050dec7cf4be6283f5cba8b920
-	//
-	// Now the new shachain root revocation scheme is tried with
-	// two different indicies as described above.
-	revPath2 := []uint32{
-		lnd.HardenedKey(uint32(
-			keychain.KeyFamilyRevocationRoot,
-		)), 0, idx,
-	}
+// DeriveKey returns the public key at the given key locator.
+func (s *seedKeyRing) DeriveKey(
+	keyLoc keychain.KeyLocator) (*keychain.KeyDescriptor, error) {
 
-	// Now we try the same with the new revocation producer format.
-	multiSigPath := []uint32{
-		lnd.HardenedKey(uint32(keychain.KeyFamilyMultiSig)),
-		0, idx,
-	}
-	multiSigPrivKey, err := lnd.PrivKeyFromPath(baseKey, multiSigPath)
+	privKey, err := lnd.PrivKeyFromPath(s.baseKey, s.path(keyLoc))
 	if err != nil {
-		return 0, nil, nil, nil, nil, err
+		return nil, err
 	}
 
-	revRoot2, err := lnd.ShaChainFromPath(
-		baseKey, revPath2, multiSigPrivKey.PubKey(),
-	)
-	if err != nil {
-		return 0, nil, nil, nil, nil, err
-	}
+	return &keychain.KeyDescriptor{
+		KeyLocator: keyLoc,
+		PubKey:     privKey.PubKey(),
+	}, nil
+}
 
-	csvTimeout, script, scriptHash, commitPoint, err = bruteForceDelayPoint(
-		delayPrivKey.PubKey(), remoteRevPoint, revRoot2, lockScript,
-		maxCsvTimeout, maxNumChanUpdates,
-	)
-	if err == nil {
-		return csvTimeout, script, scriptHash, commitPoint,
-			&keychain.KeyDescriptor{
-				PubKey: delayPrivKey.PubKey(),
-				KeyLocator: keychain.KeyLocator{
-					Family: keychain.KeyFamilyDelayBase,
-					Index:  idx,
-				},
-			}, nil
-	}
+// ShaChainRoot returns the revocation producer of the revocation root key at
+// the given key locator.
+func (s *seedKeyRing) ShaChainRoot(keyLoc keychain.KeyLocator,
+	multiSigPubKey *btcec.PublicKey) (*shachain.RevocationProducer, error) {
 
-	// Now we try to increase the index by 1 to account for the situation
-	// where the node was started with a version after (including)
-	// v0.13.0-beta
-	revPath3 := []uint32{
-		lnd.HardenedKey(uint32(
-			keychain.KeyFamilyRevocationRoot,
-		)), 0, idx + 1,
-	}
+	return lnd.ShaChainFromPath(s.baseKey, s.path(keyLoc), multiSigPubKey)
+}
 
-	// Now we try the same with the new revocation producer format.
-	multiSigPath = []uint32{
-		lnd.HardenedKey(uint32(keychain.KeyFamilyMultiSig)),
-		0, idx,
-	}
-	multiSigPrivKey, err = lnd.PrivKeyFromPath(baseKey, multiSigPath)
+func tryKey(keyRing timeLockKeyRing, remoteRevPoint *btcec.PublicKey,
+	maxCsvTimeout uint16, lockScript []byte, idx uint32,
+	maxNumChanUpdates uint64) (int32, []byte, []byte, *btcec.PublicKey,
+	*keychain.KeyDescriptor, error) {
+
+	// The easy part first, let's derive the delay base point.
+	delayDesc, err := keyRing.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyDelayBase,
+		Index:  idx,
+	})
 	if err != nil {
 		return 0, nil, nil, nil, nil, err
 	}
-
-	revRoot3, err := lnd.ShaChainFromPath(
-		baseKey, revPath3, multiSigPrivKey.PubKey(),
-	)
+	multiSigDesc, err := keyRing.DeriveKey(keychain.KeyLocator{
+		Family: keychain.KeyFamilyMultiSig,
+		Index:  idx,
+	})
 	if err != nil {
 		return 0, nil, nil, nil, nil, err
 	}
 
-	csvTimeout, script, scriptHash, commitPoint, err = bruteForceDelayPoint(
-		delayPrivKey.PubKey(), remoteRevPoint, revRoot3, lockScript,
-		maxCsvTimeout, maxNumChanUpdates,
-	)
-	if err == nil {
-		return csvTimeout, script, scriptHash, commitPoint,
-			&keychain.KeyDescriptor{
-				PubKey: delayPrivKey.PubKey(),
-				KeyLocator: keychain.KeyLocator{
-					Family: keychain.KeyFamilyDelayBase,
-					Index:  idx,
-				},
-			}, nil
+	// We need the revocation root to calculate our commit points. Up to
+	// and including lnd v0.12.0-beta, the revocation root key itself was
+	// the root, at the same index as the other keys. Starting with lnd
+	// v0.13.0-beta the root is created using ECDH between the revocation
+	// root key and the local multisig public key. Because index 0 was
+	// already used by the old scheme, nodes that were started with an old
+	// version use the same index for the new scheme, while nodes that were
+	// started with v0.13.0-beta or later use an index that is one larger.
+	// The exact path can be seen in the ShaChainRootDesc of the channel in
+	// the channel.backup file, the old scheme has a public key specified.
+	//
+	// For more details:
+	// https://github.com/lightningnetwork/lnd/commit/bb84f0ebc88620050dec7cf4be6283f5cba8b920
+	candidates := []struct {
+		revIndex       uint32
+		multiSigPubKey *btcec.PublicKey
+	}{
+		{revIndex: idx},
+		{revIndex: idx, multiSigPubKey: multiSigDesc.PubKey},
+		{revIndex: idx + 1, multiSigPubKey: multiSigDesc.PubKey},
+	}
+	for _, candidate := range candidates {
+		revRoot, err := keyRing.ShaChainRoot(keychain.KeyLocator{
+			Family: keychain.KeyFamilyRevocationRoot,
+			Index:  candidate.revIndex,
+		}, candidate.multiSigPubKey)
+		if errors.Is(err, lnd.ErrLegacyRevocationRoot) {
+			continue
+		}
+		if err != nil {
+			return 0, nil, nil, nil, nil, err
+		}
+
+		// We now have everything to brute force the lock script. This
+		// will take a long while as we both have to go through commit
+		// points and CSV values.
+		csvTimeout, script, scriptHash, commitPoint, err :=
+			bruteForceDelayPoint(
+				delayDesc.PubKey, remoteRevPoint, revRoot,
+				lockScript, maxCsvTimeout, maxNumChanUpdates,
+			)
+		if err == nil {
+			return csvTimeout, script, scriptHash, commitPoint,
+				delayDesc, nil
+		}
 	}
 
 	return 0, nil, nil, nil, nil, fmt.Errorf("target script not derived")
@@ -468,7 +472,7 @@ func bruteForceDelayPoint(delayBase, revBase *btcec.PublicKey,
 		}
 		commitPoint := input.ComputeCommitmentPoint(revPreimage[:])
 
-		csvTimeout, script, scriptHash, err := bruteForceDelay(
+		csvTimeout, script, scriptHash, err := sweep.BruteForceDelay(
 			input.TweakPubKey(delayBase, commitPoint),
 			input.DeriveRevocationPubkey(revBase, commitPoint),
 			lockScript, maxCsvTimeout,
@@ -86,8 +86,8 @@ func TestSweepTimeLockManual(t *testing.T) {
 		revPubKey, _ := btcec.ParsePubKey(revPubKeyBytes)
 
 		_, _, _, _, _, err = tryKey(
-			baseKey, revPubKey, defaultCsvLimit, lockScript,
-			tc.keyIndex, 500,
+			&seedKeyRing{baseKey: baseKey}, revPubKey,
+			defaultCsvLimit, lockScript, tc.keyIndex, 500,
 		)
 		require.NoError(t, err)
 	}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/guggero/chantools/sweep"
+	"github.com/spf13/cobra"
+)
+
+const (
+	sweepWalletDefaultGapLimit = 50
+)
+
+// walletSweepAPI is the chain backend the sweepwallet command needs to
+// discover the wallet addresses and to publish the sweep transaction.
+type walletSweepAPI interface {
+	btc.SweepAPI
+	sweep.WalletAPI
+}
+
+type sweepWalletCommand struct {
+	GapLimit  uint32
+	Publish   bool
+	SweepAddr string
+	FeeRate   uint16
+
+	explorerAPI *explorerAPIFlags
+	rootKey     *rootKey
+	cmd         *cobra.Command
+}
+
+func newSweepWalletCommand() *cobra.Command {
+	cc := &sweepWalletCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "sweepwallet",
+		Short: "Sweep all funds of the lnd on-chain wallet to a " +
+			"given address without running lnd",
+		Long: `This command sweeps the on-chain wallet of an lnd node
+directly from its seed, for cases where restoring lnd just to empty the wallet
+is overkill. Funds in channels are not touched.
+
+The addresses of the receive and change branches of all lnd wallet accounts
+are derived and looked up with the block explorer API:
+ - m/44'/<coin_type>'/0' (legacy, only used by old lnd versions)
+ - m/49'/<coin_type>'/0' (nested SegWit receive, native SegWit change, old lnd
+   versions also used nested SegWit change)
+ - m/84'/<coin_type>'/0' (native SegWit)
+ - m/86'/<coin_type>'/0' (taproot)
+
+The discovery of each branch stops after --gaplimit consecutive indexes without
+any transactions. If not all expected funds are found, for example
+because many addresses were generated but never used, the gap limit needs to be
+increased.
+
+All unspent outputs found are swept to the given address in a single
+transaction. Unconfirmed outputs are included as well.`,
+		Example: `chantools sweepwallet \
+	--feerate 10 \
+	--sweepaddr bc1q..... \
+	--publish
+
+chantools sweepwallet --gaplimit 500 \
+	--sweepaddr bc1q.....`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().Uint32Var(
+		&cc.GapLimit, "gaplimit", sweepWalletDefaultGapLimit, "number "+
+			"of consecutive unused addresses after which the "+
+			"discovery of a branch of a wallet account stops",
+	)
+	cc.explorerAPI = newExplorerAPIFlags(cc.cmd)
+	cc.cmd.Flags().BoolVar(
+		&cc.Publish, "publish", false, "publish sweep TX to the chain "+
+			"API instead of just printing the TX",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.SweepAddr, "sweepaddr", "", "address to sweep the funds to",
+	)
+	cc.cmd.Flags().Uint16Var(
+		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
+			"use for the sweep transaction in sat/vByte",
+	)
+
+	cc.rootKey = newRootKey(cc.cmd, "sweeping the wallet")
+
+	return cc.cmd
+}
+
+func (c *sweepWalletCommand) Execute(_ *cobra.Command, _ []string) error {
+	extendedKey, err := c.rootKey.read()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+
+	// Make sure sweep addr is set.
+	if c.SweepAddr == "" {
+		return fmt.Errorf("sweep addr is required")
+	}
+
+	// Set default values.
+	if c.GapLimit == 0 {
+		c.GapLimit = sweepWalletDefaultGapLimit
+	}
+	c.FeeRate, err = sweepFeeRate(
+		c.cmd, c.explorerAPI.sweepAPI(), c.FeeRate,
+	)
+	if err != nil {
+		return err
+	}
+
+	return sweepWallet(
+		extendedKey, c.explorerAPI.api(), c.SweepAddr, c.GapLimit,
+		c.FeeRate, c.Publish,
+	)
+}
+
+func sweepWallet(extendedKey *hdkeychain.ExtendedKey, api walletSweepAPI,
+	sweepAddr string, gapLimit uint32, feeRate uint16, publish bool) error {
+
+	sweepScript, err := lnd.GetP2WPKHScript(sweepAddr, chainParams)
+	if err != nil {
+		return err
+	}
+
+	utxos, err := sweep.FindWalletUTXOs(
+		extendedKey, api, gapLimit, chainParams, log,
+	)
+	if err != nil {
+		return err
+	}
+	if len(utxos) == 0 {
+		return fmt.Errorf("no unspent wallet outputs found, try " +
+			"increasing the gap limit")
+	}
+
+	var (
+		inputs     = make([]*txResultInput, len(utxos))
+		numPending int
+	)
+	for idx, utxo := range utxos {
+		inputs[idx] = &txResultInput{
+			Outpoint: utxo.Outpoint.String(),
+			Value:    utxo.Value,
+			Addr:     utxo.Addr.EncodeAddress(),
+		}
+		if !utxo.Confirmed {
+			numPending++
+		}
+	}
+	log.Infof("Found %d unspent wallet outputs (%d unconfirmed)",
+		len(utxos), numPending)
+
+	signer := &lnd.Signer{
+		ExtendedKey: extendedKey,
+		ChainParams: chainParams,
+	}
+	packet, err := sweep.WalletPsbt(
+		utxos, sweepScript, feeRate,
+		lnd.MasterFingerprint(extendedKey), log,
+	)
+	if err != nil {
+		return err
+	}
+	signed, err := signer.SignPsbt(packet)
+	if err != nil {
+		return fmt.Errorf("error signing sweep TX: %w", err)
+	}
+	if len(signed) != len(utxos) {
+		return fmt.Errorf("only %d of %d inputs could be signed",
+			len(signed), len(utxos))
+	}
+	if err := psbt.MaybeFinalizeAll(packet); err != nil {
+		return fmt.Errorf("error finalizing sweep TX: %w", err)
+	}
+	sweepTx, err := psbt.Extract(packet)
+	if err != nil {
+		return fmt.Errorf("error extracting sweep TX: %w", err)
+	}
+
+	results := newTxResults()
+	err = results.addTx(api, sweepTx, inputs, publish)
+	if err != nil {
+		return err
+	}
+
+	return results.print()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWalletChain is a fake regtest node that can also look up the
+// transactions and unspent outputs of an address.
+type fakeWalletChain struct {
+	*fakeRegtest
+}
+
+func (f *fakeWalletChain) paysTo(txOut *wire.TxOut, addr string) bool {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(
+		txOut.PkScript, chainParams,
+	)
+	return err == nil && len(addrs) == 1 && addrs[0].EncodeAddress() == addr
+}
+
+func (f *fakeWalletChain) AddressUsed(addr string) (bool, error) {
+	for _, tx := range f.txs {
+		for _, txOut := range tx.TxOut {
+			if f.paysTo(txOut, addr) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func (f *fakeWalletChain) UTXOs(addr string) ([]*btc.UTXO, error) {
+	var utxos []*btc.UTXO
+	for txid, tx := range f.txs {
+		for idx, txOut := range tx.TxOut {
+			outpoint := wire.OutPoint{
+				Hash:  tx.TxHash(),
+				Index: uint32(idx),
+			}
+			if !f.paysTo(txOut, addr) || f.spent(outpoint) {
+				continue
+			}
+
+			_, confirmed := f.confirmed[txid]
+			utxos = append(utxos, &btc.UTXO{
+				TXID:   txid,
+				Vout:   uint32(idx),
+				Value:  uint64(txOut.Value),
+				Status: &btc.Status{Confirmed: confirmed},
+			})
+		}
+	}
+
+	return utxos, nil
+}
+
+func (f *fakeWalletChain) RawTransaction(txid string) (*wire.MsgTx, error) {
+	tx, ok := f.txs[txid]
+	if !ok {
+		return nil, btc.ErrTxNotFound
+	}
+
+	return tx, nil
+}
+
+func (f *fakeWalletChain) spent(outpoint wire.OutPoint) bool {
+	for _, tx := range f.txs {
+		if txSpends(tx, outpoint) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestSweepWallet(t *testing.T) {
+	h := newHarness(t)
+
+	extendedKey, err := hdkeychain.NewKeyFromString(rootKeyAezeed)
+	require.NoError(t, err)
+
+	deriveAddr := func(path string,
+		addrType func(*hdkeychain.ExtendedKey) btcutil.Address) []byte {
+
+		parsedPath, err := lnd.ParsePath(path)
+		require.NoError(t, err)
+		key, err := lnd.DeriveChildren(extendedKey, parsedPath)
+		require.NoError(t, err)
+		pkScript, err := txscript.PayToAddrScript(addrType(key))
+		require.NoError(t, err)
+
+		return pkScript
+	}
+	p2wkh := func(key *hdkeychain.ExtendedKey) btcutil.Address {
+		pubKey, err := key.ECPubKey()
+		require.NoError(t, err)
+		addr, err := lnd.P2WKHAddr(pubKey, chainParams)
+		require.NoError(t, err)
+		return addr
+	}
+	np2wkh := func(key *hdkeychain.ExtendedKey) btcutil.Address {
+		pubKey, err := key.ECPubKey()
+		require.NoError(t, err)
+		addr, err := lnd.NP2WKHAddr(pubKey, chainParams)
+		require.NoError(t, err)
+		return addr
+	}
+	p2pkh := func(key *hdkeychain.ExtendedKey) btcutil.Address {
+		pubKey, err := key.ECPubKey()
+		require.NoError(t, err)
+		addr, err := lnd.P2PKHAddr(pubKey, chainParams)
+		require.NoError(t, err)
+		return addr
+	}
+	p2tr := func(key *hdkeychain.ExtendedKey) btcutil.Address {
+		pubKey, err := key.ECPubKey()
+		require.NoError(t, err)
+		addr, err := lnd.P2TRAddr(pubKey, chainParams)
+		require.NoError(t, err)
+		return addr
+	}
+
+	// Fund addresses of all accounts and branches, including the legacy
+	// account and the nested SegWit change address old lnd versions used.
+	// The spent output of the second address is still found, so the gap to
+	// the fourth address isn't too large. The address after a gap of 5
+	// unused addresses isn't found anymore.
+	chain := &fakeWalletChain{fakeRegtest: newFakeRegtest("regtest")}
+	chain.height = 10
+	fundingTx := wire.NewMsgTx(2)
+	fundingTx.AddTxIn(&wire.TxIn{})
+	for _, pkScript := range [][]byte{
+		deriveAddr("m/84'/1'/0'/0/0", p2wkh),
+		deriveAddr("m/84'/1'/0'/0/1", p2wkh),
+		deriveAddr("m/84'/1'/0'/0/4", p2wkh),
+		deriveAddr("m/84'/1'/0'/0/10", p2wkh),
+		deriveAddr("m/49'/1'/0'/0/2", np2wkh),
+		deriveAddr("m/49'/1'/0'/1/0", p2wkh),
+		deriveAddr("m/86'/1'/0'/1/3", p2tr),
+		deriveAddr("m/44'/1'/0'/0/0", p2pkh),
+		deriveAddr("m/49'/1'/0'/1/1", np2wkh),
+	} {
+		fundingTx.AddTxOut(&wire.TxOut{
+			Value:    50_000,
+			PkScript: pkScript,
+		})
+	}
+	chain.addTx(fundingTx)
+	chain.confirmed[fundingTx.TxHash().String()] = 1
+
+	spendTx := wire.NewMsgTx(2)
+	spendTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{
+		Hash:  fundingTx.TxHash(),
+		Index: 1,
+	}})
+	chain.addTx(spendTx)
+
+	// The sweep transaction is valid, otherwise it couldn't be published.
+	sweepAddr := "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"
+	require.NoError(t, sweepWallet(
+		extendedKey, chain, sweepAddr, 5, 10, true,
+	))
+	h.assertLogContains("Found 7 unspent wallet outputs (0 unconfirmed)")
+	h.assertLogContains("Published TX")
+
+	var sweepTx *wire.MsgTx
+	for _, tx := range chain.txs {
+		if len(tx.TxIn) > 1 {
+			sweepTx = tx
+		}
+	}
+	require.NotNil(t, sweepTx)
+	require.Len(t, sweepTx.TxIn, 7)
+	require.Len(t, sweepTx.TxOut, 1)
+	for _, txIn := range sweepTx.TxIn {
+		require.NotEqual(t, uint32(1), txIn.PreviousOutPoint.Index)
+		require.NotEqual(t, uint32(3), txIn.PreviousOutPoint.Index)
+	}
+	require.Less(t, sweepTx.TxOut[0].Value, int64(7*50_000))
+
+	// Nothing is left to sweep afterwards.
+	err = sweepWallet(extendedKey, chain, sweepAddr, 5, 10, false)
+	require.ErrorContains(t, err, "no unspent wallet outputs found")
+}
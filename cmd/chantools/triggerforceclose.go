@@ -7,10 +7,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/connmgr"
 	"github.com/btcsuite/btcd/wire"
-	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/lnd"
 	"github.com/lightningnetwork/lnd/brontide"
 	"github.com/lightningnetwork/lnd/keychain"
@@ -28,10 +28,9 @@ type triggerForceCloseCommand struct {
 	Peer         string
 	ChannelPoint string
 
-	APIURL string
-
-	rootKey *rootKey
-	cmd     *cobra.Command
+	explorerAPI *explorerAPIFlags
+	rootKey     *rootKey
+	cmd         *cobra.Command
 }
 
 func newTriggerForceCloseCommand() *cobra.Command {
@@ -40,6 +39,16 @@ func newTriggerForceCloseCommand() *cobra.Command {
 		Use: "triggerforceclose",
 		Short: "Connect to a peer and send a custom message to " +
 			"trigger a force close of the specified channel",
+		Long: `If the local channel state is lost, the remote peer needs to
+be convinced to force close the channel so the funds can be swept with the
+sweepremoteclosed command.
+
+This command opens a short lived p2p connection to the remote peer using the
+node identity key derived from the seed. It then sends an error message for the
+channel, followed by a channel_reestablish message that claims the lowest
+possible commitment state. Most implementations react to either of the messages
+by broadcasting their latest commitment transaction. The command then waits for
+the channel's funding output to be spent.`,
 		Example: `chantools triggerforceclose \
 	--peer 03abce...@xx.yy.zz.aa:9735 \
 	--channel_point abcdef01234...:x`,
@@ -54,10 +63,7 @@ func newTriggerForceCloseCommand() *cobra.Command {
 			"outpoint of the channel to trigger the force close "+
 			"of (<txid>:<txindex>)",
 	)
-	cc.cmd.Flags().StringVar(
-		&cc.APIURL, "apiurl", defaultAPIURL, "API URL to use (must "+
-			"be esplora compatible)",
-	)
+	cc.explorerAPI = newExplorerAPIFlags(cc.cmd)
 	cc.rootKey = newRootKey(cc.cmd, "deriving the identity key")
 
 	return cc.cmd
@@ -97,6 +103,9 @@ func (c *triggerForceCloseCommand) Execute(_ *cobra.Command, _ []string) error {
 	}
 	channelID := lnwire.NewChanIDFromOutPoint(outPoint)
 
+	peerPubKey := peerAddr.IdentityKey.SerializeCompressed()
+	log.Infof("Attempting to connect to peer %x as node %x, dial timeout "+
+		"is %v", peerPubKey, pubKey.SerializeCompressed(), dialTimeout)
 	conn, err := noiseDial(
 		identityECDH, peerAddr, &tor.ClearNet{}, dialTimeout,
 	)
@@ -104,8 +113,6 @@ func (c *triggerForceCloseCommand) Execute(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("error dialing peer: %w", err)
 	}
 
-	log.Infof("Attempting to connect to peer %x, dial timeout is %v",
-		pubKey.SerializeCompressed(), dialTimeout)
 	req := &connmgr.ConnReq{
 		Addr:      peerAddr,
 		Permanent: false,
@@ -115,15 +122,13 @@ func (c *triggerForceCloseCommand) Execute(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("error connecting to peer: %w", err)
 	}
 
-	log.Infof("Connection established to peer %x",
-		pubKey.SerializeCompressed())
+	log.Infof("Connection established to peer %x", peerPubKey)
 
 	// We'll wait until the peer is active.
 	select {
 	case <-p.ActiveSignal():
 	case <-p.QuitSignal():
-		return fmt.Errorf("peer %x disconnected",
-			pubKey.SerializeCompressed())
+		return fmt.Errorf("peer %x disconnected", peerPubKey)
 	}
 
 	// Channel ID (32 byte) + u16 for the data length (which will be 0).
@@ -144,10 +149,20 @@ func (c *triggerForceCloseCommand) Execute(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("error sending message: %w", err)
 	}
 
-	log.Infof("Message sent, waiting for force close transaction to " +
+	// Some implementations ignore errors for channels they consider to be
+	// active. But claiming that we've lost our state makes them force
+	// close the channel, as there is no other way for us to recover our
+	// funds.
+	log.Infof("Sending channel reestablish message to peer")
+	err = p.SendMessageLazy(true, newDataLossReestablish(channelID, pubKey))
+	if err != nil {
+		return fmt.Errorf("error sending message: %w", err)
+	}
+
+	log.Infof("Messages sent, waiting for force close transaction to " +
 		"appear in mempool")
 
-	api := &btc.ExplorerAPI{BaseURL: c.APIURL}
+	api := c.explorerAPI.api()
 	channelAddress, err := api.Address(c.ChannelPoint)
 	if err != nil {
 		return fmt.Errorf("error getting channel address: %w", err)
@@ -173,6 +188,21 @@ func (c *triggerForceCloseCommand) Execute(_ *cobra.Command, _ []string) error {
 	return nil
 }
 
+// newDataLossReestablish creates a channel_reestablish message that signals
+// the remote peer that we're at the very first commitment state. The
+// commitment point is irrelevant to the remote peer's decision to force close,
+// so any valid public key can be used.
+func newDataLossReestablish(chanID lnwire.ChannelID,
+	commitPoint *btcec.PublicKey) *lnwire.ChannelReestablish {
+
+	return &lnwire.ChannelReestablish{
+		ChanID:                    chanID,
+		NextLocalCommitHeight:     1,
+		RemoteCommitTailHeight:    0,
+		LocalUnrevokedCommitPoint: commitPoint,
+	}
+}
+
 func noiseDial(idKey keychain.SingleKeyECDH, lnAddr *lnwire.NetAddress,
 	netCfg tor.Net, timeout time.Duration) (*brontide.Conn, error) {
 
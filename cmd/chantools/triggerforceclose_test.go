@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataLossReestablish(t *testing.T) {
+	privKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	commitPoint := privKey.PubKey()
+	chanID := lnwire.NewChanIDFromOutPoint(&wire.OutPoint{Index: 1})
+
+	// The message must survive the round trip over the wire, as that's
+	// what the remote peer decodes.
+	var buf bytes.Buffer
+	_, err = lnwire.WriteMessage(
+		&buf, newDataLossReestablish(chanID, commitPoint), 0,
+	)
+	require.NoError(t, err)
+	msg, err := lnwire.ReadMessage(&buf, 0)
+	require.NoError(t, err)
+
+	reestablish, ok := msg.(*lnwire.ChannelReestablish)
+	require.True(t, ok)
+	require.Equal(t, chanID, reestablish.ChanID)
+
+	// We claim to be at the very first state and to never have received a
+	// revocation from the remote peer, which the peer can only interpret
+	// as data loss on our side if it is further along.
+	require.EqualValues(t, 1, reestablish.NextLocalCommitHeight)
+	require.EqualValues(t, 0, reestablish.RemoteCommitTailHeight)
+	require.Equal(t, [32]byte{}, reestablish.LastRemoteCommitSecret)
+	require.Equal(
+		t, commitPoint.SerializeCompressed(),
+		reestablish.LocalUnrevokedCommitPoint.SerializeCompressed(),
+	)
+}
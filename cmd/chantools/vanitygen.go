@@ -1,13 +1,14 @@
 package main
 
 import (
-	"bytes"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"math"
+	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,9 +23,19 @@ var (
 	nodeKeyDerivationPath = "m/1017'/%d'/%d'/0/0"
 )
 
+// vanityGenMatch is a seed with a node public key that matches one of the
+// patterns.
+type vanityGenMatch struct {
+	Pattern  string `json:"pattern"`
+	PubKey   string `json:"pubkey"`
+	Mnemonic string `json:"mnemonic"`
+}
+
 type vanityGenCommand struct {
-	Prefix  string
-	Threads uint8
+	Prefix   string
+	Patterns []string
+	Count    uint32
+	Threads  uint8
 
 	cmd *cobra.Command
 }
@@ -41,22 +52,44 @@ produces a node identity public key that starts with the given prefix.
 Example output:
 
 <pre>
-Running vanitygen on 8 threads. Prefix bit length is 17, expecting to approach
-probability p=1.0 after 131,072 seeds.
+Prefix bit length is 17, expecting to approach probability p=1.0 after 131,072
+seeds.
+Running vanitygen on 8 threads with 1 pattern(s).
 Tested 185k seeds, p=1.41296, speed=14k/s, elapsed=13s                          
 Looking for 022222, found pubkey: 022222f015540ddde9bdf7c95b24f1d44f7ea6ab69bec83d6fbe622296d64b51d6
 with seed: [ability roast pear stomach wink cable tube trumpet shy caught hunt
 someone border organ spoon only prepare calm silent million tobacco chaos normal
 phone]
 </pre>
+
+Instead of a prefix, one or more regular expressions can be specified with
+--pattern. They are matched against the hex encoded public key, so for example
+--pattern '^03(abc|def)' --pattern 'cafe$' looks for keys that start with 03abc
+or 03def or that end with cafe. Each match is reported with the pattern that
+matched. Use --count to continue searching after the first match, a count of 0
+means the search continues until the command is interrupted. With --json, the
+matches are only written once the search is complete, so a count of 0 can't be
+used.
 `,
-		Example: `chantools vanitygen --prefix 022222 --threads 8`,
-		RunE:    cc.Execute,
+		Example: `chantools vanitygen --prefix 022222 --threads 8
+
+chantools vanitygen --pattern '^03(abc|def)' --pattern 'cafe$' \
+	--count 0`,
+		RunE: cc.Execute,
 	}
 	cc.cmd.Flags().StringVar(
 		&cc.Prefix, "prefix", "", "hex encoded prefix to find in node "+
 			"public key",
 	)
+	cc.cmd.Flags().StringArrayVar(
+		&cc.Patterns, "pattern", nil, "regular expression to match "+
+			"against the hex encoded node public key; can be "+
+			"specified multiple times",
+	)
+	cc.cmd.Flags().Uint32Var(
+		&cc.Count, "count", 1, "number of matching keys to find "+
+			"before stopping; 0 means to search until interrupted",
+	)
 	cc.cmd.Flags().Uint8Var(
 		&cc.Threads, "threads", 4, "number of parallel threads",
 	)
@@ -65,21 +98,61 @@ phone]
 }
 
 func (c *vanityGenCommand) Execute(_ *cobra.Command, _ []string) error {
-	prefixBytes, err := hex.DecodeString(c.Prefix)
-	if err != nil {
-		return fmt.Errorf("hex decoding of prefix failed: %w", err)
+	if c.Prefix == "" && len(c.Patterns) == 0 {
+		return fmt.Errorf("either --prefix or --pattern is required")
 	}
+	if JSONOutput && c.Count == 0 {
+		return fmt.Errorf("--json requires a --count greater than 0")
+	}
+
+	// The prefix is just turned into a pattern as well. But because we
+	// know its length, we can calculate the expected number of tries.
+	var (
+		patterns []*regexp.Regexp
+		numTries float64
+	)
+	if c.Prefix != "" {
+		prefixBytes, err := hex.DecodeString(c.Prefix)
+		if err != nil {
+			return fmt.Errorf("hex decoding of prefix failed: %w",
+				err)
+		}
 
-	if len(prefixBytes) < 2 {
-		return fmt.Errorf("prefix must be at least 2 bytes")
+		if len(prefixBytes) < 2 {
+			return fmt.Errorf("prefix must be at least 2 bytes")
+		}
+		if len(prefixBytes) > 8 {
+			return fmt.Errorf("prefix too long, unlikely to find " +
+				"a key within billions of years")
+		}
+		if !(prefixBytes[0] == 0x02 || prefixBytes[0] == 0x03) {
+			return fmt.Errorf("prefix must start with 02 or 03 " +
+				"because it's an EC public key")
+		}
+
+		patterns = append(patterns, regexp.MustCompile(
+			"^"+hex.EncodeToString(prefixBytes),
+		))
+
+		numBits := ((len(prefixBytes) - 1) * 8) + 1
+		numTries = math.Pow(2, float64(numBits))
+		fmt.Fprintf(textOut(), "Prefix bit length is %d, expecting "+
+			"to approach probability p=1.0 after %s seeds.\n",
+			numBits, format(int64(numTries)))
 	}
-	if len(prefixBytes) > 8 {
-		return fmt.Errorf("prefix too long, unlikely to find a key " +
-			"within billions of years")
+	for _, pattern := range c.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern '%s': %w", pattern,
+				err)
+		}
+		patterns = append(patterns, re)
 	}
-	if !(prefixBytes[0] == 0x02 || prefixBytes[0] == 0x03) {
-		return fmt.Errorf("prefix must start with 02 or 03 because " +
-			"it's an EC public key")
+
+	// We can only tell the probability if we're only looking for a
+	// prefix.
+	if len(patterns) > 1 {
+		numTries = 0
 	}
 
 	path, err := lnd.ParsePath(fmt.Sprintf(
@@ -90,16 +163,15 @@ func (c *vanityGenCommand) Execute(_ *cobra.Command, _ []string) error {
 		return err
 	}
 
-	numBits := ((len(prefixBytes) - 1) * 8) + 1
-	numTries := math.Pow(2, float64(numBits))
-	fmt.Printf("Running vanitygen on %d threads. Prefix bit length is %d, "+
-		"expecting to approach\nprobability p=1.0 after %s seeds.\n",
-		c.Threads, numBits, format(int64(numTries)))
+	fmt.Fprintf(textOut(), "Running vanitygen on %d threads with %d "+
+		"pattern(s).\n", c.Threads, len(patterns))
 	runtime.GOMAXPROCS(int(c.Threads))
 	var (
 		mtx         sync.Mutex
 		globalCount uint64
+		matches     []*vanityGenMatch
 		abort       = make(chan struct{})
+		abortOnce   sync.Once
 		start       = time.Now()
 	)
 
@@ -129,9 +201,15 @@ func (c *vanityGenCommand) Execute(_ *cobra.Command, _ []string) error {
 				if err != nil {
 					log.Error(err)
 				}
-				pubKeyBytes := rootKey.PubKeyBytes()
+				pubKeyHex := hex.EncodeToString(
+					rootKey.PubKeyBytes(),
+				)
+
+				for _, pattern := range patterns {
+					if !pattern.MatchString(pubKeyHex) {
+						continue
+					}
 
-				if bytes.HasPrefix(pubKeyBytes, prefixBytes) {
 					seed, err := aezeed.New(
 						aezeed.CipherSeedVersion,
 						&entropy, time.Now(),
@@ -143,13 +221,33 @@ func (c *vanityGenCommand) Execute(_ *cobra.Command, _ []string) error {
 					if err != nil {
 						log.Error(err)
 					}
-					fmt.Printf("\nLooking for %x, found "+
-						"pubkey: %x\nwith seed: %v\n",
-						prefixBytes, pubKeyBytes,
-						mnemonic)
 
-					close(abort)
-					return
+					match := &vanityGenMatch{
+						Pattern: pattern.String(),
+						PubKey:  pubKeyHex,
+						Mnemonic: strings.Join(
+							mnemonic[:], " ",
+						),
+					}
+
+					mtx.Lock()
+					fmt.Fprintf(textOut(), "\nLooking for "+
+						"%s, found pubkey: %s\nwith "+
+						"seed: %v\n", pattern,
+						pubKeyHex, mnemonic)
+
+					matches = append(matches, match)
+					numFound := uint32(len(matches))
+					if c.Count > 0 && numFound >= c.Count {
+						abortOnce.Do(func() {
+							close(abort)
+						})
+					}
+					mtx.Unlock()
+
+					// Report each key only once, even if
+					// it matches multiple patterns.
+					break
 				}
 
 				if count > 0 && count%100 == 0 {
@@ -167,20 +265,32 @@ func (c *vanityGenCommand) Execute(_ *cobra.Command, _ []string) error {
 	for {
 		select {
 		case <-abort:
-			return nil
+			mtx.Lock()
+			defer mtx.Unlock()
+
+			// Other threads might have found more keys before they
+			// noticed the abort.
+			return printJSON(matches[:c.Count])
+
 		case <-time.After(1 * time.Second):
 			mtx.Lock()
 			currentCount := globalCount
+			found := len(matches)
 			mtx.Unlock()
 
-			msg := fmt.Sprintf("Tested %sk seeds, p=%.5f, "+
-				"speed=%dk/s, elapsed=%v",
-				format(int64(currentCount/1000)),
-				float64(currentCount)/numTries,
-				(currentCount-lastCount)/1000,
-				time.Since(start).Truncate(time.Second),
-			)
-			fmt.Printf("\r%-80s", msg)
+			tested := format(int64(currentCount / 1000))
+			speed := (currentCount - lastCount) / 1000
+			elapsed := time.Since(start).Truncate(time.Second)
+			msg := fmt.Sprintf("Tested %sk seeds, found %d, "+
+				"speed=%dk/s, elapsed=%v", tested, found,
+				speed, elapsed)
+			if numTries > 0 {
+				msg = fmt.Sprintf("Tested %sk seeds, p=%.5f, "+
+					"speed=%dk/s, elapsed=%v", tested,
+					float64(currentCount)/numTries, speed,
+					elapsed)
+			}
+			fmt.Fprintf(textOut(), "\r%-80s", msg)
 
 			lastCount = currentCount
 		}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/aezeed"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVanityGenJSON(t *testing.T) {
+	h := newHarness(t)
+
+	vanityGen := &vanityGenCommand{
+		Patterns: []string{"^03", "^02"},
+		Count:    2,
+		Threads:  2,
+	}
+
+	// With --json only the matches are written to stdout.
+	h.captureJSON()
+	require.NoError(t, vanityGen.Execute(nil, nil))
+	var matches []*vanityGenMatch
+	h.assertJSONOutput(&matches)
+	require.Len(t, matches, 2)
+
+	// The node public key of each seed must match the reported pattern.
+	path := fmt.Sprintf(
+		nodeKeyDerivationPath, chainParams.HDCoinType,
+		keychain.KeyFamilyNodeKey,
+	)
+	for _, match := range matches {
+		require.True(t, strings.HasPrefix(
+			match.PubKey, strings.TrimPrefix(match.Pattern, "^"),
+		))
+
+		var mnemonic aezeed.Mnemonic
+		copy(mnemonic[:], strings.Split(match.Mnemonic, " "))
+		seed, err := mnemonic.ToCipherSeed(nil)
+		require.NoError(t, err)
+		extendedKey, err := hdkeychain.NewMaster(
+			seed.Entropy[:], chainParams,
+		)
+		require.NoError(t, err)
+		_, pubKey, _, err := lnd.DeriveKey(
+			extendedKey, path, chainParams,
+		)
+		require.NoError(t, err)
+		require.Equal(
+			t, match.PubKey,
+			hex.EncodeToString(pubKey.SerializeCompressed()),
+		)
+	}
+
+	// Searching until interrupted can't be combined with --json.
+	vanityGen.Count = 0
+	require.ErrorContains(
+		t, vanityGen.Execute(nil, nil), "requires a --count",
+	)
+}
+
+func TestVanityGenPatterns(t *testing.T) {
+	h := newHarness(t)
+
+	// The prefix is combined with regular expressions that don't only
+	// match the beginning of the key.
+	vanityGen := &vanityGenCommand{
+		Prefix:   "02aa",
+		Patterns: []string{"[ab]$", "^03.*(0f|f0)"},
+		Count:    5,
+		Threads:  2,
+	}
+	h.captureJSON()
+	require.NoError(t, vanityGen.Execute(nil, nil))
+	var matches []*vanityGenMatch
+	h.assertJSONOutput(&matches)
+	require.Len(t, matches, 5)
+
+	seen := make(map[string]struct{}, len(matches))
+	for _, match := range matches {
+		require.Contains(
+			t, []string{"^02aa", "[ab]$", "^03.*(0f|f0)"},
+			match.Pattern,
+		)
+		require.Regexp(t, match.Pattern, match.PubKey)
+
+		// Each key is only reported once, even if it matches multiple
+		// patterns.
+		require.NotContains(t, seen, match.PubKey)
+		seen[match.PubKey] = struct{}{}
+	}
+
+	vanityGen.Patterns = []string{"^03(ab"}
+	require.ErrorContains(
+		t, vanityGen.Execute(nil, nil), "invalid pattern '^03(ab'",
+	)
+
+	vanityGen.Prefix = ""
+	vanityGen.Patterns = nil
+	require.ErrorContains(
+		t, vanityGen.Execute(nil, nil),
+		"either --prefix or --pattern is required",
+	)
+}
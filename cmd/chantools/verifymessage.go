@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/spf13/cobra"
+	"github.com/tv42/zbase32"
+)
+
+const verifyMessageFormat = `
+Message:	%s
+Signature:	%s
+Public key:	%s
+`
+
+// verifyMessageResult is the JSON result of the verifymessage command.
+type verifyMessageResult struct {
+	Msg       string `json:"msg"`
+	Signature string `json:"signature"`
+	PubKey    string `json:"pubkey"`
+}
+
+type verifyMessageCommand struct {
+	Msg    string
+	Sig    string
+	PubKey string
+
+	cmd *cobra.Command
+}
+
+func newVerifyMessageCommand() *cobra.Command {
+	cc := &verifyMessageCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "verifymessage",
+		Short: "Verify a message signed with a node identity key, the " +
+			"same way lnd does",
+		Long: `Verifies a zbase32 encoded signature over the given message
+as created by lnd's or chantools' signmessage command and prints the public key
+of the node that signed it.
+
+Every well-formed signature results in some public key, so the printed key must
+be compared to the expected node public key. If --pubkey is set, this is done
+automatically and the command fails if the message wasn't signed by that key.`,
+		Example: `chantools verifymessage --msg "I own this node" \
+	--sig d7ht... \
+	--pubkey 03abcd...`,
+		RunE: cc.Execute,
+	}
+	cc.cmd.Flags().StringVar(
+		&cc.Msg, "msg", "", "the message that was signed",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.Sig, "sig", "", "the zbase32 encoded signature",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.PubKey, "pubkey", "", "optional hex encoded node public "+
+			"key the message is expected to be signed by",
+	)
+
+	return cc.cmd
+}
+
+func (c *verifyMessageCommand) Execute(_ *cobra.Command, _ []string) error {
+	if c.Msg == "" || c.Sig == "" {
+		return fmt.Errorf("message and signature are required")
+	}
+
+	sig, err := zbase32.DecodeString(c.Sig)
+	if err != nil {
+		return fmt.Errorf("error decoding signature: %w", err)
+	}
+
+	// The signature is over the double SHA256 hash of the prefixed
+	// message. Recovering the public key also validates the signature.
+	msg := []byte(signedMsgPrefix + c.Msg)
+	pubKey, _, err := ecdsa.RecoverCompact(sig, chainhash.DoubleHashB(msg))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	pubKeyHex := hex.EncodeToString(pubKey.SerializeCompressed())
+
+	if c.PubKey != "" && !strings.EqualFold(c.PubKey, pubKeyHex) {
+		return fmt.Errorf("message was signed by %s and not by %s",
+			pubKeyHex, c.PubKey)
+	}
+
+	if JSONOutput {
+		return printJSON(&verifyMessageResult{
+			Msg:       c.Msg,
+			Signature: c.Sig,
+			PubKey:    pubKeyHex,
+		})
+	}
+
+	result := fmt.Sprintf(verifyMessageFormat, c.Msg, c.Sig, pubKeyHex)
+	fmt.Fprintln(textOut(), result)
+
+	// For the tests, also log as trace level which is disabled by default.
+	log.Tracef(result)
+
+	return nil
+}
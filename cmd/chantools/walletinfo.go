@@ -74,11 +74,15 @@ latter can be useful to recover funds from a wallet if the wallet password is
 still known but the seed was lost. **The 24 word seed phrase itself cannot be
 extracted** because it is hashed into the extended HD root key before storing it
 in the wallet.db.
+The extracted root key can be passed to all other commands that need the seed,
+either with the --rootkey flag or the ROOT_KEY environment variable.
 In case lnd was started with "--noseedbackup=true" your wallet has the default
 password. To unlock the wallet set the environment variable WALLET_PASSWORD="-"
 or simply press <enter> without entering a password when being prompted.`,
 		Example: `chantools walletinfo --withrootkey \
-	--walletdb ~/.lnd/data/chain/bitcoin/mainnet/wallet.db`,
+	--walletdb ~/.lnd/data/chain/bitcoin/mainnet/wallet.db
+
+chantools genimportscript --rootkey xprv...`,
 		RunE: cc.Execute,
 	}
 	cc.cmd.Flags().StringVar(
@@ -187,7 +191,7 @@ func (c *walletInfoCommand) Execute(_ *cobra.Command, _ []string) error {
 		scopeInfo,
 	)
 
-	fmt.Println(result)
+	_, _ = fmt.Fprintln(resultOut, result)
 
 	// For the tests, also log as trace level which is disabled by default.
 	log.Tracef(result)
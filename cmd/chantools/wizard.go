@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/spf13/cobra"
+)
+
+type wizardCommand struct {
+	rootKey *rootKey
+	cmd     *cobra.Command
+}
+
+func newWizardCommand() *cobra.Command {
+	cc := &wizardCommand{}
+	cc.cmd = &cobra.Command{
+		Use: "wizard",
+		Short: "Guided recovery that asks what data is available and " +
+			"runs the matching commands",
+		Long: `Starts an interactive wizard in the terminal that asks
+which data of the node is still available (seed, channel.db, channel.backup
+file or only transaction IDs) and then runs the commands that apply to the
+situation, for example summary, sweeptimelock, sweepremoteclosed or
+rescueclosed.
+
+Every sweep transaction is shown before it is published and the wizard asks
+for a confirmation before publishing it.`,
+		Example: `chantools wizard`,
+		RunE:    cc.Execute,
+	}
+	cc.rootKey = newRootKey(cc.cmd, "the recovery")
+
+	return cc.cmd
+}
+
+func (c *wizardCommand) Execute(_ *cobra.Command, _ []string) error {
+	w := &wizard{
+		in:          bufio.NewReader(os.Stdin),
+		out:         os.Stdout,
+		resultsDir:  WorkDir,
+		run:         runChantools,
+		readRootKey: c.rootKey.read,
+	}
+
+	return w.start()
+}
+
+// runChantools runs the chantools command with the given arguments in the
+// current process. The logging and network are already set up by the wizard
+// command itself.
+func runChantools(args ...string) error {
+	cmd, flags, err := rootCmd.Find(args)
+	if err != nil {
+		return err
+	}
+	if cmd.RunE == nil {
+		return fmt.Errorf("command %s can't be run by the wizard",
+			cmd.Name())
+	}
+	if err := cmd.ParseFlags(flags); err != nil {
+		return err
+	}
+
+	cfg, err := readConfig(ConfigFile)
+	if err != nil {
+		return err
+	}
+	if err := applyConfig(cmd, cfg); err != nil {
+		return err
+	}
+
+	return cmd.RunE(cmd, cmd.Flags().Args())
+}
+
+// wizard guides the user through the recovery by asking questions.
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// resultsDir is the directory the summary file is written to.
+	resultsDir string
+
+	// run runs the chantools command with the given arguments.
+	run func(args ...string) error
+
+	// readRootKey reads the root key, prompting for the seed if needed.
+	readRootKey func() (*hdkeychain.ExtendedKey, error)
+
+	sweepAddr string
+	feeRate   string
+}
+
+// printf prints a message to the user.
+func (w *wizard) printf(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(w.out, format, args...)
+}
+
+// ask asks the user a question and returns the trimmed answer.
+func (w *wizard) ask(question string) (string, error) {
+	w.printf("%s ", question)
+	answer, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || answer == "") {
+		return "", fmt.Errorf("error reading answer: %w", err)
+	}
+
+	return strings.TrimSpace(answer), nil
+}
+
+// confirm asks the user a yes/no question.
+func (w *wizard) confirm(question string) (bool, error) {
+	for {
+		answer, err := w.ask(question + " [y/n]")
+		if err != nil {
+			return false, err
+		}
+
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return true, nil
+
+		case "n", "no":
+			return false, nil
+		}
+
+		w.printf("Please answer with y or n.\n")
+	}
+}
+
+// runStep asks the user whether to run the given command and runs it.
+func (w *wizard) runStep(description string, args ...string) (bool, error) {
+	w.printf("\n%s:\n  chantools %s\n", description,
+		strings.Join(args, " "))
+	ok, err := w.confirm("Run this command now?")
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if err := w.run(args...); err != nil {
+		return false, fmt.Errorf("error running %s: %w", args[0], err)
+	}
+
+	return true, nil
+}
+
+// sweepStep runs a sweep command without publishing the transaction first and
+// then asks whether the transaction should be published.
+func (w *wizard) sweepStep(description string, args ...string) error {
+	if w.sweepAddr == "" {
+		for w.sweepAddr == "" {
+			answer, err := w.ask("Enter the address to sweep the " +
+				"funds to (a P2WKH address of a wallet you " +
+				"control):")
+			if err != nil {
+				return err
+			}
+			w.sweepAddr = answer
+		}
+
+		answer, err := w.ask("Enter the fee rate in sat/vByte (leave " +
+			"empty to use the default):")
+		if err != nil {
+			return err
+		}
+		w.feeRate = answer
+	}
+
+	args = append(args, "--sweepaddr", w.sweepAddr)
+	if w.feeRate != "" {
+		args = append(args, "--feerate", w.feeRate)
+	}
+
+	ran, err := w.runStep(description, args...)
+	if err != nil || !ran {
+		return err
+	}
+
+	w.printf("\nThe transaction above was created but not published " +
+		"yet. Check that\nthe output goes to your address and that " +
+		"the fee is what you expect.\n")
+	publish, err := w.confirm("Publish the transaction now?")
+	if err != nil || !publish {
+		return err
+	}
+
+	args = append(args, "--publish")
+	if err := w.run(args...); err != nil {
+		return fmt.Errorf("error publishing: %w", err)
+	}
+	w.printf("Transaction published.\n")
+
+	return nil
+}
+
+// start runs the wizard.
+func (w *wizard) start() error {
+	w.printf("Welcome to the chantools recovery wizard!\n\nThis wizard " +
+		"asks you a few questions about the data you still have\n" +
+		"and then runs the commands needed to recover your funds.\n\n")
+
+	hasSeed, err := w.confirm("Do you have the 24 word seed (aezeed) " +
+		"of your lnd node?")
+	if err != nil {
+		return err
+	}
+
+	channelDB, err := w.ask("Enter the path to your channel.db file " +
+		"(leave empty if you don't have it):")
+	if err != nil {
+		return err
+	}
+
+	if !hasSeed {
+		w.printf("\nWithout the seed, no funds can be swept. The " +
+			"channel.db can still\nbe used to find out which " +
+			"channels are affected.\n")
+		if channelDB != "" {
+			_, err := w.runStep(
+				"Create a summary of all channels",
+				"summary", "--fromchanneldb", channelDB,
+			)
+			return err
+		}
+
+		return nil
+	}
+
+	// Read the seed only once and hand the root key to all commands run
+	// by the wizard.
+	extendedKey, err := w.readRootKey()
+	if err != nil {
+		return fmt.Errorf("error reading root key: %w", err)
+	}
+	presetRootKey = extendedKey
+	defer func() { presetRootKey = nil }()
+
+	if channelDB != "" {
+		return w.recoverFromChannelDB(channelDB)
+	}
+
+	hasBackup, err := w.confirm("Do you have a channel.backup file " +
+		"(static channel backup)?")
+	if err != nil {
+		return err
+	}
+	if hasBackup {
+		w.printf("\nRestore a new lnd node with your seed and the " +
+			"channel.backup file\n(lncli restorechanbackup). lnd " +
+			"then asks all your peers to force close\nthe " +
+			"channels. Once the channels are closed, the funds " +
+			"arrive in the lnd\nwallet. If lnd can't sweep them " +
+			"or you don't want to run lnd, the\nfunds of " +
+			"channels that were force closed by the remote " +
+			"party can be\nswept with sweepremoteclosed.\n")
+	} else {
+		w.printf("\nWithout a channel.db or channel.backup file, " +
+			"chantools scans all\naddresses the funds of " +
+			"channels force closed by the remote party can\nbe " +
+			"sent to, for all channel types that don't need " +
+			"the channel.db.\n")
+	}
+
+	return w.sweepStep(
+		"Sweep the funds of channels force closed by the remote party",
+		"sweepremoteclosed",
+	)
+}
+
+// recoverFromChannelDB creates a summary of all channels of the channel DB and
+// runs the commands that are needed for the channels found.
+func (w *wizard) recoverFromChannelDB(channelDB string) error {
+	summaryFileName := filepath.Join(w.resultsDir, fmt.Sprintf(
+		"wizard-summary-%s.json", time.Now().Format(
+			resultTimestampFormat,
+		),
+	))
+	ran, err := w.runStep(
+		"First, the state of all channels is looked up on chain",
+		"summary", "--fromchanneldb", channelDB, "--output",
+		summaryFileName,
+	)
+	if err != nil || !ran {
+		return err
+	}
+
+	summaryFile, err := readSummaryFile(summaryFileName)
+	if err != nil {
+		return err
+	}
+
+	var (
+		numOpen, numLocal int
+		remoteCommands    = make(map[string]int)
+	)
+	for _, entry := range summaryFile.Channels {
+		closingTx := entry.ClosingTX
+		switch {
+		case closingTx == nil:
+			numOpen++
+
+		case closingTx.AllOutsSpent || !closingTx.ForceClose:
+
+		case entry.ForceClose != nil &&
+			entry.ForceClose.TXID == closingTx.TXID:
+
+			numLocal++
+
+		default:
+			command := dataformat.SweepCommandForCommitmentType(
+				entry.CommitmentType,
+			)
+			if command != "" {
+				remoteCommands[command]++
+			}
+		}
+	}
+
+	w.printf("\nFound %d open channels, %d channels force closed by "+
+		"you and %d\nchannels force closed by the remote party with "+
+		"unspent outputs.\n", numOpen, numLocal,
+		remoteCommands["sweepremoteclosed"]+
+			remoteCommands["rescueclosed"])
+
+	if numLocal > 0 {
+		err := w.sweepStep(
+			"Sweep the time locked outputs of the channels you "+
+				"force closed (only\nworks once the CSV delay "+
+				"of the channels expired)",
+			"sweeptimelock", "--fromsummary", summaryFileName,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if remoteCommands["sweepremoteclosed"] > 0 {
+		err := w.sweepStep(
+			"Sweep the funds of channels force closed by the "+
+				"remote party",
+			"sweepremoteclosed",
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if remoteCommands["rescueclosed"] > 0 {
+		_, err := w.runStep(
+			"Find the private keys of legacy channels force "+
+				"closed by the remote party\n(they need to be "+
+				"imported into bitcoind to sweep the funds)",
+			"rescueclosed", "--channeldb", channelDB,
+			"--fromsummary", summaryFileName,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if numOpen > 0 {
+		w.printf("\n%d channels are still open. Ask the peers to "+
+			"force close them with\n'chantools triggerforceclose' "+
+			"or, if the peers are offline for good,\ntry "+
+			"'chantools zombierecovery'. Run the wizard again "+
+			"once the channels\nare closed.\n", numOpen)
+	}
+
+	return nil
+}
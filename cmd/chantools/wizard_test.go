@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWizardChannelDB(t *testing.T) {
+	h := newHarness(t)
+
+	summaryFile := &dataformat.SummaryEntryFile{
+		Channels: []*dataformat.SummaryEntry{{
+			ChannelPoint: "open",
+		}, {
+			ChannelPoint: "local",
+			ClosingTX: &dataformat.ClosingTX{
+				TXID:       "aa",
+				ForceClose: true,
+			},
+			ForceClose: &dataformat.ForceClose{
+				TXID: "aa",
+			},
+		}, {
+			ChannelPoint: "remote",
+			ClosingTX: &dataformat.ClosingTX{
+				TXID:       "bb",
+				ForceClose: true,
+			},
+			ForceClose: &dataformat.ForceClose{
+				TXID: "cc",
+			},
+			CommitmentType: dataformat.CommitmentTypeAnchors,
+		}, {
+			ChannelPoint: "legacy",
+			ClosingTX: &dataformat.ClosingTX{
+				TXID:       "dd",
+				ForceClose: true,
+			},
+			CommitmentType: dataformat.CommitmentTypeLegacy,
+		}, {
+			ChannelPoint: "spent",
+			ClosingTX: &dataformat.ClosingTX{
+				TXID:         "ee",
+				ForceClose:   true,
+				AllOutsSpent: true,
+			},
+			CommitmentType: dataformat.CommitmentTypeAnchors,
+		}},
+	}
+
+	var (
+		runs    [][]string
+		rootKey string
+	)
+	run := func(args ...string) error {
+		runs = append(runs, args)
+		rootKey = presetRootKey.String()
+
+		if args[0] != "summary" {
+			return nil
+		}
+
+		content, err := json.Marshal(summaryFile)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(args[len(args)-1], content, 0644)
+	}
+
+	answers := []string{
+		"y",            // Seed available.
+		"/tmp/chan.db", // Path to channel.db.
+		"y",            // Run summary.
+		"bcrt1qsweep",  // Sweep address.
+		"",             // Default fee rate.
+		"y",            // Run sweeptimelock.
+		"y",            // Publish sweeptimelock.
+		"maybe",        // Run sweepremoteclosed, invalid answer.
+		"y",            // Run sweepremoteclosed.
+		"n",            // Don't publish sweepremoteclosed.
+		"y",            // Run rescueclosed.
+	}
+	var out bytes.Buffer
+	w := &wizard{
+		in: bufio.NewReader(strings.NewReader(
+			strings.Join(answers, "\n") + "\n",
+		)),
+		out:        &out,
+		resultsDir: h.tempDir,
+		run:        run,
+		readRootKey: func() (*hdkeychain.ExtendedKey, error) {
+			return hdkeychain.NewKeyFromString(rootKeyAezeed)
+		},
+	}
+	require.NoError(t, w.start())
+
+	require.Len(t, runs, 5)
+	summaryFileName := runs[0][len(runs[0])-1]
+	require.Equal(t, []string{
+		"summary", "--fromchanneldb", "/tmp/chan.db", "--output",
+		summaryFileName,
+	}, runs[0])
+	require.Equal(t, []string{
+		"sweeptimelock", "--fromsummary", summaryFileName,
+		"--sweepaddr", "bcrt1qsweep",
+	}, runs[1])
+	require.Equal(t, append(runs[1], "--publish"), runs[2])
+	require.Equal(t, []string{
+		"sweepremoteclosed", "--sweepaddr", "bcrt1qsweep",
+	}, runs[3])
+	require.Equal(t, []string{
+		"rescueclosed", "--channeldb", "/tmp/chan.db",
+		"--fromsummary", summaryFileName,
+	}, runs[4])
+
+	// The root key is handed to the commands and removed again
+	// afterwards.
+	require.Equal(t, rootKeyAezeed, rootKey)
+	require.Nil(t, presetRootKey)
+
+	require.Contains(t, out.String(), "Found 1 open channels, 1 channels "+
+		"force closed by you and 2")
+	require.Contains(t, out.String(), "Please answer with y or n.")
+}
+
+func TestWizardNoSeed(t *testing.T) {
+	_ = newHarness(t)
+
+	var runs [][]string
+	var out bytes.Buffer
+	w := &wizard{
+		in:  bufio.NewReader(strings.NewReader("n\n/tmp/chan.db\nn\n")),
+		out: &out,
+		run: func(args ...string) error {
+			runs = append(runs, args)
+			return nil
+		},
+		readRootKey: func() (*hdkeychain.ExtendedKey, error) {
+			t.Fatalf("root key must not be read")
+			return nil, nil
+		},
+	}
+	require.NoError(t, w.start())
+
+	require.Empty(t, runs)
+	require.Contains(t, out.String(), "no funds can be swept")
+}
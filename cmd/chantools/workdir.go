@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lncfg"
+)
+
+const (
+	defaultWorkDir = "results"
+
+	// resultTimestampFormat is the format of the timestamp that is added
+	// to the name of result files.
+	resultTimestampFormat = "2006-01-02-15-04-05"
+)
+
+var (
+	// WorkDir is the directory all result files and the log file are
+	// written to.
+	WorkDir = defaultWorkDir
+
+	// NoTimestamp indicates that the names of result files shouldn't
+	// contain a timestamp, so the result of a command can always be
+	// found under the same name. Repeated runs overwrite the file.
+	NoTimestamp bool
+
+	// ResultFile is the file the result of a command is written to,
+	// overriding the default name in the working directory.
+	ResultFile string
+)
+
+// createWorkDir makes sure the working directory exists.
+func createWorkDir() error {
+	WorkDir = lncfg.CleanAndExpandPath(WorkDir)
+	if err := os.MkdirAll(WorkDir, 0700); err != nil {
+		return fmt.Errorf("error creating working directory %s: %w",
+			WorkDir, err)
+	}
+
+	return nil
+}
+
+// workDirFileName returns the name of a file with the given name prefix and
+// extension in the working directory. The name is <name>-<timestamp>.<ext>,
+// or <name>.<ext> if the global --notimestamp flag is used.
+func workDirFileName(name, ext string) string {
+	if !NoTimestamp {
+		name += "-" + time.Now().Format(resultTimestampFormat)
+	}
+
+	return filepath.Join(WorkDir, name+"."+ext)
+}
+
+// resultFileName returns the name of the file the main result of a command is
+// written to. This is the file given with the global --resultfile flag or a
+// file in the working directory named after the given name and extension.
+func resultFileName(name, ext string) string {
+	if ResultFile != "" {
+		return lncfg.CleanAndExpandPath(ResultFile)
+	}
+
+	return workDirFileName(name, ext)
+}
+
+// writeResultFile writes the result of a command to the given file. The result
+// is first written to a temporary file in the same directory that is only
+// renamed to the given name once write succeeded, so a failed run never leaves
+// an empty or partially written file behind.
+func writeResultFile(fileName string, write func(io.Writer) error) error {
+	dir, base := filepath.Split(fileName)
+	file, err := os.CreateTemp(dir, base+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+	tempName := file.Name()
+
+	if err := write(file); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tempName)
+
+		return err
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(tempName)
+
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+
+	// The temporary file is only readable by us, the result file should
+	// get the same permissions as if it was created directly.
+	if err := os.Chmod(tempName, 0644); err != nil {
+		_ = os.Remove(tempName)
+
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+	if err := os.Rename(tempName, fileName); err != nil {
+		_ = os.Remove(tempName)
+
+		return fmt.Errorf("error renaming output file: %w", err)
+	}
+
+	return nil
+}
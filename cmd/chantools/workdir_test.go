@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/btcsuite/btclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultFileName(t *testing.T) {
+	h := newHarness(t)
+
+	defer func() {
+		WorkDir = defaultWorkDir
+		NoTimestamp = false
+		ResultFile = ""
+	}()
+
+	WorkDir = filepath.Join(h.tempDir, "work", "dir")
+	require.NoError(t, createWorkDir())
+	require.DirExists(t, WorkDir)
+
+	pattern := regexp.MustCompile(
+		`^forceclose-\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2}\.json$`,
+	)
+	fileName := resultFileName("forceclose", "json")
+	require.Equal(t, WorkDir, filepath.Dir(fileName))
+	require.Regexp(t, pattern, filepath.Base(fileName))
+
+	NoTimestamp = true
+	require.Equal(
+		t, filepath.Join(WorkDir, "forceclose.json"),
+		resultFileName("forceclose", "json"),
+	)
+
+	// The result file overrides the main result only.
+	ResultFile = h.tempFile("result.json")
+	require.Equal(t, ResultFile, resultFileName("forceclose", "json"))
+	require.Equal(
+		t, filepath.Join(WorkDir, "rescuefunding-nonce.hex"),
+		workDirFileName("rescuefunding-nonce", "hex"),
+	)
+
+	// A file can't be used as the working directory.
+	WorkDir = ResultFile
+	require.NoError(t, os.WriteFile(WorkDir, nil, 0600))
+	require.ErrorContains(
+		t, createWorkDir(), "error creating working directory",
+	)
+}
+
+func TestWriteResultFile(t *testing.T) {
+	h := newHarness(t)
+
+	fileName := h.tempFile("summary.json")
+	err := writeResultFile(fileName, func(w io.Writer) error {
+		_, err := w.Write([]byte("result"))
+		return err
+	})
+	require.NoError(t, err)
+	content, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.Equal(t, "result", string(content))
+
+	// A failed run doesn't touch the existing result and doesn't leave
+	// any temporary file behind.
+	err = writeResultFile(fileName, func(w io.Writer) error {
+		_, _ = w.Write([]byte("partial"))
+		return errors.New("backend down")
+	})
+	require.ErrorContains(t, err, "backend down")
+	content, err = os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.Equal(t, "result", string(content))
+
+	otherFile := h.tempFile("other.json")
+	err = writeResultFile(otherFile, func(io.Writer) error {
+		return errors.New("backend down")
+	})
+	require.ErrorContains(t, err, "backend down")
+	require.NoFileExists(t, otherFile)
+
+	files, err := os.ReadDir(filepath.Dir(fileName))
+	require.NoError(t, err)
+	for _, file := range files {
+		require.NotContains(t, file.Name(), ".tmp-")
+	}
+}
+
+func TestLogOutput(t *testing.T) {
+	var console, other bytes.Buffer
+	out := &logOutput{console: &console}
+	logger := btclog.NewBackend(out).Logger("TEST")
+
+	// The log can be moved to another writer without touching stdout.
+	logger.Infof("first")
+	out.console = &other
+	logger.Infof("second")
+	require.Contains(t, console.String(), "first")
+	require.NotContains(t, console.String(), "second")
+	require.Contains(t, other.String(), "second")
+}
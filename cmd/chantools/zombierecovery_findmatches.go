@@ -5,13 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"text/template"
 	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
-	"github.com/guggero/chantools/btc"
 	"github.com/hasura/go-graphql-client"
 	"github.com/spf13/cobra"
 	"golang.org/x/oauth2"
@@ -113,12 +113,12 @@ type match struct {
 }
 
 type zombieRecoveryFindMatchesCommand struct {
-	APIURL        string
 	Registrations string
 	AmbossKey     string
 	AmbossDelay   time.Duration
 
-	cmd *cobra.Command
+	chainAPI *chainAPIFlags
+	cmd      *cobra.Command
 }
 
 func newZombieRecoveryFindMatchesCommand() *cobra.Command {
@@ -139,10 +139,7 @@ registered nodes.`,
 		RunE: cc.Execute,
 	}
 
-	cc.cmd.Flags().StringVar(
-		&cc.APIURL, "apiurl", defaultAPIURL, "API URL to use (must "+
-			"be esplora compatible)",
-	)
+	cc.chainAPI = newChainAPIFlags(cc.cmd)
 	cc.cmd.Flags().StringVar(
 		&cc.Registrations, "registrations", "", "the raw data.txt "+
 			"where the registrations are stored in",
@@ -186,7 +183,7 @@ func (c *zombieRecoveryFindMatchesCommand) Execute(_ *cobra.Command,
 		log.Infof("%s: %s", groups[1], groups[2])
 	}
 
-	api := &btc.ExplorerAPI{BaseURL: c.APIURL}
+	api := c.chainAPI.api()
 	src := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: c.AmbossKey})
 	httpClient := oauth2.NewClient(context.Background(), src)
 	client := graphql.NewClient(
@@ -287,7 +284,7 @@ func (c *zombieRecoveryFindMatchesCommand) Execute(_ *cobra.Command,
 			Node1:   node1,
 		}
 
-		folder := fmt.Sprintf("results/match-%s", node1)
+		folder := filepath.Join(WorkDir, "match-"+node1)
 		err = os.MkdirAll(folder, 0755)
 		if err != nil {
 			return err
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -10,12 +11,15 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcd/btcutil/psbt"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcwallet/wallet/txrules"
+	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/lnd"
 	"github.com/lightningnetwork/lnd/input"
 	"github.com/lightningnetwork/lnd/keychain"
@@ -24,9 +28,11 @@ import (
 )
 
 type zombieRecoveryMakeOfferCommand struct {
-	Node1   string
-	Node2   string
-	FeeRate uint16
+	Node1      string
+	Node2      string
+	FeeRate    uint16
+	PsbtV2     bool
+	NostrRelay string
 
 	rootKey *rootKey
 	cmd     *cobra.Command
@@ -44,7 +50,16 @@ one party has to create an offer on how to split the funds that are in the
 channels to be rescued.
 If the other party agrees with the offer, they can sign and publish the offer
 with the 'signoffer' command. If the other party does not agree, they can create
-a counter offer.`,
+a counter offer.
+
+Use --psbtv2 if the other party's tooling expects PSBTs in the version 2
+format (BIP 370). The 'signoffer' command accepts both versions.
+
+Instead of sending the offer manually, --nostr_relay can be used to send it as
+an encrypted Nostr direct message to the other party. The node identity keys
+are used as the Nostr keys of both parties. The command then waits until the
+other party signed the offer with 'signoffer --nostr_relay' and prints the final
+transaction.`,
 		Example: `chantools zombierecovery makeoffer \
 	--node1_keys preparedkeys-xxxx-xx-xx-<pubkey1>.json \
 	--node2_keys preparedkeys-xxxx-xx-xx-<pubkey2>.json \
@@ -64,6 +79,15 @@ a counter offer.`,
 		&cc.FeeRate, "feerate", defaultFeeSatPerVByte, "fee rate to "+
 			"use for the sweep transaction in sat/vByte",
 	)
+	cc.cmd.Flags().BoolVar(
+		&cc.PsbtV2, "psbtv2", false, "create the offer as a version 2 "+
+			"PSBT (BIP 370) instead of version 0",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.NostrRelay, "nostr_relay", "", "optional websocket URL "+
+			"of a Nostr relay (wss://...) to send the offer "+
+			"through and wait for the signed transaction",
+	)
 
 	cc.rootKey = newRootKey(cc.cmd, "signing the offer")
 
@@ -202,6 +226,10 @@ func (c *zombieRecoveryMakeOfferCommand) Execute(_ *cobra.Command,
 	if len(ourKeys) == 0 || len(theirKeys) == 0 {
 		return fmt.Errorf("couldn't find necessary keys")
 	}
+	theirNodeKey := keys1.Node1.PubKey
+	if theirNodeKey == pubKeyStr {
+		theirNodeKey = keys1.Node2.PubKey
+	}
 	if ourPayoutAddr == "" || theirPayoutAddr == "" {
 		return fmt.Errorf("payout address missing")
 	}
@@ -306,7 +334,7 @@ channelLoop:
 	feeRateKWeight := chainfee.SatPerKVByte(1000 * c.FeeRate).FeePerKWeight()
 	totalFee := int64(feeRateKWeight.FeeForWeight(int64(estimator.Weight())))
 
-	fmt.Printf("Current tally (before fees):\n\t"+
+	fmt.Fprintf(textOut(), "Current tally (before fees):\n\t"+
 		"To our address (%s): %d sats\n\t"+
 		"To their address (%s): %d sats\n\t"+
 		"Estimated fees (at rate %d sat/vByte): %d sats\n",
@@ -358,7 +386,7 @@ channelLoop:
 		theirSum = 0
 	}
 
-	fmt.Printf("Current tally (after fees):\n\t"+
+	fmt.Fprintf(textOut(), "Current tally (after fees):\n\t"+
 		"To our address (%s): %d sats\n\t"+
 		"To their address (%s): %d sats\n",
 		ourPayoutAddr, ourSum, theirPayoutAddr, theirSum)
@@ -432,14 +460,91 @@ channelLoop:
 	}
 
 	// Looks like we're done!
-	base64, err := packet.B64Encode()
+	psbtVersion := btc.PsbtVersion0
+	if c.PsbtV2 {
+		psbtVersion = btc.PsbtVersion2
+	}
+	base64, err := btc.EncodePsbt(packet, psbtVersion)
 	if err != nil {
 		return fmt.Errorf("error encoding PSBT: %w", err)
 	}
 
-	fmt.Printf("Done creating offer, please send this PSBT string to \n"+
-		"the other party to review and sign (if they accept): \n%s\n",
-		base64)
+	if c.NostrRelay != "" {
+		return sendOfferViaNostr(
+			c.NostrRelay, extendedKey, theirNodeKey, base64,
+		)
+	}
+
+	if JSONOutput {
+		return printJSON(&psbtResult{Psbt: base64})
+	}
+	fmt.Fprintf(textOut(), "Done creating offer, please send this PSBT "+
+		"string to \nthe other party to review and sign (if they "+
+		"accept): \n%s\n", base64)
+
+	return nil
+}
+
+// sendOfferViaNostr sends the offer to the other party through the given
+// Nostr relay and waits for the signed transaction.
+func sendOfferViaNostr(relayURL string, extendedKey *hdkeychain.ExtendedKey,
+	theirNodeKey, offer string) error {
+
+	privKey, err := nodeIdentityKey(extendedKey)
+	if err != nil {
+		return err
+	}
+	theirPubKey, err := pubKeyFromHex(theirNodeKey)
+	if err != nil {
+		return fmt.Errorf("error parsing node pubkey: %w", err)
+	}
+
+	relay, err := connectNostrRelay(relayURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = relay.Close() }()
+
+	sentAt := time.Now().Add(-time.Minute)
+	err = sendZombieMessage(relay, privKey, theirPubKey, &zombieMessage{
+		Type:    nostrMessageOffer,
+		Payload: offer,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(textOut(), "Done creating offer, sent it to node %s "+
+		"through %s.\nWaiting up to %v for the other party to sign it "+
+		"(press <ctrl+c> to abort)...\n", theirNodeKey, relayURL,
+		nostrWaitTimeout)
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), nostrWaitTimeout,
+	)
+	defer cancel()
+	msg, err := relay.waitForMessage(
+		ctx, privKey, theirPubKey, nostrMessageSignedTx, sentAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	if JSONOutput {
+		rawTx, err := hex.DecodeString(msg.Payload)
+		if err != nil {
+			return fmt.Errorf("error decoding signed TX: %w", err)
+		}
+		tx := &wire.MsgTx{}
+		if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+			return fmt.Errorf("error parsing signed TX: %w", err)
+		}
+
+		return printJSON(newRawTxResult(tx, rawTx))
+	}
+	fmt.Fprintf(textOut(), "The other party signed the offer. Please "+
+		"publish this transaction using\nany bitcoin node:\n\n%s\n\n",
+		msg.Payload)
 
 	return nil
 }
@@ -471,7 +576,8 @@ func askAboutChannel(channel *channel, current, total int, ourAddr,
 
 	fundingTxid := strings.Split(channel.ChanPoint, ":")[0]
 
-	fmt.Printf("Channel %s (%d of %d): \n\tCapacity: %d sat\n\t"+
+	fmt.Fprintf(textOut(), "Channel %s (%d of %d): \n\t"+
+		"Capacity: %d sat\n\t"+
 		"Funding TXID: https://blockstream.info/tx/%v\n\t"+
 		"Channel info: https://1ml.com/channel/%s\n\t"+
 		"Channel funding address: %s\n\n"+
@@ -491,17 +597,17 @@ func askAboutChannel(channel *channel, current, total int, ourAddr,
 
 	// Let the user try again if they entered something incorrect.
 	if int64(ourPart) > channel.Capacity {
-		fmt.Printf("Cannot send more than %d sats to ourself!\n",
-			channel.Capacity)
+		fmt.Fprintf(textOut(), "Cannot send more than %d sats to "+
+			"ourself!\n", channel.Capacity)
 		return askAboutChannel(
 			channel, current, total, ourAddr, theirAddr,
 		)
 	}
 
 	theirPart := channel.Capacity - int64(ourPart)
-	fmt.Printf("\nWill send: \n\t%d sats to our address (%s) and \n\t"+
-		"%d sats to the other peer's address (%s).\n\n", ourPart,
-		ourAddr, theirPart, theirAddr)
+	fmt.Fprintf(textOut(), "\nWill send: \n\t%d sats to our address "+
+		"(%s) and \n\t%d sats to the other peer's address (%s).\n\n",
+		ourPart, ourAddr, theirPart, theirAddr)
 
 	return int64(ourPart), theirPart, nil
 }
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"encoding/hex"
+	"encoding/json"
+	"os"
 	"testing"
 
 	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
 	"github.com/stretchr/testify/require"
 )
 
@@ -29,3 +39,175 @@ func TestMatchScript(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, ok)
 }
+
+func TestCheckOfferWitnessScript(t *testing.T) {
+	witnessScript, err := input.GenMultiSigScript(
+		key1.SerializeCompressed(), key2.SerializeCompressed(),
+	)
+	require.NoError(t, err)
+	pkScript, err := input.WitnessScriptHash(witnessScript)
+	require.NoError(t, err)
+
+	err = checkOfferWitnessScript(witnessScript, pkScript, key1)
+	require.NoError(t, err)
+
+	// A script that isn't spent by the UTXO must be rejected.
+	err = checkOfferWitnessScript(witnessScript, []byte{0x00}, key1)
+	require.ErrorContains(t, err, "doesn't match UTXO")
+
+	// A script that doesn't contain our key must be rejected as well.
+	otherKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	err = checkOfferWitnessScript(
+		witnessScript, pkScript, otherKey.PubKey(),
+	)
+	require.ErrorContains(t, err, "doesn't contain our key")
+
+	// A 1-of-2 multisig script with our key must not be signed, the
+	// remote peer could spend it alone.
+	oneOfTwo, err := txscript.NewScriptBuilder().AddOp(txscript.OP_1).
+		AddData(key1.SerializeCompressed()).
+		AddData(key2.SerializeCompressed()).
+		AddOp(txscript.OP_2).AddOp(txscript.OP_CHECKMULTISIG).
+		Script()
+	require.NoError(t, err)
+	pkScript, err = input.WitnessScriptHash(oneOfTwo)
+	require.NoError(t, err)
+	err = checkOfferWitnessScript(oneOfTwo, pkScript, key1)
+	require.ErrorContains(t, err, "isn't a 2-of-2 multisig script")
+
+	// Neither must a script that lets the remote peer spend with only
+	// their key.
+	trick, err := txscript.NewScriptBuilder().
+		AddData(key1.SerializeCompressed()).AddOp(txscript.OP_DROP).
+		AddData(key2.SerializeCompressed()).
+		AddOp(txscript.OP_CHECKSIG).Script()
+	require.NoError(t, err)
+	pkScript, err = input.WitnessScriptHash(trick)
+	require.NoError(t, err)
+	err = checkOfferWitnessScript(trick, pkScript, key1)
+	require.ErrorContains(t, err, "isn't a 2-of-2 multisig script")
+}
+
+func TestZombieRecoveryOfferJSON(t *testing.T) {
+	h := newHarness(t)
+
+	// Both nodes use the multisig key with index 2 for the channel.
+	alice := newRescueParty(t, rootKeyAezeed, 2)
+	bob := newRescueParty(t, rootKeyBip39, 2)
+	_, fundingOut, err := input.GenFundingPkScript(
+		alice.keyDesc.PubKey.SerializeCompressed(),
+		bob.keyDesc.PubKey.SerializeCompressed(), 1_000_000,
+	)
+	require.NoError(t, err)
+	fundingAddr, err := btcutil.NewAddressWitnessScriptHash(
+		fundingOut.PkScript[2:], chainParams,
+	)
+	require.NoError(t, err)
+
+	const multisigFamily = keychain.KeyFamilyMultiSig
+	nodeInfoOf := func(party *rescueParty) *nodeInfo {
+		_, identityKey, _, err := lnd.DeriveKey(
+			party.signer.ExtendedKey, lnd.IdentityPath(chainParams),
+			chainParams,
+		)
+		require.NoError(t, err)
+		payoutAddr, err := lnd.P2WKHAddr(identityKey, chainParams)
+		require.NoError(t, err)
+
+		info := &nodeInfo{
+			PubKey: hex.EncodeToString(
+				identityKey.SerializeCompressed(),
+			),
+			PayoutAddr: payoutAddr.String(),
+		}
+		for index := uint32(0); index < 4; index++ {
+			privKey, err := party.signer.FetchPrivKey(
+				&keychain.KeyDescriptor{
+					KeyLocator: keychain.KeyLocator{
+						Family: multisigFamily,
+						Index:  index,
+					},
+				},
+			)
+			require.NoError(t, err)
+			info.MultisigKeys = append(
+				info.MultisigKeys, hex.EncodeToString(
+					privKey.PubKey().SerializeCompressed(),
+				),
+			)
+		}
+
+		return info
+	}
+	aliceInfo, bobInfo := nodeInfoOf(alice), nodeInfoOf(bob)
+
+	// Each node fills in its own keys in the match file.
+	chanPoint := wire.OutPoint{Hash: chainhash.Hash{1}, Index: 0}
+	channels := []*channel{{
+		ChanPoint: chanPoint.String(),
+		Address:   fundingAddr.String(),
+		Capacity:  fundingOut.Value,
+	}}
+	writeMatch := func(name string, node1, node2 *nodeInfo) string {
+		file := h.tempFile(name)
+		content, err := json.Marshal(&match{
+			Node1:    node1,
+			Node2:    node2,
+			Channels: channels,
+		})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(file, content, 0600))
+
+		return file
+	}
+	aliceFile := writeMatch("alice.json", aliceInfo, &nodeInfo{
+		PubKey: bobInfo.PubKey,
+	})
+	bobFile := writeMatch("bob.json", &nodeInfo{
+		PubKey: aliceInfo.PubKey,
+	}, bobInfo)
+
+	// Alice wants 400k sats of the channel, with --json only the offer is
+	// written to stdout.
+	h.captureJSON()
+	h.setStdin("400000\n")
+	makeOffer := &zombieRecoveryMakeOfferCommand{
+		Node1:   aliceFile,
+		Node2:   bobFile,
+		FeeRate: 10,
+		rootKey: &rootKey{RootKey: rootKeyAezeed},
+	}
+	require.NoError(t, makeOffer.Execute(nil, nil))
+	var offer psbtResult
+	h.assertJSONOutput(&offer)
+
+	// Bob accepts the offer and gets the final transaction.
+	h.captureJSON()
+	h.setStdin("\n")
+	signOffer := &zombieRecoverySignOfferCommand{
+		Psbt:    offer.Psbt,
+		rootKey: &rootKey{RootKey: rootKeyBip39},
+	}
+	require.NoError(t, signOffer.Execute(nil, nil))
+	var result rawTxResult
+	h.assertJSONOutput(&result)
+
+	rawTx, err := hex.DecodeString(result.RawTx)
+	require.NoError(t, err)
+	finalTx := wire.NewMsgTx(2)
+	require.NoError(t, finalTx.Deserialize(bytes.NewReader(rawTx)))
+	require.Equal(t, finalTx.TxHash().String(), result.TxID)
+	require.Len(t, finalTx.TxOut, 2)
+
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(
+		fundingOut.PkScript, fundingOut.Value,
+	)
+	vm, err := txscript.NewEngine(
+		fundingOut.PkScript, finalTx, 0, txscript.StandardVerifyFlags,
+		nil, txscript.NewTxSigHashes(finalTx, prevOutFetcher),
+		fundingOut.Value, prevOutFetcher,
+	)
+	require.NoError(t, err)
+	require.NoError(t, vm.Execute())
+}
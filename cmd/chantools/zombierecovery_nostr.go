@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/gorilla/websocket"
+	"github.com/guggero/chantools/lnd"
+)
+
+const (
+	// nostrKindEncryptedDM is the event kind of an encrypted direct message
+	// as defined in NIP-04.
+	nostrKindEncryptedDM = 4
+
+	// nostrMessageOffer is the type of the message that contains the
+	// offer PSBT.
+	nostrMessageOffer = "offer"
+
+	// nostrMessageSignedTx is the type of the message that contains the
+	// final, signed transaction.
+	nostrMessageSignedTx = "signed_tx"
+
+	// nostrPublishTimeout is the time we wait for a relay to accept an
+	// event we publish.
+	nostrPublishTimeout = 30 * time.Second
+
+	// nostrWaitTimeout is the time we wait for the other party to send us
+	// a message.
+	nostrWaitTimeout = 24 * time.Hour
+
+	// nostrWriteTimeout is the time we allow for writing a single message
+	// to the relay.
+	nostrWriteTimeout = 10 * time.Second
+)
+
+// nostrEvent is a Nostr event as defined in NIP-01.
+type nostrEvent struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// zombieMessage is the content of the direct messages the two parties of a
+// zombie channel recovery exchange.
+type zombieMessage struct {
+	Type    string `json:"type"`
+	Payload string `json:"payload"`
+}
+
+// nostrPubKey returns the hex encoded x-only public key that is used as the
+// Nostr identity of the given key.
+func nostrPubKey(pubKey *btcec.PublicKey) string {
+	return hex.EncodeToString(schnorr.SerializePubKey(pubKey))
+}
+
+// nodeIdentityKey derives the private node identity key from the root key.
+// The identity key is used as the Nostr key, so both parties know each
+// other's Nostr identity from the node public keys.
+func nodeIdentityKey(
+	extendedKey *hdkeychain.ExtendedKey) (*btcec.PrivateKey, error) {
+
+	_, _, wif, err := lnd.DeriveKey(
+		extendedKey, lnd.IdentityPath(chainParams), chainParams,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving identity key: %w", err)
+	}
+
+	return wif.PrivKey, nil
+}
+
+// computeID calculates the ID of the event as defined in NIP-01.
+func (e *nostrEvent) computeID() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode([]interface{}{
+		0, e.PubKey, e.CreatedAt, e.Kind, e.Tags, e.Content,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The encoder adds a trailing newline that is not part of the
+	// serialization.
+	id := sha256.Sum256(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	return id[:], nil
+}
+
+// verify checks the ID and signature of the event.
+func (e *nostrEvent) verify() error {
+	id, err := e.computeID()
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(id) != e.ID {
+		return fmt.Errorf("invalid event ID")
+	}
+
+	pubKeyBytes, err := hex.DecodeString(e.PubKey)
+	if err != nil {
+		return fmt.Errorf("invalid event pubkey: %w", err)
+	}
+	pubKey, err := schnorr.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return fmt.Errorf("invalid event pubkey: %w", err)
+	}
+	sigBytes, err := hex.DecodeString(e.Sig)
+	if err != nil {
+		return fmt.Errorf("invalid event signature: %w", err)
+	}
+	sig, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return fmt.Errorf("invalid event signature: %w", err)
+	}
+	if !sig.Verify(id, pubKey) {
+		return fmt.Errorf("invalid event signature")
+	}
+
+	return nil
+}
+
+// newZombieDM creates a signed NIP-04 encrypted direct message with the given
+// content for the recipient.
+func newZombieDM(privKey *btcec.PrivateKey, recipient *btcec.PublicKey,
+	msg *zombieMessage) (*nostrEvent, error) {
+
+	plaintext, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	content, err := nip04Encrypt(privKey, recipient, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &nostrEvent{
+		PubKey:    nostrPubKey(privKey.PubKey()),
+		CreatedAt: time.Now().Unix(),
+		Kind:      nostrKindEncryptedDM,
+		Tags:      [][]string{{"p", nostrPubKey(recipient)}},
+		Content:   content,
+	}
+	id, err := event.computeID()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := schnorr.Sign(privKey, id)
+	if err != nil {
+		return nil, fmt.Errorf("error signing event: %w", err)
+	}
+	event.ID = hex.EncodeToString(id)
+	event.Sig = hex.EncodeToString(sig.Serialize())
+
+	return event, nil
+}
+
+// decryptZombieDM verifies and decrypts a direct message sent to us.
+func decryptZombieDM(privKey *btcec.PrivateKey,
+	event *nostrEvent) (*zombieMessage, error) {
+
+	if err := event.verify(); err != nil {
+		return nil, err
+	}
+
+	senderBytes, err := hex.DecodeString(event.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := schnorr.ParsePubKey(senderBytes)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := nip04Decrypt(privKey, sender, event.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &zombieMessage{}
+	if err := json.Unmarshal(plaintext, msg); err != nil {
+		return nil, fmt.Errorf("error decoding message: %w", err)
+	}
+
+	return msg, nil
+}
+
+// nip04SharedKey returns the unhashed x coordinate of the ECDH shared point,
+// which is used as the AES key in NIP-04.
+func nip04SharedKey(privKey *btcec.PrivateKey,
+	pubKey *btcec.PublicKey) []byte {
+
+	var point, result btcec.JacobianPoint
+	pubKey.AsJacobian(&point)
+	btcec.ScalarMultNonConst(&privKey.Key, &point, &result)
+	result.ToAffine()
+
+	x := result.X.Bytes()
+	return x[:]
+}
+
+// nip04Encrypt encrypts the plaintext with AES-256-CBC as defined in NIP-04.
+func nip04Encrypt(privKey *btcec.PrivateKey, pubKey *btcec.PublicKey,
+	plaintext []byte) (string, error) {
+
+	block, err := aes.NewCipher(nip04SharedKey(privKey, pubKey))
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	// Apply PKCS#7 padding.
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(
+		plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...,
+	)
+
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	return base64.StdEncoding.EncodeToString(ciphertext) + "?iv=" +
+		base64.StdEncoding.EncodeToString(iv), nil
+}
+
+// nip04Decrypt decrypts the content of a NIP-04 direct message.
+func nip04Decrypt(privKey *btcec.PrivateKey, pubKey *btcec.PublicKey,
+	content string) ([]byte, error) {
+
+	parts := strings.Split(content, "?iv=")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid message content")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %w", err)
+	}
+	iv, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid IV: %w", err)
+	}
+	if len(iv) != aes.BlockSize || len(ciphertext) == 0 ||
+		len(ciphertext)%aes.BlockSize != 0 {
+
+		return nil, fmt.Errorf("invalid message length")
+	}
+
+	block, err := aes.NewCipher(nip04SharedKey(privKey, pubKey))
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	// All bytes of the PKCS#7 padding must equal its length.
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, fmt.Errorf("invalid padding, wrong key?")
+	}
+	expectedPadding := bytes.Repeat([]byte{byte(padding)}, padding)
+	if !bytes.Equal(plaintext[len(plaintext)-padding:], expectedPadding) {
+		return nil, fmt.Errorf("invalid padding, wrong key?")
+	}
+
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// nostrRelay is a minimal client for a single Nostr relay.
+type nostrRelay struct {
+	conn *websocket.Conn
+}
+
+// connectNostrRelay connects to the relay with the given websocket URL.
+func connectNostrRelay(url string) (*nostrRelay, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to relay %s: %w", url,
+			err)
+	}
+
+	return &nostrRelay{conn: conn}, nil
+}
+
+// Close closes the connection to the relay.
+func (r *nostrRelay) Close() error {
+	return r.conn.Close()
+}
+
+// writeJSON sends the message to the relay.
+func (r *nostrRelay) writeJSON(msg interface{}) error {
+	err := r.conn.SetWriteDeadline(time.Now().Add(nostrWriteTimeout))
+	if err != nil {
+		return err
+	}
+
+	return r.conn.WriteJSON(msg)
+}
+
+// readJSON reads the next message from the relay. The read is aborted once the
+// context is done, in which case the context's error is returned. The
+// connection can't be used anymore after that.
+func (r *nostrRelay) readJSON(ctx context.Context, msg interface{}) error {
+	deadline, hasDeadline := ctx.Deadline()
+	if err := r.conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+
+	// Contexts can also be canceled before their deadline, so we make the
+	// read fail immediately in that case.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = r.conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	err := r.conn.ReadJSON(msg)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// The read deadline can be hit just before the context's own timer
+	// fires, which is still a timeout of the context.
+	if err != nil && hasDeadline && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+
+	return err
+}
+
+// publish sends the event to the relay and waits for it to be accepted.
+func (r *nostrRelay) publish(ctx context.Context, event *nostrEvent) error {
+	err := r.writeJSON([]interface{}{"EVENT", event})
+	if err != nil {
+		return fmt.Errorf("error publishing event: %w", err)
+	}
+
+	for {
+		var msg []json.RawMessage
+		if err := r.readJSON(ctx, &msg); err != nil {
+			return fmt.Errorf("error reading from relay: %w", err)
+		}
+
+		// We're only interested in the OK message for our event, which
+		// has the format ["OK", <event_id>, <accepted>, <message>].
+		var msgType, eventID string
+		if len(msg) != 4 || json.Unmarshal(msg[0], &msgType) != nil ||
+			msgType != "OK" ||
+			json.Unmarshal(msg[1], &eventID) != nil ||
+			eventID != event.ID {
+
+			continue
+		}
+
+		var (
+			accepted bool
+			reason   string
+		)
+		_ = json.Unmarshal(msg[2], &accepted)
+		_ = json.Unmarshal(msg[3], &reason)
+		if !accepted {
+			return fmt.Errorf("relay rejected event: %s", reason)
+		}
+
+		return nil
+	}
+}
+
+// waitForMessage subscribes to direct messages from the sender to us and
+// returns the first one of the given type that was created after the given
+// time. It gives up once the context is done.
+func (r *nostrRelay) waitForMessage(ctx context.Context,
+	privKey *btcec.PrivateKey, sender *btcec.PublicKey, msgType string,
+	since time.Time) (*zombieMessage, error) {
+
+	subID := fmt.Sprintf("chantools-%d", time.Now().UnixNano())
+	filter := map[string]interface{}{
+		"kinds":   []int{nostrKindEncryptedDM},
+		"authors": []string{nostrPubKey(sender)},
+		"#p":      []string{nostrPubKey(privKey.PubKey())},
+		"since":   since.Unix(),
+	}
+	err := r.writeJSON([]interface{}{"REQ", subID, filter})
+	if err != nil {
+		return nil, fmt.Errorf("error subscribing: %w", err)
+	}
+	defer func() {
+		_ = r.writeJSON([]interface{}{"CLOSE", subID})
+	}()
+
+	for {
+		var msg []json.RawMessage
+		if err := r.readJSON(ctx, &msg); err != nil {
+			return nil, fmt.Errorf("error reading from relay: %w",
+				err)
+		}
+
+		// Events have the format ["EVENT", <sub_id>, <event>].
+		var relayMsgType, relaySubID string
+		if len(msg) != 3 ||
+			json.Unmarshal(msg[0], &relayMsgType) != nil ||
+			relayMsgType != "EVENT" ||
+			json.Unmarshal(msg[1], &relaySubID) != nil ||
+			relaySubID != subID {
+
+			continue
+		}
+
+		event := &nostrEvent{}
+		if err := json.Unmarshal(msg[2], event); err != nil {
+			log.Warnf("Ignoring invalid event: %v", err)
+			continue
+		}
+		if event.PubKey != nostrPubKey(sender) {
+			continue
+		}
+
+		zombieMsg, err := decryptZombieDM(privKey, event)
+		if err != nil {
+			log.Warnf("Ignoring invalid message %s: %v", event.ID,
+				err)
+			continue
+		}
+		if zombieMsg.Type != msgType {
+			continue
+		}
+
+		return zombieMsg, nil
+	}
+}
+
+// sendZombieMessage sends the message as an encrypted direct message to the
+// recipient through the relay.
+func sendZombieMessage(relay *nostrRelay, privKey *btcec.PrivateKey,
+	recipient *btcec.PublicKey, msg *zombieMessage) error {
+
+	event, err := newZombieDM(privKey, recipient, msg)
+	if err != nil {
+		return fmt.Errorf("error creating message: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), nostrPublishTimeout,
+	)
+	defer cancel()
+
+	return relay.publish(ctx, event)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZombieDM(t *testing.T) {
+	alice, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	bob, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	eve, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	msg := &zombieMessage{Type: nostrMessageOffer, Payload: "cHNidP8B<&>"}
+	event, err := newZombieDM(alice, bob.PubKey(), msg)
+	require.NoError(t, err)
+	require.NoError(t, event.verify())
+
+	// Only the recipient can decrypt the message.
+	decrypted, err := decryptZombieDM(bob, event)
+	require.NoError(t, err)
+	require.Equal(t, msg, decrypted)
+
+	_, err = decryptZombieDM(eve, event)
+	require.Error(t, err)
+
+	// A modified event must be rejected.
+	event.Content = strings.Replace(event.Content, "?iv=", "A?iv=", 1)
+	_, err = decryptZombieDM(bob, event)
+	require.ErrorContains(t, err, "invalid event ID")
+}
+
+func TestNip04Padding(t *testing.T) {
+	alice, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	bob, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	encrypt := func(padded []byte) string {
+		block, err := aes.NewCipher(nip04SharedKey(alice, bob.PubKey()))
+		require.NoError(t, err)
+
+		iv := make([]byte, aes.BlockSize)
+		ciphertext := make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(
+			ciphertext, padded,
+		)
+
+		return base64.StdEncoding.EncodeToString(ciphertext) + "?iv=" +
+			base64.StdEncoding.EncodeToString(iv)
+	}
+
+	// Correct PKCS#7 padding is removed.
+	valid := append([]byte("hello world!"), 4, 4, 4, 4)
+	plaintext, err := nip04Decrypt(bob, alice.PubKey(), encrypt(valid))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world!"), plaintext)
+
+	// Only the last byte having the right value isn't enough.
+	invalid := append([]byte("hello world!"), 1, 2, 3, 4)
+	_, err = nip04Decrypt(bob, alice.PubKey(), encrypt(invalid))
+	require.ErrorContains(t, err, "invalid padding")
+}
+
+// silentNostrRelay accepts connections but never answers.
+type silentNostrRelay struct{}
+
+func (s *silentNostrRelay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func TestNostrRelayTimeout(t *testing.T) {
+	alice, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	bob, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	server := httptest.NewServer(&silentNostrRelay{})
+	defer server.Close()
+	relayURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// A relay that never accepts our event doesn't block forever.
+	relay, err := connectNostrRelay(relayURL)
+	require.NoError(t, err)
+	defer func() { _ = relay.Close() }()
+
+	event, err := newZombieDM(alice, bob.PubKey(), &zombieMessage{
+		Type: nostrMessageOffer, Payload: "offer",
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 100*time.Millisecond,
+	)
+	defer cancel()
+	err = relay.publish(ctx, event)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Waiting for a message can be canceled.
+	relay2, err := connectNostrRelay(relayURL)
+	require.NoError(t, err)
+	defer func() { _ = relay2.Close() }()
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel2)
+	_, err = relay2.waitForMessage(
+		ctx2, bob, alice.PubKey(), nostrMessageOffer, time.Now(),
+	)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+// fakeNostrRelay is a minimal in-memory Nostr relay that stores all events
+// and returns the already stored ones for subscriptions that match the author
+// and recipient.
+type fakeNostrRelay struct {
+	mu     sync.Mutex
+	events []*nostrEvent
+}
+
+func (f *fakeNostrRelay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		var msg []json.RawMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		var msgType string
+		_ = json.Unmarshal(msg[0], &msgType)
+		switch msgType {
+		case "EVENT":
+			event := &nostrEvent{}
+			_ = json.Unmarshal(msg[1], event)
+			f.mu.Lock()
+			f.events = append(f.events, event)
+			f.mu.Unlock()
+
+			_ = conn.WriteJSON([]interface{}{
+				"OK", event.ID, event.verify() == nil, "",
+			})
+
+		case "REQ":
+			var (
+				subID  string
+				filter struct {
+					Authors []string `json:"authors"`
+					P       []string `json:"#p"`
+				}
+			)
+			_ = json.Unmarshal(msg[1], &subID)
+			_ = json.Unmarshal(msg[2], &filter)
+
+			f.mu.Lock()
+			for _, event := range f.events {
+				if event.PubKey != filter.Authors[0] ||
+					event.Tags[0][1] != filter.P[0] {
+
+					continue
+				}
+
+				_ = conn.WriteJSON([]interface{}{
+					"EVENT", subID, event,
+				})
+			}
+			f.mu.Unlock()
+		}
+	}
+}
+
+func TestZombieNostrRoundTrip(t *testing.T) {
+	alice, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	bob, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+
+	server := httptest.NewServer(&fakeNostrRelay{})
+	defer server.Close()
+	relayURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	aliceRelay, err := connectNostrRelay(relayURL)
+	require.NoError(t, err)
+	defer func() { _ = aliceRelay.Close() }()
+	bobRelay, err := connectNostrRelay(relayURL)
+	require.NoError(t, err)
+	defer func() { _ = bobRelay.Close() }()
+
+	// Alice sends the offer, Bob picks it up.
+	since := time.Now().Add(-time.Minute)
+	offer := &zombieMessage{Type: nostrMessageOffer, Payload: "offer"}
+	err = sendZombieMessage(aliceRelay, alice, bob.PubKey(), offer)
+	require.NoError(t, err)
+
+	received, err := bobRelay.waitForMessage(
+		context.Background(), bob, alice.PubKey(), nostrMessageOffer,
+		since,
+	)
+	require.NoError(t, err)
+	require.Equal(t, offer, received)
+}
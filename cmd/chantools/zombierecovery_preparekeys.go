@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"time"
 
 	"github.com/guggero/chantools/lnd"
 	"github.com/spf13/cobra"
@@ -131,8 +130,7 @@ func (c *zombieRecoveryPrepareKeysCommand) Execute(_ *cobra.Command,
 		return err
 	}
 
-	fileName := fmt.Sprintf("results/preparedkeys-%s-%s.json",
-		time.Now().Format("2006-01-02"), pubKeyStr)
+	fileName := resultFileName("preparedkeys-"+pubKeyStr, "json")
 	log.Infof("Writing result to %s", fileName)
 	return ioutil.WriteFile(fileName, matchBytes, 0644)
 }
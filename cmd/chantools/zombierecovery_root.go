@@ -16,7 +16,16 @@ func newZombieRecoveryCommand() *cobra.Command {
 		Use:   "zombierecovery",
 		Short: "Try rescuing funds stuck in channels with zombie nodes",
 		Long: `A sub command that hosts a set of further sub commands
-to help with recovering funds tuck in zombie channels.
+to help with recovering funds stuck in zombie channels.
+
+A zombie channel is a channel where both nodes are no longer able to force
+close or cooperatively close the channel through lnd. By working together, both
+parties can still sign a transaction that spends the 2-of-2 multisig funding
+output directly. The recovery consists of the following steps:
+  0. The match maker finds nodes that share channels (findmatches).
+  1. Both parties derive and exchange their multisig keys (preparekeys).
+  2. One party proposes how to split the funds as a PSBT (makeoffer).
+  3. The other party verifies, signs and publishes the offer (signoffer).
 
 Please visit https://github.com/guggero/chantools/blob/master/doc/zombierecovery.md
 for more information on how to use these commands.`,
@@ -3,20 +3,27 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcd/btcutil/psbt"
 	"github.com/btcsuite/btcd/txscript"
+	"github.com/guggero/chantools/btc"
 	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
 	"github.com/lightningnetwork/lnd/keychain"
 	"github.com/spf13/cobra"
 )
 
 type zombieRecoverySignOfferCommand struct {
-	Psbt string
+	Psbt       string
+	NostrRelay string
+	NostrPeer  string
 
 	rootKey *rootKey
 	cmd     *cobra.Command
@@ -29,9 +36,21 @@ func newZombieRecoverySignOfferCommand() *cobra.Command {
 		Short: "[3/3] Sign an offer sent by the remote peer to " +
 			"recover funds",
 		Long: `Inspect and sign an offer that was sent by the remote
-peer to recover funds from one or more channels.`,
+peer to recover funds from one or more channels.
+
+The offer can be a PSBT in the version 0 (BIP 174) or version 2 (BIP 370)
+format.
+
+If the offer was sent with 'makeoffer --nostr_relay', use --nostr_relay and
+--nostr_peer instead of --psbt to fetch the most recent offer of the other node
+from the relay (up to 7 days old). The final transaction is then sent back to
+the other party automatically.`,
 		Example: `chantools zombierecovery signoffer \
-	--psbt <offered_psbt_base64>`,
+	--psbt <offered_psbt_base64>
+
+chantools zombierecovery signoffer \
+	--nostr_relay wss://relay.example.com \
+	--nostr_peer <other_node_pubkey>`,
 		RunE: cc.Execute,
 	}
 
@@ -39,6 +58,16 @@ peer to recover funds from one or more channels.`,
 		&cc.Psbt, "psbt", "", "the base64 encoded PSBT that the other "+
 			"party sent as an offer to rescue funds",
 	)
+	cc.cmd.Flags().StringVar(
+		&cc.NostrRelay, "nostr_relay", "", "optional websocket URL "+
+			"of a Nostr relay (wss://...) to fetch the offer from "+
+			"and send the signed transaction back through",
+	)
+	cc.cmd.Flags().StringVar(
+		&cc.NostrPeer, "nostr_peer", "", "the node pubkey of the "+
+			"other party that sent the offer through the Nostr "+
+			"relay",
+	)
 
 	cc.rootKey = newRootKey(cc.cmd, "signing the offer")
 
@@ -58,87 +87,155 @@ func (c *zombieRecoverySignOfferCommand) Execute(_ *cobra.Command,
 		ChainParams: chainParams,
 	}
 
+	if c.NostrRelay != "" {
+		return c.signOfferViaNostr(extendedKey, signer)
+	}
+
 	// Decode the PSBT.
-	packet, err := psbt.NewFromRawBytes(
-		bytes.NewReader([]byte(c.Psbt)), true,
+	packet, _, err := btc.DecodePsbt(c.Psbt)
+	if err != nil {
+		return fmt.Errorf("error decoding PSBT: %w", err)
+	}
+
+	_, err = signOffer(extendedKey, packet, signer)
+	return err
+}
+
+// signOfferViaNostr fetches the offer from the Nostr relay, signs it and sends
+// the final transaction back to the other party.
+func (c *zombieRecoverySignOfferCommand) signOfferViaNostr(
+	extendedKey *hdkeychain.ExtendedKey, signer *lnd.Signer) error {
+
+	if c.NostrPeer == "" {
+		return fmt.Errorf("node pubkey of other party is required")
+	}
+	peerPubKey, err := pubKeyFromHex(c.NostrPeer)
+	if err != nil {
+		return fmt.Errorf("error parsing node pubkey: %w", err)
+	}
+	privKey, err := nodeIdentityKey(extendedKey)
+	if err != nil {
+		return err
+	}
+
+	relay, err := connectNostrRelay(c.NostrRelay)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = relay.Close() }()
+
+	log.Infof("Waiting for offer of node %s on %s", c.NostrPeer,
+		c.NostrRelay)
+	ctx, cancel := context.WithTimeout(
+		context.Background(), nostrWaitTimeout,
+	)
+	defer cancel()
+	msg, err := relay.waitForMessage(
+		ctx, privKey, peerPubKey, nostrMessageOffer,
+		time.Now().Add(-7*24*time.Hour),
 	)
+	if err != nil {
+		return err
+	}
+
+	packet, _, err := btc.DecodePsbt(msg.Payload)
 	if err != nil {
 		return fmt.Errorf("error decoding PSBT: %w", err)
 	}
 
-	return signOffer(extendedKey, packet, signer)
+	finalTx, err := signOffer(extendedKey, packet, signer)
+	if err != nil {
+		return err
+	}
+
+	err = sendZombieMessage(relay, privKey, peerPubKey, &zombieMessage{
+		Type:    nostrMessageSignedTx,
+		Payload: hex.EncodeToString(finalTx),
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Sent signed transaction to node %s", c.NostrPeer)
+
+	return nil
 }
 
+// signOffer signs the offered PSBT after the user confirmed it and returns the
+// serialized final transaction.
 func signOffer(rootKey *hdkeychain.ExtendedKey,
-	packet *psbt.Packet, signer *lnd.Signer) error {
+	packet *psbt.Packet, signer *lnd.Signer) ([]byte, error) {
 
 	// First, we need to derive the correct branch from the local root key.
-	localMultisig, err := lnd.DeriveChildren(rootKey, []uint32{
+	localMultisig, err := lnd.DeriveFromRoot(rootKey, []uint32{
 		lnd.HardenedKeyStart + uint32(keychain.BIP0043Purpose),
 		lnd.HardenedKeyStart + chainParams.HDCoinType,
 		lnd.HardenedKeyStart + uint32(keychain.KeyFamilyMultiSig),
 		0,
 	})
 	if err != nil {
-		return fmt.Errorf("could not derive local multisig key: %w",
-			err)
+		return nil, fmt.Errorf("could not derive local multisig key: "+
+			"%w", err)
 	}
 
 	// Now let's check that the packet has the expected proprietary key with
 	// our pubkey that we need to sign with.
 	if len(packet.Inputs) == 0 {
-		return fmt.Errorf("invalid PSBT, expected at least 1 input, "+
-			"got %d", len(packet.Inputs))
+		return nil, fmt.Errorf("invalid PSBT, expected at least 1 "+
+			"input, got %d", len(packet.Inputs))
 	}
 	for idx := range packet.Inputs {
 		if len(packet.Inputs[idx].Unknowns) != 1 {
-			return fmt.Errorf("invalid PSBT, expected 1 unknown "+
-				"in input %d, got %d", idx,
+			return nil, fmt.Errorf("invalid PSBT, expected 1 "+
+				"unknown in input %d, got %d", idx,
 				len(packet.Inputs[idx].Unknowns))
 		}
 	}
 
-	fmt.Printf("The PSBT contains the following proposal:\n\n\t"+
+	// With --json, stdout is reserved for the result.
+	out := textOut()
+	fmt.Fprintf(out, "The PSBT contains the following proposal:\n\n\t"+
 		"Close %d channels: \n", len(packet.Inputs))
 	var totalInput int64
 	for idx, txIn := range packet.UnsignedTx.TxIn {
 		value := packet.Inputs[idx].WitnessUtxo.Value
 		totalInput += value
-		fmt.Printf("\tChannel %d (%s:%d), capacity %d sats\n",
+		fmt.Fprintf(out, "\tChannel %d (%s:%d), capacity %d sats\n",
 			idx, txIn.PreviousOutPoint.Hash.String(),
 			txIn.PreviousOutPoint.Index, value)
 	}
-	fmt.Println()
+	fmt.Fprintln(out)
 	var totalOutput int64
 	for _, txOut := range packet.UnsignedTx.TxOut {
 		totalOutput += txOut.Value
 		pkScript, err := txscript.ParsePkScript(txOut.PkScript)
 		if err != nil {
-			return fmt.Errorf("error parsing pk script: %w", err)
+			return nil, fmt.Errorf("error parsing pk script: %w",
+				err)
 		}
 		addr, err := pkScript.Address(chainParams)
 		if err != nil {
-			return fmt.Errorf("error parsing address: %w", err)
+			return nil, fmt.Errorf("error parsing address: %w", err)
 		}
-		fmt.Printf("\tSend %d sats to address %s\n", txOut.Value, addr)
+		fmt.Fprintf(out, "\tSend %d sats to address %s\n",
+			txOut.Value, addr)
 	}
-	fmt.Printf("\n\tTotal fees: %d sats\n\nDo you want to continue?\n",
-		totalInput-totalOutput)
-	fmt.Printf("Press <enter> to continue and sign the transaction or " +
-		"<ctrl+c> to abort: ")
+	fmt.Fprintf(out, "\n\tTotal fees: %d sats\n\nDo you want to "+
+		"continue?\n", totalInput-totalOutput)
+	fmt.Fprintf(out, "Press <enter> to continue and sign the "+
+		"transaction or <ctrl+c> to abort: ")
 	_, _ = bufio.NewReader(os.Stdin).ReadString('\n')
 
 	for idx := range packet.Inputs {
 		unknown := packet.Inputs[idx].Unknowns[0]
 		if !bytes.Equal(unknown.Key, PsbtKeyTypeOutputMissingSigPubkey) {
-			return fmt.Errorf("invalid PSBT, unknown has invalid "+
-				"key %x, expected %x", unknown.Key,
+			return nil, fmt.Errorf("invalid PSBT, unknown has "+
+				"invalid key %x, expected %x", unknown.Key,
 				PsbtKeyTypeOutputMissingSigPubkey)
 		}
 		targetKey, err := btcec.ParsePubKey(unknown.Value)
 		if err != nil {
-			return fmt.Errorf("invalid PSBT, proprietary key has "+
-				"invalid pubkey: %w", err)
+			return nil, fmt.Errorf("invalid PSBT, proprietary key "+
+				"has invalid pubkey: %w", err)
 		}
 
 		// Now we can look up the local key and check the PSBT further,
@@ -147,25 +244,38 @@ func signOffer(rootKey *hdkeychain.ExtendedKey,
 			localMultisig, targetKey,
 		)
 		if err != nil {
-			return fmt.Errorf("could not find local multisig key: "+
-				"%w", err)
+			return nil, fmt.Errorf("could not find local multisig "+
+				"key: %w", err)
 		}
 		if len(packet.Inputs[idx].WitnessScript) == 0 {
-			return fmt.Errorf("invalid PSBT, missing witness " +
-				"script")
+			return nil, fmt.Errorf("invalid PSBT, missing " +
+				"witness script")
 		}
 		witnessScript := packet.Inputs[idx].WitnessScript
 		if packet.Inputs[idx].WitnessUtxo == nil {
-			return fmt.Errorf("invalid PSBT, witness UTXO missing")
+			return nil, fmt.Errorf("invalid PSBT, witness UTXO " +
+				"missing")
 		}
 		utxo := packet.Inputs[idx].WitnessUtxo
 
+		// Make sure the witness script actually belongs to the output
+		// that is being spent and is the 2-of-2 funding script with our
+		// key. Otherwise the remote peer could trick us into signing
+		// something else.
+		err = checkOfferWitnessScript(
+			witnessScript, utxo.PkScript, localKeyDesc.PubKey,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PSBT, input %d: %w",
+				idx, err)
+		}
+
 		err = signer.AddPartialSignature(
 			packet, *localKeyDesc, utxo, witnessScript, idx,
 		)
 		if err != nil {
-			return fmt.Errorf("error adding partial signature: %w",
-				err)
+			return nil, fmt.Errorf("error adding partial "+
+				"signature: %w", err)
 		}
 	}
 
@@ -173,21 +283,82 @@ func signOffer(rootKey *hdkeychain.ExtendedKey,
 	// extract the final TX.
 	err = psbt.MaybeFinalizeAll(packet)
 	if err != nil {
-		return fmt.Errorf("error finalizing PSBT: %w", err)
+		return nil, fmt.Errorf("error finalizing PSBT: %w", err)
 	}
 	finalTx, err := psbt.Extract(packet)
 	if err != nil {
-		return fmt.Errorf("unable to extract final TX: %w", err)
+		return nil, fmt.Errorf("unable to extract final TX: %w", err)
 	}
 	var buf bytes.Buffer
 	err = finalTx.Serialize(&buf)
 	if err != nil {
-		return fmt.Errorf("unable to serialize final TX: %w", err)
+		return nil, fmt.Errorf("unable to serialize final TX: %w", err)
+	}
+
+	if JSONOutput {
+		err := printJSON(newRawTxResult(finalTx, buf.Bytes()))
+		return buf.Bytes(), err
 	}
+	fmt.Fprintf(textOut(), "Success, we counter signed the PSBT and "+
+		"extracted the final\ntransaction. Please publish this using "+
+		"any bitcoin node:\n\n%x\n\n", buf.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// checkOfferWitnessScript makes sure the given witness script is the 2-of-2
+// multisig funding script of our key and one other key and that it hashes to
+// the given pk script.
+func checkOfferWitnessScript(witnessScript, pkScript []byte,
+	localKey *btcec.PublicKey) error {
 
-	fmt.Printf("Success, we counter signed the PSBT and extracted the "+
-		"final\ntransaction. Please publish this using any bitcoin "+
-		"node:\n\n%x\n\n", buf.Bytes())
+	expectedPkScript, err := input.WitnessScriptHash(witnessScript)
+	if err != nil {
+		return fmt.Errorf("error hashing witness script: %w", err)
+	}
+	if !bytes.Equal(expectedPkScript, pkScript) {
+		return fmt.Errorf("witness script doesn't match UTXO")
+	}
+
+	pushes, err := txscript.PushedData(witnessScript)
+	if err != nil {
+		return fmt.Errorf("error parsing witness script: %w", err)
+	}
+	if len(pushes) != 2 {
+		return fmt.Errorf("witness script isn't a 2-of-2 multisig " +
+			"script")
+	}
+
+	localKeyBytes := localKey.SerializeCompressed()
+	var remoteKeyBytes []byte
+	switch {
+	case bytes.Equal(pushes[0], localKeyBytes):
+		remoteKeyBytes = pushes[1]
+
+	case bytes.Equal(pushes[1], localKeyBytes):
+		remoteKeyBytes = pushes[0]
+
+	default:
+		return fmt.Errorf("witness script doesn't contain our key %x",
+			localKeyBytes)
+	}
+	if _, err := btcec.ParsePubKey(remoteKeyBytes); err != nil {
+		return fmt.Errorf("invalid remote key in witness script: %w",
+			err)
+	}
+
+	// The script must be exactly the funding script of the two keys and
+	// nothing else.
+	fundingScript, err := input.GenMultiSigScript(
+		localKeyBytes, remoteKeyBytes,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating funding script: %w", err)
+	}
+	if !bytes.Equal(fundingScript, witnessScript) {
+		return fmt.Errorf("witness script isn't a 2-of-2 multisig " +
+			"script")
+	}
 
 	return nil
 }
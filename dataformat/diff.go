@@ -0,0 +1,122 @@
+package dataformat
+
+const (
+	SummaryChangeNew       = "new"
+	SummaryChangeRemoved   = "removed"
+	SummaryChangeFound     = "found_onchain"
+	SummaryChangeClosed    = "newly_closed"
+	SummaryChangeSwept     = "newly_swept"
+	SummaryChangeSpendable = "newly_spendable"
+)
+
+// SummaryChange describes how the state of a single channel changed between
+// two summary runs.
+type SummaryChange struct {
+	ChannelPoint string   `json:"channel_point"`
+	RemotePubkey string   `json:"remote_pubkey"`
+	OldState     string   `json:"old_state"`
+	NewState     string   `json:"new_state"`
+	Changes      []string `json:"changes"`
+}
+
+// SummaryDiff is the result of comparing two summary runs.
+type SummaryDiff struct {
+	Changes []*SummaryChange `json:"changes"`
+}
+
+// DiffSummaries compares two summary files and returns all channels that
+// changed their state between the old and the new run. Channels that didn't
+// change are not contained in the result.
+func DiffSummaries(oldFile, newFile *SummaryEntryFile) *SummaryDiff {
+	oldChannels := make(map[string]*SummaryEntry, len(oldFile.Channels))
+	for _, channel := range oldFile.Channels {
+		oldChannels[channel.ChannelPoint] = channel
+	}
+
+	diff := &SummaryDiff{}
+	seen := make(map[string]struct{}, len(newFile.Channels))
+	for _, newChannel := range newFile.Channels {
+		seen[newChannel.ChannelPoint] = struct{}{}
+
+		oldChannel, ok := oldChannels[newChannel.ChannelPoint]
+		if !ok {
+			diff.Changes = append(diff.Changes, &SummaryChange{
+				ChannelPoint: newChannel.ChannelPoint,
+				RemotePubkey: newChannel.RemotePubkey,
+				NewState:     newChannel.State(),
+				Changes:      []string{SummaryChangeNew},
+			})
+
+			continue
+		}
+
+		changes := diffChannel(oldChannel, newChannel)
+		if len(changes) == 0 {
+			continue
+		}
+
+		diff.Changes = append(diff.Changes, &SummaryChange{
+			ChannelPoint: newChannel.ChannelPoint,
+			RemotePubkey: newChannel.RemotePubkey,
+			OldState:     oldChannel.State(),
+			NewState:     newChannel.State(),
+			Changes:      changes,
+		})
+	}
+
+	for _, oldChannel := range oldFile.Channels {
+		if _, ok := seen[oldChannel.ChannelPoint]; ok {
+			continue
+		}
+
+		diff.Changes = append(diff.Changes, &SummaryChange{
+			ChannelPoint: oldChannel.ChannelPoint,
+			RemotePubkey: oldChannel.RemotePubkey,
+			OldState:     oldChannel.State(),
+			Changes:      []string{SummaryChangeRemoved},
+		})
+	}
+
+	return diff
+}
+
+// Count returns the number of channels with the given change.
+func (d *SummaryDiff) Count(change string) int {
+	count := 0
+	for _, c := range d.Changes {
+		for _, name := range c.Changes {
+			if name == change {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+// diffChannel returns the list of changes between two runs of the same
+// channel.
+func diffChannel(oldChannel, newChannel *SummaryEntry) []string {
+	var changes []string
+	if !oldChannel.ChanExists && newChannel.ChanExists {
+		changes = append(changes, SummaryChangeFound)
+	}
+
+	if oldChannel.ClosingTX == nil && newChannel.ClosingTX != nil {
+		changes = append(changes, SummaryChangeClosed)
+	}
+
+	oldSwept := oldChannel.ClosingTX != nil &&
+		oldChannel.ClosingTX.AllOutsSpent
+	newSwept := newChannel.ClosingTX != nil &&
+		newChannel.ClosingTX.AllOutsSpent
+	if !oldSwept && newSwept {
+		changes = append(changes, SummaryChangeSwept)
+	}
+
+	if !oldChannel.HasPotential && newChannel.HasPotential {
+		changes = append(changes, SummaryChangeSpendable)
+	}
+
+	return changes
+}
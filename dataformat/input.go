@@ -7,7 +7,9 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/lightningnetwork/lnd/aliasmgr"
 	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lnwire"
 )
 
 type NumberString uint64
@@ -51,22 +53,39 @@ func (f *ListChannelsFile) AsSummaryEntries() ([]*SummaryEntry, error) {
 type ListChannelsChannel struct {
 	RemotePubkey  string       `json:"remote_pubkey"`
 	ChannelPoint  string       `json:"channel_point"`
+	ChanID        NumberString `json:"chan_id"`
 	Capacity      NumberString `json:"capacity"`
 	Initiator     bool         `json:"initiator"`
 	LocalBalance  NumberString `json:"local_balance"`
 	RemoteBalance NumberString `json:"remote_balance"`
+	PendingHTLCs  []struct {
+		Incoming         bool         `json:"incoming"`
+		Amount           NumberString `json:"amount"`
+		ExpirationHeight uint32       `json:"expiration_height"`
+	} `json:"pending_htlcs"`
 }
 
 func (c *ListChannelsChannel) AsSummaryEntry() *SummaryEntry {
+	var htlcs []*HTLC
+	for _, htlc := range c.PendingHTLCs {
+		htlcs = append(htlcs, &HTLC{
+			Incoming:     htlc.Incoming,
+			Amount:       uint64(htlc.Amount),
+			ExpiryHeight: htlc.ExpirationHeight,
+		})
+	}
+
 	return &SummaryEntry{
 		RemotePubkey:   c.RemotePubkey,
 		ChannelPoint:   c.ChannelPoint,
 		FundingTXID:    FundingTXID(c.ChannelPoint),
 		FundingTXIndex: FundingTXIndex(c.ChannelPoint),
+		FundingHeight:  scidHeight(uint64(c.ChanID)),
 		Capacity:       uint64(c.Capacity),
 		Initiator:      c.Initiator,
 		LocalBalance:   uint64(c.LocalBalance),
 		RemoteBalance:  uint64(c.RemoteBalance),
+		PendingHTLCs:   htlcs,
 	}
 }
 
@@ -135,6 +154,23 @@ func (c *ChannelDBFile) AsSummaryEntries() ([]*SummaryEntry, error) {
 	}
 	result := make([]*SummaryEntry, len(channels))
 	for idx, channel := range channels {
+		commitmentType, err := CommitmentTypeFromChanType(
+			channel.ChanType,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("channel %v: %w",
+				channel.FundingOutpoint, err)
+		}
+
+		var htlcs []*HTLC
+		for _, htlc := range channel.LocalCommitment.Htlcs {
+			htlcs = append(htlcs, &HTLC{
+				Incoming:     htlc.Incoming,
+				Amount:       uint64(htlc.Amt.ToSatoshis()),
+				ExpiryHeight: htlc.RefundTimeout,
+			})
+		}
+
 		result[idx] = &SummaryEntry{
 			RemotePubkey: hex.EncodeToString(
 				channel.IdentityPub.SerializeCompressed(),
@@ -142,6 +178,7 @@ func (c *ChannelDBFile) AsSummaryEntries() ([]*SummaryEntry, error) {
 			ChannelPoint:   channel.FundingOutpoint.String(),
 			FundingTXID:    channel.FundingOutpoint.Hash.String(),
 			FundingTXIndex: channel.FundingOutpoint.Index,
+			FundingHeight:  fundingHeight(channel),
 			Capacity:       uint64(channel.Capacity),
 			Initiator:      channel.IsInitiator,
 			LocalBalance: uint64(
@@ -150,11 +187,38 @@ func (c *ChannelDBFile) AsSummaryEntries() ([]*SummaryEntry, error) {
 			RemoteBalance: uint64(
 				channel.LocalCommitment.RemoteBalance.ToSatoshis(),
 			),
+			CommitmentType: commitmentType,
+			PendingHTLCs:   htlcs,
 		}
 	}
 	return result, nil
 }
 
+// scidHeight returns the block height encoded in the given short channel ID or
+// zero if it is an alias.
+func scidHeight(chanID uint64) uint32 {
+	scid := lnwire.NewShortChanIDFromInt(chanID)
+	if aliasmgr.IsAlias(scid) {
+		return 0
+	}
+
+	return scid.BlockHeight
+}
+
+// fundingHeight returns the height of the block the funding transaction of the
+// channel was confirmed in or zero if it isn't confirmed yet.
+func fundingHeight(channel *channeldb.OpenChannel) uint32 {
+	if channel.IsPending {
+		return 0
+	}
+
+	if channel.IsZeroConf() {
+		return channel.ZeroConfRealScid().BlockHeight
+	}
+
+	return scidHeight(channel.ShortChannelID.ToUint64())
+}
+
 func (f *SummaryEntryFile) AsSummaryEntries() ([]*SummaryEntry, error) {
 	return f.Channels, nil
 }
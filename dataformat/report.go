@@ -0,0 +1,240 @@
+package dataformat
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+)
+
+const (
+	ReportFormatJSON = "json"
+	ReportFormatCSV  = "csv"
+	ReportFormatHTML = "html"
+)
+
+var (
+	csvHeader = []string{
+		"channel_point", "remote_pubkey", "capacity", "initiator",
+		"local_balance", "remote_balance", "chan_exists_onchain",
+		"closed", "force_close", "all_outputs_spent",
+		"has_potential_funds", "closing_txid", "closing_conf_height",
+		"our_addr", "to_remote_addr", "commitment_type",
+		"unspent_htlc_funds",
+	}
+
+	htmlReport = template.Must(template.New("summary").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>chantools channel summary</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; }
+td.num { text-align: right; }
+.mono { font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>Channel summary</h1>
+<h2>Totals</h2>
+<table>
+<tr><th>Open channels</th><td class="num">{{.OpenChannels}}</td></tr>
+<tr><th>Sats in open channels</th><td class="num">{{.FundsOpenChannels}}</td></tr>
+<tr><th>Closed channels</th><td class="num">{{.ClosedChannels}}</td></tr>
+<tr><th>Force closed channels</th><td class="num">{{.ForceClosedChannels}}</td></tr>
+<tr><th>Coop closed channels</th><td class="num">{{.CoopClosedChannels}}</td></tr>
+<tr><th>Closed channels with all outputs spent</th><td class="num">{{.FullySpentChannels}}</td></tr>
+<tr><th>Closed channels with unspent outputs</th><td class="num">{{.ChannelsWithUnspent}}</td></tr>
+<tr><th>Closed channels with potentially our outputs</th><td class="num">{{.ChannelsWithPotential}}</td></tr>
+<tr><th>Sats in closed channels</th><td class="num">{{.FundsClosedChannels}}</td></tr>
+<tr><th>Closed channel sats that have been swept/spent</th><td class="num">{{.FundsClosedSpent}}</td></tr>
+<tr><th>Closed channel sats in force-close outputs</th><td class="num">{{.FundsForceClose}}</td></tr>
+<tr><th>Closed channel sats in coop close outputs</th><td class="num">{{.FundsCoopClose}}</td></tr>
+<tr><th>Sats stuck in HTLC outputs</th><td class="num">{{.FundsHTLC}}</td></tr>
+<tr><th>Sats stuck in HTLC outputs that are potentially ours</th><td class="num">{{.FundsHTLCOurs}}</td></tr>
+</table>
+<h2>Channels</h2>
+<table>
+<tr>
+<th>Channel point</th><th>Remote pubkey</th><th>Capacity</th>
+<th>Local balance</th><th>Remote balance</th><th>State</th>
+<th>All outputs spent</th><th>Potential funds</th><th>Closing TXID</th>
+<th>Commitment type</th>
+</tr>
+{{- range .Channels}}
+<tr>
+<td class="mono">{{.ChannelPoint}}</td>
+<td class="mono">{{.RemotePubkey}}</td>
+<td class="num">{{.Capacity}}</td>
+<td class="num">{{.LocalBalance}}</td>
+<td class="num">{{.RemoteBalance}}</td>
+<td>{{.State}}</td>
+<td>{{if .ClosingTX}}{{.ClosingTX.AllOutsSpent}}{{end}}</td>
+<td>{{.HasPotential}}</td>
+<td class="mono">{{if .ClosingTX}}{{.ClosingTX.TXID}}{{end}}</td>
+<td>{{.CommitmentType}}</td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+)
+
+// State returns a short human readable description of the on-chain state of
+// the channel.
+func (e *SummaryEntry) State() string {
+	switch {
+	case !e.ChanExists:
+		return "not found"
+
+	case e.ClosingTX == nil:
+		return "open"
+
+	case e.ClosingTX.ForceClose:
+		return "force closed"
+
+	default:
+		return "coop closed"
+	}
+}
+
+// WriteReport writes the summary file to the given writer in the requested
+// format.
+func WriteReport(w io.Writer, f *SummaryEntryFile, format string) error {
+	switch format {
+	case ReportFormatJSON:
+		summaryBytes, err := json.MarshalIndent(f, "", " ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(summaryBytes)
+		return err
+
+	case ReportFormatCSV:
+		return writeCSVReport(w, f)
+
+	case ReportFormatHTML:
+		return htmlReport.Execute(w, f)
+
+	default:
+		return fmt.Errorf("invalid report format: %s", format)
+	}
+}
+
+// csvRow is a row of the CSV report. The fields are in the order of csvHeader.
+type csvRow struct {
+	channelPoint      string
+	remotePubkey      string
+	capacity          string
+	initiator         string
+	localBalance      string
+	remoteBalance     string
+	chanExists        string
+	closed            string
+	forceClose        string
+	allOutputsSpent   string
+	hasPotentialFunds string
+	closingTXID       string
+	closingConfHeight string
+	ourAddr           string
+	toRemoteAddr      string
+	commitmentType    string
+	unspentHTLCFunds  string
+}
+
+// record returns the fields of the row as CSV record.
+func (r *csvRow) record() []string {
+	return []string{
+		r.channelPoint, r.remotePubkey, r.capacity, r.initiator,
+		r.localBalance, r.remoteBalance, r.chanExists, r.closed,
+		r.forceClose, r.allOutputsSpent, r.hasPotentialFunds,
+		r.closingTXID, r.closingConfHeight, r.ourAddr, r.toRemoteAddr,
+		r.commitmentType, r.unspentHTLCFunds,
+	}
+}
+
+// writeCSVReport writes one row per channel followed by a totals row.
+func writeCSVReport(w io.Writer, f *SummaryEntryFile) error {
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write(csvHeader); err != nil {
+		return err
+	}
+
+	u64 := func(i uint64) string {
+		return strconv.FormatUint(i, 10)
+	}
+	var totalCapacity, totalLocal, totalRemote, totalHTLC uint64
+	for _, c := range f.Channels {
+		var (
+			closed, forceClose, allSpent bool
+			closingTXID, ourAddr         string
+			toRemoteAddr, confHeight     string
+			htlcFunds                    uint64
+		)
+		if c.ClosingTX != nil {
+			closed = true
+			forceClose = c.ClosingTX.ForceClose
+			allSpent = c.ClosingTX.AllOutsSpent
+			closingTXID = c.ClosingTX.TXID
+			confHeight = strconv.FormatUint(
+				uint64(c.ClosingTX.ConfHeight), 10,
+			)
+			ourAddr = c.ClosingTX.OurAddr
+			toRemoteAddr = c.ClosingTX.ToRemoteAddr
+
+			for _, htlc := range c.ClosingTX.HTLCOutputs {
+				if !htlc.Spent {
+					htlcFunds += htlc.Value
+				}
+			}
+		}
+
+		row := &csvRow{
+			channelPoint:      c.ChannelPoint,
+			remotePubkey:      c.RemotePubkey,
+			capacity:          u64(c.Capacity),
+			initiator:         strconv.FormatBool(c.Initiator),
+			localBalance:      u64(c.LocalBalance),
+			remoteBalance:     u64(c.RemoteBalance),
+			chanExists:        strconv.FormatBool(c.ChanExists),
+			closed:            strconv.FormatBool(closed),
+			forceClose:        strconv.FormatBool(forceClose),
+			allOutputsSpent:   strconv.FormatBool(allSpent),
+			hasPotentialFunds: strconv.FormatBool(c.HasPotential),
+			closingTXID:       closingTXID,
+			closingConfHeight: confHeight,
+			ourAddr:           ourAddr,
+			toRemoteAddr:      toRemoteAddr,
+			commitmentType:    c.CommitmentType,
+			unspentHTLCFunds:  u64(htlcFunds),
+		}
+		if err := csvWriter.Write(row.record()); err != nil {
+			return err
+		}
+
+		totalCapacity += c.Capacity
+		totalLocal += c.LocalBalance
+		totalRemote += c.RemoteBalance
+		totalHTLC += htlcFunds
+	}
+
+	totals := &csvRow{
+		channelPoint:     "total",
+		capacity:         u64(totalCapacity),
+		localBalance:     u64(totalLocal),
+		remoteBalance:    u64(totalRemote),
+		unspentHTLCFunds: u64(totalHTLC),
+	}
+	if err := csvWriter.Write(totals.record()); err != nil {
+		return err
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
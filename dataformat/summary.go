@@ -5,9 +5,92 @@ import (
 	"fmt"
 
 	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/keychain"
 )
 
+const (
+	CommitmentTypeLegacy          = "legacy"
+	CommitmentTypeStaticRemoteKey = "static_remote_key"
+	CommitmentTypeAnchors         = "anchors"
+	CommitmentTypeLease           = "script_enforced_lease"
+	CommitmentTypeTaproot         = "taproot"
+
+	// CommitmentTypeLegacyOrStaticRemoteKey is used if the commitment type
+	// was detected from the closing transaction only. Legacy and static
+	// remote key commitments look the same on chain.
+	CommitmentTypeLegacyOrStaticRemoteKey = "legacy_or_static_remote_key"
+
+	// CommitmentTypeAnchorsOrLease is used if the commitment type was
+	// detected from the closing transaction only. Anchor and script
+	// enforced lease commitments look the same on chain.
+	CommitmentTypeAnchorsOrLease = "anchors_or_script_enforced_lease"
+)
+
+const (
+	// SimpleTaprootFeatureBit is the channel type bit of simple taproot
+	// channels. It is channeldb.SimpleTaprootFeatureBit of lnd v0.17.0-beta,
+	// which is newer than the lnd version we depend on.
+	SimpleTaprootFeatureBit channeldb.ChannelType = 1 << 10
+
+	// knownChanTypeBits are all channel type bits we know the commitment
+	// type of.
+	knownChanTypeBits = channeldb.DualFunderBit |
+		channeldb.SingleFunderTweaklessBit | channeldb.NoFundingTxBit |
+		channeldb.AnchorOutputsBit | channeldb.FrozenBit |
+		channeldb.ZeroHtlcTxFeeBit | channeldb.LeaseExpirationBit |
+		channeldb.ZeroConfBit | channeldb.ScidAliasChanBit |
+		channeldb.ScidAliasFeatureBit | SimpleTaprootFeatureBit
+)
+
+// CommitmentTypeFromChanType returns the commitment type name of an lnd channel
+// type. An error is returned if the channel type has bits set that we don't
+// know, as we can't tell what its commitment looks like.
+func CommitmentTypeFromChanType(chanType channeldb.ChannelType) (string,
+	error) {
+
+	if unknown := chanType &^ knownChanTypeBits; unknown != 0 {
+		return "", fmt.Errorf("unknown channel type %d (unknown bits "+
+			"%d)", chanType, unknown)
+	}
+
+	switch {
+	case chanType&SimpleTaprootFeatureBit == SimpleTaprootFeatureBit:
+		return CommitmentTypeTaproot, nil
+
+	case chanType.HasLeaseExpiration():
+		return CommitmentTypeLease, nil
+
+	case chanType.HasAnchors():
+		return CommitmentTypeAnchors, nil
+
+	case chanType.IsTweakless():
+		return CommitmentTypeStaticRemoteKey, nil
+
+	default:
+		return CommitmentTypeLegacy, nil
+	}
+}
+
+// SweepCommandForCommitmentType returns the chantools command that can be used
+// to sweep our funds from a remote force close of a channel with the given
+// commitment type.
+func SweepCommandForCommitmentType(commitmentType string) string {
+	switch commitmentType {
+	case CommitmentTypeLegacy:
+		return "rescueclosed"
+
+	case CommitmentTypeStaticRemoteKey, CommitmentTypeAnchors,
+		CommitmentTypeTaproot, CommitmentTypeLegacyOrStaticRemoteKey,
+		CommitmentTypeAnchorsOrLease:
+
+		return "sweepremoteclosed"
+
+	default:
+		return ""
+	}
+}
+
 type ClosingTX struct {
 	TXID         string `json:"txid"`
 	ForceClose   bool   `json:"force_close"`
@@ -16,6 +99,38 @@ type ClosingTX struct {
 	ToRemoteAddr string `json:"to_remote_addr"`
 	SweepPrivkey string `json:"sweep_privkey"`
 	ConfHeight   uint32 `json:"conf_height"`
+
+	HTLCOutputs []*HTLCOutput `json:"htlc_outputs,omitempty"`
+}
+
+const (
+	HTLCDirectionIncoming = "incoming"
+	HTLCDirectionOutgoing = "outgoing"
+	HTLCDirectionUnknown  = "unknown"
+)
+
+// HTLC is an HTLC that was pending on our commitment of a channel.
+type HTLC struct {
+	Incoming bool   `json:"incoming"`
+	Amount   uint64 `json:"amount"`
+
+	// ExpiryHeight is the CLTV expiry height of the HTLC, if known.
+	ExpiryHeight uint32 `json:"expiry_height,omitempty"`
+}
+
+// HTLCOutput is an HTLC output of a force close transaction.
+type HTLCOutput struct {
+	Index uint32 `json:"index"`
+	Value uint64 `json:"value"`
+	Spent bool   `json:"spent"`
+
+	// Direction is incoming or outgoing if the output could be matched to
+	// one of our pending HTLCs and unknown otherwise.
+	Direction string `json:"direction"`
+
+	// ExpiryHeight is the CLTV expiry height of the matched HTLC, if
+	// known.
+	ExpiryHeight uint32 `json:"expiry_height,omitempty"`
 }
 
 type BasePoint struct {
@@ -66,6 +181,7 @@ type SummaryEntry struct {
 	ChannelPoint   string      `json:"channel_point"`
 	FundingTXID    string      `json:"funding_txid"`
 	FundingTXIndex uint32      `json:"funding_tx_index"`
+	FundingHeight  uint32      `json:"funding_height,omitempty"`
 	Capacity       uint64      `json:"capacity"`
 	Initiator      bool        `json:"initiator"`
 	LocalBalance   uint64      `json:"local_balance"`
@@ -74,6 +190,8 @@ type SummaryEntry struct {
 	HasPotential   bool        `json:"has_potential_funds"`
 	ClosingTX      *ClosingTX  `json:"closing_tx,omitempty"`
 	ForceClose     *ForceClose `json:"force_close"`
+	CommitmentType string      `json:"commitment_type,omitempty"`
+	PendingHTLCs   []*HTLC     `json:"pending_htlcs,omitempty"`
 }
 
 type SummaryEntryFile struct {
@@ -90,4 +208,6 @@ type SummaryEntryFile struct {
 	FundsClosedSpent      uint64          `json:"funds_closed_channels_spent"`
 	FundsForceClose       uint64          `json:"funds_force_closed_maybe_ours"`
 	FundsCoopClose        uint64          `json:"funds_coop_closed_maybe_ours"`
+	FundsHTLC             uint64          `json:"funds_in_htlc_outputs"`
+	FundsHTLCOurs         uint64          `json:"funds_in_htlc_outputs_maybe_ours"`
 }
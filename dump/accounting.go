@@ -0,0 +1,266 @@
+package dump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/invoices"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+// CSVRecord is a dump type that can be written as a row of a CSV file.
+type CSVRecord interface {
+	// CSVHeader returns the column names of the CSV file.
+	CSVHeader() []string
+
+	// CSVRecord returns the values of the row, in the same order as the
+	// column names.
+	CSVRecord() []string
+}
+
+// Payment is the information we want to dump from an outgoing payment in lnd's
+// channel DB. See `channeldb.MPPayment` for information about the fields.
+type Payment struct {
+	SequenceNum     uint64              `json:"sequence_num"`
+	PaymentHash     string              `json:"payment_hash"`
+	Status          string              `json:"status"`
+	ValueMsat       lnwire.MilliSatoshi `json:"value_msat"`
+	FeeMsat         lnwire.MilliSatoshi `json:"fee_msat"`
+	CreationTime    time.Time           `json:"creation_time"`
+	SettleTime      *time.Time          `json:"settle_time,omitempty"`
+	PaymentPreimage string              `json:"payment_preimage,omitempty"`
+	PaymentRequest  string              `json:"payment_request,omitempty"`
+	FailureReason   string              `json:"failure_reason,omitempty"`
+	NumAttempts     int                 `json:"num_attempts"`
+}
+
+// Enforce Payment implements the CSVRecord interface.
+var _ CSVRecord = (*Payment)(nil)
+
+// CSVHeader returns the column names of the CSV file.
+func (p *Payment) CSVHeader() []string {
+	return []string{
+		"sequence_num", "payment_hash", "status", "value_msat",
+		"fee_msat", "creation_time", "settle_time", "payment_preimage",
+		"payment_request", "failure_reason", "num_attempts",
+	}
+}
+
+// CSVRecord returns the values of the row, in the same order as the column
+// names.
+func (p *Payment) CSVRecord() []string {
+	return []string{
+		strconv.FormatUint(p.SequenceNum, 10), p.PaymentHash, p.Status,
+		formatMsat(p.ValueMsat), formatMsat(p.FeeMsat),
+		formatTime(&p.CreationTime), formatTime(p.SettleTime),
+		p.PaymentPreimage, p.PaymentRequest, p.FailureReason,
+		strconv.Itoa(p.NumAttempts),
+	}
+}
+
+// Invoice is the information we want to dump from an invoice in lnd's channel
+// DB. See `invoices.Invoice` for information about the fields.
+type Invoice struct {
+	AddIndex        uint64              `json:"add_index"`
+	SettleIndex     uint64              `json:"settle_index"`
+	PaymentHash     string              `json:"payment_hash,omitempty"`
+	Memo            string              `json:"memo,omitempty"`
+	PaymentRequest  string              `json:"payment_request,omitempty"`
+	State           string              `json:"state"`
+	ValueMsat       lnwire.MilliSatoshi `json:"value_msat"`
+	AmtPaidMsat     lnwire.MilliSatoshi `json:"amt_paid_msat"`
+	CreationDate    time.Time           `json:"creation_date"`
+	SettleDate      *time.Time          `json:"settle_date,omitempty"`
+	PaymentPreimage string              `json:"payment_preimage,omitempty"`
+	HodlInvoice     bool                `json:"hodl_invoice"`
+}
+
+// Enforce Invoice implements the CSVRecord interface.
+var _ CSVRecord = (*Invoice)(nil)
+
+// CSVHeader returns the column names of the CSV file.
+func (i *Invoice) CSVHeader() []string {
+	return []string{
+		"add_index", "settle_index", "payment_hash", "memo",
+		"payment_request", "state", "value_msat", "amt_paid_msat",
+		"creation_date", "settle_date", "payment_preimage",
+		"hodl_invoice",
+	}
+}
+
+// CSVRecord returns the values of the row, in the same order as the column
+// names.
+func (i *Invoice) CSVRecord() []string {
+	return []string{
+		strconv.FormatUint(i.AddIndex, 10),
+		strconv.FormatUint(i.SettleIndex, 10), i.PaymentHash, i.Memo,
+		i.PaymentRequest, i.State, formatMsat(i.ValueMsat),
+		formatMsat(i.AmtPaidMsat), formatTime(&i.CreationDate),
+		formatTime(i.SettleDate), i.PaymentPreimage,
+		strconv.FormatBool(i.HodlInvoice),
+	}
+}
+
+// ForwardingEvent is the information we want to dump from an entry of lnd's
+// forwarding log. See `channeldb.ForwardingEvent` for information about the
+// fields.
+type ForwardingEvent struct {
+	Timestamp      time.Time             `json:"timestamp"`
+	IncomingChanID lnwire.ShortChannelID `json:"incoming_chan_id"`
+	OutgoingChanID lnwire.ShortChannelID `json:"outgoing_chan_id"`
+	AmtInMsat      lnwire.MilliSatoshi   `json:"amt_in_msat"`
+	AmtOutMsat     lnwire.MilliSatoshi   `json:"amt_out_msat"`
+	FeeMsat        lnwire.MilliSatoshi   `json:"fee_msat"`
+}
+
+// Enforce ForwardingEvent implements the CSVRecord interface.
+var _ CSVRecord = (*ForwardingEvent)(nil)
+
+// CSVHeader returns the column names of the CSV file.
+func (f *ForwardingEvent) CSVHeader() []string {
+	return []string{
+		"timestamp", "incoming_chan_id", "outgoing_chan_id",
+		"amt_in_msat", "amt_out_msat", "fee_msat",
+	}
+}
+
+// CSVRecord returns the values of the row, in the same order as the column
+// names.
+func (f *ForwardingEvent) CSVRecord() []string {
+	return []string{
+		formatTime(&f.Timestamp), f.IncomingChanID.String(),
+		f.OutgoingChanID.String(), formatMsat(f.AmtInMsat),
+		formatMsat(f.AmtOutMsat), formatMsat(f.FeeMsat),
+	}
+}
+
+// PaymentDump converts the payments of lnd's channel DB into the dump format.
+func PaymentDump(payments []*channeldb.MPPayment) []*Payment {
+	dumpPayments := make([]*Payment, len(payments))
+	for idx, payment := range payments {
+		dumpPayment := &Payment{
+			SequenceNum:    payment.SequenceNum,
+			PaymentHash:    payment.Info.PaymentIdentifier.String(),
+			Status:         payment.Status.String(),
+			ValueMsat:      payment.Info.Value,
+			CreationTime:   payment.Info.CreationTime,
+			PaymentRequest: string(payment.Info.PaymentRequest),
+			NumAttempts:    len(payment.HTLCs),
+		}
+
+		// Only the fees of the successful HTLCs were actually paid.
+		_, dumpPayment.FeeMsat = payment.SentAmt()
+
+		settle, failure := payment.TerminalInfo()
+		if settle != nil {
+			settleTime := settle.SettleTime
+			dumpPayment.SettleTime = &settleTime
+			dumpPayment.PaymentPreimage = settle.Preimage.String()
+		}
+		if failure != nil {
+			dumpPayment.FailureReason = failure.String()
+		}
+
+		dumpPayments[idx] = dumpPayment
+	}
+
+	return dumpPayments
+}
+
+// InvoiceDump converts the invoices of lnd's channel DB into the dump format.
+func InvoiceDump(invoiceList []invoices.Invoice,
+	params *chaincfg.Params) []*Invoice {
+
+	dumpInvoices := make([]*Invoice, len(invoiceList))
+	for idx := range invoiceList {
+		invoice := invoiceList[idx]
+		dumpInvoice := &Invoice{
+			AddIndex:       invoice.AddIndex,
+			SettleIndex:    invoice.SettleIndex,
+			PaymentHash:    invoicePaymentHash(&invoice, params),
+			Memo:           string(invoice.Memo),
+			PaymentRequest: string(invoice.PaymentRequest),
+			State:          invoice.State.String(),
+			ValueMsat:      invoice.Terms.Value,
+			AmtPaidMsat:    invoice.AmtPaid,
+			CreationDate:   invoice.CreationDate,
+			HodlInvoice:    invoice.HodlInvoice,
+		}
+		if !invoice.SettleDate.IsZero() {
+			settleDate := invoice.SettleDate
+			dumpInvoice.SettleDate = &settleDate
+		}
+		if invoice.Terms.PaymentPreimage != nil {
+			dumpInvoice.PaymentPreimage =
+				invoice.Terms.PaymentPreimage.String()
+		}
+
+		dumpInvoices[idx] = dumpInvoice
+	}
+
+	return dumpInvoices
+}
+
+// ForwardingEventDump converts the entries of lnd's forwarding log into the
+// dump format.
+func ForwardingEventDump(
+	events []channeldb.ForwardingEvent) []*ForwardingEvent {
+
+	dumpEvents := make([]*ForwardingEvent, len(events))
+	for idx, event := range events {
+		dumpEvents[idx] = &ForwardingEvent{
+			Timestamp:      event.Timestamp,
+			IncomingChanID: event.IncomingChanID,
+			OutgoingChanID: event.OutgoingChanID,
+			AmtInMsat:      event.AmtIn,
+			AmtOutMsat:     event.AmtOut,
+			FeeMsat:        event.AmtIn - event.AmtOut,
+		}
+	}
+
+	return dumpEvents
+}
+
+// invoicePaymentHash returns the payment hash of an invoice. The hash is not
+// stored with the invoice itself, so we either derive it from the preimage or
+// decode it from the payment request. An empty string is returned if neither
+// is available (for example for AMP invoices).
+func invoicePaymentHash(invoice *invoices.Invoice,
+	params *chaincfg.Params) string {
+
+	if invoice.Terms.PaymentPreimage != nil {
+		hash := sha256.Sum256(invoice.Terms.PaymentPreimage[:])
+		return hex.EncodeToString(hash[:])
+	}
+
+	if len(invoice.PaymentRequest) == 0 {
+		return ""
+	}
+
+	payReq, err := zpay32.Decode(string(invoice.PaymentRequest), params)
+	if err != nil || payReq.PaymentHash == nil {
+		return ""
+	}
+
+	return hex.EncodeToString(payReq.PaymentHash[:])
+}
+
+// formatMsat formats a milli-satoshi amount as a plain number.
+func formatMsat(amt lnwire.MilliSatoshi) string {
+	return strconv.FormatUint(uint64(amt), 10)
+}
+
+// formatTime formats a timestamp in the same RFC3339 format that is used for
+// the JSON output. Unset timestamps are returned as an empty string.
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,198 @@
+package dump
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/chanbackup"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/tor"
+)
+
+// nilPubKey is the string PubKeyToString returns for a nil public key.
+const nilPubKey = "<nil>"
+
+// ToMulti converts the dumped multi backup back into its original form so it
+// can be packed and encrypted again.
+func (m *BackupMulti) ToMulti() (*chanbackup.Multi, error) {
+	multi := &chanbackup.Multi{
+		Version: m.Version,
+		StaticBackups: make(
+			[]chanbackup.Single, len(m.StaticBackups),
+		),
+	}
+	for idx := range m.StaticBackups {
+		single, err := m.StaticBackups[idx].ToSingle()
+		if err != nil {
+			return nil, fmt.Errorf("error converting backup %d: "+
+				"%w", idx, err)
+		}
+		multi.StaticBackups[idx] = *single
+	}
+
+	return multi, nil
+}
+
+// ToSingle converts the dumped single backup back into its original form.
+func (s *BackupSingle) ToSingle() (*chanbackup.Single, error) {
+	chainHash, err := chainhash.NewHashFromStr(s.ChainHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chain hash: %w", err)
+	}
+
+	fundingOutpoint, err := lnd.ParseOutpoint(s.FundingOutpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid funding outpoint: %w", err)
+	}
+
+	remoteNodePub, err := stringToPubKey(s.RemoteNodePub)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote node pubkey: %w", err)
+	}
+
+	addrs := make([]net.Addr, len(s.Addresses))
+	for idx, addr := range s.Addresses {
+		addrs[idx], err = parseAddress(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %s: %w", addr,
+				err)
+		}
+	}
+
+	localChanCfg, err := s.LocalChanCfg.toChannelConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid local channel config: %w", err)
+	}
+	remoteChanCfg, err := s.RemoteChanCfg.toChannelConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote channel config: %w",
+			err)
+	}
+	shaChainRootDesc, err := s.ShaChainRootDesc.toKeyDescriptor()
+	if err != nil {
+		return nil, fmt.Errorf("invalid shachain root descriptor: %w",
+			err)
+	}
+
+	return &chanbackup.Single{
+		Version:          s.Version,
+		IsInitiator:      s.IsInitiator,
+		ChainHash:        *chainHash,
+		FundingOutpoint:  *fundingOutpoint,
+		ShortChannelID:   s.ShortChannelID,
+		RemoteNodePub:    remoteNodePub,
+		Addresses:        addrs,
+		Capacity:         s.Capacity,
+		LocalChanCfg:     *localChanCfg,
+		RemoteChanCfg:    *remoteChanCfg,
+		ShaChainRootDesc: *shaChainRootDesc,
+		LeaseExpiry:      s.LeaseExpiry,
+	}, nil
+}
+
+// toChannelConfig converts the dumped channel config back into its original
+// form.
+func (c *ChannelConfig) toChannelConfig() (*channeldb.ChannelConfig, error) {
+	cfg := &channeldb.ChannelConfig{
+		ChannelConstraints: c.ChannelConstraints,
+	}
+
+	keys := []struct {
+		dumped *KeyDescriptor
+		target *keychain.KeyDescriptor
+	}{
+		{&c.MultiSigKey, &cfg.MultiSigKey},
+		{&c.RevocationBasePoint, &cfg.RevocationBasePoint},
+		{&c.PaymentBasePoint, &cfg.PaymentBasePoint},
+		{&c.DelayBasePoint, &cfg.DelayBasePoint},
+		{&c.HtlcBasePoint, &cfg.HtlcBasePoint},
+	}
+	for _, key := range keys {
+		desc, err := key.dumped.toKeyDescriptor()
+		if err != nil {
+			return nil, err
+		}
+		*key.target = *desc
+	}
+
+	return cfg, nil
+}
+
+// toKeyDescriptor converts the dumped key descriptor back into its original
+// form. The key family and index are extracted from the derivation path.
+func (k *KeyDescriptor) toKeyDescriptor() (*keychain.KeyDescriptor, error) {
+	var coinType, family, index uint32
+	_, err := fmt.Sscanf(
+		k.Path, lndInternalDerivationPath, &coinType, &family, &index,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key path %s: %w", k.Path, err)
+	}
+
+	pubKey, err := stringToPubKey(k.PubKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pubkey %s: %w", k.PubKey, err)
+	}
+
+	return &keychain.KeyDescriptor{
+		KeyLocator: keychain.KeyLocator{
+			Family: keychain.KeyFamily(family),
+			Index:  index,
+		},
+		PubKey: pubKey,
+	}, nil
+}
+
+// stringToPubKey is the inverse of PubKeyToString.
+func stringToPubKey(pubKeyHex string) (*btcec.PublicKey, error) {
+	if pubKeyHex == nilPubKey || pubKeyHex == "" {
+		return nil, nil
+	}
+
+	pubKeyBytes, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		return nil, err
+	}
+
+	return btcec.ParsePubKey(pubKeyBytes)
+}
+
+// addressesToStrings returns the string representation of the given network
+// addresses.
+func addressesToStrings(addrs []net.Addr) []string {
+	result := make([]string, len(addrs))
+	for idx, addr := range addrs {
+		result[idx] = addr.String()
+	}
+
+	return result
+}
+
+// parseAddress parses a network address in the host:port format, which can
+// either be an IP or a Tor onion address.
+func parseAddress(addr string) (net.Addr, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if tor.IsOnionHost(host) {
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &tor.OnionAddr{
+			OnionService: host,
+			Port:         port,
+		}, nil
+	}
+
+	return net.ResolveTCPAddr("tcp", addr)
+}
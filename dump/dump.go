@@ -2,14 +2,17 @@ package dump
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/lightningnetwork/lnd/chanbackup"
 	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/input"
@@ -21,76 +24,147 @@ const (
 	lndInternalDerivationPath = "m/1017'/%d'/%d'/0/%d"
 )
 
-// BackupSingle is the information we want to dump from an lnd channel backup
+// BackupMulti is the information we want to dump from an lnd channel backup
 // multi file. See `chanbackup.Multi` for information about the fields.
 type BackupMulti struct {
-	Version       chanbackup.MultiBackupVersion
-	StaticBackups []BackupSingle
+	Version       chanbackup.MultiBackupVersion `json:"version"`
+	StaticBackups []BackupSingle                `json:"static_backups"`
 }
 
 // BackupSingle is the information we want to dump from an lnd channel backup.
 // See `chanbackup.Single` for information about the fields.
 type BackupSingle struct {
-	Version          chanbackup.SingleBackupVersion
-	IsInitiator      bool
-	ChainHash        string
-	FundingOutpoint  string
-	ShortChannelID   lnwire.ShortChannelID
-	RemoteNodePub    string
-	Addresses        []net.Addr
-	Capacity         btcutil.Amount
-	LocalChanCfg     ChannelConfig
-	RemoteChanCfg    ChannelConfig
-	ShaChainRootDesc KeyDescriptor
+	Version          chanbackup.SingleBackupVersion `json:"version"`
+	IsInitiator      bool                           `json:"is_initiator"`
+	ChainHash        string                         `json:"chain_hash"`
+	FundingOutpoint  string                         `json:"funding_outpoint"`
+	ShortChannelID   lnwire.ShortChannelID          `json:"short_channel_id"`
+	RemoteNodePub    string                         `json:"remote_node_pub"`
+	Addresses        []string                       `json:"addresses"`
+	Capacity         btcutil.Amount                 `json:"capacity"`
+	LocalChanCfg     ChannelConfig                  `json:"local_chan_cfg"`
+	RemoteChanCfg    ChannelConfig                  `json:"remote_chan_cfg"`
+	ShaChainRootDesc KeyDescriptor                  `json:"sha_chain_root_desc"`
+	LeaseExpiry      uint32                         `json:"lease_expiry,omitempty"`
 }
 
 // OpenChannel is the information we want to dump from an open channel in lnd's
 // channel DB. See `channeldb.OpenChannel` for information about the fields.
 type OpenChannel struct {
-	ChanType                channeldb.ChannelType
-	ChainHash               chainhash.Hash
-	FundingOutpoint         string
-	ShortChannelID          lnwire.ShortChannelID
-	IsPending               bool
-	IsInitiator             bool
-	ChanStatus              channeldb.ChannelStatus
-	FundingBroadcastHeight  uint32
-	NumConfsRequired        uint16
-	ChannelFlags            lnwire.FundingFlag
-	IdentityPub             string
-	Capacity                btcutil.Amount
-	TotalMSatSent           lnwire.MilliSatoshi
-	TotalMSatReceived       lnwire.MilliSatoshi
-	PerCommitPoint          string
-	LocalChanCfg            ChannelConfig
-	RemoteChanCfg           ChannelConfig
-	LocalCommitment         channeldb.ChannelCommitment
-	RemoteCommitment        channeldb.ChannelCommitment
-	RemoteCurrentRevocation string
-	RemoteNextRevocation    string
-	FundingTxn              string
-	LocalShutdownScript     lnwire.DeliveryAddress
-	RemoteShutdownScript    lnwire.DeliveryAddress
+	ChanType                channeldb.ChannelType       `json:"chan_type"`
+	ChainHash               chainhash.Hash              `json:"chain_hash"`
+	FundingOutpoint         string                      `json:"funding_outpoint"`
+	ShortChannelID          lnwire.ShortChannelID       `json:"short_channel_id"`
+	IsPending               bool                        `json:"is_pending"`
+	IsInitiator             bool                        `json:"is_initiator"`
+	ChanStatus              channeldb.ChannelStatus     `json:"chan_status"`
+	FundingBroadcastHeight  uint32                      `json:"funding_broadcast_height"`
+	NumConfsRequired        uint16                      `json:"num_confs_required"`
+	ChannelFlags            lnwire.FundingFlag          `json:"channel_flags"`
+	IdentityPub             string                      `json:"identity_pub"`
+	Capacity                btcutil.Amount              `json:"capacity"`
+	TotalMSatSent           lnwire.MilliSatoshi         `json:"total_msat_sent"`
+	TotalMSatReceived       lnwire.MilliSatoshi         `json:"total_msat_received"`
+	PerCommitPoint          string                      `json:"per_commit_point"`
+	LocalChanCfg            ChannelConfig               `json:"local_chan_cfg"`
+	RemoteChanCfg           ChannelConfig               `json:"remote_chan_cfg"`
+	LocalCommitment         channeldb.ChannelCommitment `json:"local_commitment"`
+	RemoteCommitment        channeldb.ChannelCommitment `json:"remote_commitment"`
+	RemoteCurrentRevocation string                      `json:"remote_current_revocation"`
+	RemoteNextRevocation    string                      `json:"remote_next_revocation"`
+	FundingTxn              string                      `json:"funding_txn"`
+	FundingAddress          string                      `json:"funding_address"`
+	LocalShutdownScript     lnwire.DeliveryAddress      `json:"local_shutdown_script"`
+	RemoteShutdownScript    lnwire.DeliveryAddress      `json:"remote_shutdown_script"`
+	BroadcastedCommitment   string                      `json:"broadcasted_commitment,omitempty"`
+	BroadcastedCooperative  string                      `json:"broadcasted_cooperative,omitempty"`
+}
+
+// MarshalJSON encodes the channel as JSON. The commitments and shutdown scripts
+// are converted into a format with hex encoded transactions, signatures,
+// hashes and scripts first.
+func (c OpenChannel) MarshalJSON() ([]byte, error) {
+	localCommit, err := ToChannelCommitment(c.LocalCommitment)
+	if err != nil {
+		return nil, err
+	}
+	remoteCommit, err := ToChannelCommitment(c.RemoteCommitment)
+	if err != nil {
+		return nil, err
+	}
+
+	// The fields of the outer struct take precedence over the ones of the
+	// embedded struct with the same JSON name.
+	type plainOpenChannel OpenChannel
+	return json.Marshal(&struct {
+		plainOpenChannel
+		LocalCommitment      ChannelCommitment `json:"local_commitment"`
+		RemoteCommitment     ChannelCommitment `json:"remote_commitment"`
+		LocalShutdownScript  string            `json:"local_shutdown_script"`
+		RemoteShutdownScript string            `json:"remote_shutdown_script"`
+	}{
+		plainOpenChannel: plainOpenChannel(c),
+		LocalCommitment:  localCommit,
+		RemoteCommitment: remoteCommit,
+		LocalShutdownScript: hex.EncodeToString(
+			c.LocalShutdownScript,
+		),
+		RemoteShutdownScript: hex.EncodeToString(
+			c.RemoteShutdownScript,
+		),
+	})
+}
+
+// ChannelCommitment is the information we want to dump from a channel
+// commitment. See `channeldb.ChannelCommitment` for information about the
+// fields.
+type ChannelCommitment struct {
+	CommitHeight    uint64              `json:"commit_height"`
+	LocalLogIndex   uint64              `json:"local_log_index"`
+	LocalHtlcIndex  uint64              `json:"local_htlc_index"`
+	RemoteLogIndex  uint64              `json:"remote_log_index"`
+	RemoteHtlcIndex uint64              `json:"remote_htlc_index"`
+	LocalBalance    lnwire.MilliSatoshi `json:"local_balance"`
+	RemoteBalance   lnwire.MilliSatoshi `json:"remote_balance"`
+	CommitFee       btcutil.Amount      `json:"commit_fee"`
+	FeePerKw        btcutil.Amount      `json:"fee_per_kw"`
+	CommitTx        string              `json:"commit_tx"`
+	CommitSig       string              `json:"commit_sig"`
+	Htlcs           []HTLC              `json:"htlcs"`
+}
+
+// HTLC is the information we want to dump from an HTLC of a channel
+// commitment. See `channeldb.HTLC` for information about the fields.
+type HTLC struct {
+	RHash         string              `json:"rhash"`
+	Amt           lnwire.MilliSatoshi `json:"amt_msat"`
+	RefundTimeout uint32              `json:"refund_timeout"`
+	OutputIndex   int32               `json:"output_index"`
+	Incoming      bool                `json:"incoming"`
+	HtlcIndex     uint64              `json:"htlc_index"`
+	LogIndex      uint64              `json:"log_index"`
 }
 
 // ClosedChannel is the information we want to dump from a closed channel in
 // lnd's channel DB. See `channeldb.ChannelCloseSummary` for information about
 // the fields.
 type ClosedChannel struct {
-	ChanPoint               string
-	ShortChanID             lnwire.ShortChannelID
-	ChainHash               chainhash.Hash
-	ClosingTXID             string
-	RemotePub               string
-	Capacity                btcutil.Amount
-	CloseHeight             uint32
-	SettledBalance          btcutil.Amount
-	TimeLockedBalance       btcutil.Amount
-	CloseType               string
-	IsPending               bool
-	RemoteCurrentRevocation string
-	RemoteNextRevocation    string
-	LocalChanConfig         ChannelConfig
+	ChanPoint               string                `json:"chan_point"`
+	ShortChanID             lnwire.ShortChannelID `json:"short_chan_id"`
+	ChainHash               chainhash.Hash        `json:"chain_hash"`
+	ClosingTXID             string                `json:"closing_txid"`
+	RemotePub               string                `json:"remote_pub"`
+	Capacity                btcutil.Amount        `json:"capacity"`
+	CloseHeight             uint32                `json:"close_height"`
+	SettledBalance          btcutil.Amount        `json:"settled_balance"`
+	TimeLockedBalance       btcutil.Amount        `json:"time_locked_balance"`
+	CloseType               string                `json:"close_type"`
+	CloseTypeName           string                `json:"close_type_name"`
+	IsPending               bool                  `json:"is_pending"`
+	RemoteCurrentRevocation string                `json:"remote_current_revocation"`
+	RemoteNextRevocation    string                `json:"remote_next_revocation"`
+	LocalChanConfig         ChannelConfig         `json:"local_chan_config"`
+	HistoricalChannel       *OpenChannel          `json:"historical_channel,omitempty"`
 }
 
 // ChannelConfig is the information we want to dump from a channel
@@ -98,18 +172,18 @@ type ClosedChannel struct {
 // fields.
 type ChannelConfig struct {
 	channeldb.ChannelConstraints
-	MultiSigKey         KeyDescriptor
-	RevocationBasePoint KeyDescriptor
-	PaymentBasePoint    KeyDescriptor
-	DelayBasePoint      KeyDescriptor
-	HtlcBasePoint       KeyDescriptor
+	MultiSigKey         KeyDescriptor `json:"multi_sig_key"`
+	RevocationBasePoint KeyDescriptor `json:"revocation_base_point"`
+	PaymentBasePoint    KeyDescriptor `json:"payment_base_point"`
+	DelayBasePoint      KeyDescriptor `json:"delay_base_point"`
+	HtlcBasePoint       KeyDescriptor `json:"htlc_base_point"`
 }
 
 // KeyDescriptor is the information we want to dump from a key descriptor. See
 // `keychain.KeyDescriptor` for more information about the fields.
 type KeyDescriptor struct {
-	Path   string
-	PubKey string
+	Path   string `json:"path"`
+	PubKey string `json:"pub_key"`
 }
 
 // OpenChannelDump converts the open channels in the given channel DB into a
@@ -134,6 +208,22 @@ func OpenChannelDump(channels []*channeldb.OpenChannel,
 		}
 		perCommitPoint := input.ComputeCommitmentPoint(revPreimage[:])
 
+		fundingAddr, err := fundingAddress(channel, params)
+		if err != nil {
+			return nil, err
+		}
+
+		// Channels that are waiting for a close transaction to confirm
+		// have it stored in the DB.
+		forceCloseTx, err := closingTx(channel.BroadcastedCommitment)
+		if err != nil {
+			return nil, err
+		}
+		coopCloseTx, err := closingTx(channel.BroadcastedCooperative)
+		if err != nil {
+			return nil, err
+		}
+
 		dumpChannels[idx] = OpenChannel{
 			ChanType:               channel.ChanType,
 			ChainHash:              channel.ChainHash,
@@ -166,18 +256,76 @@ func OpenChannelDump(channels []*channeldb.OpenChannel,
 			RemoteNextRevocation: PubKeyToString(
 				channel.RemoteNextRevocation,
 			),
-			FundingTxn:           hex.EncodeToString(buf.Bytes()),
-			LocalShutdownScript:  channel.LocalShutdownScript,
-			RemoteShutdownScript: channel.RemoteShutdownScript,
+			FundingTxn:             hex.EncodeToString(buf.Bytes()),
+			FundingAddress:         fundingAddr,
+			LocalShutdownScript:    channel.LocalShutdownScript,
+			RemoteShutdownScript:   channel.RemoteShutdownScript,
+			BroadcastedCommitment:  forceCloseTx,
+			BroadcastedCooperative: coopCloseTx,
 		}
 	}
 	return dumpChannels, nil
 }
 
+// fundingAddress returns the address of the 2-of-2 multisig funding output of
+// the given channel.
+func fundingAddress(channel *channeldb.OpenChannel,
+	params *chaincfg.Params) (string, error) {
+
+	localKey := channel.LocalChanCfg.MultiSigKey.PubKey
+	remoteKey := channel.RemoteChanCfg.MultiSigKey.PubKey
+	if localKey == nil || remoteKey == nil {
+		return "", nil
+	}
+
+	witnessScript, err := input.GenMultiSigScript(
+		localKey.SerializeCompressed(), remoteKey.SerializeCompressed(),
+	)
+	if err != nil {
+		return "", err
+	}
+	scriptHash := sha256.Sum256(witnessScript)
+	addr, err := btcutil.NewAddressWitnessScriptHash(
+		scriptHash[:], params,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return addr.EncodeAddress(), nil
+}
+
+// closingTx returns the hex encoded closing transaction returned by the given
+// fetch function or an empty string if no closing transaction is stored.
+func closingTx(fetch func() (*wire.MsgTx, error)) (string, error) {
+	tx, err := fetch()
+	switch {
+	case errors.Is(err, channeldb.ErrNoCloseTx):
+		return "", nil
+
+	case err != nil:
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// HistoricalChannelLookup is a function that looks up the final state of a
+// closed channel in the historical channel bucket.
+type HistoricalChannelLookup func(*wire.OutPoint) (*channeldb.OpenChannel,
+	error)
+
 // ClosedChannelDump converts the closed channels in the given channel DB into a
-// dumpable format.
+// dumpable format. If a lookup function is given, the final state of each
+// channel is added from the historical channel bucket, if it exists there.
 func ClosedChannelDump(channels []*channeldb.ChannelCloseSummary,
-	params *chaincfg.Params) ([]ClosedChannel, error) {
+	params *chaincfg.Params,
+	lookupHistorical HistoricalChannelLookup) ([]ClosedChannel, error) {
 
 	dumpChannels := make([]ClosedChannel, len(channels))
 	for idx, channel := range channels {
@@ -194,7 +342,8 @@ func ClosedChannelDump(channels []*channeldb.ChannelCloseSummary,
 			CloseType: fmt.Sprintf(
 				"%d", channel.CloseType,
 			),
-			IsPending: channel.IsPending,
+			CloseTypeName: closeTypeName(channel.CloseType),
+			IsPending:     channel.IsPending,
 			RemoteCurrentRevocation: PubKeyToString(
 				channel.RemoteCurrentRevocation,
 			),
@@ -205,10 +354,62 @@ func ClosedChannelDump(channels []*channeldb.ChannelCloseSummary,
 				params, channel.LocalChanConfig,
 			),
 		}
+
+		if lookupHistorical == nil {
+			continue
+		}
+
+		// Channels that were closed with an old version of lnd aren't
+		// in the historical bucket.
+		historical, err := lookupHistorical(&channel.ChanPoint)
+		switch {
+		case errors.Is(err, channeldb.ErrNoHistoricalBucket),
+			errors.Is(err, channeldb.ErrChannelNotFound):
+
+			continue
+
+		case err != nil:
+			return nil, fmt.Errorf("error fetching historical "+
+				"channel %v: %w", channel.ChanPoint, err)
+		}
+
+		historicalDump, err := OpenChannelDump(
+			[]*channeldb.OpenChannel{historical}, params,
+		)
+		if err != nil {
+			return nil, err
+		}
+		dumpChannels[idx].HistoricalChannel = &historicalDump[0]
 	}
 	return dumpChannels, nil
 }
 
+// closeTypeName returns a human readable name of the given closure type.
+func closeTypeName(closeType channeldb.ClosureType) string {
+	switch closeType {
+	case channeldb.CooperativeClose:
+		return "cooperative"
+
+	case channeldb.LocalForceClose:
+		return "local_force_close"
+
+	case channeldb.RemoteForceClose:
+		return "remote_force_close"
+
+	case channeldb.BreachClose:
+		return "breach"
+
+	case channeldb.FundingCanceled:
+		return "funding_canceled"
+
+	case channeldb.Abandoned:
+		return "abandoned"
+
+	default:
+		return "unknown"
+	}
+}
+
 // BackupDump converts the given multi backup into a dumpable format.
 func BackupDump(multi *chanbackup.Multi,
 	params *chaincfg.Params) []BackupSingle {
@@ -224,7 +425,7 @@ func BackupDump(multi *chanbackup.Multi,
 			RemoteNodePub: PubKeyToString(
 				single.RemoteNodePub,
 			),
-			Addresses: single.Addresses,
+			Addresses: addressesToStrings(single.Addresses),
 			Capacity:  single.Capacity,
 			LocalChanCfg: ToChannelConfig(
 				params, single.LocalChanCfg,
@@ -235,11 +436,53 @@ func BackupDump(multi *chanbackup.Multi,
 			ShaChainRootDesc: ToKeyDescriptor(
 				params, single.ShaChainRootDesc,
 			),
+			LeaseExpiry: single.LeaseExpiry,
 		}
 	}
 	return dumpSingles
 }
 
+// ToChannelCommitment converts the given channel commitment into a dumpable
+// format.
+func ToChannelCommitment(
+	commit channeldb.ChannelCommitment) (ChannelCommitment, error) {
+
+	var buf bytes.Buffer
+	if commit.CommitTx != nil {
+		if err := commit.CommitTx.Serialize(&buf); err != nil {
+			return ChannelCommitment{}, err
+		}
+	}
+
+	htlcs := make([]HTLC, len(commit.Htlcs))
+	for idx, htlc := range commit.Htlcs {
+		htlcs[idx] = HTLC{
+			RHash:         hex.EncodeToString(htlc.RHash[:]),
+			Amt:           htlc.Amt,
+			RefundTimeout: htlc.RefundTimeout,
+			OutputIndex:   htlc.OutputIndex,
+			Incoming:      htlc.Incoming,
+			HtlcIndex:     htlc.HtlcIndex,
+			LogIndex:      htlc.LogIndex,
+		}
+	}
+
+	return ChannelCommitment{
+		CommitHeight:    commit.CommitHeight,
+		LocalLogIndex:   commit.LocalLogIndex,
+		LocalHtlcIndex:  commit.LocalHtlcIndex,
+		RemoteLogIndex:  commit.RemoteLogIndex,
+		RemoteHtlcIndex: commit.RemoteHtlcIndex,
+		LocalBalance:    commit.LocalBalance,
+		RemoteBalance:   commit.RemoteBalance,
+		CommitFee:       commit.CommitFee,
+		FeePerKw:        commit.FeePerKw,
+		CommitTx:        hex.EncodeToString(buf.Bytes()),
+		CommitSig:       hex.EncodeToString(commit.CommitSig),
+		Htlcs:           htlcs,
+	}, nil
+}
+
 func ToChannelConfig(params *chaincfg.Params,
 	cfg channeldb.ChannelConfig) ChannelConfig {
 
@@ -277,3 +520,139 @@ func PubKeyToString(pubkey *btcec.PublicKey) string {
 	}
 	return hex.EncodeToString(pubkey.SerializeCompressed())
 }
+
+// ChannelRevocationLog is the revocation log of a channel, containing one
+// entry for each revoked remote commitment.
+type ChannelRevocationLog struct {
+	FundingOutpoint string               `json:"funding_outpoint"`
+	RemotePub       string               `json:"remote_pub"`
+	Entries         []RevocationLogEntry `json:"entries"`
+}
+
+// RevocationLogEntry is the information we want to dump from a revoked remote
+// commitment. See `channeldb.RevocationLog` for information about the fields.
+// Output indexes are -1 if the output doesn't exist or is unknown, balances
+// are nil if they're unknown.
+type RevocationLogEntry struct {
+	CommitHeight     uint64               `json:"commit_height"`
+	CommitTxHash     string               `json:"commit_tx_hash"`
+	OurOutputIndex   int                  `json:"our_output_index"`
+	TheirOutputIndex int                  `json:"their_output_index"`
+	OurBalance       *lnwire.MilliSatoshi `json:"our_balance"`
+	TheirBalance     *lnwire.MilliSatoshi `json:"their_balance"`
+	RevocationSecret string               `json:"revocation_secret"`
+	CommitPoint      string               `json:"commit_point"`
+	HTLCs            []RevokedHTLC        `json:"htlcs"`
+}
+
+// RevokedHTLC is the information we want to dump from an HTLC of a revoked
+// remote commitment. See `channeldb.HTLCEntry` for information about the
+// fields.
+type RevokedHTLC struct {
+	RHash         string         `json:"rhash"`
+	RefundTimeout uint32         `json:"refund_timeout"`
+	OutputIndex   int            `json:"output_index"`
+	Incoming      bool           `json:"incoming"`
+	Amt           btcutil.Amount `json:"amt"`
+}
+
+// RevocationLogDump converts the revocation log of the given channel into a
+// dumpable format. Logs in the legacy format are supported as well.
+func RevocationLogDump(
+	channel *channeldb.OpenChannel) (*ChannelRevocationLog, error) {
+
+	result := &ChannelRevocationLog{
+		FundingOutpoint: channel.FundingOutpoint.String(),
+		RemotePub:       PubKeyToString(channel.IdentityPub),
+		Entries:         []RevocationLogEntry{},
+	}
+
+	// All remote commitments below the current one are revoked.
+	numRevoked := channel.RemoteCommitment.CommitHeight
+	for height := uint64(0); height < numRevoked; height++ {
+		revLog, legacyCommit, err := channel.FindPreviousState(height)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching revocation log "+
+				"at height %d: %w", height, err)
+		}
+
+		entry := RevocationLogEntry{
+			CommitHeight:     height,
+			OurOutputIndex:   -1,
+			TheirOutputIndex: -1,
+		}
+		if channel.RevocationStore != nil {
+			secret, err := channel.RevocationStore.LookUp(height)
+			if err == nil {
+				entry.RevocationSecret = secret.String()
+				entry.CommitPoint = PubKeyToString(
+					input.ComputeCommitmentPoint(secret[:]),
+				)
+			}
+		}
+
+		switch {
+		case revLog != nil:
+			entry.CommitTxHash = chainhash.Hash(
+				revLog.CommitTxHash,
+			).String()
+			entry.OurOutputIndex = outputIndex(
+				revLog.OurOutputIndex,
+			)
+			entry.TheirOutputIndex = outputIndex(
+				revLog.TheirOutputIndex,
+			)
+			entry.OurBalance = revLog.OurBalance
+			entry.TheirBalance = revLog.TheirBalance
+
+			for _, htlc := range revLog.HTLCEntries {
+				entry.HTLCs = append(entry.HTLCs, RevokedHTLC{
+					RHash: hex.EncodeToString(
+						htlc.RHash[:],
+					),
+					RefundTimeout: htlc.RefundTimeout,
+					OutputIndex: outputIndex(
+						htlc.OutputIndex,
+					),
+					Incoming: htlc.Incoming,
+					Amt:      htlc.Amt,
+				})
+			}
+
+		case legacyCommit != nil:
+			if legacyCommit.CommitTx != nil {
+				entry.CommitTxHash =
+					legacyCommit.CommitTx.TxHash().String()
+			}
+			ourBalance := legacyCommit.LocalBalance
+			theirBalance := legacyCommit.RemoteBalance
+			entry.OurBalance = &ourBalance
+			entry.TheirBalance = &theirBalance
+
+			for _, htlc := range legacyCommit.Htlcs {
+				entry.HTLCs = append(entry.HTLCs, RevokedHTLC{
+					RHash: hex.EncodeToString(
+						htlc.RHash[:],
+					),
+					RefundTimeout: htlc.RefundTimeout,
+					OutputIndex:   int(htlc.OutputIndex),
+					Incoming:      htlc.Incoming,
+					Amt:           htlc.Amt.ToSatoshis(),
+				})
+			}
+		}
+
+		result.Entries = append(result.Entries, entry)
+	}
+
+	return result, nil
+}
+
+// outputIndex converts an output index of the revocation log, returning -1 for
+// outputs that don't exist.
+func outputIndex(index uint16) int {
+	if index == channeldb.OutputIndexEmpty {
+		return -1
+	}
+	return int(index)
+}
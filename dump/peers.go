@@ -0,0 +1,106 @@
+package dump
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/routing/route"
+)
+
+// Peer is the information we know about a channel peer from lnd's channel DB.
+// The addresses are the ones lnd last connected to (from the link node), the
+// graph addresses are the ones the peer announced to the network.
+type Peer struct {
+	RemotePub      string     `json:"remote_pub"`
+	Alias          string     `json:"alias,omitempty"`
+	LastSeen       *time.Time `json:"last_seen,omitempty"`
+	Addresses      []string   `json:"addresses"`
+	GraphAddresses []string   `json:"graph_addresses"`
+	OpenChannels   []string   `json:"open_channels"`
+	ClosedChannels []string   `json:"closed_channels"`
+}
+
+// NodeLookup is a function that looks up a node in the channel graph.
+type NodeLookup func(route.Vertex) (*channeldb.LightningNode, error)
+
+// PeerDump collects all peers we know of from the link nodes and the open and
+// closed channels and enriches them with the information from the channel
+// graph. The peers are sorted by their public key.
+func PeerDump(linkNodes []*channeldb.LinkNode,
+	openChannels []*channeldb.OpenChannel,
+	closedChannels []*channeldb.ChannelCloseSummary,
+	lookupNode NodeLookup) ([]*Peer, error) {
+
+	peers := make(map[string]*Peer)
+	getPeer := func(pubKey *btcec.PublicKey) *Peer {
+		pubKeyStr := PubKeyToString(pubKey)
+		peer, ok := peers[pubKeyStr]
+		if !ok {
+			peer = &Peer{
+				RemotePub:      pubKeyStr,
+				Addresses:      []string{},
+				GraphAddresses: []string{},
+				OpenChannels:   []string{},
+				ClosedChannels: []string{},
+			}
+			peers[pubKeyStr] = peer
+		}
+
+		return peer
+	}
+
+	for _, linkNode := range linkNodes {
+		peer := getPeer(linkNode.IdentityPub)
+		peer.Addresses = addressesToStrings(linkNode.Addresses)
+		if !linkNode.LastSeen.IsZero() {
+			lastSeen := linkNode.LastSeen
+			peer.LastSeen = &lastSeen
+		}
+	}
+	for _, channel := range openChannels {
+		peer := getPeer(channel.IdentityPub)
+		peer.OpenChannels = append(
+			peer.OpenChannels, channel.FundingOutpoint.String(),
+		)
+	}
+	for _, channel := range closedChannels {
+		peer := getPeer(channel.RemotePub)
+		peer.ClosedChannels = append(
+			peer.ClosedChannels, channel.ChanPoint.String(),
+		)
+	}
+
+	result := make([]*Peer, 0, len(peers))
+	for pubKeyStr, peer := range peers {
+		vertex, err := route.NewVertexFromStr(pubKeyStr)
+		if err != nil {
+			return nil, err
+		}
+
+		// The graph might have been dropped or the peer never
+		// announced itself, so it's okay to not find it.
+		node, err := lookupNode(vertex)
+		switch {
+		case errors.Is(err, channeldb.ErrGraphNodeNotFound),
+			errors.Is(err, channeldb.ErrGraphNotFound):
+
+		case err != nil:
+			return nil, err
+
+		default:
+			peer.Alias = node.Alias
+			peer.GraphAddresses = addressesToStrings(node.Addresses)
+		}
+
+		result = append(result, peer)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RemotePub < result[j].RemotePub
+	})
+
+	return result, nil
+}
@@ -25,23 +25,72 @@ var (
 	multipleSpaces  = regexp.MustCompile(" [ ]+")
 )
 
+// ReadAezeed reads an aezeed cipher seed and its passphrase from the
+// environment or the terminal and derives the root key from it.
 func ReadAezeed(params *chaincfg.Params) (*hdkeychain.ExtendedKey, time.Time,
 	error) {
 
+	return ReadAezeedWithSeed("", "", params)
+}
+
+// ReadAezeedWithSeed derives the root key from the given aezeed mnemonic and
+// passphrase. An empty mnemonic or passphrase is read from the environment or
+// the terminal instead, a passphrase of "-" means the seed doesn't have a
+// passphrase.
+func ReadAezeedWithSeed(mnemonicStr, passphrase string,
+	params *chaincfg.Params) (*hdkeychain.ExtendedKey, time.Time, error) {
+
+	mnemonic, err := readAezeedMnemonic(mnemonicStr)
+	if err != nil {
+		return nil, time.Unix(0, 0), err
+	}
+
+	passphraseBytes, err := readAezeedPassphrase(passphrase)
+	if err != nil {
+		return nil, time.Unix(0, 0), err
+	}
+
+	// If we're unable to map it back into the ciphertext, then either the
+	// mnemonic is wrong, or the passphrase is wrong.
+	cipherSeed, err := mnemonic.ToCipherSeed(passphraseBytes)
+	if err != nil {
+		return nil, time.Unix(0, 0), fmt.Errorf("failed to decrypt "+
+			"seed with passphrase: %w", err)
+	}
+	rootKey, err := hdkeychain.NewMaster(cipherSeed.Entropy[:], params)
+	if err != nil {
+		return nil, time.Unix(0, 0), fmt.Errorf("failed to derive " +
+			"master extended key")
+	}
+	return rootKey, cipherSeed.BirthdayTime(), nil
+}
+
+// ReadAezeedMnemonic reads the 24 words of an aezeed cipher seed from the
+// environment or, if not set there, from the terminal.
+func ReadAezeedMnemonic() (*aezeed.Mnemonic, error) {
+	return readAezeedMnemonic("")
+}
+
+// readAezeedMnemonic parses the given mnemonic or, if empty, reads it from the
+// environment or the terminal.
+func readAezeedMnemonic(mnemonicStr string) (*aezeed.Mnemonic, error) {
 	// To automate things with chantools, we also offer reading the seed
 	// from environment variables.
-	mnemonicStr := strings.TrimSpace(os.Getenv(MnemonicEnvName))
+	if mnemonicStr == "" {
+		mnemonicStr = strings.TrimSpace(os.Getenv(MnemonicEnvName))
+	}
 
 	// If nothing is set in the environment, read the seed from the
 	// terminal.
 	if mnemonicStr == "" {
 		var err error
 		// We'll now prompt the user to enter in their 24-word mnemonic.
-		fmt.Printf("Input your 24-word mnemonic separated by spaces: ")
+		fmt.Fprint(os.Stderr, "Input your 24-word mnemonic separated "+
+			"by spaces: ")
 		reader := bufio.NewReader(os.Stdin)
 		mnemonicStr, err = reader.ReadString('\n')
 		if err != nil {
-			return nil, time.Unix(0, 0), err
+			return nil, err
 		}
 	}
 
@@ -59,18 +108,36 @@ func ReadAezeed(params *chaincfg.Params) (*hdkeychain.ExtendedKey, time.Time,
 
 	cipherSeedMnemonic := strings.Split(mnemonicStr, " ")
 
-	fmt.Println()
+	fmt.Fprintln(os.Stderr)
 
 	if len(cipherSeedMnemonic) != 24 {
-		return nil, time.Unix(0, 0), fmt.Errorf("wrong cipher seed "+
-			"mnemonic length: got %v words, expecting %v words",
+		return nil, fmt.Errorf("wrong cipher seed mnemonic length: "+
+			"got %v words, expecting %v words",
 			len(cipherSeedMnemonic), 24)
 	}
 
-	// Additionally, the user may have a passphrase, that will also need to
-	// be provided so the daemon can properly decipher the cipher seed.
-	// Try the environment variable first.
-	passphrase := strings.TrimSpace(os.Getenv(PassphraseEnvName))
+	var mnemonic aezeed.Mnemonic
+	copy(mnemonic[:], cipherSeedMnemonic)
+
+	return &mnemonic, nil
+}
+
+// ReadAezeedPassphrase reads the passphrase of an aezeed cipher seed from the
+// environment or, if not set there, from the terminal. An empty passphrase
+// means the seed doesn't have a passphrase.
+func ReadAezeedPassphrase() ([]byte, error) {
+	return readAezeedPassphrase("")
+}
+
+// readAezeedPassphrase returns the given passphrase or, if empty, reads it
+// from the environment or the terminal.
+func readAezeedPassphrase(passphrase string) ([]byte, error) {
+	// The user may have a passphrase, that will also need to be provided
+	// so we can properly decipher the cipher seed. Try the environment
+	// variable first.
+	if passphrase == "" {
+		passphrase = strings.TrimSpace(os.Getenv(PassphraseEnvName))
+	}
 
 	// Because we cannot differentiate between an empty and a non-existent
 	// environment variable, we need a special character that indicates that
@@ -85,36 +152,22 @@ func ReadAezeed(params *chaincfg.Params) (*hdkeychain.ExtendedKey, time.Time,
 	// The environment variable didn't contain anything, we'll read the
 	// passphrase from the terminal.
 	case passphrase == "":
-		fmt.Printf("Input your cipher seed passphrase (press enter " +
-			"if your seed doesn't have a passphrase): ")
+		fmt.Fprint(os.Stderr, "Input your cipher seed passphrase "+
+			"(press enter if your seed doesn't have a "+
+			"passphrase): ")
 		var err error
 		passphraseBytes, err = terminal.ReadPassword(
 			int(syscall.Stdin), //nolint
 		)
 		if err != nil {
-			return nil, time.Unix(0, 0), err
+			return nil, err
 		}
-		fmt.Println()
+		fmt.Fprintln(os.Stderr)
 
 	// There was a password in the environment, just convert it to bytes.
 	default:
 		passphraseBytes = []byte(passphrase)
 	}
 
-	var mnemonic aezeed.Mnemonic
-	copy(mnemonic[:], cipherSeedMnemonic)
-
-	// If we're unable to map it back into the ciphertext, then either the
-	// mnemonic is wrong, or the passphrase is wrong.
-	cipherSeed, err := mnemonic.ToCipherSeed(passphraseBytes)
-	if err != nil {
-		return nil, time.Unix(0, 0), fmt.Errorf("failed to decrypt "+
-			"seed with passphrase: %w", err)
-	}
-	rootKey, err := hdkeychain.NewMaster(cipherSeed.Entropy[:], params)
-	if err != nil {
-		return nil, time.Unix(0, 0), fmt.Errorf("failed to derive " +
-			"master extended key")
-	}
-	return rootKey, cipherSeed.BirthdayTime(), nil
+	return passphraseBytes, nil
 }
@@ -0,0 +1,106 @@
+package lnd
+
+import (
+	"github.com/btcsuite/btcwallet/walletdb"
+	"github.com/lightningnetwork/lnd/kvdb"
+)
+
+// BatchBackend is a kvdb.Backend that runs all transactions in one single
+// read/write transaction of the wrapped backend. This allows us to use the
+// high level channeldb functions for multiple changes and then either commit
+// all of them at once or none at all.
+type BatchBackend struct {
+	kvdb.Backend
+
+	tx walletdb.ReadWriteTx
+}
+
+// Enforce BatchBackend implements the kvdb.Backend interface.
+var _ kvdb.Backend = (*BatchBackend)(nil)
+
+// NewBatchBackend starts a new read/write transaction in the given backend that
+// is used for all transactions until Commit or Rollback is called.
+func NewBatchBackend(backend kvdb.Backend) (*BatchBackend, error) {
+	tx, err := backend.BeginReadWriteTx()
+	if err != nil {
+		return nil, err
+	}
+
+	return &BatchBackend{
+		Backend: backend,
+		tx:      tx,
+	}, nil
+}
+
+// BeginReadTx returns the batch transaction.
+//
+// This function is part of the walletdb.DB interface implementation.
+func (b *BatchBackend) BeginReadTx() (walletdb.ReadTx, error) {
+	return &batchTx{b.tx}, nil
+}
+
+// BeginReadWriteTx returns the batch transaction.
+//
+// This function is part of the walletdb.DB interface implementation.
+func (b *BatchBackend) BeginReadWriteTx() (walletdb.ReadWriteTx, error) {
+	return &batchTx{b.tx}, nil
+}
+
+// View executes the function f with the batch transaction.
+//
+// This function is part of the walletdb.DB interface implementation.
+func (b *BatchBackend) View(f func(tx walletdb.ReadTx) error,
+	reset func()) error {
+
+	reset()
+	return f(&batchTx{b.tx})
+}
+
+// Update executes the function f with the batch transaction. If f returns an
+// error, its changes are not undone until the whole batch is rolled back.
+//
+// This function is part of the walletdb.DB interface implementation.
+func (b *BatchBackend) Update(f func(tx walletdb.ReadWriteTx) error,
+	reset func()) error {
+
+	reset()
+	return f(&batchTx{b.tx})
+}
+
+// Close does nothing, the wrapped backend must be closed separately after the
+// batch was committed or rolled back.
+//
+// This function is part of the walletdb.DB interface implementation.
+func (b *BatchBackend) Close() error {
+	return nil
+}
+
+// Commit commits all changes of the batch to the wrapped backend.
+func (b *BatchBackend) Commit() error {
+	return b.tx.Commit()
+}
+
+// Rollback discards all changes of the batch.
+func (b *BatchBackend) Rollback() error {
+	return b.tx.Rollback()
+}
+
+// batchTx is a transaction of a batch that is only committed or rolled back
+// with the batch itself.
+type batchTx struct {
+	walletdb.ReadWriteTx
+}
+
+// Commit does nothing, the changes are committed with the batch.
+//
+// This function is part of the walletdb.ReadWriteTx interface implementation.
+func (tx *batchTx) Commit() error {
+	return nil
+}
+
+// Rollback does nothing, the changes are rolled back with the batch.
+//
+// This function is part of the walletdb.ReadTx interface implementation.
+func (tx *batchTx) Rollback() error {
+	return nil
+}
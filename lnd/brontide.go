@@ -179,7 +179,7 @@ func ConnectPeer(conn *brontide.Conn, connReq *connmgr.ConnReq,
 		AuthGossiper:            gossiper,
 		ChainNotifier:           &mock.ChainNotifier{},
 		DisconnectPeer: func(key *btcec.PublicKey) error {
-			fmt.Printf("Peer %x disconnected\n",
+			fmt.Fprintf(os.Stderr, "Peer %x disconnected\n",
 				key.SerializeCompressed())
 			return nil
 		},
@@ -213,8 +213,8 @@ func ConnectPeer(conn *brontide.Conn, connReq *connmgr.ConnReq,
 		HandleCustomMessage: func(peer [33]byte,
 			msg *lnwire.Custom) error {
 
-			fmt.Printf("Received custom message from %x: %v\n",
-				peer[:], msg)
+			fmt.Fprintf(os.Stderr, "Received custom message "+
+				"from %x: %v\n", peer[:], msg)
 			return nil
 		},
 		GetAliases: func(
@@ -10,7 +10,9 @@ import (
 )
 
 // CreateChannelBackup creates a channel backup file from all channels found in
-// the given DB file, encrypted with the key in the key ring.
+// the given DB file, encrypted with the key in the key ring. The key ring must
+// belong to the node the channels were created with, otherwise lnd can neither
+// decrypt the backup nor restore the channels from it.
 func CreateChannelBackup(db *channeldb.DB, multiFile *chanbackup.MultiFile,
 	ring keychain.KeyRing) error {
 
@@ -20,6 +22,22 @@ func CreateChannelBackup(db *channeldb.DB, multiFile *chanbackup.MultiFile,
 	if err != nil {
 		return fmt.Errorf("error extracting channel backup: %w", err)
 	}
+
+	for _, single := range singles {
+		multiSigKey := single.LocalChanCfg.MultiSigKey
+		keyDesc, err := ring.DeriveKey(multiSigKey.KeyLocator)
+		if err != nil {
+			return fmt.Errorf("error deriving multisig key of "+
+				"channel %v: %w", single.FundingOutpoint, err)
+		}
+		if !keyDesc.PubKey.IsEqual(multiSigKey.PubKey) {
+			return fmt.Errorf("multisig key of channel %v cannot "+
+				"be derived from the root key, make sure the "+
+				"root key belongs to the node of the channel "+
+				"DB", single.FundingOutpoint)
+		}
+	}
+
 	multi := &chanbackup.Multi{
 		Version:       chanbackup.DefaultMultiVersion,
 		StaticBackups: singles,
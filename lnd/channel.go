@@ -65,6 +65,19 @@ func (lc *LightningChannel) SignedCommitTx() (*wire.MsgTx, error) {
 		return nil, err
 	}
 
+	// Our signature is only valid if the seed is the one the channel was
+	// opened with.
+	multiSigKey := lc.LocalChanCfg.MultiSigKey
+	ourKeyDesc, err := lc.TXSigner.DeriveKey(multiSigKey.KeyLocator)
+	if err != nil {
+		return nil, fmt.Errorf("could not derive funding key: %w", err)
+	}
+	if !ourKeyDesc.PubKey.IsEqual(multiSigKey.PubKey) {
+		return nil, fmt.Errorf("funding key of channel %v doesn't "+
+			"match the key derived from the seed, wrong seed?",
+			lc.ChannelState.FundingOutpoint)
+	}
+
 	// With this, we then generate the full witness so the caller can
 	// broadcast a fully signed transaction.
 	lc.SignDesc.SigHashes = input.NewTxSigHashesV0Only(commitTx)
@@ -83,6 +96,21 @@ func (lc *LightningChannel) SignedCommitTx() (*wire.MsgTx, error) {
 		ourSig, theirKey, theirSig,
 	)
 
+	// Make sure the remote signature stored in the DB together with ours
+	// really spends the funding output before anyone publishes the TX.
+	vm, err := txscript.NewEngine(
+		lc.SignDesc.Output.PkScript, commitTx, 0,
+		txscript.StandardVerifyFlags, nil, lc.SignDesc.SigHashes,
+		lc.SignDesc.Output.Value, lc.SignDesc.PrevOutputFetcher,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating script engine: %w", err)
+	}
+	if err := vm.Execute(); err != nil {
+		return nil, fmt.Errorf("invalid witness for commitment TX of "+
+			"channel %v: %w", lc.ChannelState.FundingOutpoint, err)
+	}
+
 	return commitTx, nil
 }
 
@@ -1,22 +1,156 @@
 package lnd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/btcsuite/btcwallet/walletdb"
 	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/lightningnetwork/lnd/kvdb/postgres"
+	"github.com/lightningnetwork/lnd/kvdb/sqlbase"
+	"github.com/lightningnetwork/lnd/kvdb/sqlite"
+	"github.com/lightningnetwork/lnd/lncfg"
 	"go.etcd.io/bbolt"
 )
 
 const (
 	DefaultOpenTimeout = time.Second * 10
+
+	// DBBackendBolt is the name of the default, file based bbolt database
+	// backend.
+	DBBackendBolt = "bolt"
+
+	// DBBackendSqlite is the name of the SQLite database backend. It is
+	// only available if chantools was built with the kvdb_sqlite build
+	// tag.
+	DBBackendSqlite = kvdb.SqliteBackendName
+
+	// DBBackendPostgres is the name of the Postgres database backend. It is
+	// only available if chantools was built with the kvdb_postgres build
+	// tag.
+	DBBackendPostgres = kvdb.PostgresBackendName
 )
 
+// sqlInitOnce makes sure the global connection pool of the SQL backends is
+// only initialized once, no matter how many databases are opened.
+var sqlInitOnce sync.Once
+
+// DBConfig describes where and how to open an lnd channel DB.
+type DBConfig struct {
+	// Backend is the name of the database backend to use.
+	Backend string
+
+	// Path is the path to the channel.db (bolt) or channel.sqlite (SQLite)
+	// file. It is not used for the Postgres backend.
+	Path string
+
+	// PostgresDSN is the connection string of the Postgres database.
+	PostgresDSN string
+
+	// Timeout is the timeout for opening the database or connecting to
+	// it.
+	Timeout time.Duration
+}
+
+// OpenChannelDB opens the channel DB described by the given config. The SQL
+// backends don't support opening the database in read only mode, so the
+// readonly flag only prevents any migrations from being applied for those.
+func OpenChannelDB(cfg *DBConfig, readonly bool) (*channeldb.DB, error) {
+	if cfg.Backend == "" || cfg.Backend == DBBackendBolt {
+		return OpenDB(cfg.Path, readonly)
+	}
+
+	backend, err := OpenBackend(cfg, readonly, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return channeldb.CreateWithBackend(
+		backend, channeldb.OptionSetUseGraphCache(false),
+		channeldb.OptionNoMigration(readonly),
+	)
+}
+
+// OpenBackend opens the raw key/value store of the channel DB described by the
+// given config. If create is true, a new, empty database is created if it
+// doesn't exist yet.
+func OpenBackend(cfg *DBConfig, readonly, create bool) (kvdb.Backend, error) {
+	var (
+		ctx     = context.Background()
+		backend kvdb.Backend
+		err     error
+	)
+	// The SQL backends share a global connection pool that needs to be
+	// initialized first. Zero means no limit on the number of connections.
+	sqlInitOnce.Do(func() {
+		sqlbase.Init(0)
+	})
+
+	switch cfg.Backend {
+	case "", DBBackendBolt:
+		if create && !fileExists(cfg.Path) {
+			return createDB(cfg.Path, DefaultOpenTimeout)
+		}
+
+		backend, err = openDB(
+			cfg.Path, false, readonly, DefaultOpenTimeout,
+		)
+		if errors.Is(err, bbolt.ErrTimeout) {
+			return nil, fmt.Errorf("error opening %s: make sure "+
+				"lnd is not running, database is locked by "+
+				"another process", cfg.Path)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return backend, nil
+
+	case DBBackendSqlite:
+		// The SQLite driver would create a new, empty database.
+		if !create && !fileExists(cfg.Path) {
+			return nil, walletdb.ErrDbDoesNotExist
+		}
+
+		backend, err = kvdb.Open(
+			kvdb.SqliteBackendName, ctx, &sqlite.Config{
+				Timeout:     cfg.Timeout,
+				BusyTimeout: cfg.Timeout,
+			}, filepath.Dir(cfg.Path), filepath.Base(cfg.Path),
+			lncfg.NSChannelDB,
+		)
+
+	case DBBackendPostgres:
+		backend, err = kvdb.Open(
+			kvdb.PostgresBackendName, ctx, &postgres.Config{
+				Dsn:     cfg.PostgresDSN,
+				Timeout: cfg.Timeout,
+			}, lncfg.NSChannelDB,
+		)
+
+	default:
+		return nil, fmt.Errorf("unknown DB backend '%s'", cfg.Backend)
+	}
+	if errors.Is(err, walletdb.ErrDbUnknownType) {
+		return nil, fmt.Errorf("DB backend '%s' not available, "+
+			"chantools must be built with the kvdb_%s build tag",
+			cfg.Backend, cfg.Backend)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s DB: %w", cfg.Backend,
+			err)
+	}
+
+	return backend, nil
+}
+
 func OpenDB(dbPath string, readonly bool) (*channeldb.DB, error) {
 	backend, err := openDB(dbPath, false, readonly, DefaultOpenTimeout)
 	if errors.Is(err, bbolt.ErrTimeout) {
@@ -498,3 +632,18 @@ func openDB(dbPath string, noFreelistSync bool,
 	boltDB, err := bbolt.Open(dbPath, 0600, options)
 	return &backend{db: boltDB}, convertErr(err)
 }
+
+// createDB creates a new, empty database at the provided path.
+func createDB(dbPath string, timeout time.Duration) (kvdb.Backend, error) {
+	options := &bbolt.Options{
+		FreelistType: bbolt.FreelistMapType,
+		Timeout:      timeout,
+	}
+
+	boltDB, err := bbolt.Open(dbPath, 0600, options)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+
+	return &backend{db: boltDB}, nil
+}
@@ -1,6 +1,7 @@
 package lnd
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"strconv"
@@ -21,11 +22,154 @@ import (
 const (
 	HardenedKeyStart            = uint32(hdkeychain.HardenedKeyStart)
 	WalletDefaultDerivationPath = "m/84'/0'/0'"
+	WalletBIP44DerivationPath   = "m/44'/0'/0'"
 	WalletBIP49DerivationPath   = "m/49'/0'/0'"
 	WalletBIP86DerivationPath   = "m/86'/0'/0'"
 	LndDerivationPath           = "m/1017'/%d'/%d'"
+
+	// AccountKeyDepth is the depth of an lnd account key
+	// (m/1017'/<coin_type>'/<key_family>') in the key tree.
+	AccountKeyDepth = 3
 )
 
+// AccountKey is the extended key of an lnd key family account
+// (m/1017'/<coin_type>'/<key_family>'). Such a key can be used instead of the
+// root key to derive the keys of that single key family.
+type AccountKey struct {
+	*hdkeychain.ExtendedKey
+
+	// CoinType is the BIP44 coin type of the network the key is for.
+	CoinType uint32
+}
+
+// NewAccountKey makes sure the given extended key is at the depth of an lnd
+// key family account and looks up the coin type of its network.
+func NewAccountKey(key *hdkeychain.ExtendedKey) (*AccountKey, error) {
+	if key.Depth() != AccountKeyDepth ||
+		key.ChildIndex() < HardenedKeyStart {
+
+		return nil, fmt.Errorf("account key must be at the depth of " +
+			"an lnd account (m/1017'/<coin_type>'/<key_family>')")
+	}
+
+	coinType, err := keyCoinType(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccountKey{
+		ExtendedKey: key,
+		CoinType:    coinType,
+	}, nil
+}
+
+// ParseAccountKey parses an extended private key of an lnd key family
+// account.
+func ParseAccountKey(xprv string) (*AccountKey, error) {
+	accountKey, err := parseAccountKey(xprv)
+	if err != nil {
+		return nil, err
+	}
+
+	if !accountKey.IsPrivate() {
+		return nil, fmt.Errorf("account key must be an extended " +
+			"private key")
+	}
+
+	return accountKey, nil
+}
+
+// keyCoinType returns the BIP44 coin type of the network the given extended
+// key is encoded for.
+func keyCoinType(key *hdkeychain.ExtendedKey) (uint32, error) {
+	for _, params := range []*chaincfg.Params{
+		&chaincfg.MainNetParams, &chaincfg.TestNet3Params,
+		&chaincfg.RegressionNetParams, &chaincfg.SimNetParams,
+		&chaincfg.SigNetParams,
+	} {
+		if bytes.Equal(key.Version(), params.HDPrivateKeyID[:]) ||
+			bytes.Equal(key.Version(), params.HDPublicKeyID[:]) {
+
+			return params.HDCoinType, nil
+		}
+	}
+
+	return 0, fmt.Errorf("account key is for an unknown network")
+}
+
+// ParseAccountXPub parses an extended public key of an lnd key family account.
+// Such a key can only be used to derive the public keys of that key family.
+func ParseAccountXPub(xpub string) (*AccountKey, error) {
+	accountKey, err := parseAccountKey(xpub)
+	if err != nil {
+		return nil, err
+	}
+
+	if accountKey.IsPrivate() {
+		return nil, fmt.Errorf("account key must be an extended " +
+			"public key")
+	}
+
+	return accountKey, nil
+}
+
+// parseAccountKey parses an extended key and makes sure it is at the depth of
+// an lnd key family account.
+func parseAccountKey(key string) (*AccountKey, error) {
+	extendedKey, err := hdkeychain.NewKeyFromString(key)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing account key: %w", err)
+	}
+
+	return NewAccountKey(extendedKey)
+}
+
+// DeriveChildren derives the key at the given full lnd derivation path. Only
+// the keys of the account's key family can be derived, so only the part of the
+// path below the account is derived.
+func (a *AccountKey) DeriveChildren(path []uint32) (*hdkeychain.ExtendedKey,
+	error) {
+
+	family := a.ChildIndex() - HardenedKeyStart
+	if len(path) < AccountKeyDepth ||
+		path[0] != HardenedKey(uint32(keychain.BIP0043Purpose)) {
+
+		return nil, fmt.Errorf("path %v cannot be derived from an "+
+			"account key of key family %d", path, family)
+	}
+	if path[1] != HardenedKey(a.CoinType) {
+		return nil, fmt.Errorf("coin type %d is needed but the "+
+			"account key is of coin type %d",
+			path[1]-HardenedKeyStart, a.CoinType)
+	}
+	if path[2] != a.ChildIndex() {
+		return nil, fmt.Errorf("key family %d is needed but the "+
+			"account key is of key family %d",
+			path[2]-HardenedKeyStart, family)
+	}
+
+	return DeriveChildren(a.ExtendedKey, path[AccountKeyDepth:])
+}
+
+// DeriveFromRoot derives the key at the given full derivation path from the
+// root key. Instead of the root key, the key of an lnd key family account can
+// be given, in which case only the keys of that key family can be derived.
+func DeriveFromRoot(rootKey *hdkeychain.ExtendedKey, path []uint32) (
+	*hdkeychain.ExtendedKey, error) {
+
+	if rootKey.Depth() == 0 {
+		return DeriveChildren(rootKey, path)
+	}
+
+	accountKey, err := NewAccountKey(rootKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return accountKey.DeriveChildren(path)
+}
+
+// DeriveChildren derives the key at the given path below the given key.
 func DeriveChildren(key *hdkeychain.ExtendedKey, path []uint32) (
 	*hdkeychain.ExtendedKey, error) {
 
@@ -97,7 +241,7 @@ func HardenedKey(key uint32) uint32 {
 }
 
 // DeriveKey derives the public key and private key in the WIF format for a
-// given key path of the extended key.
+// given key path of the root key, which can also be an lnd account key.
 func DeriveKey(extendedKey *hdkeychain.ExtendedKey, path string,
 	params *chaincfg.Params) (*hdkeychain.ExtendedKey, *btcec.PublicKey,
 	*btcutil.WIF, error) {
@@ -107,7 +251,7 @@ func DeriveKey(extendedKey *hdkeychain.ExtendedKey, path string,
 		return nil, nil, nil, fmt.Errorf("could not parse derivation "+
 			"path: %w", err)
 	}
-	derivedKey, err := DeriveChildren(extendedKey, parsedPath)
+	derivedKey, err := DeriveFromRoot(extendedKey, parsedPath)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("could not derive children: "+
 			"%w", err)
@@ -192,6 +336,17 @@ func MultisigPath(params *chaincfg.Params, index int) string {
 	)
 }
 
+// KeyLocatorPath returns the derivation path of the key with the given key
+// family and index in lnd's internal keychain.
+func KeyLocatorPath(params *chaincfg.Params, family keychain.KeyFamily,
+	index uint32) string {
+
+	return fmt.Sprintf(
+		LndDerivationPath+"/0/%d", params.HDCoinType, uint32(family),
+		index,
+	)
+}
+
 func AllDerivationPaths(params *chaincfg.Params) ([]string, [][]uint32, error) {
 	mkPath := func(f keychain.KeyFamily) string {
 		return fmt.Sprintf(
@@ -199,6 +354,7 @@ func AllDerivationPaths(params *chaincfg.Params) ([]string, [][]uint32, error) {
 		)
 	}
 	pathStrings := []string{
+		WalletBIP44DerivationPath,
 		WalletBIP49DerivationPath,
 		WalletDefaultDerivationPath,
 		WalletBIP86DerivationPath,
@@ -387,6 +543,38 @@ func P2AnchorStaticRemote(pubKey *btcec.PublicKey,
 	return p2wsh, commitScript, err
 }
 
+// P2TaprootStaticRemote returns the address of the to_remote output of a
+// simple taproot channel, together with the script of its only leaf and the
+// control block to spend it.
+func P2TaprootStaticRemote(pubKey *btcec.PublicKey,
+	params *chaincfg.Params) (*btcutil.AddressTaproot, []byte, []byte,
+	error) {
+
+	script, err := TaprootToRemoteScript(pubKey)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("could not create script: %w",
+			err)
+	}
+
+	leaf := txscript.NewBaseTapLeaf(script)
+	rootHash := leaf.TapHash()
+	taprootKey := txscript.ComputeTaprootOutputKey(
+		TaprootNUMSKey, rootHash[:],
+	)
+	controlBlock, err := TaprootControlBlock(
+		TaprootNUMSKey, []txscript.TapLeaf{leaf}, leaf,
+	)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("could not create control "+
+			"block: %w", err)
+	}
+
+	p2tr, err := btcutil.NewAddressTaproot(
+		schnorr.SerializePubKey(taprootKey), params,
+	)
+	return p2tr, script, controlBlock, err
+}
+
 type HDKeyRing struct {
 	ExtendedKey *hdkeychain.ExtendedKey
 	ChainParams *chaincfg.Params
@@ -402,7 +590,7 @@ func (r *HDKeyRing) DeriveKey(keyLoc keychain.KeyLocator) (
 	keychain.KeyDescriptor, error) {
 
 	var empty = keychain.KeyDescriptor{}
-	derivedKey, err := DeriveChildren(r.ExtendedKey, []uint32{
+	derivedKey, err := DeriveFromRoot(r.ExtendedKey, []uint32{
 		HardenedKeyStart + uint32(keychain.BIP0043Purpose),
 		HardenedKeyStart + r.ChainParams.HDCoinType,
 		HardenedKeyStart + uint32(keyLoc.Family),
@@ -437,7 +625,7 @@ func (r *HDKeyRing) CheckDescriptor(
 	}
 
 	// Performance fix, derive static path only once.
-	familyKey, err := DeriveChildren(r.ExtendedKey, []uint32{
+	familyKey, err := DeriveFromRoot(r.ExtendedKey, []uint32{
 		HardenedKeyStart + uint32(keychain.BIP0043Purpose),
 		HardenedKeyStart + r.ChainParams.HDCoinType,
 		HardenedKeyStart + uint32(keyDesc.Family),
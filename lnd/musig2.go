@@ -0,0 +1,311 @@
+package lnd
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+)
+
+// muSig2State is the state of a MuSig2 signing session that is kept in memory
+// until the final signature was created or the session is cleaned up.
+type muSig2State struct {
+	input.MuSig2SessionInfo
+
+	context input.MuSig2Context
+	session input.MuSig2Session
+}
+
+// MuSig2GenNonces generates a fresh set of MuSig2 nonces for the key at the
+// given key locator. This is only needed if the secret nonce has to outlive a
+// single run (for example because the counterparty needs some time to sign),
+// in which case it must be stored safely and used exactly once with
+// MuSig2ResumeSession. Sessions created with MuSig2CreateSession generate their
+// own nonces.
+func (s *Signer) MuSig2GenNonces(keyLoc keychain.KeyLocator) (*musig2.Nonces,
+	error) {
+
+	privKey, err := s.FetchPrivKey(&keychain.KeyDescriptor{
+		KeyLocator: keyLoc,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error deriving private key: %w", err)
+	}
+
+	nonces, err := musig2.GenNonces(
+		musig2.WithPublicKey(privKey.PubKey()),
+		musig2.WithNonceSecretKeyAux(privKey),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error generating nonces: %w", err)
+	}
+
+	return nonces, nil
+}
+
+// MuSig2CreateSession creates a new MuSig2 signing session with fresh nonces
+// using the local key identified by the key locator. The list of all signer
+// public keys must include the local key. Nonces of other signers that are
+// already known can be registered right away.
+func (s *Signer) MuSig2CreateSession(bipVersion input.MuSig2Version,
+	keyLoc keychain.KeyLocator, allSignerPubKeys []*btcec.PublicKey,
+	tweaks *input.MuSig2Tweaks,
+	otherSignerNonces [][musig2.PubNonceSize]byte) (
+	*input.MuSig2SessionInfo, error) {
+
+	privKey, err := s.FetchPrivKey(&keychain.KeyDescriptor{
+		KeyLocator: keyLoc,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error deriving private key: %w", err)
+	}
+
+	muSigContext, muSigSession, err := input.MuSig2CreateContext(
+		bipVersion, privKey, allSignerPubKeys, tweaks,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating signing context: %w",
+			err)
+	}
+
+	return s.addMuSig2Session(
+		bipVersion, muSigContext, muSigSession, tweaks,
+		otherSignerNonces,
+	)
+}
+
+// MuSig2ResumeSession creates a MuSig2 signing session that uses the given,
+// previously generated local nonces instead of fresh ones. The nonces must have
+// been created by MuSig2GenNonces for the same key locator and must never be
+// used for more than one signature. Only the final version of the MuSig2 BIP is
+// supported.
+func (s *Signer) MuSig2ResumeSession(keyLoc keychain.KeyLocator,
+	allSignerPubKeys []*btcec.PublicKey, tweaks *input.MuSig2Tweaks,
+	localNonces *musig2.Nonces,
+	otherSignerNonces [][musig2.PubNonceSize]byte) (
+	*input.MuSig2SessionInfo, error) {
+
+	privKey, err := s.FetchPrivKey(&keychain.KeyDescriptor{
+		KeyLocator: keyLoc,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error deriving private key: %w", err)
+	}
+
+	opts := append([]musig2.ContextOption{
+		musig2.WithKnownSigners(allSignerPubKeys),
+	}, tweaks.ToContextOptions()...)
+	muSigContext, err := musig2.NewContext(privKey, true, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating signing context: %w",
+			err)
+	}
+	muSigSession, err := muSigContext.NewSession(
+		musig2.WithPreGeneratedNonce(localNonces),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating signing session: %w",
+			err)
+	}
+
+	return s.addMuSig2Session(
+		input.MuSig2Version100RC2, muSigContext, muSigSession, tweaks,
+		otherSignerNonces,
+	)
+}
+
+// addMuSig2Session registers the already known nonces of other signers with
+// the session and stores it in memory.
+func (s *Signer) addMuSig2Session(bipVersion input.MuSig2Version,
+	muSigContext input.MuSig2Context, muSigSession input.MuSig2Session,
+	tweaks *input.MuSig2Tweaks,
+	otherSignerNonces [][musig2.PubNonceSize]byte) (
+	*input.MuSig2SessionInfo, error) {
+
+	var (
+		haveAllNonces bool
+		err           error
+	)
+	for _, otherSignerNonce := range otherSignerNonces {
+		haveAllNonces, err = muSigSession.RegisterPubNonce(
+			otherSignerNonce,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error registering other "+
+				"signer public nonce: %w", err)
+		}
+	}
+
+	combinedKey, err := muSigContext.CombinedKey()
+	if err != nil {
+		return nil, fmt.Errorf("error getting combined key: %w", err)
+	}
+	session := &muSig2State{
+		MuSig2SessionInfo: input.MuSig2SessionInfo{
+			SessionID: input.NewMuSig2SessionID(
+				combinedKey, muSigSession.PublicNonce(),
+			),
+			Version:       bipVersion,
+			PublicNonce:   muSigSession.PublicNonce(),
+			CombinedKey:   combinedKey,
+			TaprootTweak:  tweaks.HasTaprootTweak(),
+			HaveAllNonces: haveAllNonces,
+		},
+		context: muSigContext,
+		session: muSigSession,
+	}
+
+	// The internal key is needed for script path spends of outputs that
+	// use the combined key as their internal key.
+	if tweaks.HasTaprootTweak() {
+		internalKey, err := muSigContext.TaprootInternalKey()
+		if err != nil {
+			return nil, fmt.Errorf("error getting internal key: %w",
+				err)
+		}
+		session.TaprootInternalKey = internalKey
+	}
+
+	s.muSig2SessionsMtx.Lock()
+	defer s.muSig2SessionsMtx.Unlock()
+
+	if s.muSig2Sessions == nil {
+		s.muSig2Sessions = make(map[input.MuSig2SessionID]*muSig2State)
+	}
+	if _, ok := s.muSig2Sessions[session.SessionID]; ok {
+		return nil, fmt.Errorf("session with ID %x already exists, "+
+			"nonces must not be reused", session.SessionID[:])
+	}
+	s.muSig2Sessions[session.SessionID] = session
+
+	return &session.MuSig2SessionInfo, nil
+}
+
+// MuSig2RegisterNonces registers the public nonces of other signers with the
+// session and returns true once the nonces of all signers are known.
+func (s *Signer) MuSig2RegisterNonces(sessionID input.MuSig2SessionID,
+	otherSignerNonces [][musig2.PubNonceSize]byte) (bool, error) {
+
+	s.muSig2SessionsMtx.Lock()
+	defer s.muSig2SessionsMtx.Unlock()
+
+	session, ok := s.muSig2Sessions[sessionID]
+	if !ok {
+		return false, fmt.Errorf("session with ID %x not found",
+			sessionID[:])
+	}
+	if session.HaveAllNonces {
+		return true, fmt.Errorf("already have all nonces")
+	}
+
+	numSigners := len(session.context.SigningKeys())
+	remainingNonces := numSigners - session.session.NumRegisteredNonces()
+	if len(otherSignerNonces) > remainingNonces {
+		return false, fmt.Errorf("only %d other nonces remaining but "+
+			"trying to register %d more", remainingNonces,
+			len(otherSignerNonces))
+	}
+
+	var err error
+	for _, otherSignerNonce := range otherSignerNonces {
+		session.HaveAllNonces, err = session.session.RegisterPubNonce(
+			otherSignerNonce,
+		)
+		if err != nil {
+			return false, fmt.Errorf("error registering other "+
+				"signer public nonce: %w", err)
+		}
+	}
+
+	return session.HaveAllNonces, nil
+}
+
+// MuSig2Sign creates the partial signature of the local key for the given
+// message. All nonces must be registered before. If this signer doesn't
+// combine the signatures, the session can be cleaned up right away.
+func (s *Signer) MuSig2Sign(sessionID input.MuSig2SessionID,
+	msg [sha256.Size]byte, cleanUp bool) (*musig2.PartialSignature, error) {
+
+	s.muSig2SessionsMtx.Lock()
+	defer s.muSig2SessionsMtx.Unlock()
+
+	session, ok := s.muSig2Sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("session with ID %x not found",
+			sessionID[:])
+	}
+	if !session.HaveAllNonces {
+		return nil, fmt.Errorf("only have %d of %d required nonces",
+			session.session.NumRegisteredNonces(),
+			len(session.context.SigningKeys()))
+	}
+
+	partialSig, err := input.MuSig2Sign(session.session, msg, true)
+	if err != nil {
+		return nil, fmt.Errorf("error signing with local key: %w", err)
+	}
+
+	if cleanUp {
+		delete(s.muSig2Sessions, sessionID)
+	}
+
+	return partialSig, nil
+}
+
+// MuSig2CombineSig combines the given partial signatures with the local one.
+// Once the partial signatures of all signers are known, the final signature is
+// returned and the session is removed.
+func (s *Signer) MuSig2CombineSig(sessionID input.MuSig2SessionID,
+	partialSigs []*musig2.PartialSignature) (*schnorr.Signature, bool,
+	error) {
+
+	s.muSig2SessionsMtx.Lock()
+	defer s.muSig2SessionsMtx.Unlock()
+
+	session, ok := s.muSig2Sessions[sessionID]
+	if !ok {
+		return nil, false, fmt.Errorf("session with ID %x not found",
+			sessionID[:])
+	}
+	if session.HaveAllSigs {
+		return nil, true, fmt.Errorf("already have all partial " +
+			"signatures")
+	}
+
+	var err error
+	for _, otherPartialSig := range partialSigs {
+		session.HaveAllSigs, err = input.MuSig2CombineSig(
+			session.session, otherPartialSig,
+		)
+		if err != nil {
+			return nil, false, fmt.Errorf("error combining "+
+				"partial signature: %w", err)
+		}
+	}
+
+	if !session.HaveAllSigs {
+		return nil, false, nil
+	}
+
+	delete(s.muSig2Sessions, sessionID)
+
+	return session.session.FinalSig(), true, nil
+}
+
+// MuSig2Cleanup removes a session from memory.
+func (s *Signer) MuSig2Cleanup(sessionID input.MuSig2SessionID) error {
+	s.muSig2SessionsMtx.Lock()
+	defer s.muSig2SessionsMtx.Unlock()
+
+	if _, ok := s.muSig2Sessions[sessionID]; !ok {
+		return fmt.Errorf("session with ID %x not found", sessionID[:])
+	}
+
+	delete(s.muSig2Sessions, sessionID)
+
+	return nil
+}
@@ -0,0 +1,414 @@
+package lnd
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+)
+
+var (
+	// PsbtKeyTypeInputSignatureTweakSingle is the proprietary PSBT input
+	// key type that holds the single tweak of the key an input needs to be
+	// signed with. This is the same key type lnd uses for its PSBTs.
+	PsbtKeyTypeInputSignatureTweakSingle = []byte{0x51}
+
+	// PsbtKeyTypeInputSignatureTweakDouble is the proprietary PSBT input
+	// key type that holds the double tweak (the private key of the
+	// revealed commitment secret) of the key an input needs to be signed
+	// with. This is the same key type lnd uses for its PSBTs.
+	PsbtKeyTypeInputSignatureTweakDouble = []byte{0x52}
+
+	// PsbtKeyTypeInputWitnessTemplate is the proprietary PSBT input key
+	// type that holds the serialized witness of an input with placeholder
+	// signatures. The placeholders are replaced with the actual signatures
+	// once the PSBT is signed, which allows the transaction to be
+	// finalized without knowing the scripts involved.
+	PsbtKeyTypeInputWitnessTemplate = []byte{0x53}
+)
+
+// KeyDeriver derives the public key of a key locator.
+type KeyDeriver interface {
+	// DeriveKey returns the public key at the given key locator.
+	DeriveKey(keyLoc keychain.KeyLocator) (*keychain.KeyDescriptor, error)
+}
+
+// AccountXPubDeriver derives the public keys of lnd's internal keychain from
+// the extended public keys of its key family accounts alone. That allows a PSBT
+// for an external signer to be created without the seed.
+type AccountXPubDeriver struct {
+	// AccountKeys are the extended public keys of the key family accounts
+	// (m/1017'/<coin_type>'/<key_family>') the keys are derived from.
+	AccountKeys []*AccountKey
+}
+
+// DeriveKey returns the public key at the given key locator.
+func (d *AccountXPubDeriver) DeriveKey(
+	keyLoc keychain.KeyLocator) (*keychain.KeyDescriptor, error) {
+
+	familyIndex := HardenedKey(uint32(keyLoc.Family))
+	for _, accountKey := range d.AccountKeys {
+		if accountKey.ChildIndex() != familyIndex {
+			continue
+		}
+
+		key, err := DeriveChildren(accountKey.ExtendedKey, []uint32{
+			0, keyLoc.Index,
+		})
+		if err != nil {
+			return nil, err
+		}
+		pubKey, err := key.ECPubKey()
+		if err != nil {
+			return nil, err
+		}
+
+		return &keychain.KeyDescriptor{
+			KeyLocator: keyLoc,
+			PubKey:     pubKey,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no account key of key family %d given",
+		keyLoc.Family)
+}
+
+// PsbtSigner is a signer that delegates the signing to an external signer,
+// for example a hardware wallet, through a PSBT. It is used in two passes: In
+// the first pass no signed packet is set and the signer only records all sign
+// requests in a PSBT and returns placeholder signatures. The PSBT is then
+// signed externally. In the second pass the same transaction is signed again
+// with the signed packet set, and the signer returns the signatures it
+// contains.
+type PsbtSigner struct {
+	input.MockSigner
+	KeyDeriver
+
+	// ChainParams are the parameters of the chain the keys are derived
+	// for.
+	ChainParams *chaincfg.Params
+
+	// Fingerprint is the fingerprint of the master key of the external
+	// signer, in the byte order used by the psbt package.
+	Fingerprint uint32
+
+	// SignedPacket is the PSBT that was signed by the external signer.
+	SignedPacket *psbt.Packet
+
+	packet *psbt.Packet
+}
+
+// Packet returns the PSBT with all sign requests recorded so far.
+func (s *PsbtSigner) Packet() (*psbt.Packet, error) {
+	if s.packet == nil {
+		return nil, fmt.Errorf("no inputs to sign")
+	}
+
+	return s.packet, nil
+}
+
+// SignOutputRaw records the sign request in the PSBT or returns the signature
+// of the external signer, depending on the pass.
+func (s *PsbtSigner) SignOutputRaw(tx *wire.MsgTx,
+	signDesc *input.SignDescriptor) (input.Signature, error) {
+
+	if s.SignedPacket != nil {
+		return s.externalSignature(tx, signDesc)
+	}
+
+	if s.packet == nil || s.packet.UnsignedTx.TxHash() != tx.TxHash() {
+		unsignedTx := tx.Copy()
+		for _, txIn := range unsignedTx.TxIn {
+			txIn.SignatureScript = nil
+			txIn.Witness = nil
+		}
+
+		var err error
+		s.packet, err = psbt.NewFromUnsignedTx(unsignedTx)
+		if err != nil {
+			return nil, fmt.Errorf("error creating PSBT: %w", err)
+		}
+	}
+
+	if err := s.addSignRequest(tx, signDesc); err != nil {
+		return nil, err
+	}
+
+	// The placeholder signatures are never valid, they only allow the
+	// caller to build the transaction as usual.
+	return placeholderSig(
+		txscript.IsPayToTaproot(signDesc.Output.PkScript),
+	), nil
+}
+
+// AddWitnessTemplates adds the witnesses of the given transaction, which was
+// built with the placeholder signatures, to the inputs of the PSBT that need
+// to be signed. For inputs that are spent through a tap script, the leaf script
+// and control block are added as well.
+func (s *PsbtSigner) AddWitnessTemplates(tx *wire.MsgTx) error {
+	packet, err := s.Packet()
+	if err != nil {
+		return err
+	}
+	if packet.UnsignedTx.TxHash() != tx.TxHash() {
+		return fmt.Errorf("transaction doesn't match PSBT")
+	}
+
+	for idx := range packet.Inputs {
+		pIn := &packet.Inputs[idx]
+		if pIn.WitnessUtxo == nil {
+			continue
+		}
+
+		witness := tx.TxIn[idx].Witness
+		var buf bytes.Buffer
+		if err := psbt.WriteTxWitness(&buf, witness); err != nil {
+			return fmt.Errorf("error serializing witness: %w", err)
+		}
+		pIn.Unknowns = append(pIn.Unknowns, &psbt.Unknown{
+			Key:   PsbtKeyTypeInputWitnessTemplate,
+			Value: buf.Bytes(),
+		})
+
+		// A tap script spend witness ends with the script and the
+		// control block.
+		if len(pIn.TaprootBip32Derivation) == 0 ||
+			len(pIn.TaprootBip32Derivation[0].LeafHashes) == 0 ||
+			len(witness) < 2 {
+
+			continue
+		}
+		pIn.TaprootLeafScript = []*psbt.TaprootTapLeafScript{{
+			ControlBlock: witness[len(witness)-1],
+			Script:       witness[len(witness)-2],
+			LeafVersion:  txscript.BaseLeafVersion,
+		}}
+	}
+
+	return nil
+}
+
+// placeholderSig returns the placeholder signature the PSBT signer returns
+// while recording the sign requests.
+func placeholderSig(taproot bool) input.Signature {
+	var one btcec.ModNScalar
+	one.SetInt(1)
+	if taproot {
+		var r btcec.FieldVal
+		r.SetInt(1)
+		return schnorr.NewSignature(&r, &one)
+	}
+
+	return ecdsa.NewSignature(&one, &one)
+}
+
+// isPlaceholderSig returns true if the witness element is a placeholder
+// signature, optionally followed by a sighash flag.
+func isPlaceholderSig(element []byte) bool {
+	for _, taproot := range []bool{false, true} {
+		sig := placeholderSig(taproot).Serialize()
+		if len(element) < len(sig) || len(element) > len(sig)+1 {
+			continue
+		}
+		if bytes.Equal(element[:len(sig)], sig) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ComputeInputScript is not supported by the PSBT signer.
+func (s *PsbtSigner) ComputeInputScript(_ *wire.MsgTx,
+	_ *input.SignDescriptor) (*input.Script, error) {
+
+	return nil, fmt.Errorf("unimplemented")
+}
+
+// addSignRequest adds all information the external signer needs to sign the
+// input described by the sign descriptor to the PSBT.
+func (s *PsbtSigner) addSignRequest(tx *wire.MsgTx,
+	signDesc *input.SignDescriptor) error {
+
+	if signDesc.InputIndex >= len(s.packet.Inputs) {
+		return fmt.Errorf("invalid input index %d", signDesc.InputIndex)
+	}
+
+	keyDesc := signDesc.KeyDesc
+	if keyDesc.PubKey == nil {
+		derived, err := s.DeriveKey(keyDesc.KeyLocator)
+		if err != nil {
+			return err
+		}
+		keyDesc.PubKey = derived.PubKey
+	}
+
+	path := []uint32{
+		HardenedKeyStart + uint32(keychain.BIP0043Purpose),
+		HardenedKeyStart + s.ChainParams.HDCoinType,
+		HardenedKeyStart + uint32(keyDesc.Family),
+		0,
+		keyDesc.Index,
+	}
+
+	pIn := &s.packet.Inputs[signDesc.InputIndex]
+	pIn.WitnessUtxo = signDesc.Output
+	pIn.SighashType = signDesc.HashType
+
+	if !txscript.IsPayToTaproot(signDesc.Output.PkScript) {
+		// The witness script of a P2WKH output is implied.
+		if txscript.IsPayToWitnessScriptHash(signDesc.Output.PkScript) {
+			pIn.WitnessScript = signDesc.WitnessScript
+		}
+		pubKey := keyDesc.PubKey.SerializeCompressed()
+		pIn.Bip32Derivation = []*psbt.Bip32Derivation{{
+			PubKey:               pubKey,
+			MasterKeyFingerprint: s.Fingerprint,
+			Bip32Path:            path,
+		}}
+
+		pIn.Unknowns = nil
+		if len(signDesc.SingleTweak) > 0 {
+			pIn.Unknowns = append(pIn.Unknowns, &psbt.Unknown{
+				Key:   PsbtKeyTypeInputSignatureTweakSingle,
+				Value: signDesc.SingleTweak,
+			})
+		}
+		if signDesc.DoubleTweak != nil {
+			pIn.Unknowns = append(pIn.Unknowns, &psbt.Unknown{
+				Key:   PsbtKeyTypeInputSignatureTweakDouble,
+				Value: signDesc.DoubleTweak.Serialize(),
+			})
+		}
+
+		return nil
+	}
+
+	// Taproot signatures commit to all previous outputs, so the signer
+	// needs to know all of them.
+	if signDesc.PrevOutputFetcher != nil {
+		for idx, txIn := range tx.TxIn {
+			prevOut := signDesc.PrevOutputFetcher.FetchPrevOutput(
+				txIn.PreviousOutPoint,
+			)
+			if prevOut != nil && s.packet.Inputs[idx].WitnessUtxo ==
+				nil {
+
+				s.packet.Inputs[idx].WitnessUtxo = prevOut
+			}
+		}
+	}
+
+	derivation := &psbt.TaprootBip32Derivation{
+		XOnlyPubKey:          schnorr.SerializePubKey(keyDesc.PubKey),
+		MasterKeyFingerprint: s.Fingerprint,
+		Bip32Path:            path,
+	}
+	switch signDesc.SignMethod {
+	case input.TaprootKeySpendBIP0086SignMethod:
+
+	case input.TaprootKeySpendSignMethod:
+		pIn.TaprootMerkleRoot = signDesc.TapTweak
+
+	case input.TaprootScriptSpendSignMethod:
+		leafHash := txscript.NewBaseTapLeaf(
+			signDesc.WitnessScript,
+		).TapHash()
+		derivation.LeafHashes = [][]byte{leafHash[:]}
+
+	default:
+		return fmt.Errorf("unknown sign method: %v",
+			signDesc.SignMethod)
+	}
+	pIn.TaprootBip32Derivation = []*psbt.TaprootBip32Derivation{
+		derivation,
+	}
+
+	return nil
+}
+
+// externalSignature returns the signature of the external signer for the
+// input described by the sign descriptor.
+func (s *PsbtSigner) externalSignature(tx *wire.MsgTx,
+	signDesc *input.SignDescriptor) (input.Signature, error) {
+
+	if s.SignedPacket.UnsignedTx.TxHash() != tx.TxHash() {
+		return nil, fmt.Errorf("the transaction to sign differs from " +
+			"the one in the signed PSBT, make sure to use the " +
+			"same flags (for example --feerate) as for the export")
+	}
+	if signDesc.InputIndex >= len(s.SignedPacket.Inputs) {
+		return nil, fmt.Errorf("invalid input index %d",
+			signDesc.InputIndex)
+	}
+	pIn := s.SignedPacket.Inputs[signDesc.InputIndex]
+
+	keyDesc := signDesc.KeyDesc
+	if keyDesc.PubKey == nil {
+		derived, err := s.DeriveKey(keyDesc.KeyLocator)
+		if err != nil {
+			return nil, err
+		}
+		keyDesc.PubKey = derived.PubKey
+	}
+
+	if txscript.IsPayToTaproot(signDesc.Output.PkScript) {
+		rawSig := pIn.TaprootKeySpendSig
+		if signDesc.SignMethod == input.TaprootScriptSpendSignMethod {
+			rawSig = nil
+			leafHash := txscript.NewBaseTapLeaf(
+				signDesc.WitnessScript,
+			).TapHash()
+			xOnlyKey := schnorr.SerializePubKey(keyDesc.PubKey)
+			for _, sig := range pIn.TaprootScriptSpendSig {
+				if bytes.Equal(sig.LeafHash, leafHash[:]) &&
+					bytes.Equal(sig.XOnlyPubKey, xOnlyKey) {
+
+					rawSig = sig.Signature
+				}
+			}
+		}
+		if len(rawSig) < schnorr.SignatureSize {
+			return nil, fmt.Errorf("signed PSBT has no signature "+
+				"for input %d", signDesc.InputIndex)
+		}
+
+		return schnorr.ParseSignature(rawSig[:schnorr.SignatureSize])
+	}
+
+	// The signature is made with the tweaked key, if there is a tweak.
+	signingKey := keyDesc.PubKey
+	switch {
+	case len(signDesc.SingleTweak) > 0:
+		signingKey = input.TweakPubKeyWithTweak(
+			signingKey, signDesc.SingleTweak,
+		)
+
+	case signDesc.DoubleTweak != nil:
+		signingKey = input.DeriveRevocationPubkey(
+			signingKey, signDesc.DoubleTweak.PubKey(),
+		)
+	}
+
+	for _, sig := range pIn.PartialSigs {
+		if !bytes.Equal(sig.PubKey, signingKey.SerializeCompressed()) {
+			continue
+		}
+
+		// Chop off the sighash flag at the end of the signature.
+		return ecdsa.ParseDERSignature(sig.Signature[:len(
+			sig.Signature,
+		)-1])
+	}
+
+	return nil, fmt.Errorf("signed PSBT has no signature for input %d",
+		signDesc.InputIndex)
+}
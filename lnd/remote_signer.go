@@ -0,0 +1,291 @@
+package lnd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnrpc/signrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/walletrpc"
+	"github.com/lightningnetwork/lnd/macaroons"
+	"github.com/lightningnetwork/lnd/shachain"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"gopkg.in/macaroon.v2"
+)
+
+const (
+	// remoteSignerTimeout is the timeout for a single RPC call to the
+	// remote signer.
+	remoteSignerTimeout = time.Minute
+)
+
+var (
+	// ErrLegacyRevocationRoot is returned if the revocation root of the
+	// legacy scheme is requested from a remote signer. That scheme uses
+	// the private key itself, which the remote signer never reveals.
+	ErrLegacyRevocationRoot = errors.New("the legacy revocation root " +
+		"can only be derived from the seed")
+)
+
+// RemoteSigner is a signer that uses the keys of a remote lnd node through
+// its signrpc and walletrpc gRPC interfaces, so the seed never has to leave
+// that node. The macaroon used needs the permissions to derive keys and to
+// sign, for example the admin or signer macaroon.
+type RemoteSigner struct {
+	input.MockSigner
+
+	conn   *grpc.ClientConn
+	signer signrpc.SignerClient
+	wallet walletrpc.WalletKitClient
+}
+
+// DialRemoteSigner connects to the gRPC interface of the lnd node at the given
+// host:port, using the given TLS certificate and macaroon files.
+func DialRemoteSigner(host, tlsCertPath, macaroonPath string) (*RemoteSigner,
+	error) {
+
+	tlsCreds, err := credentials.NewClientTLSFromFile(tlsCertPath, "")
+	if err != nil {
+		return nil, fmt.Errorf("error reading TLS certificate: %w", err)
+	}
+
+	macBytes, err := os.ReadFile(macaroonPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading macaroon: %w", err)
+	}
+	mac := &macaroon.Macaroon{}
+	if err := mac.UnmarshalBinary(macBytes); err != nil {
+		return nil, fmt.Errorf("error decoding macaroon: %w", err)
+	}
+	macCred, err := macaroons.NewMacaroonCredential(mac)
+	if err != nil {
+		return nil, fmt.Errorf("error creating macaroon credential: %w",
+			err)
+	}
+
+	conn, err := grpc.Dial(
+		host, grpc.WithTransportCredentials(tlsCreds),
+		grpc.WithPerRPCCredentials(macCred),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to remote signer: %w",
+			err)
+	}
+
+	return NewRemoteSigner(conn), nil
+}
+
+// NewRemoteSigner creates a remote signer that uses the given gRPC connection.
+func NewRemoteSigner(conn *grpc.ClientConn) *RemoteSigner {
+	return &RemoteSigner{
+		conn:   conn,
+		signer: signrpc.NewSignerClient(conn),
+		wallet: walletrpc.NewWalletKitClient(conn),
+	}
+}
+
+// Close closes the connection to the remote signer.
+func (r *RemoteSigner) Close() error {
+	return r.conn.Close()
+}
+
+// DeriveKey returns the public key at the given key locator.
+func (r *RemoteSigner) DeriveKey(
+	keyLoc keychain.KeyLocator) (*keychain.KeyDescriptor, error) {
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), remoteSignerTimeout,
+	)
+	defer cancel()
+
+	keyDesc, err := r.wallet.DeriveKey(ctx, &signrpc.KeyLocator{
+		KeyFamily: int32(keyLoc.Family),
+		KeyIndex:  int32(keyLoc.Index),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error deriving key %d/%d: %w",
+			keyLoc.Family, keyLoc.Index, err)
+	}
+
+	pubKey, err := btcec.ParsePubKey(keyDesc.RawKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing public key: %w", err)
+	}
+
+	return &keychain.KeyDescriptor{
+		KeyLocator: keyLoc,
+		PubKey:     pubKey,
+	}, nil
+}
+
+// SignOutputRaw lets the remote signer create a signature for the input
+// described by the sign descriptor.
+func (r *RemoteSigner) SignOutputRaw(tx *wire.MsgTx,
+	signDesc *input.SignDescriptor) (input.Signature, error) {
+
+	var txBuf bytes.Buffer
+	if err := tx.Serialize(&txBuf); err != nil {
+		return nil, err
+	}
+
+	signMethod, err := marshalSignMethod(signDesc.SignMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	rpcDesc := &signrpc.SignDescriptor{
+		KeyDesc: &signrpc.KeyDescriptor{
+			KeyLoc: &signrpc.KeyLocator{
+				KeyFamily: int32(signDesc.KeyDesc.Family),
+				KeyIndex:  int32(signDesc.KeyDesc.Index),
+			},
+		},
+		SingleTweak:   signDesc.SingleTweak,
+		TapTweak:      signDesc.TapTweak,
+		WitnessScript: signDesc.WitnessScript,
+		Output: &signrpc.TxOut{
+			Value:    signDesc.Output.Value,
+			PkScript: signDesc.Output.PkScript,
+		},
+		Sighash:    uint32(signDesc.HashType),
+		InputIndex: int32(signDesc.InputIndex),
+		SignMethod: signMethod,
+	}
+	if signDesc.KeyDesc.PubKey != nil {
+		rpcDesc.KeyDesc.RawKeyBytes =
+			signDesc.KeyDesc.PubKey.SerializeCompressed()
+	}
+	if signDesc.DoubleTweak != nil {
+		rpcDesc.DoubleTweak = signDesc.DoubleTweak.Serialize()
+	}
+
+	// Taproot signatures commit to all previous outputs, so we need to
+	// send them along if we know them.
+	var prevOutputs []*signrpc.TxOut
+	if signDesc.PrevOutputFetcher != nil {
+		for _, txIn := range tx.TxIn {
+			prevOut := signDesc.PrevOutputFetcher.FetchPrevOutput(
+				txIn.PreviousOutPoint,
+			)
+			if prevOut == nil {
+				return nil, fmt.Errorf("previous output %v "+
+					"not found", txIn.PreviousOutPoint)
+			}
+			prevOutputs = append(prevOutputs, &signrpc.TxOut{
+				Value:    prevOut.Value,
+				PkScript: prevOut.PkScript,
+			})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), remoteSignerTimeout,
+	)
+	defer cancel()
+
+	resp, err := r.signer.SignOutputRaw(ctx, &signrpc.SignReq{
+		RawTxBytes:  txBuf.Bytes(),
+		SignDescs:   []*signrpc.SignDescriptor{rpcDesc},
+		PrevOutputs: prevOutputs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error signing with remote signer: %w",
+			err)
+	}
+	if len(resp.RawSigs) != 1 {
+		return nil, fmt.Errorf("expected 1 signature, got %d",
+			len(resp.RawSigs))
+	}
+
+	rawSig := resp.RawSigs[0]
+	if txscript.IsPayToTaproot(signDesc.Output.PkScript) {
+		if len(rawSig) < schnorr.SignatureSize {
+			return nil, fmt.Errorf("invalid schnorr signature "+
+				"length %d", len(rawSig))
+		}
+
+		return schnorr.ParseSignature(rawSig[:schnorr.SignatureSize])
+	}
+
+	return ecdsa.ParseDERSignature(rawSig)
+}
+
+// ShaChainRoot returns the revocation producer of a channel whose revocation
+// root was created with ECDH between the revocation root key at the given key
+// locator and the local multisig public key, which is what lnd does since
+// v0.13.0-beta. The legacy scheme (multiSigPubKey is nil) isn't supported.
+func (r *RemoteSigner) ShaChainRoot(keyLoc keychain.KeyLocator,
+	multiSigPubKey *btcec.PublicKey) (*shachain.RevocationProducer, error) {
+
+	if multiSigPubKey == nil {
+		return nil, ErrLegacyRevocationRoot
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), remoteSignerTimeout,
+	)
+	defer cancel()
+
+	resp, err := r.signer.DeriveSharedKey(ctx, &signrpc.SharedKeyRequest{
+		EphemeralPubkey: multiSigPubKey.SerializeCompressed(),
+		KeyDesc: &signrpc.KeyDescriptor{
+			KeyLoc: &signrpc.KeyLocator{
+				KeyFamily: int32(keyLoc.Family),
+				KeyIndex:  int32(keyLoc.Index),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error deriving shared key %d/%d: %w",
+			keyLoc.Family, keyLoc.Index, err)
+	}
+
+	revRoot, err := chainhash.NewHash(resp.SharedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shared key: %w", err)
+	}
+
+	return shachain.NewRevocationProducer(*revRoot), nil
+}
+
+// ComputeInputScript is not supported by the remote signer.
+func (r *RemoteSigner) ComputeInputScript(_ *wire.MsgTx,
+	_ *input.SignDescriptor) (*input.Script, error) {
+
+	return nil, fmt.Errorf("unimplemented")
+}
+
+// marshalSignMethod converts a sign method into its RPC representation.
+func marshalSignMethod(signMethod input.SignMethod) (signrpc.SignMethod,
+	error) {
+
+	switch signMethod {
+	case input.WitnessV0SignMethod:
+		return signrpc.SignMethod_SIGN_METHOD_WITNESS_V0, nil
+
+	case input.TaprootKeySpendBIP0086SignMethod:
+		return signrpc.SignMethod_SIGN_METHOD_TAPROOT_KEY_SPEND_BIP0086,
+			nil
+
+	case input.TaprootKeySpendSignMethod:
+		return signrpc.SignMethod_SIGN_METHOD_TAPROOT_KEY_SPEND, nil
+
+	case input.TaprootScriptSpendSignMethod:
+		return signrpc.SignMethod_SIGN_METHOD_TAPROOT_SCRIPT_SPEND, nil
+
+	default:
+		return 0, fmt.Errorf("unknown sign method %v", signMethod)
+	}
+}
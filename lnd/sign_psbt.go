@@ -0,0 +1,452 @@
+package lnd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+)
+
+// SignPsbt signs all inputs of a PSBT with the keys of this signer. The keys
+// are derived from the BIP32 derivations of the inputs, which can be lnd key
+// paths (m/1017'/<coin_type>'/<key_family>'/0/<index>) as well as any other
+// path, for example the wallet paths m/84'/0'/0'/0/<index>. Inputs without a
+// BIP32 derivation or only with derivations of other master keys are skipped,
+// the indexes of all inputs that were signed are returned. Legacy (non-SegWit)
+// inputs need the full previous transaction.
+func (s *Signer) SignPsbt(packet *psbt.Packet) ([]int, error) {
+	// Taproot signatures commit to the previous outputs of all inputs.
+	var (
+		prevOutFetcher = txscript.NewMultiPrevOutFetcher(nil)
+		utxos          = make([]*wire.TxOut, len(packet.Inputs))
+	)
+	for idx, txIn := range packet.UnsignedTx.TxIn {
+		utxo, err := psbtInputUtxo(packet, idx)
+		if err != nil {
+			return nil, err
+		}
+		prevOutFetcher.AddPrevOut(txIn.PreviousOutPoint, utxo)
+		utxos[idx] = utxo
+	}
+	sigHashes := txscript.NewTxSigHashes(packet.UnsignedTx, prevOutFetcher)
+
+	var signed []int
+	for idx := range packet.Inputs {
+		pIn := &packet.Inputs[idx]
+		signDesc, privKey, err := s.psbtSignDescriptor(pIn, utxos[idx])
+		if err != nil {
+			return nil, fmt.Errorf("error signing input %d: %w",
+				idx, err)
+		}
+		if signDesc == nil {
+			continue
+		}
+
+		// Legacy inputs use the original signature hash algorithm that
+		// doesn't commit to the value of the output being spent.
+		if pIn.WitnessUtxo == nil {
+			err := addLegacySignature(
+				packet.UnsignedTx, idx, pIn, signDesc, privKey,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("error signing input "+
+					"%d: %w", idx, err)
+			}
+			signed = append(signed, idx)
+
+			continue
+		}
+
+		signDesc.InputIndex = idx
+		signDesc.SigHashes = sigHashes
+		signDesc.PrevOutputFetcher = prevOutFetcher
+		sig, err := signOutputRaw(packet.UnsignedTx, signDesc, privKey)
+		if err != nil {
+			return nil, fmt.Errorf("error signing input %d: %w",
+				idx, err)
+		}
+
+		addPsbtSignature(pIn, signDesc, sig)
+		signed = append(signed, idx)
+	}
+
+	return signed, nil
+}
+
+// addPsbtSignature adds the signature for the input described by the sign
+// descriptor to the PSBT input.
+func addPsbtSignature(pIn *psbt.PInput, signDesc *input.SignDescriptor,
+	sig input.Signature) {
+
+	switch signDesc.SignMethod {
+	case input.TaprootKeySpendBIP0086SignMethod,
+		input.TaprootKeySpendSignMethod:
+
+		pIn.TaprootKeySpendSig = taprootSig(sig, signDesc.HashType)
+
+	case input.TaprootScriptSpendSignMethod:
+		leafHash := txscript.NewBaseTapLeaf(
+			signDesc.WitnessScript,
+		).TapHash()
+		xOnlyKey := schnorr.SerializePubKey(signDesc.KeyDesc.PubKey)
+		pIn.TaprootScriptSpendSig = append(
+			pIn.TaprootScriptSpendSig, &psbt.TaprootScriptSpendSig{
+				XOnlyPubKey: xOnlyKey,
+				LeafHash:    leafHash[:],
+				Signature:   sig.Serialize(),
+				SigHash:     signDesc.HashType,
+			},
+		)
+
+	default:
+		// The signature is made with the tweaked key, if there is a
+		// tweak.
+		signingKey := signDesc.KeyDesc.PubKey
+		switch {
+		case len(signDesc.SingleTweak) > 0:
+			signingKey = input.TweakPubKeyWithTweak(
+				signingKey, signDesc.SingleTweak,
+			)
+
+		case signDesc.DoubleTweak != nil:
+			signingKey = input.DeriveRevocationPubkey(
+				signingKey, signDesc.DoubleTweak.PubKey(),
+			)
+		}
+		sigBytes := append(sig.Serialize(), byte(signDesc.HashType))
+		pIn.PartialSigs = append(pIn.PartialSigs, &psbt.PartialSig{
+			PubKey:    signingKey.SerializeCompressed(),
+			Signature: sigBytes,
+		})
+	}
+}
+
+// addLegacySignature signs the legacy (non-SegWit) input with the given index
+// and adds the signature to the PSBT input.
+func addLegacySignature(tx *wire.MsgTx, idx int, pIn *psbt.PInput,
+	signDesc *input.SignDescriptor, privKey *btcec.PrivateKey) error {
+
+	privKey = maybeTweakPrivKey(signDesc, privKey)
+	sig, err := txscript.RawTxInSignature(
+		tx, idx, signDesc.WitnessScript, signDesc.HashType, privKey,
+	)
+	if err != nil {
+		return err
+	}
+
+	pIn.PartialSigs = append(pIn.PartialSigs, &psbt.PartialSig{
+		PubKey:    privKey.PubKey().SerializeCompressed(),
+		Signature: sig,
+	})
+
+	return nil
+}
+
+// psbtInputUtxo returns the output spent by the PSBT input with the given
+// index. For SegWit inputs that only contain the full previous transaction, the
+// witness UTXO is added to the input.
+func psbtInputUtxo(packet *psbt.Packet, idx int) (*wire.TxOut, error) {
+	pIn := &packet.Inputs[idx]
+	if pIn.WitnessUtxo != nil {
+		return pIn.WitnessUtxo, nil
+	}
+
+	prevOut := packet.UnsignedTx.TxIn[idx].PreviousOutPoint
+	prevTx := pIn.NonWitnessUtxo
+	if prevTx == nil || prevTx.TxHash() != prevOut.Hash ||
+		int(prevOut.Index) >= len(prevTx.TxOut) {
+
+		return nil, fmt.Errorf("input %d has no witness UTXO", idx)
+	}
+
+	utxo := prevTx.TxOut[prevOut.Index]
+	if isWitnessInput(utxo.PkScript, pIn.RedeemScript) {
+		pIn.WitnessUtxo = utxo
+	}
+
+	return utxo, nil
+}
+
+// isWitnessInput returns true if the given output script (and redeem script in
+// case of a P2SH output) is a SegWit output.
+func isWitnessInput(pkScript, redeemScript []byte) bool {
+	if txscript.IsPayToScriptHash(pkScript) {
+		return txscript.IsWitnessProgram(redeemScript)
+	}
+
+	return txscript.IsWitnessProgram(pkScript)
+}
+
+// masterFingerprint returns the fingerprint of the root key in the byte order
+// used by the psbt package or zero if the signer only has an account key.
+func (s *Signer) masterFingerprint() uint32 {
+	return MasterFingerprint(s.ExtendedKey)
+}
+
+// MasterFingerprint returns the fingerprint of the given root key in the byte
+// order used by the psbt package or zero if the key isn't a root key.
+func MasterFingerprint(extendedKey *hdkeychain.ExtendedKey) uint32 {
+	if extendedKey.Depth() != 0 {
+		return 0
+	}
+	pubKey, err := extendedKey.ECPubKey()
+	if err != nil {
+		return 0
+	}
+
+	hash := btcutil.Hash160(pubKey.SerializeCompressed())
+	return binary.LittleEndian.Uint32(hash[:4])
+}
+
+// isOurFingerprint returns true if a BIP32 derivation with the given master
+// key fingerprint can belong to this signer. A fingerprint of zero is used by
+// PSBTs that don't know the fingerprint, so it matches any key.
+func (s *Signer) isOurFingerprint(fingerprint uint32) bool {
+	ourFingerprint := s.masterFingerprint()
+	return fingerprint == 0 || ourFingerprint == 0 ||
+		fingerprint == ourFingerprint
+}
+
+// psbtSignDescriptor creates the sign descriptor and private key for a PSBT
+// input that spends the given output from its BIP32 derivation and the
+// proprietary tweak fields. Nil is returned if the input has no BIP32
+// derivation of our master key.
+func (s *Signer) psbtSignDescriptor(pIn *psbt.PInput,
+	utxo *wire.TxOut) (*input.SignDescriptor, *btcec.PrivateKey, error) {
+
+	signDesc := &input.SignDescriptor{
+		Output:   utxo,
+		HashType: pIn.SighashType,
+	}
+
+	var (
+		taprootDerivation *psbt.TaprootBip32Derivation
+		derivation        *psbt.Bip32Derivation
+	)
+	for _, d := range pIn.TaprootBip32Derivation {
+		if s.isOurFingerprint(d.MasterKeyFingerprint) {
+			taprootDerivation = d
+			break
+		}
+	}
+	for _, d := range pIn.Bip32Derivation {
+		if s.isOurFingerprint(d.MasterKeyFingerprint) {
+			derivation = d
+			break
+		}
+	}
+
+	var (
+		path      []uint32
+		pubKey    []byte
+		serialize func(*btcec.PublicKey) []byte
+	)
+	switch {
+	case taprootDerivation != nil:
+		path = taprootDerivation.Bip32Path
+		pubKey = taprootDerivation.XOnlyPubKey
+		serialize = schnorr.SerializePubKey
+
+		switch {
+		case len(taprootDerivation.LeafHashes) > 0:
+			signDesc.SignMethod = input.TaprootScriptSpendSignMethod
+			targetLeaf := taprootDerivation.LeafHashes[0]
+			for _, leaf := range pIn.TaprootLeafScript {
+				leafHash := txscript.NewBaseTapLeaf(
+					leaf.Script,
+				).TapHash()
+				if bytes.Equal(leafHash[:], targetLeaf) {
+					signDesc.WitnessScript = leaf.Script
+				}
+			}
+			if signDesc.WitnessScript == nil {
+				return nil, nil, fmt.Errorf("leaf script " +
+					"missing")
+			}
+
+		case len(pIn.TaprootMerkleRoot) > 0:
+			signDesc.SignMethod = input.TaprootKeySpendSignMethod
+			signDesc.TapTweak = pIn.TaprootMerkleRoot
+
+		default:
+			signDesc.SignMethod =
+				input.TaprootKeySpendBIP0086SignMethod
+		}
+
+	case derivation != nil:
+		path = derivation.Bip32Path
+		pubKey = derivation.PubKey
+		serialize = (*btcec.PublicKey).SerializeCompressed
+
+		signDesc.SignMethod = input.WitnessV0SignMethod
+		if signDesc.HashType == 0 {
+			signDesc.HashType = txscript.SigHashAll
+		}
+
+		// The txscript library expects the witness script of a P2WKH
+		// output to be the pkScript of the output. For a nested P2WKH
+		// output that is the redeem script. The same applies to the
+		// script that is signed for a legacy P2PKH or P2SH output.
+		signDesc.WitnessScript = pIn.WitnessScript
+		if len(signDesc.WitnessScript) == 0 {
+			signDesc.WitnessScript = pIn.RedeemScript
+		}
+		if len(signDesc.WitnessScript) == 0 {
+			signDesc.WitnessScript = utxo.PkScript
+		}
+
+		singleKey := PsbtKeyTypeInputSignatureTweakSingle
+		doubleKey := PsbtKeyTypeInputSignatureTweakDouble
+		for _, unknown := range pIn.Unknowns {
+			switch {
+			case bytes.Equal(unknown.Key, singleKey):
+				signDesc.SingleTweak = unknown.Value
+
+			case bytes.Equal(unknown.Key, doubleKey):
+				signDesc.DoubleTweak, _ =
+					btcec.PrivKeyFromBytes(unknown.Value)
+			}
+		}
+
+	default:
+		return nil, nil, nil
+	}
+
+	derivedKey, err := DeriveFromRoot(s.ExtendedKey, path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error deriving key at path %v: "+
+			"%w", FormatPath(path), err)
+	}
+	privKey, err := derivedKey.ECPrivKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error deriving key at path %v: "+
+			"%w", FormatPath(path), err)
+	}
+	if !bytes.Equal(serialize(privKey.PubKey()), pubKey) {
+		return nil, nil, fmt.Errorf("key %x at path %v doesn't match "+
+			"the key derived from the seed, wrong seed?", pubKey,
+			FormatPath(path))
+	}
+	signDesc.KeyDesc = keychain.KeyDescriptor{
+		PubKey: privKey.PubKey(),
+	}
+
+	return signDesc, privKey, nil
+}
+
+// FinalizePsbt replaces the placeholder signatures in the witness templates of
+// a PSBT created by the PsbtSigner with the actual signatures and extracts the
+// final transaction.
+func FinalizePsbt(packet *psbt.Packet) (*wire.MsgTx, error) {
+	for idx := range packet.Inputs {
+		pIn := &packet.Inputs[idx]
+
+		var template []byte
+		for _, unknown := range pIn.Unknowns {
+			if bytes.Equal(
+				unknown.Key, PsbtKeyTypeInputWitnessTemplate,
+			) {
+
+				template = unknown.Value
+			}
+		}
+		if template == nil {
+			return nil, fmt.Errorf("input %d has no witness "+
+				"template", idx)
+		}
+		witness, err := readWitness(template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid witness template of "+
+				"input %d: %w", idx, err)
+		}
+
+		var sig []byte
+		switch {
+		case len(pIn.TaprootKeySpendSig) > 0:
+			sig = pIn.TaprootKeySpendSig
+
+		case len(pIn.TaprootScriptSpendSig) == 1:
+			scriptSig := pIn.TaprootScriptSpendSig[0]
+			sig = scriptSig.Signature
+			if scriptSig.SigHash != txscript.SigHashDefault {
+				sig = append(sig, byte(scriptSig.SigHash))
+			}
+
+		case len(pIn.PartialSigs) == 1:
+			sig = pIn.PartialSigs[0].Signature
+
+		default:
+			return nil, fmt.Errorf("input %d must have exactly "+
+				"one signature", idx)
+		}
+
+		numPlaceholders := 0
+		for elementIdx, element := range witness {
+			if isPlaceholderSig(element) {
+				witness[elementIdx] = sig
+				numPlaceholders++
+			}
+		}
+		if numPlaceholders != 1 {
+			return nil, fmt.Errorf("witness template of input %d "+
+				"must have exactly one placeholder signature",
+				idx)
+		}
+
+		var buf bytes.Buffer
+		if err := psbt.WriteTxWitness(&buf, witness); err != nil {
+			return nil, fmt.Errorf("error serializing witness: %w",
+				err)
+		}
+		pIn.FinalScriptWitness = buf.Bytes()
+	}
+
+	return psbt.Extract(packet)
+}
+
+// readWitness de-serializes a witness in the wire format.
+func readWitness(serialized []byte) (wire.TxWitness, error) {
+	reader := bytes.NewReader(serialized)
+	count, err := wire.ReadVarInt(reader, 0)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(len(serialized)) {
+		return nil, fmt.Errorf("too many witness elements: %d", count)
+	}
+
+	witness := make(wire.TxWitness, count)
+	for idx := range witness {
+		witness[idx], err = wire.ReadVarBytes(
+			reader, 0, txscript.MaxScriptSize, "witness",
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return witness, nil
+}
+
+// FormatPath formats a derivation path in the usual m/a'/b notation.
+func FormatPath(path []uint32) string {
+	formatted := "m"
+	for _, index := range path {
+		if index >= HardenedKeyStart {
+			formatted += fmt.Sprintf("/%d'", index-HardenedKeyStart)
+			continue
+		}
+		formatted += fmt.Sprintf("/%d", index)
+	}
+
+	return formatted
+}
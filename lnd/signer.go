@@ -3,6 +3,7 @@ package lnd
 import (
 	"crypto/sha256"
 	"fmt"
+	"sync"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
@@ -19,15 +20,21 @@ import (
 type Signer struct {
 	input.MockSigner
 
+	// ExtendedKey is either the root key of the wallet or the account key
+	// of a single key family (m/1017'/<coin_type>'/<key_family>'), in
+	// which case only keys of that family can be used. An account key can
+	// also be an extended public key if the signer is only used to derive
+	// public keys.
 	ExtendedKey *hdkeychain.ExtendedKey
 	ChainParams *chaincfg.Params
+
+	muSig2Sessions    map[input.MuSig2SessionID]*muSig2State
+	muSig2SessionsMtx sync.Mutex
 }
 
 func (s *Signer) SignOutputRaw(tx *wire.MsgTx,
 	signDesc *input.SignDescriptor) (input.Signature, error) {
 
-	witnessScript := signDesc.WitnessScript
-
 	// First attempt to fetch the private key which corresponds to the
 	// specified public key.
 	privKey, err := s.FetchPrivKey(&signDesc.KeyDesc)
@@ -35,9 +42,39 @@ func (s *Signer) SignOutputRaw(tx *wire.MsgTx,
 		return nil, err
 	}
 
+	return signOutputRaw(tx, signDesc, privKey)
+}
+
+// signOutputRaw signs the input described by the sign descriptor with the given
+// private key, after applying the tweak of the sign descriptor, if any.
+func signOutputRaw(tx *wire.MsgTx, signDesc *input.SignDescriptor,
+	privKey *btcec.PrivateKey) (input.Signature, error) {
+
+	var (
+		witnessScript = signDesc.WitnessScript
+		err           error
+	)
+
 	privKey = maybeTweakPrivKey(signDesc, privKey)
 
-	sigHashes := txscript.NewTxSigHashes(tx, signDesc.PrevOutputFetcher)
+	// Not all sign descriptors come with a previous output fetcher. For
+	// non-taproot spends we only need the output being spent. Taproot
+	// signatures commit to the previous outputs of all inputs though.
+	prevOutFetcher := signDesc.PrevOutputFetcher
+	if prevOutFetcher == nil {
+		if txscript.IsPayToTaproot(signDesc.Output.PkScript) &&
+			len(tx.TxIn) > 1 {
+
+			return nil, fmt.Errorf("previous output fetcher " +
+				"required for signing taproot inputs of a " +
+				"transaction with multiple inputs")
+		}
+
+		prevOutFetcher = txscript.NewCannedPrevOutputFetcher(
+			signDesc.Output.PkScript, signDesc.Output.Value,
+		)
+	}
+	sigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
 	if txscript.IsPayToTaproot(signDesc.Output.PkScript) {
 		// Are we spending a script path or the key path? The API is
 		// slightly different, so we need to account for that to get the
@@ -114,19 +151,46 @@ func (s *Signer) ComputeInputScript(_ *wire.MsgTx, _ *input.SignDescriptor) (
 func (s *Signer) FetchPrivKey(descriptor *keychain.KeyDescriptor) (
 	*btcec.PrivateKey, error) {
 
-	key, err := DeriveChildren(s.ExtendedKey, []uint32{
-		HardenedKeyStart + uint32(keychain.BIP0043Purpose),
-		HardenedKeyStart + s.ChainParams.HDCoinType,
-		HardenedKeyStart + uint32(descriptor.Family),
-		0,
-		descriptor.Index,
-	})
+	key, err := s.deriveKeyLocator(descriptor.KeyLocator)
 	if err != nil {
 		return nil, err
 	}
 	return key.ECPrivKey()
 }
 
+// DeriveKey returns the public key at the given key locator.
+func (s *Signer) DeriveKey(
+	keyLoc keychain.KeyLocator) (*keychain.KeyDescriptor, error) {
+
+	key, err := s.deriveKeyLocator(keyLoc)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := key.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &keychain.KeyDescriptor{
+		KeyLocator: keyLoc,
+		PubKey:     pubKey,
+	}, nil
+}
+
+// deriveKeyLocator derives the extended key at the lnd derivation path of the
+// given key locator.
+func (s *Signer) deriveKeyLocator(
+	keyLoc keychain.KeyLocator) (*hdkeychain.ExtendedKey, error) {
+
+	return DeriveFromRoot(s.ExtendedKey, []uint32{
+		HardenedKeyStart + uint32(keychain.BIP0043Purpose),
+		HardenedKeyStart + s.ChainParams.HDCoinType,
+		HardenedKeyStart + uint32(keyLoc.Family),
+		0,
+		keyLoc.Index,
+	})
+}
+
 func (s *Signer) AddPartialSignature(packet *psbt.Packet,
 	keyDesc keychain.KeyDescriptor, utxo *wire.TxOut, witnessScript []byte,
 	inputIndex int) error {
@@ -172,8 +236,14 @@ func (s *Signer) AddPartialSignature(packet *psbt.Packet,
 func maybeTweakPrivKey(signDesc *input.SignDescriptor,
 	privKey *btcec.PrivateKey) *btcec.PrivateKey {
 
-	if signDesc.SingleTweak != nil {
+	switch {
+	case signDesc.SingleTweak != nil:
 		return input.TweakPrivKey(privKey, signDesc.SingleTweak)
+
+	case signDesc.DoubleTweak != nil:
+		return input.DeriveRevocationPrivKey(
+			privKey, signDesc.DoubleTweak,
+		)
 	}
 	return privKey
 }
@@ -0,0 +1,121 @@
+package lnd
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/input"
+)
+
+var (
+	// TaprootNUMSHex is the hex encoded version of the NUMS point lnd uses
+	// as the internal key of taproot outputs that must only be spent
+	// through the script path.
+	TaprootNUMSHex = "02dca094751109d0bd055d03565874e8276dd53e926b44e3bd" +
+		"1bb6bf4bc130a279"
+
+	// TaprootNUMSKey is the parsed NUMS point.
+	TaprootNUMSKey = mustParsePubKey(TaprootNUMSHex)
+)
+
+// mustParsePubKey parses a hex encoded public key or panics.
+func mustParsePubKey(pubKeyHex string) *btcec.PublicKey {
+	pubKeyBytes, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		panic(err)
+	}
+	pubKey, err := btcec.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		panic(err)
+	}
+
+	return pubKey
+}
+
+// TaprootToRemoteScript returns the script of the single leaf of the to_remote
+// output of a simple taproot channel:
+//
+//	<to_remote_key> OP_CHECKSIG 1 OP_CHECKSEQUENCEVERIFY OP_DROP
+func TaprootToRemoteScript(toRemoteKey *btcec.PublicKey) ([]byte, error) {
+	builder := txscript.NewScriptBuilder()
+	builder.AddData(schnorr.SerializePubKey(toRemoteKey))
+	builder.AddOp(txscript.OP_CHECKSIG)
+	builder.AddOp(txscript.OP_1)
+	builder.AddOp(txscript.OP_CHECKSEQUENCEVERIFY)
+	builder.AddOp(txscript.OP_DROP)
+
+	return builder.Script()
+}
+
+// TaprootControlBlock returns the serialized control block for spending the
+// given leaf of the tap script tree that consists of the given leaves and is
+// committed to in the output key together with the internal key.
+func TaprootControlBlock(internalKey *btcec.PublicKey,
+	leaves []txscript.TapLeaf, leaf txscript.TapLeaf) ([]byte, error) {
+
+	tree := txscript.AssembleTaprootScriptTree(leaves...)
+	proofIndex, ok := tree.LeafProofIndex[leaf.TapHash()]
+	if !ok {
+		return nil, fmt.Errorf("leaf is not part of the tree")
+	}
+
+	controlBlock := tree.LeafMerkleProofs[proofIndex].ToControlBlock(
+		internalKey,
+	)
+
+	return controlBlock.ToBytes()
+}
+
+// TaprootKeySpendWitness signs the input described by the sign descriptor
+// through the key spend path and returns the witness. The sign method must be
+// one of the key spend methods, a tap tweak is applied by the signer.
+func TaprootKeySpendWitness(signer input.Signer,
+	signDesc *input.SignDescriptor, tx *wire.MsgTx) (wire.TxWitness,
+	error) {
+
+	sig, err := signer.SignOutputRaw(tx, signDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	return wire.TxWitness{taprootSig(sig, signDesc.HashType)}, nil
+}
+
+// TaprootScriptSpendWitness signs the input described by the sign descriptor
+// through the script path of the leaf in its witness script and returns the
+// witness. Additional witness elements the script needs below the signature
+// can be prepended to the witness by the caller.
+func TaprootScriptSpendWitness(signer input.Signer,
+	signDesc *input.SignDescriptor, tx *wire.MsgTx,
+	controlBlock []byte) (wire.TxWitness, error) {
+
+	if signDesc.SignMethod != input.TaprootScriptSpendSignMethod {
+		return nil, fmt.Errorf("invalid sign method %v for script "+
+			"spend", signDesc.SignMethod)
+	}
+
+	sig, err := signer.SignOutputRaw(tx, signDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	return wire.TxWitness{
+		taprootSig(sig, signDesc.HashType), signDesc.WitnessScript,
+		controlBlock,
+	}, nil
+}
+
+// taprootSig serializes a schnorr signature and appends the sighash flag if it
+// isn't the default one.
+func taprootSig(sig input.Signature, hashType txscript.SigHashType) []byte {
+	sigBytes := sig.Serialize()
+	if hashType != txscript.SigHashDefault {
+		sigBytes = append(sigBytes, byte(hashType))
+	}
+
+	return sigBytes
+}
@@ -64,7 +64,7 @@ function build_release() {
     pushd "${dir}"
 
     green " - Building: ${os} ${arch} ${arm}"
-    env CGO_ENABLED=0 GOOS=$os GOARCH=$arch GOARM=$arm go build -v -trimpath -ldflags="${ldflags}" ${PKG}/cmd/chantools
+    env CGO_ENABLED=0 GOOS=$os GOARCH=$arch GOARM=$arm go build -v -trimpath -tags="kvdb_postgres kvdb_sqlite" -ldflags="${ldflags}" ${PKG}/cmd/chantools
     popd
 
     if [[ $os == "windows" ]]; then
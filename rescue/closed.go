@@ -0,0 +1,340 @@
+package rescue
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btclog"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+)
+
+var (
+	// ErrAddrNotFound is returned if none of the keys in the cache
+	// controls the given address.
+	ErrAddrNotFound = errors.New("addr not found")
+)
+
+type cacheEntry struct {
+	privKey *btcec.PrivateKey
+	pubKey  *btcec.PublicKey
+}
+
+// KeyCache holds the first keys of the payment base key family to find the
+// private key of the to_remote output of a channel that was force closed by
+// the remote party.
+type KeyCache struct {
+	entries     []*cacheEntry
+	chainParams *chaincfg.Params
+	log         btclog.Logger
+}
+
+// NewKeyCache derives the first numKeys keys of the payment base key family
+// from the given root key.
+func NewKeyCache(extendedKey *hdkeychain.ExtendedKey, numKeys int,
+	chainParams *chaincfg.Params, log btclog.Logger) (*KeyCache, error) {
+
+	if numKeys <= 0 {
+		return nil, fmt.Errorf("number of keys must be positive")
+	}
+
+	c := &KeyCache{
+		entries:     make([]*cacheEntry, numKeys),
+		chainParams: chainParams,
+		log:         log,
+	}
+	for i := 0; i < numKeys; i++ {
+		key, err := lnd.DeriveFromRoot(extendedKey, []uint32{
+			lnd.HardenedKeyStart + uint32(keychain.BIP0043Purpose),
+			lnd.HardenedKeyStart + chainParams.HDCoinType,
+			lnd.HardenedKeyStart +
+				uint32(keychain.KeyFamilyPaymentBase),
+			0,
+			uint32(i),
+		})
+		if err != nil {
+			return nil, err
+		}
+		privKey, err := key.ECPrivKey()
+		if err != nil {
+			return nil, err
+		}
+		pubKey, err := key.ECPubKey()
+		if err != nil {
+			return nil, err
+		}
+		c.entries[i] = &cacheEntry{
+			privKey: privKey,
+			pubKey:  pubKey,
+		}
+
+		if i > 0 && i%10000 == 0 {
+			log.Infof("Filled cache with %d of %d keys.", i,
+				numKeys)
+		}
+	}
+
+	return c, nil
+}
+
+// RescueChannels tries to find the private key of the to_remote output of all
+// closed channels in the given entries by tweaking the cached keys with each
+// of the possible commit points. The untweaked static_remote_key and anchor
+// outputs are tried as well. The keys found are set as the SweepPrivkey of the
+// closing transaction of the entries and returned as a map of address to
+// private key in the WIF format.
+func (c *KeyCache) RescueChannels(entries []*dataformat.SummaryEntry,
+	possibleCommitPoints []*btcec.PublicKey) (map[string]string, error) {
+
+	// Add a nil commit point to the list of possible commit points to also
+	// try brute forcing a static_remote_key address.
+	possibleCommitPoints = append(possibleCommitPoints, nil)
+
+	resultMap := make(map[string]string)
+
+	// Try naive/lucky guess by trying out all combinations.
+outer:
+	for _, entry := range entries {
+		// Don't try anything with open channels, fully closed channels
+		// or channels where we already have the private key.
+		if entry.ClosingTX == nil ||
+			entry.ClosingTX.AllOutsSpent ||
+			(entry.ClosingTX.OurAddr == "" &&
+				entry.ClosingTX.ToRemoteAddr == "") ||
+			entry.ClosingTX.SweepPrivkey != "" {
+
+			continue
+		}
+
+		// Try with every possible commit point now.
+		for _, commitPoint := range possibleCommitPoints {
+			addr := entry.ClosingTX.OurAddr
+			if addr == "" {
+				addr = entry.ClosingTX.ToRemoteAddr
+			}
+
+			wif, err := c.FindKey(addr, commitPoint)
+			switch {
+			case err == nil:
+				entry.ClosingTX.SweepPrivkey = wif
+				resultMap[addr] = wif
+
+				continue outer
+
+			case errors.Is(err, ErrAddrNotFound):
+
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	return resultMap, nil
+}
+
+// RescueAddress tries to find the private key of the given to_remote address
+// by tweaking the cached keys with the given commit point. The address is also
+// tried as an untweaked static_remote_key or anchor output.
+func (c *KeyCache) RescueAddress(addr btcutil.Address,
+	commitPoint *btcec.PublicKey) (string, error) {
+
+	// Make the check on the decoded address according to the active
+	// network (testnet or mainnet only).
+	if !addr.IsForNet(c.chainParams) {
+		return "", fmt.Errorf("address: %v is not valid for this "+
+			"network: %v", addr, c.chainParams.Name)
+	}
+
+	// Must be a bech32 native SegWit address.
+	switch addr.(type) {
+	case *btcutil.AddressWitnessPubKeyHash:
+		c.log.Infof("Brute forcing private key for tweaked public "+
+			"key hash %x\n", addr.ScriptAddress())
+
+	case *btcutil.AddressWitnessScriptHash:
+		c.log.Infof("Brute forcing private key for anchor to_remote "+
+			"script hash %x\n", addr.ScriptAddress())
+
+	default:
+		return "", fmt.Errorf("address: must be a bech32 P2WPKH or " +
+			"P2WSH address")
+	}
+
+	wif, err := c.FindKey(addr.String(), commitPoint)
+	switch {
+	case err == nil:
+		return wif, nil
+
+	case errors.Is(err, ErrAddrNotFound):
+		// Try again as a static_remote_key.
+
+	default:
+		return "", err
+	}
+
+	// Try again as a static_remote_key address.
+	wif, err = c.FindKey(addr.String(), nil)
+	switch {
+	case err == nil:
+		return wif, nil
+
+	case errors.Is(err, ErrAddrNotFound):
+		return "", fmt.Errorf("did not find private key for address "+
+			"%v", addr)
+
+	default:
+		return "", err
+	}
+}
+
+// FindKey returns the private key in the WIF format that controls the given
+// address. If the commit point is nil, the cached keys are tried untweaked to
+// match static_remote_key and anchor outputs. ErrAddrNotFound is returned if
+// none of the cached keys matches.
+func (c *KeyCache) FindKey(addr string,
+	perCommitPoint *btcec.PublicKey) (string, error) {
+
+	targetPubKeyHash, scriptHash, err := lnd.DecodeAddressHash(
+		addr, c.chainParams,
+	)
+	if err != nil {
+		return "", fmt.Errorf("error parsing addr: %w", err)
+	}
+
+	// A P2WSH address can only be the to_remote output of an anchor
+	// channel. That output isn't tweaked with the commit point, so we only
+	// need to look at it once.
+	if scriptHash {
+		if perCommitPoint != nil {
+			return "", ErrAddrNotFound
+		}
+
+		return c.findAnchorKey(addr, targetPubKeyHash)
+	}
+
+	// If the commit point is nil, we try with plain private keys to match
+	// static_remote_key outputs.
+	if perCommitPoint == nil {
+		for i, cacheEntry := range c.entries {
+			hashedPubKey := btcutil.Hash160(
+				cacheEntry.pubKey.SerializeCompressed(),
+			)
+			equal := subtle.ConstantTimeCompare(
+				targetPubKeyHash, hashedPubKey,
+			)
+			if equal == 1 {
+				wif, err := btcutil.NewWIF(
+					cacheEntry.privKey, c.chainParams, true,
+				)
+				if err != nil {
+					return "", err
+				}
+				c.log.Infof("The private key for addr %s "+
+					"(static_remote_key) found after "+
+					"%d tries: %s", addr, i, wif.String(),
+				)
+				return wif.String(), nil
+			}
+		}
+
+		return "", ErrAddrNotFound
+	}
+
+	// Loop through all cached payment base point keys, tweak each of it
+	// with the per_commit_point and see if the hashed public key
+	// corresponds to the target pubKeyHash of the given address.
+	for i, cacheEntry := range c.entries {
+		basePoint := cacheEntry.pubKey
+		tweakedPubKey := input.TweakPubKey(basePoint, perCommitPoint)
+		tweakBytes := input.SingleTweakBytes(perCommitPoint, basePoint)
+		tweakedPrivKey := input.TweakPrivKey(
+			cacheEntry.privKey, tweakBytes,
+		)
+		hashedPubKey := btcutil.Hash160(
+			tweakedPubKey.SerializeCompressed(),
+		)
+		equal := subtle.ConstantTimeCompare(
+			targetPubKeyHash, hashedPubKey,
+		)
+		if equal == 1 {
+			wif, err := btcutil.NewWIF(
+				tweakedPrivKey, c.chainParams, true,
+			)
+			if err != nil {
+				return "", err
+			}
+			c.log.Infof("The private key for addr %s found after "+
+				"%d tries: %s", addr, i, wif.String(),
+			)
+			return wif.String(), nil
+		}
+	}
+
+	return "", ErrAddrNotFound
+}
+
+// findAnchorKey tries to find the private key for the 1-CSV encumbered
+// to_remote output of an anchor channel with the given script hash.
+func (c *KeyCache) findAnchorKey(addr string,
+	targetScriptHash []byte) (string, error) {
+
+	for i, cacheEntry := range c.entries {
+		_, script, err := lnd.P2AnchorStaticRemote(
+			cacheEntry.pubKey, c.chainParams,
+		)
+		if err != nil {
+			return "", err
+		}
+		scriptHash := sha256.Sum256(script)
+		equal := subtle.ConstantTimeCompare(
+			targetScriptHash, scriptHash[:],
+		)
+		if equal == 1 {
+			wif, err := btcutil.NewWIF(
+				cacheEntry.privKey, c.chainParams, true,
+			)
+			if err != nil {
+				return "", err
+			}
+			c.log.Infof("The private key for addr %s (anchor "+
+				"to_remote) found after %d tries: %s, witness "+
+				"script: %x", addr, i, wif.String(), script,
+			)
+			return wif.String(), nil
+		}
+	}
+
+	return "", ErrAddrNotFound
+}
+
+// ImportCommand returns the bitcoind console command to import the private
+// key for the given address. The to_remote output of anchor channels needs to
+// be imported as a descriptor that describes its 1-CSV witness script.
+func ImportCommand(addr, wif string,
+	chainParams *chaincfg.Params) (string, error) {
+
+	_, scriptHash, err := lnd.DecodeAddressHash(addr, chainParams)
+	if err != nil {
+		return "", err
+	}
+
+	if !scriptHash {
+		return fmt.Sprintf(`importprivkey "%s" "%s" false`, wif, addr),
+			nil
+	}
+
+	desc := btc.DescriptorSumCreate(fmt.Sprintf(
+		"wsh(and_v(v:pk(%s),older(1)))", wif,
+	))
+	return fmt.Sprintf(`importdescriptors '[{"desc":"%s",`+
+		`"timestamp":"now","label":"%s"}]'`, desc, addr), nil
+}
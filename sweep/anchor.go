@@ -0,0 +1,242 @@
+package sweep
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btclog"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+)
+
+// AnchorFeeInput is a P2WKH output of the on-chain wallet that pays the fees
+// of an anchor CPFP transaction.
+type AnchorFeeInput struct {
+	Outpoint wire.OutPoint
+	Utxo     *wire.TxOut
+	PrivKey  *btcec.PrivateKey
+}
+
+// check makes sure the fee input is a P2WKH output of its private key. Only
+// those are supported, so we can sign them directly with the private key.
+func (f *AnchorFeeInput) check() error {
+	feeInputScript, err := input.WitnessPubKeyHash(
+		f.PrivKey.PubKey().SerializeCompressed(),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating fee input script: %w", err)
+	}
+	if !bytes.Equal(f.Utxo.PkScript, feeInputScript) {
+		return fmt.Errorf("fee input %v is not a P2WKH output of the "+
+			"given key", f.Outpoint)
+	}
+
+	return nil
+}
+
+// AnchorCPFP creates and signs a child transaction that spends our anchor
+// output of the given commitment transaction together with the fee input. The
+// fee of the child is chosen so the package of the commitment transaction
+// (which pays commitFee on its own) and the child pays the given fee rate. The
+// change is sent back to the script of the fee input.
+func AnchorCPFP(signer input.Signer, commitTx *wire.MsgTx, commitFee int64,
+	fundingKey *keychain.KeyDescriptor, feeInput *AnchorFeeInput,
+	feeRate uint16, log btclog.Logger) (*wire.MsgTx, error) {
+
+	// Our anchor output is locked to our funding key.
+	anchorScript, err := input.CommitScriptAnchor(fundingKey.PubKey)
+	if err != nil {
+		return nil, fmt.Errorf("error creating anchor script: %w", err)
+	}
+	anchorPkScript, err := input.WitnessScriptHash(anchorScript)
+	if err != nil {
+		return nil, fmt.Errorf("error creating anchor pk script: %w",
+			err)
+	}
+	anchorIndex := -1
+	for idx, txOut := range commitTx.TxOut {
+		if bytes.Equal(txOut.PkScript, anchorPkScript) {
+			anchorIndex = idx
+		}
+	}
+	if anchorIndex < 0 {
+		return nil, fmt.Errorf("commitment TX %v has no anchor output "+
+			"of ours", commitTx.TxHash())
+	}
+	anchorOut := commitTx.TxOut[anchorIndex]
+
+	if err := feeInput.check(); err != nil {
+		return nil, err
+	}
+
+	var estimator input.TxWeightEstimator
+	estimator.AddWitnessInput(input.AnchorWitnessSize)
+	estimator.AddP2WKHInput()
+	estimator.AddP2WKHOutput()
+	childWeight := int64(estimator.Weight())
+	commitWeight := int64(
+		commitTx.SerializeSizeStripped()*3 + commitTx.SerializeSize(),
+	)
+
+	// The child needs to pay for the weight of both transactions, minus
+	// what the commitment transaction already pays itself. But it needs
+	// to pay at least the fee rate for its own weight.
+	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
+	childFee := int64(feeRateKWeight.FeeForWeight(
+		commitWeight+childWeight,
+	)) - commitFee
+	minChildFee := int64(feeRateKWeight.FeeForWeight(childWeight))
+	if childFee < minChildFee {
+		childFee = minChildFee
+	}
+
+	totalValue := anchorOut.Value + feeInput.Utxo.Value
+	if totalValue-childFee < DustLimit {
+		return nil, fmt.Errorf("fee input of %d sats is too small to "+
+			"pay a CPFP fee of %d sats", feeInput.Utxo.Value,
+			childFee)
+	}
+
+	log.Infof("CPFP fee %d sats for package of %d WU (commitment TX pays "+
+		"%d sats)", childFee, commitWeight+childWeight, commitFee)
+
+	childTx := wire.NewMsgTx(2)
+	childTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{
+			Hash:  commitTx.TxHash(),
+			Index: uint32(anchorIndex),
+		},
+		Sequence: wire.MaxTxInSequenceNum,
+	})
+	childTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: feeInput.Outpoint,
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	childTx.AddTxOut(&wire.TxOut{
+		Value:    totalValue - childFee,
+		PkScript: feeInput.Utxo.PkScript,
+	})
+
+	// Sign the transaction now.
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	prevOutFetcher.AddPrevOut(childTx.TxIn[0].PreviousOutPoint, anchorOut)
+	prevOutFetcher.AddPrevOut(feeInput.Outpoint, feeInput.Utxo)
+	sigHashes := txscript.NewTxSigHashes(childTx, prevOutFetcher)
+
+	anchorSignDesc := &input.SignDescriptor{
+		KeyDesc:           *fundingKey,
+		WitnessScript:     anchorScript,
+		Output:            anchorOut,
+		HashType:          txscript.SigHashAll,
+		SigHashes:         sigHashes,
+		PrevOutputFetcher: prevOutFetcher,
+		InputIndex:        0,
+	}
+	childTx.TxIn[0].Witness, err = input.CommitSpendAnchor(
+		signer, anchorSignDesc, childTx,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error signing anchor input: %w", err)
+	}
+
+	childTx.TxIn[1].Witness, err = txscript.WitnessSignature(
+		childTx, sigHashes, 1, feeInput.Utxo.Value,
+		feeInput.Utxo.PkScript, txscript.SigHashAll, feeInput.PrivKey,
+		true,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error signing fee input: %w", err)
+	}
+
+	return childTx, nil
+}
+
+// AnchorHTLCTx adds the fee input and a change output to the given
+// HTLC-timeout or HTLC-success transaction of an anchor channel and signs it.
+// Those transactions don't pay any fees on their own. The remote peer signed
+// them with SIGHASH_SINGLE|ANYONECANPAY, so inputs and outputs can be added
+// as long as the HTLC input and the second-level output stay at index zero.
+// The preimage must be set for HTLC-success transactions and be nil for
+// HTLC-timeout transactions. The change is sent back to the script of the fee
+// input and is the second output of the returned transaction.
+func AnchorHTLCTx(signer input.Signer, htlcTx *wire.MsgTx,
+	signDetails *input.SignDetails, preimage []byte,
+	feeInput *AnchorFeeInput, feeRate uint16,
+	log btclog.Logger) (*wire.MsgTx, error) {
+
+	if len(htlcTx.TxIn) != 1 || len(htlcTx.TxOut) != 1 {
+		return nil, fmt.Errorf("HTLC TX %v must have exactly one "+
+			"input and output", htlcTx.TxHash())
+	}
+	if err := feeInput.check(); err != nil {
+		return nil, err
+	}
+
+	weight := int64(input.HtlcTimeoutWeightConfirmed)
+	if preimage != nil {
+		weight = input.HtlcSuccessWeightConfirmed
+	}
+	weight += input.P2WKHWitnessSize +
+		4*(input.InputSize+input.P2WKHOutputSize)
+
+	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
+	fee := int64(feeRateKWeight.FeeForWeight(weight))
+	if feeInput.Utxo.Value-fee < DustLimit {
+		return nil, fmt.Errorf("fee input of %d sats is too small to "+
+			"pay a fee of %d sats", feeInput.Utxo.Value, fee)
+	}
+
+	log.Infof("Fee %d sats for HTLC TX of %d WU", fee, weight)
+
+	tx := htlcTx.Copy()
+	tx.TxIn[0].Witness = nil
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: feeInput.Outpoint,
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	tx.AddTxOut(&wire.TxOut{
+		Value:    feeInput.Utxo.Value - fee,
+		PkScript: feeInput.Utxo.PkScript,
+	})
+
+	// Our own signature of the HTLC input commits to all inputs and
+	// outputs, so we need to sign it again.
+	signDesc := signDetails.SignDesc
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	prevOutFetcher.AddPrevOut(tx.TxIn[0].PreviousOutPoint, signDesc.Output)
+	prevOutFetcher.AddPrevOut(feeInput.Outpoint, feeInput.Utxo)
+	sigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+	signDesc.SigHashes = sigHashes
+	signDesc.PrevOutputFetcher = prevOutFetcher
+	signDesc.InputIndex = 0
+
+	var err error
+	if preimage != nil {
+		tx.TxIn[0].Witness, err = input.ReceiverHtlcSpendRedeem(
+			signDetails.PeerSig, signDetails.SigHashType, preimage,
+			signer, &signDesc, tx,
+		)
+	} else {
+		tx.TxIn[0].Witness, err = input.SenderHtlcSpendTimeout(
+			signDetails.PeerSig, signDetails.SigHashType, signer,
+			&signDesc, tx,
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error signing HTLC input: %w", err)
+	}
+
+	tx.TxIn[1].Witness, err = txscript.WitnessSignature(
+		tx, sigHashes, 1, feeInput.Utxo.Value, feeInput.Utxo.PkScript,
+		txscript.SigHashAll, feeInput.PrivKey, true,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error signing fee input: %w", err)
+	}
+
+	return tx, nil
+}
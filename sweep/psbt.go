@@ -0,0 +1,50 @@
+package sweep
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/guggero/chantools/lnd"
+)
+
+// CombinePsbt extracts the final transaction from a sweep PSBT that was
+// signed offline or by a hardware wallet and makes sure all its inputs are
+// signed correctly.
+func CombinePsbt(packet *psbt.Packet) (*wire.MsgTx, error) {
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for idx, txIn := range packet.UnsignedTx.TxIn {
+		utxo := packet.Inputs[idx].WitnessUtxo
+		if utxo == nil {
+			return nil, fmt.Errorf("input %d has no witness UTXO",
+				idx)
+		}
+		prevOutFetcher.AddPrevOut(txIn.PreviousOutPoint, utxo)
+	}
+
+	sweepTx, err := lnd.FinalizePsbt(packet)
+	if err != nil {
+		return nil, fmt.Errorf("error finalizing PSBT: %w", err)
+	}
+
+	sigHashes := txscript.NewTxSigHashes(sweepTx, prevOutFetcher)
+	for idx, txIn := range sweepTx.TxIn {
+		prevOut := prevOutFetcher.FetchPrevOutput(txIn.PreviousOutPoint)
+		vm, err := txscript.NewEngine(
+			prevOut.PkScript, sweepTx, idx,
+			txscript.StandardVerifyFlags, nil, sigHashes,
+			prevOut.Value, prevOutFetcher,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error creating script engine: "+
+				"%w", err)
+		}
+		if err := vm.Execute(); err != nil {
+			return nil, fmt.Errorf("invalid signature for input "+
+				"%d: %w", idx, err)
+		}
+	}
+
+	return sweepTx, nil
+}
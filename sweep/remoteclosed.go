@@ -0,0 +1,303 @@
+package sweep
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btclog"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+)
+
+// UnspentAPI is the chain backend needed to find the outputs of channels that
+// were force closed by the remote party.
+type UnspentAPI interface {
+	// Unspent returns all unspent outputs of the given address.
+	Unspent(addr string) ([]*btc.Vout, error)
+}
+
+// RemoteClosedTarget is an address derived from a payment base point key that
+// has unspent outputs. Those are the to_remote outputs of channels that were
+// force closed by the remote party.
+type RemoteClosedTarget struct {
+	// Addr is the address that has unspent outputs.
+	Addr btcutil.Address
+
+	// Path is the derivation path of the key of the address.
+	Path string
+
+	// KeyDesc is the key descriptor of the key of the address.
+	KeyDesc *keychain.KeyDescriptor
+
+	// Vouts are the unspent outputs of the address.
+	Vouts []*btc.Vout
+
+	// Script is the witness script of a P2WSH or the leaf script of a
+	// P2TR address. It is nil for a P2WKH address.
+	Script []byte
+
+	// ControlBlock is the serialized control block of a P2TR address.
+	ControlBlock []byte
+}
+
+// FindRemoteClosed looks up the unspent outputs of all to_remote addresses
+// (P2WKH, anchor P2WSH and simple taproot P2TR) of the first recoveryWindow
+// keys of the payment base key family.
+func FindRemoteClosed(signer Signer, api UnspentAPI, recoveryWindow uint32,
+	chainParams *chaincfg.Params,
+	log btclog.Logger) ([]*RemoteClosedTarget, error) {
+
+	var targets []*RemoteClosedTarget
+	for index := uint32(0); index < recoveryWindow; index++ {
+		path := fmt.Sprintf("m/1017'/%d'/%d'/0/%d",
+			chainParams.HDCoinType, keychain.KeyFamilyPaymentBase,
+			index)
+		keyDesc, err := signer.DeriveKey(keychain.KeyLocator{
+			Family: keychain.KeyFamilyPaymentBase,
+			Index:  index,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not derive key: %w", err)
+		}
+
+		foundTargets, err := queryAddressBalances(
+			path, keyDesc, api, chainParams, log,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not query API for "+
+				"addresses with funds: %w", err)
+		}
+		targets = append(targets, foundTargets...)
+	}
+
+	return targets, nil
+}
+
+// RemoteClosed creates and signs a transaction that sweeps all unspent
+// outputs of the given targets to the given pkScript.
+func RemoteClosed(signer input.Signer, targets []*RemoteClosedTarget,
+	sweepScript []byte, feeRate uint16, chainParams *chaincfg.Params,
+	log btclog.Logger) (*wire.MsgTx, error) {
+
+	// Create estimator and transaction template.
+	var (
+		estimator        input.TxWeightEstimator
+		signDescs        []*input.SignDescriptor
+		controlBlocks    [][]byte
+		sweepTx          = wire.NewMsgTx(2)
+		totalOutputValue = uint64(0)
+		prevOutFetcher   = txscript.NewMultiPrevOutFetcher(nil)
+	)
+
+	// Add all found target outputs.
+	for _, target := range targets {
+		for _, vout := range target.Vouts {
+			totalOutputValue += vout.Value
+
+			txHash, err := chainhash.NewHashFromStr(
+				vout.Outspend.Txid,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing tx "+
+					"hash: %w", err)
+			}
+			pkScript, err := lnd.GetWitnessAddrScript(
+				target.Addr, chainParams,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("error getting pk "+
+					"script: %w", err)
+			}
+
+			signDesc := &input.SignDescriptor{
+				KeyDesc:       *target.KeyDesc,
+				WitnessScript: target.Script,
+				Output: &wire.TxOut{
+					PkScript: pkScript,
+					Value:    int64(vout.Value),
+				},
+				HashType:          txscript.SigHashAll,
+				PrevOutputFetcher: prevOutFetcher,
+			}
+
+			sequence := wire.MaxTxInSequenceNum
+			switch target.Addr.(type) {
+			case *btcutil.AddressWitnessPubKeyHash:
+				estimator.AddP2WKHInput()
+
+			case *btcutil.AddressWitnessScriptHash:
+				estimator.AddWitnessInput(
+					input.ToRemoteConfirmedWitnessSize,
+				)
+				sequence = 1
+
+			case *btcutil.AddressTaproot:
+				estimator.AddWitnessInput(
+					TaprootScriptSpendWitnessSize(
+						target.Script,
+						target.ControlBlock,
+					),
+				)
+				sequence = 1
+				signDesc.HashType = txscript.SigHashDefault
+				signDesc.SignMethod =
+					input.TaprootScriptSpendSignMethod
+			}
+
+			outpoint := wire.OutPoint{
+				Hash:  *txHash,
+				Index: uint32(vout.Outspend.Vin),
+			}
+			sweepTx.TxIn = append(sweepTx.TxIn, &wire.TxIn{
+				PreviousOutPoint: outpoint,
+				Sequence:         sequence,
+			})
+			prevOutFetcher.AddPrevOut(outpoint, signDesc.Output)
+
+			signDescs = append(signDescs, signDesc)
+			controlBlocks = append(
+				controlBlocks, target.ControlBlock,
+			)
+		}
+	}
+
+	if len(targets) == 0 || totalOutputValue < DustLimit {
+		return nil, fmt.Errorf("found %d sweep targets with total "+
+			"value of %d satoshis which is below the dust limit "+
+			"of %d", len(targets), totalOutputValue, DustLimit)
+	}
+
+	// Add our sweep destination output.
+	estimator.AddP2WKHOutput()
+
+	// Calculate the fee based on the given fee rate and our weight
+	// estimation.
+	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
+	totalFee := feeRateKWeight.FeeForWeight(int64(estimator.Weight()))
+
+	log.Infof("Fee %d sats of %d total amount (estimated weight %d)",
+		totalFee, totalOutputValue, estimator.Weight())
+
+	sweepTx.TxOut = []*wire.TxOut{{
+		Value:    int64(totalOutputValue) - int64(totalFee),
+		PkScript: sweepScript,
+	}}
+
+	// Sign the transaction now.
+	sigHashes := txscript.NewTxSigHashes(sweepTx, prevOutFetcher)
+	for idx, desc := range signDescs {
+		desc.SigHashes = sigHashes
+		desc.InputIndex = idx
+
+		switch {
+		case txscript.IsPayToTaproot(desc.Output.PkScript):
+			witness, err := lnd.TaprootScriptSpendWitness(
+				signer, desc, sweepTx, controlBlocks[idx],
+			)
+			if err != nil {
+				return nil, err
+			}
+			sweepTx.TxIn[idx].Witness = witness
+
+		case len(desc.WitnessScript) > 0:
+			witness, err := input.CommitSpendToRemoteConfirmed(
+				signer, desc, sweepTx,
+			)
+			if err != nil {
+				return nil, err
+			}
+			sweepTx.TxIn[idx].Witness = witness
+
+		default:
+			// The txscript library expects the witness script of a
+			// P2WKH descriptor to be set to the pkScript of the
+			// output...
+			desc.WitnessScript = desc.Output.PkScript
+			witness, err := input.CommitSpendNoDelay(
+				signer, desc, sweepTx, true,
+			)
+			if err != nil {
+				return nil, err
+			}
+			sweepTx.TxIn[idx].Witness = witness
+		}
+	}
+
+	return sweepTx, nil
+}
+
+func queryAddressBalances(path string, keyDesc *keychain.KeyDescriptor,
+	api UnspentAPI, chainParams *chaincfg.Params,
+	log btclog.Logger) ([]*RemoteClosedTarget, error) {
+
+	var targets []*RemoteClosedTarget
+	queryAddr := func(address btcutil.Address, script,
+		controlBlock []byte) error {
+
+		unspent, err := api.Unspent(address.EncodeAddress())
+		if err != nil {
+			return fmt.Errorf("could not query unspent: %w", err)
+		}
+
+		if len(unspent) > 0 {
+			log.Infof("Found %d unspent outputs for address %v",
+				len(unspent), address.EncodeAddress())
+			targets = append(targets, &RemoteClosedTarget{
+				Addr:         address,
+				Path:         path,
+				KeyDesc:      keyDesc,
+				Vouts:        unspent,
+				Script:       script,
+				ControlBlock: controlBlock,
+			})
+		}
+
+		return nil
+	}
+
+	pubKey := keyDesc.PubKey
+	p2wkh, err := lnd.P2WKHAddr(pubKey, chainParams)
+	if err != nil {
+		return nil, err
+	}
+	if err := queryAddr(p2wkh, nil, nil); err != nil {
+		return nil, err
+	}
+
+	p2anchor, script, err := lnd.P2AnchorStaticRemote(pubKey, chainParams)
+	if err != nil {
+		return nil, err
+	}
+	if err := queryAddr(p2anchor, script, nil); err != nil {
+		return nil, err
+	}
+
+	p2tr, script, controlBlock, err := lnd.P2TaprootStaticRemote(
+		pubKey, chainParams,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := queryAddr(p2tr, script, controlBlock); err != nil {
+		return nil, err
+	}
+
+	return targets, nil
+}
+
+// TaprootScriptSpendWitnessSize returns the size of the witness that spends a
+// taproot output through the given script leaf with a single signature.
+func TaprootScriptSpendWitnessSize(script, controlBlock []byte) int {
+	// Number of witness elements, the signature, the script and the
+	// control block, each with their length prefix.
+	return 1 + 1 + schnorr.SignatureSize + 1 + len(script) + 1 +
+		len(controlBlock)
+}
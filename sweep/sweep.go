@@ -0,0 +1,34 @@
+package sweep
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+)
+
+const (
+	// DustLimit is the minimum total value in satoshis a sweep needs to
+	// have to be worth creating a transaction for.
+	DustLimit = 600
+)
+
+// Signer is the interface a signer needs to implement to create sweep
+// transactions. It is implemented by the seed based lnd.Signer, the
+// lnd.RemoteSigner and the lnd.PsbtSigner.
+type Signer interface {
+	input.Signer
+
+	// DeriveKey returns the public key at the given key locator.
+	DeriveKey(keyLoc keychain.KeyLocator) (*keychain.KeyDescriptor, error)
+}
+
+func pubKeyFromHex(pubKeyHex string) (*btcec.PublicKey, error) {
+	pointBytes, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		return nil, fmt.Errorf("error hex decoding pub key: %w", err)
+	}
+	return btcec.ParsePubKey(pointBytes)
+}
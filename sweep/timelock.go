@@ -0,0 +1,282 @@
+package sweep
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btclog"
+	"github.com/guggero/chantools/dataformat"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/keychain"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+)
+
+// TimeLockTarget is the time locked to_local output of a commitment
+// transaction that we force closed ourselves.
+type TimeLockTarget struct {
+	// ChannelPoint is the funding outpoint of the channel the output
+	// belongs to.
+	ChannelPoint string
+
+	// TxID and Index are the outpoint of the to_local output.
+	TxID  chainhash.Hash
+	Index uint32
+
+	// LockScript is the pkScript of the to_local output.
+	LockScript []byte
+
+	// Value is the value of the to_local output in satoshis.
+	Value int64
+
+	// CommitPoint is the per commitment point of the commitment.
+	CommitPoint *btcec.PublicKey
+
+	// RevocationBasePoint is the revocation base point of the remote
+	// party.
+	RevocationBasePoint *btcec.PublicKey
+
+	// DelayBasePointDesc is the key descriptor of our delay base point.
+	DelayBasePointDesc *keychain.KeyDescriptor
+}
+
+// SkippedChannel is a channel that was not swept.
+type SkippedChannel struct {
+	// ChannelPoint is the funding outpoint of the channel.
+	ChannelPoint string
+
+	// Reason describes why the channel was skipped.
+	Reason string
+}
+
+// TimeLockTargets extracts the sweepable to_local outputs from the given
+// channel summary entries. Entries that can't be swept are skipped and
+// returned separately.
+func TimeLockTargets(entries []*dataformat.SummaryEntry,
+	log btclog.Logger) ([]*TimeLockTarget, []*SkippedChannel, error) {
+
+	var (
+		targets = make([]*TimeLockTarget, 0, len(entries))
+		skipped []*SkippedChannel
+	)
+	for _, entry := range entries {
+		// Skip entries that can't be swept.
+		allSpent := entry.ClosingTX != nil &&
+			entry.ClosingTX.AllOutsSpent
+		if entry.ForceClose == nil || allSpent ||
+			entry.LocalBalance == 0 {
+
+			log.Infof("Not sweeping %s, info missing or all spent",
+				entry.ChannelPoint)
+			skipped = append(skipped, &SkippedChannel{
+				ChannelPoint: entry.ChannelPoint,
+				Reason:       "info missing or all spent",
+			})
+
+			continue
+		}
+
+		fc := entry.ForceClose
+
+		// Find index of sweepable output of commitment TX.
+		txindex := -1
+		if len(fc.Outs) == 1 {
+			txindex = 0
+			if fc.Outs[0].Value != entry.LocalBalance {
+				log.Errorf("Potential value mismatch! %d vs "+
+					"%d (%s)",
+					fc.Outs[0].Value, entry.LocalBalance,
+					entry.ChannelPoint)
+			}
+		} else {
+			for idx, out := range fc.Outs {
+				if out.Value == entry.LocalBalance {
+					txindex = idx
+				}
+			}
+		}
+		if txindex == -1 {
+			log.Errorf("Could not find sweep output for chan %s",
+				entry.ChannelPoint)
+			skipped = append(skipped, &SkippedChannel{
+				ChannelPoint: entry.ChannelPoint,
+				Reason:       "sweep output not found",
+			})
+
+			continue
+		}
+
+		// Prepare sweep script parameters.
+		commitPoint, err := pubKeyFromHex(fc.CommitPoint)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing commit "+
+				"point: %w", err)
+		}
+		revBase, err := pubKeyFromHex(fc.RevocationBasePoint.PubKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing "+
+				"revocation base point: %w", err)
+		}
+		delayDesc, err := fc.DelayBasePoint.Desc()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing delay base "+
+				"point: %w", err)
+		}
+
+		lockScript, err := hex.DecodeString(fc.Outs[txindex].Script)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing target "+
+				"script: %w", err)
+		}
+
+		// Create the transaction input.
+		txHash, err := chainhash.NewHashFromStr(fc.TXID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing tx hash: "+
+				"%w", err)
+		}
+
+		targets = append(targets, &TimeLockTarget{
+			ChannelPoint:        entry.ChannelPoint,
+			TxID:                *txHash,
+			Index:               uint32(txindex),
+			LockScript:          lockScript,
+			Value:               int64(fc.Outs[txindex].Value),
+			CommitPoint:         commitPoint,
+			RevocationBasePoint: revBase,
+			DelayBasePointDesc:  delayDesc,
+		})
+	}
+
+	return targets, skipped, nil
+}
+
+// TimeLock creates and signs a transaction that sweeps all the given time
+// locked to_local outputs to the given pkScript. Because the CSV delay of a
+// channel isn't always known, it is brute forced up to the given maximum.
+// Targets for which no matching script is found are skipped.
+func TimeLock(signer input.Signer, targets []*TimeLockTarget,
+	sweepScript []byte, maxCsvTimeout, feeRate uint16,
+	log btclog.Logger) (*wire.MsgTx, error) {
+
+	sweepTx := wire.NewMsgTx(2)
+	totalOutputValue := int64(0)
+	signDescs := make([]*input.SignDescriptor, 0)
+	var estimator input.TxWeightEstimator
+
+	for _, target := range targets {
+		// We can't rely on the CSV delay of the channel DB to be
+		// correct. But it doesn't cost us a lot to just brute force it.
+		csvTimeout, script, scriptHash, err := BruteForceDelay(
+			input.TweakPubKey(
+				target.DelayBasePointDesc.PubKey,
+				target.CommitPoint,
+			), input.DeriveRevocationPubkey(
+				target.RevocationBasePoint,
+				target.CommitPoint,
+			), target.LockScript, maxCsvTimeout,
+		)
+		if err != nil {
+			log.Errorf("Could not create matching script for %s "+
+				"or csv too high: %v", target.ChannelPoint, err)
+			continue
+		}
+
+		// Create the transaction input.
+		sweepTx.TxIn = append(sweepTx.TxIn, &wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{
+				Hash:  target.TxID,
+				Index: target.Index,
+			},
+			Sequence: input.LockTimeToSequence(
+				false, uint32(csvTimeout),
+			),
+		})
+
+		// Create the sign descriptor for the input.
+		signDesc := &input.SignDescriptor{
+			KeyDesc: *target.DelayBasePointDesc,
+			SingleTweak: input.SingleTweakBytes(
+				target.CommitPoint,
+				target.DelayBasePointDesc.PubKey,
+			),
+			WitnessScript: script,
+			Output: &wire.TxOut{
+				PkScript: scriptHash,
+				Value:    target.Value,
+			},
+			HashType: txscript.SigHashAll,
+		}
+		totalOutputValue += target.Value
+		signDescs = append(signDescs, signDesc)
+
+		// Account for the input weight.
+		estimator.AddWitnessInput(input.ToLocalTimeoutWitnessSize)
+	}
+
+	// Add our sweep destination output.
+	estimator.AddP2WKHOutput()
+
+	// Calculate the fee based on the given fee rate and our weight
+	// estimation.
+	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
+	totalFee := feeRateKWeight.FeeForWeight(int64(estimator.Weight()))
+
+	log.Infof("Fee %d sats of %d total amount (estimated weight %d)",
+		totalFee, totalOutputValue, estimator.Weight())
+
+	sweepTx.TxOut = []*wire.TxOut{{
+		Value:    totalOutputValue - int64(totalFee),
+		PkScript: sweepScript,
+	}}
+
+	// Sign the transaction now.
+	sigHashes := input.NewTxSigHashesV0Only(sweepTx)
+	for idx, desc := range signDescs {
+		desc.SigHashes = sigHashes
+		desc.InputIndex = idx
+		witness, err := input.CommitSpendTimeout(signer, desc, sweepTx)
+		if err != nil {
+			return nil, err
+		}
+		sweepTx.TxIn[idx].Witness = witness
+	}
+
+	return sweepTx, nil
+}
+
+// BruteForceDelay finds the CSV delay of a to_local output by trying all
+// delays up to the given maximum until the resulting script matches the
+// target pkScript. It returns the delay, the witness script and the pkScript.
+func BruteForceDelay(delayPubkey, revocationPubkey *btcec.PublicKey,
+	targetScript []byte, maxCsvTimeout uint16) (int32, []byte, []byte,
+	error) {
+
+	if len(targetScript) != 34 {
+		return 0, nil, nil, fmt.Errorf("invalid target script: %s",
+			targetScript)
+	}
+	for i := uint16(0); i <= maxCsvTimeout; i++ {
+		s, err := input.CommitScriptToSelf(
+			uint32(i), delayPubkey, revocationPubkey,
+		)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("error creating "+
+				"script: %w", err)
+		}
+		sh, err := input.WitnessScriptHash(s)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("error hashing script: "+
+				"%w", err)
+		}
+		if bytes.Equal(targetScript[0:8], sh[0:8]) {
+			return int32(i), s, sh, nil
+		}
+	}
+	return 0, nil, nil, fmt.Errorf("csv timeout not found for target "+
+		"script %s", targetScript)
+}
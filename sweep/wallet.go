@@ -0,0 +1,443 @@
+package sweep
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btclog"
+	"github.com/guggero/chantools/btc"
+	"github.com/guggero/chantools/lnd"
+	"github.com/lightningnetwork/lnd/input"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
+)
+
+// WalletAPI is the chain backend needed to discover the addresses of the lnd
+// on-chain wallet and their unspent outputs.
+type WalletAPI interface {
+	// AddressUsed returns true if the given address has any confirmed or
+	// unconfirmed transactions.
+	AddressUsed(addr string) (bool, error)
+
+	// UTXOs returns the outputs of the given address that aren't spent
+	// yet.
+	UTXOs(addr string) ([]*btc.UTXO, error)
+
+	// RawTransaction returns the full transaction with the given ID. It is
+	// needed for spending legacy outputs.
+	RawTransaction(txid string) (*wire.MsgTx, error)
+}
+
+// WalletAddrType is the type of address of a branch of an lnd wallet account.
+type WalletAddrType uint8
+
+const (
+	// WalletAddrNP2WKH is a nested (P2SH) pay to witness key hash address.
+	WalletAddrNP2WKH WalletAddrType = iota
+
+	// WalletAddrP2WKH is a native SegWit v0 pay to witness key hash
+	// address.
+	WalletAddrP2WKH
+
+	// WalletAddrP2TR is a BIP86 pay to taproot address.
+	WalletAddrP2TR
+
+	// WalletAddrP2PKH is a legacy pay to public key hash address.
+	WalletAddrP2PKH
+)
+
+// String returns the name of the address type.
+func (t WalletAddrType) String() string {
+	switch t {
+	case WalletAddrNP2WKH:
+		return "np2wkh"
+
+	case WalletAddrP2WKH:
+		return "p2wkh"
+
+	case WalletAddrP2TR:
+		return "p2tr"
+
+	case WalletAddrP2PKH:
+		return "p2pkh"
+
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+// WalletAccount is one of the default accounts of the lnd on-chain wallet.
+type WalletAccount struct {
+	// Purpose is the BIP43 purpose of the account.
+	Purpose uint32
+
+	// ExternalTypes are the address types of the external (receive)
+	// branch.
+	ExternalTypes []WalletAddrType
+
+	// InternalTypes are the address types of the internal (change)
+	// branch.
+	InternalTypes []WalletAddrType
+}
+
+// WalletAccounts are the accounts of the lnd on-chain wallet. The m/49'
+// account uses nested SegWit addresses for receiving and native SegWit
+// addresses for change, but old versions of lnd also used nested SegWit
+// addresses for change. The m/44' account with legacy addresses was only used
+// by old versions of lnd.
+var WalletAccounts = []*WalletAccount{{
+	Purpose:       44,
+	ExternalTypes: []WalletAddrType{WalletAddrP2PKH},
+	InternalTypes: []WalletAddrType{WalletAddrP2PKH},
+}, {
+	Purpose:       49,
+	ExternalTypes: []WalletAddrType{WalletAddrNP2WKH},
+	InternalTypes: []WalletAddrType{WalletAddrP2WKH, WalletAddrNP2WKH},
+}, {
+	Purpose:       84,
+	ExternalTypes: []WalletAddrType{WalletAddrP2WKH},
+	InternalTypes: []WalletAddrType{WalletAddrP2WKH},
+}, {
+	Purpose:       86,
+	ExternalTypes: []WalletAddrType{WalletAddrP2TR},
+	InternalTypes: []WalletAddrType{WalletAddrP2TR},
+}}
+
+// WalletUTXO is an unspent output of the lnd on-chain wallet.
+type WalletUTXO struct {
+	// Addr is the wallet address the output pays to.
+	Addr btcutil.Address
+
+	// Type is the type of the address.
+	Type WalletAddrType
+
+	// Path is the full derivation path of the key of the address.
+	Path []uint32
+
+	// PubKey is the public key of the address.
+	PubKey *btcec.PublicKey
+
+	// Outpoint is the outpoint of the output.
+	Outpoint wire.OutPoint
+
+	// Value is the value of the output in satoshis.
+	Value int64
+
+	// Confirmed is true if the output is confirmed.
+	Confirmed bool
+
+	// PrevTx is the full transaction that created the output. It is only
+	// set for legacy outputs.
+	PrevTx *wire.MsgTx
+}
+
+// FindWalletUTXOs derives the addresses of both branches of all lnd wallet
+// accounts and looks up their unspent outputs. The discovery of each branch
+// stops after gapLimit consecutive indexes without any transactions to any of
+// the address types of the branch.
+func FindWalletUTXOs(extendedKey *hdkeychain.ExtendedKey, api WalletAPI,
+	gapLimit uint32, chainParams *chaincfg.Params,
+	log btclog.Logger) ([]*WalletUTXO, error) {
+
+	var utxos []*WalletUTXO
+	for _, account := range WalletAccounts {
+		accountPath := []uint32{
+			lnd.HardenedKey(account.Purpose),
+			lnd.HardenedKey(chainParams.HDCoinType),
+			lnd.HardenedKey(0),
+		}
+		accountKey, err := lnd.DeriveFromRoot(extendedKey, accountPath)
+		if err != nil {
+			return nil, fmt.Errorf("error deriving account key: %w",
+				err)
+		}
+
+		branchTypes := [][]WalletAddrType{
+			account.ExternalTypes, account.InternalTypes,
+		}
+		for branch, addrTypes := range branchTypes {
+			branchPath := []uint32{
+				accountPath[0], accountPath[1], accountPath[2],
+				uint32(branch),
+			}
+			branchKey, err := accountKey.Derive(uint32(branch))
+			if err != nil {
+				return nil, fmt.Errorf("error deriving branch "+
+					"key: %w", err)
+			}
+
+			branchUTXOs, err := findBranchUTXOs(
+				branchKey, branchPath, addrTypes, api,
+				gapLimit, chainParams, log,
+			)
+			if err != nil {
+				return nil, err
+			}
+			utxos = append(utxos, branchUTXOs...)
+		}
+	}
+
+	return utxos, nil
+}
+
+// findBranchUTXOs looks up the unspent outputs of the addresses of a single
+// branch of a wallet account until gapLimit consecutive unused indexes are
+// found.
+func findBranchUTXOs(branchKey *hdkeychain.ExtendedKey, branchPath []uint32,
+	addrTypes []WalletAddrType, api WalletAPI, gapLimit uint32,
+	chainParams *chaincfg.Params, log btclog.Logger) ([]*WalletUTXO,
+	error) {
+
+	var (
+		utxos  []*WalletUTXO
+		unused uint32
+	)
+	for index := uint32(0); unused < gapLimit; index++ {
+		key, err := branchKey.Derive(index)
+		if err != nil {
+			return nil, fmt.Errorf("error deriving key: %w", err)
+		}
+		pubKey, err := key.ECPubKey()
+		if err != nil {
+			return nil, fmt.Errorf("error deriving public key: %w",
+				err)
+		}
+
+		path := make([]uint32, len(branchPath), len(branchPath)+1)
+		copy(path, branchPath)
+		path = append(path, index)
+
+		unused++
+		for _, addrType := range addrTypes {
+			addr, err := WalletAddress(
+				pubKey, addrType, chainParams,
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			used, err := api.AddressUsed(addr.EncodeAddress())
+			if err != nil {
+				return nil, fmt.Errorf("error querying "+
+					"address %v: %w", addr, err)
+			}
+			if !used {
+				continue
+			}
+			unused = 0
+
+			addrUTXOs, err := addressUTXOs(
+				addr, addrType, path, pubKey, api, log,
+			)
+			if err != nil {
+				return nil, err
+			}
+			utxos = append(utxos, addrUTXOs...)
+		}
+	}
+
+	return utxos, nil
+}
+
+// addressUTXOs looks up the unspent outputs of a single wallet address.
+func addressUTXOs(addr btcutil.Address, addrType WalletAddrType,
+	path []uint32, pubKey *btcec.PublicKey, api WalletAPI,
+	log btclog.Logger) ([]*WalletUTXO, error) {
+
+	apiUTXOs, err := api.UTXOs(addr.EncodeAddress())
+	if err != nil {
+		return nil, fmt.Errorf("error querying unspent outputs of "+
+			"address %v: %w", addr, err)
+	}
+	if len(apiUTXOs) > 0 {
+		log.Infof("Found %d unspent outputs for address %v",
+			len(apiUTXOs), addr)
+	}
+
+	utxos := make([]*WalletUTXO, 0, len(apiUTXOs))
+	for _, utxo := range apiUTXOs {
+		txHash, err := chainhash.NewHashFromStr(utxo.TXID)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing tx hash: %w", err)
+		}
+
+		walletUTXO := &WalletUTXO{
+			Addr:   addr,
+			Type:   addrType,
+			Path:   path,
+			PubKey: pubKey,
+			Outpoint: wire.OutPoint{
+				Hash:  *txHash,
+				Index: utxo.Vout,
+			},
+			Value:     int64(utxo.Value),
+			Confirmed: utxo.Status != nil && utxo.Status.Confirmed,
+		}
+
+		// Legacy inputs don't commit to the value of the output they
+		// spend, so the full previous transaction is needed.
+		if addrType == WalletAddrP2PKH {
+			walletUTXO.PrevTx, err = api.RawTransaction(utxo.TXID)
+			if err != nil {
+				return nil, fmt.Errorf("error fetching "+
+					"transaction %s: %w", utxo.TXID, err)
+			}
+		}
+
+		utxos = append(utxos, walletUTXO)
+	}
+
+	return utxos, nil
+}
+
+// WalletAddress returns the address of the given type for the public key.
+func WalletAddress(pubKey *btcec.PublicKey, addrType WalletAddrType,
+	chainParams *chaincfg.Params) (btcutil.Address, error) {
+
+	switch addrType {
+	case WalletAddrNP2WKH:
+		return lnd.NP2WKHAddr(pubKey, chainParams)
+
+	case WalletAddrP2WKH:
+		return lnd.P2WKHAddr(pubKey, chainParams)
+
+	case WalletAddrP2TR:
+		return lnd.P2TRAddr(pubKey, chainParams)
+
+	case WalletAddrP2PKH:
+		return lnd.P2PKHAddr(pubKey, chainParams)
+
+	default:
+		return nil, fmt.Errorf("unknown address type %d", addrType)
+	}
+}
+
+// WalletPsbt creates an unsigned PSBT that sweeps all given wallet outputs to
+// the given pkScript. The inputs carry the BIP32 derivations of their keys, so
+// the PSBT can be signed by any signer that has the root key, including
+// lnd.Signer and hardware wallets.
+func WalletPsbt(utxos []*WalletUTXO, sweepScript []byte, feeRate uint16,
+	fingerprint uint32, log btclog.Logger) (*psbt.Packet, error) {
+
+	var (
+		estimator        input.TxWeightEstimator
+		totalOutputValue int64
+		outpoints        = make([]*wire.OutPoint, len(utxos))
+		sequences        = make([]uint32, len(utxos))
+	)
+	for idx, utxo := range utxos {
+		totalOutputValue += utxo.Value
+		outpoints[idx] = &utxo.Outpoint
+		sequences[idx] = wire.MaxTxInSequenceNum
+
+		switch utxo.Type {
+		case WalletAddrNP2WKH:
+			estimator.AddNestedP2WKHInput()
+
+		case WalletAddrP2WKH:
+			estimator.AddP2WKHInput()
+
+		case WalletAddrP2TR:
+			estimator.AddTaprootKeySpendInput(
+				txscript.SigHashDefault,
+			)
+
+		case WalletAddrP2PKH:
+			estimator.AddP2PKHInput()
+		}
+	}
+
+	if totalOutputValue < DustLimit {
+		return nil, fmt.Errorf("found %d wallet outputs with total "+
+			"value of %d satoshis which is below the dust limit "+
+			"of %d", len(utxos), totalOutputValue, DustLimit)
+	}
+
+	// Add our sweep destination output.
+	estimator.AddP2WKHOutput()
+
+	// Calculate the fee based on the given fee rate and our weight
+	// estimation.
+	feeRateKWeight := chainfee.SatPerKVByte(1000 * feeRate).FeePerKWeight()
+	totalFee := feeRateKWeight.FeeForWeight(int64(estimator.Weight()))
+
+	log.Infof("Fee %d sats of %d total amount (estimated weight %d)",
+		totalFee, totalOutputValue, estimator.Weight())
+
+	if totalOutputValue-int64(totalFee) < DustLimit {
+		return nil, fmt.Errorf("total value of %d satoshis minus the "+
+			"fee of %d satoshis is below the dust limit of %d",
+			totalOutputValue, totalFee, DustLimit)
+	}
+
+	packet, err := psbt.New(outpoints, []*wire.TxOut{{
+		Value:    totalOutputValue - int64(totalFee),
+		PkScript: sweepScript,
+	}}, 2, 0, sequences)
+	if err != nil {
+		return nil, fmt.Errorf("error creating PSBT: %w", err)
+	}
+
+	for idx, utxo := range utxos {
+		pkScript, err := txscript.PayToAddrScript(utxo.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("error getting pk script: %w",
+				err)
+		}
+
+		pIn := &packet.Inputs[idx]
+		pIn.WitnessUtxo = &wire.TxOut{
+			Value:    utxo.Value,
+			PkScript: pkScript,
+		}
+		if utxo.Type == WalletAddrP2PKH {
+			if utxo.PrevTx == nil {
+				return nil, fmt.Errorf("previous transaction "+
+					"of legacy output %v missing",
+					utxo.Outpoint)
+			}
+			pIn.WitnessUtxo = nil
+			pIn.NonWitnessUtxo = utxo.PrevTx
+		}
+
+		if utxo.Type == WalletAddrP2TR {
+			pIn.TaprootBip32Derivation = []*psbt.
+				TaprootBip32Derivation{{
+				XOnlyPubKey: schnorr.SerializePubKey(
+					utxo.PubKey,
+				),
+				MasterKeyFingerprint: fingerprint,
+				Bip32Path:            utxo.Path,
+			}}
+			pIn.TaprootInternalKey = schnorr.SerializePubKey(
+				utxo.PubKey,
+			)
+
+			continue
+		}
+
+		pubKey := utxo.PubKey.SerializeCompressed()
+		pIn.Bip32Derivation = []*psbt.Bip32Derivation{{
+			PubKey:               pubKey,
+			MasterKeyFingerprint: fingerprint,
+			Bip32Path:            utxo.Path,
+		}}
+
+		if utxo.Type == WalletAddrNP2WKH {
+			pIn.RedeemScript, err = input.WitnessPubKeyHash(pubKey)
+			if err != nil {
+				return nil, fmt.Errorf("error creating redeem "+
+					"script: %w", err)
+			}
+		}
+	}
+
+	return packet, nil
+}